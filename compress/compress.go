@@ -0,0 +1,177 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compress
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+/* DOC:
+ * The compress package compresses the write path and decompresses the read path of a netpoll.Connection
+ * transparently, while keeping the nocopy Reader and Writer.
+ *
+ * NewWriter: buffer the data written by a LinkBuffer, and compress it into the connection on each Flush.
+ * NewReader: decompress the data of the connection into a LinkBuffer, which is read by the netpoll.Reader methods.
+ *
+ * The algorithms are pluggable by Codec, Gzip and Deflate are provided by the standard library,
+ * and the encoders and decoders of the others often satisfy Compressor and Decompressor already,
+ * e.g. zstd.Encoder and zstd.Decoder of github.com/klauspost/compress.
+ */
+
+// Compressor compresses the data written into the underlying io.Writer, e.g. *gzip.Writer.
+type Compressor interface {
+	io.WriteCloser
+	// Flush writes all the data compressed so far, so that the peer is able to decompress all the data written.
+	Flush() error
+	// Reset discards the state and compresses into w as a new stream, so that the Compressor is reused.
+	Reset(w io.Writer)
+}
+
+// Decompressor decompresses the data read from the underlying io.Reader, e.g. *gzip.Reader.
+// Read returns io.EOF at the end of a stream, and the Reader resets it for the next stream once more data arrives,
+// so the Decompressor should not read the concatenated streams by itself, which blocks at the end of each stream.
+type Decompressor interface {
+	io.Reader
+	// Reset discards the state and decompresses from r, so that the Decompressor is reused.
+	Reset(r io.Reader) error
+}
+
+// Codec creates the Compressor and Decompressor of an algorithm.
+// The Compressors and Decompressors are pooled for each Codec with their windows and dictionaries,
+// so a Codec should be created once and shared, and it must be comparable, e.g. a pointer.
+type Codec interface {
+	NewCompressor(w io.Writer) (Compressor, error)
+	NewDecompressor(r io.Reader) (Decompressor, error)
+}
+
+// codecPool pools the Compressors and Decompressors of a Codec.
+type codecPool struct {
+	compressors   sync.Pool
+	decompressors sync.Pool
+}
+
+var pools sync.Map // Codec -> *codecPool
+
+func poolOf(codec Codec) *codecPool {
+	if p, ok := pools.Load(codec); ok {
+		return p.(*codecPool)
+	}
+	p, _ := pools.LoadOrStore(codec, &codecPool{})
+	return p.(*codecPool)
+}
+
+// getCompressor gets a Compressor of codec into w from the pool, or creates a new one.
+func getCompressor(codec Codec, w io.Writer) (Compressor, error) {
+	if c, ok := poolOf(codec).compressors.Get().(Compressor); ok {
+		c.Reset(w)
+		return c, nil
+	}
+	return codec.NewCompressor(w)
+}
+
+func putCompressor(codec Codec, c Compressor) {
+	c.Reset(nil)
+	poolOf(codec).compressors.Put(c)
+}
+
+// getDecompressor gets a Decompressor of codec from r from the pool, or creates a new one.
+func getDecompressor(codec Codec, r io.Reader) (Decompressor, error) {
+	if d, ok := poolOf(codec).decompressors.Get().(Decompressor); ok {
+		if err := d.Reset(r); err != nil {
+			// the Decompressor is still reusable
+			poolOf(codec).decompressors.Put(d)
+			return nil, err
+		}
+		return d, nil
+	}
+	return codec.NewDecompressor(r)
+}
+
+func putDecompressor(codec Codec, d Decompressor) {
+	poolOf(codec).decompressors.Put(d)
+}
+
+// Gzip returns the Codec of gzip at the compression level, see compress/gzip.
+func Gzip(level int) Codec {
+	return &gzipCodec{level: level}
+}
+
+type gzipCodec struct {
+	level int
+}
+
+// NewCompressor implements Codec.
+func (c *gzipCodec) NewCompressor(w io.Writer) (Compressor, error) {
+	return gzip.NewWriterLevel(w, c.level)
+}
+
+// NewDecompressor implements Codec.
+func (c *gzipCodec) NewDecompressor(r io.Reader) (Decompressor, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	gr.Multistream(false)
+	return &gzipReader{gr}, nil
+}
+
+// gzipReader implements Decompressor by *gzip.Reader, whose multistream mode is disabled by each Reset.
+type gzipReader struct {
+	*gzip.Reader
+}
+
+// Reset implements Decompressor.
+func (r *gzipReader) Reset(src io.Reader) error {
+	if err := r.Reader.Reset(src); err != nil {
+		return err
+	}
+	r.Reader.Multistream(false)
+	return nil
+}
+
+// Deflate returns the Codec of the raw deflate at the compression level with the preset dictionary,
+// which can be nil, see compress/flate.
+func Deflate(level int, dict []byte) Codec {
+	return &deflateCodec{level: level, dict: dict}
+}
+
+type deflateCodec struct {
+	level int
+	dict  []byte
+}
+
+// NewCompressor implements Codec.
+func (c *deflateCodec) NewCompressor(w io.Writer) (Compressor, error) {
+	return flate.NewWriterDict(w, c.level, c.dict)
+}
+
+// NewDecompressor implements Codec.
+func (c *deflateCodec) NewDecompressor(r io.Reader) (Decompressor, error) {
+	return &flateReader{ReadCloser: flate.NewReaderDict(r, c.dict), dict: c.dict}, nil
+}
+
+// flateReader implements Decompressor by flate.Resetter.
+type flateReader struct {
+	io.ReadCloser
+	dict []byte
+}
+
+// Reset implements Decompressor.
+func (r *flateReader) Reset(src io.Reader) error {
+	return r.ReadCloser.(flate.Resetter).Reset(src, r.dict)
+}
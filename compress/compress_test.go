@@ -0,0 +1,186 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func MustNil(t *testing.T, val interface{}) {
+	t.Helper()
+	if val != nil {
+		t.Fatal("assertion nil failed, val=", val)
+	}
+}
+
+func MustTrue(t *testing.T, cond bool) {
+	t.Helper()
+	if !cond {
+		t.Fatal("assertion true failed")
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	dict := []byte("netpoll compress dictionary")
+	for name, codec := range map[string]Codec{
+		"gzip":    Gzip(gzip.BestSpeed),
+		"deflate": Deflate(gzip.DefaultCompression, dict),
+	} {
+		t.Run(name, func(t *testing.T) {
+			buf := netpoll.NewLinkBuffer()
+			w := NewWriter(buf, codec)
+			data := bytes.Repeat([]byte("netpoll compress dictionary\n"), 4096)
+			_, err := w.WriteBinary(data)
+			MustNil(t, err)
+			MustNil(t, w.Flush())
+			MustTrue(t, buf.Len() > 0 && buf.Len() < len(data)/10)
+
+			r := NewReader(buf, codec)
+			p, err := r.Next(len(data))
+			MustNil(t, err)
+			MustTrue(t, bytes.Equal(p, data))
+			MustNil(t, r.Release())
+
+			// the stream is ended by Close
+			_, err = w.WriteString("end\n")
+			MustNil(t, err)
+			MustNil(t, w.Close())
+			line, err := r.Until('\n')
+			MustNil(t, err)
+			MustTrue(t, string(line) == "end\n")
+			_, err = r.Next(1)
+			MustTrue(t, errors.Is(err, io.EOF))
+			MustNil(t, r.Close())
+		})
+	}
+}
+
+func TestReaderUntilN(t *testing.T) {
+	codec := Gzip(gzip.DefaultCompression)
+	buf := netpoll.NewLinkBuffer()
+	w := NewWriter(buf, codec)
+	w.WriteString(strings.Repeat("x", 100) + "\nshort\n")
+	MustNil(t, w.Close())
+
+	r := NewReader(buf, codec)
+	_, err := r.UntilN('\n', 64)
+	MustTrue(t, errors.Is(err, netpoll.ErrLineTooLong))
+	MustNil(t, r.Skip(101))
+	line, err := r.UntilN('\n', 64)
+	MustNil(t, err)
+	MustTrue(t, string(line) == "short\n")
+}
+
+func TestStreaming(t *testing.T) {
+	codec := Gzip(gzip.DefaultCompression)
+	ln, err := netpoll.CreateListener("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	conns := make(chan netpoll.Connection, 1)
+	loop, err := netpoll.NewEventLoop(func(ctx context.Context, connection netpoll.Connection) error {
+		return nil
+	}, netpoll.WithOnPrepare(func(connection netpoll.Connection) context.Context {
+		conns <- connection
+		return context.Background()
+	}))
+	MustNil(t, err)
+	go loop.Serve(ln)
+	defer loop.Shutdown(context.Background())
+
+	conn, err := netpoll.DialConnection("tcp", ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	peer := <-conns
+
+	// each line is readable by the peer once it's flushed, before the stream ends
+	w := NewWriter(peer.Writer(), codec)
+	r := NewReader(conn.Reader(), codec)
+	for i := 0; i < 100; i++ {
+		msg := fmt.Sprintf("log line %d %s\n", i, strings.Repeat("z", i*100))
+		_, err = w.WriteString(msg)
+		MustNil(t, err)
+		MustNil(t, w.Flush())
+		line, err := r.Until('\n')
+		MustNil(t, err)
+		MustTrue(t, string(line) == msg)
+		MustNil(t, r.Release())
+	}
+	// the data written after Close is a new stream
+	MustNil(t, w.Close())
+	_, err = w.WriteString("again")
+	MustNil(t, err)
+	MustNil(t, w.Close())
+	s, err := r.ReadString(5)
+	MustNil(t, err)
+	MustTrue(t, s == "again")
+
+	// the corrupted data fails the Reader
+	_, err = peer.Writer().WriteString("garbage data")
+	MustNil(t, err)
+	MustNil(t, peer.Writer().Flush())
+	_, err = r.Next(1)
+	MustTrue(t, err != nil)
+	MustNil(t, r.Close())
+}
+
+// upperCodec is a custom Codec which "compresses" the data by converting it to upper case.
+type upperCodec struct{}
+
+type upperWriter struct{ w io.Writer }
+
+func (u *upperWriter) Write(p []byte) (int, error) { return u.w.Write(bytes.ToUpper(p)) }
+func (u *upperWriter) Close() error                { return nil }
+func (u *upperWriter) Flush() error                { return nil }
+func (u *upperWriter) Reset(w io.Writer)           { u.w = w }
+
+type upperReader struct{ r io.Reader }
+
+func (u *upperReader) Read(p []byte) (int, error) { return u.r.Read(p) }
+func (u *upperReader) Reset(r io.Reader) error    { u.r = r; return nil }
+
+func (c *upperCodec) NewCompressor(w io.Writer) (Compressor, error) {
+	return &upperWriter{w: w}, nil
+}
+
+func (c *upperCodec) NewDecompressor(r io.Reader) (Decompressor, error) {
+	return &upperReader{r: r}, nil
+}
+
+func TestCustomCodec(t *testing.T) {
+	codec := &upperCodec{}
+	buf := netpoll.NewLinkBuffer()
+	w := NewWriter(buf, codec)
+	w.WriteString("hello")
+	MustNil(t, w.Close())
+	// the Compressor is reused from the pool
+	w = NewWriter(buf, codec)
+	w.WriteString(" world")
+	MustNil(t, w.Close())
+	r := NewReader(buf, codec)
+	s, err := r.ReadString(11)
+	MustNil(t, err)
+	MustTrue(t, s == "HELLO WORLD")
+}
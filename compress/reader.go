@@ -0,0 +1,264 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// readBlock is the size of the buffer allocated for each read of the Decompressor at least.
+const readBlock = 4 << 10
+
+// Reader implements netpoll.Reader, the data of the underlying netpoll.Reader is decompressed into a LinkBuffer
+// when more data is needed, so the slices returned by Next and Peek are valid until Release like the connection.
+// Reader is blocking like the underlying netpoll.Reader, so it's usually read by a dedicated goroutine,
+// or by OnRequest which is allowed to block.
+type Reader struct {
+	in    netpoll.Reader
+	src   io.Reader
+	codec Codec
+	d     Decompressor // taken from the pool by the first read, and put back by Close
+	ended bool         // the stream of d has ended, so it's reset for the next stream
+	buf   *netpoll.LinkBuffer
+	err   error // the error of the Decompressor, returned once the data decompressed is read up
+}
+
+var (
+	_ netpoll.Reader = &Reader{}
+
+	_ netpoll.BinaryToReader = &Reader{}
+	_ netpoll.UntilNReader   = &Reader{}
+	_ netpoll.PeekVecReader  = &Reader{}
+)
+
+// NewReader returns a Reader which decompresses the data read from r by codec, e.g. Connection.Reader().
+// r must not be read by the others meanwhile.
+func NewReader(r netpoll.Reader, codec Codec) *Reader {
+	return &Reader{
+		in:    r,
+		src:   netpoll.NewRawReader(r),
+		codec: codec,
+		buf:   netpoll.NewLinkBuffer(),
+	}
+}
+
+// Close puts the Decompressor back to the pool and releases the data decompressed,
+// the Reader must not be used after that.
+func (r *Reader) Close() error {
+	if r.d != nil {
+		putDecompressor(r.codec, r.d)
+		r.d = nil
+	}
+	if r.err == nil {
+		r.err = netpoll.Exception(netpoll.ErrConnClosed, "compress reader closed")
+	}
+	return r.buf.Close()
+}
+
+// fill decompresses more data until n bytes are buffered, or the Decompressor fails.
+// The streams are concatenated, so the Decompressor is reset once its stream ends and more data arrives.
+func (r *Reader) fill(n int) (err error) {
+	for r.buf.Len() < n {
+		if r.err != nil {
+			return r.err
+		}
+		if r.d == nil || r.ended {
+			if err = r.next(); err != nil {
+				r.err = err
+				continue
+			}
+		}
+		size := n - r.buf.Len()
+		if size < readBlock {
+			size = readBlock
+		}
+		p, err := r.buf.Malloc(size)
+		if err != nil {
+			return err
+		}
+		m, err := r.d.Read(p)
+		if m < 0 {
+			m = 0
+		}
+		r.buf.MallocAck(r.buf.MallocLen() - size + m)
+		r.buf.Flush()
+		if err == io.EOF {
+			r.ended = true
+		} else if err != nil {
+			r.err = err
+		}
+	}
+	return nil
+}
+
+// next waits for the data of the next stream, and gets or resets the Decompressor for it.
+// It returns io.EOF if there's no more data.
+func (r *Reader) next() (err error) {
+	if r.in.Len() == 0 {
+		if _, ok := r.in.(*netpoll.LinkBuffer); ok {
+			// the LinkBuffer is never filled by others
+			return io.EOF
+		}
+		if _, err = r.in.Peek(1); err != nil {
+			if errors.Is(err, io.EOF) {
+				return io.EOF
+			}
+			return err
+		}
+	}
+	if r.d == nil {
+		r.d, err = getDecompressor(r.codec, r.src)
+		return err
+	}
+	if err = r.d.Reset(r.src); err == nil {
+		r.ended = false
+	}
+	return err
+}
+
+// Next implements netpoll.Reader.
+func (r *Reader) Next(n int) (p []byte, err error) {
+	if err = r.fill(n); err != nil {
+		return nil, err
+	}
+	return r.buf.Next(n)
+}
+
+// Peek implements netpoll.Reader.
+func (r *Reader) Peek(n int) (buf []byte, err error) {
+	if err = r.fill(n); err != nil {
+		return nil, err
+	}
+	return r.buf.Peek(n)
+}
+
+// PeekVec implements netpoll.PeekVecReader.
+func (r *Reader) PeekVec(n int) (vs [][]byte, err error) {
+	if err = r.fill(n); err != nil {
+		return nil, err
+	}
+	return r.buf.PeekVec(n)
+}
+
+// Skip implements netpoll.Reader.
+func (r *Reader) Skip(n int) (err error) {
+	if err = r.fill(n); err != nil {
+		return err
+	}
+	return r.buf.Skip(n)
+}
+
+// Until implements netpoll.Reader.
+func (r *Reader) Until(delim byte) (line []byte, err error) {
+	return r.until(delim, 0)
+}
+
+// UntilN implements netpoll.UntilNReader.
+func (r *Reader) UntilN(delim byte, max int) (line []byte, err error) {
+	return r.until(delim, max)
+}
+
+// until decompresses until delim is found, max limits the length of the line if it's positive.
+func (r *Reader) until(delim byte, max int) (line []byte, err error) {
+	for scanned := 0; ; {
+		if err = r.fill(scanned + 1); err != nil {
+			// all the data in the buffer is returned with the error
+			if n := r.buf.Len(); n > 0 {
+				line, _ = r.buf.Next(n)
+			}
+			return line, err
+		}
+		n := r.buf.Len()
+		vs, _ := r.buf.PeekVec(n)
+		idx, off := -1, 0
+		for _, v := range vs {
+			if i := bytes.IndexByte(v, delim); i >= 0 {
+				idx = off + i
+				break
+			}
+			off += len(v)
+		}
+		if max > 0 && (idx >= max || idx < 0 && n >= max) {
+			return nil, netpoll.Exception(netpoll.ErrLineTooLong, fmt.Sprintf("max[%d]", max))
+		}
+		if idx >= 0 {
+			return r.buf.Next(idx + 1)
+		}
+		scanned = n
+	}
+}
+
+// ReadString implements netpoll.Reader.
+func (r *Reader) ReadString(n int) (s string, err error) {
+	if err = r.fill(n); err != nil {
+		return "", err
+	}
+	return r.buf.ReadString(n)
+}
+
+// ReadBinary implements netpoll.Reader.
+func (r *Reader) ReadBinary(n int) (p []byte, err error) {
+	if err = r.fill(n); err != nil {
+		return nil, err
+	}
+	return r.buf.ReadBinary(n)
+}
+
+// ReadBinaryTo implements netpoll.BinaryToReader.
+func (r *Reader) ReadBinaryTo(p []byte) (n int, err error) {
+	if err = r.fill(len(p)); err != nil {
+		return 0, err
+	}
+	return r.buf.ReadBinaryTo(p)
+}
+
+// PeekTo implements netpoll.BinaryToReader.
+func (r *Reader) PeekTo(p []byte) (n int, err error) {
+	if err = r.fill(len(p)); err != nil {
+		return 0, err
+	}
+	return r.buf.PeekTo(p)
+}
+
+// ReadByte implements netpoll.Reader.
+func (r *Reader) ReadByte() (b byte, err error) {
+	if err = r.fill(1); err != nil {
+		return 0, err
+	}
+	return r.buf.ReadByte()
+}
+
+// Slice implements netpoll.Reader.
+func (r *Reader) Slice(n int) (p netpoll.Reader, err error) {
+	if err = r.fill(n); err != nil {
+		return nil, err
+	}
+	return r.buf.Slice(n)
+}
+
+// Release implements netpoll.Reader.
+func (r *Reader) Release() (err error) {
+	return r.buf.Release()
+}
+
+// Len implements netpoll.Reader, it's the length of the data decompressed but not read yet.
+func (r *Reader) Len() (length int) {
+	return r.buf.Len()
+}
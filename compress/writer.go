@@ -0,0 +1,84 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compress
+
+import (
+	"io"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// Writer implements netpoll.Writer, the data written is buffered by the LinkBuffer without copying,
+// and compressed into the underlying netpoll.Writer on each Flush.
+type Writer struct {
+	*netpoll.LinkBuffer
+	raw   netpoll.RawWriter
+	codec Codec
+	c     Compressor // taken from the pool once data is flushed, and put back by Close
+}
+
+var _ netpoll.Writer = &Writer{}
+
+// NewWriter returns a Writer which compresses the data into w by codec, e.g. Connection.Writer().
+// w must not be written by the others meanwhile.
+func NewWriter(w netpoll.Writer, codec Codec) *Writer {
+	return &Writer{
+		LinkBuffer: netpoll.NewLinkBuffer(),
+		raw:        netpoll.NewRawWriter(w, 0),
+		codec:      codec,
+	}
+}
+
+// Flush implements netpoll.Writer, it compresses the data written and flushes the compressed data,
+// so that the peer is able to decompress all the data flushed.
+func (w *Writer) Flush() (err error) {
+	if err = w.compress(); err != nil {
+		return err
+	}
+	if err = w.c.Flush(); err != nil {
+		return err
+	}
+	return w.raw.Flush()
+}
+
+// Close flushes the data written and ends the compressed stream, then the Compressor is put back to the pool.
+// The data written after Close is compressed as a new stream.
+func (w *Writer) Close() (err error) {
+	if err = w.compress(); err != nil {
+		return err
+	}
+	err = w.c.Close()
+	putCompressor(w.codec, w.c)
+	w.c = nil
+	if err != nil {
+		return err
+	}
+	return w.raw.Flush()
+}
+
+// compress feeds the data buffered to the Compressor.
+func (w *Writer) compress() (err error) {
+	if err = w.LinkBuffer.Flush(); err != nil {
+		return err
+	}
+	if w.c == nil {
+		if w.c, err = getCompressor(w.codec, w.raw); err != nil {
+			return err
+		}
+	}
+	// the buffered nodes are written to the Compressor directly
+	_, err = io.Copy(w.c, netpoll.NewRawReader(w.LinkBuffer))
+	return err
+}
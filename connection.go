@@ -15,7 +15,10 @@
 package netpoll
 
 import (
+	"context"
 	"net"
+	"os"
+	"syscall"
 	"time"
 )
 
@@ -68,9 +71,350 @@ type Connection interface {
 	// This is very useful for cleaning up idle connections. For instance, you can use callbacks to clean up
 	// the local resources, which bound to the idle connection, when hangup by the peer. No need another goroutine
 	// to polling check connection status.
+	// It's the same as PriorityCloseCallbackAdder.AddCloseCallbackWithPriority with priority 0.
 	AddCloseCallback(callback CloseCallback) error
 }
 
+// SocketConn is an optional interface of Connection, which provides the operations of the underlying socket.
+// The connections served by the pollers and the TLS connections over them implement it, see SendFile.
+type SocketConn interface {
+	// SendFile sends n bytes of f starting at off to the connection after the buffered data is flushed,
+	// so the order of the output is kept. If n <= 0, the rest of the file from off will be sent.
+	// sendfile(2) is used to avoid copying the data to user space, and it falls back to copying
+	// by the output buffer if sendfile is unavailable, e.g. the connection is a TLS connection.
+	// It returns the number of bytes sent, and io.EOF if the file ends before n bytes are sent.
+	SendFile(f *os.File, off, n int64) (written int64, err error)
+
+	// SendFDs passes the file descriptors to the peer by SCM_RIGHTS after the buffered data is flushed.
+	// Since the ancillary data can't be sent alone, the fds are sent along with a single zero byte,
+	// which is read by the peer as normal data. The fds can be closed after SendFDs returns.
+	// It returns ErrUnsupported on non-unix connections.
+	SendFDs(fds []int) error
+
+	// ReceiveFDs takes the file descriptors passed by the peer with SCM_RIGHTS, and the caller must close them.
+	// The fds are received along with the data sent with them, so they're available once the data is readable.
+	// The fds not taken are closed when the connection is closed. It returns nil on non-unix connections.
+	ReceiveFDs() []int
+
+	// PeerCredentials returns the credentials of the peer process of unix sockets by SO_PEERCRED,
+	// which are the ones when the connection is established. It's only supported on Linux.
+	PeerCredentials() (*Ucred, error)
+
+	// OriginalDst returns the destination address of TCP connections before they're redirected by the NAT of netfilter,
+	// e.g. the REDIRECT target of iptables, by SO_ORIGINAL_DST and IP6T_SO_ORIGINAL_DST on Linux. It returns the local
+	// address if the connection isn't NATed, e.g. the ones accepted by WithListenTransparent. It returns
+	// ErrUnsupported on the other platforms or non-TCP connections.
+	OriginalDst() (net.Addr, error)
+
+	// SyscallConn implements syscall.Conn to set the socket options not provided by Connection,
+	// e.g. IP_TOS, SO_MARK or TCP_CONGESTION, while the fd stays registered with the poller.
+	// The fd is not closed until the function passed to RawConn.Control returns, but the data must not be
+	// read or written by the fd, so RawConn.Read and RawConn.Write return ErrUnsupported.
+	SyscallConn() (syscall.RawConn, error)
+}
+
+// BufferTuner is an optional interface of Connection, which tunes the buffers of the connection.
+// All the connections of netpoll implement it.
+type BufferTuner interface {
+	// SetMallocSize sets the minimum size of the memory blocks allocated by the input and output buffers.
+	// A large size avoids chaining too many blocks for large frames, while a small one saves memory for tiny frames.
+	// The default size is LinkBufferCap, and a non-positive size restores the default.
+	SetMallocSize(size int) error
+
+	// SetMaxInputBuffer sets the maximum size of the data buffered in the input buffer.
+	// The poller stops reading from the connection once exceeded, and resumes after the data is consumed,
+	// so that slow readers apply backpressure to the peer instead of buffering without limit.
+	// Reading more than size bytes at once is still allowed. A non-positive size means no limit, which is the default.
+	SetMaxInputBuffer(size int) error
+
+	// SetMaxOutputBuffer sets the maximum size of the data buffered in the output buffer,
+	// including the data not flushed and the data flushed but not sent to the peer yet.
+	// The Writer methods and Flush return ErrWriteBufferFull if the limit would be exceeded.
+	// A non-positive size means no limit, which is the default.
+	SetMaxOutputBuffer(size int) error
+
+	// SetReadWatermark defers calling OnRequest until at least n bytes are buffered, e.g. the size of
+	// the fixed header, so that the peers sending a byte at a time won't wake up OnRequest for each byte.
+	// The buffered data less than n is not delivered to OnRequest if the peer closes the connection.
+	// A non-positive n restores the default, which calls OnRequest once any data is buffered.
+	SetReadWatermark(n int) error
+}
+
+// HalfCloser is an optional interface of Connection, which shuts down either side of the connection.
+// All the connections of netpoll implement it.
+type HalfCloser interface {
+	// CloseWrite shuts down the writing side of the connection by shutdown(2), so the peer reads EOF
+	// after the sent data, while the data from the peer can still be read until EOF.
+	// The buffered data must be flushed before CloseWrite, and writing after CloseWrite fails.
+	CloseWrite() error
+
+	// CloseRead shuts down the reading side of the connection by shutdown(2) and stops the poller reading from it.
+	// The data already in the input buffer can still be read, after which the Reader returns ErrEOF,
+	// while the connection can still be written to. The buffers are released by Close as usual.
+	CloseRead() error
+}
+
+// SocketTuner is an optional interface of Connection, which sets the options of the underlying socket.
+// The connections served by the pollers and the ones wrapping net.Conn by WrapConn implement it.
+type SocketTuner interface {
+	// SetTCPKeepAlive enables the TCP keepalive and tunes it, so that the dead peers of idle connections are detected
+	// and the NAT mappings are kept alive without application-level pings. idle is the time the connection stays idle
+	// before the first probe, interval is the time between probes, and count is the number of unacknowledged probes
+	// before the connection is dropped. They are rounded up to seconds, and non-positive values keep the system defaults.
+	// It takes no effect on non-TCP connections.
+	SetTCPKeepAlive(idle, interval time.Duration, count int) error
+
+	// SetNoDelay controls whether the Nagle's algorithm is disabled by TCP_NODELAY,
+	// which is disabled by default so that the small writes are sent without delay.
+	// It takes no effect on non-TCP connections.
+	SetNoDelay(noDelay bool) error
+
+	// SetQuickAck controls whether the ACKs are sent immediately instead of being delayed by TCP_QUICKACK.
+	// It's only supported on Linux, and the kernel may leave the quick ACK mode later,
+	// so it's usually called again after reading. It takes no effect on non-TCP connections.
+	SetQuickAck(quickAck bool) error
+
+	// SetZeroCopy sends the flushed data by MSG_ZEROCOPY once it reaches threshold bytes, which saves the copy
+	// into the kernel for the large payloads, e.g. of proxies. The sent data is kept in the output buffer until
+	// the kernel notifies the completion by the error queue, which is handled by the poller.
+	// It only takes effect on the TCP connections on Linux 4.14+, and returns ErrUnsupported otherwise.
+	// Non-positive threshold disables it, which is the default.
+	SetZeroCopy(threshold int) error
+}
+
+// AsyncFlusher is an optional interface of Connection, which flushes the data without waiting for the peer.
+// All the connections of netpoll implement it.
+type AsyncFlusher interface {
+	// FlushAsync is the same as Writer.Flush, except that it returns once the data is queued instead of waiting
+	// for the peer to receive it, and callback is called by the poller once all the data has been accepted by
+	// the kernel, or with the error if the connection is closed meanwhile. callback may be called before FlushAsync
+	// returns if the data is sent at once, and it's not called if FlushAsync returns an error.
+	// The write timeout doesn't apply, and flushing again before callback is called returns ErrConcurrentAccess.
+	// It's synchronous for TLS connections and on Windows, which call callback before returning.
+	FlushAsync(callback func(err error)) error
+}
+
+// AutoFlusher is an optional interface of Connection, which flushes the written data automatically.
+// The connections served by the pollers and the TLS connections over them implement it.
+type AutoFlusher interface {
+	// SetAutoFlush coalesces the small writes, and flushes them once the data not flushed reaches threshold bytes,
+	// or once interval elapses since the first write not flushed, so that Flush is not required after each write.
+	// The data allocated by Malloc is not flushed automatically until Flush or MallocAck is called, since it may
+	// not be filled yet. Non-positive threshold or interval disables the corresponding policy, which is the default.
+	SetAutoFlush(threshold int, interval time.Duration) error
+}
+
+// StatsProvider is an optional interface of Connection, which reports the counters of the connection.
+// All the connections of netpoll implement it.
+type StatsProvider interface {
+	// Stats returns the counters of the connection, which are maintained since the connection is created.
+	// For TLS connections, the bytes of the TLS records are counted.
+	Stats() ConnStats
+}
+
+// Heartbeater is an optional interface of Connection, which runs the periodic task of the connection.
+// All the connections of netpoll implement it.
+type Heartbeater interface {
+	// SetHeartbeat calls fn at the fixed interval while the connection is active, e.g. to send the pings of
+	// the protocol, without a goroutine waiting for each connection. The timer is scheduled by the timer wheel of
+	// the poller, but fn is called by the global runner, which may run concurrently with OnRequest, and the next
+	// call is scheduled after fn returns.
+	// It replaces the previous heartbeat, and a non-positive interval or a nil fn cancels it.
+	// The heartbeat is canceled automatically once the connection is closed.
+	SetHeartbeat(interval time.Duration, fn func(connection Connection)) error
+}
+
+// LoopRunner is an optional interface of Connection, which runs the tasks on the poller serving the connection.
+// The connections served by the pollers and the TLS connections over them implement it.
+type LoopRunner interface {
+	// RunOnLoop runs task asynchronously on the poller goroutine serving the connection, so that the tasks
+	// posted to the same poller run in order without locks, unless the connection is migrated by WithRebalance.
+	// The task must not block since it blocks all the connections on the poller, e.g. Flush may wait for the poller.
+	// It returns ErrUnsupported if the connection is not served by a poller.
+	RunOnLoop(task func()) error
+}
+
+// ConcurrentWriter is an optional interface of Connection, which can be written by multiple goroutines.
+// All the connections of netpoll implement it.
+type ConcurrentWriter interface {
+	// SafeWrite copies p to the output buffer, and it's safe to be called by multiple goroutines
+	// together with SafeFlush, e.g. to push messages from the goroutines other than OnRequest.
+	// The data of each SafeWrite is kept contiguous in the order of the calls, but it must not be mixed
+	// with the Writer used by another goroutine concurrently.
+	SafeWrite(p []byte) error
+
+	// SafeFlush flushes the data written by SafeWrite, and it's safe to be called by multiple goroutines.
+	// The concurrent calls are batched into one Flush, so the poller is woken up at most once for each batch:
+	// if another goroutine is flushing, SafeFlush returns nil at once and the data is flushed by that goroutine,
+	// which gets the error if any.
+	SafeFlush() error
+}
+
+// ReadContextSetter is an optional interface of Connection, which binds the reading to a context.
+// The connections served by the pollers, the TLS connections over them and Pipe implement it.
+type ReadContextSetter interface {
+	// SetReadContext makes the future Reader calls waiting for data return ctx.Err() once ctx is done,
+	// e.g. to unblock the reading when the request is hedged or the service is shutting down.
+	// It works together with the read timeout, and a nil ctx removes it.
+	SetReadContext(ctx context.Context) error
+}
+
+// CloseNotifier is an optional interface of Connection, which tells when and why the connection is closed.
+// All the connections of netpoll implement it.
+type CloseNotifier interface {
+	// Done returns a channel that's closed once the connection is fully closed,
+	// i.e. after all the CloseCallbacks are called, no matter it's closed by the user or the peer.
+	Done() <-chan struct{}
+
+	// CloseWithError closes the connection like Close, and records err as the reason,
+	// which is wrapped by the ErrConnClosed returned by the subsequent reads and returned by Err,
+	// so that the CloseCallbacks and the goroutines reading the connection can tell why it's closed.
+	// Only the first reason is kept if it's called more than once.
+	CloseWithError(err error) error
+
+	// Err returns nil if the connection is active. Otherwise, it returns the reason passed to CloseWithError,
+	// or ErrEOF if it's closed by the peer, or ErrConnClosed if it's closed by Close.
+	Err() error
+}
+
+// PriorityCloseCallbackAdder is an optional interface of Connection, which orders and removes the CloseCallbacks.
+// All the connections of netpoll implement it.
+type PriorityCloseCallbackAdder interface {
+	// AddCloseCallbackWithPriority adds a CloseCallback, which can be removed by the returned handle.
+	// The callbacks with the higher priority are called first, and the ones with the same priority are called
+	// in reverse order of adding. Each callback is called exactly once after the last OnRequest returns,
+	// and it returns ErrConnClosed if the callbacks have been called.
+	AddCloseCallbackWithPriority(callback CloseCallback, priority int) (*CloseCallbackHandle, error)
+}
+
+// UserDataHolder is an optional interface of Connection, which attaches the data of the user to the connection.
+// All the connections of netpoll implement it.
+type UserDataHolder interface {
+	// SetUserData attaches data to the connection, e.g. the session object of the protocol, which replaces
+	// the previous one, so that it can be retrieved by UserData in OnRequest and the CloseCallbacks.
+	// It's safe to be called concurrently with UserData.
+	SetUserData(data interface{}) error
+
+	// UserData returns the data set by SetUserData, or nil if not set.
+	UserData() interface{}
+}
+
+// RateLimiter is an optional interface of Connection, which limits the rate of the data of the connection.
+// The connections served by the pollers and the TLS connections over them implement it.
+type RateLimiter interface {
+	// SetReadRateLimit limits the rate of reading from the connection to bytesPerSec, allowing bursts of up to
+	// burst bytes, e.g. to protect the parsers from abusive senders. Once exceeded, the poller stops reading from
+	// the connection until the tokens are refilled, so the peer is throttled by TCP flow control without any goroutine.
+	// A non-positive burst means bytesPerSec, and a non-positive bytesPerSec means no limit, which is the default.
+	SetReadRateLimit(bytesPerSec, burst int) error
+
+	// SetWriteRateLimit limits the rate of sending the data of the connection to bytesPerSec, allowing bursts of up to
+	// burst bytes. Once exceeded, the flushed data is left in the output buffer, and sent by the poller once the quota
+	// is refilled, so Flush may take longer and the write timeout should be large enough. It works together with
+	// WithWriteRateLimit, and SendFile and SendFDs are not limited. A non-positive burst means bytesPerSec,
+	// and a non-positive bytesPerSec means no limit, which is the default.
+	SetWriteRateLimit(bytesPerSec, burst int) error
+}
+
+// TCPInfoProvider is an optional interface of Connection, which reports the state of the TCP connection.
+// The connections served by the pollers and the TLS connections over them implement it.
+type TCPInfoProvider interface {
+	// TCPInfo returns the live transport telemetry of TCP connections by TCP_INFO on Linux,
+	// or TCP_CONNECTION_INFO on macOS, e.g. for the load balancers and the adaptive timeouts.
+	// It returns ErrUnsupported on the other platforms or non-TCP connections.
+	TCPInfo() (*TCPInfo, error)
+
+	// MPTCPInfo returns the state of Multipath TCP connections by MPTCP_INFO on Linux 5.16+, e.g. the subflows,
+	// see WithDialMultipath and WithListenMultipath. It returns ErrUnsupported if the connection is not MPTCP,
+	// including the ones which have fallen back to TCP since the peer doesn't support MPTCP.
+	MPTCPInfo() (*MPTCPInfo, error)
+}
+
+// NetConnCompatSetter is an optional interface of Connection, which makes the connection a drop-in replacement of net.TCPConn.
+// The connections served by the pollers and the TLS connections over them implement it.
+type NetConnCompatSetter interface {
+	// SetNetConnCompat makes the net.Conn methods behave exactly like net.TCPConn, so that the connection can be
+	// handed to the third-party code relying on their semantics, e.g. the TLS and SSH libraries.
+	// Once enabled, setting the deadlines wakes up the pending Read and Write, which fail immediately if the deadline
+	// has passed even if the data is ready, and the errors of Read and Write are *net.OpError wrapping
+	// os.ErrDeadlineExceeded or net.ErrClosed, or io.EOF returned by Read once the peer closes the connection.
+	// It's disabled by default.
+	SetNetConnCompat(enabled bool) error
+}
+
+// KernelTLSEnabler is an optional interface of Connection, which offloads the TLS records to the kernel.
+// The connections served by the pollers implement it.
+type KernelTLSEnabler interface {
+	// EnableKernelTLS hands the TLS session established in user space to the kernel by TLS_TX and TLS_RX on Linux,
+	// so that the following writes, reads and Sendfile carry the plaintext, which is encrypted and decrypted by the kernel.
+	// It must be called right after the handshake, before any data of the session is buffered by the connection,
+	// otherwise EBUSY is returned. Once RX is enabled, the records other than the application data, e.g. the alerts
+	// and the KeyUpdate of TLS 1.3, fail the reads with EIO. It returns ErrUnsupported on non-TCP connections,
+	// or if the kernel doesn't support the cipher suite, see KernelTLSSupported.
+	EnableKernelTLS(params KernelTLSParams) error
+}
+
+// Ucred is the credentials of the peer process, see SocketConn.PeerCredentials.
+type Ucred struct {
+	Pid int32
+	Uid uint32
+	Gid uint32
+}
+
+// TCPInfo is the transport telemetry of a TCP connection, see TCPInfoProvider.TCPInfo.
+// The fields unavailable on the platform are zero.
+type TCPInfo struct {
+	RTT                time.Duration // smoothed round-trip time
+	RTTVar             time.Duration // variance of the round-trip time
+	MinRTT             time.Duration // minimum round-trip time observed, Linux only
+	RTO                time.Duration // retransmission timeout
+	Retransmits        uint32        // segments retransmitted and not acknowledged yet, Linux only
+	TotalRetransmits   uint32        // segments retransmitted since the connection is established
+	CongestionWindow   uint32        // congestion window in bytes
+	MSS                uint32        // maximum segment size to send
+	DeliveryRate       uint64        // recent delivery rate in bytes per second, Linux only
+	BytesSent          uint64        // bytes sent, including the retransmitted ones
+	BytesRetransmitted uint64        // bytes retransmitted
+	BytesReceived      uint64        // bytes received
+}
+
+// MPTCPInfo is the state of a Multipath TCP connection, see TCPInfoProvider.MPTCPInfo.
+// The fields unavailable on the kernel are zero.
+type MPTCPInfo struct {
+	Subflows           uint8  // subflows established besides the initial one
+	SubflowsMax        uint8  // limit of the additional subflows
+	AddAddrSignal      uint8  // addresses announced to the peer
+	AddAddrAccepted    uint8  // addresses announced by the peer and accepted
+	LocalAddrUsed      uint8  // local addresses used by the subflows
+	Token              uint32 // local token identifying the connection
+	Retransmits        uint32 // retransmissions at the MPTCP level, Linux 6.5+
+	BytesSent          uint64 // bytes sent by all the subflows, Linux 6.5+
+	BytesReceived      uint64 // bytes received by all the subflows, Linux 6.5+
+	BytesRetransmitted uint64 // bytes retransmitted at the MPTCP level, Linux 6.5+
+	BytesAcked         uint64 // bytes acknowledged at the MPTCP level, Linux 6.5+
+}
+
+// KernelTLSParams is the state of a TLS session established in user space, see KernelTLSEnabler.EnableKernelTLS.
+type KernelTLSParams struct {
+	// Version is tls.VersionTLS12 or tls.VersionTLS13.
+	Version uint16
+	// CipherSuite is one of the AES-GCM and ChaCha20-Poly1305 cipher suites of crypto/tls.
+	CipherSuite uint16
+	// TX and RX are the traffic keys of the writes and the reads, either can be nil to leave it in user space.
+	TX, RX *KernelTLSKey
+}
+
+// KernelTLSKey is the traffic key of a direction of a TLS session, see KernelTLSParams.
+type KernelTLSKey struct {
+	// Key is the 16 or 32 bytes key of the cipher suite.
+	Key []byte
+	// IV is the 12 bytes nonce of TLS 1.3 and ChaCha20-Poly1305, or the 4 bytes implicit nonce of AES-GCM
+	// of TLS 1.2, whose explicit nonce is the sequence number of the record like crypto/tls.
+	IV []byte
+	// Seq is the sequence number of the next record.
+	Seq uint64
+}
+
 // Conn extends net.Conn, but supports getting the conn's fd.
 type Conn interface {
 	net.Conn
@@ -87,6 +431,13 @@ type Listener interface {
 	Fd() (fd int)
 }
 
+// SystemdListener is a listener passed by systemd socket activation.
+type SystemdListener struct {
+	Listener
+	// Name is set by FileDescriptorName= of the socket unit, which is the unit name by default.
+	Name string
+}
+
 // Dialer extends net.Dialer's API, just for interface compatibility.
 // DialConnection is recommended, but of course all functions are practically the same.
 // The returned net.Conn can be directly asserted as Connection if error is nil.
@@ -94,4 +445,20 @@ type Dialer interface {
 	DialConnection(network, address string, timeout time.Duration) (connection Connection, err error)
 
 	DialTimeout(network, address string, timeout time.Duration) (conn net.Conn, err error)
+
+	// DialContext dials with ctx instead of a timeout, the in-flight connect is canceled once ctx is done.
+	// The values of ctx are kept by the context of the connection, which is passed to OnRequest
+	// as the one returned by OnPrepare of the accepted connections, but its cancellation is not.
+	DialContext(ctx context.Context, network, address string) (connection Connection, err error)
 }
+
+// valueContext only keeps the values of the parent context, it's never canceled.
+type valueContext struct {
+	context.Context
+}
+
+func (valueContext) Deadline() (deadline time.Time, ok bool) { return }
+
+func (valueContext) Done() <-chan struct{} { return nil }
+
+func (valueContext) Err() error { return nil }
@@ -0,0 +1,120 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// autoFlush flushes the small writes coalesced in the output buffer, see AutoFlusher.SetAutoFlush.
+// The Writer is not safe for concurrent use, so mu serializes the writes and the flushes with the timer.
+type autoFlush struct {
+	mu        sync.Mutex
+	threshold int
+	interval  time.Duration
+	timer     *Timer
+	armed     bool // the timer is running
+	malloced  bool // the data allocated by Malloc may not be filled until Flush or MallocAck
+	stopped   bool
+}
+
+// SetAutoFlush implements AutoFlusher.
+func (c *connection) SetAutoFlush(threshold int, interval time.Duration) error {
+	var af *autoFlush
+	if threshold > 0 || interval > 0 {
+		af = &autoFlush{threshold: threshold, interval: interval}
+	}
+	if old := c.autoFlush.Swap(af); old != nil {
+		old.stop()
+	}
+	return nil
+}
+
+// beginWrite locks the output buffer against the auto flush timer if SetAutoFlush is enabled,
+// and the returned autoFlush must be passed to endWrite.
+func (c *connection) beginWrite() *autoFlush {
+	af := c.autoFlush.Load()
+	if af != nil {
+		af.mu.Lock()
+	}
+	return af
+}
+
+// endWrite flushes the output buffer if the data not flushed reaches the threshold,
+// otherwise arms the timer to flush it later. It returns err if the write failed.
+func (c *connection) endWrite(af *autoFlush, err error) error {
+	if af == nil {
+		return err
+	}
+	defer af.mu.Unlock()
+	if err != nil || af.malloced || af.stopped {
+		return err
+	}
+	pending := c.outputBuffer.MallocLen()
+	if pending == 0 {
+		return nil
+	}
+	if af.threshold > 0 && pending >= af.threshold {
+		// the buffer may be flushing by FlushAsync, leave it to the next write or the timer
+		if err = c.flushBuffer(); err == nil || !errors.Is(err, ErrConcurrentAccess) {
+			return err
+		}
+	}
+	af.arm(c)
+	return nil
+}
+
+// onAutoFlush is called after the interval elapses since the first write not flushed.
+// It's run by the global runner instead of the poller, since the flushing may wait for the poller.
+func (c *connection) onAutoFlush(af *autoFlush) {
+	af.mu.Lock()
+	defer af.mu.Unlock()
+	af.armed = false
+	if af.stopped || af.malloced || !c.IsActive() {
+		return
+	}
+	if err := c.flushBuffer(); errors.Is(err, ErrConcurrentAccess) {
+		af.arm(c)
+	}
+}
+
+// arm starts the timer if it's not running, af.mu must be held.
+func (af *autoFlush) arm(c *connection) {
+	if af.interval <= 0 || af.armed {
+		return
+	}
+	af.armed = true
+	if af.timer == nil {
+		af.timer = c.wheel().afterFunc(af.interval, 0, func() {
+			runTask(context.Background(), nil, func() { c.onAutoFlush(af) })
+		})
+		return
+	}
+	af.timer.reset(af.interval)
+}
+
+func (af *autoFlush) stop() {
+	af.mu.Lock()
+	af.stopped = true
+	if af.timer != nil {
+		af.timer.Stop()
+	}
+	af.mu.Unlock()
+}
@@ -0,0 +1,196 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// closeState records the reason of closing a connection, and signals Done once it's fully closed,
+// see CloseNotifier.CloseWithError.
+type closeState struct {
+	mu       sync.Mutex
+	done     chan struct{} // created by the first Done call
+	finished bool
+	reason   atomic.Pointer[error]
+}
+
+// doneChan implements CloseNotifier.Done.
+func (s *closeState) doneChan() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done == nil {
+		s.done = make(chan struct{})
+		if s.finished {
+			close(s.done)
+		}
+	}
+	return s.done
+}
+
+// finish closes the channel returned by Done, it's called after all the CloseCallbacks.
+func (s *closeState) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return
+	}
+	s.finished = true
+	if s.done != nil {
+		close(s.done)
+	}
+}
+
+// setReason records err as the reason of closing, only the first one is kept.
+func (s *closeState) setReason(err error) {
+	if err != nil {
+		s.reason.CompareAndSwap(nil, &err)
+	}
+}
+
+func (s *closeState) getReason() error {
+	if r := s.reason.Load(); r != nil {
+		return *r
+	}
+	return nil
+}
+
+// closedError returns ErrConnClosed for the operations after closing, which wraps the reason if any.
+func (s *closeState) closedError(suffix string) error {
+	err := Exception(ErrConnClosed, suffix)
+	if r := s.getReason(); r != nil {
+		return fmt.Errorf("%w: %w", err, r)
+	}
+	return err
+}
+
+// finalPriority is the priority of the CloseCallback releasing the resources of a connection, which runs last.
+const finalPriority = math.MinInt
+
+// CloseCallbackHandle is returned by PriorityCloseCallbackAdder.AddCloseCallbackWithPriority to remove the CloseCallback.
+type CloseCallbackHandle struct {
+	callbacks *closeCallbacks
+	fn        CloseCallback
+	priority  int
+}
+
+// Remove removes the CloseCallback, so that it won't be called. It returns false if the CloseCallback
+// has been called or is being called, or it has been removed.
+func (h *CloseCallbackHandle) Remove() bool {
+	if h == nil {
+		return false
+	}
+	cs := h.callbacks
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for i, handle := range cs.handles {
+		if handle == h {
+			cs.handles = append(cs.handles[:i], cs.handles[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// closeCallbacks holds the CloseCallbacks of a connection in the calling order, i.e. the higher priority first,
+// and the later added first for the same priority. They are taken only once, so each is called exactly once.
+type closeCallbacks struct {
+	mu      sync.Mutex
+	handles []*CloseCallbackHandle
+	closed  bool // the connection is closed, the callbacks are taken once OnRequest is not running
+	busy    bool // OnRequest is running
+	fired   bool // the callbacks have been taken
+}
+
+// add registers fn with priority, it returns ErrConnClosed if the callbacks have been taken.
+func (cs *closeCallbacks) add(fn CloseCallback, priority int) (*CloseCallbackHandle, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.fired {
+		return nil, Exception(ErrConnClosed, "when add close callback")
+	}
+	h := &CloseCallbackHandle{callbacks: cs, fn: fn, priority: priority}
+	i := 0
+	for i < len(cs.handles) && cs.handles[i].priority > priority {
+		i++
+	}
+	cs.handles = append(cs.handles, nil)
+	copy(cs.handles[i+1:], cs.handles[i:])
+	cs.handles[i] = h
+	return h, nil
+}
+
+// take takes the callbacks to be called, it returns nil if they have been taken.
+func (cs *closeCallbacks) take() []*CloseCallbackHandle {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.takeLocked()
+}
+
+func (cs *closeCallbacks) takeLocked() []*CloseCallbackHandle {
+	if cs.fired {
+		return nil
+	}
+	cs.fired = true
+	handles := cs.handles
+	cs.handles = nil
+	return handles
+}
+
+// close marks the connection closed, and takes the callbacks unless OnRequest is running,
+// which are taken by leave after OnRequest returns. ok is true if the callbacks are taken.
+func (cs *closeCallbacks) close() (handles []*CloseCallbackHandle, ok bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.closed = true
+	if cs.busy || cs.fired {
+		return nil, false
+	}
+	return cs.takeLocked(), true
+}
+
+// enter is called before OnRequest, it returns false if the connection is closed.
+func (cs *closeCallbacks) enter() bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.closed {
+		return false
+	}
+	cs.busy = true
+	return true
+}
+
+// leave is called after OnRequest returns, and takes the callbacks if the connection is closed meanwhile.
+func (cs *closeCallbacks) leave() (handles []*CloseCallbackHandle, ok bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.busy = false
+	if !cs.closed || cs.fired {
+		return nil, false
+	}
+	return cs.takeLocked(), true
+}
+
+// runCloseCallbacks calls the callbacks taken from closeCallbacks in order.
+func runCloseCallbacks(conn Connection, handles []*CloseCallbackHandle) {
+	for i, h := range handles {
+		if err := h.fn(conn); err != nil {
+			logger.Warn("CloseCallback failed", "index", i, "err", err)
+		}
+	}
+}
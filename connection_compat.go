@@ -0,0 +1,214 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// netConnCompat makes the net.Conn methods behave like net.TCPConn, see NetConnCompatSetter.SetNetConnCompat.
+type netConnCompat struct {
+	readWake  chan struct{} // notifies the pending read that the read deadline is changed
+	writeWake chan struct{} // notifies the pending flush that the write deadline is changed
+}
+
+// SetNetConnCompat implements NetConnCompatSetter.
+func (c *connection) SetNetConnCompat(enabled bool) error {
+	var nc *netConnCompat
+	if enabled {
+		nc = &netConnCompat{readWake: make(chan struct{}, 1), writeWake: make(chan struct{}, 1)}
+	}
+	if old := c.compat.Swap(nc); old != nil {
+		// the pending waits check again without the compat mode
+		wake(old.readWake)
+		wake(old.writeWake)
+	}
+	return nil
+}
+
+// deadlineChanged wakes up the pending read or flush to check the new deadline in the compat mode.
+func (c *connection) deadlineChanged(read, write bool) {
+	nc := c.compat.Load()
+	if nc == nil {
+		return
+	}
+	if read {
+		wake(nc.readWake)
+	}
+	if write {
+		wake(nc.writeWake)
+	}
+}
+
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// deadlineExceeded reports whether the deadline loaded from dl has passed.
+func deadlineExceeded(dl *int64) bool {
+	d := atomic.LoadInt64(dl)
+	return d > 0 && d <= time.Now().UnixNano()
+}
+
+// netConnError converts err returned by the net.Conn method op to the one returned by net.TCPConn in the compat mode.
+func (c *connection) netConnError(op string, err error) error {
+	if err == nil || c.compat.Load() == nil {
+		return err
+	}
+	switch {
+	case c.isCloseBy(user):
+		err = net.ErrClosed
+	case errors.Is(err, ErrEOF):
+		// the connection closed by the poller wraps ErrEOF as the reason
+		if op == "read" {
+			return io.EOF
+		}
+	case errors.Is(err, ErrConnClosed):
+		err = net.ErrClosed
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		err = os.ErrDeadlineExceeded
+	}
+	return &net.OpError{Op: op, Net: c.network, Source: c.localAddr, Addr: c.remoteAddr, Err: err}
+}
+
+// waitReadCompat waits full n bytes like waitRead, and the read deadline is loaded again once it's changed,
+// so that the pending read is woken up by SetReadDeadline. readTimeout takes effect if the deadline is not set.
+func (c *connection) waitReadCompat(nc *netConnCompat, n int, done <-chan struct{}) error {
+	var timeout int64
+	if c.readTimeout > 0 {
+		timeout = time.Now().Add(c.readTimeout).UnixNano()
+	}
+	var timer deadlineTimer
+	defer timer.stop()
+	for c.inputBuffer.Len() < n {
+		switch c.status(closing) {
+		case poller:
+			return Exception(ErrEOF, "wait read")
+		case user:
+			return c.closeState.closedError("wait read")
+		}
+		dl := atomic.LoadInt64(&c.readDeadline)
+		if dl == 0 {
+			dl = timeout
+		}
+		expired, ok := timer.reset(dl)
+		if !ok {
+			return Exception(ErrReadTimeout, c.peerString())
+		}
+		select {
+		case err := <-c.readTrigger:
+			if err != nil {
+				return err
+			}
+		case <-nc.readWake:
+		case <-expired:
+			timer.fired()
+		case <-done:
+			if c.inputBuffer.Len() >= n {
+				return nil
+			}
+			return c.readCtx.Err()
+		}
+	}
+	return nil
+}
+
+// waitFlushCompat waits the poller to flush like waitFlush, and the write deadline is loaded again once it's changed.
+func (c *connection) waitFlushCompat(nc *netConnCompat) error {
+	var timeout int64
+	if c.writeTimeout > 0 {
+		timeout = time.Now().Add(c.writeTimeout).UnixNano()
+	}
+	var timer deadlineTimer
+	defer timer.stop()
+	for {
+		dl := atomic.LoadInt64(&c.writeDeadline)
+		if dl == 0 {
+			dl = timeout
+		}
+		expired, ok := timer.reset(dl)
+		if !ok {
+			select {
+			case err := <-c.writeTrigger:
+				return err
+			default:
+			}
+			// the same as waitFlush, the data left is not flushed again
+			c.operator.Control(PollRW2R)
+			return Exception(ErrWriteTimeout, c.peerString())
+		}
+		select {
+		case err := <-c.writeTrigger:
+			return err
+		case <-nc.writeWake:
+		case <-expired:
+			timer.fired()
+		}
+	}
+}
+
+// deadlineTimer fires at the deadline, which may be changed while waiting.
+type deadlineTimer struct {
+	timer    *time.Timer
+	deadline int64 // UnixNano() the timer fires at, 0 if it's not armed
+}
+
+// reset arms the timer to fire at deadline if it's changed, and returns the channel to wait, which is nil
+// if deadline is 0. It returns false if deadline has passed.
+func (t *deadlineTimer) reset(deadline int64) (<-chan time.Time, bool) {
+	if deadline == 0 {
+		t.stop()
+		return nil, true
+	}
+	timeout := time.Duration(deadline - time.Now().UnixNano())
+	if timeout <= 0 {
+		return nil, false
+	}
+	if deadline != t.deadline {
+		t.stop()
+		if t.timer == nil {
+			t.timer = time.NewTimer(timeout)
+		} else {
+			t.timer.Reset(timeout)
+		}
+		t.deadline = deadline
+	}
+	return t.timer.C, true
+}
+
+// fired must be called once the channel returned by reset is received.
+func (t *deadlineTimer) fired() {
+	t.deadline = 0
+}
+
+func (t *deadlineTimer) stop() {
+	if t.deadline != 0 && !t.timer.Stop() {
+		select {
+		case <-t.timer.C:
+		default:
+		}
+	}
+	t.deadline = 0
+}
@@ -16,11 +16,16 @@ package netpoll
 
 import (
 	"fmt"
+	"io"
 	"net"
+	"os"
 	"syscall"
 )
 
-// extends syscall.Errno, the range is set to 0x100-0x1FF
+// extends syscall.Errno, the range is set to 0x100-0x1FF.
+// The errors returned by netpoll wrap them, use errors.Is to check them instead of comparing the strings.
+// The errors also match the ones of the standard library, e.g. ErrEOF matches io.EOF, ErrConnClosed matches
+// net.ErrClosed and the timeouts match os.ErrDeadlineExceeded, and errors.As works with net.Error.
 const (
 	// The connection closed when in use.
 	ErrConnClosed = syscall.Errno(0x101)
@@ -38,6 +43,14 @@ const (
 	ErrWriteTimeout = syscall.Errno(0x107)
 	// Concurrent connection access error
 	ErrConcurrentAccess = syscall.Errno(0x108)
+	// The output buffer exceeds the limit set by BufferTuner.SetMaxOutputBuffer
+	ErrWriteBufferFull = syscall.Errno(0x109)
+	// The frame exceeds the max size of the FrameDecoder
+	ErrFrameTooLarge = syscall.Errno(0x10A)
+	// The delimiter is not found within the max length, see UntilN
+	ErrLineTooLong = syscall.Errno(0x10B)
+	// The HTTP request is malformed, see HTTPRequest.Parse
+	ErrBadHTTPRequest = syscall.Errno(0x10C)
 )
 
 const ErrnoMask = 0xFF
@@ -86,6 +99,9 @@ func (e *exception) Is(target error) bool {
 	if e.no == ErrEOF && target == ErrConnClosed {
 		return true
 	}
+	if std := stdErrors[e.no]; std != nil && std == target {
+		return true
+	}
 	return e.no.Is(target)
 }
 
@@ -105,6 +121,15 @@ func (e *exception) Temporary() bool {
 	return e.no.Temporary()
 }
 
+// stdErrors are the errors of the standard library matched by the errors defined in netpoll.
+var stdErrors = map[syscall.Errno]error{
+	ErrConnClosed:   net.ErrClosed,
+	ErrEOF:          io.EOF,
+	ErrReadTimeout:  os.ErrDeadlineExceeded,
+	ErrWriteTimeout: os.ErrDeadlineExceeded,
+	ErrDialTimeout:  os.ErrDeadlineExceeded,
+}
+
 // Errors defined in netpoll
 var errnos = [...]string{
 	ErrnoMask & ErrConnClosed:       "connection has been closed",
@@ -115,4 +140,8 @@ var errnos = [...]string{
 	ErrnoMask & ErrEOF:              "EOF",
 	ErrnoMask & ErrWriteTimeout:     "connection write timeout",
 	ErrnoMask & ErrConcurrentAccess: "concurrent connection access",
+	ErrnoMask & ErrWriteBufferFull:  "connection write buffer full",
+	ErrnoMask & ErrFrameTooLarge:    "frame too large",
+	ErrnoMask & ErrLineTooLong:      "line too long",
+	ErrnoMask & ErrBadHTTPRequest:   "malformed http request",
 }
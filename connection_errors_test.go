@@ -18,8 +18,13 @@ package netpoll
 
 import (
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
 	"syscall"
 	"testing"
+	"time"
 )
 
 func TestErrno(t *testing.T) {
@@ -33,3 +38,32 @@ func TestErrno(t *testing.T) {
 	Equal(t, err2.Error(), "broken pipe when flush")
 	t.Logf("error2=%s", err2)
 }
+
+func TestErrnoStdErrors(t *testing.T) {
+	MustTrue(t, errors.Is(Exception(ErrEOF, ""), io.EOF))
+	MustTrue(t, errors.Is(Exception(ErrEOF, ""), ErrConnClosed))
+	MustTrue(t, errors.Is(Exception(ErrConnClosed, "when read"), net.ErrClosed))
+	MustTrue(t, !errors.Is(Exception(ErrConnClosed, ""), io.EOF))
+	MustTrue(t, errors.Is(Exception(ErrReadTimeout, ""), os.ErrDeadlineExceeded))
+	MustTrue(t, errors.Is(Exception(ErrWriteTimeout, ""), os.ErrDeadlineExceeded))
+	MustTrue(t, !errors.Is(Exception(ErrWriteBufferFull, ""), os.ErrDeadlineExceeded))
+
+	var ne net.Error
+	MustTrue(t, errors.As(fmt.Errorf("wrapped: %w", Exception(ErrReadTimeout, "")), &ne))
+	MustTrue(t, ne.Timeout())
+
+	// the errors returned by the connection
+	rfd, wfd := GetSysFdPairs()
+	rconn, err := NewFDConnection(rfd)
+	MustNil(t, err)
+	wconn, err := NewFDConnection(wfd)
+	MustNil(t, err)
+	rconn.SetReadTimeout(time.Millisecond)
+	_, err = rconn.Reader().Next(1)
+	MustTrue(t, errors.Is(err, ErrReadTimeout) && errors.Is(err, os.ErrDeadlineExceeded))
+	MustTrue(t, errors.As(err, &ne) && ne.Timeout())
+	wconn.Close()
+	_, err = rconn.Reader().Next(1)
+	MustTrue(t, errors.Is(err, ErrEOF) && errors.Is(err, io.EOF))
+	rconn.Close()
+}
@@ -17,10 +17,18 @@
 package netpoll
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 type connState = int32
@@ -40,24 +48,75 @@ type connection struct {
 	readTimeout   time.Duration
 	readDeadline  int64 // UnixNano(). it overwrites readTimeout. 0 if not set.
 	readTimer     *time.Timer
+	readCtx       context.Context // see SetReadContext, nil if not set
 	readTrigger   chan error
 	waitReadSize  int64
 	writeTimeout  time.Duration
 	writeDeadline int64 // UnixNano(). it overwrites writeTimeout. 0 if not set.
 	writeTimer    *time.Timer
 	writeTrigger  chan error
+	flushCallback atomic.Pointer[func(err error)] // see FlushAsync, the flushing lock is held until it's called
+	autoFlush     atomic.Pointer[autoFlush]       // see SetAutoFlush, nil if disabled
+	heartbeat     heartbeat
+	safe          safeWriter // see SafeWrite
+	closeState    closeState // see Done and CloseWithError
 	inputBuffer   *LinkBuffer
 	outputBuffer  *LinkBuffer
 	outputBarrier *barrier
 	maxSize       int       // The maximum size of data between two Release().
 	bookSize      int       // The size of data that can be read at once.
 	state         connState // Connection state should be changed sequentially.
+
+	maxInputBuffer  int64      // see SetMaxInputBuffer, 0 means no limit
+	readWatermark   int64      // see SetReadWatermark, 0 means any data
+	maxOutputBuffer int64      // see SetMaxOutputBuffer, 0 means no limit
+	readPaused      int32      // 1 if the poller stops reading since the input buffer is full or throttled
+	readPauseMu     sync.Mutex // serializes pauseRead and resumeRead
+	readClosed      int32      // 1 if CloseRead is called, reading is paused forever
+	rightsMu        sync.Mutex
+	rights          []int // the fds received by SCM_RIGHTS, see ReceiveFDs
+	budget          *memoryBudget
+	stats           connStats
+	userData        atomic.Pointer[interface{}]  // see SetUserData
+	readLimit       atomic.Pointer[readLimiter]  // see SetReadRateLimit, nil if disabled
+	writeLimit      atomic.Pointer[writeLimiter] // see SetWriteRateLimit, nil if disabled
+	egress          *writeLimiter                // see WithWriteRateLimit, shared by the EventLoop
+	pacer           writePacer
+	fdMu            sync.RWMutex // serializes RawConn.Control with closing the fd
+	fdClosed        bool
+	compat          atomic.Pointer[netConnCompat] // see SetNetConnCompat, nil if disabled
+	zeroCopy        atomic.Pointer[zeroCopy]      // see SetZeroCopy, nil if never enabled
 }
 
 var (
 	_ Connection = &connection{}
 	_ Reader     = &connection{}
 	_ Writer     = &connection{}
+
+	_ BinaryToReader = &connection{}
+	_ UntilNReader   = &connection{}
+	_ PeekVecReader  = &connection{}
+	_ VecWriter      = &connection{}
+
+	_ SocketConn                 = &connection{}
+	_ BufferTuner                = &connection{}
+	_ HalfCloser                 = &connection{}
+	_ SocketTuner                = &connection{}
+	_ AsyncFlusher               = &connection{}
+	_ AutoFlusher                = &connection{}
+	_ StatsProvider              = &connection{}
+	_ Heartbeater                = &connection{}
+	_ LoopRunner                 = &connection{}
+	_ ConcurrentWriter           = &connection{}
+	_ ReadContextSetter          = &connection{}
+	_ CloseNotifier              = &connection{}
+	_ PriorityCloseCallbackAdder = &connection{}
+	_ UserDataHolder             = &connection{}
+	_ RateLimiter                = &connection{}
+	_ TCPInfoProvider            = &connection{}
+	_ NetConnCompatSetter        = &connection{}
+	_ KernelTLSEnabler           = &connection{}
+	_ pollConnection             = &connection{}
 )
 
 // Reader implements Connection.
@@ -83,12 +142,106 @@ func (c *connection) SetIdleTimeout(timeout time.Duration) error {
 	return nil
 }
 
+// SetMallocSize implements BufferTuner.
+func (c *connection) SetMallocSize(size int) error {
+	if size < 0 {
+		size = 0
+	}
+	c.inputBuffer.setBlockSize(size)
+	c.outputBuffer.setBlockSize(size)
+	return nil
+}
+
+// SetMaxInputBuffer implements BufferTuner.
+func (c *connection) SetMaxInputBuffer(size int) error {
+	if size < 0 {
+		size = 0
+	}
+	atomic.StoreInt64(&c.maxInputBuffer, int64(size))
+	// the limit may be raised or removed
+	c.resumeRead()
+	return nil
+}
+
+// SetReadWatermark implements BufferTuner.
+func (c *connection) SetReadWatermark(n int) error {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt64(&c.readWatermark, int64(n))
+	// the buffered data may reach the lowered watermark
+	if c.readable() && c.onRequestCallback.Load() != nil {
+		c.onRequest()
+	}
+	return nil
+}
+
+// readable returns true if the buffered data reaches the read watermark to call OnRequest.
+func (c *connection) readable() bool {
+	return c.inputBuffer.Len() >= c.watermark()
+}
+
+func (c *connection) watermark() int {
+	if wm := int(atomic.LoadInt64(&c.readWatermark)); wm > 1 {
+		return wm
+	}
+	return 1
+}
+
+// SetMaxOutputBuffer implements BufferTuner.
+func (c *connection) SetMaxOutputBuffer(size int) error {
+	if size < 0 {
+		size = 0
+	}
+	atomic.StoreInt64(&c.maxOutputBuffer, int64(size))
+	return nil
+}
+
+// SetHeartbeat implements Heartbeater.
+func (c *connection) SetHeartbeat(interval time.Duration, fn func(connection Connection)) error {
+	c.heartbeat.set(c.wheel(), c, interval, fn)
+	return nil
+}
+
+// RunOnLoop implements LoopRunner.
+func (c *connection) RunOnLoop(task func()) error {
+	if !c.IsActive() {
+		return Exception(ErrConnClosed, "when run on loop")
+	}
+	poster, ok := c.operator.currentPoll().(taskPoster)
+	if !ok {
+		return Exception(ErrUnsupported, "RunOnLoop")
+	}
+	return poster.post(task)
+}
+
+// wheel returns the timer wheel of the poller serving the connection.
+func (c *connection) wheel() *timerWheel {
+	return pollTimers(c.operator.currentPoll())
+}
+
+// SafeWrite implements ConcurrentWriter.
+func (c *connection) SafeWrite(p []byte) error {
+	return c.safe.write(c, p)
+}
+
+// SafeFlush implements ConcurrentWriter.
+func (c *connection) SafeFlush() error {
+	return c.safe.flush(c)
+}
+
 // SetReadTimeout implements Connection.
 func (c *connection) SetReadTimeout(timeout time.Duration) error {
 	if timeout >= 0 {
 		c.readTimeout = timeout
 	}
-	c.readDeadline = 0
+	atomic.StoreInt64(&c.readDeadline, 0)
+	return nil
+}
+
+// SetReadContext implements ReadContextSetter.
+func (c *connection) SetReadContext(ctx context.Context) error {
+	c.readCtx = ctx
 	return nil
 }
 
@@ -97,7 +250,7 @@ func (c *connection) SetWriteTimeout(timeout time.Duration) error {
 	if timeout >= 0 {
 		c.writeTimeout = timeout
 	}
-	c.writeDeadline = 0
+	atomic.StoreInt64(&c.writeDeadline, 0)
 	return nil
 }
 
@@ -107,28 +260,31 @@ func (c *connection) SetDeadline(t time.Time) error {
 	if !t.IsZero() {
 		v = t.UnixNano()
 	}
-	c.readDeadline = v
-	c.writeDeadline = v
+	atomic.StoreInt64(&c.readDeadline, v)
+	atomic.StoreInt64(&c.writeDeadline, v)
+	c.deadlineChanged(true, true)
 	return nil
 }
 
 // SetReadDeadline implements net.Conn.SetReadDeadline
 func (c *connection) SetReadDeadline(t time.Time) error {
 	if t.IsZero() {
-		c.readDeadline = 0
+		atomic.StoreInt64(&c.readDeadline, 0)
 	} else {
-		c.readDeadline = t.UnixNano()
+		atomic.StoreInt64(&c.readDeadline, t.UnixNano())
 	}
+	c.deadlineChanged(true, false)
 	return nil
 }
 
 // SetWriteDeadline implements net.Conn.SetWriteDeadline
 func (c *connection) SetWriteDeadline(t time.Time) error {
 	if t.IsZero() {
-		c.writeDeadline = 0
+		atomic.StoreInt64(&c.writeDeadline, 0)
 	} else {
-		c.writeDeadline = t.UnixNano()
+		atomic.StoreInt64(&c.writeDeadline, t.UnixNano())
 	}
+	c.deadlineChanged(false, true)
 	return nil
 }
 
@@ -150,6 +306,14 @@ func (c *connection) Peek(n int) (buf []byte, err error) {
 	return c.inputBuffer.Peek(n)
 }
 
+// PeekVec implements PeekVecReader.
+func (c *connection) PeekVec(n int) (vs [][]byte, err error) {
+	if err = c.waitRead(n); err != nil {
+		return vs, err
+	}
+	return c.inputBuffer.PeekVec(n)
+}
+
 // Skip implements Connection.
 func (c *connection) Skip(n int) (err error) {
 	if err = c.waitRead(n); err != nil {
@@ -178,7 +342,9 @@ func (c *connection) Release() (err error) {
 		}
 		c.operator.done()
 	}
-	return c.inputBuffer.Release()
+	err = c.inputBuffer.Release()
+	c.resumeRead()
+	return err
 }
 
 // Slice implements Connection.
@@ -196,6 +362,11 @@ func (c *connection) Len() (length int) {
 
 // Until implements Connection.
 func (c *connection) Until(delim byte) (line []byte, err error) {
+	return c.UntilN(delim, 0)
+}
+
+// UntilN implements UntilNReader.
+func (c *connection) UntilN(delim byte, max int) (line []byte, err error) {
 	var n, l int
 	for {
 		if err = c.waitRead(n + 1); err != nil {
@@ -208,8 +379,14 @@ func (c *connection) Until(delim byte) (line []byte, err error) {
 		i := c.inputBuffer.indexByte(delim, n)
 		if i < 0 {
 			n = l // skip all exists bytes
+			if max > 0 && n >= max {
+				return nil, Exception(ErrLineTooLong, fmt.Sprintf("max[%d]", max))
+			}
 			continue
 		}
+		if max > 0 && i >= max {
+			return nil, Exception(ErrLineTooLong, fmt.Sprintf("max[%d]", max))
+		}
 		return c.Next(i + 1)
 	}
 }
@@ -230,6 +407,22 @@ func (c *connection) ReadBinary(n int) (p []byte, err error) {
 	return c.inputBuffer.ReadBinary(n)
 }
 
+// ReadBinaryTo implements BinaryToReader.
+func (c *connection) ReadBinaryTo(p []byte) (n int, err error) {
+	if err = c.waitRead(len(p)); err != nil {
+		return 0, err
+	}
+	return c.inputBuffer.ReadBinaryTo(p)
+}
+
+// PeekTo implements BinaryToReader.
+func (c *connection) PeekTo(p []byte) (n int, err error) {
+	if err = c.waitRead(len(p)); err != nil {
+		return 0, err
+	}
+	return c.inputBuffer.PeekTo(p)
+}
+
 // ReadByte implements Connection.
 func (c *connection) ReadByte() (b byte, err error) {
 	if err = c.waitRead(1); err != nil {
@@ -245,11 +438,21 @@ func (c *connection) Malloc(n int) (buf []byte, err error) {
 	if !c.IsActive() {
 		return nil, Exception(ErrConnClosed, "when malloc")
 	}
-	return c.outputBuffer.Malloc(n)
+	af := c.beginWrite()
+	if err = c.checkOutputBuffer(n); err == nil {
+		buf, err = c.outputBuffer.Malloc(n)
+	}
+	if af != nil && err == nil {
+		af.malloced = true
+	}
+	return buf, c.endWrite(af, err)
 }
 
 // MallocLen implements Connection.
 func (c *connection) MallocLen() (length int) {
+	if af := c.beginWrite(); af != nil {
+		defer af.mu.Unlock()
+	}
 	return c.outputBuffer.MallocLen()
 }
 
@@ -260,6 +463,15 @@ func (c *connection) MallocLen() (length int) {
 // If empty, it will call syscall.Write to send data directly,
 // otherwise the buffer will be sent asynchronously by the epoll trigger.
 func (c *connection) Flush() error {
+	if af := c.beginWrite(); af != nil {
+		af.malloced = false
+		defer af.mu.Unlock()
+	}
+	return c.flushBuffer()
+}
+
+// flushBuffer flushes the output buffer without locking it against the auto flush timer.
+func (c *connection) flushBuffer() error {
 	if !c.IsActive() {
 		return Exception(ErrConnClosed, "when flush")
 	}
@@ -268,9 +480,278 @@ func (c *connection) Flush() error {
 		return Exception(ErrConcurrentAccess, "when flush")
 	}
 	defer c.unlock(flushing)
+	if err := c.checkOutputBuffer(0); err != nil {
+		return err
+	}
 
 	c.outputBuffer.Flush()
-	return c.flush()
+	err := c.flush()
+	c.trace(TraceFlush, err)
+	return err
+}
+
+// FlushAsync implements AsyncFlusher.
+func (c *connection) FlushAsync(callback func(err error)) error {
+	if af := c.beginWrite(); af != nil {
+		af.malloced = false
+		defer af.mu.Unlock()
+	}
+	if !c.IsActive() {
+		return Exception(ErrConnClosed, "when flush")
+	}
+	if !c.lock(flushing) {
+		return Exception(ErrConcurrentAccess, "when flush")
+	}
+	if err := c.checkOutputBuffer(0); err != nil {
+		c.unlock(flushing)
+		return err
+	}
+
+	c.outputBuffer.Flush()
+	done, err := c.send()
+	if done || err != nil {
+		c.unlock(flushing)
+		c.trace(TraceFlush, err)
+		if err == nil {
+			callback(nil)
+		}
+		return err
+	}
+	// the flushing lock is released by the poller with the callback, see triggerWrite.
+	cb := &callback
+	c.flushCallback.Store(cb)
+	err = c.operator.Control(PollR2RW)
+	if err == nil && !c.IsActive() {
+		err = Exception(ErrConnClosed, "when flush")
+	}
+	if err != nil && c.flushCallback.CompareAndSwap(cb, nil) {
+		c.unlock(flushing)
+		return Exception(err, "when flush")
+	}
+	return nil
+}
+
+// SendFile implements SocketConn.
+func (c *connection) SendFile(f *os.File, off, n int64) (written int64, err error) {
+	if !c.IsActive() {
+		return 0, Exception(ErrConnClosed, "when sendfile")
+	}
+	if n, err = sendFileSize(f, off, n); err != nil {
+		return 0, err
+	}
+	if af := c.beginWrite(); af != nil {
+		defer af.mu.Unlock()
+	}
+	if !c.lock(flushing) {
+		return 0, Exception(ErrConcurrentAccess, "when sendfile")
+	}
+	defer c.unlock(flushing)
+
+	// flush the buffered data first to keep the order of output
+	flush := func() error {
+		c.outputBuffer.Flush()
+		return c.flush()
+	}
+	if err = flush(); err != nil {
+		return 0, err
+	}
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	for written < n {
+		size := n - written
+		if size > maxSendfileSize {
+			size = maxSendfileSize
+		}
+		var m int
+		var serr error
+		err = rc.Read(func(infd uintptr) bool {
+			offset := off + written
+			m, serr = unix.Sendfile(c.fd, int(infd), &offset, int(size))
+			return true
+		})
+		if err != nil {
+			return written, err
+		}
+		if m > 0 {
+			c.stats.write(m)
+			written += int64(m)
+		}
+		switch {
+		case serr == nil:
+			if m == 0 { // reach the end of file
+				return written, io.EOF
+			}
+		case serr == syscall.EINTR:
+		case serr == syscall.EAGAIN:
+			// wait for writable, the poller will trigger write since the output buffer is empty
+			if err = c.operator.Control(PollR2RW); err != nil {
+				return written, Exception(err, "when sendfile")
+			}
+			if err = c.waitFlush(); err != nil {
+				return written, err
+			}
+		case written == 0 && isSendfileUnsupported(serr):
+			// sendfile is unavailable for the file or the socket
+			return copyFile(c.outputBuffer, flush, f, off, n)
+		default:
+			return written, Exception(serr, "when sendfile")
+		}
+	}
+	return written, nil
+}
+
+// SendFDs implements SocketConn.
+func (c *connection) SendFDs(fds []int) error {
+	if !c.IsActive() {
+		return Exception(ErrConnClosed, "when send fds")
+	}
+	if !c.isUnix() {
+		return Exception(ErrUnsupported, "SendFDs on non-unix connection")
+	}
+	if af := c.beginWrite(); af != nil {
+		defer af.mu.Unlock()
+	}
+	if !c.lock(flushing) {
+		return Exception(ErrConcurrentAccess, "when send fds")
+	}
+	defer c.unlock(flushing)
+
+	// flush the buffered data first to keep the order of output
+	c.outputBuffer.Flush()
+	if err := c.flush(); err != nil {
+		return err
+	}
+	for {
+		n, err := sendRights(c.fd, fds)
+		switch {
+		case err == nil && n > 0:
+			c.stats.write(n)
+			return nil
+		case err == nil || err == syscall.EINTR:
+		case err == syscall.EAGAIN:
+			// wait for writable, the poller will trigger write since the output buffer is empty
+			if err = c.operator.Control(PollR2RW); err != nil {
+				return Exception(err, "when send fds")
+			}
+			if err = c.waitFlush(); err != nil {
+				return err
+			}
+		default:
+			return Exception(err, "when send fds")
+		}
+	}
+}
+
+// ReceiveFDs implements SocketConn.
+func (c *connection) ReceiveFDs() (fds []int) {
+	c.rightsMu.Lock()
+	fds, c.rights = c.rights, nil
+	c.rightsMu.Unlock()
+	return fds
+}
+
+// Stats implements StatsProvider.
+func (c *connection) Stats() ConnStats {
+	return c.stats.snapshot()
+}
+
+// SetUserData implements UserDataHolder.
+func (c *connection) SetUserData(data interface{}) error {
+	c.userData.Store(&data)
+	return nil
+}
+
+// UserData implements UserDataHolder.
+func (c *connection) UserData() interface{} {
+	if data := c.userData.Load(); data != nil {
+		return *data
+	}
+	return nil
+}
+
+// PeerCredentials implements SocketConn.
+func (c *connection) PeerCredentials() (*Ucred, error) {
+	if !c.isUnix() {
+		return nil, Exception(ErrUnsupported, "PeerCredentials on non-unix connection")
+	}
+	return getPeerCred(c.fd)
+}
+
+// TCPInfo implements TCPInfoProvider.
+func (c *connection) TCPInfo() (*TCPInfo, error) {
+	switch c.network {
+	case "tcp", "tcp4", "tcp6":
+		return getTCPInfo(c.fd)
+	}
+	return nil, Exception(ErrUnsupported, "TCPInfo on non-tcp connection")
+}
+
+// MPTCPInfo implements TCPInfoProvider.
+func (c *connection) MPTCPInfo() (*MPTCPInfo, error) {
+	switch c.network {
+	case "tcp", "tcp4", "tcp6":
+		return getMPTCPInfo(c.fd)
+	}
+	return nil, Exception(ErrUnsupported, "MPTCPInfo on non-tcp connection")
+}
+
+// OriginalDst implements SocketConn.
+func (c *connection) OriginalDst() (net.Addr, error) {
+	switch c.network {
+	case "tcp", "tcp4", "tcp6":
+		return getOriginalDst(c.fd)
+	}
+	return nil, Exception(ErrUnsupported, "OriginalDst on non-tcp connection")
+}
+
+// EnableKernelTLS implements KernelTLSEnabler.
+func (c *connection) EnableKernelTLS(params KernelTLSParams) error {
+	switch c.network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return Exception(ErrUnsupported, "EnableKernelTLS on non-tcp connection")
+	}
+	// the buffered data is not the plaintext expected by the kernel
+	if params.TX != nil && !c.outputBuffer.IsEmpty() {
+		return Exception(syscall.EBUSY, "output buffered before kernel TLS")
+	}
+	if params.RX != nil && c.inputBuffer.Len() > 0 {
+		return Exception(syscall.EBUSY, "input buffered before kernel TLS")
+	}
+	return setKernelTLS(c.fd, &params)
+}
+
+// peerString returns the remote address in the errors, which may be nil for the connections created by NewFDConnection.
+func (c *connection) peerString() string {
+	if c.remoteAddr == nil {
+		return ""
+	}
+	return c.remoteAddr.String()
+}
+
+// isUnix reports whether the connection is a unix socket.
+func (c *connection) isUnix() bool {
+	return strings.HasPrefix(c.network, "unix")
+}
+
+// onRights implements FDOperator.
+func (c *connection) onRights(fds []int) {
+	c.rightsMu.Lock()
+	c.rights = append(c.rights, fds...)
+	c.rightsMu.Unlock()
+}
+
+// closeRights closes the received fds not taken by ReceiveFDs.
+func (c *connection) closeRights() {
+	for _, fd := range c.ReceiveFDs() {
+		syscall.Close(fd)
+	}
+}
+
+func isSendfileUnsupported(err error) bool {
+	return err == syscall.ENOSYS || err == syscall.EINVAL || err == syscall.EOPNOTSUPP || err == syscall.ENOTSUP
 }
 
 // MallocAck implements Connection.
@@ -278,7 +759,12 @@ func (c *connection) MallocAck(n int) (err error) {
 	if !c.IsActive() {
 		return Exception(ErrConnClosed, "when malloc ack")
 	}
-	return c.outputBuffer.MallocAck(n)
+	af := c.beginWrite()
+	err = c.outputBuffer.MallocAck(n)
+	if af != nil {
+		af.malloced = false
+	}
+	return c.endWrite(af, err)
 }
 
 // Append implements Connection.
@@ -286,7 +772,11 @@ func (c *connection) Append(w Writer) (err error) {
 	if !c.IsActive() {
 		return Exception(ErrConnClosed, "when append")
 	}
-	return c.outputBuffer.Append(w)
+	af := c.beginWrite()
+	if err = c.checkOutputBuffer(w.MallocLen()); err == nil {
+		err = c.outputBuffer.Append(w)
+	}
+	return c.endWrite(af, err)
 }
 
 // WriteString implements Connection.
@@ -294,7 +784,11 @@ func (c *connection) WriteString(s string) (n int, err error) {
 	if !c.IsActive() {
 		return 0, Exception(ErrConnClosed, "when write string")
 	}
-	return c.outputBuffer.WriteString(s)
+	af := c.beginWrite()
+	if err = c.checkOutputBuffer(len(s)); err == nil {
+		n, err = c.outputBuffer.WriteString(s)
+	}
+	return n, c.endWrite(af, err)
 }
 
 // WriteBinary implements Connection.
@@ -302,7 +796,23 @@ func (c *connection) WriteBinary(b []byte) (n int, err error) {
 	if !c.IsActive() {
 		return 0, Exception(ErrConnClosed, "when write binary")
 	}
-	return c.outputBuffer.WriteBinary(b)
+	af := c.beginWrite()
+	if err = c.checkOutputBuffer(len(b)); err == nil {
+		n, err = c.outputBuffer.WriteBinary(b)
+	}
+	return n, c.endWrite(af, err)
+}
+
+// Writev implements VecWriter.
+func (c *connection) Writev(bs [][]byte) (n int, err error) {
+	if !c.IsActive() {
+		return 0, Exception(ErrConnClosed, "when writev")
+	}
+	af := c.beginWrite()
+	if err = c.checkOutputBuffer(vecLen(bs)); err == nil {
+		n, err = c.outputBuffer.Writev(bs)
+	}
+	return n, c.endWrite(af, err)
 }
 
 // WriteDirect implements Connection.
@@ -310,7 +820,11 @@ func (c *connection) WriteDirect(p []byte, remainCap int) (err error) {
 	if !c.IsActive() {
 		return Exception(ErrConnClosed, "when write direct")
 	}
-	return c.outputBuffer.WriteDirect(p, remainCap)
+	af := c.beginWrite()
+	if err = c.checkOutputBuffer(len(p)); err == nil {
+		err = c.outputBuffer.WriteDirect(p, remainCap)
+	}
+	return c.endWrite(af, err)
 }
 
 // WriteByte implements Connection.
@@ -318,7 +832,11 @@ func (c *connection) WriteByte(b byte) (err error) {
 	if !c.IsActive() {
 		return Exception(ErrConnClosed, "when write byte")
 	}
-	return c.outputBuffer.WriteByte(b)
+	af := c.beginWrite()
+	if err = c.checkOutputBuffer(1); err == nil {
+		err = c.outputBuffer.WriteByte(b)
+	}
+	return c.endWrite(af, err)
 }
 
 // ------------------------------------------ implement net.Conn ------------------------------------------
@@ -328,22 +846,41 @@ func (c *connection) Read(p []byte) (n int, err error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
+	if c.compat.Load() != nil && deadlineExceeded(&c.readDeadline) {
+		return 0, c.netConnError("read", Exception(ErrReadTimeout, c.peerString()))
+	}
 	if err = c.waitRead(1); err != nil {
-		return 0, err
+		return 0, c.netConnError("read", err)
 	}
-	return c.inputBuffer.readCopy(p), nil
+	n = c.inputBuffer.readCopy(p)
+	c.resumeRead()
+	return n, nil
 }
 
 // Write will Flush soon.
 func (c *connection) Write(p []byte) (n int, err error) {
+	if c.compat.Load() != nil && deadlineExceeded(&c.writeDeadline) {
+		return 0, c.netConnError("write", Exception(ErrWriteTimeout, c.peerString()))
+	}
+	n, err = c.write(p)
+	return n, c.netConnError("write", err)
+}
+
+func (c *connection) write(p []byte) (n int, err error) {
 	if !c.IsActive() {
 		return 0, Exception(ErrConnClosed, "when write")
 	}
 
+	if af := c.beginWrite(); af != nil {
+		defer af.mu.Unlock()
+	}
 	if !c.lock(flushing) {
 		return 0, Exception(ErrConcurrentAccess, "when write")
 	}
 	defer c.unlock(flushing)
+	if err = c.checkOutputBuffer(len(p)); err != nil {
+		return 0, err
+	}
 
 	dst, _ := c.outputBuffer.Malloc(len(p))
 	n = copy(dst, p)
@@ -357,6 +894,61 @@ func (c *connection) Close() error {
 	return c.onClose()
 }
 
+// CloseWithError implements CloseNotifier.
+func (c *connection) CloseWithError(err error) error {
+	c.closeState.setReason(err)
+	return c.onClose()
+}
+
+// Done implements CloseNotifier.
+func (c *connection) Done() <-chan struct{} {
+	return c.closeState.doneChan()
+}
+
+// Err implements CloseNotifier.
+func (c *connection) Err() error {
+	if c.IsActive() {
+		return nil
+	}
+	// the reason is set by onHup if closed by peer
+	if err := c.closeState.getReason(); err != nil {
+		return err
+	}
+	return Exception(ErrConnClosed, "closed by user")
+}
+
+// CloseWrite implements HalfCloser.
+func (c *connection) CloseWrite() error {
+	if !c.IsActive() {
+		return Exception(ErrConnClosed, "when close write")
+	}
+	if !c.lock(flushing) {
+		return Exception(ErrConcurrentAccess, "when close write")
+	}
+	defer c.unlock(flushing)
+	return c.netFD.CloseWrite()
+}
+
+// CloseRead implements HalfCloser.
+func (c *connection) CloseRead() error {
+	if !c.IsActive() {
+		return Exception(ErrConnClosed, "when close read")
+	}
+	if !atomic.CompareAndSwapInt32(&c.readClosed, 0, 1) {
+		return nil
+	}
+	// stop reading before shutdown, or the poller will treat the EOF as hup and close the connection.
+	c.readPauseMu.Lock()
+	if atomic.LoadInt32(&c.readPaused) == 0 {
+		atomic.StoreInt32(&c.readPaused, 1)
+		c.operator.Control(PollPauseRead)
+	}
+	c.readPauseMu.Unlock()
+	err := c.netFD.CloseRead()
+	c.triggerRead(Exception(ErrEOF, "read closed"))
+	return err
+}
+
 // Detach detaches the connection from poller but doesn't close it.
 func (c *connection) Detach() error {
 	c.detaching = true
@@ -379,13 +971,18 @@ func (c *connection) init(conn Conn, opts *options) (err error) {
 	// init buffer, barrier, finalizer
 	c.readTrigger = make(chan error, 1)
 	c.writeTrigger = make(chan error, 1)
-	c.bookSize, c.maxSize = defaultLinkBufferSize, defaultLinkBufferSize
-	c.inputBuffer, c.outputBuffer = NewLinkBuffer(defaultLinkBufferSize), NewLinkBuffer()
+	size := defaultLinkBufferSize
+	if opts != nil && opts.bufferSize > 0 {
+		size = opts.bufferSize
+	}
+	c.bookSize, c.maxSize = size, size
+	c.inputBuffer, c.outputBuffer = NewLinkBuffer(size), NewLinkBuffer()
 	c.outputBarrier = barrierPool.Get().(*barrier)
 	c.state = connStateNone
 
 	c.initNetFD(conn) // conn must be *netFD{}
-	c.initFDOperator()
+	c.initFDOperator(opts)
+	c.stats.init(pollStats(c.operator.poll))
 	c.initFinalizer()
 
 	syscall.SetNonblock(c.fd, true)
@@ -411,26 +1008,40 @@ func (c *connection) initNetFD(conn Conn) {
 	}
 }
 
-func (c *connection) initFDOperator() {
-	poll := pollmanager.Pick()
+func (c *connection) initFDOperator(opts *options) {
+	poll := pickPoll(opts, c.fd)
 	op := poll.Alloc()
 	op.FD = c.fd
 	op.OnRead, op.OnWrite, op.OnHup = nil, nil, c.onHup
 	op.Inputs, op.InputAck = c.inputs, c.inputAck
 	op.Outputs, op.OutputAck = c.outputs, c.outputAck
+	op.onErrQueue = c.onErrQueue
+	op.edgeTriggered = opts != nil && opts.epollMode == EdgeTriggered
+	if c.isUnix() {
+		op.onRights = c.onRights
+	}
 	c.operator = op
 }
 
 func (c *connection) initFinalizer() {
-	c.AddCloseCallback(func(connection Connection) (err error) {
+	c.closeCallbacks.add(func(connection Connection) (err error) {
 		c.stop(flushing)
+		if af := c.autoFlush.Swap(nil); af != nil {
+			af.stop()
+		}
+		c.heartbeat.stop()
+		if l := c.readLimit.Swap(nil); l != nil {
+			l.stop()
+		}
+		c.pacer.stop()
 		c.operator.Free()
-		if err = c.netFD.Close(); err != nil {
-			logger.Printf("NETPOLL: netFD close failed: %v", err)
+		if err = c.closeFD(); err != nil {
+			logger.Error("netFD close failed", "fd", c.fd, "err", err)
 		}
 		c.closeBuffer()
+		c.closeRights()
 		return nil
-	})
+	}, finalPriority)
 }
 
 func (c *connection) triggerRead(err error) {
@@ -441,6 +1052,13 @@ func (c *connection) triggerRead(err error) {
 }
 
 func (c *connection) triggerWrite(err error) {
+	// complete the FlushAsync instead of waking up Flush
+	if cb := c.flushCallback.Swap(nil); cb != nil {
+		c.unlock(flushing)
+		c.trace(TraceFlush, err)
+		(*cb)(err)
+		return
+	}
 	select {
 	case c.writeTrigger <- err:
 	default:
@@ -452,16 +1070,28 @@ func (c *connection) waitRead(n int) (err error) {
 	if n <= c.inputBuffer.Len() {
 		return nil
 	}
+	if atomic.LoadInt32(&c.readClosed) == 1 {
+		return Exception(ErrEOF, "read closed")
+	}
 	atomic.StoreInt64(&c.waitReadSize, int64(n))
 	defer atomic.StoreInt64(&c.waitReadSize, 0)
-	if dl := c.readDeadline; dl > 0 {
+	// reading more than the limit at once is allowed
+	c.resumeRead()
+	var done <-chan struct{}
+	if c.readCtx != nil {
+		done = c.readCtx.Done()
+	}
+	if nc := c.compat.Load(); nc != nil {
+		return c.waitReadCompat(nc, n, done)
+	}
+	if dl := atomic.LoadInt64(&c.readDeadline); dl > 0 {
 		timeout := time.Duration(dl - time.Now().UnixNano())
 		if timeout <= 0 {
-			return Exception(ErrReadTimeout, c.remoteAddr.String())
+			return Exception(ErrReadTimeout, c.peerString())
 		}
-		return c.waitReadWithTimeout(n, timeout)
+		return c.waitReadWithTimeout(n, timeout, done)
 	} else if c.readTimeout > 0 {
-		return c.waitReadWithTimeout(n, c.readTimeout)
+		return c.waitReadWithTimeout(n, c.readTimeout, done)
 	}
 	// wait full n
 	for c.inputBuffer.Len() < n {
@@ -469,19 +1099,27 @@ func (c *connection) waitRead(n int) (err error) {
 		case poller:
 			return Exception(ErrEOF, "wait read")
 		case user:
-			return Exception(ErrConnClosed, "wait read")
+			return c.closeState.closedError("wait read")
 		default:
-			err = <-c.readTrigger
-			if err != nil {
-				return err
+			select {
+			case err = <-c.readTrigger:
+				if err != nil {
+					return err
+				}
+			case <-done:
+				// double check if there is enough data to be read
+				if c.inputBuffer.Len() >= n {
+					return nil
+				}
+				return c.readCtx.Err()
 			}
 		}
 	}
 	return nil
 }
 
-// waitReadWithTimeout will wait full n bytes or until timeout.
-func (c *connection) waitReadWithTimeout(n int, timeout time.Duration) (err error) {
+// waitReadWithTimeout will wait full n bytes or until timeout, or until done is closed by the read context.
+func (c *connection) waitReadWithTimeout(n int, timeout time.Duration, done <-chan struct{}) (err error) {
 	if c.readTimer == nil {
 		c.readTimer = time.NewTimer(timeout)
 	} else {
@@ -496,7 +1134,7 @@ func (c *connection) waitReadWithTimeout(n int, timeout time.Duration) (err erro
 			goto RET
 		case user:
 			// cannot return directly, stop timer first!
-			err = Exception(ErrConnClosed, "wait read")
+			err = c.closeState.closedError("wait read")
 			goto RET
 		default:
 			select {
@@ -505,12 +1143,17 @@ func (c *connection) waitReadWithTimeout(n int, timeout time.Duration) (err erro
 				if c.inputBuffer.Len() >= n {
 					return nil
 				}
-				return Exception(ErrReadTimeout, c.remoteAddr.String())
+				return Exception(ErrReadTimeout, c.peerString())
 			case err = <-c.readTrigger:
 				if err != nil {
 					goto RET
 				}
 				continue
+			case <-done:
+				if c.inputBuffer.Len() < n {
+					err = c.readCtx.Err()
+				}
+				goto RET
 			}
 		}
 	}
@@ -524,26 +1167,11 @@ RET:
 
 // flush writes data directly.
 func (c *connection) flush() error {
-	if c.outputBuffer.IsEmpty() {
-		return nil
-	}
-	bs := c.outputBuffer.GetBytes(c.outputBarrier.bs)
-	n, err := sendmsg(c.fd, bs, c.outputBarrier.ivs, false)
-	if err != nil && err != syscall.EAGAIN {
-		return Exception(err, "when flush")
-	}
-	if n > 0 {
-		err = c.outputBuffer.Skip(n)
-		c.outputBuffer.Release()
-		if err != nil {
-			return Exception(err, "when flush")
-		}
-	}
 	// return if write all buffer.
-	if c.outputBuffer.IsEmpty() {
-		return nil
+	if done, err := c.send(); done || err != nil {
+		return err
 	}
-	err = c.operator.Control(PollR2RW)
+	err := c.operator.Control(PollR2RW)
 	if err != nil {
 		return Exception(err, "when flush")
 	}
@@ -551,12 +1179,46 @@ func (c *connection) flush() error {
 	return c.waitFlush()
 }
 
+// send writes the output buffer directly once, and returns done if all the data is sent.
+func (c *connection) send() (done bool, err error) {
+	if c.outputBuffer.IsEmpty() {
+		return true, nil
+	}
+	bs := c.outputBuffer.GetBytes(c.outputBarrier.bs)
+	// leave it to the poller if the write rate limit is exceeded
+	if bs, _ = c.paceWrite(bs); len(bs) == 0 {
+		return false, nil
+	}
+	zerocopy := c.useZeroCopy(bs)
+	n, err := sendmsg(c.fd, bs, c.outputBarrier.ivs, zerocopy)
+	if zerocopy && err == syscall.ENOBUFS {
+		// too many notifications of MSG_ZEROCOPY are pending, send it with copying
+		zerocopy = false
+		n, err = sendmsg(c.fd, bs, c.outputBarrier.ivs, false)
+	}
+	// EINPROGRESS means the handshake of TCP Fast Open is in progress, wait for writable like EAGAIN.
+	if err != nil && err != syscall.EAGAIN && err != syscall.EINPROGRESS {
+		return false, Exception(err, "when flush")
+	}
+	if n > 0 {
+		c.stats.write(n)
+		c.paceAck(n)
+		if err = c.releaseOutput(n, zerocopy); err != nil {
+			return false, Exception(err, "when flush")
+		}
+	}
+	return c.outputBuffer.IsEmpty(), nil
+}
+
 func (c *connection) waitFlush() (err error) {
+	if nc := c.compat.Load(); nc != nil {
+		return c.waitFlushCompat(nc)
+	}
 	timeout := c.writeTimeout
-	if dl := c.writeDeadline; dl > 0 {
+	if dl := atomic.LoadInt64(&c.writeDeadline); dl > 0 {
 		timeout = time.Duration(dl - time.Now().UnixNano())
 		if timeout <= 0 {
-			return Exception(ErrWriteTimeout, c.remoteAddr.String())
+			return Exception(ErrWriteTimeout, c.peerString())
 		}
 	}
 	if timeout == 0 {
@@ -586,10 +1248,62 @@ func (c *connection) waitFlush() (err error) {
 		// if timeout, remove write event from poller
 		// we cannot flush it again, since we don't if the poller is still process outputBuffer
 		c.operator.Control(PollRW2R)
-		return Exception(ErrWriteTimeout, c.remoteAddr.String())
+		return Exception(ErrWriteTimeout, c.peerString())
 	}
 }
 
+// checkOutputBuffer returns ErrWriteBufferFull if the output buffer will exceed the limit after n bytes written.
+func (c *connection) checkOutputBuffer(n int) error {
+	return checkBufferLimit(&c.maxOutputBuffer, c.outputBuffer.Len()+c.outputBuffer.MallocLen(), n)
+}
+
+// inputFull returns true if the input buffer reaches the limit or the memory budget is exceeded,
+// and no one waits for more data.
+func (c *connection) inputFull() bool {
+	length := int64(c.inputBuffer.Len())
+	if length == 0 || length < atomic.LoadInt64(&c.waitReadSize) {
+		return false
+	}
+	if limit := atomic.LoadInt64(&c.maxInputBuffer); limit > 0 && length >= limit {
+		return true
+	}
+	return c.budget != nil && c.budget.exceeded() && length >= c.budget.share()
+}
+
+// pauseRead stops the poller reading from the connection if the input buffer is full.
+func (c *connection) pauseRead() {
+	if !c.inputFull() {
+		return
+	}
+	c.readPauseMu.Lock()
+	defer c.readPauseMu.Unlock()
+	if atomic.LoadInt32(&c.readPaused) == 1 {
+		return
+	}
+	// mark paused before checking again, so that resumeRead after consuming the data never misses it.
+	atomic.StoreInt32(&c.readPaused, 1)
+	if !c.inputFull() {
+		atomic.StoreInt32(&c.readPaused, 0)
+		return
+	}
+	c.operator.Control(PollPauseRead)
+}
+
+// resumeRead restores reading from the connection if it's paused, the input buffer is not full anymore,
+// and it's not throttled by the read rate limit.
+func (c *connection) resumeRead() {
+	if atomic.LoadInt32(&c.readPaused) == 0 {
+		return
+	}
+	c.readPauseMu.Lock()
+	defer c.readPauseMu.Unlock()
+	if atomic.LoadInt32(&c.readPaused) == 0 || atomic.LoadInt32(&c.readClosed) == 1 || c.inputFull() || c.readThrottled() {
+		return
+	}
+	atomic.StoreInt32(&c.readPaused, 0)
+	c.operator.Control(PollResumeRead)
+}
+
 func (c *connection) getState() connState {
 	return atomic.LoadInt32(&c.state)
 }
@@ -601,3 +1315,25 @@ func (c *connection) setState(newState connState) {
 func (c *connection) changeState(from, to connState) bool {
 	return atomic.CompareAndSwapInt32(&c.state, from, to)
 }
+
+// info returns the ConnInfo of the connection, the poller is indexed in polls.
+func (c *connection) info(polls []Poll) ConnInfo {
+	info := ConnInfo{
+		FD:          c.fd,
+		LocalAddr:   c.LocalAddr(),
+		RemoteAddr:  c.RemoteAddr(),
+		InputBytes:  c.inputBuffer.Len(),
+		OutputBytes: c.outputBuffer.Len(),
+		IdleTime:    c.stats.idleTime(),
+		Poller:      -1,
+	}
+	if poll := c.operator.currentPoll(); poll != nil {
+		for i, p := range polls {
+			if p == poll {
+				info.Poller = i
+				break
+			}
+		}
+	}
+	return info
+}
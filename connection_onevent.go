@@ -18,9 +18,11 @@ package netpoll
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"runtime/debug"
 	"sync/atomic"
-
-	"github.com/cloudwego/netpoll/internal/runner"
+	"time"
 )
 
 // ------------------------------------ implement OnPrepare, OnRequest, CloseCallback ------------------------------------
@@ -38,12 +40,25 @@ type onEvent struct {
 	onConnectCallback    atomic.Value
 	onDisconnectCallback atomic.Value
 	onRequestCallback    atomic.Value
-	closeCallbacks       atomic.Value // value is latest *callbackNode
+	onShutdownCallback   func()
+	traceCallback        func(event TraceEvent, err error)
+	errorCallback        func(op ErrorOp, err error)
+	panicCallback        func(recovered interface{}, stack []byte)
+	strictPanic          bool // rethrow the panic recovered by panicCallback
+	firstByteTraced      bool
+	closeCallbacks       closeCallbacks
+	executor             Executor // runs OnConnect and OnRequest, the global runner is used if nil
 }
 
-type callbackNode struct {
-	fn  CloseCallback
-	pre *callbackNode
+// eventConnection is a Connection which accepts the event callbacks of EventLoop.
+type eventConnection interface {
+	Connection
+	BufferTuner
+	AutoFlusher
+	NetConnCompatSetter
+	SocketTuner
+	SetOnConnect(onConnect OnConnect) error
+	SetOnDisconnect(onDisconnect OnDisconnect) error
 }
 
 // SetOnConnect set the OnConnect callback.
@@ -69,7 +84,7 @@ func (c *connection) SetOnRequest(onRequest OnRequest) error {
 	}
 	c.onRequestCallback.Store(onRequest)
 	// fix: trigger OnRequest if there is already input data.
-	if !c.inputBuffer.IsEmpty() {
+	if c.readable() {
 		c.onRequest()
 	}
 	return nil
@@ -77,32 +92,107 @@ func (c *connection) SetOnRequest(onRequest OnRequest) error {
 
 // AddCloseCallback adds a CloseCallback to this connection.
 func (c *connection) AddCloseCallback(callback CloseCallback) error {
+	_, err := c.AddCloseCallbackWithPriority(callback, 0)
+	return err
+}
+
+// AddCloseCallbackWithPriority implements PriorityCloseCallbackAdder.
+func (c *connection) AddCloseCallbackWithPriority(callback CloseCallback, priority int) (*CloseCallbackHandle, error) {
 	if callback == nil {
-		return nil
+		return nil, nil
 	}
-	cb := &callbackNode{}
-	cb.fn = callback
-	if pre := c.closeCallbacks.Load(); pre != nil {
-		cb.pre = pre.(*callbackNode)
-	}
-	c.closeCallbacks.Store(cb)
-	return nil
+	return c.closeCallbacks.add(callback, priority)
 }
 
 // onPrepare supports close connection, but not read/write data.
 // connection will be registered by this call after preparing.
 func (c *connection) onPrepare(opts *options) (err error) {
 	if opts != nil {
-		c.SetOnConnect(opts.onConnect)
-		c.SetOnDisconnect(opts.onDisconnect)
-		c.SetOnRequest(opts.onRequest)
+		// callbacks will receive the TLS connection instead if TLS is enabled.
+		var conn eventConnection = c
+		if opts.tlsConfig != nil {
+			conn = newTLSConnection(c, tls.Server(&tlsTransport{connection: c}, opts.tlsConfig))
+		}
+		conn.SetOnConnect(opts.onConnect)
+		conn.SetOnDisconnect(opts.onDisconnect)
+		if opts.frameDecoder != nil && opts.onRequest != nil {
+			conn.SetOnRequest(DecodeFrames(opts.frameDecoder, opts.onRequest))
+		} else {
+			conn.SetOnRequest(opts.onRequest)
+		}
+		if onShutdown := opts.onShutdown; onShutdown != nil {
+			c.onShutdownCallback = func() {
+				onShutdown(c.ctx, conn)
+			}
+		}
+		if tracer := opts.tracer; tracer != nil {
+			c.traceCallback = func(event TraceEvent, err error) {
+				tracer.Trace(TraceInfo{Event: event, Time: time.Now(), Conn: conn, Err: err})
+			}
+			c.trace(TraceAccept, nil)
+			c.AddCloseCallback(func(Connection) error {
+				c.trace(TraceClose, nil)
+				return nil
+			})
+		}
+		if onError := opts.onError; onError != nil {
+			c.errorCallback = func(op ErrorOp, err error) {
+				onError(ErrorInfo{Op: op, Addr: c.LocalAddr(), FD: c.fd, Err: err})
+			}
+		}
+		if onPanic := opts.onPanic; onPanic != nil {
+			c.panicCallback = func(recovered interface{}, stack []byte) {
+				onPanic(conn, recovered, stack)
+			}
+			c.strictPanic = opts.strictPanic
+		}
+		if onClose := opts.onClose; onClose != nil {
+			c.AddCloseCallback(func(Connection) error {
+				ctx := c.ctx
+				if ctx == nil { // closed in OnPrepare
+					ctx = context.Background()
+				}
+				onClose(ctx, conn)
+				return nil
+			})
+		}
 		c.SetReadTimeout(opts.readTimeout)
 		c.SetWriteTimeout(opts.writeTimeout)
 		c.SetIdleTimeout(opts.idleTimeout)
+		if opts.onIdle != nil && opts.idleTimeout > 0 {
+			watchIdle(c.wheel(), conn, &c.stats, opts.idleTimeout, opts.onIdle)
+		}
+		if ka := opts.keepAlive; ka != nil {
+			c.SetTCPKeepAlive(ka.idle, ka.interval, ka.count)
+		}
+		if opts.bufferSize > 0 {
+			conn.SetMallocSize(opts.bufferSize)
+		}
+		if opts.maxInput > 0 {
+			conn.SetMaxInputBuffer(opts.maxInput)
+		}
+		if opts.maxOutput > 0 {
+			conn.SetMaxOutputBuffer(opts.maxOutput)
+		}
+		if opts.readWatermark > 0 {
+			conn.SetReadWatermark(opts.readWatermark)
+		}
+		if af := opts.autoFlush; af != nil {
+			conn.SetAutoFlush(af.threshold, af.interval)
+		}
+		if opts.netConnCompat {
+			conn.SetNetConnCompat(true)
+		}
+		if opts.zeroCopy > 0 {
+			conn.SetZeroCopy(opts.zeroCopy)
+		}
+		c.budget = opts.budget
+		c.egress = opts.egress
+		c.executor = opts.executor
 
 		// calling prepare first and then register.
 		if opts.onPrepare != nil {
-			c.ctx = opts.onPrepare(c)
+			c.ctx = opts.onPrepare(conn)
 		}
 	}
 
@@ -174,6 +264,48 @@ func (c *connection) onRequest() (needTrigger bool) {
 	return !processed
 }
 
+// onShutdown calls the OnShutdown callback with the processing lock, so that it will not be executed
+// concurrently with OnRequest. It returns false if the processing lock is held by others.
+func (c *connection) onShutdown() (called bool) {
+	if c.onShutdownCallback == nil {
+		return true
+	}
+	if !c.lock(processing) {
+		return false
+	}
+	c.onShutdownCallback()
+	c.unlock(processing)
+	// the poller may fail to get the processing lock during the callback, so help it to process.
+	if c.status(closing) != 0 && c.lock(processing) {
+		c.closeCallback(false, c.isCloseBy(user))
+	} else if c.readable() {
+		c.onRequest()
+	}
+	return true
+}
+
+// migrate moves the connection to poll with the processing lock, so that the operator will not be freed by
+// closing meanwhile. It returns ErrConcurrentAccess if the processing lock is held by others.
+func (c *connection) migrate(poll Poll) error {
+	if !c.lock(processing) {
+		return Exception(ErrConcurrentAccess, "when migrate")
+	}
+	var err error
+	if c.IsActive() && c.operator.poll != nil {
+		err = c.operator.migrate(poll)
+	} else {
+		err = Exception(ErrConnClosed, "when migrate")
+	}
+	c.unlock(processing)
+	// the poller may fail to get the processing lock during migrating, so help it to process.
+	if c.status(closing) != 0 && c.lock(processing) {
+		c.closeCallback(false, c.isCloseBy(user))
+	} else if c.readable() {
+		c.onRequest()
+	}
+	return err
+}
+
 // onProcess is responsible for executing the onConnect/onRequest function serially,
 // and make sure the connection has been closed correctly if user call c.Close() in onConnect/onRequest function.
 func (c *connection) onProcess(onConnect OnConnect, onRequest OnRequest) (processed bool) {
@@ -188,13 +320,22 @@ func (c *connection) onProcess(onConnect OnConnect, onRequest OnRequest) (proces
 			if !panicked {
 				return
 			}
-			// cannot use recover() here, since we don't want to break the panic stack
+			// only recover when OnPanic is set, otherwise we don't want to break the panic stack
+			var recovered interface{}
+			if c.panicCallback != nil {
+				recovered = recover()
+				c.panicCallback(recovered, debug.Stack())
+			}
+			c.reportError(ErrorOpPanic, errors.New("OnConnect or OnRequest panicked"))
 			c.unlock(processing)
 			if c.IsActive() {
 				c.Close()
 			} else {
 				c.closeCallback(false, false)
 			}
+			if c.panicCallback != nil && c.strictPanic {
+				panic(recovered)
+			}
 		}()
 		// trigger onConnect first
 		if onConnect != nil && c.changeState(connStateNone, connStateConnected) {
@@ -211,8 +352,8 @@ func (c *connection) onProcess(onConnect OnConnect, onRequest OnRequest) (proces
 	START:
 		// The `onRequest` must be executed at least once if conn have any readable data,
 		// which is in order to cover the `send & close by peer` case.
-		if onRequest != nil && c.Reader().Len() > 0 {
-			_ = onRequest(c.ctx, c)
+		if onRequest != nil && c.readable() {
+			c.callOnRequest(onRequest)
 		}
 		// The processing loop must ensure that the connection meets `IsActive`.
 		// `onRequest` must either eventually read all the input data or actively Close the connection,
@@ -221,10 +362,10 @@ func (c *connection) onProcess(onConnect OnConnect, onRequest OnRequest) (proces
 		for {
 			closedBy = c.status(closing)
 			// close by user or not processable
-			if closedBy == user || onRequest == nil || c.Reader().Len() == 0 {
+			if closedBy == user || onRequest == nil || !c.readable() {
 				break
 			}
-			_ = onRequest(c.ctx, c)
+			c.callOnRequest(onRequest)
 		}
 		// handling callback if connection has been closed.
 		if closedBy != none {
@@ -250,7 +391,7 @@ func (c *connection) onProcess(onConnect OnConnect, onRequest OnRequest) (proces
 			return
 		}
 		// double check is processable
-		if onRequest != nil && c.Reader().Len() > 0 && c.lock(processing) {
+		if onRequest != nil && c.readable() && c.lock(processing) {
 			goto START
 		}
 		// task exits
@@ -258,10 +399,35 @@ func (c *connection) onProcess(onConnect OnConnect, onRequest OnRequest) (proces
 	} // end of task closure func
 
 	// add new task
-	runner.RunTask(c.ctx, task)
+	runTask(c.ctx, c.executor, task)
 	return true
 }
 
+// callOnRequest calls onRequest and records the time spent.
+func (c *connection) callOnRequest(onRequest OnRequest) {
+	c.trace(TraceRequestStart, nil)
+	begin := statsNow()
+	err := onRequest(c.ctx, c)
+	c.stats.shard.request(begin)
+	c.trace(TraceRequestEnd, err)
+	// onRequest may consume the data without Release
+	c.resumeRead()
+}
+
+// reportError reports the error to OnError if it's set.
+func (c *connection) reportError(op ErrorOp, err error) {
+	if c.errorCallback != nil {
+		c.errorCallback(op, err)
+	}
+}
+
+// trace reports the event to the Tracer if it's set.
+func (c *connection) trace(event TraceEvent, err error) {
+	if c.traceCallback != nil {
+		c.traceCallback(event, err)
+	}
+}
+
 // closeCallback .
 // It can be confirmed that closeCallback and onRequest will not be executed concurrently.
 // If onRequest is still running, it will trigger closeCallback on exit.
@@ -272,16 +438,11 @@ func (c *connection) closeCallback(needLock, needDetach bool) (err error) {
 	if needDetach && c.operator.poll != nil { // If Close is called during OnPrepare, poll is not registered.
 		// PollDetach only happen when user call conn.Close() or poller detect error
 		if err := c.operator.Control(PollDetach); err != nil {
-			logger.Printf("NETPOLL: closeCallback[%v,%v] detach operator failed: %v", needLock, needDetach, err)
+			logger.Error("closeCallback detach operator failed", "needLock", needLock, "needDetach", needDetach, "err", err)
 		}
 	}
-	latest := c.closeCallbacks.Load()
-	if latest == nil {
-		return nil
-	}
-	for callback := latest.(*callbackNode); callback != nil; callback = callback.pre {
-		callback.fn(c)
-	}
+	runCloseCallbacks(c, c.closeCallbacks.take())
+	c.closeState.finish()
 	return nil
 }
 
@@ -289,7 +450,8 @@ func (c *connection) closeCallback(needLock, needDetach bool) (err error) {
 func (c *connection) register() (err error) {
 	err = c.operator.Control(PollReadable)
 	if err != nil {
-		logger.Printf("NETPOLL: connection register failed: %v", err)
+		logger.Error("connection register failed", "fd", c.fd, "err", err)
+		c.reportError(ErrorOpControl, err)
 		c.Close()
 		return Exception(ErrConnClosed, err.Error())
 	}
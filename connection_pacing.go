@@ -0,0 +1,114 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// writePacer resumes writing after the quota of the write rate limits is refilled.
+type writePacer struct {
+	mu      sync.Mutex
+	timer   *Timer
+	stopped bool
+}
+
+// SetWriteRateLimit implements RateLimiter.
+func (c *connection) SetWriteRateLimit(bytesPerSec, burst int) error {
+	var l *writeLimiter
+	if bytesPerSec > 0 {
+		l = newWriteLimiter(bytesPerSec, burst)
+	}
+	c.writeLimit.Store(l)
+	return nil
+}
+
+// paceWrite limits bs to the quota of the write rate limits, and it returns nil and the time to wait
+// if the quota runs out.
+func (c *connection) paceWrite(bs [][]byte) (rs [][]byte, wait time.Duration) {
+	own := c.writeLimit.Load()
+	if own == nil && c.egress == nil {
+		return bs, 0
+	}
+	now, quota := time.Now(), math.MaxInt
+	for _, l := range [...]*writeLimiter{own, c.egress} {
+		if l == nil {
+			continue
+		}
+		n, w := l.quota(now)
+		if n < quota {
+			quota = n
+		}
+		if w > wait {
+			wait = w
+		}
+	}
+	if quota == 0 {
+		return nil, wait
+	}
+	for i, b := range bs {
+		if len(b) >= quota {
+			bs[i] = b[:quota]
+			return bs[:i+1], 0
+		}
+		quota -= len(b)
+	}
+	return bs, 0
+}
+
+// paceAck takes the tokens of the write rate limits after n bytes are sent.
+func (c *connection) paceAck(n int) {
+	if l := c.writeLimit.Load(); l != nil {
+		l.take(n)
+	}
+	if c.egress != nil {
+		c.egress.take(n)
+	}
+}
+
+// pauseWrite stops the poller writing to the connection until the quota is refilled after wait.
+func (c *connection) pauseWrite(wait time.Duration) {
+	c.operator.Control(PollRW2R)
+	p := &c.pacer
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	resume := func() {
+		// the data is left if the flushing is timeout, which must not be sent by the poller anymore
+		if c.IsActive() && !c.isUnlock(flushing) && !c.outputBuffer.IsEmpty() {
+			c.operator.Control(PollR2RW)
+		}
+	}
+	if p.timer == nil {
+		p.timer = c.wheel().afterFunc(wait, 0, resume)
+		return
+	}
+	p.timer.reset(wait)
+}
+
+func (p *writePacer) stop() {
+	p.mu.Lock()
+	p.stopped = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.mu.Unlock()
+}
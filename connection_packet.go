@@ -0,0 +1,131 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// PacketConnection is a datagram-oriented connection driven by the pollers, such as UDP, unixgram, unixpacket and netlink sockets.
+// Each ReadPacket returns exactly one datagram, and each WritePacket sends exactly one datagram.
+type PacketConnection interface {
+	// PacketConnection extends net.PacketConn, just for interface compatibility.
+	net.PacketConn
+
+	// Fd return the fd of the packet connection, used by poll.
+	Fd() (fd int)
+
+	// IsActive checks whether the packet connection is active or not.
+	IsActive() bool
+
+	// ReadPacket returns the next datagram and the address it came from.
+	// The returned Reader holds the whole payload, and it will not be modified by later reads.
+	// It blocks until a datagram arrives, or returns an error after the timeout set by SetReadTimeout.
+	ReadPacket() (p Reader, addr net.Addr, err error)
+
+	// WritePacket sends all the readable data of p to addr as a single datagram.
+	// The data is sent with a scatter/gather syscall, so it is not copied.
+	WritePacket(p *LinkBuffer, addr net.Addr) (n int, err error)
+
+	// WritePackets sends all the readable data of each ps[i] to addrs[i] as a datagram, and returns the count of
+	// the datagrams sent. addrs must be nil for the connected sockets. The datagrams are sent by batches of
+	// sendmmsg on Linux, which saves the syscalls of the high-QPS servers.
+	WritePackets(ps []*LinkBuffer, addrs []net.Addr) (n int, err error)
+
+	// SendBatch sends all the readable data of p to addr as the datagrams of segmentSize bytes, the last one may be
+	// shorter, and returns the bytes sent. On Linux 4.18+, up to 64 datagrams are sent by each syscall with UDP GSO,
+	// which are segmented by the kernel or the device, otherwise they are sent one by one.
+	SendBatch(p *LinkBuffer, segmentSize int, addr net.Addr) (n int, err error)
+
+	// SetReadTimeout sets the timeout for future ReadPacket calls wait.
+	// A zero value for timeout means ReadPacket will not timeout.
+	SetReadTimeout(timeout time.Duration) error
+
+	// SetReadBatch reads up to n datagrams by each recvmmsg syscall, which saves the syscalls of the high-QPS
+	// servers, and costs n * 64KB of memory to receive. n <= 1 reads the datagrams one by one, which is the default.
+	// It's only supported on Linux, and returns ErrUnsupported on the other systems.
+	SetReadBatch(n int) error
+
+	// SetGRO enables UDP GRO, so that the datagrams of the same flow are coalesced by the kernel into one read,
+	// which are split again before delivered, e.g. the ones sent by SendBatch. It's only supported by UDP on
+	// Linux 5.0+, and returns ErrUnsupported otherwise.
+	SetGRO(enabled bool) error
+
+	// JoinGroup joins the multicast group on the interface ifi, or the one chosen by the system if ifi is nil.
+	// If source is not nil, only the datagrams sent by source are received, which is the source-specific multicast.
+	// It's only supported by UDP, and the source-specific multicast is only supported on Linux.
+	JoinGroup(ifi *net.Interface, group, source net.IP) error
+
+	// LeaveGroup leaves the multicast group joined by JoinGroup with the same ifi and source.
+	LeaveGroup(ifi *net.Interface, group, source net.IP) error
+
+	// SetMulticastInterface sets the interface of the outgoing multicast datagrams, nil lets the system choose.
+	SetMulticastInterface(ifi *net.Interface) error
+
+	// SetMulticastTTL sets the TTL, or the hop limit of IPv6, of the outgoing multicast datagrams, which is 1 by default.
+	SetMulticastTTL(ttl int) error
+
+	// SetMulticastLoopback sets whether the outgoing multicast datagrams are looped back to the local sockets,
+	// which is enabled by default.
+	SetMulticastLoopback(enabled bool) error
+
+	// SetOnPacket sets the OnPacket callback. Once it's set, all the datagrams will be
+	// delivered to OnPacket serially in a worker goroutine, and ReadPacket should not be used.
+	SetOnPacket(onPacket OnPacket) error
+}
+
+// NetlinkAddr represents the address of an AF_NETLINK socket, see ListenNetlink.
+// A zero Pid addresses the kernel, and Groups is the bitmask of the multicast groups.
+type NetlinkAddr struct {
+	Pid    uint32
+	Groups uint32
+}
+
+// Network returns the address's network name, "netlink".
+func (a *NetlinkAddr) Network() string {
+	return "netlink"
+}
+
+// String returns the address in the form of "pid:groups".
+func (a *NetlinkAddr) String() string {
+	if a == nil {
+		return "<nil>"
+	}
+	return strconv.FormatUint(uint64(a.Pid), 10) + ":" + strconv.FormatUint(uint64(a.Groups), 10)
+}
+
+// LinkAddr represents the link-layer address of an AF_PACKET socket, see ListenRawPacket.
+type LinkAddr struct {
+	Ifindex      int              // index of the network interface
+	Protocol     uint16           // ethernet protocol in host byte order, e.g. 0x0800 for IPv4
+	PacketType   uint8            // PACKET_HOST, PACKET_BROADCAST, PACKET_OUTGOING etc. of the frames received
+	HardwareAddr net.HardwareAddr // the source of the frames received, or the destination of the frames sent
+}
+
+// Network returns the address's network name, "packet".
+func (a *LinkAddr) Network() string {
+	return "packet"
+}
+
+// String returns the address in the form of "ifindex/protocol/hwaddr", e.g. "1/0x0800/00:00:00:00:00:00".
+func (a *LinkAddr) String() string {
+	if a == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("%d/%#04x/%s", a.Ifindex, a.Protocol, a.HardwareAddr)
+}
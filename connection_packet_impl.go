@@ -0,0 +1,653 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// maxPacketSize is the max size of a datagram that can be received.
+	maxPacketSize = 64 * 1024
+	// inputBlockPackets is the number of the datagrams of maxPacketSize held by a node of the input buffer.
+	inputBlockPackets = 4
+	// packetQueueCap limits the datagrams buffered by a PacketConnection,
+	// the datagrams will be dropped when the queue is full, just like the kernel does.
+	packetQueueCap = 1024
+)
+
+// the states of UDP GSO probed by SendBatch.
+const (
+	gsoUnknown int32 = iota
+	gsoSupported
+	gsoUnsupported
+)
+
+// ListenPacket announces on the local network address, the network must be "udp", "udp4", "udp6" or "unixgram".
+func ListenPacket(network, address string) (PacketConnection, error) {
+	pc, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ConvertPacketConn(pc)
+	// the fd has been duplicated
+	pc.Close()
+	return conn, err
+}
+
+// DialPacket connects to the address on the datagram-oriented network, the network must be "udp", "udp4", "udp6",
+// "unixgram" or "unixpacket". The message boundaries are kept, and WritePacket should be called with a nil addr
+// since the socket is connected.
+func DialPacket(network, address string) (PacketConnection, error) {
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram", "unixpacket":
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	pconn, err := ConvertPacketConn(conn.(net.PacketConn))
+	// the fd has been duplicated
+	conn.Close()
+	return pconn, err
+}
+
+// ConvertPacketConn converts net.PacketConn to PacketConnection and registers it into the poller.
+// The fd of pc is duplicated, so pc can be closed by the caller independently.
+func ConvertPacketConn(pc net.PacketConn) (PacketConnection, error) {
+	if conn, ok := pc.(PacketConnection); ok {
+		return conn, nil
+	}
+	var file *os.File
+	var err error
+	switch c := pc.(type) {
+	case *net.UDPConn:
+		file, err = c.File()
+	case *net.UnixConn:
+		file, err = c.File()
+	case *net.IPConn:
+		file, err = c.File()
+	default:
+		return nil, errors.New("packet conn type can't support")
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn := &packetConnection{
+		fd:        int(file.Fd()),
+		file:      file,
+		localAddr: pc.LocalAddr(),
+	}
+	if err = syscall.SetNonblock(conn.fd, true); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err = conn.init(nil); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+type packet struct {
+	buf  *LinkBuffer
+	addr net.Addr
+}
+
+// packetConnection is the implementation of PacketConnection.
+type packetConnection struct {
+	locker
+	fd            int
+	file          *os.File
+	localAddr     net.Addr
+	seqpacket     bool // SOCK_SEQPACKET is connection-oriented, and an empty read means the peer is closed
+	operator      *FDOperator
+	ctx           context.Context
+	onPacket      atomic.Value
+	executor      Executor
+	mux           sync.Mutex
+	queue         []packet
+	inputBuffer   *LinkBuffer                 // receives the datagrams by recvfrom, which are sliced out without copying
+	batch         atomic.Pointer[packetBatch] // see SetReadBatch and SetGRO, nil if disabled
+	batchSize     int                         // see SetReadBatch, guarded by mux
+	gro           bool                        // see SetGRO, guarded by mux
+	gso           int32                       // see SendBatch, one of gsoUnknown, gsoSupported and gsoUnsupported
+	readTimeout   time.Duration
+	readDeadline  int64 // UnixNano(). it overwrites readTimeout. 0 if not set.
+	readTrigger   chan error
+	writeDeadline int64 // UnixNano(). 0 if not set.
+	writeTrigger  chan error
+	closeOnce     sync.Once
+	closeCallback func() // called after closed, used by the server
+}
+
+var _ PacketConnection = &packetConnection{}
+
+func (c *packetConnection) init(opts *options) error {
+	c.ctx = context.Background()
+	c.inputBuffer = NewLinkBuffer()
+	c.inputBuffer.setBlockSize(inputBlockPackets * maxPacketSize)
+	c.readTrigger = make(chan error, 1)
+	c.writeTrigger = make(chan error, 1)
+	if typ, err := unix.GetsockoptInt(c.fd, unix.SOL_SOCKET, unix.SO_TYPE); err == nil {
+		c.seqpacket = typ == unix.SOCK_SEQPACKET
+	}
+	poll := pickPoll(opts, c.fd)
+	c.operator = poll.Alloc()
+	c.operator.FD = c.fd
+	c.operator.OnRead, c.operator.OnWrite, c.operator.OnHup = c.onRead, c.onWrite, c.onHup
+	if err := c.operator.Control(PollReadable); err != nil {
+		logger.Error("packet connection register failed", "fd", c.fd, "err", err)
+		c.Close()
+		return Exception(ErrConnClosed, err.Error())
+	}
+	return nil
+}
+
+// Fd implements PacketConnection.
+func (c *packetConnection) Fd() (fd int) {
+	return c.fd
+}
+
+// IsActive implements PacketConnection.
+func (c *packetConnection) IsActive() bool {
+	return c.isCloseBy(none)
+}
+
+// LocalAddr implements net.PacketConn.
+func (c *packetConnection) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+// SetReadTimeout implements PacketConnection.
+func (c *packetConnection) SetReadTimeout(timeout time.Duration) error {
+	if timeout >= 0 {
+		c.readTimeout = timeout
+	}
+	c.readDeadline = 0
+	return nil
+}
+
+// SetDeadline implements net.PacketConn.
+func (c *packetConnection) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline implements net.PacketConn.
+func (c *packetConnection) SetReadDeadline(t time.Time) error {
+	if t.IsZero() {
+		c.readDeadline = 0
+	} else {
+		c.readDeadline = t.UnixNano()
+	}
+	return nil
+}
+
+// SetWriteDeadline implements net.PacketConn.
+func (c *packetConnection) SetWriteDeadline(t time.Time) error {
+	if t.IsZero() {
+		c.writeDeadline = 0
+	} else {
+		c.writeDeadline = t.UnixNano()
+	}
+	return nil
+}
+
+// SetOnPacket implements PacketConnection.
+func (c *packetConnection) SetOnPacket(onPacket OnPacket) error {
+	if onPacket == nil {
+		return nil
+	}
+	c.onPacket.Store(onPacket)
+	c.onProcess()
+	return nil
+}
+
+// ReadPacket implements PacketConnection.
+func (c *packetConnection) ReadPacket() (p Reader, addr net.Addr, err error) {
+	pkt, err := c.waitPacket()
+	if err != nil {
+		return nil, nil, err
+	}
+	return pkt.buf, pkt.addr, nil
+}
+
+// ReadFrom implements net.PacketConn.
+func (c *packetConnection) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	pkt, err := c.waitPacket()
+	if err != nil {
+		return 0, nil, err
+	}
+	// the rest of datagram will be discarded like recvfrom does.
+	n = pkt.buf.readCopy(p)
+	pkt.buf.Close()
+	return n, pkt.addr, nil
+}
+
+// WritePacket implements PacketConnection.
+func (c *packetConnection) WritePacket(p *LinkBuffer, addr net.Addr) (n int, err error) {
+	if !c.IsActive() {
+		return 0, Exception(ErrConnClosed, "when write packet")
+	}
+	sa, err := addrToSockaddr(addr)
+	if err != nil {
+		return 0, err
+	}
+	bs := p.GetBytes(make([][]byte, 0, barriercap))
+	n, err = c.sendmsg(bs, nil, sa)
+	if err != nil {
+		return n, err
+	}
+	return n, p.Skip(n)
+}
+
+// WritePackets implements PacketConnection.
+func (c *packetConnection) WritePackets(ps []*LinkBuffer, addrs []net.Addr) (n int, err error) {
+	if !c.IsActive() {
+		return 0, Exception(ErrConnClosed, "when write packets")
+	}
+	if addrs != nil && len(addrs) != len(ps) {
+		return 0, errors.New("addrs must be nil or as many as the packets")
+	}
+	if len(ps) == 0 {
+		return 0, nil
+	}
+	return c.writePackets(ps, addrs)
+}
+
+// SendBatch implements PacketConnection.
+func (c *packetConnection) SendBatch(p *LinkBuffer, segmentSize int, addr net.Addr) (n int, err error) {
+	if !c.IsActive() {
+		return 0, Exception(ErrConnClosed, "when send batch")
+	}
+	if segmentSize <= 0 {
+		return 0, errors.New("segment size must be positive")
+	}
+	sa, err := addrToSockaddr(addr)
+	if err != nil {
+		return 0, err
+	}
+	for p.Len() > 0 {
+		m, err := c.sendSegments(p, segmentSize, sa)
+		if err != nil {
+			return n, err
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// sendSegment sends the first segmentSize bytes of p as a datagram.
+func (c *packetConnection) sendSegment(p *LinkBuffer, segmentSize int, sa unix.Sockaddr) (n int, err error) {
+	n, err = c.sendmsg(headBytes(p.GetBytes(nil), segmentSize), nil, sa)
+	if err != nil {
+		return 0, err
+	}
+	return n, p.Skip(n)
+}
+
+// headBytes truncates bs to the first n bytes.
+func headBytes(bs [][]byte, n int) [][]byte {
+	for i := range bs {
+		if len(bs[i]) >= n {
+			bs[i] = bs[i][:n]
+			return bs[:i+1]
+		}
+		n -= len(bs[i])
+	}
+	return bs
+}
+
+// WriteTo implements net.PacketConn.
+func (c *packetConnection) WriteTo(p []byte, addr net.Addr) (n int, err error) {
+	if !c.IsActive() {
+		return 0, Exception(ErrConnClosed, "when write to")
+	}
+	sa, err := addrToSockaddr(addr)
+	if err != nil {
+		return 0, err
+	}
+	return c.sendmsg([][]byte{p}, nil, sa)
+}
+
+// Close implements net.PacketConn.
+func (c *packetConnection) Close() error {
+	if !c.closeBy(user) {
+		return nil
+	}
+	c.close()
+	return nil
+}
+
+func (c *packetConnection) close() {
+	c.closeOnce.Do(func() {
+		c.triggerRead(Exception(ErrConnClosed, "self close"))
+		c.triggerWrite(Exception(ErrConnClosed, "self close"))
+		if c.operator.poll != nil {
+			if err := c.operator.Control(PollDetach); err != nil {
+				logger.Error("packet connection detach operator failed", "fd", c.fd, "err", err)
+			}
+		}
+		c.operator.Free()
+		if c.file != nil {
+			c.file.Close()
+		} else {
+			syscall.Close(c.fd)
+		}
+		if c.closeCallback != nil {
+			c.closeCallback()
+		}
+	})
+}
+
+// isIdle implements gracefulExit.
+func (c *packetConnection) isIdle() (yes bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.isUnlock(processing) && len(c.queue) == 0
+}
+
+// closeGracefully waits for all the received datagrams processed, and then closes the packet connection.
+func (c *packetConnection) closeGracefully(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for !c.isIdle() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return c.Close()
+}
+
+// sendmsg sends bs as one datagram with the control message oob, and waits for writable
+// if the socket send buffer is full.
+func (c *packetConnection) sendmsg(bs [][]byte, oob []byte, sa unix.Sockaddr) (n int, err error) {
+	for {
+		n, err = unix.SendmsgBuffers(c.fd, bs, oob, sa, 0)
+		if err != syscall.EAGAIN {
+			if err != nil {
+				return n, Exception(err, "when write packet")
+			}
+			return n, nil
+		}
+		if err = c.waitWrite(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (c *packetConnection) waitWrite() (err error) {
+	var timer <-chan time.Time
+	if dl := c.writeDeadline; dl > 0 {
+		timeout := time.Duration(dl - time.Now().UnixNano())
+		if timeout <= 0 {
+			return Exception(ErrWriteTimeout, "when write packet")
+		}
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	if err = c.operator.Control(PollR2RW); err != nil {
+		return Exception(err, "when write packet")
+	}
+	select {
+	case err = <-c.writeTrigger:
+		return err
+	case <-timer:
+		c.operator.Control(PollRW2R)
+		return Exception(ErrWriteTimeout, "when write packet")
+	}
+}
+
+// waitPacket pops a datagram from the queue, or waits until timeout.
+func (c *packetConnection) waitPacket() (pkt packet, err error) {
+	var timer <-chan time.Time
+	timeout := c.readTimeout
+	if dl := c.readDeadline; dl > 0 {
+		timeout = time.Duration(dl - time.Now().UnixNano())
+		if timeout <= 0 {
+			return pkt, Exception(ErrReadTimeout, "when read packet")
+		}
+	}
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	for {
+		if pkt, ok := c.pop(); ok {
+			return pkt, nil
+		}
+		if !c.IsActive() {
+			return pkt, Exception(ErrConnClosed, "when read packet")
+		}
+		select {
+		case err = <-c.readTrigger:
+			if err != nil {
+				return pkt, err
+			}
+		case <-timer:
+			return pkt, Exception(ErrReadTimeout, "when read packet")
+		}
+	}
+}
+
+func (c *packetConnection) push(pkt packet) (ok bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if len(c.queue) >= packetQueueCap {
+		return false
+	}
+	c.queue = append(c.queue, pkt)
+	return true
+}
+
+func (c *packetConnection) pop() (pkt packet, ok bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if len(c.queue) == 0 {
+		return pkt, false
+	}
+	pkt = c.queue[0]
+	c.queue[0] = packet{}
+	c.queue = c.queue[1:]
+	return pkt, true
+}
+
+func (c *packetConnection) triggerRead(err error) {
+	select {
+	case c.readTrigger <- err:
+	default:
+	}
+}
+
+func (c *packetConnection) triggerWrite(err error) {
+	select {
+	case c.writeTrigger <- err:
+	default:
+	}
+}
+
+// onRead implements FDOperator, it reads all the datagrams in the socket receive buffer.
+func (c *packetConnection) onRead(p Poll) error {
+	var received bool
+	if b := c.batch.Load(); b != nil {
+		received = c.readBatch(b)
+	} else {
+		received = c.readEach()
+	}
+	if received {
+		if !c.onProcess() {
+			c.triggerRead(nil)
+		}
+	}
+	return nil
+}
+
+// readEach reads the datagrams by recvfrom one by one. Each datagram is received into the input buffer directly,
+// and its node is shared by the following datagrams until there is no room for a datagram of maxPacketSize.
+func (c *packetConnection) readEach() (received bool) {
+	for i := 0; i < maxReadCycle; i++ {
+		p := c.inputBuffer.bookFull(maxPacketSize)
+		n, sa, err := unix.Recvfrom(c.fd, p, 0)
+		if err != nil {
+			c.inputBuffer.bookAck(0)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != syscall.EAGAIN {
+				logger.Error("packet connection recvfrom failed", "fd", c.fd, "err", err)
+			}
+			break
+		}
+		c.inputBuffer.bookAck(n)
+		if n == 0 && c.seqpacket {
+			// EOF, the connection will be closed by onHup
+			break
+		}
+		buf := c.inputBuffer.sliceNode(n)
+		if !c.push(packet{buf: buf, addr: sockaddrToPacketAddr(sa)}) {
+			buf.Close()
+			continue
+		}
+		received = true
+	}
+	return received
+}
+
+// enqueue copies the datagram received by recvmmsg into the queue, it's dropped if the queue is full.
+func (c *packetConnection) enqueue(data []byte, addr net.Addr) (ok bool) {
+	buf := NewLinkBuffer(len(data))
+	p, _ := buf.Malloc(len(data))
+	copy(p, data)
+	buf.Flush()
+	if !c.push(packet{buf: buf, addr: addr}) {
+		buf.Close()
+		return false
+	}
+	return true
+}
+
+// onWrite implements FDOperator.
+func (c *packetConnection) onWrite(p Poll) error {
+	c.operator.Control(PollRW2R)
+	c.triggerWrite(nil)
+	return nil
+}
+
+// onHup implements FDOperator.
+func (c *packetConnection) onHup(p Poll) error {
+	if !c.closeBy(poller) {
+		return nil
+	}
+	c.close()
+	return nil
+}
+
+// onProcess delivers the queued datagrams to OnPacket serially.
+func (c *packetConnection) onProcess() (processed bool) {
+	onPacket, _ := c.onPacket.Load().(OnPacket)
+	if onPacket == nil {
+		return false
+	}
+	if !c.lock(processing) {
+		return true
+	}
+	task := func() {
+		panicked := true
+		defer func() {
+			if panicked {
+				c.unlock(processing)
+			}
+		}()
+	START:
+		for pkt, ok := c.pop(); ok; pkt, ok = c.pop() {
+			_ = onPacket(c.ctx, c, pkt.buf, pkt.addr)
+			pkt.buf.Close()
+		}
+		c.unlock(processing)
+		// double check is processable
+		if !c.isIdle() && c.lock(processing) {
+			goto START
+		}
+		panicked = false
+	}
+	runTask(c.ctx, c.executor, task)
+	return true
+}
+
+// sockaddrToPacketAddr returns a go/net friendly address of datagram sockets.
+func sockaddrToPacketAddr(sa unix.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return &net.UDPAddr{IP: append(net.IP{}, sa.Addr[:]...), Port: sa.Port}
+	case *unix.SockaddrInet6:
+		var zone string
+		if sa.ZoneId != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa.ZoneId)); err == nil {
+				zone = ifi.Name
+			}
+		}
+		return &net.UDPAddr{IP: append(net.IP{}, sa.Addr[:]...), Port: sa.Port, Zone: zone}
+	case *unix.SockaddrUnix:
+		return &net.UnixAddr{Net: "unixgram", Name: sa.Name}
+	}
+	return sysToPacketAddr(sa)
+}
+
+// addrToSockaddr converts the destination address of datagram to unix.Sockaddr.
+func addrToSockaddr(addr net.Addr) (unix.Sockaddr, error) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		if ip4 := a.IP.To4(); ip4 != nil {
+			sa := &unix.SockaddrInet4{Port: a.Port}
+			copy(sa.Addr[:], ip4)
+			return sa, nil
+		}
+		sa := &unix.SockaddrInet6{Port: a.Port}
+		copy(sa.Addr[:], a.IP.To16())
+		if a.Zone != "" {
+			if ifi, err := net.InterfaceByName(a.Zone); err == nil {
+				sa.ZoneId = uint32(ifi.Index)
+			}
+		}
+		return sa, nil
+	case *net.UnixAddr:
+		return &unix.SockaddrUnix{Name: a.Name}, nil
+	case *NetlinkAddr:
+		return netlinkToSockaddr(a)
+	case *LinkAddr:
+		return linkToSockaddr(a)
+	case nil:
+		// for the connected sockets
+		return nil, nil
+	}
+	return nil, &net.AddrError{Err: "unsupported address type", Addr: addr.String()}
+}
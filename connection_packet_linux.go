@@ -0,0 +1,378 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// maxPacketBatch limits the datagrams sent or received by one syscall, which is UIO_MAXIOV of the kernel.
+	maxPacketBatch = 1024
+	// maxGSOSegments limits the datagrams segmented by UDP GSO, which is UDP_MAX_SEGMENTS of the kernel.
+	maxGSOSegments = 64
+	// maxUDPPayload is the max payload of a UDP datagram over IPv4, which limits the data segmented by UDP GSO.
+	maxUDPPayload = 65507
+)
+
+// groOOBSize is the size of the control message of UDP_GRO, which carries the segment size as an int.
+var groOOBSize = unix.CmsgSpace(4)
+
+// packetBatch holds the buffers to receive the datagrams by recvmmsg, see PacketConnection.SetReadBatch.
+type packetBatch struct {
+	msgs  []mmsghdr
+	iovs  []syscall.Iovec
+	names []syscall.RawSockaddrAny
+	oobs  []byte
+	buf   []byte
+}
+
+func newPacketBatch(n int) *packetBatch {
+	b := &packetBatch{
+		msgs:  make([]mmsghdr, n),
+		iovs:  make([]syscall.Iovec, n),
+		names: make([]syscall.RawSockaddrAny, n),
+		oobs:  make([]byte, n*groOOBSize),
+		buf:   make([]byte, n*maxPacketSize),
+	}
+	for i := range b.msgs {
+		b.iovs[i].Base = &b.buf[i*maxPacketSize]
+		b.iovs[i].SetLen(maxPacketSize)
+		b.msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+		b.msgs[i].hdr.Iov = &b.iovs[i]
+		b.msgs[i].hdr.Iovlen = 1
+		b.msgs[i].hdr.Control = &b.oobs[i*groOOBSize]
+	}
+	return b
+}
+
+// reset makes the buffers ready for the next recvmmsg.
+func (b *packetBatch) reset() {
+	for i := range b.msgs {
+		b.msgs[i].hdr.Namelen = syscall.SizeofSockaddrAny
+		b.msgs[i].hdr.SetControllen(groOOBSize)
+	}
+}
+
+// packet returns the payload and the source address of the i-th datagram received, and the segment size
+// of the datagrams coalesced by UDP GRO, 0 if it's not coalesced.
+func (b *packetBatch) packet(i int) (data []byte, addr net.Addr, segmentSize int) {
+	m := &b.msgs[i]
+	data = b.buf[i*maxPacketSize : i*maxPacketSize+int(m.len)]
+	if m.hdr.Controllen > 0 {
+		oob := b.oobs[i*groOOBSize : i*groOOBSize+int(m.hdr.Controllen)]
+		if msgs, err := unix.ParseSocketControlMessage(oob); err == nil {
+			for _, msg := range msgs {
+				if msg.Header.Level == unix.SOL_UDP && msg.Header.Type == unix.UDP_GRO && len(msg.Data) >= 4 {
+					segmentSize = int(*(*int32)(unsafe.Pointer(&msg.Data[0])))
+				}
+			}
+		}
+	}
+	return data, rawToPacketAddr(&b.names[i], m.hdr.Namelen), segmentSize
+}
+
+// ListenNetlink opens an AF_NETLINK socket of protocol, e.g. unix.NETLINK_ROUTE or unix.NETLINK_KOBJECT_UEVENT,
+// subscribed to the multicast groups, and registers it into the poller as a PacketConnection. Each ReadPacket
+// returns one datagram which may hold several netlink messages, and the requests are sent to the kernel by
+// WritePacket with a nil addr or a zero NetlinkAddr.
+func ListenNetlink(protocol int, groups uint32) (PacketConnection, error) {
+	fd, err := sysSocket(unix.AF_NETLINK, unix.SOCK_RAW, protocol)
+	if err != nil {
+		return nil, err
+	}
+	if err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: groups}); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	conn := &packetConnection{fd: fd, localAddr: &NetlinkAddr{Groups: groups}}
+	// the port id is assigned by the kernel
+	if sa, err := unix.Getsockname(fd); err == nil {
+		if addr := sysToPacketAddr(sa); addr != nil {
+			conn.localAddr = addr
+		}
+	}
+	if err = conn.init(nil); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ListenRawPacket opens an AF_PACKET socket receiving the frames of protocol, e.g. unix.ETH_P_ALL or unix.ETH_P_IP,
+// on the network interface ifname, or on all the interfaces if ifname is empty, and registers it into the poller
+// as a PacketConnection. Each ReadPacket returns one frame including the link-layer header with its LinkAddr,
+// and WritePacket injects a frame to the interface bound, or to the interface of a non-nil LinkAddr.
+// It needs the CAP_NET_RAW capability.
+func ListenRawPacket(ifname string, protocol uint16) (PacketConnection, error) {
+	var ifindex int
+	if ifname != "" {
+		ifi, err := net.InterfaceByName(ifname)
+		if err != nil {
+			return nil, err
+		}
+		ifindex = ifi.Index
+	}
+	fd, err := sysSocket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(protocol)))
+	if err != nil {
+		return nil, err
+	}
+	if err = unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(protocol), Ifindex: ifindex}); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	conn := &packetConnection{fd: fd, localAddr: &LinkAddr{Ifindex: ifindex, Protocol: protocol}}
+	if sa, err := unix.Getsockname(fd); err == nil {
+		if addr := sysToPacketAddr(sa); addr != nil {
+			conn.localAddr = addr
+		}
+	}
+	if err = conn.init(nil); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// sysToPacketAddr returns the NetlinkAddr or LinkAddr of sa, nil if it's not an AF_NETLINK or AF_PACKET address.
+func sysToPacketAddr(sa unix.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *unix.SockaddrNetlink:
+		return &NetlinkAddr{Pid: sa.Pid, Groups: sa.Groups}
+	case *unix.SockaddrLinklayer:
+		halen := int(sa.Halen)
+		if halen > len(sa.Addr) {
+			halen = len(sa.Addr)
+		}
+		return &LinkAddr{
+			Ifindex:      sa.Ifindex,
+			Protocol:     ntohs(sa.Protocol),
+			PacketType:   sa.Pkttype,
+			HardwareAddr: append(net.HardwareAddr{}, sa.Addr[:halen]...),
+		}
+	}
+	return nil
+}
+
+// linkToSockaddr converts the destination address of AF_PACKET to unix.Sockaddr.
+func linkToSockaddr(a *LinkAddr) (unix.Sockaddr, error) {
+	sa := &unix.SockaddrLinklayer{Protocol: htons(a.Protocol), Ifindex: a.Ifindex}
+	if len(a.HardwareAddr) > len(sa.Addr) {
+		return nil, &net.AddrError{Err: "hardware address too long", Addr: a.HardwareAddr.String()}
+	}
+	sa.Halen = uint8(copy(sa.Addr[:], a.HardwareAddr))
+	return sa, nil
+}
+
+// htons converts v from host to network byte order.
+func htons(v uint16) (n uint16) {
+	putPort(&n, int(v))
+	return n
+}
+
+// ntohs converts v from network to host byte order.
+func ntohs(v uint16) uint16 {
+	return uint16(getPort(&v))
+}
+
+// netlinkToSockaddr converts the destination address of netlink to unix.Sockaddr.
+func netlinkToSockaddr(a *NetlinkAddr) (unix.Sockaddr, error) {
+	return &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Pid: a.Pid, Groups: a.Groups}, nil
+}
+
+// SetReadBatch implements PacketConnection.
+func (c *packetConnection) SetReadBatch(n int) error {
+	if n > maxPacketBatch {
+		n = maxPacketBatch
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.batchSize = n
+	c.resetBatch()
+	return nil
+}
+
+// SetGRO implements PacketConnection.
+func (c *packetConnection) SetGRO(enabled bool) error {
+	if _, ok := c.localAddr.(*net.UDPAddr); !ok {
+		return Exception(ErrUnsupported, "UDP_GRO on "+c.localAddr.Network())
+	}
+	if err := unix.SetsockoptInt(c.fd, unix.SOL_UDP, unix.UDP_GRO, boolint(enabled)); err != nil {
+		if err == unix.ENOPROTOOPT {
+			return Exception(ErrUnsupported, "UDP_GRO")
+		}
+		return Exception(err, "when set UDP_GRO")
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.gro = enabled
+	c.resetBatch()
+	return nil
+}
+
+// resetBatch allocates the buffers for recvmmsg if SetReadBatch or SetGRO is enabled, it must be called with mux held.
+// The datagrams coalesced by UDP GRO are received with the control message, so they are read by recvmmsg as well.
+func (c *packetConnection) resetBatch() {
+	n := c.batchSize
+	if n <= 1 {
+		n = 0
+		if c.gro {
+			n = 1
+		}
+	}
+	var b *packetBatch
+	if n > 0 {
+		b = newPacketBatch(n)
+	}
+	c.batch.Store(b)
+}
+
+// readBatch reads all the datagrams in the socket receive buffer by recvmmsg.
+func (c *packetConnection) readBatch(b *packetBatch) (received bool) {
+	for i := 0; i < maxReadCycle; i++ {
+		b.reset()
+		n, err := recvmmsg(c.fd, b.msgs)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != syscall.EAGAIN {
+				logger.Error("packet connection recvmmsg failed", "fd", c.fd, "err", err)
+			}
+			break
+		}
+		for j := 0; j < n; j++ {
+			data, addr, segmentSize := b.packet(j)
+			if len(data) == 0 && c.seqpacket {
+				// EOF, the connection will be closed by onHup
+				return received
+			}
+			// split the datagrams coalesced by UDP GRO
+			for segmentSize > 0 && len(data) > segmentSize {
+				received = c.enqueue(data[:segmentSize], addr) || received
+				data = data[segmentSize:]
+			}
+			received = c.enqueue(data, addr) || received
+		}
+		if n < len(b.msgs) {
+			// the socket receive buffer is drained
+			break
+		}
+	}
+	return received
+}
+
+// writePackets sends the datagrams by sendmmsg, and waits for writable if the socket send buffer is full.
+func (c *packetConnection) writePackets(ps []*LinkBuffer, addrs []net.Addr) (n int, err error) {
+	msgs := make([]mmsghdr, len(ps))
+	names := make([]syscall.RawSockaddrAny, len(ps))
+	for i, p := range ps {
+		if addrs != nil {
+			if msgs[i].hdr.Namelen, err = putSockaddr(addrs[i], &names[i]); err != nil {
+				return 0, err
+			}
+			if msgs[i].hdr.Namelen > 0 {
+				msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&names[i]))
+			}
+		}
+		bs := p.GetBytes(nil)
+		ivs := make([]syscall.Iovec, len(bs))
+		if iovLen := iovecs(bs, ivs); iovLen > 0 {
+			msgs[i].hdr.Iov = &ivs[0]
+			msgs[i].hdr.Iovlen = uint64(iovLen)
+		}
+	}
+	for n < len(ps) {
+		end := n + maxPacketBatch
+		if end > len(ps) {
+			end = len(ps)
+		}
+		m, err := sendmmsg(c.fd, msgs[n:end])
+		switch err {
+		case nil:
+			for i := n; i < n+m; i++ {
+				ps[i].Skip(int(msgs[i].len))
+			}
+			n += m
+		case syscall.EINTR:
+		case syscall.EAGAIN:
+			if err = c.waitWrite(); err != nil {
+				return n, err
+			}
+		default:
+			return n, Exception(err, "when write packets")
+		}
+	}
+	return n, nil
+}
+
+// sendSegments sends the datagrams of segmentSize bytes in the head of p by one sendmsg with UDP_SEGMENT,
+// so that they are segmented by the kernel or the device. It sends only one datagram if UDP GSO is unavailable.
+func (c *packetConnection) sendSegments(p *LinkBuffer, segmentSize int, sa unix.Sockaddr) (n int, err error) {
+	size := maxUDPPayload / segmentSize
+	if size > maxGSOSegments {
+		size = maxGSOSegments
+	}
+	size *= segmentSize
+	if l := p.Len(); l < size {
+		size = l
+	}
+	if size <= segmentSize || !c.supportGSO() {
+		return c.sendSegment(p, segmentSize, sa)
+	}
+	oob := make([]byte, unix.CmsgSpace(2))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level, h.Type = unix.SOL_UDP, unix.UDP_SEGMENT
+	h.SetLen(unix.CmsgLen(2))
+	*(*uint16)(unsafe.Pointer(&oob[unix.CmsgLen(0)])) = uint16(segmentSize)
+	n, err = c.sendmsg(headBytes(p.GetBytes(nil), size), oob, sa)
+	if err != nil {
+		switch {
+		case errors.Is(err, syscall.EIO):
+			// the device can't checksum the segments, never try again
+			atomic.StoreInt32(&c.gso, gsoUnsupported)
+			return c.sendSegment(p, segmentSize, sa)
+		case errors.Is(err, syscall.EINVAL):
+			// the segment size exceeds the MTU
+			return c.sendSegment(p, segmentSize, sa)
+		}
+		return 0, err
+	}
+	return n, p.Skip(n)
+}
+
+// supportGSO reports whether the socket supports UDP GSO, the kernels before 4.18 ignore UDP_SEGMENT,
+// so it's probed by getsockopt once.
+func (c *packetConnection) supportGSO() bool {
+	switch atomic.LoadInt32(&c.gso) {
+	case gsoSupported:
+		return true
+	case gsoUnsupported:
+		return false
+	}
+	gso := gsoUnsupported
+	if _, ok := c.localAddr.(*net.UDPAddr); ok {
+		if _, err := unix.GetsockoptInt(c.fd, unix.SOL_UDP, unix.UDP_SEGMENT); err == nil {
+			gso = gsoSupported
+		}
+	}
+	atomic.StoreInt32(&c.gso, gso)
+	return gso == gsoSupported
+}
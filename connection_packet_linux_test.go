@@ -0,0 +1,175 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package netpoll
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestNetlinkConnection(t *testing.T) {
+	for _, batch := range []int{0, 4} {
+		conn, err := ListenNetlink(unix.NETLINK_ROUTE, 0)
+		MustNil(t, err)
+		Equal(t, conn.LocalAddr().Network(), "netlink")
+		Assert(t, conn.LocalAddr().(*NetlinkAddr).Pid != 0)
+		if batch > 0 {
+			MustNil(t, conn.SetReadBatch(batch))
+		}
+
+		// dump the links, which contain the loopback at least
+		req := struct {
+			hdr unix.NlMsghdr
+			ifi unix.IfInfomsg
+		}{}
+		req.hdr.Len = uint32(unsafe.Sizeof(req))
+		req.hdr.Type = unix.RTM_GETLINK
+		req.hdr.Flags = unix.NLM_F_REQUEST | unix.NLM_F_DUMP
+		req.hdr.Seq = 1
+		req.ifi.Family = unix.AF_UNSPEC
+		_, err = conn.WriteTo(unsafe.Slice((*byte)(unsafe.Pointer(&req)), unsafe.Sizeof(req)), &NetlinkAddr{})
+		MustNil(t, err)
+
+		var links int
+	READ:
+		for {
+			p, addr, err := conn.ReadPacket()
+			MustNil(t, err)
+			Equal(t, addr.(*NetlinkAddr).Pid, uint32(0))
+			buf, err := p.Next(p.Len())
+			MustNil(t, err)
+			for len(buf) >= unix.NLMSG_HDRLEN {
+				hdr := (*unix.NlMsghdr)(unsafe.Pointer(&buf[0]))
+				Equal(t, hdr.Seq, uint32(1))
+				switch hdr.Type {
+				case unix.RTM_NEWLINK:
+					links++
+				case unix.NLMSG_DONE:
+					break READ
+				default:
+					t.Fatalf("unexpected netlink message type %d", hdr.Type)
+				}
+				size := (int(hdr.Len) + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+				if size > len(buf) {
+					size = len(buf)
+				}
+				buf = buf[size:]
+			}
+		}
+		Assert(t, links > 0)
+		MustNil(t, conn.Close())
+	}
+}
+
+func TestRawPacketConnection(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface")
+	}
+	for _, batch := range []int{0, 4} {
+		conn, err := ListenRawPacket("lo", unix.ETH_P_ALL)
+		if errors.Is(err, syscall.EPERM) {
+			t.Skip("AF_PACKET needs CAP_NET_RAW")
+		}
+		MustNil(t, err)
+		Equal(t, conn.LocalAddr().(*LinkAddr).Ifindex, lo.Index)
+		if batch > 0 {
+			MustNil(t, conn.SetReadBatch(batch))
+		}
+
+		// the frames of the datagrams sent over the loopback are captured
+		payload := fmt.Sprintf("capture-%d-%d", batch, time.Now().UnixNano())
+		udp, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		MustNil(t, err)
+		_, err = udp.WriteTo([]byte(payload), udp.LocalAddr())
+		MustNil(t, err)
+		for {
+			p, addr, err := conn.ReadPacket()
+			MustNil(t, err)
+			frame, err := p.Next(p.Len())
+			MustNil(t, err)
+			if !strings.Contains(string(frame), payload) {
+				continue
+			}
+			la := addr.(*LinkAddr)
+			Equal(t, la.Ifindex, lo.Index)
+			Equal(t, la.Protocol, uint16(unix.ETH_P_IP))
+			break
+		}
+		MustNil(t, udp.Close())
+		MustNil(t, conn.Close())
+	}
+}
+
+func TestPacketConnectionMulticast(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+	server, err := ListenPacket("udp4", "0.0.0.0:0")
+	MustNil(t, err)
+	defer server.Close()
+	client, err := ListenPacket("udp4", "127.0.0.1:0")
+	MustNil(t, err)
+	defer client.Close()
+	MustNil(t, client.SetMulticastInterface(lo))
+	MustNil(t, client.SetMulticastTTL(1))
+	MustNil(t, client.SetMulticastLoopback(true))
+	MustNil(t, server.SetReadTimeout(100*time.Millisecond))
+
+	group := net.IPv4(239, 1, 2, 3)
+	dst := &net.UDPAddr{IP: group, Port: server.LocalAddr().(*net.UDPAddr).Port}
+	exchange := func(msg string) error {
+		_, err := client.WriteTo([]byte(msg), dst)
+		MustNil(t, err)
+		p, _, err := server.ReadPacket()
+		if err != nil {
+			return err
+		}
+		Equal(t, string(p.(*LinkBuffer).Bytes()), msg)
+		return nil
+	}
+
+	// any-source
+	MustNil(t, server.JoinGroup(lo, group, nil))
+	MustNil(t, exchange("any-source"))
+	MustNil(t, server.LeaveGroup(lo, group, nil))
+	Assert(t, errors.Is(exchange("left"), ErrReadTimeout))
+
+	// source-specific, the datagrams of the other sources are filtered
+	MustNil(t, server.JoinGroup(lo, group, net.IPv4(127, 0, 0, 2)))
+	Assert(t, errors.Is(exchange("filtered"), ErrReadTimeout))
+	MustNil(t, server.LeaveGroup(lo, group, net.IPv4(127, 0, 0, 2)))
+	MustNil(t, server.JoinGroup(lo, group, net.IPv4(127, 0, 0, 1)))
+	MustNil(t, exchange("source-specific"))
+
+	// invalid groups and networks
+	Assert(t, server.JoinGroup(lo, net.IPv4(127, 0, 0, 1), nil) != nil)
+	Assert(t, server.JoinGroup(lo, group, net.IPv6loopback) != nil)
+	unixgram, err := ListenPacket("unixgram", "")
+	MustNil(t, err)
+	defer unixgram.Close()
+	Assert(t, errors.Is(unixgram.JoinGroup(nil, group, nil), ErrUnsupported))
+}
@@ -0,0 +1,107 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// JoinGroup implements PacketConnection.
+func (c *packetConnection) JoinGroup(ifi *net.Interface, group, source net.IP) error {
+	return c.setMembership(ifi, group, source, true)
+}
+
+// LeaveGroup implements PacketConnection.
+func (c *packetConnection) LeaveGroup(ifi *net.Interface, group, source net.IP) error {
+	return c.setMembership(ifi, group, source, false)
+}
+
+func (c *packetConnection) setMembership(ifi *net.Interface, group, source net.IP, join bool) error {
+	if _, err := c.multicastFamily(); err != nil {
+		return err
+	}
+	if !group.IsMulticast() {
+		return Exception(syscall.EINVAL, group.String()+" is not a multicast group")
+	}
+	if source != nil && (source.To4() == nil) != (group.To4() == nil) {
+		return Exception(syscall.EINVAL, "the families of source "+source.String()+" and group "+group.String()+" mismatch")
+	}
+	if err := setMulticastMembership(c.fd, ifi, group, source, join); err != nil {
+		if join {
+			return Exception(err, "when join group "+group.String())
+		}
+		return Exception(err, "when leave group "+group.String())
+	}
+	return nil
+}
+
+// SetMulticastInterface implements PacketConnection.
+func (c *packetConnection) SetMulticastInterface(ifi *net.Interface) error {
+	return c.setMulticastOption("IP_MULTICAST_IF", func(ipv6 bool) error {
+		return setMulticastInterface(c.fd, ipv6, ifi)
+	})
+}
+
+// SetMulticastTTL implements PacketConnection.
+func (c *packetConnection) SetMulticastTTL(ttl int) error {
+	if ttl < 0 || ttl > 255 {
+		return Exception(syscall.EINVAL, "multicast TTL")
+	}
+	return c.setMulticastOption("IP_MULTICAST_TTL", func(ipv6 bool) error {
+		return setMulticastTTL(c.fd, ipv6, ttl)
+	})
+}
+
+// SetMulticastLoopback implements PacketConnection.
+func (c *packetConnection) SetMulticastLoopback(enabled bool) error {
+	return c.setMulticastOption("IP_MULTICAST_LOOP", func(ipv6 bool) error {
+		return setMulticastLoopback(c.fd, ipv6, enabled)
+	})
+}
+
+// setMulticastOption sets the option by the family of the socket. The dual-stack IPv6 sockets send to
+// the IPv4 groups with the IPv4 options, so they are set as well, and the error is ignored since they
+// are refused by the IPv6-only sockets, or by the IPv6 sockets on bsd systems.
+func (c *packetConnection) setMulticastOption(name string, set func(ipv6 bool) error) error {
+	ipv6, err := c.multicastFamily()
+	if err != nil {
+		return err
+	}
+	if err = set(ipv6); err != nil {
+		return Exception(err, "when set "+name)
+	}
+	if ipv6 {
+		set(false)
+	}
+	return nil
+}
+
+// multicastFamily returns whether the socket is AF_INET6, and ErrUnsupported if it's not a UDP socket.
+func (c *packetConnection) multicastFamily() (ipv6 bool, err error) {
+	if _, ok := c.localAddr.(*net.UDPAddr); !ok {
+		return false, Exception(ErrUnsupported, "multicast on "+c.localAddr.Network())
+	}
+	sa, err := unix.Getsockname(c.fd)
+	if err != nil {
+		return false, err
+	}
+	_, ipv6 = sa.(*unix.SockaddrInet6)
+	return ipv6, nil
+}
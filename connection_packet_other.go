@@ -0,0 +1,92 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux && !windows
+
+package netpoll
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// ListenNetlink is not supported since there is no AF_NETLINK on bsd systems.
+func ListenNetlink(protocol int, groups uint32) (PacketConnection, error) {
+	return nil, Exception(ErrUnsupported, "AF_NETLINK")
+}
+
+// ListenRawPacket is not supported since there is no AF_PACKET on bsd systems.
+func ListenRawPacket(ifname string, protocol uint16) (PacketConnection, error) {
+	return nil, Exception(ErrUnsupported, "AF_PACKET")
+}
+
+// sysToPacketAddr always returns nil since there is no AF_NETLINK or AF_PACKET on bsd systems.
+func sysToPacketAddr(sa unix.Sockaddr) net.Addr {
+	return nil
+}
+
+// netlinkToSockaddr is not supported since there is no AF_NETLINK on bsd systems.
+func netlinkToSockaddr(a *NetlinkAddr) (unix.Sockaddr, error) {
+	return nil, Exception(ErrUnsupported, "AF_NETLINK")
+}
+
+// linkToSockaddr is not supported since there is no AF_PACKET on bsd systems.
+func linkToSockaddr(a *LinkAddr) (unix.Sockaddr, error) {
+	return nil, Exception(ErrUnsupported, "AF_PACKET")
+}
+
+// packetBatch is only supported on Linux.
+type packetBatch struct{}
+
+// SetReadBatch implements PacketConnection, but it's only supported on Linux.
+func (c *packetConnection) SetReadBatch(n int) error {
+	return Exception(ErrUnsupported, "SetReadBatch")
+}
+
+// SetGRO implements PacketConnection, but it's only supported on Linux.
+func (c *packetConnection) SetGRO(enabled bool) error {
+	return Exception(ErrUnsupported, "UDP_GRO")
+}
+
+// readBatch is never called since SetReadBatch is not supported.
+func (c *packetConnection) readBatch(b *packetBatch) (received bool) {
+	return false
+}
+
+// writePackets sends the datagrams one by one, since there is no sendmmsg.
+func (c *packetConnection) writePackets(ps []*LinkBuffer, addrs []net.Addr) (n int, err error) {
+	for i, p := range ps {
+		var addr net.Addr
+		if addrs != nil {
+			addr = addrs[i]
+		}
+		sa, err := addrToSockaddr(addr)
+		if err != nil {
+			return n, err
+		}
+		m, err := c.sendmsg(p.GetBytes(nil), nil, sa)
+		if err != nil {
+			return n, err
+		}
+		p.Skip(m)
+		n++
+	}
+	return n, nil
+}
+
+// sendSegments sends the datagrams one by one, since there is no UDP GSO.
+func (c *packetConnection) sendSegments(p *LinkBuffer, segmentSize int, sa unix.Sockaddr) (n int, err error) {
+	return c.sendSegment(p, segmentSize, sa)
+}
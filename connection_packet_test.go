@@ -0,0 +1,347 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPacketConnection(t *testing.T) {
+	server, err := ListenPacket("udp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer server.Close()
+	client, err := ListenPacket("udp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer client.Close()
+
+	// datagram boundaries must be kept
+	for _, msg := range []string{"hello", "world", ""} {
+		_, err = client.WriteTo([]byte(msg), server.LocalAddr())
+		MustNil(t, err)
+	}
+	for _, msg := range []string{"hello", "world", ""} {
+		p, addr, err := server.ReadPacket()
+		MustNil(t, err)
+		Equal(t, p.Len(), len(msg))
+		s, err := p.ReadString(p.Len())
+		MustNil(t, err)
+		Equal(t, s, msg)
+		Equal(t, addr.String(), client.LocalAddr().String())
+	}
+
+	// write a packet from multiple nodes
+	buf := NewLinkBuffer()
+	buf.WriteString("hello ")
+	buf.WriteBinary([]byte("netpoll"))
+	buf.Flush()
+	n, err := server.WritePacket(buf, client.LocalAddr())
+	MustNil(t, err)
+	Equal(t, n, 13)
+	Equal(t, buf.Len(), 0)
+	recv := make([]byte, 64)
+	n, addr, err := client.ReadFrom(recv)
+	MustNil(t, err)
+	Equal(t, string(recv[:n]), "hello netpoll")
+	Equal(t, addr.String(), server.LocalAddr().String())
+
+	// read timeout
+	err = server.SetReadTimeout(10 * time.Millisecond)
+	MustNil(t, err)
+	_, _, err = server.ReadPacket()
+	Assert(t, errors.Is(err, ErrReadTimeout), err)
+
+	// read after close
+	err = server.Close()
+	MustNil(t, err)
+	MustTrue(t, !server.IsActive())
+	_, _, err = server.ReadPacket()
+	Assert(t, errors.Is(err, ErrConnClosed), err)
+}
+
+func TestPacketConnectionNocopy(t *testing.T) {
+	server, err := ListenPacket("udp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer server.Close()
+	client, err := ListenPacket("udp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer client.Close()
+
+	for _, msg := range []string{"hello", "world"} {
+		_, err = client.WriteTo([]byte(msg), server.LocalAddr())
+		MustNil(t, err)
+	}
+	p1, _, err := server.ReadPacket()
+	MustNil(t, err)
+	p2, _, err := server.ReadPacket()
+	MustNil(t, err)
+	b1, b2 := p1.(*LinkBuffer), p2.(*LinkBuffer)
+	// the datagrams are received into the same node
+	MustTrue(t, b1.head.origin != nil && b1.head.origin == b2.head.origin)
+	Equal(t, string(b1.Bytes()), "hello")
+	Equal(t, string(b2.Bytes()), "world")
+	// writing a packet never overwrites the following one
+	_, err = b1.WriteString(" netpoll")
+	MustNil(t, err)
+	MustNil(t, b1.Flush())
+	Equal(t, string(b1.Bytes()), "hello netpoll")
+	Equal(t, string(b2.Bytes()), "world")
+	MustNil(t, b1.Close())
+	MustNil(t, b2.Close())
+}
+
+func TestEventLoopServePacket(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer pc.Close()
+	loop, err := NewEventLoop(nil,
+		WithOnPacket(func(ctx context.Context, conn PacketConnection, p Reader, addr net.Addr) error {
+			buf, err := p.Next(p.Len())
+			MustNil(t, err)
+			_, err = conn.WriteTo(buf, addr)
+			return err
+		}),
+	)
+	MustNil(t, err)
+	go loop.(PacketServer).ServePacket(pc)
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	MustNil(t, err)
+	defer conn.Close()
+	recv := make([]byte, 64)
+	for i := 0; i < 16; i++ {
+		_, err = conn.Write([]byte("ping"))
+		MustNil(t, err)
+		err = conn.SetReadDeadline(time.Now().Add(time.Second))
+		MustNil(t, err)
+		n, err := conn.Read(recv)
+		MustNil(t, err)
+		Equal(t, string(recv[:n]), "ping")
+	}
+
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+}
+
+func TestDialPacketUnixgram(t *testing.T) {
+	address := "unixgram.test.sock"
+	os.Remove(address)
+	defer os.Remove(address)
+	server, err := ListenPacket("unixgram", address)
+	MustNil(t, err)
+	defer server.Close()
+	client, err := DialPacket("unixgram", address)
+	MustNil(t, err)
+	defer client.Close()
+
+	_, err = DialPacket("tcp", address)
+	Assert(t, err != nil)
+
+	// the client is connected, so the datagrams are sent without address
+	for _, msg := range []string{"hello", "world"} {
+		_, err = client.WriteTo([]byte(msg), nil)
+		MustNil(t, err)
+	}
+	for _, msg := range []string{"hello", "world"} {
+		p, _, err := server.ReadPacket()
+		MustNil(t, err)
+		Equal(t, p.Len(), len(msg))
+		buf, _ := p.Next(p.Len())
+		Equal(t, string(buf), msg)
+	}
+}
+
+func TestEventLoopServeUnixpacket(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("unixpacket is unsupported on darwin")
+	}
+	address := "unixpacket.test.sock"
+	os.Remove(address)
+	defer os.Remove(address)
+	ln, err := CreateListener("unixpacket", address)
+	MustNil(t, err)
+	loop, err := NewEventLoop(nil,
+		WithOnPacket(func(ctx context.Context, conn PacketConnection, p Reader, addr net.Addr) error {
+			buf, err := p.Next(p.Len())
+			MustNil(t, err)
+			_, err = conn.WriteTo(buf, nil)
+			return err
+		}),
+	)
+	MustNil(t, err)
+	go loop.Serve(ln)
+	time.Sleep(10 * time.Millisecond)
+
+	conn, err := DialPacket("unixpacket", address)
+	MustNil(t, err)
+	MustNil(t, conn.SetReadTimeout(time.Second))
+	// the message boundaries are kept in both directions
+	for _, msg := range []string{"hello", "world"} {
+		_, err = conn.WriteTo([]byte(msg), nil)
+		MustNil(t, err)
+	}
+	for _, msg := range []string{"hello", "world"} {
+		p, _, err := conn.ReadPacket()
+		MustNil(t, err)
+		buf, _ := p.Next(p.Len())
+		Equal(t, string(buf), msg)
+	}
+	MustNil(t, conn.Close())
+
+	// the server side connection is closed by the peer
+	evl := loop.(*eventLoop)
+	evl.Lock()
+	svr := evl.svrs[0]
+	evl.Unlock()
+	for i := 0; i < 100; i++ {
+		var n int
+		svr.connections.Range(func(key, value interface{}) bool {
+			n++
+			return true
+		})
+		if n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	svr.connections.Range(func(key, value interface{}) bool {
+		t.Fatal("the connection is not closed")
+		return true
+	})
+	MustNil(t, loop.Shutdown(context.Background()))
+}
+
+func TestPacketConnectionBatch(t *testing.T) {
+	server, err := ListenPacket("udp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer server.Close()
+	client, err := ListenPacket("udp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer client.Close()
+
+	err = server.SetReadBatch(8)
+	if runtime.GOOS != "linux" {
+		Assert(t, errors.Is(err, ErrUnsupported), err)
+	} else {
+		MustNil(t, err)
+	}
+
+	// more datagrams than a batch, including the empty one
+	var ps []*LinkBuffer
+	var addrs []net.Addr
+	for i := 0; i < 20; i++ {
+		buf := NewLinkBuffer()
+		buf.WriteString(strings.Repeat("x", i))
+		buf.Flush()
+		ps = append(ps, buf)
+		addrs = append(addrs, server.LocalAddr())
+	}
+	_, err = client.WritePackets(ps, addrs[1:])
+	Assert(t, err != nil)
+	n, err := client.WritePackets(ps, addrs)
+	MustNil(t, err)
+	Equal(t, n, len(ps))
+	for i := range ps {
+		Equal(t, ps[i].Len(), 0)
+		p, addr, err := server.ReadPacket()
+		MustNil(t, err)
+		Equal(t, p.Len(), i)
+		Equal(t, addr.String(), client.LocalAddr().String())
+	}
+
+	// the connected sockets send the datagrams without address
+	address := "unixgram.batch.test.sock"
+	os.Remove(address)
+	defer os.Remove(address)
+	userver, err := ListenPacket("unixgram", address)
+	MustNil(t, err)
+	defer userver.Close()
+	if runtime.GOOS == "linux" {
+		MustNil(t, userver.SetReadBatch(4))
+	}
+	uclient, err := DialPacket("unixgram", address)
+	MustNil(t, err)
+	defer uclient.Close()
+	ps = ps[:0]
+	for _, msg := range []string{"hello", "netpoll", "batch"} {
+		buf := NewLinkBuffer()
+		buf.WriteString(msg)
+		buf.Flush()
+		ps = append(ps, buf)
+	}
+	n, err = uclient.WritePackets(ps, nil)
+	MustNil(t, err)
+	Equal(t, n, len(ps))
+	for _, msg := range []string{"hello", "netpoll", "batch"} {
+		p, _, err := userver.ReadPacket()
+		MustNil(t, err)
+		s, _ := p.ReadString(p.Len())
+		Equal(t, s, msg)
+	}
+}
+
+func TestPacketConnectionSendBatch(t *testing.T) {
+	server, err := ListenPacket("udp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer server.Close()
+	client, err := ListenPacket("udp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer client.Close()
+
+	err = server.SetGRO(true)
+	if runtime.GOOS != "linux" {
+		Assert(t, errors.Is(err, ErrUnsupported), err)
+	} else {
+		MustNil(t, err)
+	}
+
+	// the last datagram is shorter than the segment size
+	data := make([]byte, 100*100+50)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	buf := NewLinkBuffer()
+	buf.WriteBinary(data)
+	buf.Flush()
+	_, err = client.SendBatch(buf, 0, server.LocalAddr())
+	Assert(t, err != nil)
+	n, err := client.SendBatch(buf, 100, server.LocalAddr())
+	MustNil(t, err)
+	Equal(t, n, len(data))
+	Equal(t, buf.Len(), 0)
+	if runtime.GOOS == "linux" {
+		Equal(t, client.(*packetConnection).gso, gsoSupported)
+	}
+	for off := 0; off < len(data); off += 100 {
+		p, addr, err := server.ReadPacket()
+		MustNil(t, err)
+		size := len(data) - off
+		if size > 100 {
+			size = 100
+		}
+		Equal(t, p.Len(), size)
+		b, _ := p.Next(size)
+		Equal(t, string(b), string(data[off:off+size]))
+		Equal(t, addr.String(), client.LocalAddr().String())
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// readLimiter is the token bucket of SetReadRateLimit. The poller reads as much as the buffer booked,
+// so the tokens may go negative, and then reading is paused until they are refilled to zero.
+type readLimiter struct {
+	mu        sync.Mutex
+	rate      float64 // bytes per second
+	burst     float64
+	tokens    float64
+	last      time.Time
+	timer     *Timer
+	throttled bool // reading is paused until refilled
+	stopped   bool
+}
+
+// SetReadRateLimit implements RateLimiter.
+func (c *connection) SetReadRateLimit(bytesPerSec, burst int) error {
+	var l *readLimiter
+	if bytesPerSec > 0 {
+		if burst < 1 {
+			burst = bytesPerSec
+		}
+		l = &readLimiter{rate: float64(bytesPerSec), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+	}
+	if old := c.readLimit.Swap(l); old != nil {
+		old.stop()
+	}
+	// the limit may be raised or removed
+	c.resumeRead()
+	return nil
+}
+
+// throttleRead takes n tokens after the poller reads n bytes, and pauses reading if the bucket is empty.
+func (c *connection) throttleRead(n int) {
+	l := c.readLimit.Load()
+	if l == nil {
+		return
+	}
+	wait := l.take(n, time.Now())
+	if wait <= 0 {
+		return
+	}
+	c.readPauseMu.Lock()
+	if atomic.LoadInt32(&c.readPaused) == 0 {
+		atomic.StoreInt32(&c.readPaused, 1)
+		c.operator.Control(PollPauseRead)
+	}
+	c.readPauseMu.Unlock()
+	l.arm(c.wheel(), wait, func() {
+		if l.refill() {
+			c.resumeRead()
+		}
+	})
+}
+
+// readThrottled returns true if reading is paused by the read rate limit.
+func (c *connection) readThrottled() bool {
+	l := c.readLimit.Load()
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.throttled
+}
+
+// take takes n tokens, and returns the time to wait until the tokens are refilled to zero if they run out.
+func (l *readLimiter) take(n int, now time.Time) (wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.tokens += now.Sub(l.last).Seconds() * l.rate; l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 || l.stopped {
+		return 0
+	}
+	l.throttled = true
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// refill is called by the timer, and returns false if the limiter is stopped.
+func (l *readLimiter) refill() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.throttled = false
+	return !l.stopped
+}
+
+// arm starts the timer of w to call fn after wait, the running timer is reset since more tokens are taken.
+// fn is called by the poller, so it must not block.
+func (l *readLimiter) arm(w *timerWheel, wait time.Duration, fn func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return
+	}
+	if l.timer == nil {
+		l.timer = w.afterFunc(wait, 0, fn)
+		return
+	}
+	l.timer.reset(wait)
+}
+
+func (l *readLimiter) stop() {
+	l.mu.Lock()
+	l.stopped = true
+	l.throttled = false
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	l.mu.Unlock()
+}
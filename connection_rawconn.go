@@ -0,0 +1,64 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"syscall"
+)
+
+// rawConn implements syscall.RawConn for the connections served by the poller, see SocketConn.SyscallConn.
+type rawConn struct {
+	c *connection
+}
+
+// SyscallConn implements SocketConn.
+func (c *connection) SyscallConn() (syscall.RawConn, error) {
+	if !c.IsActive() {
+		return nil, Exception(ErrConnClosed, "when syscall conn")
+	}
+	return rawConn{c}, nil
+}
+
+// closeFD closes the fd once the running RawConn.Control calls return.
+func (c *connection) closeFD() error {
+	c.fdMu.Lock()
+	defer c.fdMu.Unlock()
+	c.fdClosed = true
+	return c.netFD.Close()
+}
+
+// Control calls f with the fd, which is not closed until f returns.
+func (rc rawConn) Control(f func(fd uintptr)) error {
+	c := rc.c
+	c.fdMu.RLock()
+	defer c.fdMu.RUnlock()
+	if c.fdClosed {
+		return Exception(ErrConnClosed, "when control")
+	}
+	f(uintptr(c.fd))
+	return nil
+}
+
+// Read is unsupported since the data is read by the poller.
+func (rc rawConn) Read(f func(fd uintptr) (done bool)) error {
+	return Exception(ErrUnsupported, "RawConn.Read")
+}
+
+// Write is unsupported since the data is written by the poller.
+func (rc rawConn) Write(f func(fd uintptr) (done bool)) error {
+	return Exception(ErrUnsupported, "RawConn.Write")
+}
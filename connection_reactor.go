@@ -27,6 +27,7 @@ func (c *connection) onHup(p Poll) error {
 	if !c.closeBy(poller) {
 		return nil
 	}
+	c.closeState.setReason(Exception(ErrEOF, "peer close"))
 	c.triggerRead(Exception(ErrEOF, "peer close"))
 	c.triggerWrite(Exception(ErrConnClosed, "peer close"))
 
@@ -50,7 +51,7 @@ func (c *connection) onHup(p Poll) error {
 func (c *connection) onClose() error {
 	// user code close the connection
 	if c.closeBy(user) {
-		c.triggerRead(Exception(ErrConnClosed, "self close"))
+		c.triggerRead(c.closeState.closedError("self close"))
 		c.triggerWrite(Exception(ErrConnClosed, "self close"))
 		// Detach from poller when processing finished, otherwise it will cause race
 		c.closeCallback(true, true)
@@ -75,7 +76,8 @@ func (c *connection) closeBuffer() {
 	if c.inputBuffer.Len() == 0 || onConnect != nil || onRequest != nil {
 		c.inputBuffer.Close()
 	}
-	if c.outputBuffer.Len() == 0 || onConnect != nil || onRequest != nil {
+	// the kernel may still read the data sent by MSG_ZEROCOPY, so leave it to GC instead of reusing
+	if (c.outputBuffer.Len() == 0 || onConnect != nil || onRequest != nil) && !c.zeroCopyPending() {
 		c.outputBuffer.Close()
 		barrierPool.Put(c.outputBarrier)
 	}
@@ -94,12 +96,20 @@ func (c *connection) inputAck(n int) (err error) {
 		return nil
 	}
 
+	c.stats.read(n)
+	if !c.firstByteTraced && c.traceCallback != nil {
+		c.firstByteTraced = true
+		c.trace(TraceFirstByte, nil)
+	}
+
 	// Auto size bookSize.
 	if n == c.bookSize && c.bookSize < mallocMax {
 		c.bookSize <<= 1
 	}
 
 	length, _ := c.inputBuffer.bookAck(n)
+	c.pauseRead()
+	c.throttleRead(n)
 	if c.maxSize < length {
 		c.maxSize = length
 	}
@@ -108,7 +118,7 @@ func (c *connection) inputAck(n int) (err error) {
 	}
 
 	needTrigger := true
-	if length == n { // first start onRequest
+	if wm := c.watermark(); length-n < wm && length >= wm { // first start onRequest
 		needTrigger = c.onRequest()
 	}
 	if needTrigger && length >= int(atomic.LoadInt64(&c.waitReadSize)) {
@@ -124,14 +134,19 @@ func (c *connection) outputs(vs [][]byte) (rs [][]byte, _ bool) {
 		return rs, false
 	}
 	rs = c.outputBuffer.GetBytes(vs)
-	return rs, false
+	rs, wait := c.paceWrite(rs)
+	if len(rs) == 0 {
+		c.pauseWrite(wait)
+	}
+	return rs, c.useZeroCopy(rs)
 }
 
 // outputAck implements FDOperator.
 func (c *connection) outputAck(n int) (err error) {
 	if n > 0 {
-		c.outputBuffer.Skip(n)
-		c.outputBuffer.Release()
+		c.stats.write(n)
+		c.paceAck(n)
+		c.releaseOutput(n, c.operator.zeroCopied)
 	}
 	if c.outputBuffer.IsEmpty() {
 		c.rw2r()
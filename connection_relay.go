@@ -0,0 +1,83 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"errors"
+	"io"
+)
+
+// Relay copies data from src to dst until EOF is reached on src or an error occurs,
+// and returns the number of bytes copied. A successful Relay returns err == nil, not io.EOF.
+//
+// On Linux, the data is moved by splice(2) in the kernel without being copied to user space,
+// so src is detached from the poller and cannot be read by netpoll anymore. Otherwise, or if any
+// of them is a TLS connection, the nocopy buffers of src are forwarded to dst instead.
+//
+// Relay blocks like io.Copy, src must not be read by others during Relay, and it's not closed
+// when Relay returns. An example usage in TCP Proxy scenario:
+//
+//	func onRequest(ctx context.Context, upstream netpoll.Connection) error {
+//		downstream := ctx.Value(downstreamKey).(netpoll.Connection)
+//		go netpoll.Relay(upstream, downstream)
+//		netpoll.Relay(downstream, upstream)
+//		downstream.Close()
+//		return upstream.Close()
+//	}
+func Relay(dst, src Connection) (written int64, err error) {
+	if written, err, handled := relaySplice(dst, src); handled {
+		return written, err
+	}
+	return relayBuffer(dst, src)
+}
+
+// relayBuffer forwards the buffered data of src to dst once it arrives.
+func relayBuffer(dst, src Connection) (written int64, err error) {
+	for {
+		// wait for the next data
+		if _, err = src.Reader().Peek(1); err != nil {
+			if errors.Is(err, ErrEOF) || errors.Is(err, io.EOF) {
+				err = nil
+			}
+			return written, err
+		}
+		n, err := relayBuffered(dst, src)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// relayBuffered moves the data buffered in src to dst.
+func relayBuffered(dst, src Connection) (n int, err error) {
+	r, w := src.Reader(), dst.Writer()
+	if n = r.Len(); n == 0 {
+		return 0, nil
+	}
+	buf, err := r.Next(n)
+	if err != nil {
+		return 0, err
+	}
+	// buf may be referenced by w, so it must be released after flushing
+	if _, err = w.WriteBinary(buf); err == nil {
+		err = w.Flush()
+	}
+	r.Release()
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// relaySplice moves the data from src to dst by splice(2) with a pipe pair, which is done by
+// the net package over the duplicated fds, so the waiting is handled by the Go runtime poller.
+func relaySplice(dst, src Connection) (written int64, err error, handled bool) {
+	sc, dc := rawConnection(src), rawConnection(dst)
+	if sc == nil || dc == nil || sc.operator.poll == nil {
+		return 0, nil, false
+	}
+	if !sc.IsActive() {
+		return 0, Exception(ErrConnClosed, "when relay"), true
+	}
+	// stop reading src by poller, and wait for the running read event to finish
+	if err = sc.operator.Control(PollDetach); err != nil {
+		return 0, Exception(err, "when relay"), true
+	}
+	sc.operator.unused()
+
+	// the data read before detaching must be sent first
+	n, err := relayBuffered(dc, sc)
+	if err != nil {
+		return 0, err, true
+	}
+	written = int64(n)
+
+	r, err := dupConn(sc.fd)
+	if err != nil {
+		return written, err, true
+	}
+	defer r.Close()
+	w, err := dupConn(dc.fd)
+	if err != nil {
+		return written, err, true
+	}
+	defer w.Close()
+	// the duplicated fds keep the sockets alive, so they must be closed with the connections
+	closeDup := func(Connection) error {
+		r.Close()
+		w.Close()
+		return nil
+	}
+	sc.AddCloseCallback(closeDup)
+	dc.AddCloseCallback(closeDup)
+	if !sc.IsActive() || !dc.IsActive() {
+		return written, Exception(ErrConnClosed, "when relay"), true
+	}
+
+	m, err := io.Copy(w, r)
+	if m > 0 {
+		sc.stats.read(int(m))
+		dc.stats.write(int(m))
+	}
+	if err != nil && (!sc.IsActive() || !dc.IsActive()) {
+		err = Exception(ErrConnClosed, "when relay")
+	}
+	return written + m, err, true
+}
+
+// dupConn returns a net.Conn over the duplicated fd, the original fd is not affected when it's closed.
+func dupConn(fd int) (net.Conn, error) {
+	nfd, err := unix.FcntlInt(uintptr(fd), unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("fcntl", err)
+	}
+	// net.FileConn duplicates the fd again, so f can be closed directly
+	f := os.NewFile(uintptr(nfd), "")
+	defer f.Close()
+	return net.FileConn(f)
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func testRelayProxy(t *testing.T, relay func(dst, src Connection) (int64, error)) {
+	network, backend, proxy := "tcp", getTestAddress(), getTestAddress()
+	backendLoop := newTestEventLoop(network, backend,
+		func(ctx context.Context, connection Connection) error {
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			_, err = connection.Writer().WriteBinary(buf)
+			if err != nil {
+				return err
+			}
+			return connection.Writer().Flush()
+		},
+	)
+	relayed, reversed := make(chan int64, 1), make(chan struct{})
+	proxyLoop := newTestEventLoop(network, proxy,
+		func(ctx context.Context, upstream Connection) error {
+			downstream, err := DialConnection(network, backend, time.Second)
+			MustNil(t, err)
+			go func() {
+				relay(upstream, downstream)
+				close(reversed)
+			}()
+			n, err := relay(downstream, upstream)
+			MustNil(t, err)
+			relayed <- n
+			downstream.Close()
+			return upstream.Close()
+		},
+	)
+
+	conn, err := DialConnection(network, proxy, time.Second)
+	MustNil(t, err)
+	_, err = conn.Writer().WriteString("hello")
+	MustNil(t, err)
+	MustNil(t, conn.Writer().Flush())
+	buf, err := conn.Reader().Next(5)
+	MustNil(t, err)
+	Equal(t, string(buf), "hello")
+
+	msg := make([]byte, 4*1024*1024)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	_, err = conn.Writer().WriteBinary(msg)
+	MustNil(t, err)
+	MustNil(t, conn.Writer().Flush())
+	buf, err = conn.Reader().Next(len(msg))
+	MustNil(t, err)
+	MustTrue(t, bytes.Equal(buf, msg))
+	MustNil(t, conn.Close())
+
+	select {
+	case n := <-relayed:
+		Equal(t, n, int64(5+len(msg)))
+	case <-time.After(time.Second):
+		t.Fatal("relay is not finished after the upstream closed")
+	}
+	select {
+	case <-reversed:
+	case <-time.After(time.Second):
+		t.Fatal("relay is not finished after the connections closed")
+	}
+	MustNil(t, proxyLoop.Shutdown(context.Background()))
+	MustNil(t, backendLoop.Shutdown(context.Background()))
+}
+
+func TestRelay(t *testing.T) {
+	testRelayProxy(t, Relay)
+}
+
+func TestRelayBuffer(t *testing.T) {
+	testRelayProxy(t, relayBuffer)
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// safeWriter serializes the writes of multiple goroutines, and batches their flushes, see ConcurrentWriter.SafeWrite.
+type safeWriter struct {
+	mu       sync.Mutex // protects the Writer
+	flushing int32      // 1 if a goroutine is flushing
+	pending  int32      // 1 if there is data written but not flushed by the flushing goroutine
+}
+
+// write copies p to w.
+func (s *safeWriter) write(w Writer, p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf, err := w.Malloc(len(p))
+	if err != nil {
+		return err
+	}
+	copy(buf, p)
+	return nil
+}
+
+// flush flushes w until no more data is written by the other goroutines during flushing,
+// so that the data of the concurrent SafeFlush calls is sent together.
+func (s *safeWriter) flush(w Writer) error {
+	atomic.StoreInt32(&s.pending, 1)
+	// if another goroutine is flushing, it will find pending and flush the data written before
+	for atomic.CompareAndSwapInt32(&s.flushing, 0, 1) {
+		var err error
+		for err == nil && atomic.SwapInt32(&s.pending, 0) == 1 {
+			s.mu.Lock()
+			err = w.Flush()
+			s.mu.Unlock()
+		}
+		atomic.StoreInt32(&s.flushing, 0)
+		// check again, since the others may give up before flushing is reset
+		if err != nil || atomic.LoadInt32(&s.pending) == 0 {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// maxSendfileSize is the max bytes sent by one sendfile call, the same as net package.
+	maxSendfileSize = 4 << 20
+	// copyFileSize is the max bytes copied to the output buffer at once when sendfile is unavailable.
+	copyFileSize = 64 << 10
+)
+
+// SendFile sends n bytes of f starting at off to conn by SocketConn.SendFile if conn implements it,
+// otherwise the file is copied by the output buffer of conn, see SocketConn.
+func SendFile(conn Connection, f *os.File, off, n int64) (written int64, err error) {
+	if sc, ok := conn.(SocketConn); ok {
+		return sc.SendFile(f, off, n)
+	}
+	if !conn.IsActive() {
+		return 0, Exception(ErrConnClosed, "when sendfile")
+	}
+	if n, err = sendFileSize(f, off, n); err != nil {
+		return 0, err
+	}
+	w := conn.Writer()
+	return copyFile(w, w.Flush, f, off, n)
+}
+
+// sendFileSize returns the number of bytes to send, which is the rest of the file if n <= 0.
+func sendFileSize(f *os.File, off, n int64) (int64, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("sendfile offset[%d] invalid", off)
+	}
+	if n > 0 {
+		return n, nil
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size() - off, nil
+}
+
+// copyFile sends the file by copying it to w chunk by chunk, flush is called after each copy.
+func copyFile(w Writer, flush func() error, f *os.File, off, n int64) (written int64, err error) {
+	for written < n {
+		size := n - written
+		if size > copyFileSize {
+			size = copyFileSize
+		}
+		base := w.MallocLen()
+		buf, err := w.Malloc(int(size))
+		if err != nil {
+			return written, err
+		}
+		m, rerr := f.ReadAt(buf, off+written)
+		if err = w.MallocAck(base + m); err != nil {
+			return written, err
+		}
+		if m > 0 {
+			if err = flush(); err != nil {
+				return written, err
+			}
+			written += int64(m)
+		}
+		if rerr != nil {
+			if rerr == io.EOF && written == n {
+				break
+			}
+			return written, rerr
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,575 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// stdConnection implements Connection by the standard net package. It's used on Windows to make the services
+// built on netpoll work for development, and by WrapConn for the net.Conn whose fd can't be polled, e.g. *tls.Conn.
+//
+// There is no poller, so the data is read by the goroutine which calls the Reader,
+// or by a dedicated goroutine after OnRequest is set.
+type stdConnection struct {
+	net.Conn
+	ctx    context.Context
+	reader *zcReader
+	writer *zcWriter
+
+	readTimeout  int64 // time.Duration
+	writeTimeout int64 // time.Duration
+	closed       int32
+	connected    int32 // 1 if OnConnect has been called
+	serving      int32 // 1 if the serving goroutine is running
+	processing   int32 // 1 if OnRequest is running
+	watermark    int64 // see SetReadWatermark
+	waiting      bool  // waiting for the next request, readTimeout will not take effect
+	stats        connStats
+	userData     atomic.Pointer[interface{}] // see SetUserData
+	heartbeat    heartbeat
+	safe         safeWriter // see SafeWrite
+	closeState   closeState // see Done and CloseWithError
+
+	mu             sync.Mutex // protects onRequest
+	onConnect      OnConnect
+	onRequest      OnRequest
+	onDisconnect   OnDisconnect
+	closeCallbacks closeCallbacks
+	executor       Executor
+
+	tracer          Tracer
+	firstByteTraced bool
+}
+
+var (
+	_ Connection = &stdConnection{}
+	_ Conn       = &stdConnection{}
+
+	_ BufferTuner                = &stdConnection{}
+	_ HalfCloser                 = &stdConnection{}
+	_ SocketTuner                = &stdConnection{}
+	_ AsyncFlusher               = &stdConnection{}
+	_ StatsProvider              = &stdConnection{}
+	_ Heartbeater                = &stdConnection{}
+	_ ConcurrentWriter           = &stdConnection{}
+	_ CloseNotifier              = &stdConnection{}
+	_ PriorityCloseCallbackAdder = &stdConnection{}
+	_ UserDataHolder             = &stdConnection{}
+)
+
+// WrapConn wraps any net.Conn into Connection, e.g. *tls.Conn or the connections created by the other libraries,
+// so that they can be handled by the same code as the connections of netpoll. The data is read into the buffer
+// by the goroutine calling the Reader, or by a dedicated goroutine once OnRequest is set by SetOnRequest,
+// and the data flushed is written to conn synchronously. conn is closed once the Connection is closed.
+// The methods depending on the poller return ErrUnsupported, so the sockets which can be polled should be
+// served by EventLoop or converted by NewFDConnection instead.
+func WrapConn(conn net.Conn) Connection {
+	if c, ok := conn.(Connection); ok {
+		return c
+	}
+	return newStdConnection(conn, nil)
+}
+
+func newStdConnection(conn net.Conn, opts *options) *stdConnection {
+	c := &stdConnection{Conn: conn, ctx: context.Background()}
+	c.stats.init(nil)
+	c.reader = newZCReader(stdReader{c})
+	c.writer = newZCWriter(stdWriter{c})
+	if opts == nil {
+		return c
+	}
+	c.onConnect, c.onRequest, c.onDisconnect = opts.onConnect, opts.onRequest, opts.onDisconnect
+	if opts.frameDecoder != nil && c.onRequest != nil {
+		c.onRequest = DecodeFrames(opts.frameDecoder, c.onRequest)
+	}
+	c.executor = opts.executor
+	c.SetReadTimeout(opts.readTimeout)
+	c.SetWriteTimeout(opts.writeTimeout)
+	c.SetIdleTimeout(opts.idleTimeout)
+	if opts.onIdle != nil && opts.idleTimeout > 0 {
+		watchIdle(&defaultTimers, c, &c.stats, opts.idleTimeout, opts.onIdle)
+	}
+	if ka := opts.keepAlive; ka != nil {
+		c.SetTCPKeepAlive(ka.idle, ka.interval, ka.count)
+	}
+	if opts.bufferSize > 0 {
+		c.SetMallocSize(opts.bufferSize)
+	}
+	if opts.maxOutput > 0 {
+		c.SetMaxOutputBuffer(opts.maxOutput)
+	}
+	if opts.readWatermark > 0 {
+		c.SetReadWatermark(opts.readWatermark)
+	}
+	if c.tracer = opts.tracer; c.tracer != nil {
+		c.trace(TraceAccept, nil)
+		c.AddCloseCallback(func(Connection) error {
+			c.trace(TraceClose, nil)
+			return nil
+		})
+	}
+	if onClose := opts.onClose; onClose != nil {
+		c.AddCloseCallback(func(Connection) error {
+			onClose(c.ctx, c)
+			return nil
+		})
+	}
+	return c
+}
+
+// Fd implements Conn.
+func (c *stdConnection) Fd() (fd int) {
+	return sysFd(c.Conn)
+}
+
+// Reader implements Connection.
+func (c *stdConnection) Reader() Reader {
+	return c.reader
+}
+
+// Writer implements Connection.
+func (c *stdConnection) Writer() Writer {
+	return c.writer
+}
+
+// IsActive implements Connection.
+func (c *stdConnection) IsActive() bool {
+	return atomic.LoadInt32(&c.closed) == 0
+}
+
+// SetReadTimeout implements Connection.
+func (c *stdConnection) SetReadTimeout(timeout time.Duration) error {
+	if timeout >= 0 {
+		atomic.StoreInt64(&c.readTimeout, int64(timeout))
+	}
+	return nil
+}
+
+// SetWriteTimeout implements Connection.
+func (c *stdConnection) SetWriteTimeout(timeout time.Duration) error {
+	if timeout >= 0 {
+		atomic.StoreInt64(&c.writeTimeout, int64(timeout))
+	}
+	return nil
+}
+
+// SetIdleTimeout implements Connection.
+func (c *stdConnection) SetIdleTimeout(timeout time.Duration) error {
+	if timeout <= 0 {
+		return nil
+	}
+	if tc, ok := c.Conn.(*net.TCPConn); ok {
+		tc.SetKeepAlive(true)
+		return tc.SetKeepAlivePeriod(timeout)
+	}
+	return nil
+}
+
+// SetNoDelay implements SocketTuner.
+func (c *stdConnection) SetNoDelay(noDelay bool) error {
+	if tc, ok := c.Conn.(*net.TCPConn); ok {
+		return tc.SetNoDelay(noDelay)
+	}
+	return nil
+}
+
+// SetQuickAck implements SocketTuner, but TCP_QUICKACK is not supported without the poller.
+func (c *stdConnection) SetQuickAck(quickAck bool) error {
+	return Exception(ErrUnsupported, "TCP_QUICKACK")
+}
+
+// SetTCPKeepAlive implements SocketTuner.
+// Only the idle time takes effect, which is used as the interval as well.
+func (c *stdConnection) SetTCPKeepAlive(idle, interval time.Duration, count int) error {
+	tc, ok := c.Conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if err := tc.SetKeepAlive(true); err != nil {
+		return err
+	}
+	if idle > 0 {
+		return tc.SetKeepAlivePeriod(idle)
+	}
+	return nil
+}
+
+// SetMallocSize implements BufferTuner.
+func (c *stdConnection) SetMallocSize(size int) error {
+	if size < 0 {
+		size = 0
+	}
+	c.reader.buf.setBlockSize(size)
+	c.writer.buf.setBlockSize(size)
+	return nil
+}
+
+// SetMaxInputBuffer implements BufferTuner.
+// The data is only read when the buffered data is not enough, so there is nothing to limit.
+func (c *stdConnection) SetMaxInputBuffer(size int) error {
+	return nil
+}
+
+// SetReadWatermark implements BufferTuner.
+func (c *stdConnection) SetReadWatermark(n int) error {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt64(&c.watermark, int64(n))
+	return nil
+}
+
+// SetMaxOutputBuffer implements BufferTuner.
+func (c *stdConnection) SetMaxOutputBuffer(size int) error {
+	c.writer.setMaxSize(size)
+	return nil
+}
+
+// SetZeroCopy implements SocketTuner, but it's not supported without the poller.
+func (c *stdConnection) SetZeroCopy(threshold int) error {
+	return Exception(ErrUnsupported, "SetZeroCopy")
+}
+
+// SetHeartbeat implements Heartbeater.
+func (c *stdConnection) SetHeartbeat(interval time.Duration, fn func(connection Connection)) error {
+	c.heartbeat.set(&defaultTimers, c, interval, fn)
+	return nil
+}
+
+// SafeWrite implements ConcurrentWriter.
+func (c *stdConnection) SafeWrite(p []byte) error {
+	return c.safe.write(c.writer, p)
+}
+
+// SafeFlush implements ConcurrentWriter.
+func (c *stdConnection) SafeFlush() error {
+	return c.safe.flush(c.writer)
+}
+
+// SetOnRequest implements Connection.
+// Once OnRequest is set, the data will be read by a dedicated goroutine.
+func (c *stdConnection) SetOnRequest(onRequest OnRequest) error {
+	if onRequest == nil {
+		return nil
+	}
+	c.mu.Lock()
+	c.onRequest = onRequest
+	c.mu.Unlock()
+	c.serve()
+	return nil
+}
+
+// AddCloseCallback implements Connection.
+func (c *stdConnection) AddCloseCallback(callback CloseCallback) error {
+	_, err := c.AddCloseCallbackWithPriority(callback, 0)
+	return err
+}
+
+// AddCloseCallbackWithPriority implements PriorityCloseCallbackAdder.
+func (c *stdConnection) AddCloseCallbackWithPriority(callback CloseCallback, priority int) (*CloseCallbackHandle, error) {
+	if callback == nil {
+		return nil, nil
+	}
+	return c.closeCallbacks.add(callback, priority)
+}
+
+// Read behavior is the same as net.Conn, buffered data will be returned first.
+func (c *stdConnection) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if c.reader.Len() > 0 {
+		return c.reader.buf.readCopy(p), nil
+	}
+	return stdReader{c}.Read(p)
+}
+
+// Write will send p directly.
+func (c *stdConnection) Write(p []byte) (n int, err error) {
+	return stdWriter{c}.Write(p)
+}
+
+// FlushAsync implements AsyncFlusher, the data is flushed synchronously without the poller.
+func (c *stdConnection) FlushAsync(callback func(err error)) error {
+	if err := c.writer.Flush(); err != nil {
+		return err
+	}
+	callback(nil)
+	return nil
+}
+
+// Stats implements StatsProvider.
+func (c *stdConnection) Stats() ConnStats {
+	return c.stats.snapshot()
+}
+
+// SetUserData implements UserDataHolder.
+func (c *stdConnection) SetUserData(data interface{}) error {
+	c.userData.Store(&data)
+	return nil
+}
+
+// UserData implements UserDataHolder.
+func (c *stdConnection) UserData() interface{} {
+	if data := c.userData.Load(); data != nil {
+		return *data
+	}
+	return nil
+}
+
+// CloseWrite implements HalfCloser.
+func (c *stdConnection) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return Exception(ErrUnsupported, "CloseWrite")
+}
+
+// CloseRead implements HalfCloser.
+func (c *stdConnection) CloseRead() error {
+	if cr, ok := c.Conn.(interface{ CloseRead() error }); ok {
+		return cr.CloseRead()
+	}
+	return Exception(ErrUnsupported, "CloseRead")
+}
+
+// Close implements Connection.
+func (c *stdConnection) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return nil
+	}
+	err := c.Conn.Close()
+	c.heartbeat.stop()
+	// the callbacks are called after OnRequest returns if it's running
+	if handles, ok := c.closeCallbacks.close(); ok {
+		c.closeCallback(handles)
+	}
+	return err
+}
+
+// closeCallback calls the CloseCallbacks, and then signals Done.
+func (c *stdConnection) closeCallback(handles []*CloseCallbackHandle) {
+	runCloseCallbacks(c, handles)
+	c.closeState.finish()
+}
+
+// CloseWithError implements CloseNotifier.
+func (c *stdConnection) CloseWithError(err error) error {
+	c.closeState.setReason(err)
+	return c.Close()
+}
+
+// Done implements CloseNotifier.
+func (c *stdConnection) Done() <-chan struct{} {
+	return c.closeState.doneChan()
+}
+
+// Err implements CloseNotifier.
+func (c *stdConnection) Err() error {
+	if c.IsActive() {
+		return nil
+	}
+	if err := c.closeState.getReason(); err != nil {
+		return err
+	}
+	return Exception(ErrConnClosed, "closed by user")
+}
+
+// serve starts the goroutine to call OnConnect and OnRequest.
+// If OnRequest is not set, the goroutine exits after OnConnect, and the data is left to the user.
+func (c *stdConnection) serve() {
+	if !atomic.CompareAndSwapInt32(&c.serving, 0, 1) {
+		return
+	}
+	runTask(c.ctx, c.executor, func() {
+		if c.onConnect != nil && atomic.CompareAndSwapInt32(&c.connected, 0, 1) {
+			c.ctx = c.onConnect(c.ctx, c)
+		}
+		c.mu.Lock()
+		onRequest := c.onRequest
+		if onRequest == nil {
+			atomic.StoreInt32(&c.serving, 0)
+		}
+		c.mu.Unlock()
+		if onRequest == nil {
+			return
+		}
+		var err error
+		for c.IsActive() {
+			wm := int(atomic.LoadInt64(&c.watermark))
+			if wm < 1 {
+				wm = 1
+			}
+			if c.reader.Len() < wm {
+				if err != nil {
+					break
+				}
+				c.waiting = true
+				err = c.reader.fill(wm)
+				c.waiting = false
+				continue
+			}
+			c.mu.Lock()
+			onRequest = c.onRequest
+			c.mu.Unlock()
+			// onRequest must either eventually read all the input data or actively Close the connection.
+			if !c.closeCallbacks.enter() {
+				break
+			}
+			atomic.StoreInt32(&c.processing, 1)
+			c.trace(TraceRequestStart, nil)
+			begin := statsNow()
+			err := onRequest(c.ctx, c)
+			c.stats.shard.request(begin)
+			c.trace(TraceRequestEnd, err)
+			atomic.StoreInt32(&c.processing, 0)
+			// the CloseCallbacks are deferred if onRequest closes the connection
+			if handles, ok := c.closeCallbacks.leave(); ok {
+				c.closeCallback(handles)
+			}
+		}
+		// closed by peer
+		if c.IsActive() {
+			if c.onDisconnect != nil {
+				c.onDisconnect(c.ctx, c)
+			}
+			c.CloseWithError(Exception(ErrEOF, "closed by peer"))
+		}
+	})
+}
+
+// onShutdown calls OnShutdown if the connection is not processing OnRequest.
+func (c *stdConnection) onShutdown(onShutdown OnShutdown) (called bool) {
+	if onShutdown == nil {
+		return true
+	}
+	if !c.isIdle() {
+		return false
+	}
+	onShutdown(c.ctx, c)
+	return true
+}
+
+// trace reports the event to the Tracer if it's set.
+func (c *stdConnection) trace(event TraceEvent, err error) {
+	if c.tracer != nil {
+		c.tracer.Trace(TraceInfo{Event: event, Time: time.Now(), Conn: c, Err: err})
+	}
+}
+
+// isIdle returns true if the connection is not processing OnRequest.
+func (c *stdConnection) isIdle() bool {
+	return atomic.LoadInt32(&c.processing) == 0
+}
+
+// stdReader applies the read timeout for each Read.
+type stdReader struct {
+	c *stdConnection
+}
+
+func (r stdReader) Read(p []byte) (n int, err error) {
+	c := r.c
+	if c.waiting {
+		c.Conn.SetReadDeadline(time.Time{})
+	} else if timeout := time.Duration(atomic.LoadInt64(&c.readTimeout)); timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	n, err = c.Conn.Read(p)
+	if n > 0 {
+		c.stats.read(n)
+		if !c.firstByteTraced && c.tracer != nil {
+			c.firstByteTraced = true
+			c.trace(TraceFirstByte, nil)
+		}
+	}
+	if err != nil {
+		err = c.mapErr(err, ErrReadTimeout)
+	}
+	return n, err
+}
+
+// stdWriter applies the write timeout for each Write.
+type stdWriter struct {
+	c *stdConnection
+}
+
+func (w stdWriter) Write(p []byte) (n int, err error) {
+	c := w.c
+	if !c.IsActive() {
+		return 0, Exception(ErrConnClosed, "when write")
+	}
+	if timeout := time.Duration(atomic.LoadInt64(&c.writeTimeout)); timeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	n, err = c.Conn.Write(p)
+	if n > 0 {
+		c.stats.write(n)
+	}
+	if err != nil {
+		err = c.mapErr(err, ErrWriteTimeout)
+	}
+	// the buffered data is written by one Write when flushing
+	c.trace(TraceFlush, err)
+	return n, err
+}
+
+// mapErr converts the errors of net package to netpoll errors.
+func (c *stdConnection) mapErr(err, timeoutErr error) error {
+	var ne net.Error
+	switch {
+	case !c.IsActive() || errors.Is(err, net.ErrClosed):
+		return c.closeState.closedError(err.Error())
+	case errors.As(err, &ne) && ne.Timeout():
+		return Exception(timeoutErr, c.RemoteAddr().String())
+	}
+	return err
+}
+
+// info returns the ConnInfo of the connection, which is not served by any poller.
+func (c *stdConnection) info() ConnInfo {
+	return ConnInfo{
+		FD:          -1,
+		LocalAddr:   c.LocalAddr(),
+		RemoteAddr:  c.RemoteAddr(),
+		InputBytes:  c.reader.Len(),
+		OutputBytes: c.writer.buf.Len(),
+		IdleTime:    c.stats.idleTime(),
+		Poller:      -1,
+	}
+}
+
+// sysFd returns the socket handle of c, or -1 if it's not a socket.
+func sysFd(c interface{}) (fd int) {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return -1
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return -1
+	}
+	fd = -1
+	rc.Control(func(h uintptr) {
+		fd = int(h)
+	})
+	return fd
+}
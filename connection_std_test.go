@@ -0,0 +1,77 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWrapConn(t *testing.T) {
+	ln, err := net.Listen("tcp", getTestAddress())
+	MustNil(t, err)
+	defer ln.Close()
+	closed := make(chan struct{})
+	go func() {
+		conn, err := ln.Accept()
+		MustNil(t, err)
+		// the server echoes the lines by OnRequest over TLS
+		sconn := WrapConn(tls.Server(conn, newTestTLSConfig(t)))
+		MustNil(t, sconn.AddCloseCallback(func(Connection) error {
+			close(closed)
+			return nil
+		}))
+		MustNil(t, sconn.SetOnRequest(func(ctx context.Context, connection Connection) error {
+			line, err := connection.Reader().Until('\n')
+			if err != nil {
+				return err
+			}
+			_, err = connection.Writer().WriteBinary(line)
+			MustNil(t, err)
+			return connection.Writer().Flush()
+		}))
+	}()
+
+	tconn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	MustNil(t, err)
+	conn := WrapConn(tconn)
+	Equal(t, WrapConn(conn), conn)
+	Equal(t, conn.RemoteAddr().String(), ln.Addr().String())
+	MustNil(t, conn.SetReadTimeout(time.Second))
+	for i := 0; i < 3; i++ {
+		_, err = conn.Writer().WriteString("hello\n")
+		MustNil(t, err)
+		MustNil(t, conn.Writer().Flush())
+		line, err := conn.Reader().Until('\n')
+		MustNil(t, err)
+		Equal(t, string(line), "hello\n")
+	}
+	_, ok := conn.(TCPInfoProvider)
+	MustTrue(t, !ok)
+
+	// the server is closed once the client is closed
+	MustNil(t, conn.Close())
+	MustTrue(t, !conn.IsActive())
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("the server is not closed")
+	}
+}
@@ -17,9 +17,12 @@
 package netpoll
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"runtime"
@@ -568,6 +571,32 @@ func TestSetTCPNoDelay(t *testing.T) {
 	MustTrue(t, n == 0)
 }
 
+func TestConnectionSetNoDelay(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	ln, err := net.Listen(network, address)
+	MustNil(t, err)
+	defer ln.Close()
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	fd := conn.(Conn).Fd()
+	tuner := conn.(SocketTuner)
+	MustNil(t, tuner.SetNoDelay(false))
+	n, _ := syscall.GetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	Equal(t, n, 0)
+	MustNil(t, tuner.SetNoDelay(true))
+	n, _ = syscall.GetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	MustTrue(t, n > 0)
+
+	err = tuner.SetQuickAck(true)
+	if runtime.GOOS == "linux" {
+		MustNil(t, err)
+	} else {
+		MustTrue(t, errors.Is(err, ErrUnsupported))
+	}
+	MustNil(t, conn.Close())
+}
+
 func TestConnectionUntil(t *testing.T) {
 	r, w := GetSysFdPairs()
 	rconn, wconn := &connection{}, &connection{}
@@ -599,6 +628,663 @@ func TestConnectionUntil(t *testing.T) {
 	Assert(t, errors.Is(err, ErrEOF), err)
 }
 
+func TestConnectionUntilN(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	wconn.init(&netFD{fd: w}, nil)
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for _, s := range []string{"GET / HT", "TP/1.1\r\nHost: a\n", "0123456789"} {
+			wconn.Write([]byte(s))
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	line, err := ReadLine(rconn.Reader(), 32)
+	MustNil(t, err)
+	Equal(t, string(line), "GET / HTTP/1.1")
+	line, err = ReadLine(rconn.Reader(), 32)
+	MustNil(t, err)
+	Equal(t, string(line), "Host: a")
+	MustNil(t, rconn.Reader().Release())
+
+	// the delim is not found in max bytes, and the data is left unread
+	_, err = UntilN(rconn.Reader(), '\n', 8)
+	Assert(t, errors.Is(err, ErrLineTooLong), err)
+	Equal(t, rconn.Reader().Len(), 10)
+	// the writes must not be concurrent
+	<-written
+	_, err = wconn.Write([]byte("\n"))
+	MustNil(t, err)
+	_, err = UntilN(rconn.Reader(), '\n', 8)
+	Assert(t, errors.Is(err, ErrLineTooLong), err)
+	line, err = UntilN(rconn.Reader(), '\n', 11)
+	MustNil(t, err)
+	Equal(t, string(line), "0123456789\n")
+
+	rconn.Close()
+	wconn.Close()
+}
+
+func TestConnectionWritev(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	wconn.init(&netFD{fd: w}, &options{maxOutput: 16})
+
+	n, err := wconn.Writev([][]byte{[]byte("head"), make([]byte, 8), []byte("tail")})
+	MustNil(t, err)
+	Equal(t, n, 16)
+	MustNil(t, wconn.Flush())
+	buf, err := rconn.Reader().Next(16)
+	MustNil(t, err)
+	Equal(t, string(buf[:4]), "head")
+	Equal(t, string(buf[12:]), "tail")
+
+	_, err = wconn.Writev([][]byte{make([]byte, 10), make([]byte, 7)})
+	MustTrue(t, errors.Is(err, ErrWriteBufferFull))
+	wconn.Close()
+	_, err = wconn.Writev([][]byte{[]byte("closed")})
+	MustTrue(t, errors.Is(err, ErrConnClosed))
+	rconn.Close()
+}
+
+func TestConnectionFlushAsync(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, &options{maxInput: block1k})
+	wconn.init(&netFD{fd: w}, nil)
+
+	// sent at once
+	done := make(chan error, 1)
+	_, err := wconn.WriteString("hello")
+	MustNil(t, err)
+	MustNil(t, wconn.FlushAsync(func(err error) { done <- err }))
+	MustNil(t, <-done)
+
+	// queued until the reader consumes the data
+	size := 8 * 1024 * 1024
+	_, err = wconn.WriteBinary(make([]byte, size))
+	MustNil(t, err)
+	MustNil(t, wconn.FlushAsync(func(err error) { done <- err }))
+	Equal(t, len(done), 0)
+	MustTrue(t, errors.Is(wconn.Flush(), ErrConcurrentAccess))
+	_, err = rconn.Reader().Next(size + 5)
+	MustNil(t, err)
+	MustNil(t, <-done)
+	MustNil(t, rconn.Reader().Release())
+
+	// completed with the error once closed
+	_, err = wconn.WriteBinary(make([]byte, size))
+	MustNil(t, err)
+	MustNil(t, wconn.FlushAsync(func(err error) { done <- err }))
+	MustNil(t, wconn.Close())
+	MustTrue(t, errors.Is(<-done, ErrConnClosed))
+	rconn.Close()
+}
+
+func TestConnectionAutoFlush(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, &options{readTimeout: time.Second}))
+	MustNil(t, wconn.init(&netFD{fd: w}, &options{autoFlush: &autoFlushConfig{threshold: 8}}))
+
+	// flushed by size
+	_, err := wconn.WriteString("0123")
+	MustNil(t, err)
+	time.Sleep(20 * time.Millisecond)
+	Equal(t, rconn.Reader().Len(), 0)
+	_, err = wconn.WriteString("4567")
+	MustNil(t, err)
+	buf, err := rconn.Reader().Next(8)
+	MustNil(t, err)
+	Equal(t, string(buf), "01234567")
+
+	// flushed by time
+	MustNil(t, wconn.SetAutoFlush(0, 10*time.Millisecond))
+	MustNil(t, wconn.WriteByte('a'))
+	_, err = wconn.WriteString("bc")
+	MustNil(t, err)
+	buf, err = rconn.Reader().Next(3)
+	MustNil(t, err)
+	Equal(t, string(buf), "abc")
+
+	// the data allocated by Malloc is kept until MallocAck
+	buf, err = wconn.Malloc(3)
+	MustNil(t, err)
+	time.Sleep(30 * time.Millisecond)
+	copy(buf, "def")
+	Equal(t, rconn.Reader().Len(), 0)
+	MustNil(t, wconn.MallocAck(3))
+	buf, err = rconn.Reader().Next(3)
+	MustNil(t, err)
+	Equal(t, string(buf), "def")
+
+	// disabled
+	MustNil(t, wconn.SetAutoFlush(0, 0))
+	_, err = wconn.WriteString("ghi")
+	MustNil(t, err)
+	time.Sleep(30 * time.Millisecond)
+	Equal(t, rconn.Reader().Len(), 0)
+	MustNil(t, wconn.Flush())
+	buf, err = rconn.Reader().Next(3)
+	MustNil(t, err)
+	Equal(t, string(buf), "ghi")
+
+	rconn.Close()
+	wconn.Close()
+}
+
+func TestConnectionHeartbeat(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, &options{readTimeout: time.Second}))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+
+	var beats int32
+	MustNil(t, wconn.SetHeartbeat(10*time.Millisecond, func(conn Connection) {
+		atomic.AddInt32(&beats, 1)
+		_, err := conn.Writer().WriteString("ping")
+		MustNil(t, err)
+		MustNil(t, conn.Writer().Flush())
+	}))
+	buf, err := rconn.Reader().Next(12)
+	MustNil(t, err)
+	Equal(t, string(buf), "pingpingping")
+
+	// canceled by a non-positive interval
+	MustNil(t, wconn.SetHeartbeat(0, nil))
+	n := atomic.LoadInt32(&beats)
+	time.Sleep(30 * time.Millisecond)
+	Equal(t, atomic.LoadInt32(&beats), n)
+
+	// canceled once closed
+	MustNil(t, wconn.SetHeartbeat(10*time.Millisecond, func(conn Connection) {
+		atomic.AddInt32(&beats, 1)
+	}))
+	MustNil(t, wconn.Close())
+	time.Sleep(30 * time.Millisecond)
+	Equal(t, atomic.LoadInt32(&beats), n)
+	rconn.Close()
+}
+
+func TestConnectionRunOnLoop(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, nil))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+
+	// the tasks run in order without locks
+	var seq []int
+	done := make(chan struct{})
+	for i := 0; i < 100; i++ {
+		i := i
+		MustNil(t, rconn.RunOnLoop(func() {
+			seq = append(seq, i)
+			if i == 99 {
+				close(done)
+			}
+		}))
+	}
+	<-done
+	Equal(t, len(seq), 100)
+	for i, n := range seq {
+		Equal(t, n, i)
+	}
+
+	MustNil(t, rconn.Close())
+	MustTrue(t, errors.Is(rconn.RunOnLoop(func() {}), ErrConnClosed))
+	wconn.Close()
+}
+
+func TestConnectionSafeWrite(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, &options{readTimeout: time.Second}))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+
+	// each goroutine writes the records of its id and sequence
+	goroutines, records := 8, 100
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			record := make([]byte, 8)
+			for i := 0; i < records; i++ {
+				binary.BigEndian.PutUint32(record, uint32(g))
+				binary.BigEndian.PutUint32(record[4:], uint32(i))
+				MustNil(t, wconn.SafeWrite(record))
+				MustNil(t, wconn.SafeFlush())
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	next := make([]uint32, goroutines)
+	for i := 0; i < goroutines*records; i++ {
+		record, err := rconn.Reader().Next(8)
+		MustNil(t, err)
+		g, seq := binary.BigEndian.Uint32(record), binary.BigEndian.Uint32(record[4:])
+		Equal(t, seq, next[g])
+		next[g]++
+	}
+	MustNil(t, rconn.Reader().Release())
+	MustNil(t, wconn.Close())
+	MustNil(t, rconn.Close())
+}
+
+func TestConnectionReadContext(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, nil))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+
+	// unblocked once canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	MustNil(t, rconn.SetReadContext(ctx))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err := rconn.Reader().Next(1)
+	MustTrue(t, errors.Is(err, context.Canceled))
+
+	// the buffered data can still be read
+	_, err = wconn.WriteString("hello")
+	MustNil(t, err)
+	MustNil(t, wconn.Flush())
+	for rconn.Reader().Len() < 5 {
+		runtime.Gosched()
+	}
+	buf, err := rconn.Reader().Next(5)
+	MustNil(t, err)
+	Equal(t, string(buf), "hello")
+
+	// works together with the read timeout
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	MustNil(t, rconn.SetReadContext(ctx))
+	MustNil(t, rconn.SetReadTimeout(time.Second))
+	_, err = rconn.Reader().Next(1)
+	MustTrue(t, errors.Is(err, context.DeadlineExceeded))
+
+	// removed by nil
+	MustNil(t, rconn.SetReadContext(nil))
+	MustNil(t, rconn.SetReadTimeout(10*time.Millisecond))
+	_, err = rconn.Reader().Next(1)
+	MustTrue(t, errors.Is(err, ErrReadTimeout))
+
+	MustNil(t, wconn.Close())
+	MustNil(t, rconn.Close())
+}
+
+func TestConnectionCloseWithError(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, nil))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+	MustNil(t, rconn.Err())
+	done := rconn.Done()
+	select {
+	case <-done:
+		t.Fatal("done before closed")
+	default:
+	}
+
+	// the reason is visible to the blocked reads and the close callbacks
+	reason := errors.New("bad request")
+	var callbackErr error
+	MustNil(t, rconn.AddCloseCallback(func(connection Connection) error {
+		callbackErr = connection.(CloseNotifier).Err()
+		return nil
+	}))
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := rconn.Reader().Next(1)
+		readErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	MustNil(t, rconn.CloseWithError(reason))
+	err := <-readErr
+	MustTrue(t, errors.Is(err, ErrConnClosed) && errors.Is(err, reason))
+	<-done
+	Equal(t, callbackErr, reason)
+	Equal(t, rconn.Err(), reason)
+	<-rconn.Done()
+
+	// only the first reason is kept
+	MustNil(t, rconn.CloseWithError(errors.New("ignored")))
+	Equal(t, rconn.Err(), reason)
+
+	// closed by peer, the connection without OnRequest is fully closed by the user
+	for wconn.IsActive() {
+		runtime.Gosched()
+	}
+	MustTrue(t, errors.Is(wconn.Err(), ErrEOF))
+	MustNil(t, wconn.Close())
+	<-wconn.Done()
+	MustTrue(t, errors.Is(wconn.Err(), ErrEOF))
+}
+
+func TestConnectionCloseCallbackPriority(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, nil))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+
+	var order []int
+	add := func(id, priority int) *CloseCallbackHandle {
+		h, err := rconn.AddCloseCallbackWithPriority(func(Connection) error {
+			order = append(order, id)
+			return nil
+		}, priority)
+		MustNil(t, err)
+		return h
+	}
+	add(1, 0)
+	add(2, 0)
+	add(3, 10)
+	add(4, -10)
+	removed := add(5, 0)
+	MustTrue(t, removed.Remove())
+	MustTrue(t, !removed.Remove())
+	MustNil(t, rconn.AddCloseCallback(func(Connection) error {
+		order = append(order, 6)
+		return nil
+	}))
+
+	// the higher priority first, and the later added first for the same priority
+	MustNil(t, rconn.Close())
+	MustNil(t, rconn.Close())
+	Equal(t, fmt.Sprint(order), "[3 6 2 1 4]")
+
+	// no more callbacks once called
+	_, err := rconn.AddCloseCallbackWithPriority(func(Connection) error { return nil }, 0)
+	MustTrue(t, errors.Is(err, ErrConnClosed))
+	MustNil(t, wconn.Close())
+}
+
+func TestConnectionUserData(t *testing.T) {
+	type session struct{ id int }
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, nil))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+	Equal(t, rconn.UserData(), nil)
+
+	MustNil(t, rconn.SetUserData(&session{id: 1}))
+	MustNil(t, rconn.SetUserData(&session{id: 2}))
+	var got *session
+	MustNil(t, rconn.AddCloseCallback(func(connection Connection) error {
+		got, _ = connection.(UserDataHolder).UserData().(*session)
+		return nil
+	}))
+	MustNil(t, rconn.Close())
+	Equal(t, got.id, 2)
+
+	// values of different types can be set
+	MustNil(t, wconn.SetUserData("session"))
+	MustNil(t, wconn.SetUserData(nil))
+	Equal(t, wconn.UserData(), nil)
+	MustNil(t, wconn.Close())
+}
+
+func TestConnectionReadRateLimit(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, nil))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+	MustNil(t, rconn.SetReadRateLimit(10000, 1000))
+	send := func(size, expected int) time.Duration {
+		begin := time.Now()
+		_, err := wconn.WriteBinary(make([]byte, size))
+		MustNil(t, err)
+		MustNil(t, wconn.Flush())
+		for rconn.Reader().Len() < expected {
+			time.Sleep(time.Millisecond)
+		}
+		return time.Since(begin)
+	}
+
+	// the burst is read at once, and then the bucket runs out
+	send(1000, 1000)
+	send(1000, 2000)
+	// reading is paused until 1000 bytes are refilled
+	cost := send(1000, 3000)
+	MustTrue(t, cost >= 80*time.Millisecond)
+
+	// resumed at once after the limit is removed
+	send(1000, 4000)
+	MustNil(t, rconn.SetReadRateLimit(10, 1))
+	send(1000, 5000)
+	_, err := wconn.WriteBinary(make([]byte, 1000))
+	MustNil(t, err)
+	MustNil(t, wconn.Flush())
+	time.Sleep(10 * time.Millisecond)
+	Equal(t, rconn.Reader().Len(), 5000)
+	MustNil(t, rconn.SetReadRateLimit(0, 0))
+	for rconn.Reader().Len() < 6000 {
+		time.Sleep(time.Millisecond)
+	}
+
+	MustNil(t, wconn.Close())
+	MustNil(t, rconn.Close())
+}
+
+func TestConnectionWriteRateLimit(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, nil))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+	MustNil(t, wconn.SetWriteRateLimit(200*1024, 8*1024))
+	MustNil(t, wconn.SetWriteTimeout(time.Second))
+
+	// the burst is sent at once, and the rest is paced by the poller
+	begin := time.Now()
+	_, err := wconn.WriteBinary(make([]byte, 48*1024))
+	MustNil(t, err)
+	MustNil(t, wconn.Flush())
+	MustTrue(t, time.Since(begin) >= 150*time.Millisecond)
+	_, err = rconn.Reader().Next(48 * 1024)
+	MustNil(t, err)
+	Equal(t, wconn.Stats().BytesWritten, uint64(48*1024))
+
+	// sent at once after the limit is removed
+	MustNil(t, wconn.SetWriteRateLimit(0, 0))
+	begin = time.Now()
+	_, err = wconn.WriteBinary(make([]byte, 48*1024))
+	MustNil(t, err)
+	MustNil(t, wconn.Flush())
+	MustTrue(t, time.Since(begin) < 100*time.Millisecond)
+	_, err = rconn.Reader().Next(48 * 1024)
+	MustNil(t, err)
+
+	MustNil(t, wconn.Close())
+	MustNil(t, rconn.Close())
+}
+
+func TestConnectionSyscallConn(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn := &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, nil))
+	var sc syscall.Conn = rconn
+	raw, err := sc.SyscallConn()
+	MustNil(t, err)
+
+	// the socket options are set while the fd stays registered
+	var size int
+	MustNil(t, raw.Control(func(fd uintptr) {
+		MustNil(t, syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, 64*1024))
+		size, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	}))
+	MustNil(t, err)
+	MustTrue(t, size >= 64*1024)
+	MustTrue(t, errors.Is(raw.Read(func(uintptr) bool { return true }), ErrUnsupported))
+	MustTrue(t, errors.Is(raw.Write(func(uintptr) bool { return true }), ErrUnsupported))
+
+	MustNil(t, rconn.Close())
+	MustTrue(t, errors.Is(raw.Control(func(uintptr) {}), ErrConnClosed))
+	_, err = rconn.SyscallConn()
+	MustTrue(t, errors.Is(err, ErrConnClosed))
+	syscall.Close(w)
+}
+
+func TestConnectionNetConnCompat(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, &options{netConnCompat: true, maxInput: 4096}))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+	MustNil(t, wconn.SetNetConnCompat(true))
+	isTimeout := func(err error) bool {
+		var oe *net.OpError
+		return errors.As(err, &oe) && oe.Timeout() && errors.Is(err, os.ErrDeadlineExceeded)
+	}
+	buf := make([]byte, 16)
+
+	// setting the deadline wakes up the pending read
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		rconn.SetReadDeadline(time.Now())
+	}()
+	_, err := rconn.Read(buf)
+	MustTrue(t, isTimeout(err))
+
+	// the deadline passed fails the read even if the data is ready
+	_, err = wconn.Write([]byte("hello"))
+	MustNil(t, err)
+	for rconn.Reader().Len() < 5 {
+		runtime.Gosched()
+	}
+	_, err = rconn.Read(buf)
+	MustTrue(t, isTimeout(err))
+	MustNil(t, rconn.SetReadDeadline(time.Time{}))
+	n, err := rconn.Read(buf)
+	MustNil(t, err)
+	Equal(t, string(buf[:n]), "hello")
+
+	// extending the deadline keeps the read waiting
+	MustNil(t, rconn.SetReadDeadline(time.Now().Add(20*time.Millisecond)))
+	written := make(chan error)
+	go func() {
+		rconn.SetReadDeadline(time.Now().Add(time.Hour))
+		time.Sleep(50 * time.Millisecond)
+		_, err := wconn.Write([]byte("world"))
+		written <- err
+	}()
+	n, err = rconn.Read(buf)
+	MustNil(t, err)
+	Equal(t, string(buf[:n]), "world")
+	MustNil(t, <-written)
+
+	// setting the deadline wakes up the pending write
+	MustNil(t, syscall.SetsockoptInt(w, syscall.SOL_SOCKET, syscall.SO_SNDBUF, 4096))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		wconn.SetWriteDeadline(time.Now())
+	}()
+	_, err = wconn.Write(make([]byte, 8*1024*1024))
+	MustTrue(t, isTimeout(err))
+	_, err = wconn.Write([]byte("!"))
+	MustTrue(t, isTimeout(err))
+
+	// io.EOF is returned once the peer is closed, and net.ErrClosed after closing
+	MustNil(t, wconn.Close())
+	for {
+		if _, err = rconn.Read(buf); err != nil {
+			break
+		}
+	}
+	Equal(t, err, io.EOF)
+	MustNil(t, rconn.Close())
+	_, err = rconn.Read(buf)
+	var oe *net.OpError
+	MustTrue(t, errors.As(err, &oe) && errors.Is(err, net.ErrClosed))
+}
+
+func TestConnectionZeroCopy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+	conn, err := DialConnection("tcp", ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	peer, err := ln.Accept()
+	MustNil(t, err)
+	defer peer.Close()
+
+	err = conn.(SocketTuner).SetZeroCopy(4096)
+	if runtime.GOOS != "linux" {
+		Assert(t, errors.Is(err, ErrUnsupported), err)
+		return
+	}
+	MustNil(t, err)
+
+	// the large writes are sent by MSG_ZEROCOPY, and the small ones are copied
+	data := make([]byte, 1<<20)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	go func() {
+		for _, size := range []int{100, len(data)} {
+			buf, _ := conn.Writer().Malloc(size)
+			copy(buf, data[:size])
+			conn.Writer().Flush()
+		}
+	}()
+	got := make([]byte, 100+len(data))
+	_, err = io.ReadFull(peer, got)
+	MustNil(t, err)
+	Equal(t, string(got[:100]), string(data[:100]))
+	Equal(t, string(got[100:]), string(data))
+
+	// the sent data is released once all the sends are notified completed
+	c := &conn.(*TCPConnection).connection
+	zc := c.zeroCopy.Load()
+	for i := 0; i < 100 && c.zeroCopyPending(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	zc.mu.Lock()
+	sent, done := zc.sent, zc.done
+	zc.mu.Unlock()
+	Assert(t, sent > 0 && sent == done, sent, done)
+
+	// disabled by non-positive threshold
+	MustNil(t, conn.(SocketTuner).SetZeroCopy(0))
+	Assert(t, !c.useZeroCopy([][]byte{data}))
+}
+
+func TestConnectionStats(t *testing.T) {
+	enableStats(t)
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, &options{readTimeout: time.Second}))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+	stats := rconn.Stats()
+	Equal(t, stats.BytesRead, uint64(0))
+	Equal(t, stats.Reads, uint64(0))
+	MustTrue(t, stats.LastActive.Equal(stats.CreatedAt))
+
+	time.Sleep(time.Millisecond)
+	_, err := wconn.WriteString("hello")
+	MustNil(t, err)
+	MustNil(t, wconn.Flush())
+	_, err = rconn.Reader().Next(5)
+	MustNil(t, err)
+
+	wstats, rstats := wconn.Stats(), rconn.Stats()
+	Equal(t, wstats.BytesWritten, uint64(5))
+	Equal(t, wstats.Writes, uint64(1))
+	Equal(t, wstats.BytesRead, uint64(0))
+	Equal(t, rstats.BytesRead, uint64(5))
+	Equal(t, rstats.Reads, uint64(1))
+	MustTrue(t, rstats.LastActive.After(rstats.CreatedAt))
+	MustNil(t, wconn.Close())
+	MustNil(t, rconn.Close())
+}
+
 func TestBookSizeLargerThanMaxSize(t *testing.T) {
 	r, w := GetSysFdPairs()
 	rconn, wconn := &connection{}, &connection{}
@@ -925,3 +1611,364 @@ func TestConnectionDailTimeoutAndClose(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestConnectionSendFile(t *testing.T) {
+	size := 8 * 1024 * 1024 // larger than socket buffer to wait for writable
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	f, err := os.CreateTemp(t.TempDir(), "sendfile")
+	MustNil(t, err)
+	defer f.Close()
+	_, err = f.Write(content)
+	MustNil(t, err)
+
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, nil))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// the buffered data must be sent first
+		_, err := wconn.Writer().WriteString("head")
+		MustNil(t, err)
+		n, err := wconn.SendFile(f, 0, 0)
+		MustNil(t, err)
+		Equal(t, n, int64(size))
+		n, err = wconn.SendFile(f, 10, 10)
+		MustNil(t, err)
+		Equal(t, n, int64(10))
+		// the file ends before n bytes sent
+		n, err = wconn.SendFile(f, int64(size-5), 10)
+		Equal(t, err, io.EOF)
+		Equal(t, n, int64(5))
+	}()
+
+	buf, err := rconn.Reader().Next(4)
+	MustNil(t, err)
+	Equal(t, string(buf), "head")
+	buf, err = rconn.Reader().Next(size)
+	MustNil(t, err)
+	MustTrue(t, bytes.Equal(buf, content))
+	buf, err = rconn.Reader().Next(10)
+	MustNil(t, err)
+	MustTrue(t, bytes.Equal(buf, content[10:20]))
+	buf, err = rconn.Reader().Next(5)
+	MustNil(t, err)
+	MustTrue(t, bytes.Equal(buf, content[size-5:]))
+	MustNil(t, rconn.Reader().Release())
+	<-done
+
+	rconn.Close()
+	_, err = wconn.SendFile(f, 0, 0)
+	Assert(t, err != nil)
+	wconn.Close()
+}
+
+func TestConnectionMallocSize(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, &options{bufferSize: block32k}))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+	Equal(t, rconn.bookSize, block32k)
+	Equal(t, rconn.inputBuffer.nodeSize(1), block32k)
+	Equal(t, rconn.outputBuffer.nodeSize(1), block32k)
+
+	MustNil(t, wconn.SetMallocSize(block32k))
+	_, err := wconn.Writer().Malloc(1)
+	MustNil(t, err)
+	MustTrue(t, cap(wconn.outputBuffer.write.buf) >= block32k)
+	MustNil(t, wconn.Writer().Flush())
+	buf, err := rconn.Reader().Next(1)
+	MustNil(t, err)
+	Equal(t, len(buf), 1)
+	MustTrue(t, cap(rconn.inputBuffer.read.buf) >= block32k)
+
+	rconn.Close()
+	wconn.Close()
+}
+
+func TestConnectionMaxInputBuffer(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, &options{maxInput: block1k}))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+
+	size := block64k
+	msg := make([]byte, size)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	_, err := wconn.Write(msg)
+	MustNil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	// the poller stops reading once the limit is exceeded
+	length := rconn.Reader().Len()
+	MustTrue(t, length >= block1k && length < size)
+	Equal(t, atomic.LoadInt32(&rconn.readPaused), int32(1))
+	time.Sleep(10 * time.Millisecond)
+	Equal(t, rconn.Reader().Len(), length)
+
+	// consuming the data resumes reading, and reading more than the limit at once is allowed
+	buf, err := rconn.Reader().Next(size)
+	MustNil(t, err)
+	MustTrue(t, bytes.Equal(buf, msg))
+	MustNil(t, rconn.Reader().Release())
+	Equal(t, atomic.LoadInt32(&rconn.readPaused), int32(0))
+
+	// removing the limit resumes reading as well
+	_, err = wconn.Write(msg)
+	MustNil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	Equal(t, atomic.LoadInt32(&rconn.readPaused), int32(1))
+	MustNil(t, rconn.SetMaxInputBuffer(0))
+	buf, err = rconn.Reader().Next(size)
+	MustNil(t, err)
+	MustTrue(t, bytes.Equal(buf, msg))
+
+	rconn.Close()
+	wconn.Close()
+}
+
+func TestConnectionReadWatermark(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	var lengths []int
+	requests := make(chan struct{}, 16)
+	MustNil(t, rconn.init(&netFD{fd: r}, &options{
+		readWatermark: 4,
+		onRequest: func(ctx context.Context, connection Connection) error {
+			lengths = append(lengths, connection.Reader().Len())
+			connection.Reader().Skip(connection.Reader().Len())
+			connection.Reader().Release()
+			requests <- struct{}{}
+			return nil
+		},
+	}))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+
+	// OnRequest is not called until 4 bytes are buffered
+	for i := 0; i < 4; i++ {
+		_, err := wconn.Write([]byte{byte(i)})
+		MustNil(t, err)
+		time.Sleep(10 * time.Millisecond)
+	}
+	<-requests
+	Equal(t, len(lengths), 1)
+	Equal(t, lengths[0], 4)
+
+	// lowering the watermark calls OnRequest for the buffered data
+	_, err := wconn.Write([]byte{0, 1})
+	MustNil(t, err)
+	time.Sleep(10 * time.Millisecond)
+	Equal(t, len(requests), 0)
+	MustNil(t, rconn.SetReadWatermark(0))
+	<-requests
+	Equal(t, lengths[1], 2)
+
+	rconn.Close()
+	wconn.Close()
+}
+
+func TestConnectionMaxOutputBuffer(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, nil))
+	MustNil(t, wconn.init(&netFD{fd: w}, &options{maxOutput: 16}))
+
+	_, err := wconn.Writer().WriteString("0123456789")
+	MustNil(t, err)
+	_, err = wconn.Writer().WriteString("0123456789")
+	MustTrue(t, errors.Is(err, ErrWriteBufferFull))
+	_, err = wconn.Writer().Malloc(7)
+	MustTrue(t, errors.Is(err, ErrWriteBufferFull))
+	err = wconn.Writer().WriteByte('a')
+	MustNil(t, err)
+	MustNil(t, wconn.Writer().Flush())
+	buf, err := rconn.Reader().Next(11)
+	MustNil(t, err)
+	Equal(t, string(buf), "0123456789a")
+
+	// the flushed data is sent, so there is room for more
+	_, err = wconn.Write(make([]byte, 16))
+	MustNil(t, err)
+	_, err = wconn.Write(make([]byte, 17))
+	MustTrue(t, errors.Is(err, ErrWriteBufferFull))
+
+	// the limit is removed
+	MustNil(t, wconn.SetMaxOutputBuffer(0))
+	_, err = wconn.Write(make([]byte, 17))
+	MustNil(t, err)
+
+	rconn.Close()
+	wconn.Close()
+}
+
+func TestConnectionMemoryLimit(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	pressure := make(chan int64, 1)
+	// the buffers in use always exceed the limit
+	budget := newMemoryBudget(1, func(inUse, limit int64) {
+		Equal(t, limit, int64(1))
+		pressure <- inUse
+	})
+	MustNil(t, rconn.init(&netFD{fd: r}, &options{budget: budget}))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+
+	size := block64k
+	msg := make([]byte, size)
+	_, err := wconn.Write(msg)
+	MustNil(t, err)
+	select {
+	case inUse := <-pressure:
+		MustTrue(t, inUse > 1)
+	case <-time.After(time.Second):
+		t.Fatal("OnMemoryPressure is not called")
+	}
+	time.Sleep(10 * time.Millisecond)
+	Equal(t, atomic.LoadInt32(&rconn.readPaused), int32(1))
+	MustTrue(t, rconn.Reader().Len() < size)
+
+	// consuming the data resumes reading
+	_, err = rconn.Reader().Next(size)
+	MustNil(t, err)
+	MustNil(t, rconn.Reader().Release())
+	Equal(t, atomic.LoadInt32(&rconn.readPaused), int32(0))
+	// OnMemoryPressure is called once until recovered
+	select {
+	case <-pressure:
+		t.Fatal("OnMemoryPressure is called again")
+	default:
+	}
+
+	rconn.Close()
+	wconn.Close()
+}
+
+func TestConnectionSendFDs(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r, network: "unix"}, nil))
+	MustNil(t, wconn.init(&netFD{fd: w, network: "unix"}, nil))
+	defer rconn.Close()
+	defer wconn.Close()
+
+	var pipe [2]int
+	MustNil(t, syscall.Pipe(pipe[:]))
+	defer syscall.Close(pipe[1])
+
+	// the buffered data must be sent first
+	_, err := wconn.Writer().WriteString("head")
+	MustNil(t, err)
+	MustNil(t, wconn.SendFDs([]int{pipe[0]}))
+	MustNil(t, syscall.Close(pipe[0]))
+	buf, err := rconn.Reader().Next(5)
+	MustNil(t, err)
+	Equal(t, string(buf), "head\x00")
+	fds := rconn.ReceiveFDs()
+	Equal(t, len(fds), 1)
+	Equal(t, len(rconn.ReceiveFDs()), 0)
+
+	// the received fd refers to the same pipe
+	_, err = syscall.Write(pipe[1], []byte("ping"))
+	MustNil(t, err)
+	n, err := syscall.Read(fds[0], make([]byte, 8))
+	MustNil(t, err)
+	Equal(t, n, 4)
+	syscall.Close(fds[0])
+
+	if runtime.GOOS == "linux" {
+		cred, err := rconn.PeerCredentials()
+		MustNil(t, err)
+		Equal(t, int(cred.Pid), os.Getpid())
+		Equal(t, int(cred.Uid), os.Getuid())
+	}
+
+	// non-unix connection
+	rfd, wfd := GetSysFdPairs()
+	conn := &connection{}
+	MustNil(t, conn.init(&netFD{fd: rfd}, nil))
+	defer conn.Close()
+	defer syscall.Close(wfd)
+	Assert(t, errors.Is(conn.SendFDs([]int{wfd}), ErrUnsupported))
+	_, err = conn.PeerCredentials()
+	Assert(t, errors.Is(err, ErrUnsupported))
+}
+
+func TestConnectionCloseWrite(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	ln, err := net.Listen(network, address)
+	MustNil(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		MustNil(t, err)
+		defer conn.Close()
+		// read the request until EOF
+		req, err := io.ReadAll(conn)
+		MustNil(t, err)
+		_, err = conn.Write(append(req, "-pong"...))
+		MustNil(t, err)
+	}()
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn.Writer().WriteString("ping")
+	MustNil(t, err)
+	MustNil(t, conn.Writer().Flush())
+	MustNil(t, conn.(HalfCloser).CloseWrite())
+	_, err = conn.Write([]byte("ping"))
+	MustTrue(t, err != nil)
+
+	// the response can still be read until EOF
+	buf, err := conn.Reader().Next(9)
+	MustNil(t, err)
+	Equal(t, string(buf), "ping-pong")
+	_, err = conn.Reader().Next(1)
+	MustTrue(t, errors.Is(err, ErrEOF))
+	MustNil(t, conn.Close())
+}
+
+func TestConnectionCloseRead(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	ln, err := net.Listen(network, address)
+	MustNil(t, err)
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		MustNil(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("hello"))
+		MustNil(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		MustNil(t, err)
+		received <- string(buf)
+	}()
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	buf, err := conn.Reader().Peek(5)
+	MustNil(t, err)
+	Equal(t, string(buf), "hello")
+	MustNil(t, conn.(HalfCloser).CloseRead())
+
+	// the buffered data can still be read, then EOF
+	buf, err = conn.Reader().Next(5)
+	MustNil(t, err)
+	Equal(t, string(buf), "hello")
+	_, err = conn.Reader().Next(1)
+	MustTrue(t, errors.Is(err, ErrEOF))
+
+	// the connection can still be written to
+	MustTrue(t, conn.IsActive())
+	_, err = conn.Write([]byte("ping"))
+	MustNil(t, err)
+	Equal(t, <-received, "ping")
+	MustNil(t, conn.Close())
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// connTimer calls fire repeatedly by the timer wheel, so that no goroutine is occupied while waiting.
+type connTimer struct {
+	mu      sync.Mutex
+	timer   *Timer
+	stopped bool
+}
+
+// startTimer calls fire after d, and then after the duration returned by fire, until fire returns false
+// or the timer is stopped. fire is run by the global runner instead of the poller, since it calls the user
+// functions which may block.
+func startTimer(w *timerWheel, d time.Duration, fire func() (next time.Duration, ok bool)) *connTimer {
+	t := &connTimer{}
+	t.mu.Lock()
+	t.timer = w.afterFunc(d, 0, func() {
+		runTask(context.Background(), nil, func() {
+			next, ok := fire()
+			t.mu.Lock()
+			if ok && !t.stopped {
+				t.timer.reset(next)
+			}
+			t.mu.Unlock()
+		})
+	})
+	t.mu.Unlock()
+	return t
+}
+
+func (t *connTimer) stop() {
+	t.mu.Lock()
+	t.stopped = true
+	t.timer.Stop()
+	t.mu.Unlock()
+}
+
+// watchIdle calls onIdle once conn has not read or written any data for the timeout, see WithOnIdle.
+// It's stopped once conn is closed.
+func watchIdle(w *timerWheel, conn Connection, stats *connStats, timeout time.Duration, onIdle OnIdle) {
+	stats.track()
+	t := startTimer(w, timeout, func() (time.Duration, bool) {
+		if !conn.IsActive() {
+			return 0, false
+		}
+		if idle := stats.idleTime(); idle < timeout {
+			return timeout - idle, true
+		}
+		if !onIdle(conn) {
+			conn.Close()
+			return 0, false
+		}
+		return timeout, true
+	})
+	conn.AddCloseCallback(func(Connection) error {
+		t.stop()
+		return nil
+	})
+}
+
+// heartbeat calls the function set by Heartbeater.SetHeartbeat at a fixed interval.
+type heartbeat struct {
+	mu    sync.Mutex
+	timer *connTimer
+}
+
+// set replaces the heartbeat of conn scheduled by w, the previous one is stopped.
+func (h *heartbeat) set(w *timerWheel, conn Connection, interval time.Duration, fn func(connection Connection)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.timer != nil {
+		h.timer.stop()
+		h.timer = nil
+	}
+	if interval <= 0 || fn == nil || !conn.IsActive() {
+		return
+	}
+	h.timer = startTimer(w, interval, func() (time.Duration, bool) {
+		if !conn.IsActive() {
+			return 0, false
+		}
+		fn(conn)
+		return interval, true
+	})
+}
+
+// stop is called once the connection is closed.
+func (h *heartbeat) stop() {
+	h.mu.Lock()
+	if h.timer != nil {
+		h.timer.stop()
+		h.timer = nil
+	}
+	h.mu.Unlock()
+}
@@ -0,0 +1,490 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// TLSServer returns a new TLS server side Connection using conn as the transport.
+// It is safe to call TLSServer inside OnPrepare, the handshake is run by the first task scheduled for the
+// connection, and it's completed before the OnConnect and OnRequest set on the returned Connection are called.
+// Otherwise, it's performed lazily on the first read or write.
+//
+// The handshake of crypto/tls blocks until the next flight of the peer arrives, so it cannot run on the
+// poller goroutine itself, or a slow client would stall all the connections of the poller.
+func TLSServer(conn Connection, config *tls.Config) (Connection, error) {
+	c := rawConnection(conn)
+	if c == nil {
+		return nil, Exception(ErrUnsupported, "TLS over non-netpoll connection")
+	}
+	return newTLSConnection(c, tls.Server(&tlsTransport{connection: c}, config)), nil
+}
+
+// TLSClient returns a new TLS client side Connection using conn as the transport.
+// Unlike TLSServer, the handshake is completed by the dialing goroutine before TLSClient returns,
+// just like the connecting of DialConnection, and conn will be closed if the handshake failed.
+func TLSClient(conn Connection, config *tls.Config) (Connection, error) {
+	c := rawConnection(conn)
+	if c == nil {
+		return nil, Exception(ErrUnsupported, "TLS over non-netpoll connection")
+	}
+	tc := newTLSConnection(c, tls.Client(&tlsTransport{connection: c}, config))
+	if err := tc.tc.Handshake(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// rawConnection returns the *connection behind conn, or nil if conn is not created by netpoll.
+func rawConnection(conn Connection) *connection {
+	switch c := conn.(type) {
+	case *connection:
+		return c
+	case *TCPConnection:
+		return &c.connection
+	case *UnixConnection:
+		return &c.connection
+	}
+	return nil
+}
+
+// tlsRecordHeaderLen is the length of the TLS record header, whose last 2 bytes are the length of the record.
+const tlsRecordHeaderLen = 5
+
+// tlsTransport is the transport of crypto/tls, which reads the TLS records one by one from the connection.
+// crypto/tls reads the underlying connection as much as possible, so it may buffer the records following the
+// handshake internally, which would never trigger OnRequest since the input buffer of the connection is empty.
+type tlsTransport struct {
+	*connection
+	remain int // the length of the current record which is not read yet
+}
+
+// Read reads the current record only.
+func (t *tlsTransport) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if t.remain == 0 {
+		hdr, err := t.connection.Peek(tlsRecordHeaderLen)
+		if err != nil {
+			// crypto/tls reports the truncated record
+			return t.connection.Read(p)
+		}
+		t.remain = tlsRecordHeaderLen + int(binary.BigEndian.Uint16(hdr[3:]))
+	}
+	if len(p) > t.remain {
+		p = p[:t.remain]
+	}
+	n, err = t.connection.Read(p)
+	t.remain -= n
+	return n, err
+}
+
+// tlsConnection implements Connection over the application data of a TLS session.
+//
+// The underlying connection is only used to transport TLS records, the decrypted
+// data is buffered by a zcReader so that the nocopy Reader API still works.
+type tlsConnection struct {
+	*connection
+	tc     *tls.Conn
+	reader *zcReader
+	writer *zcWriter
+
+	readWatermark int64 // the watermark of the decrypted data, see SetReadWatermark
+}
+
+var (
+	_ Connection = &tlsConnection{}
+	_ Reader     = &tlsConnection{}
+	_ Writer     = &tlsConnection{}
+
+	_ BinaryToReader = &tlsConnection{}
+	_ UntilNReader   = &tlsConnection{}
+	_ PeekVecReader  = &tlsConnection{}
+	_ VecWriter      = &tlsConnection{}
+
+	_ SocketConn                 = &tlsConnection{}
+	_ BufferTuner                = &tlsConnection{}
+	_ HalfCloser                 = &tlsConnection{}
+	_ SocketTuner                = &tlsConnection{}
+	_ AsyncFlusher               = &tlsConnection{}
+	_ AutoFlusher                = &tlsConnection{}
+	_ StatsProvider              = &tlsConnection{}
+	_ Heartbeater                = &tlsConnection{}
+	_ LoopRunner                 = &tlsConnection{}
+	_ ConcurrentWriter           = &tlsConnection{}
+	_ ReadContextSetter          = &tlsConnection{}
+	_ CloseNotifier              = &tlsConnection{}
+	_ PriorityCloseCallbackAdder = &tlsConnection{}
+	_ UserDataHolder             = &tlsConnection{}
+	_ RateLimiter                = &tlsConnection{}
+	_ TCPInfoProvider            = &tlsConnection{}
+	_ NetConnCompatSetter        = &tlsConnection{}
+	_ pollConnection             = &tlsConnection{}
+)
+
+func newTLSConnection(c *connection, tc *tls.Conn) *tlsConnection {
+	return &tlsConnection{
+		connection: c,
+		tc:         tc,
+		reader:     newZCReader(tc),
+		writer:     newZCWriter(tc),
+	}
+}
+
+// handshake completes the handshake before the callbacks are called, the connection is closed if it failed.
+// It returns immediately once the handshake has been completed.
+func (c *tlsConnection) handshake(ctx context.Context) error {
+	err := c.tc.HandshakeContext(ctx)
+	if err != nil {
+		c.connection.CloseWithError(err)
+	}
+	return err
+}
+
+// ConnectionState returns basic TLS details about the connection.
+func (c *tlsConnection) ConnectionState() tls.ConnectionState {
+	return c.tc.ConnectionState()
+}
+
+// EnableKernelTLS is unsupported since crypto/tls doesn't export the traffic keys of the session,
+// the sessions established by the other TLS implementations can be handed to the kernel by the raw connection.
+func (c *tlsConnection) EnableKernelTLS(params KernelTLSParams) error {
+	return Exception(ErrUnsupported, "EnableKernelTLS on crypto/tls connection")
+}
+
+// Reader implements Connection.
+func (c *tlsConnection) Reader() Reader {
+	return c
+}
+
+// Writer implements Connection.
+func (c *tlsConnection) Writer() Writer {
+	return c
+}
+
+// SetMallocSize implements BufferTuner.
+func (c *tlsConnection) SetMallocSize(size int) error {
+	if size < 0 {
+		size = 0
+	}
+	c.reader.buf.setBlockSize(size)
+	c.writer.buf.setBlockSize(size)
+	return c.connection.SetMallocSize(size)
+}
+
+// SetMaxOutputBuffer implements BufferTuner.
+// Only the plaintext buffered by the Writer is limited, since the TLS records cannot be partially written.
+func (c *tlsConnection) SetMaxOutputBuffer(size int) error {
+	c.writer.setMaxSize(size)
+	return nil
+}
+
+// SetAutoFlush is unsupported since the plaintext must be encrypted by the goroutine writing it.
+func (c *tlsConnection) SetAutoFlush(threshold int, interval time.Duration) error {
+	return Exception(ErrUnsupported, "SetAutoFlush on TLS connection")
+}
+
+// SafeWrite implements ConcurrentWriter, the plaintext is buffered by the Writer of the TLS connection.
+func (c *tlsConnection) SafeWrite(p []byte) error {
+	return c.safe.write(c, p)
+}
+
+// SafeFlush implements ConcurrentWriter.
+func (c *tlsConnection) SafeFlush() error {
+	return c.safe.flush(c)
+}
+
+// SetHeartbeat implements Heartbeater, fn is called with the TLS connection.
+func (c *tlsConnection) SetHeartbeat(interval time.Duration, fn func(connection Connection)) error {
+	c.heartbeat.set(c.wheel(), c, interval, fn)
+	return nil
+}
+
+// SetReadWatermark implements BufferTuner.
+// The watermark applies to the decrypted data, the TLS records are always decrypted once received.
+func (c *tlsConnection) SetReadWatermark(n int) error {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt64(&c.readWatermark, int64(n))
+	return nil
+}
+
+// SetOnConnect set the OnConnect callback.
+func (c *tlsConnection) SetOnConnect(onConnect OnConnect) error {
+	if onConnect == nil {
+		return nil
+	}
+	return c.connection.SetOnConnect(func(ctx context.Context, _ Connection) context.Context {
+		if c.handshake(ctx) != nil {
+			return ctx
+		}
+		return onConnect(ctx, c)
+	})
+}
+
+// SetOnDisconnect set the OnDisconnect callback.
+func (c *tlsConnection) SetOnDisconnect(onDisconnect OnDisconnect) error {
+	if onDisconnect == nil {
+		return nil
+	}
+	return c.connection.SetOnDisconnect(func(ctx context.Context, _ Connection) {
+		onDisconnect(ctx, c)
+	})
+}
+
+// SetOnRequest implements Connection.
+// OnRequest will only be called when there is decrypted data to be read after the handshake.
+func (c *tlsConnection) SetOnRequest(onRequest OnRequest) error {
+	if onRequest == nil {
+		return nil
+	}
+	return c.connection.SetOnRequest(func(ctx context.Context, _ Connection) error {
+		if err := c.handshake(ctx); err != nil {
+			return err
+		}
+		// decrypt all the buffered records first, or the processing loop will never exit.
+		for c.connection.Len() > 0 {
+			if err := c.reader.fill(c.reader.Len() + 1); err != nil {
+				return err
+			}
+		}
+		for c.reader.Len() > 0 && c.reader.Len() >= int(atomic.LoadInt64(&c.readWatermark)) && c.IsActive() {
+			if err := onRequest(ctx, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AddCloseCallback implements Connection.
+func (c *tlsConnection) AddCloseCallback(callback CloseCallback) error {
+	_, err := c.AddCloseCallbackWithPriority(callback, 0)
+	return err
+}
+
+// AddCloseCallbackWithPriority implements PriorityCloseCallbackAdder, the callback receives the TLS connection.
+func (c *tlsConnection) AddCloseCallbackWithPriority(callback CloseCallback, priority int) (*CloseCallbackHandle, error) {
+	if callback == nil {
+		return nil, nil
+	}
+	return c.connection.AddCloseCallbackWithPriority(func(Connection) error {
+		return callback(c)
+	}, priority)
+}
+
+// SendFDs is unsupported since the zero byte sent along with the fds would break the TLS records.
+func (c *tlsConnection) SendFDs(fds []int) error {
+	return Exception(ErrUnsupported, "SendFDs on TLS connection")
+}
+
+// CloseWrite sends a close_notify alert and shuts down the writing side of the underlying connection.
+func (c *tlsConnection) CloseWrite() error {
+	if err := c.tc.CloseWrite(); err != nil {
+		return err
+	}
+	return c.connection.CloseWrite()
+}
+
+// Close sends a close_notify alert and closes the underlying connection.
+func (c *tlsConnection) Close() error {
+	return c.tc.Close()
+}
+
+// CloseWithError implements CloseNotifier.
+func (c *tlsConnection) CloseWithError(err error) error {
+	c.closeState.setReason(err)
+	return c.Close()
+}
+
+// ------------------------------------------ implement zero-copy reader ------------------------------------------
+
+// Next implements Connection.
+func (c *tlsConnection) Next(n int) (p []byte, err error) {
+	return c.reader.Next(n)
+}
+
+// Peek implements Connection.
+func (c *tlsConnection) Peek(n int) (buf []byte, err error) {
+	return c.reader.Peek(n)
+}
+
+// PeekVec implements PeekVecReader.
+func (c *tlsConnection) PeekVec(n int) (vs [][]byte, err error) {
+	return c.reader.PeekVec(n)
+}
+
+// Skip implements Connection.
+func (c *tlsConnection) Skip(n int) (err error) {
+	return c.reader.Skip(n)
+}
+
+// Release implements Connection.
+func (c *tlsConnection) Release() (err error) {
+	return c.reader.Release()
+}
+
+// Slice implements Connection.
+func (c *tlsConnection) Slice(n int) (r Reader, err error) {
+	return c.reader.Slice(n)
+}
+
+// Len implements Connection.
+func (c *tlsConnection) Len() (length int) {
+	return c.reader.Len()
+}
+
+// Until implements Connection.
+func (c *tlsConnection) Until(delim byte) (line []byte, err error) {
+	return c.reader.Until(delim)
+}
+
+// UntilN implements UntilNReader.
+func (c *tlsConnection) UntilN(delim byte, max int) (line []byte, err error) {
+	return c.reader.UntilN(delim, max)
+}
+
+// ReadString implements Connection.
+func (c *tlsConnection) ReadString(n int) (s string, err error) {
+	return c.reader.ReadString(n)
+}
+
+// ReadBinary implements Connection.
+func (c *tlsConnection) ReadBinary(n int) (p []byte, err error) {
+	return c.reader.ReadBinary(n)
+}
+
+// ReadBinaryTo implements BinaryToReader.
+func (c *tlsConnection) ReadBinaryTo(p []byte) (n int, err error) {
+	return c.reader.ReadBinaryTo(p)
+}
+
+// PeekTo implements BinaryToReader.
+func (c *tlsConnection) PeekTo(p []byte) (n int, err error) {
+	return c.reader.PeekTo(p)
+}
+
+// ReadByte implements Connection.
+func (c *tlsConnection) ReadByte() (b byte, err error) {
+	return c.reader.ReadByte()
+}
+
+// ------------------------------------------ implement zero-copy writer ------------------------------------------
+
+// Malloc implements Connection.
+func (c *tlsConnection) Malloc(n int) (buf []byte, err error) {
+	if !c.IsActive() {
+		return nil, Exception(ErrConnClosed, "when malloc")
+	}
+	return c.writer.Malloc(n)
+}
+
+// MallocLen implements Connection.
+func (c *tlsConnection) MallocLen() (length int) {
+	return c.writer.MallocLen()
+}
+
+// Flush encrypts all malloc data and sends it by the underlying connection.
+func (c *tlsConnection) Flush() error {
+	if !c.IsActive() {
+		return Exception(ErrConnClosed, "when flush")
+	}
+	return c.writer.Flush()
+}
+
+// FlushAsync implements AsyncFlusher, the data is flushed synchronously since it must be encrypted first.
+func (c *tlsConnection) FlushAsync(callback func(err error)) error {
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	callback(nil)
+	return nil
+}
+
+// SendFile implements SocketConn.
+// The file is always copied by the output buffer since the data must be encrypted.
+func (c *tlsConnection) SendFile(f *os.File, off, n int64) (written int64, err error) {
+	if !c.IsActive() {
+		return 0, Exception(ErrConnClosed, "when sendfile")
+	}
+	if n, err = sendFileSize(f, off, n); err != nil {
+		return 0, err
+	}
+	return copyFile(c.writer, c.writer.Flush, f, off, n)
+}
+
+// MallocAck implements Connection.
+func (c *tlsConnection) MallocAck(n int) (err error) {
+	return c.writer.MallocAck(n)
+}
+
+// Append implements Connection.
+func (c *tlsConnection) Append(w Writer) (err error) {
+	return c.writer.Append(w)
+}
+
+// WriteString implements Connection.
+func (c *tlsConnection) WriteString(s string) (n int, err error) {
+	return c.writer.WriteString(s)
+}
+
+// WriteBinary implements Connection.
+func (c *tlsConnection) WriteBinary(b []byte) (n int, err error) {
+	return c.writer.WriteBinary(b)
+}
+
+// Writev implements VecWriter.
+func (c *tlsConnection) Writev(bs [][]byte) (n int, err error) {
+	return c.writer.Writev(bs)
+}
+
+// WriteDirect implements Connection.
+func (c *tlsConnection) WriteDirect(p []byte, remainCap int) (err error) {
+	return c.writer.WriteDirect(p, remainCap)
+}
+
+// WriteByte implements Connection.
+func (c *tlsConnection) WriteByte(b byte) (err error) {
+	return c.writer.WriteByte(b)
+}
+
+// ------------------------------------------ implement net.Conn ------------------------------------------
+
+// Read behavior is the same as net.Conn, buffered data will be returned first.
+func (c *tlsConnection) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if c.reader.Len() > 0 {
+		return c.reader.buf.readCopy(p), nil
+	}
+	return c.tc.Read(p)
+}
+
+// Write will encrypt and send p soon.
+func (c *tlsConnection) Write(p []byte) (n int, err error) {
+	return c.tc.Write(p)
+}
@@ -0,0 +1,189 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	MustNil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "netpoll"},
+		DNSNames:     []string{"netpoll"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	MustNil(t, err)
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+}
+
+func TestTLSConnection(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	config := newTestTLSConfig(t)
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			line, err := connection.Reader().Until('\n')
+			if err != nil {
+				return err
+			}
+			_, err = connection.Writer().WriteBinary(line)
+			MustNil(t, err)
+			err = connection.Writer().Flush()
+			MustNil(t, err)
+			return connection.Reader().Release()
+		},
+		WithTLSConfig(config),
+	)
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	tconn, err := TLSClient(conn, &tls.Config{InsecureSkipVerify: true})
+	MustNil(t, err)
+
+	for i := 0; i < 128; i++ {
+		_, err = tconn.Writer().WriteString("hello\n")
+		MustNil(t, err)
+		err = tconn.Writer().Flush()
+		MustNil(t, err)
+		resp, err := tconn.Reader().ReadString(6)
+		MustNil(t, err)
+		Equal(t, resp, "hello\n")
+	}
+	// large message cross multiple records
+	msg := make([]byte, 1<<20)
+	msg[len(msg)-1] = '\n'
+	_, err = tconn.Writer().WriteBinary(msg)
+	MustNil(t, err)
+	err = tconn.Writer().Flush()
+	MustNil(t, err)
+	resp, err := tconn.Reader().Next(len(msg))
+	MustNil(t, err)
+	Equal(t, len(resp), len(msg))
+	// the file must be encrypted instead of sent by sendfile
+	f, err := os.CreateTemp(t.TempDir(), "sendfile")
+	MustNil(t, err)
+	defer f.Close()
+	_, err = f.WriteString("hello\n")
+	MustNil(t, err)
+	n, err := tconn.(SocketConn).SendFile(f, 0, 0)
+	MustNil(t, err)
+	Equal(t, n, int64(6))
+	resp, err = tconn.Reader().Next(6)
+	MustNil(t, err)
+	Equal(t, string(resp), "hello\n")
+
+	err = tconn.Close()
+	MustNil(t, err)
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+}
+
+func TestTLSConnectionStdClient(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			_, err = connection.Write(buf)
+			return err
+		},
+		WithTLSConfig(newTestTLSConfig(t)),
+	)
+
+	conn, err := tls.Dial(network, address, &tls.Config{InsecureSkipVerify: true})
+	MustNil(t, err)
+	_, err = conn.Write([]byte("ping"))
+	MustNil(t, err)
+	buf := make([]byte, 4)
+	n, err := conn.Read(buf)
+	MustNil(t, err)
+	Equal(t, string(buf[:n]), "ping")
+
+	err = conn.Close()
+	MustNil(t, err)
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+}
+
+func TestTLSConnectionHandshakeBeforeCallbacks(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	var requested int32
+	connected := make(chan bool, 2)
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			atomic.AddInt32(&requested, 1)
+			MustTrue(t, connection.(*tlsConnection).ConnectionState().HandshakeComplete)
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			_, err = connection.Write(buf)
+			return err
+		},
+		WithTLSConfig(newTestTLSConfig(t)),
+		WithOnConnect(func(ctx context.Context, connection Connection) context.Context {
+			connected <- connection.(*tlsConnection).ConnectionState().HandshakeComplete
+			return ctx
+		}),
+	)
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	tconn, err := TLSClient(conn, &tls.Config{InsecureSkipVerify: true})
+	MustNil(t, err)
+	MustTrue(t, <-connected)
+	_, err = tconn.Write([]byte("ping"))
+	MustNil(t, err)
+	resp, err := tconn.Reader().Next(4)
+	MustNil(t, err)
+	Equal(t, string(resp), "ping")
+	MustNil(t, tconn.Close())
+
+	// the plaintext fails the handshake, which closes the connection without calling OnConnect and OnRequest
+	conn, err = DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	MustNil(t, err)
+	for conn.IsActive() {
+		runtime.Gosched()
+	}
+	Equal(t, atomic.LoadInt32(&requested), int32(1))
+	Equal(t, len(connected), 0)
+
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+}
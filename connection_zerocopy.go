@@ -0,0 +1,121 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// zeroCopy tracks the sends by MSG_ZEROCOPY, see SocketTuner.SetZeroCopy.
+// The kernel still reads the data after sendmsg returns, so the sent data of the output buffer
+// is kept until all the sends are notified completed by the error queue.
+type zeroCopy struct {
+	threshold int64      // the min bytes sent by MSG_ZEROCOPY, non-positive if disabled
+	mu        sync.Mutex // serializes releasing the output buffer by the sends and the notifications
+	sent      uint32     // count of the sends by MSG_ZEROCOPY
+	done      uint32     // count of the sends completed
+}
+
+// SetZeroCopy implements SocketTuner.
+func (c *connection) SetZeroCopy(threshold int) error {
+	zc := c.zeroCopy.Load()
+	if zc == nil {
+		if threshold <= 0 {
+			return nil
+		}
+		switch c.network {
+		case "tcp", "tcp4", "tcp6":
+		default:
+			return Exception(ErrUnsupported, "zero copy on "+c.network)
+		}
+		if err := setZeroCopy(c.fd); err != nil {
+			return err
+		}
+		// keep the first one, whose sends may be in flight
+		c.zeroCopy.CompareAndSwap(nil, &zeroCopy{})
+		zc = c.zeroCopy.Load()
+	}
+	atomic.StoreInt64(&zc.threshold, int64(threshold))
+	return nil
+}
+
+// useZeroCopy reports whether bs should be sent by MSG_ZEROCOPY.
+func (c *connection) useZeroCopy(bs [][]byte) bool {
+	zc := c.zeroCopy.Load()
+	if zc == nil {
+		return false
+	}
+	threshold := atomic.LoadInt64(&zc.threshold)
+	return threshold > 0 && int64(vecLen(bs)) >= threshold
+}
+
+// releaseOutput skips n bytes sent of the output buffer, and releases them if there is no send by MSG_ZEROCOPY
+// in flight, zerocopy reports whether they are sent by MSG_ZEROCOPY.
+func (c *connection) releaseOutput(n int, zerocopy bool) error {
+	zc := c.zeroCopy.Load()
+	if zc == nil {
+		err := c.outputBuffer.Skip(n)
+		c.outputBuffer.Release()
+		return err
+	}
+	zc.mu.Lock()
+	defer zc.mu.Unlock()
+	if zerocopy {
+		zc.sent++
+	}
+	err := c.outputBuffer.Skip(n)
+	if zc.sent == zc.done {
+		c.outputBuffer.Release()
+	}
+	return err
+}
+
+// zeroCopyPending reports whether there are sends by MSG_ZEROCOPY not completed.
+func (c *connection) zeroCopyPending() bool {
+	zc := c.zeroCopy.Load()
+	if zc == nil {
+		return false
+	}
+	zc.mu.Lock()
+	defer zc.mu.Unlock()
+	return zc.sent != zc.done
+}
+
+// onErrQueue implements FDOperator, it releases the output buffer once all the sends by MSG_ZEROCOPY are completed.
+func (c *connection) onErrQueue() bool {
+	zc := c.zeroCopy.Load()
+	if zc == nil {
+		return false
+	}
+	done, ok, err := recvZeroCopy(c.fd)
+	if ok {
+		zc.mu.Lock()
+		// the notification may come before the send is counted, then it's released by releaseOutput
+		zc.done = done
+		if zc.sent == zc.done {
+			c.outputBuffer.Release()
+		}
+		zc.mu.Unlock()
+	}
+	if err != nil {
+		return false
+	}
+	soerr, err := syscall.GetsockoptInt(c.fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+	return err == nil && soerr == 0
+}
@@ -17,6 +17,9 @@ package netpoll
 import (
 	"context"
 	"net"
+	"time"
+
+	"github.com/cloudwego/netpoll/internal/runner"
 )
 
 // A EventLoop is a network server.
@@ -27,13 +30,65 @@ type EventLoop interface {
 	Serve(ln net.Listener) error
 
 	// Shutdown is used to graceful exit.
-	// It will close all idle connections on the server, but will not change the underlying pollers.
+	// It stops accepting new connections, calls OnShutdown for each connection if it's set,
+	// and then waits for the running OnRequest finished and the pending data flushed before closing
+	// each connection, but will not change the underlying pollers.
 	//
 	// Argument: ctx set the waiting deadline, after which an error will be returned,
 	// but will not force the closing of connections in progress.
 	Shutdown(ctx context.Context) error
 }
 
+// PacketServer is an optional interface of EventLoop, which serves the datagram sockets.
+// The EventLoop created by NewEventLoop implements it except on Windows.
+type PacketServer interface {
+	// ServePacket runs blockingly to provide services over a datagram socket, every datagram received
+	// will be delivered to the OnPacket registered by WithOnPacket. It returns when the packet
+	// connection is closed or Shutdown is invoked.
+	ServePacket(pc net.PacketConn) error
+}
+
+// ListenersServer is an optional interface of EventLoop, which serves several listeners together.
+// The EventLoop created by NewEventLoop implements it.
+type ListenersServer interface {
+	// ServeListeners is like Serve but serves all of lns together with the same options, e.g. a tcp listener
+	// and a unix listener, and a single Shutdown stops accepting on all of them.
+	ServeListeners(lns ...net.Listener) error
+}
+
+// ConnectionsLister is an optional interface of EventLoop, which lists the connections it accepted.
+// The EventLoop created by NewEventLoop implements it.
+type ConnectionsLister interface {
+	// Connections returns a snapshot of the live connections accepted by the EventLoop,
+	// e.g. to find out who is connected and who holds the most buffers in an admin endpoint.
+	Connections() []ConnInfo
+}
+
+// TimerScheduler is an optional interface of EventLoop, which schedules the timers by the timer wheels of the pollers.
+// The EventLoop created by NewEventLoop implements it.
+type TimerScheduler interface {
+	// AfterFunc calls fn once after d by the timer wheel of a poller, i.e. one of the dedicated pollers
+	// if WithNumLoops is set, otherwise one of the global pollers. It's much cheaper than
+	// time.AfterFunc to manage a large number of timers, at the cost of the precision of 1ms.
+	// fn is called by the poller goroutine if any, so it must not block the other timers and the connections.
+	// The timers are independent of Serve and Shutdown.
+	AfterFunc(d time.Duration, fn func()) *Timer
+
+	// Tick is like AfterFunc but calls fn every d until the returned Timer is stopped.
+	Tick(d time.Duration, fn func()) *Timer
+}
+
+// ConnInfo describes a live connection accepted by EventLoop, see ConnectionsLister.Connections.
+type ConnInfo struct {
+	FD          int // -1 if the connection has no fd, e.g. on Windows
+	LocalAddr   net.Addr
+	RemoteAddr  net.Addr
+	InputBytes  int           // bytes read but not consumed by the Reader yet
+	OutputBytes int           // bytes flushed but not sent to the peer yet
+	IdleTime    time.Duration // time since the connection read or wrote data last time, see ConnStats.LastActive
+	Poller      int           // index of the poller serving the connection, -1 if it's not served by a poller
+}
+
 /* The Connection Callback Sequence Diagram
 | Connection State                     | Callback Function | Notes
 |   Connected but not initialized      |    OnPrepare      | Conn is not registered into poller
@@ -41,13 +96,38 @@ type EventLoop interface {
 |   Read first byte                    |    OnRequest      | Conn is ready for read or write
 |   Peer closed but conn is active     |    OnDisconnect   | Conn access will race with OnRequest function
 |   Self closed and conn is closed     |    CloseCallback  | Conn is destroyed
+|   Self closed and conn is closed     |    OnClose        | Conn buffers are not released yet
 
 Execution Order:
-  OnPrepare => OnConnect => OnRequest      => CloseCallback
+  OnPrepare => OnConnect => OnRequest      => CloseCallback(OnClose)
                             OnDisconnect
 Note: only OnRequest and OnDisconnect will be executed in parallel
 */
 
+// Executor runs the tasks which call OnConnect, OnRequest and OnPacket, see WithExecutor.
+// It can be a goroutine pool, a bounded pool, or even run the task inline. Note that an inline
+// executor runs the task in the poller, so the callbacks must not block.
+type Executor interface {
+	Submit(task func())
+}
+
+// ExecutorFunc is an adapter to allow the use of ordinary functions as Executor.
+type ExecutorFunc func(task func())
+
+// Submit implements Executor.
+func (f ExecutorFunc) Submit(task func()) {
+	f(task)
+}
+
+// runTask runs task by executor if it's set, otherwise by the global runner.
+func runTask(ctx context.Context, executor Executor, task func()) {
+	if executor != nil {
+		executor.Submit(task)
+		return
+	}
+	runner.RunTask(ctx, task)
+}
+
 // OnPrepare is used to inject custom preparation at connection initialization,
 // which is optional but important in some scenarios. For example, a qps limiter
 // can be set by closing overloaded connections directly in OnPrepare.
@@ -65,6 +145,7 @@ type OnPrepare func(connection Connection) context.Context
 // It supports read/write/close connection, and could return a ctx which will be passed to OnRequest.
 // OnConnect will not block the poller since it's executed asynchronously.
 // Only after OnConnect finished the OnRequest could be executed.
+// The ctx argument is the one returned by OnPrepare, derive the returned ctx from it to keep both values.
 //
 // An example usage in TCP Proxy scenario:
 //
@@ -83,6 +164,74 @@ type OnConnect func(ctx context.Context, connection Connection) context.Context
 // OnDisconnect is different from CloseCallback, you could check with "The Connection Callback Sequence Diagram" section.
 type OnDisconnect func(ctx context.Context, connection Connection)
 
+// OnClose is called exactly once when the connection is closed, no matter it's closed by the peer or by the user.
+// It's called after OnDisconnect and the running OnRequest have finished, and before the buffers of the connection
+// are released, so it's the right place to clean up the per-connection state.
+// OnClose is executed as a CloseCallback of priority 0 registered before OnPrepare, so it runs after all the other
+// CloseCallbacks except the ones with a negative priority.
+type OnClose func(ctx context.Context, connection Connection)
+
+// OnShutdown is called once for each connection when EventLoop.Shutdown begins, it's usually used to
+// notify the peer that the server is going away, e.g. sending a GOAWAY frame.
+// It will not be executed concurrently with OnRequest, and the connection will be closed after
+// all the written data has been flushed and the running OnRequest has finished.
+type OnShutdown func(ctx context.Context, connection Connection)
+
+// OnIdle is called once the connection has not read or written any data for the timeout set by WithIdleTimeout,
+// so that the protocols with their own keepalive can decide what to do, e.g. sending a ping.
+// The connection is closed if OnIdle returns false, otherwise OnIdle will be called again after another timeout.
+// It's called by the global runner once the timer of the poller fires, which may run concurrently with OnRequest.
+type OnIdle func(connection Connection) (keep bool)
+
+// OnPanic is called with the recovered value and the stack when OnConnect or OnRequest panics,
+// then the connection is closed and the server keeps serving the others.
+// The panic is swallowed unless WithStrictPanic is set.
+type OnPanic func(connection Connection, recovered interface{}, stack []byte)
+
+// ErrorOp classifies the errors reported to OnError by the failed operation.
+type ErrorOp string
+
+const (
+	// ErrorOpAccept is reported when accepting a connection fails, e.g. EMFILE and ENFILE.
+	// The listener keeps retrying, so OnError may be called repeatedly until it recovers.
+	ErrorOpAccept ErrorOp = "accept"
+	// ErrorOpControl is reported when the fd of a listener or a connection fails to be registered into
+	// or detached from the poller, i.e. epoll_ctl or kevent fails.
+	ErrorOpControl ErrorOp = "control"
+	// ErrorOpPanic is reported when OnConnect or OnRequest panics, and the panic is still propagated
+	// unless it's recovered by OnPanic.
+	ErrorOpPanic ErrorOp = "panic"
+)
+
+// ErrorInfo describes the error reported to OnError.
+type ErrorInfo struct {
+	Op   ErrorOp
+	Addr net.Addr // address of the listener, or the local address of the connection
+	FD   int      // fd of the listener or the connection
+	Err  error
+}
+
+// OnError is called when the EventLoop fails internally, such as the failures of accepting and polling,
+// so that operators can alert and apply policies. It must return as quick as possible because it may block poller.
+type OnError func(info ErrorInfo)
+
+// OnAccept is called right after a connection is accepted, before any buffer is allocated or it's registered
+// to the poller, e.g. to apply the IP allow/deny lists or the connection-rate policies. The connection is closed
+// at once if an error is returned. fd is the socket of the connection, or -1 if it's unavailable on Windows.
+// OnAccept must return as quick as possible because it will block poller.
+type OnAccept func(fd int, remote net.Addr) error
+
+// OnOverload is called when a new connection is rejected since the number of connections
+// has reached the limit set by WithMaxConnections. The connection will be closed after OnOverload returns.
+// OnOverload must return as quick as possible because it will block poller.
+type OnOverload func(conn net.Conn)
+
+// OnMemoryPressure is called when the buffers in use of the process start exceeding the limit set by WithMemoryLimit,
+// inUse is the bytes of the buffers in use at that time. The largest consumers have stopped reading when it's called,
+// and it's a chance to shed load, e.g. closing the idle connections.
+// OnMemoryPressure is called asynchronously, and it will be called again if the limit is exceeded again after recovered.
+type OnMemoryPressure func(inUse, limit int64)
+
 // OnRequest defines the function for handling connection. When data is sent from the connection peer,
 // netpoll actively reads the data in LT mode and places it in the connection's input buffer.
 // Generally, OnRequest starts handling the data in the following way:
@@ -112,3 +261,7 @@ type OnDisconnect func(ctx context.Context, connection Connection)
 //
 // Return: error is unused which will be ignored directly.
 type OnRequest func(ctx context.Context, connection Connection) error
+
+// OnPacket is the callback of PacketConnection, which will be called for every received datagram.
+// The datagrams of the same PacketConnection are processed serially, and p will be recycled after OnPacket returns.
+type OnPacket func(ctx context.Context, conn PacketConnection, p Reader, addr net.Addr) error
@@ -16,6 +16,7 @@ package netpoll
 
 import (
 	"runtime"
+	"sync"
 	"sync/atomic"
 )
 
@@ -36,29 +37,158 @@ type FDOperator struct {
 	InputAck func(n int) (err error)
 
 	// Outputs will locked if len(rs) > 0, which need unlocked by OutputAck.
-	// supportZeroCopy is not implemented, and it will be ignored
+	// rs is sent by MSG_ZEROCOPY if supportZeroCopy on Linux, and ignored on the other systems,
+	// the poll reports whether it's really sent without copying by zeroCopied before OutputAck.
 	Outputs   func(vs [][]byte) (rs [][]byte, supportZeroCopy bool)
 	OutputAck func(n int) (err error)
 
+	// onRights is set for the connections of unix sockets, then the data is read by recvmsg,
+	// and the file descriptors passed by SCM_RIGHTS are handed to it before InputAck.
+	onRights func(fds []int)
+
+	// zeroCopied reports whether the data acknowledged by OutputAck is sent by MSG_ZEROCOPY.
+	zeroCopied bool
+
+	// edgeTriggered monitors the events in edge-triggered mode, then the poll reads or writes until the socket is
+	// drained or full, see WithEpollMode. exclusive registers the fd with EPOLLEXCLUSIVE, see WithEpollExclusive.
+	edgeTriggered bool
+	exclusive     bool
+
+	// onErrQueue is called when the poll gets EPOLLERR, it handles the notifications of MSG_ZEROCOPY
+	// in the error queue, and returns false if the socket has a real error.
+	onErrQueue func() bool
+
 	// poll is the registered location of the file descriptor.
 	poll Poll
 
 	// protect only detach once
 	detached int32
 
+	// the monitored events are changed by both the poller and user goroutines,
+	// mu serializes the changes so that the poll always applies the latest state.
+	mu         sync.Mutex
+	writing    bool // PollR2RW
+	readPaused bool // PollPauseRead
+	migrating  bool // the changes are applied to the new poll after migrated
+
 	// private, used by operatorCache
 	next  *FDOperator
-	state int32 // CAS: 0(unused) 1(inuse) 2(do-done)
-	index int32 // index in operatorCache
+	state int32          // CAS: 0(unused) 1(inuse) 2(do-done)
+	index int32          // index in operatorCache
+	cache *operatorCache // the cache allocating the operator
 }
 
 func (op *FDOperator) Control(event PollEvent) error {
-	if event == PollDetach && atomic.AddInt32(&op.detached, 1) > 1 {
-		return nil
+	switch event {
+	case PollReadable, PollWritable:
+		// registered again after detached, e.g. the listener paused by the accept limit or EMFILE
+		atomic.StoreInt32(&op.detached, 0)
+	case PollDetach:
+		op.mu.Lock()
+		defer op.mu.Unlock()
+		// the operator has been detached from the old poll if it's migrating
+		if atomic.AddInt32(&op.detached, 1) > 1 || op.migrating {
+			return nil
+		}
+	case PollR2RW, PollRW2R, PollPauseRead, PollResumeRead:
+		op.mu.Lock()
+		defer op.mu.Unlock()
+		switch event {
+		case PollR2RW, PollRW2R:
+			op.writing = event == PollR2RW
+		case PollPauseRead, PollResumeRead:
+			op.readPaused = event == PollPauseRead
+		}
+		if op.migrating {
+			return nil
+		}
 	}
 	return op.poll.Control(op, event)
 }
 
+// migratablePoll is implemented by the polls whose operators can be migrated to each other.
+type migratablePoll interface {
+	Poll
+	operators() *operatorCache
+}
+
+// isReadPaused reports whether the reading is paused by PollPauseRead.
+func (op *FDOperator) isReadPaused() bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return op.readPaused
+}
+
+// currentPoll returns the poll where the operator is registered.
+func (op *FDOperator) currentPoll() Poll {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return op.poll
+}
+
+// setPoll sets the poll of the operator, which may be read by currentPoll concurrently.
+func (op *FDOperator) setPoll(poll Poll) {
+	op.mu.Lock()
+	op.poll = poll
+	op.mu.Unlock()
+}
+
+// migrate moves the operator registered for reading to the poll `to`, the events monitored are kept.
+func (op *FDOperator) migrate(to Poll) error {
+	op.mu.Lock()
+	from := op.poll
+	if from == to {
+		op.mu.Unlock()
+		return nil
+	}
+	src, ok := from.(migratablePoll)
+	dst, ok2 := to.(migratablePoll)
+	if !ok || !ok2 {
+		op.mu.Unlock()
+		return Exception(ErrUnsupported, "migrate between the polls")
+	}
+	if op.migrating || atomic.LoadInt32(&op.detached) > 0 {
+		op.mu.Unlock()
+		return Exception(ErrConnClosed, "when migrate")
+	}
+	// detach from the old poll, and the changes of events are delayed until migrated.
+	if op.writing {
+		from.Control(op, PollRW2R)
+	}
+	if err := from.Control(op, PollDetach); err != nil {
+		op.mu.Unlock()
+		return err
+	}
+	op.migrating = true
+	op.mu.Unlock()
+
+	// the events polled before detaching may be still handling by the old poller.
+	src.operators().barrier(from)
+
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.migrating = false
+	if atomic.LoadInt32(&op.detached) > 0 {
+		// closed during migrating
+		return Exception(ErrConnClosed, "when migrate")
+	}
+	op.poll = to
+	atomic.AddInt32(&src.operators().inuse, -1)
+	atomic.AddInt32(&dst.operators().inuse, 1)
+	if err := to.Control(op, PollReadable); err != nil {
+		return err
+	}
+	if op.readPaused {
+		if err := to.Control(op, PollPauseRead); err != nil {
+			return err
+		}
+	}
+	if op.writing {
+		return to.Control(op, PollR2RW)
+	}
+	return nil
+}
+
 func (op *FDOperator) Free() {
 	op.poll.Free(op)
 }
@@ -98,6 +228,10 @@ func (op *FDOperator) reset() {
 	op.OnRead, op.OnWrite, op.OnHup = nil, nil, nil
 	op.Inputs, op.InputAck = nil, nil
 	op.Outputs, op.OutputAck = nil, nil
-	op.poll = nil
+	op.onRights = nil
+	op.zeroCopied, op.onErrQueue = false, nil
+	op.edgeTriggered, op.exclusive = false, false
+	op.setPoll(nil)
 	op.detached = 0
+	op.writing, op.readPaused = false, false
 }
@@ -35,6 +35,12 @@ type operatorCache struct {
 	// to reduce GC pressure, we only store op index here
 	freelocked int32
 	freelist   []int32
+	// foreign store the freeable operators allocated by other caches, which are migrated to the poll of this cache
+	foreign []*FDOperator
+	// inuse is the number of the allocated operators, including the migrated ones
+	inuse int32
+	// rounds is increased every time the poller finishes handling the polled events
+	rounds uint32
 }
 
 func (c *operatorCache) alloc() *FDOperator {
@@ -47,7 +53,7 @@ func (c *operatorCache) alloc() *FDOperator {
 		}
 		index := int32(len(c.cache))
 		for i := uintptr(0); i < n; i++ {
-			pd := &FDOperator{index: index}
+			pd := &FDOperator{index: index, cache: c}
 			c.cache = append(c.cache, pd)
 			pd.next = c.first
 			c.first = pd
@@ -57,6 +63,7 @@ func (c *operatorCache) alloc() *FDOperator {
 	op := c.first
 	c.first = op.next
 	unlock(&c.locked)
+	atomic.AddInt32(&c.inuse, 1)
 	return op
 }
 
@@ -66,14 +73,29 @@ func (c *operatorCache) freeable(op *FDOperator) {
 	// reset all state
 	op.unused()
 	op.reset()
+	atomic.AddInt32(&c.inuse, -1)
 	lock(&c.freelocked)
-	c.freelist = append(c.freelist, op.index)
+	if op.cache == c {
+		c.freelist = append(c.freelist, op.index)
+	} else {
+		c.foreign = append(c.foreign, op)
+	}
 	unlock(&c.freelocked)
 }
 
 func (c *operatorCache) free() {
+	atomic.AddUint32(&c.rounds, 1)
 	lock(&c.freelocked)
 	defer unlock(&c.freelocked)
+	for i, op := range c.foreign {
+		// give back to the cache allocating it
+		lock(&op.cache.locked)
+		op.next = op.cache.first
+		op.cache.first = op
+		unlock(&op.cache.locked)
+		c.foreign[i] = nil
+	}
+	c.foreign = c.foreign[:0]
 	if len(c.freelist) == 0 {
 		return
 	}
@@ -88,6 +110,15 @@ func (c *operatorCache) free() {
 	unlock(&c.locked)
 }
 
+// barrier waits until the poller finishes handling the events polled before, p is the poll owning the cache.
+func (c *operatorCache) barrier(p Poll) {
+	rounds := atomic.LoadUint32(&c.rounds)
+	p.Trigger()
+	for atomic.LoadUint32(&c.rounds) == rounds {
+		runtime.Gosched()
+	}
+}
+
 func lock(locked *int32) {
 	for !atomic.CompareAndSwapInt32(locked, 0, 1) {
 		runtime.Gosched()
@@ -0,0 +1,120 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// FDHandler handles the events of the file descriptor registered by RegisterFD.
+// OnRead and OnWrite are called in the poller, so they must not block, and the poller is level-triggered,
+// which means they are called again and again until the fd is drained or no longer writable.
+type FDHandler struct {
+	// OnRead is called when the fd is readable, nil means the readable event is not monitored.
+	OnRead func(fd int)
+	// OnWrite is called when the fd is writable, only if the writable event is monitored by WatchWrite.
+	OnWrite func(fd int)
+	// OnHup is called when the fd is hung up or has an error, and the fd has been deregistered from the poller.
+	OnHup func(fd int)
+}
+
+// FDWatcher is the registration of a file descriptor returned by RegisterFD.
+type FDWatcher interface {
+	// Fd returns the registered file descriptor.
+	Fd() int
+
+	// WatchWrite starts or stops monitoring the writable event.
+	WatchWrite(enable bool) error
+
+	// Close deregisters the fd from the poller, the fd is owned by user and will not be closed.
+	Close() error
+}
+
+type fdWatcher struct {
+	fd       int
+	operator *FDOperator
+	handler  FDHandler
+	closed   int32
+}
+
+func newFDWatcher(poll Poll, fd int, handler FDHandler) (*fdWatcher, error) {
+	if handler.OnRead == nil && handler.OnWrite == nil {
+		return nil, fmt.Errorf("register fd[%d] without OnRead and OnWrite", fd)
+	}
+	w := &fdWatcher{fd: fd, handler: handler}
+	w.operator = poll.Alloc()
+	w.operator.FD = fd
+	w.operator.OnRead, w.operator.OnWrite, w.operator.OnHup = w.onRead, w.onWrite, w.onHup
+	if err := w.operator.Control(PollReadable); err != nil {
+		w.operator.Free()
+		return nil, err
+	}
+	if handler.OnRead == nil {
+		// only registered for writing
+		if err := w.operator.Control(PollPauseRead); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Fd implements FDWatcher.
+func (w *fdWatcher) Fd() int {
+	return w.fd
+}
+
+// WatchWrite implements FDWatcher.
+func (w *fdWatcher) WatchWrite(enable bool) error {
+	if atomic.LoadInt32(&w.closed) != 0 {
+		return Exception(ErrConnClosed, "watch write")
+	}
+	if enable {
+		return w.operator.Control(PollR2RW)
+	}
+	return w.operator.Control(PollRW2R)
+}
+
+// Close implements FDWatcher.
+func (w *fdWatcher) Close() error {
+	if !atomic.CompareAndSwapInt32(&w.closed, 0, 1) {
+		return nil
+	}
+	err := w.operator.Control(PollDetach)
+	w.operator.Free()
+	return err
+}
+
+func (w *fdWatcher) onRead(p Poll) error {
+	if w.handler.OnRead != nil {
+		w.handler.OnRead(w.fd)
+	}
+	return nil
+}
+
+func (w *fdWatcher) onWrite(p Poll) error {
+	if w.handler.OnWrite != nil {
+		w.handler.OnWrite(w.fd)
+	}
+	return nil
+}
+
+func (w *fdWatcher) onHup(p Poll) error {
+	if w.handler.OnHup != nil {
+		w.handler.OnHup(w.fd)
+	}
+	return nil
+}
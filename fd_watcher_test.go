@@ -0,0 +1,84 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRegisterFD(t *testing.T) {
+	var fds [2]int
+	MustNil(t, syscall.Pipe(fds[:]))
+	rfd, wfd := fds[0], fds[1]
+	MustNil(t, syscall.SetNonblock(rfd, true))
+	MustNil(t, syscall.SetNonblock(wfd, true))
+	defer syscall.Close(rfd)
+
+	_, err := RegisterFD(rfd, FDHandler{})
+	Assert(t, err != nil)
+
+	reads, hups := make(chan string, 8), make(chan int, 1)
+	rw, err := RegisterFD(rfd, FDHandler{
+		OnRead: func(fd int) {
+			buf := make([]byte, 64)
+			n, _ := syscall.Read(fd, buf)
+			if n > 0 {
+				reads <- string(buf[:n])
+			}
+		},
+		OnHup: func(fd int) {
+			hups <- fd
+		},
+	})
+	MustNil(t, err)
+	defer rw.Close()
+	Equal(t, rw.Fd(), rfd)
+
+	// the writable event is monitored only if WatchWrite
+	var ww FDWatcher
+	writes := make(chan struct{}, 1)
+	ww, err = RegisterFD(wfd, FDHandler{
+		OnWrite: func(fd int) {
+			syscall.Write(fd, []byte("ping"))
+			ww.WatchWrite(false)
+			writes <- struct{}{}
+		},
+	})
+	MustNil(t, err)
+	select {
+	case <-writes:
+		t.Fatal("OnWrite is called without WatchWrite")
+	case <-time.After(20 * time.Millisecond):
+	}
+	MustNil(t, ww.WatchWrite(true))
+	<-writes
+	Equal(t, <-reads, "ping")
+
+	// the fd is still owned by user after Close
+	MustNil(t, ww.Close())
+	MustNil(t, ww.Close())
+	Assert(t, ww.WatchWrite(true) != nil)
+	_, err = syscall.Write(wfd, []byte("pong"))
+	MustNil(t, err)
+	Equal(t, <-reads, "pong")
+
+	// hup when the write end is closed
+	MustNil(t, syscall.Close(wfd))
+	Equal(t, <-hups, rfd)
+}
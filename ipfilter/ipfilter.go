@@ -0,0 +1,139 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipfilter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cloudwego/netpoll"
+)
+
+/* DOC:
+ * Filter checks the remote addresses of the connections accepted by EventLoop against the allow and deny lists
+ * of CIDRs, e.g. "10.0.0.0/8" or a single address "192.168.1.1", before any buffer is allocated for them.
+ * An address in the deny list is always rejected, and if the allow list is not empty, only the addresses in it
+ * are accepted. The addresses other than IP, e.g. of unix sockets, are always accepted.
+ * The lists can be replaced atomically by Update at runtime, and the new lists apply to the next connections.
+ */
+
+// ErrDenied is returned by Check if the address is rejected.
+var ErrDenied = errors.New("address denied")
+
+// Filter is the allow and deny lists of CIDRs, it's safe for concurrent use.
+type Filter struct {
+	rules atomic.Pointer[rules]
+}
+
+type rules struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// New creates a Filter with the allow and deny lists, see Update.
+func New(allow, deny []string) (*Filter, error) {
+	f := &Filter{}
+	if err := f.Update(allow, deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Update replaces both the allow and deny lists atomically, the lists are kept if any CIDR is invalid.
+func (f *Filter) Update(allow, deny []string) error {
+	r := &rules{}
+	var err error
+	if r.allow, err = parsePrefixes(allow); err != nil {
+		return err
+	}
+	if r.deny, err = parsePrefixes(deny); err != nil {
+		return err
+	}
+	f.rules.Store(r)
+	return nil
+}
+
+// Option returns the netpoll.Option which applies the Filter to the EventLoop by netpoll.WithOnAccept.
+func (f *Filter) Option() netpoll.Option {
+	return netpoll.WithOnAccept(func(fd int, remote net.Addr) error {
+		return f.Check(remote)
+	})
+}
+
+// Check returns ErrDenied if addr is rejected by the lists.
+func (f *Filter) Check(addr net.Addr) error {
+	ip, ok := addrIP(addr)
+	if !ok {
+		return nil
+	}
+	r := f.rules.Load()
+	if contains(r.deny, ip) || (len(r.allow) > 0 && !contains(r.allow, ip)) {
+		return fmt.Errorf("%w: %s", ErrDenied, ip)
+	}
+	return nil
+}
+
+// addrIP returns the IP of addr, the IPv4-mapped IPv6 addresses are converted to IPv4.
+func addrIP(addr net.Addr) (ip netip.Addr, ok bool) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, ok = netip.AddrFromSlice(a.IP)
+	case *net.UDPAddr:
+		ip, ok = netip.AddrFromSlice(a.IP)
+	case *net.IPAddr:
+		ip, ok = netip.AddrFromSlice(a.IP)
+	}
+	return ip.Unmap(), ok
+}
+
+func contains(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefixes parses the CIDRs, a single address is treated as the prefix of its full length.
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, s := range cidrs {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %w", s, err)
+			}
+			ip = ip.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", s, err)
+		}
+		// the IPv4-mapped IPv6 prefixes are unmapped like the addresses
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package ipfilter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func MustNil(t *testing.T, val interface{}) {
+	t.Helper()
+	if val != nil {
+		t.Fatal("assertion nil failed, val=", val)
+	}
+}
+
+func MustTrue(t *testing.T, cond bool) {
+	t.Helper()
+	if !cond {
+		t.Fatal("assertion true failed")
+	}
+}
+
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 80}
+}
+
+func TestFilter(t *testing.T) {
+	f, err := New([]string{"10.0.0.0/8", "2001:db8::/32", "192.168.1.1"}, []string{"10.1.0.0/16"})
+	MustNil(t, err)
+	MustNil(t, f.Check(tcpAddr("10.2.3.4")))
+	MustNil(t, f.Check(tcpAddr("192.168.1.1")))
+	MustNil(t, f.Check(tcpAddr("2001:db8::1")))
+	MustNil(t, f.Check(tcpAddr("::ffff:10.2.3.4")))
+	MustNil(t, f.Check(&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}))
+	// the deny list wins
+	MustTrue(t, errors.Is(f.Check(tcpAddr("10.1.2.3")), ErrDenied))
+	// not in the allow list
+	MustTrue(t, errors.Is(f.Check(tcpAddr("192.168.1.2")), ErrDenied))
+	MustTrue(t, errors.Is(f.Check(tcpAddr("::1")), ErrDenied))
+
+	// the lists are kept if the update is invalid
+	MustTrue(t, f.Update([]string{"10.0.0.0/33"}, nil) != nil)
+	MustTrue(t, f.Update(nil, []string{"bad"}) != nil)
+	MustNil(t, f.Check(tcpAddr("10.2.3.4")))
+
+	// accept all but the deny list
+	MustNil(t, f.Update(nil, []string{"::ffff:192.168.0.0/112"}))
+	MustNil(t, f.Check(tcpAddr("10.1.2.3")))
+	MustTrue(t, errors.Is(f.Check(tcpAddr("192.168.3.4")), ErrDenied))
+}
+
+func TestFilterEventLoop(t *testing.T) {
+	f, err := New(nil, []string{"127.0.0.1"})
+	MustNil(t, err)
+	loop, err := netpoll.NewEventLoop(func(ctx context.Context, connection netpoll.Connection) error {
+		buf, err := connection.Reader().Next(connection.Reader().Len())
+		if err != nil {
+			return err
+		}
+		_, err = connection.Writer().WriteBinary(buf)
+		if err != nil {
+			return err
+		}
+		return connection.Writer().Flush()
+	}, f.Option())
+	MustNil(t, err)
+	ln, err := netpoll.CreateListener("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	go loop.Serve(ln)
+	defer loop.Shutdown(context.Background())
+
+	// rejected and closed by the server
+	conn, err := net.DialTimeout("tcp", ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	var ne net.Error
+	MustTrue(t, err != nil && !(errors.As(err, &ne) && ne.Timeout()))
+	conn.Close()
+
+	// accepted after the update
+	MustNil(t, f.Update(nil, nil))
+	conn, err = net.DialTimeout("tcp", ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	_, err = conn.Write([]byte("ping"))
+	MustNil(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	MustNil(t, err)
+	MustTrue(t, string(buf) == "ping")
+	conn.Close()
+}
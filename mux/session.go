@@ -0,0 +1,324 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/cloudwego/netpoll"
+)
+
+/* DOC:
+ * Session multiplexes the streams over a single netpoll.Connection, each stream is a bidirectional
+ * byte stream with the nocopy Reader and Writer, and the credit-based flow control.
+ *
+ * Each frame has a 12 bytes header in big endian:
+ *
+ *	version(1) | type(1) | flags(2) | stream id(4) | length(4)
+ *
+ * The length is the size of the payload of the data frames, or the window increased by the window frames.
+ * The streams are opened by SYN, accepted by ACK, half-closed by FIN and aborted by RST, which are the flags
+ * of the data or window frames. The client opens the streams of odd ids, and the server opens the even ones.
+ */
+
+const (
+	frameVersion = 0
+	headerSize   = 12
+
+	typeData   = 0
+	typeWindow = 1
+
+	flagSYN = 1 << 0
+	flagACK = 1 << 1
+	flagFIN = 1 << 2
+	flagRST = 1 << 3
+
+	// initialWindow is the window of each stream before the peer announces a larger one by SYN or ACK.
+	initialWindow = 256 << 10
+	// maxFrameSize limits the payload of a data frame, so that the streams are interleaved fairly.
+	maxFrameSize = 64 << 10
+
+	defaultAcceptBacklog = 256
+)
+
+var (
+	// ErrSessionClosed is returned by the Session and its streams after the connection is closed.
+	ErrSessionClosed = errors.New("mux session has been closed")
+	// ErrStreamClosed is returned by writing a stream after it's closed by Stream.Close or Stream.Reset.
+	ErrStreamClosed = errors.New("mux stream has been closed")
+	// ErrStreamReset is returned by the stream after it's reset by the peer.
+	ErrStreamReset = errors.New("mux stream has been reset by peer")
+
+	errProtocol = errors.New("mux protocol error")
+)
+
+// SessionConfig configures the Session created by NewSession.
+type SessionConfig struct {
+	// Client must be set by one side of the connection and not the other, usually the one that dials.
+	Client bool
+	// StreamWindow is the receive window of each stream in bytes, which limits the data received but not read yet.
+	// It's 256KB if it's smaller than that.
+	StreamWindow int
+	// AcceptBacklog limits the streams opened by the peer but not accepted yet, the others are reset.
+	// It's 256 if it's not positive.
+	AcceptBacklog int
+}
+
+// Session multiplexes the streams over a netpoll.Connection, see NewSession.
+type Session struct {
+	conn    netpoll.Connection
+	window  int
+	wmu     sync.Mutex // serializes the frames written to conn
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	err     error // set once the session is closed
+	accepts chan *Stream
+	done    chan struct{}
+}
+
+// NewSession creates a Session over conn, which takes over the reads of conn by SetOnRequest.
+// So the accepted connections must be passed to NewSession in OnPrepare of the EventLoop,
+// and conn must not be read by the others. The session is closed when conn is closed.
+func NewSession(conn netpoll.Connection, cfg SessionConfig) (*Session, error) {
+	s := &Session{
+		conn:    conn,
+		window:  cfg.StreamWindow,
+		streams: make(map[uint32]*Stream),
+		nextID:  2,
+		done:    make(chan struct{}),
+	}
+	if s.window < initialWindow {
+		s.window = initialWindow
+	}
+	if cfg.Client {
+		s.nextID = 1
+	}
+	backlog := cfg.AcceptBacklog
+	if backlog <= 0 {
+		backlog = defaultAcceptBacklog
+	}
+	s.accepts = make(chan *Stream, backlog)
+	if err := conn.AddCloseCallback(func(netpoll.Connection) error {
+		s.shutdown(ErrSessionClosed)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := conn.SetOnRequest(s.onRequest); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenStream opens a new stream, which is delivered to AcceptStream of the peer.
+func (s *Session) OpenStream() (*Stream, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	if err := s.writeFrame(typeWindow, flagSYN, id, uint32(s.window-initialWindow), nil, 0); err != nil {
+		s.remove(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// AcceptStream waits for the next stream opened by the peer.
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case st := <-s.accepts:
+		if err := s.writeFrame(typeWindow, flagACK, st.id, uint32(s.window-initialWindow), nil, 0); err != nil {
+			return nil, err
+		}
+		return st, nil
+	case <-s.done:
+		return nil, s.err
+	}
+}
+
+// NumStreams returns the count of the streams which are not closed by both sides yet.
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// Close closes the connection, and all the streams fail with ErrSessionClosed.
+func (s *Session) Close() error {
+	s.shutdown(ErrSessionClosed)
+	return s.conn.Close()
+}
+
+// shutdown fails all the streams with err, it only takes effect once.
+func (s *Session) shutdown(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	streams := s.streams
+	s.streams = make(map[uint32]*Stream)
+	close(s.done)
+	s.mu.Unlock()
+	for _, st := range streams {
+		st.fail(err)
+	}
+}
+
+// remove forgets the stream once it's closed by both sides or reset.
+func (s *Session) remove(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+// onRequest reads the frames until conn has no more data, it blocks to read the rest of an incomplete frame.
+func (s *Session) onRequest(ctx context.Context, conn netpoll.Connection) error {
+	reader := conn.Reader()
+	for reader.Len() > 0 {
+		if err := s.readFrame(reader); err != nil {
+			// the streams get ErrSessionClosed unless the peer violates the protocol
+			if !errors.Is(err, errProtocol) {
+				err = ErrSessionClosed
+			}
+			s.shutdown(err)
+			conn.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Session) readFrame(reader netpoll.Reader) error {
+	hdr, err := reader.Next(headerSize)
+	if err != nil {
+		return err
+	}
+	if hdr[0] != frameVersion {
+		return fmt.Errorf("%w: unknown version %d", errProtocol, hdr[0])
+	}
+	typ, flags := hdr[1], binary.BigEndian.Uint16(hdr[2:])
+	id, length := binary.BigEndian.Uint32(hdr[4:]), binary.BigEndian.Uint32(hdr[8:])
+	reader.Release()
+
+	st, err := s.dispatch(id, flags)
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case typeData:
+		if length > maxFrameSize {
+			return fmt.Errorf("%w: frame of %d bytes", errProtocol, length)
+		}
+		if st == nil {
+			// the stream has been closed or reset, discard the data
+			err = reader.Skip(int(length))
+		} else {
+			err = st.receive(reader, int(length))
+		}
+		if err != nil {
+			return err
+		}
+		reader.Release()
+	case typeWindow:
+		if st != nil {
+			st.increaseWindow(int(length))
+		}
+	default:
+		return fmt.Errorf("%w: unknown frame type %d", errProtocol, typ)
+	}
+	if st != nil && flags&flagFIN != 0 {
+		st.remoteClose()
+	}
+	return nil
+}
+
+// dispatch finds the stream of id, and handles SYN and RST. It returns nil if the stream doesn't exist any more.
+func (s *Session) dispatch(id uint32, flags uint16) (*Stream, error) {
+	s.mu.Lock()
+	st := s.streams[id]
+	if flags&flagSYN != 0 {
+		if st != nil || id == 0 || id%2 == s.nextID%2 {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("%w: unexpected SYN of stream %d", errProtocol, id)
+		}
+		if s.err != nil {
+			s.mu.Unlock()
+			return nil, nil
+		}
+		st = newStream(s, id)
+		select {
+		case s.accepts <- st:
+			s.streams[id] = st
+		default:
+			// the backlog is full
+			s.mu.Unlock()
+			return nil, s.writeFrame(typeWindow, flagRST, id, 0, nil, 0)
+		}
+	}
+	s.mu.Unlock()
+	if st != nil && flags&flagRST != 0 {
+		s.remove(id)
+		st.fail(ErrStreamReset)
+		return nil, nil
+	}
+	return st, nil
+}
+
+// writeFrame writes a frame with the first n bytes of payload if it's not nil, which is appended
+// without copying if n is all of it.
+func (s *Session) writeFrame(typ uint8, flags uint16, id, length uint32, payload *netpoll.LinkBuffer, n int) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	writer := s.conn.Writer()
+	hdr, err := writer.Malloc(headerSize)
+	if err != nil {
+		return err
+	}
+	hdr[0], hdr[1] = frameVersion, typ
+	binary.BigEndian.PutUint16(hdr[2:], flags)
+	binary.BigEndian.PutUint32(hdr[4:], id)
+	binary.BigEndian.PutUint32(hdr[8:], length)
+	if payload != nil {
+		if n == payload.Len() {
+			err = writer.Append(payload)
+		} else {
+			var buf []byte
+			if buf, err = writer.Malloc(n); err == nil {
+				_, err = netpoll.ReadBinaryTo(payload, buf)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err = writer.Flush(); err != nil && !s.conn.IsActive() {
+		return ErrSessionClosed
+	}
+	return err
+}
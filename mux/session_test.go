@@ -0,0 +1,238 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package mux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// newSessionPair returns the sessions of both sides of a tcp connection served by an EventLoop.
+func newSessionPair(t *testing.T, cfg SessionConfig) (client, server *Session) {
+	ln, err := netpoll.CreateListener("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	servers := make(chan *Session, 1)
+	loop, err := netpoll.NewEventLoop(func(ctx context.Context, connection netpoll.Connection) error {
+		return nil
+	}, netpoll.WithOnPrepare(func(connection netpoll.Connection) context.Context {
+		s, err := NewSession(connection, cfg)
+		MustNil(t, err)
+		servers <- s
+		return context.Background()
+	}))
+	MustNil(t, err)
+	go loop.Serve(ln)
+	t.Cleanup(func() {
+		loop.Shutdown(context.Background())
+	})
+
+	conn, err := netpoll.DialConnection("tcp", ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	clientCfg := cfg
+	clientCfg.Client = true
+	client, err = NewSession(conn, clientCfg)
+	MustNil(t, err)
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return client, <-servers
+}
+
+func TestSessionStreams(t *testing.T) {
+	client, server := newSessionPair(t, SessionConfig{})
+
+	// the server echoes the lines of each stream until it's closed by the client
+	go func() {
+		for {
+			st, err := server.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					line, err := st.Reader().Until('\n')
+					if err != nil {
+						Assert(t, errors.Is(err, io.EOF), err)
+						MustNil(t, st.Close())
+						return
+					}
+					_, err = st.Writer().WriteBinary(line)
+					MustNil(t, err)
+					MustNil(t, st.Writer().Flush())
+					st.Reader().Release()
+				}
+			}()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			st, err := client.OpenStream()
+			MustNil(t, err)
+			Equal(t, st.ID()%2, uint32(1))
+			for j := 0; j < 8; j++ {
+				msg := fmt.Sprintf("stream %d message %d\n", i, j)
+				_, err = st.Writer().WriteString(msg)
+				MustNil(t, err)
+				MustNil(t, st.Writer().Flush())
+				line, err := st.Reader().ReadString(len(msg))
+				MustNil(t, err)
+				Equal(t, line, msg)
+			}
+			MustNil(t, st.Close())
+			_, err = st.Writer().WriteString("closed")
+			MustNil(t, err)
+			Equal(t, st.Writer().Flush(), ErrStreamClosed)
+			_, err = st.Reader().Next(1)
+			Equal(t, err, io.EOF)
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 100 && (client.NumStreams() > 0 || server.NumStreams() > 0); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	Equal(t, client.NumStreams(), 0)
+	Equal(t, server.NumStreams(), 0)
+}
+
+func TestSessionFlowControl(t *testing.T) {
+	client, server := newSessionPair(t, SessionConfig{StreamWindow: 512 << 10})
+	data := bytes.Repeat([]byte("0123456789abcdef"), 256<<10) // 4MB, far more than the window
+	st, err := client.OpenStream()
+	MustNil(t, err)
+	sent := make(chan error, 1)
+	go func() {
+		_, err := st.Writer().WriteBinary(data)
+		if err == nil {
+			err = st.Writer().Flush()
+		}
+		sent <- err
+	}()
+
+	peer, err := server.AcceptStream()
+	MustNil(t, err)
+	// the writer is blocked by the window until the data is read
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err = <-sent:
+		t.Fatal("flush should be blocked by the window", err)
+	default:
+	}
+	Assert(t, peer.Reader().Len() <= 512<<10, peer.Reader().Len())
+	for off := 0; off < len(data); off += 32 << 10 {
+		p, err := peer.Reader().Next(32 << 10)
+		MustNil(t, err)
+		MustTrue(t, bytes.Equal(p, data[off:off+32<<10]))
+		peer.Reader().Release()
+	}
+	MustNil(t, <-sent)
+}
+
+func TestSessionReset(t *testing.T) {
+	client, server := newSessionPair(t, SessionConfig{})
+	st, err := client.OpenStream()
+	MustNil(t, err)
+	_, err = st.Writer().WriteString("ping")
+	MustNil(t, err)
+	MustNil(t, st.Writer().Flush())
+	peer, err := server.AcceptStream()
+	MustNil(t, err)
+	s, err := peer.Reader().ReadString(4)
+	MustNil(t, err)
+	Equal(t, s, "ping")
+
+	MustNil(t, st.Reset())
+	_, err = peer.Reader().Next(1)
+	Equal(t, err, ErrStreamReset)
+	_, err = peer.Writer().WriteString("pong")
+	MustNil(t, err)
+	Equal(t, peer.Writer().Flush(), ErrStreamReset)
+	_, err = st.Reader().Next(1)
+	Equal(t, err, ErrStreamClosed)
+
+	// the other streams are not affected
+	st, err = client.OpenStream()
+	MustNil(t, err)
+	peer, err = server.AcceptStream()
+	MustNil(t, err)
+	Equal(t, peer.ID(), st.ID())
+}
+
+func TestSessionClose(t *testing.T) {
+	client, server := newSessionPair(t, SessionConfig{AcceptBacklog: 1})
+	st, err := client.OpenStream()
+	MustNil(t, err)
+	// the backlog is full, so the stream is reset
+	rst, err := client.OpenStream()
+	MustNil(t, err)
+	_, err = rst.Reader().Next(1)
+	Equal(t, err, ErrStreamReset)
+	peer, err := server.AcceptStream()
+	MustNil(t, err)
+	Equal(t, peer.ID(), st.ID())
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := server.AcceptStream()
+		accepted <- err
+	}()
+	MustNil(t, client.Close())
+	Equal(t, <-accepted, ErrSessionClosed)
+	_, err = peer.Reader().Next(1)
+	Equal(t, err, ErrSessionClosed)
+	_, err = st.Reader().Next(1)
+	Equal(t, err, ErrSessionClosed)
+	_, err = client.OpenStream()
+	Equal(t, err, ErrSessionClosed)
+}
+
+func TestSessionProtocolError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// a frame of an unknown version
+		hdr := make([]byte, headerSize)
+		hdr[0] = frameVersion + 1
+		conn.Write(hdr)
+		time.Sleep(time.Second)
+	}()
+	conn, err := netpoll.DialConnection("tcp", ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	s, err := NewSession(conn, SessionConfig{Client: true})
+	MustNil(t, err)
+	_, err = s.AcceptStream()
+	MustTrue(t, errors.Is(err, errProtocol))
+	MustTrue(t, !conn.IsActive())
+}
@@ -15,6 +15,7 @@
 package mux
 
 import (
+	"errors"
 	"fmt"
 	"runtime"
 	"sync"
@@ -30,21 +31,54 @@ import (
  * If there is an error in the data transmission, the connection will be closed.
  *
  * ShardQueue.Add: add the data to be sent.
+ * ShardQueue.AddSized: add the data to be sent, which is limited by QueueConfig.MaxBytes.
  * NewShardQueue: create a queue with netpoll.Connection.
+ * NewShardQueueWithConfig: create a queue with netpoll.Connection and QueueConfig.
  * ShardSize: the recommended number of shards is 32.
  */
 var ShardSize int
 
+var (
+	// ErrQueueFull is returned by ShardQueue.AddSized if the queue is full and QueueConfig.Block is not set.
+	ErrQueueFull = errors.New("shardQueue is full")
+	// ErrQueueClosed is returned by ShardQueue.AddSized after the queue is closed.
+	ErrQueueClosed = errors.New("shardQueue has been closed")
+)
+
+// QueueConfig configures the ShardQueue created by NewShardQueueWithConfig.
+type QueueConfig struct {
+	// Shards is the number of shards, ShardSize is used if it's not positive.
+	Shards int
+	// MaxBytes limits the size of the data added by AddSized but not flushed yet, 0 means no limit.
+	// The data added by Add is not counted. A single AddSized larger than MaxBytes is accepted once the queue is empty.
+	MaxBytes int
+	// Block makes AddSized wait until the queue has room if it's full, otherwise AddSized returns ErrQueueFull.
+	Block bool
+	// OnDrained is called once all the data queued has been flushed after the queue was full,
+	// e.g. to resume the streams paused by ErrQueueFull. It's called by the goroutine flushing the queue.
+	OnDrained func()
+}
+
 func init() {
 	ShardSize = runtime.GOMAXPROCS(0)
 }
 
 // NewShardQueue .
 func NewShardQueue(size int, conn netpoll.Connection) (queue *ShardQueue) {
+	return NewShardQueueWithConfig(conn, QueueConfig{Shards: size})
+}
+
+// NewShardQueueWithConfig creates a ShardQueue of conn configured by cfg.
+func NewShardQueueWithConfig(conn netpoll.Connection, cfg QueueConfig) (queue *ShardQueue) {
+	size := cfg.Shards
+	if size <= 0 {
+		size = ShardSize
+	}
 	queue = &ShardQueue{
 		conn:    conn,
 		size:    int32(size),
 		getters: make([][]WriterGetter, size),
+		sizes:   make([]int, size),
 		swap:    make([]WriterGetter, 0, 64),
 		locks:   make([]int32, size),
 	}
@@ -52,6 +86,7 @@ func NewShardQueue(size int, conn netpoll.Connection) (queue *ShardQueue) {
 		queue.getters[i] = make([]WriterGetter, 0, 64)
 	}
 	queue.list = make([]int32, size)
+	queue.limit.init(cfg)
 	return queue
 }
 
@@ -66,11 +101,74 @@ type ShardQueue struct {
 	conn      netpoll.Connection
 	idx, size int32
 	getters   [][]WriterGetter // len(getters) = size
+	sizes     []int            // the size of the data added by AddSized to each shard, len(sizes) = size
 	swap      []WriterGetter   // use for swap
 	locks     []int32          // len(locks) = size
+	limit     queueLimit
 	queueTrigger
 }
 
+// queueLimit applies QueueConfig.MaxBytes to ShardQueue.AddSized.
+type queueLimit struct {
+	mu        sync.Mutex
+	cond      sync.Cond
+	maxBytes  int
+	block     bool
+	onDrained func()
+	queued    int  // the size of the data added by AddSized but not flushed yet
+	full      bool // the queue has been full since the last OnDrained
+	closed    bool
+}
+
+func (l *queueLimit) init(cfg QueueConfig) {
+	l.cond.L = &l.mu
+	l.maxBytes, l.block, l.onDrained = cfg.MaxBytes, cfg.Block, cfg.OnDrained
+}
+
+// acquire reserves size bytes, and waits for the room if it's blocking.
+func (l *queueLimit) acquire(size int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for !l.closed && l.maxBytes > 0 && l.queued > 0 && l.queued+size > l.maxBytes {
+		l.full = true
+		if !l.block {
+			return ErrQueueFull
+		}
+		l.cond.Wait()
+	}
+	if l.closed {
+		return ErrQueueClosed
+	}
+	l.queued += size
+	return nil
+}
+
+// release frees the size bytes flushed, and calls OnDrained if the queue is drained after it was full.
+func (l *queueLimit) release(size int) {
+	if size == 0 {
+		return
+	}
+	l.mu.Lock()
+	l.queued -= size
+	drained := l.queued == 0 && l.full
+	if drained {
+		l.full = false
+	}
+	l.cond.Broadcast()
+	l.mu.Unlock()
+	if drained && l.onDrained != nil {
+		l.onDrained()
+	}
+}
+
+// close wakes up the blocking AddSized.
+func (l *queueLimit) close() {
+	l.mu.Lock()
+	l.closed = true
+	l.cond.Broadcast()
+	l.mu.Unlock()
+}
+
 const (
 	// queueTrigger state
 	active  = 0
@@ -93,10 +191,29 @@ func (q *ShardQueue) Add(gts ...WriterGetter) {
 	if atomic.LoadInt32(&q.state) != active {
 		return
 	}
+	q.add(0, gts)
+}
+
+// AddSized is like Add, but the size of the data returned by gts is counted against QueueConfig.MaxBytes
+// until it's flushed. It returns ErrQueueFull if the queue is full and QueueConfig.Block is not set,
+// otherwise it blocks until the queue has room.
+func (q *ShardQueue) AddSized(size int, gts ...WriterGetter) error {
+	if atomic.LoadInt32(&q.state) != active {
+		return ErrQueueClosed
+	}
+	if err := q.limit.acquire(size); err != nil {
+		return err
+	}
+	q.add(size, gts)
+	return nil
+}
+
+func (q *ShardQueue) add(size int, gts []WriterGetter) {
 	shard := atomic.AddInt32(&q.idx, 1) % q.size
 	q.lock(shard)
 	trigger := len(q.getters[shard]) == 0
 	q.getters[shard] = append(q.getters[shard], gts...)
+	q.sizes[shard] += size
 	q.unlock(shard)
 	if trigger {
 		q.triggering(shard)
@@ -107,6 +224,7 @@ func (q *ShardQueue) Close() error {
 	if !atomic.CompareAndSwapInt32(&q.state, active, closing) {
 		return fmt.Errorf("shardQueue has been closed")
 	}
+	q.limit.close()
 	// wait for all tasks finished
 	for atomic.LoadInt32(&q.state) != closed {
 		if atomic.LoadInt32(&q.trigger) == 0 {
@@ -138,6 +256,7 @@ func (q *ShardQueue) foreach() {
 	}
 	runner.RunTask(nil, func() {
 		var negNum int32 // is negative number of triggerNum
+		var size int     // the size of the data dealt, see AddSized
 		for triggerNum := atomic.LoadInt32(&q.trigger); triggerNum > 0; {
 			q.r = (q.r + 1) % q.size
 			shared := q.list[q.r]
@@ -147,6 +266,8 @@ func (q *ShardQueue) foreach() {
 			tmp := q.getters[shared]
 			q.getters[shared] = q.swap[:0]
 			q.swap = tmp
+			size += q.sizes[shared]
+			q.sizes[shared] = 0
 			q.unlock(shared)
 
 			// deal
@@ -158,6 +279,7 @@ func (q *ShardQueue) foreach() {
 			}
 		}
 		q.flush()
+		q.limit.release(size)
 
 		// quit & check again
 		atomic.StoreInt32(&q.runNum, 0)
@@ -74,6 +74,56 @@ func TestShardQueue(t *testing.T) {
 	Equal(t, rn, total)
 }
 
+func TestShardQueueLimit(t *testing.T) {
+	for _, block := range []bool{false, true} {
+		conn, peer := netpoll.Pipe()
+		drained := make(chan struct{}, 1)
+		queue := NewShardQueueWithConfig(conn, QueueConfig{
+			Shards:    2,
+			MaxBytes:  16,
+			Block:     block,
+			OnDrained: func() { drained <- struct{}{} },
+		})
+		getter := func(wait chan struct{}) WriterGetter {
+			return func() (buf netpoll.Writer, isNil bool) {
+				if wait != nil {
+					<-wait
+				}
+				buf = netpoll.NewLinkBuffer(10)
+				buf.Malloc(10)
+				return buf, false
+			}
+		}
+
+		// the first data is not flushed until released
+		release := make(chan struct{})
+		MustNil(t, queue.AddSized(10, getter(release)))
+		if !block {
+			Equal(t, queue.AddSized(10, getter(nil)), ErrQueueFull)
+			close(release)
+		} else {
+			added := make(chan error, 1)
+			go func() { added <- queue.AddSized(10, getter(nil)) }()
+			time.Sleep(10 * time.Millisecond)
+			Equal(t, len(added), 0)
+			close(release)
+			MustNil(t, <-added)
+		}
+		<-drained
+
+		total := 10
+		if block {
+			total = 20
+		}
+		buf, err := peer.Reader().Next(total)
+		MustNil(t, err)
+		Equal(t, len(buf), total)
+		MustNil(t, queue.Close())
+		Equal(t, queue.AddSized(10, getter(nil)), ErrQueueClosed)
+		conn.Close()
+	}
+}
+
 // TODO: need mock flush
 func BenchmarkShardQueue(b *testing.B) {
 	b.Skip()
@@ -0,0 +1,432 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// Stream is a bidirectional byte stream of the Session, see Session.OpenStream and Session.AcceptStream.
+// Like netpoll.Connection, its Reader and its Writer can be used concurrently, but each of them must be
+// used by one goroutine at a time.
+type Stream struct {
+	session *Session
+	id      uint32
+	recv    *netpoll.LinkBuffer // filled by the session, and read by the Reader
+	reader  streamReader
+	writer  streamWriter
+
+	mu           sync.Mutex
+	cond         *sync.Cond // signaled once the data, the window or the state changes
+	readErr      error      // returned once recv is drained, io.EOF after the peer closed
+	writeErr     error
+	sendWindow   int // the bytes allowed to send
+	recvWindow   int // the bytes the peer is allowed to send
+	consumed     int // the bytes read but not announced to the peer yet
+	localClosed  bool
+	remoteClosed bool
+}
+
+func newStream(s *Session, id uint32) *Stream {
+	st := &Stream{
+		session:    s,
+		id:         id,
+		recv:       netpoll.NewLinkBuffer(),
+		sendWindow: initialWindow,
+		recvWindow: s.window,
+	}
+	st.cond = sync.NewCond(&st.mu)
+	st.reader.st = st
+	st.writer.st = st
+	st.writer.LinkBuffer = netpoll.NewLinkBuffer()
+	return st
+}
+
+// ID returns the id of the stream, which is odd if it's opened by the client.
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// Reader returns the nocopy Reader of the stream, which blocks until the data is enough,
+// or returns io.EOF once the peer has closed the stream and the data is drained.
+func (st *Stream) Reader() netpoll.Reader {
+	return &st.reader
+}
+
+// Writer returns the nocopy Writer of the stream, whose Flush sends the data as the data frames,
+// and blocks if the receive window of the peer is full.
+func (st *Stream) Writer() netpoll.Writer {
+	return &st.writer
+}
+
+// Close flushes the data written and closes the write side of the stream, the peer reads io.EOF
+// after the data. The stream can still be read until the peer closes it as well.
+func (st *Stream) Close() error {
+	if err := st.writer.Flush(); err != nil && err != ErrStreamClosed {
+		return err
+	}
+	st.mu.Lock()
+	if st.localClosed || st.writeErr != nil {
+		st.mu.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	st.writeErr = ErrStreamClosed
+	remoteClosed := st.remoteClosed
+	st.cond.Broadcast()
+	st.mu.Unlock()
+
+	err := st.session.writeFrame(typeWindow, flagFIN, st.id, 0, nil, 0)
+	if remoteClosed {
+		st.session.remove(st.id)
+	}
+	return err
+}
+
+// Reset aborts both sides of the stream, the data not read is discarded and the peer gets ErrStreamReset.
+func (st *Stream) Reset() error {
+	st.mu.Lock()
+	if st.readErr != nil && st.writeErr != nil {
+		st.mu.Unlock()
+		return nil
+	}
+	st.mu.Unlock()
+	st.fail(ErrStreamClosed)
+	st.session.remove(st.id)
+	return st.session.writeFrame(typeWindow, flagRST, st.id, 0, nil, 0)
+}
+
+// fail makes the reads and the writes return err.
+func (st *Stream) fail(err error) {
+	st.mu.Lock()
+	if st.readErr == nil || st.readErr == io.EOF {
+		st.readErr = err
+	}
+	if st.writeErr == nil {
+		st.writeErr = err
+	}
+	st.cond.Broadcast()
+	st.mu.Unlock()
+}
+
+// receive reads the payload of n bytes from the connection into recv.
+func (st *Stream) receive(reader netpoll.Reader, n int) error {
+	st.mu.Lock()
+	if n > st.recvWindow {
+		st.mu.Unlock()
+		return fmt.Errorf("%w: stream %d exceeds the window", errProtocol, st.id)
+	}
+	st.recvWindow -= n
+	discard := st.readErr != nil
+	st.mu.Unlock()
+	if discard || n == 0 {
+		return reader.Skip(n)
+	}
+	buf, err := st.recv.Malloc(n)
+	if err != nil {
+		return err
+	}
+	if _, err = netpoll.ReadBinaryTo(reader, buf); err != nil {
+		return err
+	}
+	st.recv.Flush()
+	st.mu.Lock()
+	st.cond.Broadcast()
+	st.mu.Unlock()
+	return nil
+}
+
+// remoteClose handles the FIN of the peer.
+func (st *Stream) remoteClose() {
+	st.mu.Lock()
+	st.remoteClosed = true
+	if st.readErr == nil {
+		st.readErr = io.EOF
+	}
+	localClosed := st.localClosed
+	st.cond.Broadcast()
+	st.mu.Unlock()
+	if localClosed {
+		st.session.remove(st.id)
+	}
+}
+
+// increaseWindow handles the window frame of the peer.
+func (st *Stream) increaseWindow(delta int) {
+	st.mu.Lock()
+	st.sendWindow += delta
+	st.cond.Broadcast()
+	st.mu.Unlock()
+}
+
+// reserve waits until the window is not full, and takes up to n bytes of it.
+func (st *Stream) reserve(n int) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for st.writeErr == nil && st.sendWindow == 0 {
+		st.cond.Wait()
+	}
+	if st.writeErr != nil {
+		return 0, st.writeErr
+	}
+	if n > st.sendWindow {
+		n = st.sendWindow
+	}
+	if n > maxFrameSize {
+		n = maxFrameSize
+	}
+	st.sendWindow -= n
+	return n, nil
+}
+
+// waitRead waits until recv has n bytes, or returns the error once the data is drained.
+func (st *Stream) waitRead(n int) error {
+	if st.recv.Len() >= n {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for st.recv.Len() < n {
+		if st.readErr != nil {
+			return st.readErr
+		}
+		st.cond.Wait()
+	}
+	return nil
+}
+
+// consume counts the bytes read, and announces the window to the peer once half of it is read.
+func (st *Stream) consume(n int) {
+	if n <= 0 {
+		return
+	}
+	st.mu.Lock()
+	st.consumed += n
+	if st.consumed < st.session.window/2 || st.readErr != nil {
+		st.mu.Unlock()
+		return
+	}
+	delta := st.consumed
+	st.consumed = 0
+	st.recvWindow += delta
+	st.mu.Unlock()
+	// the error means the session is closed, which is reported by the other operations
+	st.session.writeFrame(typeWindow, 0, st.id, uint32(delta), nil, 0)
+}
+
+// streamReader implements netpoll.Reader of the stream.
+type streamReader struct {
+	st *Stream
+}
+
+var (
+	_ netpoll.Reader = &streamReader{}
+
+	_ netpoll.BinaryToReader = &streamReader{}
+	_ netpoll.UntilNReader   = &streamReader{}
+	_ netpoll.PeekVecReader  = &streamReader{}
+)
+
+// Next implements netpoll.Reader.
+func (r *streamReader) Next(n int) (p []byte, err error) {
+	if err = r.st.waitRead(n); err != nil {
+		return nil, err
+	}
+	if p, err = r.st.recv.Next(n); err == nil {
+		r.st.consume(n)
+	}
+	return p, err
+}
+
+// Peek implements netpoll.Reader.
+func (r *streamReader) Peek(n int) (buf []byte, err error) {
+	if err = r.st.waitRead(n); err != nil {
+		return nil, err
+	}
+	return r.st.recv.Peek(n)
+}
+
+// PeekVec implements netpoll.PeekVecReader.
+func (r *streamReader) PeekVec(n int) (vs [][]byte, err error) {
+	if err = r.st.waitRead(n); err != nil {
+		return nil, err
+	}
+	return r.st.recv.PeekVec(n)
+}
+
+// Skip implements netpoll.Reader.
+func (r *streamReader) Skip(n int) (err error) {
+	if err = r.st.waitRead(n); err != nil {
+		return err
+	}
+	if err = r.st.recv.Skip(n); err == nil {
+		r.st.consume(n)
+	}
+	return err
+}
+
+// Until implements netpoll.Reader.
+func (r *streamReader) Until(delim byte) (line []byte, err error) {
+	return r.until(delim, 0)
+}
+
+// UntilN implements netpoll.UntilNReader.
+func (r *streamReader) UntilN(delim byte, max int) (line []byte, err error) {
+	return r.until(delim, max)
+}
+
+// until waits until delim is received, max limits the length of the line if it's positive.
+func (r *streamReader) until(delim byte, max int) (line []byte, err error) {
+	st := r.st
+	for scanned := 0; ; {
+		if err = st.waitRead(scanned + 1); err != nil {
+			// all the data in the buffer is returned with the error
+			if n := st.recv.Len(); n > 0 {
+				line, _ = st.recv.Next(n)
+				st.consume(n)
+			}
+			return line, err
+		}
+		n := st.recv.Len()
+		vs, _ := st.recv.PeekVec(n)
+		idx, off := -1, 0
+		for _, v := range vs {
+			if i := bytes.IndexByte(v, delim); i >= 0 {
+				idx = off + i
+				break
+			}
+			off += len(v)
+		}
+		if max > 0 && (idx >= max || idx < 0 && n >= max) {
+			return nil, netpoll.Exception(netpoll.ErrLineTooLong, fmt.Sprintf("max[%d]", max))
+		}
+		if idx >= 0 {
+			return r.Next(idx + 1)
+		}
+		scanned = n
+	}
+}
+
+// ReadString implements netpoll.Reader.
+func (r *streamReader) ReadString(n int) (s string, err error) {
+	if err = r.st.waitRead(n); err != nil {
+		return "", err
+	}
+	if s, err = r.st.recv.ReadString(n); err == nil {
+		r.st.consume(n)
+	}
+	return s, err
+}
+
+// ReadBinary implements netpoll.Reader.
+func (r *streamReader) ReadBinary(n int) (p []byte, err error) {
+	if err = r.st.waitRead(n); err != nil {
+		return nil, err
+	}
+	if p, err = r.st.recv.ReadBinary(n); err == nil {
+		r.st.consume(n)
+	}
+	return p, err
+}
+
+// ReadBinaryTo implements netpoll.BinaryToReader.
+func (r *streamReader) ReadBinaryTo(p []byte) (n int, err error) {
+	if err = r.st.waitRead(len(p)); err != nil {
+		return 0, err
+	}
+	if n, err = r.st.recv.ReadBinaryTo(p); err == nil {
+		r.st.consume(n)
+	}
+	return n, err
+}
+
+// PeekTo implements netpoll.BinaryToReader.
+func (r *streamReader) PeekTo(p []byte) (n int, err error) {
+	if err = r.st.waitRead(len(p)); err != nil {
+		return 0, err
+	}
+	return r.st.recv.PeekTo(p)
+}
+
+// ReadByte implements netpoll.Reader.
+func (r *streamReader) ReadByte() (b byte, err error) {
+	if err = r.st.waitRead(1); err != nil {
+		return 0, err
+	}
+	if b, err = r.st.recv.ReadByte(); err == nil {
+		r.st.consume(1)
+	}
+	return b, err
+}
+
+// Slice implements netpoll.Reader.
+func (r *streamReader) Slice(n int) (p netpoll.Reader, err error) {
+	if err = r.st.waitRead(n); err != nil {
+		return nil, err
+	}
+	if p, err = r.st.recv.Slice(n); err == nil {
+		r.st.consume(n)
+	}
+	return p, err
+}
+
+// Release implements netpoll.Reader.
+func (r *streamReader) Release() (err error) {
+	return r.st.recv.Release()
+}
+
+// Len implements netpoll.Reader.
+func (r *streamReader) Len() (length int) {
+	return r.st.recv.Len()
+}
+
+// streamWriter implements netpoll.Writer of the stream, the data is buffered by the LinkBuffer until Flush.
+type streamWriter struct {
+	*netpoll.LinkBuffer
+	st *Stream
+}
+
+var _ netpoll.Writer = &streamWriter{}
+
+// Flush implements netpoll.Writer, it sends the data written as the data frames within the window of the peer.
+// The data is appended to the connection without copying if it fits in a frame.
+func (w *streamWriter) Flush() (err error) {
+	if err = w.LinkBuffer.Flush(); err != nil {
+		return err
+	}
+	for w.LinkBuffer.Len() > 0 {
+		n, err := w.st.reserve(w.LinkBuffer.Len())
+		if err != nil {
+			return err
+		}
+		payload := w.LinkBuffer
+		if n == payload.Len() {
+			// the buffer is taken over by the connection
+			w.LinkBuffer = netpoll.NewLinkBuffer()
+		}
+		if err = w.st.session.writeFrame(typeData, 0, w.st.id, uint32(n), payload, n); err != nil {
+			return err
+		}
+		if payload == w.LinkBuffer {
+			payload.Release()
+		}
+	}
+	return nil
+}
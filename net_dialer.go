@@ -23,10 +23,16 @@ import (
 )
 
 // DialConnection is a default implementation of Dialer.
+// The connections of "unixgram" and "unixpacket" are stream-oriented, use DialPacket to keep the message boundaries.
 func DialConnection(network, address string, timeout time.Duration) (connection Connection, err error) {
 	return defaultDialer.DialConnection(network, address, timeout)
 }
 
+// DialContext is like DialConnection but uses ctx instead of a timeout, see Dialer.DialContext.
+func DialContext(ctx context.Context, network, address string) (connection Connection, err error) {
+	return defaultDialer.DialContext(ctx, network, address)
+}
+
 // NewFDConnection create a Connection initialized by any fd
 // It's useful for writing unit tests for functions that have args with the type of netpoll.Connection
 // The typical usage is like:
@@ -43,14 +49,20 @@ func NewFDConnection(fd int) (Connection, error) {
 	return conn, nil
 }
 
-// NewDialer only support TCP and unix socket now.
-func NewDialer() Dialer {
-	return &dialer{}
+// NewDialer only support TCP, unix and vsock socket now.
+func NewDialer(opts ...DialerOption) Dialer {
+	d := &dialer{}
+	for _, do := range opts {
+		do.f(&d.opts)
+	}
+	return d
 }
 
 var defaultDialer = NewDialer()
 
-type dialer struct{}
+type dialer struct {
+	opts dialerOptions
+}
 
 // DialTimeout implements Dialer.
 func (d *dialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
@@ -65,15 +77,52 @@ func (d *dialer) DialConnection(network, address string, timeout time.Duration)
 		defer cancel()
 		ctx = subCtx
 	}
+	return d.dial(ctx, network, address)
+}
 
+// DialContext implements Dialer.
+func (d *dialer) DialContext(ctx context.Context, network, address string) (connection Connection, err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	conn, err := d.dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	// the values of ctx are kept, but the connection won't be canceled with ctx.
+	switch c := conn.(type) {
+	case *TCPConnection:
+		c.ctx = valueContext{ctx}
+	case *UnixConnection:
+		c.ctx = valueContext{ctx}
+	case *VsockConnection:
+		c.ctx = valueContext{ctx}
+	}
+	return conn, nil
+}
+
+func (d *dialer) dial(ctx context.Context, network, address string) (connection Connection, err error) {
 	switch network {
 	case "tcp", "tcp4", "tcp6":
+		if d.opts.proxy != nil {
+			return d.dialProxy(ctx, network, address)
+		}
 		return d.dialTCP(ctx, network, address)
 	case "unix", "unixgram", "unixpacket":
 		raddr := &UnixAddr{
 			UnixAddr: net.UnixAddr{Name: address, Net: network},
 		}
-		return DialUnix(network, nil, raddr)
+		return dialUnix(ctx, network, nil, raddr)
+	case "vsock":
+		raddr, err := ResolveVsockAddr(network, address)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := DialVsock(ctx, raddr)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
 	default:
 		return nil, net.UnknownNetworkError(network)
 	}
@@ -92,8 +141,11 @@ func (d *dialer) dialTCP(ctx context.Context, network, address string) (connecti
 	// host maybe empty if address is :12345
 	if host == "" {
 		ipaddrs = []net.IPAddr{{}}
+	} else if ip := net.ParseIP(host); ip != nil {
+		// the literal is not passed to the custom resolver
+		ipaddrs = []net.IPAddr{{IP: ip}}
 	} else {
-		ipaddrs, err = net.DefaultResolver.LookupIPAddr(ctx, host)
+		ipaddrs, err = d.resolver().LookupIPAddr(ctx, host)
 		if err != nil {
 			return nil, err
 		}
@@ -102,16 +154,117 @@ func (d *dialer) dialTCP(ctx context.Context, network, address string) (connecti
 		}
 	}
 
+	if laddr := d.opts.laddr; laddr != nil && laddr.IP != nil && !laddr.IP.IsUnspecified() {
+		// the local address can't be bound to a connection of the other family.
+		isIPv4 := laddr.IP.To4() != nil
+		suitable := ipaddrs[:0:0]
+		for _, ipaddr := range ipaddrs {
+			if (ipaddr.IP == nil || ipaddr.IP.To4() != nil) == isIPv4 {
+				suitable = append(suitable, ipaddr)
+			}
+		}
+		if len(suitable) == 0 {
+			return nil, &net.OpError{Op: "dial", Net: network, Source: laddr, Addr: nil, Err: errNoSuitableAddress}
+		}
+		ipaddrs = suitable
+	}
+	if network == "tcp" {
+		// race the two address families by Happy Eyeballs if both are resolved.
+		primaries, fallbacks := partitionAddrs(ipaddrs)
+		if len(fallbacks) > 0 {
+			return d.dialParallel(ctx, network, primaries, fallbacks, portnum)
+		}
+	}
+	return d.dialSerial(ctx, network, ipaddrs, portnum)
+}
+
+// fallbackDelay is the delay before the fallback addresses are dialed, as recommended by RFC 8305.
+var fallbackDelay = 300 * time.Millisecond
+
+// partitionAddrs divides ipaddrs into the ones of the same family as the first address and the others.
+func partitionAddrs(ipaddrs []net.IPAddr) (primaries, fallbacks []net.IPAddr) {
+	isIPv4 := func(ip net.IP) bool { return ip == nil || ip.To4() != nil }
+	family := isIPv4(ipaddrs[0].IP)
+	for _, ipaddr := range ipaddrs {
+		if isIPv4(ipaddr.IP) == family {
+			primaries = append(primaries, ipaddr)
+		} else {
+			fallbacks = append(fallbacks, ipaddr)
+		}
+	}
+	return primaries, fallbacks
+}
+
+// dialParallel races the primaries and the fallbacks, the fallbacks are dialed after fallbackDelay
+// or the primaries have failed, and the first established connection is returned.
+func (d *dialer) dialParallel(ctx context.Context, network string, primaries, fallbacks []net.IPAddr, port int) (*TCPConnection, error) {
+	type result struct {
+		conn    *TCPConnection
+		err     error
+		primary bool
+	}
+	results := make(chan result) // unbuffered to close the connection of the loser
+	returned := make(chan struct{})
+	defer close(returned)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	race := func(ipaddrs []net.IPAddr, primary bool) {
+		conn, err := d.dialSerial(ctx, network, ipaddrs, port)
+		select {
+		case results <- result{conn: conn, err: err, primary: primary}:
+		case <-returned:
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+	go race(primaries, true)
+	timer := time.NewTimer(fallbackDelay)
+	defer timer.Stop()
+
+	var primaryErr, fallbackErr error
+	fallbackStarted := false
+	for {
+		select {
+		case <-timer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				go race(fallbacks, false)
+			}
+		case res := <-results:
+			if res.err == nil {
+				return res.conn, nil
+			}
+			if res.primary {
+				primaryErr = res.err
+			} else {
+				fallbackErr = res.err
+			}
+			if primaryErr != nil && fallbackErr != nil {
+				return nil, primaryErr
+			}
+			// start the fallbacks at once if the primaries have failed.
+			if !fallbackStarted {
+				fallbackStarted = true
+				go race(fallbacks, false)
+			}
+		}
+	}
+}
+
+// dialSerial dials ipaddrs in order, and returns the first established connection.
+func (d *dialer) dialSerial(ctx context.Context, network string, ipaddrs []net.IPAddr, port int) (connection *TCPConnection, err error) {
 	var firstErr error // The error from the first address is most relevant.
 	tcpAddr := &TCPAddr{}
 	for _, ipaddr := range ipaddrs {
 		tcpAddr.IP = ipaddr.IP
-		tcpAddr.Port = portnum
+		tcpAddr.Port = port
 		tcpAddr.Zone = ipaddr.Zone
 		if ipaddr.IP != nil && ipaddr.IP.To4() == nil {
-			connection, err = DialTCP(ctx, "tcp6", nil, tcpAddr)
+			connection, err = dialTCP(ctx, "tcp6", d.localAddr(), tcpAddr, d.opts.multipath, d.ctrlFn())
 		} else {
-			connection, err = DialTCP(ctx, "tcp", nil, tcpAddr)
+			connection, err = dialTCP(ctx, "tcp", d.localAddr(), tcpAddr, d.opts.multipath, d.ctrlFn())
 		}
 		if err == nil {
 			return connection, nil
@@ -132,8 +285,45 @@ func (d *dialer) dialTCP(ctx context.Context, network, address string) (connecti
 	return nil, firstErr
 }
 
+// resolver returns the Resolver to look up the hosts.
+func (d *dialer) resolver() Resolver {
+	if d.opts.resolver != nil {
+		return d.opts.resolver
+	}
+	return net.DefaultResolver
+}
+
+// localAddr returns the local address to bind, or nil if it's chosen by the system.
+func (d *dialer) localAddr() *TCPAddr {
+	if d.opts.laddr == nil {
+		return nil
+	}
+	return &TCPAddr{TCPAddr: *d.opts.laddr}
+}
+
+// ctrlFn returns the function to set the socket options before connecting.
+func (d *dialer) ctrlFn() func(fd int) error {
+	fastOpen, ifname := d.opts.fastOpen, d.opts.ifname
+	if !fastOpen && ifname == "" {
+		return nil
+	}
+	return func(fd int) error {
+		if ifname != "" {
+			if err := setBindToDevice(fd, ifname); err != nil {
+				return err
+			}
+		}
+		if fastOpen {
+			return setTCPFastOpenConnect(fd)
+		}
+		return nil
+	}
+}
+
 // sysDialer contains a Dial's parameters and configuration.
 type sysDialer struct {
 	net.Dialer
 	network, address string
+	ctrlFn           func(fd int) error // called before connecting if it's not nil
+	proto            int                // protocol of the socket, e.g. IPPROTO_MPTCP, 0 for the default one
 }
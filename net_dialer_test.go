@@ -18,7 +18,9 @@ package netpoll
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"runtime"
 	"strconv"
 	"strings"
@@ -105,6 +107,179 @@ func TestDialerUnix(t *testing.T) {
 	Equal(t, conn.RemoteAddr().String(), "tmp.sock")
 }
 
+func TestDialContext(t *testing.T) {
+	address := getTestAddress()
+	ln, err := net.Listen("tcp", address)
+	MustNil(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("ping"))
+		}
+	}()
+
+	// canceled before dialing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = DialContext(ctx, "tcp", address)
+	MustTrue(t, err != nil)
+
+	type key struct{}
+	ctx, cancel = context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+	conn, err := DialContext(ctx, "tcp", address)
+	MustNil(t, err)
+	defer conn.Close()
+	// the connection is not canceled with the dial ctx
+	cancel()
+	values := make(chan interface{}, 1)
+	conn.SetOnRequest(func(ctx context.Context, connection Connection) error {
+		connection.Reader().Skip(connection.Reader().Len())
+		MustNil(t, ctx.Err())
+		values <- ctx.Value(key{})
+		return nil
+	})
+	Equal(t, <-values, "value")
+}
+
+func TestDialParallel(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("127.0.0.2 is only routed to loopback by default on linux")
+	}
+	// the primary listener never accepts and its backlog is filled, so the connects to it hang.
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	MustNil(t, err)
+	defer syscall.Close(fd)
+	MustNil(t, syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}))
+	MustNil(t, syscall.Listen(fd, 0))
+	sa, err := syscall.Getsockname(fd)
+	MustNil(t, err)
+	port := sa.(*syscall.SockaddrInet4).Port
+	filled, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	MustNil(t, err)
+	defer filled.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.2:"+strconv.Itoa(port))
+	MustNil(t, err)
+	defer ln.Close()
+
+	delay := fallbackDelay
+	defer func() { fallbackDelay = delay }()
+	d := NewDialer().(*dialer)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the fallback wins after the delay
+	fallbackDelay = 50 * time.Millisecond
+	begin := time.Now()
+	conn, err := d.dialParallel(ctx, "tcp",
+		[]net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, []net.IPAddr{{IP: net.IPv4(127, 0, 0, 2)}}, port)
+	MustNil(t, err)
+	Equal(t, conn.RemoteAddr().String(), ln.Addr().String())
+	Assert(t, time.Since(begin) >= fallbackDelay, time.Since(begin))
+	conn.Close()
+
+	// the fallback starts at once if the primary fails
+	fallbackDelay = 5 * time.Second
+	begin = time.Now()
+	conn, err = d.dialParallel(ctx, "tcp",
+		[]net.IPAddr{{IP: net.IPv4(127, 0, 0, 3)}}, []net.IPAddr{{IP: net.IPv4(127, 0, 0, 2)}}, port)
+	MustNil(t, err)
+	Equal(t, conn.RemoteAddr().String(), ln.Addr().String())
+	Assert(t, time.Since(begin) < time.Second, time.Since(begin))
+	conn.Close()
+
+	primaries, fallbacks := partitionAddrs([]net.IPAddr{
+		{IP: net.ParseIP("::1")}, {IP: net.IPv4(127, 0, 0, 1)}, {IP: net.ParseIP("::2")},
+	})
+	Equal(t, len(primaries), 2)
+	Equal(t, len(fallbacks), 1)
+}
+
+type testResolver map[string][]net.IPAddr
+
+func (r testResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ipaddrs, ok := r[host]; ok {
+		return ipaddrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestDialerResolver(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	dialer := NewDialer(WithDialResolver(testResolver{
+		"netpoll.test": {{IP: net.IPv4(127, 0, 0, 1)}},
+	}))
+	conn, err := dialer.DialConnection("tcp", "netpoll.test:"+port, time.Second)
+	MustNil(t, err)
+	Equal(t, conn.RemoteAddr().String(), ln.Addr().String())
+	conn.Close()
+
+	// ip literals don't need resolving
+	conn, err = dialer.DialConnection("tcp", ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	conn.Close()
+
+	_, err = dialer.DialConnection("tcp", "unknown.test:"+port, time.Second)
+	var dnsErr *net.DNSError
+	MustTrue(t, errors.As(err, &dnsErr))
+}
+
+func TestDialerLocalAddr(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("127.0.0.2 is only routed to loopback by default on linux")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	dialer := NewDialer(WithDialLocalAddr(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}))
+	conn, err := dialer.DialConnection("tcp", ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	Equal(t, conn.LocalAddr().(*net.TCPAddr).IP.String(), "127.0.0.2")
+	peer := <-accepted
+	Equal(t, peer.RemoteAddr().String(), conn.LocalAddr().String())
+	peer.Close()
+
+	// the family of the local address doesn't match
+	dialer = NewDialer(WithDialLocalAddr(&net.TCPAddr{IP: net.IPv6loopback}))
+	_, err = dialer.DialConnection("tcp", ln.Addr().String(), time.Second)
+	MustTrue(t, errors.Is(err, errNoSuitableAddress))
+}
+
+func TestDialerInterface(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_BINDTODEVICE is only supported on linux")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+
+	conn, err := NewDialer(WithDialInterface("lo")).DialConnection("tcp", ln.Addr().String(), time.Second)
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("SO_BINDTODEVICE needs CAP_NET_RAW")
+	}
+	MustNil(t, err)
+	conn.Close()
+	_, err = NewDialer(WithDialInterface("netpoll-none")).DialConnection("tcp", ln.Addr().String(), time.Second)
+	MustTrue(t, errors.Is(err, syscall.ENODEV))
+}
+
 func TestDialerFdAlloc(t *testing.T) {
 	address := getTestAddress()
 	ln, err := CreateListener("tcp", address)
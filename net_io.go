@@ -32,6 +32,22 @@ func ioread(fd int, bs [][]byte, ivs []syscall.Iovec) (n int, err error) {
 	return n, err
 }
 
+// ioreadop reads the data of the operator like ioread,
+// and receives the file descriptors passed by SCM_RIGHTS if the operator needs.
+func ioreadop(op *FDOperator, bs [][]byte, ivs []syscall.Iovec) (n int, err error) {
+	if op.onRights == nil {
+		return ioread(op.FD, bs, ivs)
+	}
+	n, err = recvRights(op.FD, bs, op.onRights)
+	if n == 0 && err == nil { // means EOF
+		return 0, Exception(ErrEOF, "")
+	}
+	if err == syscall.EINTR || err == syscall.EAGAIN {
+		return 0, nil
+	}
+	return n, err
+}
+
 // return value:
 // - n: n == 0 but err == nil, retry syscall
 // - err: if not nil, connection should be closed.
@@ -17,10 +17,15 @@
 package netpoll
 
 import (
+	"context"
 	"errors"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
 // CreateListener return a new Listener.
@@ -28,7 +33,10 @@ func CreateListener(network, addr string) (l Listener, err error) {
 	if network == "udp" || network == "udp4" || network == "udp6" {
 		return nil, Exception(ErrUnsupported, "UDP")
 	}
-	// tcp, tcp4, tcp6, unix
+	if network == "vsock" {
+		return listenVsock(addr)
+	}
+	// tcp, tcp4, tcp6, unix, unixpacket
 	ln, err := net.Listen(network, addr)
 	if err != nil {
 		return nil, err
@@ -36,10 +44,244 @@ func CreateListener(network, addr string) (l Listener, err error) {
 	return ConvertListener(ln)
 }
 
-// ConvertListener converts net.Listener to Listener
+// CreateReusePortListener return a new Listener with SO_REUSEPORT enabled,
+// so that more listeners can be bound to the same address, see WithReusePort.
+func CreateReusePortListener(network, addr string) (l Listener, err error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, Exception(ErrUnsupported, "reuse port on "+network)
+	}
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) (err error) {
+		cerr := c.Control(func(fd uintptr) {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return err
+	}}
+	ln, err := lc.Listen(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertListener(ln)
+}
+
+// CreateFastOpenListener return a new TCP Listener with TCP Fast Open enabled,
+// so that the data sent in the SYN by the clients is accepted without waiting for the handshake.
+// queueLen limits the pending TFO requests which haven't completed the handshake, it only takes effect on Linux.
+// The system must allow TFO for servers as well, e.g. net.ipv4.tcp_fastopen has the 0x2 bit set on Linux.
+func CreateFastOpenListener(network, addr string, queueLen int) (l Listener, err error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, Exception(ErrUnsupported, "fast open on "+network)
+	}
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) (err error) {
+		cerr := c.Control(func(fd uintptr) {
+			err = setTCPFastOpen(int(fd), queueLen)
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return err
+	}}
+	ln, err := lc.Listen(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertListener(ln)
+}
+
+// CreateListenerWithOptions return a new Listener like CreateListener, whose socket is configured by opts,
+// e.g. WithListenBacklog, so that the accept behavior can be tuned without creating the fd by the caller.
+// The tcp options return ErrUnsupported on the unix networks.
+func CreateListenerWithOptions(network, addr string, opts ...ListenerOption) (l Listener, err error) {
+	op := &listenerOptions{}
+	for _, opt := range opts {
+		opt.f(op)
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	case "udp", "udp4", "udp6":
+		return nil, Exception(ErrUnsupported, "UDP")
+	default:
+		if op.deferAccept > 0 || op.freebind || op.v6only != nil || op.multipath || op.transparent {
+			return nil, Exception(ErrUnsupported, "tcp listener options on "+network)
+		}
+	}
+	if op.multipath {
+		// fall back to TCP if MPTCP is unavailable
+		if l, err = listenMPTCP(network, addr, op); err != nil && !mptcpUnavailable(err) {
+			return nil, err
+		}
+	}
+	if l == nil {
+		lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) (err error) {
+			cerr := c.Control(func(fd uintptr) {
+				err = op.control(network, int(fd))
+			})
+			if cerr != nil {
+				return cerr
+			}
+			return err
+		}}
+		ln, err := lc.Listen(context.Background(), network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if l, err = ConvertListener(ln); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	if op.backlog > 0 {
+		// listen again on the listening socket only updates the backlog
+		if err = syscall.Listen(l.Fd(), op.backlog); err != nil {
+			l.Close()
+			return nil, os.NewSyscallError("listen", err)
+		}
+	}
+	return l, nil
+}
+
+// control sets the socket options of the listener before it's bound, network is the one of the socket, e.g. "tcp6".
+func (op *listenerOptions) control(network string, fd int) (err error) {
+	if op.recvBuffer > 0 {
+		if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, op.recvBuffer); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if op.sendBuffer > 0 {
+		if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, op.sendBuffer); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if op.v6only != nil && network == "tcp6" {
+		if err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, boolint(*op.v6only)); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if op.freebind {
+		if err = setFreebind(fd); err != nil {
+			return err
+		}
+	}
+	if op.transparent {
+		if err = setTransparent(fd, network == "tcp6"); err != nil {
+			return err
+		}
+	}
+	if op.deferAccept > 0 {
+		return setTCPDeferAccept(fd, op.deferAccept)
+	}
+	return nil
+}
+
+// maxListenerBacklog is passed to listen by the listeners created from the fd, the kernel caps it by somaxconn.
+const maxListenerBacklog = 1<<16 - 1
+
+// listenMPTCP creates a listener of an IPPROTO_MPTCP socket, whose options are set by op before it's bound.
+// The wildcard address of "tcp" is listened by a dual-stack socket, like net.Listen.
+func listenMPTCP(network, addr string, op *listenerOptions) (Listener, error) {
+	laddr, err := ResolveTCPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	family, ipv6only := favoriteAddrFamily(network, laddr, nil)
+	if network == "tcp" && laddr.isWildcard() {
+		family = syscall.AF_INET6
+	}
+	fd, err := sysSocket(family, syscall.SOCK_STREAM, ipprotoMPTCP)
+	if err != nil {
+		return nil, err
+	}
+	var ln *listener
+	if err = listenTCPSocket(fd, family, ipv6only, laddr, op); err == nil {
+		ln, err = newFDListener(fd)
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return nil, &net.OpError{Op: "listen", Net: network, Addr: laddr.opAddr(), Err: err}
+	}
+	return ln, nil
+}
+
+// listenTCPSocket sets the options of the socket by op, binds it to laddr and listens.
+func listenTCPSocket(fd, family int, ipv6only bool, laddr *TCPAddr, op *listenerOptions) error {
+	// the network passed to control is the one of the socket, like net.ListenConfig
+	network := "tcp4"
+	if family == syscall.AF_INET6 {
+		network = "tcp6"
+		syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, boolint(ipv6only))
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+	if err := op.control(network, fd); err != nil {
+		return err
+	}
+	sa, err := laddr.sockaddr(family)
+	if err != nil {
+		return err
+	}
+	if err = syscall.Bind(fd, sa); err != nil {
+		return os.NewSyscallError("bind", err)
+	}
+	if err = syscall.Listen(fd, maxListenerBacklog); err != nil {
+		return os.NewSyscallError("listen", err)
+	}
+	return nil
+}
+
+// mptcpUnavailable reports whether the IPPROTO_MPTCP socket is refused, e.g. before Linux 5.6,
+// net.mptcp.enabled is 0, or on the other systems, so that TCP is used instead.
+func mptcpUnavailable(err error) bool {
+	var se *os.SyscallError
+	if !errors.As(err, &se) || se.Syscall != "socket" {
+		return false
+	}
+	switch se.Err {
+	case syscall.EPROTONOSUPPORT, syscall.EINVAL, syscall.ENOPROTOOPT, syscall.EAFNOSUPPORT:
+		return true
+	}
+	return false
+}
+
+// reusePortListeners creates n more listeners bound to the same address as ln.
+func reusePortListeners(ln Listener, n int) (lns []Listener, err error) {
+	if n <= 0 || ln.Addr().Network() != "tcp" {
+		return nil, nil
+	}
+	if v, err := unix.GetsockoptInt(ln.Fd(), unix.SOL_SOCKET, unix.SO_REUSEPORT); err != nil || v == 0 {
+		return nil, errors.New("listener must be created by CreateReusePortListener to reuse port")
+	}
+	for i := 0; i < n; i++ {
+		l, err := CreateReusePortListener("tcp", ln.Addr().String())
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, l)
+	}
+	return lns, nil
+}
+
+// ConvertListener converts net.Listener to Listener.
+// The fd of *net.TCPListener and *net.UnixListener is polled directly, and the other implementations,
+// e.g. the listeners of cmux, are accepted by a dedicated goroutine which hands the connections to the poller,
+// so that the data buffered by them is not lost. The accepted connections whose fd can't be extracted
+// are relayed over unix socket pairs, and the socket options take no effect on them.
 func ConvertListener(l net.Listener) (nl Listener, err error) {
-	if tmp, ok := l.(Listener); ok {
+	switch tmp := l.(type) {
+	case Listener:
 		return tmp, nil
+	case *net.TCPListener, *net.UnixListener:
+	default:
+		return newBridgeListener(l)
 	}
 	ln := &listener{}
 	ln.ln = l
@@ -51,17 +293,88 @@ func ConvertListener(l net.Listener) (nl Listener, err error) {
 	return ln, syscall.SetNonblock(ln.fd, true)
 }
 
+// sdListenFDsStart is the first fd passed by systemd socket activation, see sd_listen_fds(3).
+const sdListenFDsStart = 3
+
+// ListenersFromSystemd returns the listeners passed by systemd socket activation with LISTEN_FDS and LISTEN_FDNAMES,
+// which can be served by EventLoop directly. It returns nil if the process is not activated by systemd.
+// The environment variables are unset, so they are not inherited by the child processes.
+// The fds which are not listening stream sockets, e.g. datagram sockets and FIFOs, are skipped and left open.
+func ListenersFromSystemd() ([]SystemdListener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	fds := make([]int, n)
+	for i := range fds {
+		fds[i] = sdListenFDsStart + i
+	}
+	return systemdListeners(fds, strings.Split(os.Getenv("LISTEN_FDNAMES"), ":"))
+}
+
+func systemdListeners(fds []int, names []string) (lns []SystemdListener, err error) {
+	for i, fd := range fds {
+		syscall.CloseOnExec(fd)
+		if v, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ACCEPTCONN); err != nil || v == 0 {
+			continue
+		}
+		ln, err := newFDListener(fd)
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, err
+		}
+		sl := SystemdListener{Listener: ln}
+		if i < len(names) {
+			sl.Name = names[i]
+		}
+		lns = append(lns, sl)
+	}
+	return lns, nil
+}
+
+// newFDListener creates a Listener from the fd of a listening socket, and takes the ownership of fd.
+func newFDListener(fd int) (*listener, error) {
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return nil, os.NewSyscallError("getsockname", err)
+	}
+	addr := sockaddrToAddr(sa)
+	if addr == nil {
+		return nil, errors.New("listener type can't support")
+	}
+	if ua, ok := addr.(*net.UnixAddr); ok {
+		if typ, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TYPE); err == nil && typ == unix.SOCK_SEQPACKET {
+			ua.Net = "unixpacket"
+		}
+	}
+	ln := &listener{fd: fd, addr: addr}
+	return ln, syscall.SetNonblock(fd, true)
+}
+
 var _ net.Listener = &listener{}
 
 type listener struct {
 	fd   int
 	addr net.Addr     // listener's local addr
-	ln   net.Listener // tcp|unix listener
+	ln   net.Listener // tcp|unix listener, nil if created from fd
 	file *os.File
 }
 
 // Accept implements Listener.
 func (ln *listener) Accept() (net.Conn, error) {
+	if _, ok := ln.addr.(*VsockAddr); ok {
+		return ln.acceptVsock()
+	}
 	fd, sa, err := syscall.Accept(ln.fd)
 	if err != nil {
 		/* https://man7.org/linux/man-pages/man2/accept.2.html
@@ -85,6 +398,23 @@ func (ln *listener) Accept() (net.Conn, error) {
 	return nfd, nil
 }
 
+// acceptVsock is like Accept but for the AF_VSOCK listener, whose addresses are not supported by package syscall.
+func (ln *listener) acceptVsock() (net.Conn, error) {
+	fd, raddr, err := acceptVsock(ln.fd)
+	if err != nil {
+		if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK {
+			return nil, nil
+		}
+		return nil, err
+	}
+	nfd := &netFD{}
+	nfd.fd = fd
+	nfd.localAddr = ln.addr
+	nfd.network = ln.addr.Network()
+	nfd.remoteAddr = raddr
+	return nfd, nil
+}
+
 // Close implements Listener.
 func (ln *listener) Close() error {
 	if ln.fd != 0 {
@@ -0,0 +1,241 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly || linux
+
+package netpoll
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// bridgeListener serves the net.Listener whose fd can't be extracted, e.g. the listeners of cmux,
+// by accepting in a dedicated goroutine. The accepted connections are queued and signaled by a pipe,
+// whose read end is polled as the fd of the listener.
+type bridgeListener struct {
+	ln     net.Listener
+	rfd    int // nonblocking, polled by the server
+	wfd    int // blocking, so that accepting is paused if the pipe is full
+	mu     sync.Mutex
+	queue  []bridgeAccept
+	closed bool
+}
+
+type bridgeAccept struct {
+	conn *netFD
+	err  error
+}
+
+func newBridgeListener(ln net.Listener) (*bridgeListener, error) {
+	var p [2]int
+	syscall.ForkLock.RLock()
+	err := syscall.Pipe(p[:])
+	if err == nil {
+		syscall.CloseOnExec(p[0])
+		syscall.CloseOnExec(p[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, os.NewSyscallError("pipe", err)
+	}
+	if err = syscall.SetNonblock(p[0], true); err != nil {
+		syscall.Close(p[0])
+		syscall.Close(p[1])
+		return nil, os.NewSyscallError("setnonblock", err)
+	}
+	bl := &bridgeListener{ln: ln, rfd: p[0], wfd: p[1]}
+	go bl.run()
+	return bl, nil
+}
+
+// run accepts the connections until the listener is closed, and retries the other errors with backoff.
+func (ln *bridgeListener) run() {
+	defer syscall.Close(ln.wfd)
+	var delay time.Duration
+	for {
+		var nfd *netFD
+		conn, err := ln.ln.Accept()
+		if err == nil {
+			delay = 0
+			if nfd, err = bridgeConn(conn); err != nil {
+				conn.Close()
+			}
+		}
+		ln.mu.Lock()
+		if ln.closed {
+			ln.mu.Unlock()
+			if nfd != nil {
+				nfd.Close()
+			}
+			return
+		}
+		ln.queue = append(ln.queue, bridgeAccept{conn: nfd, err: err})
+		ln.mu.Unlock()
+		if _, werr := syscall.Write(ln.wfd, []byte{0}); werr != nil {
+			return
+		}
+		if err == nil || conn != nil {
+			continue
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if delay == 0 {
+			delay = 5 * time.Millisecond
+		} else if delay *= 2; delay > time.Second {
+			delay = time.Second
+		}
+		time.Sleep(delay)
+	}
+}
+
+// Accept implements Listener, it returns the connection queued by the accepting goroutine,
+// or nil if there is none.
+func (ln *bridgeListener) Accept() (net.Conn, error) {
+	var b [1]byte
+	syscall.Read(ln.rfd, b[:])
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	if ln.closed {
+		return nil, net.ErrClosed
+	}
+	if len(ln.queue) == 0 {
+		return nil, nil
+	}
+	accepted := ln.queue[0]
+	ln.queue[0] = bridgeAccept{}
+	ln.queue = ln.queue[1:]
+	if accepted.err != nil {
+		return nil, accepted.err
+	}
+	return accepted.conn, nil
+}
+
+// Close implements Listener.
+func (ln *bridgeListener) Close() error {
+	ln.mu.Lock()
+	if ln.closed {
+		ln.mu.Unlock()
+		return nil
+	}
+	ln.closed = true
+	queue := ln.queue
+	ln.queue = nil
+	ln.mu.Unlock()
+	for _, accepted := range queue {
+		if accepted.conn != nil {
+			accepted.conn.Close()
+		}
+	}
+	err := ln.ln.Close()
+	syscall.Close(ln.rfd)
+	return err
+}
+
+// Addr implements Listener.
+func (ln *bridgeListener) Addr() net.Addr {
+	return ln.ln.Addr()
+}
+
+// Fd implements Listener.
+func (ln *bridgeListener) Fd() (fd int) {
+	return ln.rfd
+}
+
+// bridgeConn converts the connection accepted by bridgeListener to netFD. The fd of *net.TCPConn and *net.UnixConn
+// is duplicated, and the other connections, which may have buffered data, are relayed over a unix socket pair.
+func bridgeConn(conn net.Conn) (*netFD, error) {
+	switch conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		fd, err := dupConnFD(conn.(syscall.Conn))
+		if err != nil {
+			return nil, err
+		}
+		conn.Close()
+		return &netFD{fd: fd, network: conn.LocalAddr().Network(), localAddr: conn.LocalAddr(), remoteAddr: conn.RemoteAddr()}, nil
+	}
+	return relayConn(conn)
+}
+
+// dupConnFD duplicates the fd of conn with close-on-exec set.
+func dupConnFD(conn syscall.Conn) (fd int, err error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	cerr := rc.Control(func(sysfd uintptr) {
+		fd, err = unix.FcntlInt(sysfd, unix.F_DUPFD_CLOEXEC, 0)
+	})
+	if cerr != nil {
+		return -1, cerr
+	}
+	if err != nil {
+		return -1, os.NewSyscallError("fcntl", err)
+	}
+	return fd, nil
+}
+
+// relayConn copies the data between conn and a unix socket pair, whose other end is returned with the addresses
+// of conn. The half close is relayed in both directions, and conn is closed once both directions are finished.
+func relayConn(conn net.Conn) (*netFD, error) {
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, os.NewSyscallError("socketpair", err)
+	}
+	f := os.NewFile(uintptr(fds[1]), "netpoll-relay")
+	fc, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		syscall.Close(fds[0])
+		return nil, err
+	}
+	peer := fc.(*net.UnixConn)
+	var finished int32
+	finish := func() {
+		if atomic.AddInt32(&finished, 1) == 2 {
+			conn.Close()
+			peer.Close()
+		}
+	}
+	go func() {
+		io.Copy(peer, conn)
+		peer.CloseWrite()
+		finish()
+	}()
+	go func() {
+		io.Copy(conn, peer)
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			conn.Close()
+		}
+		finish()
+	}()
+	return &netFD{fd: fds[0], network: "unix", localAddr: conn.LocalAddr(), remoteAddr: conn.RemoteAddr()}, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestListenTransparent(t *testing.T) {
+	// a nonlocal address is only bound by the transparent sockets
+	_, err := CreateListenerWithOptions("tcp", "192.0.2.1:0")
+	MustTrue(t, errors.Is(err, syscall.EADDRNOTAVAIL))
+	ln, err := CreateListenerWithOptions("tcp", "192.0.2.1:0", WithListenTransparent())
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("IP_TRANSPARENT requires CAP_NET_ADMIN")
+	}
+	MustNil(t, err)
+	MustNil(t, ln.Close())
+	// IPV6_TRANSPARENT of the IPv6 sockets
+	if ln, err = CreateListenerWithOptions("tcp6", "[2001:db8::1]:0", WithListenTransparent()); !errors.Is(err, syscall.EAFNOSUPPORT) {
+		MustNil(t, err)
+		MustNil(t, ln.Close())
+	}
+
+	_, err = CreateListenerWithOptions("unix", "/tmp/netpoll_transparent.sock", WithListenTransparent())
+	MustTrue(t, errors.Is(err, ErrUnsupported))
+}
+
+func TestOriginalDst(t *testing.T) {
+	// the accepted connections of the wildcard listener report the address actually connected
+	ln, err := CreateListenerWithOptions("tcp", "0.0.0.0:0", WithListenTransparent())
+	if errors.Is(err, syscall.EPERM) {
+		ln, err = CreateListener("tcp", "0.0.0.0:0")
+	}
+	MustNil(t, err)
+	port := ln.Addr().(*net.TCPAddr).Port
+	dsts := make(chan net.Addr, 1)
+	loop, err := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		return nil
+	}, WithOnPrepare(func(connection Connection) context.Context {
+		dst, err := connection.(SocketConn).OriginalDst()
+		MustNil(t, err)
+		dsts <- dst
+		return context.Background()
+	}))
+	MustNil(t, err)
+	go loop.Serve(ln)
+	defer loop.Shutdown(context.Background())
+
+	conn, err := DialConnection("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	Equal(t, (<-dsts).String(), net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	dst, err := conn.(SocketConn).OriginalDst()
+	MustNil(t, err)
+	Equal(t, dst.String(), conn.LocalAddr().String())
+
+	r, w := GetSysFdPairs()
+	rconn := &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r, network: "unix"}, nil))
+	defer rconn.Close()
+	defer syscall.Close(w)
+	_, err = rconn.OriginalDst()
+	MustTrue(t, errors.Is(err, ErrUnsupported))
+}
@@ -18,8 +18,15 @@ package netpoll
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -29,12 +36,23 @@ func TestListenerDialer(t *testing.T) {
 	addr := getTestAddress()
 	ln, err := CreateListener(network, addr)
 	MustNil(t, err)
-	defer ln.Close()
 	trigger := make(chan int)
 	msg := []byte("0123456789")
 
+	// the listener must be closed by the accepting goroutine, or the fd may be reused by
+	// a listener of other tests before Accept, which will steal its connections.
+	stop := make(chan int, 1)
+	defer close(stop)
+
 	go func() {
 		for {
+			select {
+			case <-stop:
+				err := ln.Close()
+				MustNil(t, err)
+				return
+			default:
+			}
 			conn, err := ln.Accept()
 			if conn == nil && err == nil {
 				continue
@@ -108,3 +126,377 @@ func TestConvertListener(t *testing.T) {
 		panic(err)
 	}
 }
+
+// sniffListener wraps the accepted connections like cmux, whose first bytes have been read and buffered.
+type sniffListener struct {
+	net.Listener
+	sniffed string
+}
+
+func (ln *sniffListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil || ln.sniffed == "" {
+		return conn, err
+	}
+	return &sniffConn{Conn: conn, r: io.MultiReader(strings.NewReader(ln.sniffed), conn)}, nil
+}
+
+type sniffConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *sniffConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func TestServeBridgeListener(t *testing.T) {
+	for _, sniffed := range []string{"", "hello "} {
+		network, address := "tcp", getTestAddress()
+		nln, err := net.Listen(network, address)
+		MustNil(t, err)
+		ln, err := ConvertListener(&sniffListener{Listener: nln, sniffed: sniffed})
+		MustNil(t, err)
+		_, ok := ln.(*bridgeListener)
+		MustTrue(t, ok)
+
+		var remote atomic.Value
+		loop, err := NewEventLoop(func(ctx context.Context, connection Connection) error {
+			remote.Store(connection.RemoteAddr().String())
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			_, err = connection.Write(buf)
+			return err
+		})
+		MustNil(t, err)
+		go loop.Serve(ln)
+
+		for i := 0; i < 3; i++ {
+			conn, err := DialConnection(network, address, time.Second)
+			MustNil(t, err)
+			_, err = conn.Write([]byte("world"))
+			MustNil(t, err)
+			expected := sniffed + "world"
+			buf, err := conn.Reader().Next(len(expected))
+			MustNil(t, err)
+			Equal(t, string(buf), expected)
+			Equal(t, remote.Load().(string), conn.LocalAddr().String())
+
+			// the half close of the client is relayed, and the server closes the connection
+			MustNil(t, conn.(HalfCloser).CloseWrite())
+			_, err = conn.Reader().Next(1)
+			MustTrue(t, err != nil)
+			MustNil(t, conn.Close())
+		}
+		MustNil(t, loop.Shutdown(context.Background()))
+		_, err = nln.Accept()
+		MustTrue(t, errors.Is(err, net.ErrClosed))
+	}
+}
+
+func TestReusePortListener(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	ln, err := CreateListener(network, address)
+	MustNil(t, err)
+	_, err = reusePortListeners(ln, 1)
+	Assert(t, err != nil)
+	MustNil(t, ln.Close())
+
+	ln, err = CreateReusePortListener(network, address)
+	MustNil(t, err)
+	lns, err := reusePortListeners(ln, 3)
+	MustNil(t, err)
+	Equal(t, len(lns), 3)
+	for _, l := range lns {
+		Equal(t, l.Addr().String(), address)
+		MustNil(t, l.Close())
+	}
+
+	// serve by multiple listeners
+	loop, err := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		buf, err := connection.Reader().Next(connection.Reader().Len())
+		if err != nil {
+			return err
+		}
+		_, err = connection.Write(buf)
+		return err
+	}, WithReusePort())
+	MustNil(t, err)
+	go loop.Serve(ln)
+	for i := 0; i < 10; i++ {
+		conn, err := DialConnection(network, address, time.Second)
+		MustNil(t, err)
+		_, err = conn.Write([]byte("ping"))
+		MustNil(t, err)
+		buf, err := conn.Reader().Next(4)
+		MustNil(t, err)
+		Equal(t, string(buf), "ping")
+		MustNil(t, conn.Close())
+	}
+	MustNil(t, loop.Shutdown(context.Background()))
+}
+
+func TestReusePortMaxConnections(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	ln, err := CreateReusePortListener(network, address)
+	MustNil(t, err)
+	var overloaded int32
+	loop, err := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		_, err := connection.Reader().Next(connection.Reader().Len())
+		return err
+	}, WithOnOverload(func(conn net.Conn) {
+		atomic.AddInt32(&overloaded, 1)
+	}), WithNumLoops(4), WithReusePort(), WithMaxConnections(2))
+	MustNil(t, err)
+	go loop.Serve(ln)
+	defer loop.Shutdown(context.Background())
+
+	// the limit is shared by the listeners of the reuseport group
+	var conns []Connection
+	for i := 0; i < 6; i++ {
+		// the dialing fails if the connection has been closed by server already
+		if conn, err := DialConnection(network, address, time.Second); err == nil {
+			conns = append(conns, conn)
+		}
+	}
+	for i := 0; i < 100 && atomic.LoadInt32(&overloaded) < 4; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	Equal(t, atomic.LoadInt32(&overloaded), int32(4))
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func TestReusePortSteering(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_ATTACH_REUSEPORT_CBPF is only supported on linux")
+	}
+	network, address := "tcp", getTestAddress()
+	ln, err := CreateReusePortListener(network, address)
+	MustNil(t, err)
+	polls := make(chan Poll, 1)
+	loop, err := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		buf, err := connection.Reader().Next(connection.Reader().Len())
+		if err != nil {
+			return err
+		}
+		_, err = connection.Write(buf)
+		return err
+	}, WithOnPrepare(func(conn Connection) context.Context {
+		polls <- conn.(*connection).operator.poll
+		return context.Background()
+	}), WithNumLoops(2), WithReusePort(), WithReusePortSteering())
+	MustNil(t, err)
+	pollers := loop.(*eventLoop).pollers
+	go loop.Serve(ln)
+	defer loop.Shutdown(context.Background())
+
+	// the SYN of loopback is received by the cpu of the dialer, which steers the connections to the same poller
+	cpus, err := allowedCPUs()
+	MustNil(t, err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// the thread is not unlocked, so that it's terminated with the goroutine instead of keeping the affinity.
+		runtime.LockOSThread()
+		MustNil(t, setThreadAffinity(cpus[0]))
+		for i := 0; i < 10; i++ {
+			conn, err := DialConnection(network, address, time.Second)
+			MustNil(t, err)
+			_, err = conn.Write([]byte("ping"))
+			MustNil(t, err)
+			buf, err := conn.Reader().Next(4)
+			MustNil(t, err)
+			Equal(t, string(buf), "ping")
+			MustNil(t, conn.Close())
+			MustTrue(t, <-polls == pollers.polls[cpus[0]%2])
+		}
+	}()
+	<-done
+}
+
+func TestFastOpenListener(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	ln, err := CreateFastOpenListener(network, address, 16)
+	MustNil(t, err)
+
+	loop, err := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		buf, err := connection.Reader().Next(connection.Reader().Len())
+		if err != nil {
+			return err
+		}
+		_, err = connection.Write(buf)
+		return err
+	})
+	MustNil(t, err)
+	go loop.Serve(ln)
+
+	// the server falls back to the normal handshake if fast open is disabled by the system.
+	dialer := NewDialer(WithDialFastOpen())
+	for i := 0; i < 3; i++ {
+		conn, err := dialer.DialConnection(network, address, time.Second)
+		MustNil(t, err)
+		_, err = conn.Write([]byte("ping"))
+		MustNil(t, err)
+		buf, err := conn.Reader().Next(4)
+		MustNil(t, err)
+		Equal(t, string(buf), "ping")
+		MustNil(t, conn.Close())
+	}
+	MustNil(t, loop.Shutdown(context.Background()))
+}
+
+func TestListenerWithOptions(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	opts := []ListenerOption{WithListenBacklog(16), WithListenRecvBuffer(1 << 16), WithListenSendBuffer(1 << 16)}
+	if runtime.GOOS == "linux" {
+		opts = append(opts, WithListenDeferAccept(time.Second), WithListenFreebind())
+	}
+	ln, err := CreateListenerWithOptions(network, address, opts...)
+	MustNil(t, err)
+	rcvbuf, err := syscall.GetsockoptInt(ln.Fd(), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	MustNil(t, err)
+	MustTrue(t, rcvbuf >= 1<<16)
+	if runtime.GOOS == "linux" {
+		// the address reserved for documentation is not local
+		fln, err := CreateListenerWithOptions(network, "192.0.2.1:0", WithListenFreebind())
+		MustNil(t, err)
+		MustNil(t, fln.Close())
+	}
+
+	loop, err := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		buf, err := connection.Reader().Next(connection.Reader().Len())
+		if err != nil {
+			return err
+		}
+		_, err = connection.Write(buf)
+		return err
+	})
+	MustNil(t, err)
+	go loop.Serve(ln)
+
+	// the connection is accepted once the data arrives with TCP_DEFER_ACCEPT
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn.Write([]byte("ping"))
+	MustNil(t, err)
+	buf, err := conn.Reader().Next(4)
+	MustNil(t, err)
+	Equal(t, string(buf), "ping")
+	MustNil(t, conn.Close())
+	MustNil(t, loop.Shutdown(context.Background()))
+
+	// the tcp options are not available for unix sockets
+	_, err = CreateListenerWithOptions("unix", "netpoll-listener-options.sock", WithListenV6Only(true))
+	MustTrue(t, errors.Is(err, ErrUnsupported))
+}
+
+func TestMultipathTCP(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	ln, err := CreateListenerWithOptions(network, address, WithListenMultipath())
+	MustNil(t, err)
+	// MPTCP is only available on Linux with net.mptcp.enabled, which falls back to TCP otherwise
+	enabled, _ := os.ReadFile("/proc/sys/net/mptcp/enabled")
+	multipath := strings.TrimSpace(string(enabled)) == "1"
+
+	serverErrs := make(chan error, 2)
+	loop, err := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		buf, err := connection.Reader().Next(connection.Reader().Len())
+		if err != nil {
+			return err
+		}
+		_, err = connection.(TCPInfoProvider).MPTCPInfo()
+		serverErrs <- err
+		_, err = connection.Write(buf)
+		return err
+	})
+	MustNil(t, err)
+	go loop.Serve(ln)
+
+	echo := func(dialer Dialer) (Connection, error) {
+		conn, err := dialer.DialConnection(network, address, time.Second)
+		MustNil(t, err)
+		_, err = conn.Write([]byte("ping"))
+		MustNil(t, err)
+		buf, err := conn.Reader().Next(4)
+		MustNil(t, err)
+		Equal(t, string(buf), "ping")
+		return conn, <-serverErrs
+	}
+	conn, serverErr := echo(NewDialer(WithDialMultipath()))
+	info, err := conn.(TCPInfoProvider).MPTCPInfo()
+	if multipath {
+		MustNil(t, err)
+		MustNil(t, serverErr)
+		MustTrue(t, info.Token != 0)
+	} else {
+		MustTrue(t, errors.Is(err, ErrUnsupported))
+		MustTrue(t, errors.Is(serverErr, ErrUnsupported))
+	}
+	MustNil(t, conn.Close())
+
+	// the clients without MPTCP are accepted as TCP
+	conn, serverErr = echo(NewDialer())
+	_, err = conn.(TCPInfoProvider).MPTCPInfo()
+	MustTrue(t, errors.Is(err, ErrUnsupported))
+	MustTrue(t, errors.Is(serverErr, ErrUnsupported))
+	MustNil(t, conn.Close())
+	MustNil(t, loop.Shutdown(context.Background()))
+
+	_, err = CreateListenerWithOptions("unix", "netpoll-listener-multipath.sock", WithListenMultipath())
+	MustTrue(t, errors.Is(err, ErrUnsupported))
+}
+
+func TestListenersFromSystemd(t *testing.T) {
+	// not activated by systemd
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	lns, err := ListenersFromSystemd()
+	MustNil(t, err)
+	Equal(t, len(lns), 0)
+	Equal(t, os.Getenv("LISTEN_FDS"), "")
+
+	addr := getTestAddress()
+	ln, err := net.Listen("tcp", addr)
+	MustNil(t, err)
+	defer ln.Close()
+	file, err := ln.(*net.TCPListener).File()
+	MustNil(t, err)
+	defer file.Close()
+	fd, err := syscall.Dup(int(file.Fd()))
+	MustNil(t, err)
+	// not a listening socket
+	r, w := GetSysFdPairs()
+	defer syscall.Close(r)
+	defer syscall.Close(w)
+
+	lns, err = systemdListeners([]int{r, fd}, []string{"sock", "web"})
+	MustNil(t, err)
+	Equal(t, len(lns), 1)
+	Equal(t, lns[0].Name, "web")
+	Equal(t, lns[0].Addr().String(), addr)
+
+	loop, err := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		buf, err := connection.Reader().Next(connection.Reader().Len())
+		if err != nil {
+			return err
+		}
+		_, err = connection.Write(buf)
+		return err
+	})
+	MustNil(t, err)
+	go loop.Serve(lns[0])
+	conn, err := DialConnection("tcp", addr, time.Second)
+	MustNil(t, err)
+	_, err = conn.Writer().WriteString("ping")
+	MustNil(t, err)
+	MustNil(t, conn.Writer().Flush())
+	buf, err := conn.Reader().Next(4)
+	MustNil(t, err)
+	Equal(t, string(buf), "ping")
+	conn.Close()
+	MustNil(t, loop.Shutdown(context.Background()))
+}
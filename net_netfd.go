@@ -170,9 +170,10 @@ func (c *netFD) connect(ctx context.Context, la, ra syscall.Sockaddr) (rsa sysca
 
 // Various errors contained in OpError.
 var (
-	errMissingAddress = errors.New("missing address")
-	errCanceled       = errors.New("operation was canceled")
-	errIOTimeout      = errors.New("i/o timeout")
+	errMissingAddress    = errors.New("missing address")
+	errNoSuitableAddress = errors.New("no suitable address found")
+	errCanceled          = errors.New("operation was canceled")
+	errIOTimeout         = errors.New("i/o timeout")
 )
 
 // mapErr maps from the context errors to the historical internal net
@@ -61,12 +61,22 @@ func (c *netFD) Close() (err error) {
 	if !c.detaching && c.fd > 2 {
 		err = syscall.Close(c.fd)
 		if err != nil {
-			logger.Printf("NETPOLL: netFD[%d] close error: %s", c.fd, err.Error())
+			logger.Error("netFD close failed", "fd", c.fd, "err", err)
 		}
 	}
 	return err
 }
 
+// CloseRead shuts down the reading side of the connection.
+func (c *netFD) CloseRead() error {
+	return syscall.Shutdown(c.fd, syscall.SHUT_RD)
+}
+
+// CloseWrite shuts down the writing side of the connection.
+func (c *netFD) CloseWrite() error {
+	return syscall.Shutdown(c.fd, syscall.SHUT_WR)
+}
+
 // LocalAddr implements Conn.
 func (c *netFD) LocalAddr() (addr net.Addr) {
 	return c.localAddr
@@ -89,6 +99,38 @@ func (c *netFD) SetKeepAlive(second int) error {
 	return nil
 }
 
+// SetNoDelay implements SocketTuner.
+func (c *netFD) SetNoDelay(noDelay bool) error {
+	if !strings.HasPrefix(c.network, "tcp") {
+		return nil
+	}
+	return setTCPNoDelay(c.fd, noDelay)
+}
+
+// SetQuickAck implements SocketTuner.
+func (c *netFD) SetQuickAck(quickAck bool) error {
+	if !strings.HasPrefix(c.network, "tcp") {
+		return nil
+	}
+	return setTCPQuickAck(c.fd, quickAck)
+}
+
+// SetTCPKeepAlive implements SocketTuner.
+func (c *netFD) SetTCPKeepAlive(idle, interval time.Duration, count int) error {
+	if !strings.HasPrefix(c.network, "tcp") {
+		return nil
+	}
+	return SetKeepAliveConfig(c.fd, keepAliveSeconds(idle), keepAliveSeconds(interval), count)
+}
+
+// keepAliveSeconds rounds up d to seconds, since the keepalive options are in seconds.
+func keepAliveSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int((d + time.Second - 1) / time.Second)
+}
+
 // SetDeadline implements Conn.
 func (c *netFD) SetDeadline(t time.Time) error {
 	return Exception(ErrUnsupported, "SetDeadline")
@@ -22,7 +22,7 @@ import (
 
 func newPollDesc(fd int) *pollDesc {
 	pd := &pollDesc{}
-	poll := pollmanager.Pick()
+	poll := pollmanager.pick(fd)
 	pd.operator = poll.Alloc()
 	pd.operator.poll = poll
 	pd.operator.FD = fd
@@ -45,7 +45,7 @@ func (pd *pollDesc) WaitWrite(ctx context.Context) (err error) {
 	if pd.operator.isUnused() {
 		// add ET|Write|Hup
 		if err = pd.operator.Control(PollWritable); err != nil {
-			logger.Printf("NETPOLL: pollDesc register operator failed: %v", err)
+			logger.Error("pollDesc register operator failed", "fd", pd.operator.FD, "err", err)
 			return err
 		}
 	}
@@ -90,6 +90,6 @@ func (pd *pollDesc) onhup(p Poll) error {
 
 func (pd *pollDesc) detach() {
 	if err := pd.operator.Control(PollDetach); err != nil {
-		logger.Printf("NETPOLL: pollDesc detach operator failed: %v", err)
+		logger.Error("pollDesc detach operator failed", "fd", pd.operator.FD, "err", err)
 	}
 }
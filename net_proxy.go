@@ -0,0 +1,231 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialProxy dials address through the proxy, the handshake is performed with the connection registered
+// in the poller, so the data left after the handshake is kept by the Reader of the returned connection.
+func (d *dialer) dialProxy(ctx context.Context, network, address string) (Connection, error) {
+	proxy := d.opts.proxy
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	portnum, err := net.DefaultResolver.LookupPort(ctx, network, port)
+	if err != nil {
+		return nil, err
+	}
+	proxyAddr := proxy.Host
+	if proxy.Port() == "" {
+		if proxy.Scheme == "http" {
+			proxyAddr = net.JoinHostPort(proxy.Hostname(), "80")
+		} else {
+			proxyAddr = net.JoinHostPort(proxy.Hostname(), "1080")
+		}
+	}
+	conn, err := d.dialTCP(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadTimeout(time.Until(deadline))
+	}
+	// the handshake is aborted once ctx is done
+	conn.SetReadContext(ctx)
+	switch proxy.Scheme {
+	case "socks5":
+		// resolve the host locally, "socks5h" leaves it to the proxy.
+		if ip := net.ParseIP(host); ip == nil {
+			var ipaddrs []net.IPAddr
+			if ipaddrs, err = d.resolver().LookupIPAddr(ctx, host); err == nil && len(ipaddrs) == 0 {
+				err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			}
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			host = ipaddrs[0].IP.String()
+		}
+		err = socks5Handshake(conn, proxy.User, host, portnum)
+	case "socks5h":
+		err = socks5Handshake(conn, proxy.User, host, portnum)
+	case "http":
+		err = httpConnectHandshake(conn, proxy.User, net.JoinHostPort(host, strconv.Itoa(portnum)))
+	default:
+		err = fmt.Errorf("unsupported proxy scheme[%s]", proxy.Scheme)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, &net.OpError{Op: "proxyconnect", Net: network, Addr: conn.RemoteAddr(), Err: err}
+	}
+	conn.SetReadTimeout(0)
+	conn.SetReadContext(nil)
+	return conn, nil
+}
+
+// socks5Handshake performs the handshake of RFC 1928, and the username/password authentication of RFC 1929.
+func socks5Handshake(conn Connection, user *url.Userinfo, host string, port int) error {
+	methods := []byte{0x00} // no authentication
+	if user != nil {
+		methods = append(methods, 0x02) // username/password
+	}
+	writer, reader := conn.Writer(), conn.Reader()
+	writer.WriteBinary(append([]byte{0x05, byte(len(methods))}, methods...))
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	reply, err := reader.Next(2)
+	if err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return errors.New("socks5: invalid version")
+	}
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if user == nil {
+			return errors.New("socks5: authentication required")
+		}
+		password, _ := user.Password()
+		if len(user.Username()) > 255 || len(password) > 255 {
+			return errors.New("socks5: username or password too long")
+		}
+		buf := []byte{0x01, byte(len(user.Username()))}
+		buf = append(buf, user.Username()...)
+		buf = append(buf, byte(len(password)))
+		buf = append(buf, password...)
+		writer.WriteBinary(buf)
+		if err = writer.Flush(); err != nil {
+			return err
+		}
+		if reply, err = reader.Next(2); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("socks5: authentication failed")
+		}
+	default:
+		return errors.New("socks5: no acceptable authentication method")
+	}
+
+	req := []byte{0x05, 0x01, 0x00} // CONNECT
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New("socks5: host too long")
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, 0x01)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, 0x04)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	writer.WriteBinary(req)
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	if reply, err = reader.Next(4); err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		return fmt.Errorf("socks5: connect failed with reply %d", reply[1])
+	}
+	// skip the bound address
+	var size int
+	switch reply[3] {
+	case 0x01:
+		size = net.IPv4len
+	case 0x04:
+		size = net.IPv6len
+	case 0x03:
+		var n byte
+		if n, err = reader.ReadByte(); err != nil {
+			return err
+		}
+		size = int(n)
+	default:
+		return errors.New("socks5: invalid address type")
+	}
+	if err = reader.Skip(size + 2); err != nil {
+		return err
+	}
+	return reader.Release()
+}
+
+const (
+	maxProxyLineSize   = 4 << 10  // the max size of a line of the response of the http proxy
+	maxProxyHeaderSize = 64 << 10 // the max size of the response header of the http proxy
+)
+
+// httpConnectHandshake establishes a tunnel by the CONNECT method of HTTP/1.1.
+// ErrLineTooLong is returned if a line of the response exceeds maxProxyLineSize,
+// or the whole response header exceeds maxProxyHeaderSize.
+func httpConnectHandshake(conn Connection, user *url.Userinfo, address string) error {
+	req := "CONNECT " + address + " HTTP/1.1\r\nHost: " + address + "\r\n"
+	if user != nil {
+		password, _ := user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req += "Proxy-Authorization: Basic " + auth + "\r\n"
+	}
+	writer, reader := conn.Writer(), conn.Reader()
+	writer.WriteString(req + "\r\n")
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	status, err := UntilN(reader, '\n', maxProxyLineSize)
+	if err != nil {
+		return err
+	}
+	size := len(status)
+	// HTTP/1.1 200 Connection established
+	fields := strings.SplitN(strings.TrimSpace(string(status)), " ", 3)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "HTTP/") {
+		return errors.New("http proxy: malformed response")
+	}
+	if fields[1] != "200" {
+		return fmt.Errorf("http proxy: %s", strings.Join(fields[1:], " "))
+	}
+	// skip the headers
+	for {
+		line, err := UntilN(reader, '\n', maxProxyLineSize)
+		if err != nil {
+			return err
+		}
+		if len(line) <= 2 {
+			break
+		}
+		if size += len(line); size > maxProxyHeaderSize {
+			return Exception(ErrLineTooLong, fmt.Sprintf("http proxy: response header exceeds %d bytes", maxProxyHeaderSize))
+		}
+	}
+	return reader.Release()
+}
@@ -0,0 +1,224 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveTestProxy accepts the connections of ln and serves each one by handshake,
+// which returns the target address to tunnel, or empty if it's rejected.
+func serveTestProxy(ln net.Listener, handshake func(conn net.Conn, rd *bufio.Reader) string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			rd := bufio.NewReader(conn)
+			target := handshake(conn, rd)
+			if target == "" {
+				return
+			}
+			tconn, err := net.Dial("tcp", target)
+			if err != nil {
+				return
+			}
+			defer tconn.Close()
+			go io.Copy(tconn, rd)
+			io.Copy(conn, tconn)
+		}()
+	}
+}
+
+func socks5TestHandshake(conn net.Conn, rd *bufio.Reader) string {
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(rd, buf[:2]); err != nil {
+		return ""
+	}
+	io.ReadFull(rd, buf[:buf[1]])
+	// require username/password
+	conn.Write([]byte{0x05, 0x02})
+	io.ReadFull(rd, buf[:2])
+	user := make([]byte, buf[1])
+	io.ReadFull(rd, user)
+	io.ReadFull(rd, buf[:1])
+	password := make([]byte, buf[0])
+	io.ReadFull(rd, password)
+	if string(user) != "user" || string(password) != "password" {
+		conn.Write([]byte{0x01, 0x01})
+		return ""
+	}
+	conn.Write([]byte{0x01, 0x00})
+
+	io.ReadFull(rd, buf[:4])
+	var host string
+	switch buf[3] {
+	case 0x01:
+		io.ReadFull(rd, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case 0x03:
+		io.ReadFull(rd, buf[:1])
+		name := make([]byte, buf[0])
+		io.ReadFull(rd, name)
+		host = string(name)
+	}
+	io.ReadFull(rd, buf[:2])
+	port := binary.BigEndian.Uint16(buf[:2])
+	if host != "127.0.0.1" {
+		conn.Write([]byte{0x05, 0x04, 0x00, 0x01, 0, 0, 0, 0, 0, 0}) // host unreachable
+		return ""
+	}
+	conn.Write([]byte{0x05, 0x00, 0x00, 0x03, 4, 'n', 'a', 'm', 'e', 0, 0})
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+func httpTestHandshake(conn net.Conn, rd *bufio.Reader) string {
+	req, err := http.ReadRequest(rd)
+	if err != nil || req.Method != http.MethodConnect {
+		return ""
+	}
+	if user, password, ok := parseTestProxyAuth(req); !ok || user != "user" || password != "password" {
+		conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+		return ""
+	}
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\nX-Proxy: test\r\n\r\n"))
+	return req.Host
+}
+
+func parseTestProxyAuth(req *http.Request) (user, password string, ok bool) {
+	// the same format as the Authorization header
+	r := &http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}
+	return r.BasicAuth()
+}
+
+func TestDialProxy(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			// greet first, and then echo
+			conn.Write([]byte("hello"))
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	for scheme, handshake := range map[string]func(net.Conn, *bufio.Reader) string{
+		"socks5":  socks5TestHandshake,
+		"socks5h": socks5TestHandshake,
+		"http":    httpTestHandshake,
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		MustNil(t, err)
+		go serveTestProxy(ln, handshake)
+
+		proxy := &url.URL{Scheme: scheme, User: url.UserPassword("user", "password"), Host: ln.Addr().String()}
+		dialer := NewDialer(WithDialProxy(proxy))
+		conn, err := dialer.DialConnection("tcp", target.Addr().String(), time.Second)
+		MustNil(t, err)
+		// the data sent right after the handshake is kept
+		buf, err := conn.Reader().Next(5)
+		MustNil(t, err)
+		Equal(t, string(buf), "hello")
+		_, err = conn.Write([]byte("ping"))
+		MustNil(t, err)
+		buf, err = conn.Reader().Next(4)
+		MustNil(t, err)
+		Equal(t, string(buf), "ping")
+		conn.Close()
+
+		// wrong password
+		proxy.User = url.UserPassword("user", "wrong")
+		_, err = NewDialer(WithDialProxy(proxy)).DialConnection("tcp", target.Addr().String(), time.Second)
+		Assert(t, err != nil, scheme)
+		ln.Close()
+	}
+
+	// the host is resolved by the proxy with socks5h
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+	go serveTestProxy(ln, socks5TestHandshake)
+	_, port, _ := net.SplitHostPort(target.Addr().String())
+	proxy := &url.URL{Scheme: "socks5h", User: url.UserPassword("user", "password"), Host: ln.Addr().String()}
+	_, err = NewDialer(WithDialProxy(proxy)).DialConnection("tcp", "localhost:"+port, time.Second)
+	Assert(t, err != nil) // the test proxy only accepts 127.0.0.1
+	proxy.Scheme = "socks5"
+	dialer := NewDialer(WithDialProxy(proxy), WithDialResolver(testResolver{"localhost": {{IP: net.IPv4(127, 0, 0, 1)}}}))
+	conn, err := dialer.DialConnection("tcp", "localhost:"+port, time.Second)
+	MustNil(t, err)
+	conn.Close()
+}
+
+func TestDialProxyHandshakeLimits(t *testing.T) {
+	for _, resp := range []string{
+		"HTTP/1.1 200 " + strings.Repeat("x", maxProxyLineSize) + "\r\n\r\n",
+		"HTTP/1.1 200 OK\r\n" + strings.Repeat("X-Proxy: "+strings.Repeat("x", 1000)+"\r\n", maxProxyHeaderSize/1000) + "\r\n",
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		MustNil(t, err)
+		go serveTestProxy(ln, func(conn net.Conn, rd *bufio.Reader) string {
+			if _, err := http.ReadRequest(rd); err != nil {
+				return ""
+			}
+			conn.Write([]byte(resp))
+			// keep the connection until the client gives up
+			io.Copy(io.Discard, rd)
+			return ""
+		})
+		proxy := &url.URL{Scheme: "http", Host: ln.Addr().String()}
+		_, err = NewDialer(WithDialProxy(proxy)).DialConnection("tcp", "127.0.0.1:80", time.Second)
+		Assert(t, errors.Is(err, ErrLineTooLong), err)
+		ln.Close()
+	}
+}
+
+func TestDialProxyContextCanceled(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+	// the proxy never responds
+	go serveTestProxy(ln, func(conn net.Conn, rd *bufio.Reader) string {
+		io.Copy(io.Discard, rd)
+		return ""
+	})
+
+	proxy := &url.URL{Scheme: "socks5", Host: ln.Addr().String()}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	begin := time.Now()
+	_, err = NewDialer(WithDialProxy(proxy)).DialContext(ctx, "tcp", "127.0.0.1:80")
+	Assert(t, errors.Is(err, context.Canceled), err)
+	Assert(t, time.Since(begin) < time.Second, time.Since(begin))
+}
@@ -154,6 +154,11 @@ func newTCPConnection(conn Conn) (connection *TCPConnection, err error) {
 // If the IP field of raddr is nil or an unspecified IP address, the
 // local system is assumed.
 func DialTCP(ctx context.Context, network string, laddr, raddr *TCPAddr) (*TCPConnection, error) {
+	return dialTCP(ctx, network, laddr, raddr, false, nil)
+}
+
+// dialTCP dials by an IPPROTO_MPTCP socket if multipath is set, and falls back to TCP if MPTCP is unavailable.
+func dialTCP(ctx context.Context, network string, laddr, raddr *TCPAddr, multipath bool, ctrlFn func(fd int) error) (*TCPConnection, error) {
 	switch network {
 	case "tcp", "tcp4", "tcp6":
 	default:
@@ -165,7 +170,10 @@ func DialTCP(ctx context.Context, network string, laddr, raddr *TCPAddr) (*TCPCo
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	sd := &sysDialer{network: network, address: raddr.String()}
+	sd := &sysDialer{network: network, address: raddr.String(), ctrlFn: ctrlFn}
+	if multipath {
+		sd.proto = ipprotoMPTCP
+	}
 	c, err := sd.dialTCP(ctx, laddr, raddr)
 	if err != nil {
 		return nil, &net.OpError{Op: "dial", Net: network, Source: laddr.opAddr(), Addr: raddr.opAddr(), Err: err}
@@ -174,7 +182,7 @@ func DialTCP(ctx context.Context, network string, laddr, raddr *TCPAddr) (*TCPCo
 }
 
 func (sd *sysDialer) dialTCP(ctx context.Context, laddr, raddr *TCPAddr) (*TCPConnection, error) {
-	conn, err := internetSocket(ctx, sd.network, laddr, raddr, syscall.SOCK_STREAM, 0, "dial")
+	conn, err := sd.tcpSocket(ctx, laddr, raddr)
 
 	// TCP has a rarely used mechanism called a 'simultaneous connection' in
 	// which Dial("tcp", addr1, addr2) run on the machine at addr1 can
@@ -204,7 +212,7 @@ func (sd *sysDialer) dialTCP(ctx context.Context, laddr, raddr *TCPAddr) (*TCPCo
 		if err == nil {
 			conn.Close()
 		}
-		conn, err = internetSocket(ctx, sd.network, laddr, raddr, syscall.SOCK_STREAM, 0, "dial")
+		conn, err = sd.tcpSocket(ctx, laddr, raddr)
 	}
 
 	if err != nil {
@@ -213,6 +221,17 @@ func (sd *sysDialer) dialTCP(ctx context.Context, laddr, raddr *TCPAddr) (*TCPCo
 	return newTCPConnection(conn)
 }
 
+// tcpSocket creates a connected socket of sd.proto, or TCP if sd.proto is unavailable.
+func (sd *sysDialer) tcpSocket(ctx context.Context, laddr, raddr *TCPAddr) (*netFD, error) {
+	if sd.proto != 0 {
+		conn, err := internetSocket(ctx, sd.network, laddr, raddr, syscall.SOCK_STREAM, sd.proto, "dial", sd.ctrlFn)
+		if !mptcpUnavailable(err) {
+			return conn, err
+		}
+	}
+	return internetSocket(ctx, sd.network, laddr, raddr, syscall.SOCK_STREAM, 0, "dial", sd.ctrlFn)
+}
+
 func selfConnect(conn *netFD, err error) bool {
 	// If the connect failed, we clearly didn't connect to ourselves.
 	if err != nil {
@@ -89,13 +89,17 @@ func newUnixConnection(conn Conn) (connection *UnixConnection, err error) {
 // If laddr is non-nil, it is used as the local address for the
 // connection.
 func DialUnix(network string, laddr, raddr *UnixAddr) (*UnixConnection, error) {
+	return dialUnix(context.Background(), network, laddr, raddr)
+}
+
+func dialUnix(ctx context.Context, network string, laddr, raddr *UnixAddr) (*UnixConnection, error) {
 	switch network {
 	case "unix", "unixgram", "unixpacket":
 	default:
 		return nil, &net.OpError{Op: "dial", Net: network, Source: laddr.opAddr(), Addr: raddr.opAddr(), Err: net.UnknownNetworkError(network)}
 	}
 	sd := &sysDialer{network: network, address: raddr.String()}
-	c, err := sd.dialUnix(context.Background(), laddr, raddr)
+	c, err := sd.dialUnix(ctx, laddr, raddr)
 	if err != nil {
 		return nil, &net.OpError{Op: "dial", Net: network, Source: laddr.opAddr(), Addr: raddr.opAddr(), Err: err}
 	}
@@ -139,5 +143,5 @@ func unixSocket(ctx context.Context, network string, laddr, raddr sockaddr, mode
 		return nil, errors.New("unknown mode: " + mode)
 	}
 
-	return socket(ctx, network, syscall.AF_UNIX, sotype, 0, false, laddr, raddr)
+	return socket(ctx, network, syscall.AF_UNIX, sotype, 0, false, laddr, raddr, nil)
 }
@@ -0,0 +1,111 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Well-known context IDs of vsock, see vsock(7).
+const (
+	VsockCIDAny   uint32 = 0xffffffff // any address, which is used to listen on all the CIDs
+	VsockCIDLocal uint32 = 1          // the local loopback, which needs the vsock_loopback module
+	VsockCIDHost  uint32 = 2          // the host, which is used by the guests to connect the host
+)
+
+// VsockAddr represents the address of an AF_VSOCK end point, which is used for the communication
+// between the virtual machines and the host, e.g. the guests of Firecracker or Kata Containers.
+type VsockAddr struct {
+	CID  uint32
+	Port uint32
+}
+
+// Network returns the address's network name, "vsock".
+func (a *VsockAddr) Network() string {
+	return "vsock"
+}
+
+// String returns the address in the form of "cid:port".
+func (a *VsockAddr) String() string {
+	if a == nil {
+		return "<nil>"
+	}
+	return strconv.FormatUint(uint64(a.CID), 10) + ":" + strconv.FormatUint(uint64(a.Port), 10)
+}
+
+// ResolveVsockAddr parses address of the form "cid:port" or "vsock://cid:port" as a VsockAddr,
+// where an empty cid means VsockCIDAny.
+func ResolveVsockAddr(network, address string) (*VsockAddr, error) {
+	if network != "vsock" {
+		return nil, net.UnknownNetworkError(network)
+	}
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(address, "vsock://"))
+	if err != nil {
+		return nil, err
+	}
+	addr := &VsockAddr{CID: VsockCIDAny}
+	if host != "" {
+		cid, err := strconv.ParseUint(host, 10, 32)
+		if err != nil {
+			return nil, &net.AddrError{Err: "invalid vsock cid", Addr: address}
+		}
+		addr.CID = uint32(cid)
+	}
+	p, err := strconv.ParseUint(port, 10, 32)
+	if err != nil {
+		return nil, &net.AddrError{Err: "invalid vsock port", Addr: address}
+	}
+	addr.Port = uint32(p)
+	return addr, nil
+}
+
+// VsockConnection implements Connection.
+type VsockConnection struct {
+	connection
+}
+
+// DialVsock connects to raddr over AF_VSOCK, it's only supported on Linux.
+func DialVsock(ctx context.Context, raddr *VsockAddr) (*VsockConnection, error) {
+	if raddr == nil || raddr.CID == VsockCIDAny {
+		return nil, &net.OpError{Op: "dial", Net: "vsock", Err: errMissingAddress}
+	}
+	nfd, err := dialVsock(ctx, raddr)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: "vsock", Addr: raddr, Err: err}
+	}
+	conn := &VsockConnection{}
+	if err = conn.init(nfd, nil); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// listenVsock creates the listener of network "vsock".
+func listenVsock(address string) (Listener, error) {
+	laddr, err := ResolveVsockAddr("vsock", address)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := newVsockListener(laddr)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: "vsock", Addr: laddr, Err: err}
+	}
+	return ln, nil
+}
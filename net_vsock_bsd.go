@@ -0,0 +1,37 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package netpoll
+
+import (
+	"context"
+	"net"
+)
+
+// newVsockListener is not supported since there is no AF_VSOCK on bsd systems.
+func newVsockListener(laddr *VsockAddr) (*listener, error) {
+	return nil, Exception(ErrUnsupported, "AF_VSOCK")
+}
+
+// acceptVsock is not supported since there is no AF_VSOCK on bsd systems.
+func acceptVsock(fd int) (nfd int, raddr net.Addr, err error) {
+	return -1, nil, Exception(ErrUnsupported, "AF_VSOCK")
+}
+
+// dialVsock is not supported since there is no AF_VSOCK on bsd systems.
+func dialVsock(ctx context.Context, raddr *VsockAddr) (nfd *netFD, err error) {
+	return nil, Exception(ErrUnsupported, "AF_VSOCK")
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"context"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// newVsockListener creates a listening AF_VSOCK socket bound to laddr.
+func newVsockListener(laddr *VsockAddr) (*listener, error) {
+	fd, err := sysSocket(unix.AF_VSOCK, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = unix.Bind(fd, &unix.SockaddrVM{CID: laddr.CID, Port: laddr.Port}); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if err = syscall.Listen(fd, syscall.SOMAXCONN); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("listen", err)
+	}
+	// the port is assigned by the kernel if it's VMADDR_PORT_ANY
+	if sa, err := unix.Getsockname(fd); err == nil {
+		laddr = vsockAddr(sa)
+	}
+	return &listener{fd: fd, addr: laddr}, nil
+}
+
+// acceptVsock accepts a connection from the listening AF_VSOCK socket.
+func acceptVsock(fd int) (nfd int, raddr net.Addr, err error) {
+	nfd, sa, err := unix.Accept(fd)
+	if err != nil {
+		return -1, nil, err
+	}
+	syscall.CloseOnExec(nfd)
+	return nfd, vsockAddr(sa), nil
+}
+
+// dialVsock connects to raddr with a nonblocking AF_VSOCK socket.
+func dialVsock(ctx context.Context, raddr *VsockAddr) (nfd *netFD, err error) {
+	fd, err := sysSocket(unix.AF_VSOCK, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	nfd = newNetFD(fd, unix.AF_VSOCK, syscall.SOCK_STREAM, "vsock")
+	if err = nfd.connectVsock(ctx, &unix.SockaddrVM{CID: raddr.CID, Port: raddr.Port}); err != nil {
+		nfd.Close()
+		return nil, err
+	}
+	nfd.isConnected = true
+	if sa, err := unix.Getsockname(fd); err == nil {
+		nfd.localAddr = vsockAddr(sa)
+	}
+	nfd.remoteAddr = raddr
+	return nfd, nil
+}
+
+// connectVsock is like connect but for the AF_VSOCK sockets, which are not supported by package syscall.
+func (c *netFD) connectVsock(ctx context.Context, ra unix.Sockaddr) error {
+	switch err := unix.Connect(c.fd, ra); err {
+	case syscall.EINPROGRESS, syscall.EALREADY, syscall.EINTR:
+	case nil, syscall.EISCONN:
+		return nil
+	default:
+		return os.NewSyscallError("connect", err)
+	}
+
+	c.pd = newPollDesc(c.fd)
+	defer func() {
+		// free operator to avoid leak
+		c.pd.operator.Free()
+		c.pd = nil
+	}()
+	for {
+		if err := c.pd.WaitWrite(ctx); err != nil {
+			return err
+		}
+		nerr, err := syscall.GetsockoptInt(c.fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+		if err != nil {
+			return os.NewSyscallError("getsockopt", err)
+		}
+		switch err := syscall.Errno(nerr); err {
+		case syscall.EINPROGRESS, syscall.EALREADY, syscall.EINTR:
+		case syscall.EISCONN:
+			return nil
+		case syscall.Errno(0):
+			// the poller can wake us up spuriously, check that we are really connected.
+			if _, err := unix.Getpeername(c.fd); err == nil {
+				return nil
+			}
+		default:
+			return os.NewSyscallError("connect", err)
+		}
+	}
+}
+
+// vsockAddr converts sa to *VsockAddr, nil if it's not an AF_VSOCK address.
+func vsockAddr(sa unix.Sockaddr) *VsockAddr {
+	if vm, ok := sa.(*unix.SockaddrVM); ok {
+		return &VsockAddr{CID: vm.CID, Port: vm.Port}
+	}
+	return nil
+}
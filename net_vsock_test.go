@@ -0,0 +1,81 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestResolveVsockAddr(t *testing.T) {
+	addr, err := ResolveVsockAddr("vsock", "vsock://3:1024")
+	MustNil(t, err)
+	Equal(t, addr.CID, uint32(3))
+	Equal(t, addr.Port, uint32(1024))
+	Equal(t, addr.String(), "3:1024")
+	Equal(t, addr.Network(), "vsock")
+
+	addr, err = ResolveVsockAddr("vsock", ":1024")
+	MustNil(t, err)
+	Equal(t, addr.CID, VsockCIDAny)
+
+	_, err = ResolveVsockAddr("vsock", "host:1024")
+	Assert(t, err != nil)
+	_, err = ResolveVsockAddr("tcp", "3:1024")
+	Assert(t, err != nil)
+}
+
+func TestVsockConnection(t *testing.T) {
+	ln, err := CreateListener("vsock", fmt.Sprintf(":%d", 1024+time.Now().UnixNano()%10000))
+	if errors.Is(err, syscall.EAFNOSUPPORT) {
+		t.Skip("AF_VSOCK is not supported")
+	}
+	MustNil(t, err)
+	addr := ln.Addr().(*VsockAddr)
+	Equal(t, addr.CID, VsockCIDAny)
+
+	loop, err := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		buf, err := connection.Reader().Next(connection.Reader().Len())
+		if err != nil {
+			return err
+		}
+		_, err = connection.Write(buf)
+		return err
+	})
+	MustNil(t, err)
+	go loop.Serve(ln)
+	defer loop.Shutdown(context.Background())
+
+	// the local loopback is only available with the vsock_loopback module
+	conn, err := DialConnection("vsock", fmt.Sprintf("%d:%d", VsockCIDLocal, addr.Port), 200*time.Millisecond)
+	if err != nil {
+		t.Skipf("vsock loopback is unavailable: %v", err)
+	}
+	defer conn.Close()
+	_, ok := conn.(*VsockConnection)
+	MustTrue(t, ok)
+	Equal(t, conn.RemoteAddr().String(), fmt.Sprintf("%d:%d", VsockCIDLocal, addr.Port))
+	_, err = conn.Write([]byte("ping"))
+	MustNil(t, err)
+	buf, err := conn.Reader().Next(4)
+	MustNil(t, err)
+	Equal(t, string(buf), "ping")
+}
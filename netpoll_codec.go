@@ -0,0 +1,263 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// FrameDecoder splits the input data of a connection into frames, see WithFrameDecoder.
+type FrameDecoder interface {
+	// Decode slices a complete frame from reader, e.g. by Reader.Slice.
+	// If the buffered data is not a complete frame yet, it returns a nil frame without reading anything,
+	// and need is the size of the buffered data needed to decode again, or 0 if unknown.
+	// The connection is closed if an error is returned, e.g. the frame is too large.
+	Decode(reader Reader) (frame Reader, need int, err error)
+}
+
+// DecodeFrames returns an OnRequest which calls onRequest once for each complete frame decoded by decoder,
+// so that it's also available for the connections not created by EventLoop, e.g. by Connection.SetOnRequest.
+// The Reader of the connection passed to onRequest only reads the frame, which is released after onRequest returns.
+// The read watermark of the connection is managed by DecodeFrames, see BufferTuner.SetReadWatermark.
+func DecodeFrames(decoder FrameDecoder, onRequest OnRequest) OnRequest {
+	return func(ctx context.Context, connection Connection) error {
+		reader := connection.Reader()
+		for reader.Len() > 0 && connection.IsActive() {
+			frame, need, err := decoder.Decode(reader)
+			if err != nil {
+				connection.Close()
+				return err
+			}
+			if frame == nil {
+				// not be called again until the frame is complete
+				if need <= reader.Len() {
+					need = reader.Len() + 1
+				}
+				return setReadWatermark(connection, need)
+			}
+			err = onRequest(ctx, newFrameConnection(connection, frame))
+			frame.Release()
+			reader.Release()
+			if err != nil {
+				return err
+			}
+		}
+		return setReadWatermark(connection, 0)
+	}
+}
+
+// setReadWatermark sets the read watermark of conn if it implements BufferTuner, otherwise OnRequest
+// is called once any data is buffered, and the incomplete frame is decoded again.
+func setReadWatermark(conn Connection, n int) error {
+	if bt, ok := conn.(BufferTuner); ok {
+		return bt.SetReadWatermark(n)
+	}
+	return nil
+}
+
+// pollConnection is the Connection served by a poller, which implements all the optional interfaces of Connection.
+type pollConnection interface {
+	Connection
+	SocketConn
+	BufferTuner
+	HalfCloser
+	SocketTuner
+	AsyncFlusher
+	AutoFlusher
+	StatsProvider
+	Heartbeater
+	LoopRunner
+	ConcurrentWriter
+	ReadContextSetter
+	CloseNotifier
+	PriorityCloseCallbackAdder
+	UserDataHolder
+	RateLimiter
+	TCPInfoProvider
+	NetConnCompatSetter
+	KernelTLSEnabler
+}
+
+// newFrameConnection returns the connection passed to OnRequest by DecodeFrames, whose Reader only reads the frame.
+// The optional interfaces of the connections served by the pollers are kept.
+func newFrameConnection(conn Connection, frame Reader) Connection {
+	if pc, ok := conn.(pollConnection); ok {
+		return &pollFrameConnection{pollConnection: pc, frame: frame}
+	}
+	return &frameConnection{Connection: conn, frame: frame}
+}
+
+// frameConnection is the connection passed to OnRequest by DecodeFrames, whose Reader only reads the frame.
+type frameConnection struct {
+	Connection
+	frame Reader
+}
+
+// Reader implements Connection.
+func (c *frameConnection) Reader() Reader {
+	return c.frame
+}
+
+// Read implements Connection.
+func (c *frameConnection) Read(p []byte) (n int, err error) {
+	return readFrame(c.frame, p)
+}
+
+// pollFrameConnection is the frameConnection of the connections served by the pollers.
+type pollFrameConnection struct {
+	pollConnection
+	frame Reader
+}
+
+// Reader implements Connection.
+func (c *pollFrameConnection) Reader() Reader {
+	return c.frame
+}
+
+// Read implements Connection.
+func (c *pollFrameConnection) Read(p []byte) (n int, err error) {
+	return readFrame(c.frame, p)
+}
+
+// readFrame implements net.Conn.Read by the frame.
+func readFrame(frame Reader, p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if n = frame.Len(); n == 0 {
+		return 0, io.EOF
+	}
+	if n > len(p) {
+		n = len(p)
+	}
+	buf, err := frame.Next(n)
+	return copy(p, buf), err
+}
+
+// NewLengthFieldDecoder returns a FrameDecoder for the frames which contain a big-endian unsigned length field,
+// and the length is the size of the data following the length field. The frame starts lengthOffset bytes before
+// the length field of lengthSize bytes, which is one of 1, 2, 3, 4 and 8.
+// It returns ErrFrameTooLarge if the size of the whole frame exceeds maxFrameSize, 0 means no limit.
+// maxFrameSize should not exceed the limit set by WithMaxInputBuffer, otherwise the frame never completes.
+func NewLengthFieldDecoder(lengthOffset, lengthSize, maxFrameSize int) FrameDecoder {
+	return NewLengthFieldDecoderWithConfig(LengthFieldConfig{
+		LengthOffset: lengthOffset,
+		LengthSize:   lengthSize,
+		MaxFrameSize: maxFrameSize,
+	})
+}
+
+// LengthFieldConfig configures the FrameDecoder returned by NewLengthFieldDecoderWithConfig, which is
+// analogous to LengthFieldBasedFrameDecoder of Netty.
+type LengthFieldConfig struct {
+	// LengthOffset is the offset of the length field from the start of the frame.
+	LengthOffset int
+	// LengthSize is the size of the unsigned length field in bytes, which is one of 1, 2, 3, 4 and 8.
+	LengthSize int
+	// LittleEndian is set if the length field is little-endian rather than big-endian.
+	LittleEndian bool
+	// LengthAdjustment is added to the length field to get the size of the data following the length field,
+	// e.g. -LengthSize if the length includes the length field itself.
+	LengthAdjustment int
+	// InitialBytesToStrip is skipped from the start of the frame returned, e.g. LengthOffset+LengthSize
+	// to strip the header. It's skipped by Reader.Skip of the frame, so it's zero-copy as well.
+	InitialBytesToStrip int
+	// MaxFrameSize limits the size of the whole frame before stripped, 0 means no limit.
+	// It should not exceed the limit set by WithMaxInputBuffer, otherwise the frame never completes.
+	MaxFrameSize int
+}
+
+// NewLengthFieldDecoderWithConfig returns a FrameDecoder which splits the frames by the length field
+// described by cfg, and each frame is sliced from the input without copying.
+// It returns ErrFrameTooLarge if the size of the whole frame exceeds cfg.MaxFrameSize.
+func NewLengthFieldDecoderWithConfig(cfg LengthFieldConfig) FrameDecoder {
+	switch cfg.LengthSize {
+	case 1, 2, 3, 4, 8:
+	default:
+		panic(fmt.Sprintf("netpoll: invalid length field size %d", cfg.LengthSize))
+	}
+	if cfg.LengthOffset < 0 || cfg.InitialBytesToStrip < 0 {
+		panic(fmt.Sprintf("netpoll: invalid length field offset %d or bytes to strip %d",
+			cfg.LengthOffset, cfg.InitialBytesToStrip))
+	}
+	return &lengthFieldDecoder{cfg: cfg}
+}
+
+type lengthFieldDecoder struct {
+	cfg LengthFieldConfig
+}
+
+// maxLength keeps the size of the frame computed from the length field from overflowing.
+const maxLength = uint64(math.MaxInt32)
+
+// Decode implements FrameDecoder.
+func (d *lengthFieldDecoder) Decode(reader Reader) (frame Reader, need int, err error) {
+	header := d.cfg.LengthOffset + d.cfg.LengthSize
+	if reader.Len() < header {
+		return nil, header, nil
+	}
+	buf, err := reader.Peek(header)
+	if err != nil {
+		return nil, 0, err
+	}
+	length := d.length(buf[d.cfg.LengthOffset:])
+	if length > maxLength {
+		return nil, 0, Exception(ErrFrameTooLarge, fmt.Sprintf("size=%d, max=%d", length+uint64(header), d.cfg.MaxFrameSize))
+	}
+	size := header + int(length) + d.cfg.LengthAdjustment
+	if max := d.cfg.MaxFrameSize; max > 0 && size > max {
+		return nil, 0, Exception(ErrFrameTooLarge, fmt.Sprintf("size=%d, max=%d", size, max))
+	}
+	if size < header || size < d.cfg.InitialBytesToStrip {
+		return nil, 0, fmt.Errorf("netpoll: invalid length field %d, frame size=%d", length, size)
+	}
+	if reader.Len() < size {
+		return nil, size, nil
+	}
+	if frame, err = reader.Slice(size); err != nil {
+		return nil, 0, err
+	}
+	if d.cfg.InitialBytesToStrip > 0 {
+		err = frame.Skip(d.cfg.InitialBytesToStrip)
+	}
+	return frame, 0, err
+}
+
+// length reads the length field.
+func (d *lengthFieldDecoder) length(field []byte) uint64 {
+	var order binary.ByteOrder = binary.BigEndian
+	if d.cfg.LittleEndian {
+		order = binary.LittleEndian
+	}
+	switch d.cfg.LengthSize {
+	case 1:
+		return uint64(field[0])
+	case 2:
+		return uint64(order.Uint16(field))
+	case 3:
+		if d.cfg.LittleEndian {
+			return uint64(field[0]) | uint64(field[1])<<8 | uint64(field[2])<<16
+		}
+		return uint64(field[2]) | uint64(field[1])<<8 | uint64(field[0])<<16
+	case 4:
+		return uint64(order.Uint32(field))
+	default:
+		return order.Uint64(field)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDecodeFrames(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	frames := make(chan string, 16)
+	closed := make(chan struct{})
+	MustNil(t, rconn.init(&netFD{fd: r}, &options{
+		frameDecoder: NewLengthFieldDecoder(1, 2, 16),
+		onRequest: func(ctx context.Context, connection Connection) error {
+			// the optional interfaces of the connection are kept
+			_, ok := connection.(CloseNotifier)
+			MustTrue(t, ok)
+			frame, err := io.ReadAll(connection)
+			MustNil(t, err)
+			frames <- string(frame)
+			return nil
+		},
+	}))
+	MustNil(t, rconn.AddCloseCallback(func(Connection) error {
+		close(closed)
+		return nil
+	}))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+
+	// a frame sent a byte at a time
+	for _, b := range []byte{'a', 0, 3, 'x', 'y', 'z'} {
+		_, err := wconn.Write([]byte{b})
+		MustNil(t, err)
+		time.Sleep(5 * time.Millisecond)
+	}
+	Equal(t, <-frames, "a\x00\x03xyz")
+	Equal(t, len(frames), 0)
+
+	// several frames and a partial one in a write
+	_, err := wconn.Write([]byte("b\x00\x011c\x00\x00d\x00\x02"))
+	MustNil(t, err)
+	Equal(t, <-frames, "b\x00\x011")
+	Equal(t, <-frames, "c\x00\x00")
+	_, err = wconn.Write([]byte("33"))
+	MustNil(t, err)
+	Equal(t, <-frames, "d\x00\x0233")
+
+	// the connection is closed if the frame is too large
+	_, err = wconn.Write([]byte("e\x00\x10"))
+	MustNil(t, err)
+	<-closed
+	Equal(t, rconn.IsActive(), false)
+	wconn.Close()
+}
+
+func TestLengthFieldDecoder(t *testing.T) {
+	decoder := NewLengthFieldDecoder(0, 4, 0)
+	buf := NewLinkBuffer()
+	buf.WriteString("\x00\x00")
+	buf.Flush()
+	frame, need, err := decoder.Decode(buf)
+	MustTrue(t, frame == nil && need == 4 && err == nil)
+
+	buf.WriteString("\x00\x05abc")
+	buf.Flush()
+	frame, need, err = decoder.Decode(buf)
+	MustTrue(t, frame == nil && need == 9 && err == nil)
+	Equal(t, buf.Len(), 7)
+
+	buf.WriteString("de")
+	buf.Flush()
+	frame, need, err = decoder.Decode(buf)
+	MustNil(t, err)
+	Equal(t, need, 0)
+	Equal(t, frame.Len(), 9)
+	Equal(t, buf.Len(), 0)
+
+	decoder = NewLengthFieldDecoder(0, 8, 1024)
+	buf.WriteString("\xff\xff\xff\xff\xff\xff\xff\xff")
+	buf.Flush()
+	_, _, err = decoder.Decode(buf)
+	MustTrue(t, errors.Is(err, ErrFrameTooLarge))
+}
+
+func TestLengthFieldDecoderWithConfig(t *testing.T) {
+	// a 2 bytes magic, a little-endian length of 3 bytes including the header, and the header is stripped
+	decoder := NewLengthFieldDecoderWithConfig(LengthFieldConfig{
+		LengthOffset:        2,
+		LengthSize:          3,
+		LittleEndian:        true,
+		LengthAdjustment:    -5,
+		InitialBytesToStrip: 5,
+		MaxFrameSize:        16,
+	})
+	buf := NewLinkBuffer()
+	buf.WriteString("mg\x08\x00\x00abcmg\x05\x00\x00mg\x07")
+	buf.Flush()
+	frame, need, err := decoder.Decode(buf)
+	MustNil(t, err)
+	Equal(t, need, 0)
+	s, _ := frame.ReadString(frame.Len())
+	Equal(t, s, "abc")
+	// an empty frame
+	frame, _, err = decoder.Decode(buf)
+	MustNil(t, err)
+	Equal(t, frame.Len(), 0)
+	frame, need, err = decoder.Decode(buf)
+	MustTrue(t, frame == nil && need == 5 && err == nil)
+
+	buf.WriteString("\x00\x00x")
+	buf.Flush()
+	frame, need, err = decoder.Decode(buf)
+	MustTrue(t, frame == nil && need == 7 && err == nil)
+	buf.WriteString("y")
+	buf.Flush()
+	frame, _, err = decoder.Decode(buf)
+	MustNil(t, err)
+	s, _ = frame.ReadString(frame.Len())
+	Equal(t, s, "xy")
+
+	// the adjusted size is smaller than the header
+	buf.WriteString("mg\x04\x00\x00")
+	buf.Flush()
+	_, _, err = decoder.Decode(buf)
+	MustTrue(t, err != nil && !errors.Is(err, ErrFrameTooLarge))
+
+	// the limit applies to the adjusted size
+	buf = NewLinkBuffer()
+	buf.WriteString("mg\x11\x00\x00")
+	buf.Flush()
+	_, _, err = decoder.Decode(buf)
+	MustTrue(t, errors.Is(err, ErrFrameTooLarge))
+
+	decoder = NewLengthFieldDecoderWithConfig(LengthFieldConfig{LengthSize: 4, LittleEndian: true})
+	buf = NewLinkBuffer()
+	buf.WriteString("\x02\x00\x00\x00ok")
+	buf.Flush()
+	frame, _, err = decoder.Decode(buf)
+	MustNil(t, err)
+	Equal(t, frame.Len(), 6)
+	buf.WriteString("\x00\x00\x00\x80")
+	buf.Flush()
+	_, _, err = decoder.Decode(buf)
+	MustTrue(t, errors.Is(err, ErrFrameTooLarge))
+}
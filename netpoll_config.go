@@ -17,6 +17,7 @@ package netpoll
 import (
 	"context"
 	"io"
+	"time"
 )
 
 // global config
@@ -27,12 +28,20 @@ var (
 // Config expose some tuning parameters to control the internal behaviors of netpoll.
 // Every parameter with the default zero value should keep the default behavior of netpoll.
 type Config struct {
-	PollerNum    int                                 // number of pollers
-	BufferSize   int                                 // default size of a new connection's LinkBuffer
-	Runner       func(ctx context.Context, f func()) // runner for event handler, most of the time use a goroutine pool.
-	LoggerOutput io.Writer                           // logger output
-	LoadBalance  LoadBalance                         // load balance for poller picker
-	Feature                                          // define all features that not enable by default
+	PollerNum      int                                 // number of pollers
+	BufferSize     int                                 // default size of a new connection's LinkBuffer
+	Runner         func(ctx context.Context, f func()) // runner for event handler, most of the time use a goroutine pool.
+	LoggerOutput   io.Writer                           // logger output
+	Logger         Logger                              // user-defined logger, overrides LoggerOutput if set
+	LoadBalance    LoadBalance                         // load balance for poller picker
+	LoadBalancer   LoadBalancer                        // user-defined load balancer, overrides LoadBalance if set
+	PollerEngine   PollerEngine                        // underlying implementation of pollers
+	PollerAffinity []int                               // cpus to pin the pollers to in turn, empty non-nil means all the allowed cpus
+	PollerBatch    int                                 // max events fetched by each wait of the pollers, 0 means growing on demand
+	PollerSpin     time.Duration                       // how long the pollers keep polling without blocking since the last events
+	StatsTiming    bool                                // measures the time of Stats and ConnStats.LastActive, which costs time.Now() on the hot paths
+	StatsBuffers   bool                                // counts Stats.BufferInUse, which costs an atomic add on every buffer allocation and free
+	Feature                                            // define all features that not enable by default
 }
 
 // Feature expose some new features maybe promoted as a default behavior but not yet.
@@ -0,0 +1,81 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// Logger receives the internal logs of netpoll, such as the failures of the pollers and the accept loops.
+// The keyvals are the alternating keys and values to describe the log, e.g. "fd", 10, "err", err.
+// It's called in the pollers, so it should not block.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+var logger Logger = newStdLogger(os.Stderr)
+
+// SetLogger replaces the Logger of netpoll, which writes to os.Stderr by the standard log package by default.
+// It should be called in init() function like Configure.
+func SetLogger(l Logger) {
+	logger = l
+}
+
+// stdLogger writes the logs by the standard log package, the Debug logs are dropped.
+type stdLogger struct {
+	*log.Logger
+}
+
+func newStdLogger(w io.Writer) Logger {
+	return stdLogger{log.New(w, "", log.LstdFlags)}
+}
+
+func (l stdLogger) Debug(msg string, keyvals ...interface{}) {}
+
+func (l stdLogger) Info(msg string, keyvals ...interface{}) {
+	l.output("INFO", msg, keyvals)
+}
+
+func (l stdLogger) Warn(msg string, keyvals ...interface{}) {
+	l.output("WARN", msg, keyvals)
+}
+
+func (l stdLogger) Error(msg string, keyvals ...interface{}) {
+	l.output("ERROR", msg, keyvals)
+}
+
+// output formats the log as "NETPOLL: [LEVEL] msg key1=value1 key2=value2".
+func (l stdLogger) output(level, msg string, keyvals []interface{}) {
+	var sb strings.Builder
+	sb.WriteString("NETPOLL: [")
+	sb.WriteString(level)
+	sb.WriteString("] ")
+	sb.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 < len(keyvals) {
+			fmt.Fprintf(&sb, " %v=%v", keyvals[i], keyvals[i+1])
+		} else {
+			fmt.Fprintf(&sb, " %v", keyvals[i])
+		}
+	}
+	l.Output(3, sb.String())
+}
@@ -0,0 +1,65 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testLogger struct {
+	mu   sync.Mutex
+	logs []string
+}
+
+func (l *testLogger) log(level, msg string, keyvals []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logs = append(l.logs, level+" "+msg)
+}
+
+func (l *testLogger) Debug(msg string, keyvals ...interface{}) { l.log("DEBUG", msg, keyvals) }
+func (l *testLogger) Info(msg string, keyvals ...interface{})  { l.log("INFO", msg, keyvals) }
+func (l *testLogger) Warn(msg string, keyvals ...interface{})  { l.log("WARN", msg, keyvals) }
+func (l *testLogger) Error(msg string, keyvals ...interface{}) { l.log("ERROR", msg, keyvals) }
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := newStdLogger(&buf)
+	l.Debug("dropped")
+	l.Warn("accept conn failed", "fd", 10, "err", errors.New("too many open files"), "odd")
+	line := strings.TrimSpace(buf.String())
+	MustTrue(t, strings.HasSuffix(line, "NETPOLL: [WARN] accept conn failed fd=10 err=too many open files odd"))
+	MustTrue(t, !strings.Contains(line, "dropped"))
+}
+
+func TestConfigureLogger(t *testing.T) {
+	old := logger
+	defer func() { logger = old }()
+
+	l := &testLogger{}
+	MustNil(t, Configure(Config{Logger: l}))
+	logger.Error("poller close failed", "err", errors.New("closed"))
+	Equal(t, len(l.logs), 1)
+	Equal(t, l.logs[0], "ERROR poller close failed")
+
+	SetLogger(old)
+	Equal(t, logger, old)
+}
@@ -0,0 +1,64 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/cloudwego/netpoll/internal/runner"
+)
+
+// memoryBudget limits the buffers in use of the whole process, see WithMemoryLimit.
+// The buffers are counted by Stats.BufferInUse, which includes the buffers of all the LinkBuffers.
+type memoryBudget struct {
+	limit      int64
+	onPressure OnMemoryPressure
+	pressure   int32 // 1 if the limit is exceeded and OnMemoryPressure has been called
+}
+
+func newMemoryBudget(limit int64, onPressure OnMemoryPressure) *memoryBudget {
+	// the budget depends on the counting of the buffers
+	atomic.StoreInt32(&statsBuffers, 1)
+	return &memoryBudget{limit: limit, onPressure: onPressure}
+}
+
+// exceeded returns true if the buffers in use exceed the limit.
+// OnMemoryPressure is called asynchronously once each time the limit starts being exceeded.
+func (b *memoryBudget) exceeded() bool {
+	inUse := atomic.LoadInt64(&bufferInUse)
+	if inUse <= b.limit {
+		if atomic.LoadInt32(&b.pressure) == 1 {
+			atomic.StoreInt32(&b.pressure, 0)
+		}
+		return false
+	}
+	if atomic.CompareAndSwapInt32(&b.pressure, 0, 1) && b.onPressure != nil {
+		runner.RunTask(context.Background(), func() {
+			b.onPressure(inUse, b.limit)
+		})
+	}
+	return true
+}
+
+// share returns the fair share of the limit for each active connection.
+// The connections buffering more than it are the largest consumers, which stop reading first when the limit is exceeded.
+func (b *memoryBudget) share() int64 {
+	conns := activeConns()
+	if conns < 1 {
+		conns = 1
+	}
+	return b.limit / conns
+}
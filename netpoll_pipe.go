@@ -0,0 +1,506 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pipe creates a pair of in-memory Connections connected to each other, the data flushed to one is read from the other.
+// No socket or poller is involved, so the OnRequest handlers and the codecs can be tested deterministically.
+// Once OnRequest is set by SetOnRequest, it's called by a dedicated goroutine for the data flushed by the peer,
+// and the connection is closed after the peer is closed, the same as the connections created by EventLoop.
+// Flush never blocks since the data is buffered without limit, and the Connection methods tuning the sockets take no effect.
+func Pipe() (Connection, Connection) {
+	b1, b2 := newPipeBuffer(), newPipeBuffer()
+	return newPipeConnection(b1, b2), newPipeConnection(b2, b1)
+}
+
+// pipeConnection implements Connection over two pipeBuffers.
+type pipeConnection struct {
+	in     *pipeBuffer // written by the peer
+	out    *pipeBuffer // read by the peer
+	ctx    context.Context
+	reader *zcReader
+	writer *zcWriter
+
+	readTimeout  int64           // time.Duration
+	readDeadline int64           // UnixNano(). it overwrites readTimeout. 0 if not set.
+	readCtx      context.Context // see SetReadContext, nil if not set
+	closed       int32
+	readClosed   int32 // 1 if CloseRead is called
+	serving      int32 // 1 if the serving goroutine is running
+	watermark    int64 // see SetReadWatermark
+	waiting      bool  // waiting for the next request, readTimeout will not take effect
+	stats        connStats
+	userData     atomic.Pointer[interface{}] // see SetUserData
+	heartbeat    heartbeat
+	safe         safeWriter // see SafeWrite
+	closeState   closeState // see Done and CloseWithError
+
+	mu             sync.Mutex // protects onRequest
+	onRequest      OnRequest
+	closeCallbacks closeCallbacks
+}
+
+var (
+	_ Connection = &pipeConnection{}
+
+	_ BufferTuner                = &pipeConnection{}
+	_ HalfCloser                 = &pipeConnection{}
+	_ AsyncFlusher               = &pipeConnection{}
+	_ StatsProvider              = &pipeConnection{}
+	_ Heartbeater                = &pipeConnection{}
+	_ ConcurrentWriter           = &pipeConnection{}
+	_ ReadContextSetter          = &pipeConnection{}
+	_ CloseNotifier              = &pipeConnection{}
+	_ PriorityCloseCallbackAdder = &pipeConnection{}
+	_ UserDataHolder             = &pipeConnection{}
+)
+
+func newPipeConnection(in, out *pipeBuffer) *pipeConnection {
+	c := &pipeConnection{in: in, out: out, ctx: context.Background()}
+	c.reader = newZCReader(pipeReader{c})
+	c.writer = newZCWriter(pipeWriter{c})
+	c.stats.init(nil)
+	return c
+}
+
+// Reader implements Connection.
+func (c *pipeConnection) Reader() Reader {
+	return c.reader
+}
+
+// Writer implements Connection.
+func (c *pipeConnection) Writer() Writer {
+	return c.writer
+}
+
+// IsActive implements Connection, it returns false once either side of the Pipe is closed.
+func (c *pipeConnection) IsActive() bool {
+	return atomic.LoadInt32(&c.closed) == 0 && !c.in.isClosed()
+}
+
+// SetReadTimeout implements Connection.
+func (c *pipeConnection) SetReadTimeout(timeout time.Duration) error {
+	if timeout >= 0 {
+		atomic.StoreInt64(&c.readTimeout, int64(timeout))
+	}
+	return nil
+}
+
+// SetWriteTimeout implements Connection, but writing never blocks.
+func (c *pipeConnection) SetWriteTimeout(timeout time.Duration) error {
+	return nil
+}
+
+// SetIdleTimeout implements Connection, but it takes no effect.
+func (c *pipeConnection) SetIdleTimeout(timeout time.Duration) error {
+	return nil
+}
+
+// SetDeadline implements net.Conn.
+func (c *pipeConnection) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *pipeConnection) SetReadDeadline(t time.Time) error {
+	var deadline int64
+	if !t.IsZero() {
+		deadline = t.UnixNano()
+	}
+	atomic.StoreInt64(&c.readDeadline, deadline)
+	return nil
+}
+
+// SetReadContext implements ReadContextSetter.
+func (c *pipeConnection) SetReadContext(ctx context.Context) error {
+	c.readCtx = ctx
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn, but writing never blocks.
+func (c *pipeConnection) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// SetOnRequest implements Connection.
+// Once OnRequest is set, the data will be read by a dedicated goroutine.
+func (c *pipeConnection) SetOnRequest(onRequest OnRequest) error {
+	if onRequest == nil {
+		return nil
+	}
+	c.mu.Lock()
+	c.onRequest = onRequest
+	c.mu.Unlock()
+	c.serve()
+	return nil
+}
+
+// AddCloseCallback implements Connection.
+func (c *pipeConnection) AddCloseCallback(callback CloseCallback) error {
+	_, err := c.AddCloseCallbackWithPriority(callback, 0)
+	return err
+}
+
+// AddCloseCallbackWithPriority implements PriorityCloseCallbackAdder.
+func (c *pipeConnection) AddCloseCallbackWithPriority(callback CloseCallback, priority int) (*CloseCallbackHandle, error) {
+	if callback == nil {
+		return nil, nil
+	}
+	return c.closeCallbacks.add(callback, priority)
+}
+
+// SetMallocSize implements BufferTuner.
+func (c *pipeConnection) SetMallocSize(size int) error {
+	if size < 0 {
+		size = 0
+	}
+	c.reader.buf.setBlockSize(size)
+	c.writer.buf.setBlockSize(size)
+	return nil
+}
+
+// SetMaxInputBuffer implements BufferTuner, but the data flushed by the peer is always buffered.
+func (c *pipeConnection) SetMaxInputBuffer(size int) error {
+	return nil
+}
+
+// SetReadWatermark implements BufferTuner.
+func (c *pipeConnection) SetReadWatermark(n int) error {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt64(&c.watermark, int64(n))
+	return nil
+}
+
+// SetMaxOutputBuffer implements BufferTuner.
+func (c *pipeConnection) SetMaxOutputBuffer(size int) error {
+	c.writer.setMaxSize(size)
+	return nil
+}
+
+// SetHeartbeat implements Heartbeater.
+func (c *pipeConnection) SetHeartbeat(interval time.Duration, fn func(connection Connection)) error {
+	c.heartbeat.set(&defaultTimers, c, interval, fn)
+	return nil
+}
+
+// SafeWrite implements ConcurrentWriter.
+func (c *pipeConnection) SafeWrite(p []byte) error {
+	return c.safe.write(c.writer, p)
+}
+
+// SafeFlush implements ConcurrentWriter.
+func (c *pipeConnection) SafeFlush() error {
+	return c.safe.flush(c.writer)
+}
+
+// LocalAddr implements net.Conn.
+func (c *pipeConnection) LocalAddr() net.Addr {
+	return pipeAddr{}
+}
+
+// RemoteAddr implements net.Conn.
+func (c *pipeConnection) RemoteAddr() net.Addr {
+	return pipeAddr{}
+}
+
+// Read behavior is the same as net.Conn, buffered data will be returned first.
+func (c *pipeConnection) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if c.reader.Len() > 0 {
+		return c.reader.buf.readCopy(p), nil
+	}
+	n, err = pipeReader{c}.Read(p)
+	if err == io.EOF {
+		err = Exception(ErrEOF, "")
+	}
+	return n, err
+}
+
+// Write will send p directly.
+func (c *pipeConnection) Write(p []byte) (n int, err error) {
+	return pipeWriter{c}.Write(p)
+}
+
+// FlushAsync implements AsyncFlusher, the data is flushed synchronously since Flush never blocks.
+func (c *pipeConnection) FlushAsync(callback func(err error)) error {
+	if err := c.writer.Flush(); err != nil {
+		return err
+	}
+	callback(nil)
+	return nil
+}
+
+// Stats implements StatsProvider.
+func (c *pipeConnection) Stats() ConnStats {
+	return c.stats.snapshot()
+}
+
+// SetUserData implements UserDataHolder.
+func (c *pipeConnection) SetUserData(data interface{}) error {
+	c.userData.Store(&data)
+	return nil
+}
+
+// UserData implements UserDataHolder.
+func (c *pipeConnection) UserData() interface{} {
+	if data := c.userData.Load(); data != nil {
+		return *data
+	}
+	return nil
+}
+
+// CloseWrite implements HalfCloser, the peer reads EOF after the flushed data.
+func (c *pipeConnection) CloseWrite() error {
+	c.out.close()
+	return nil
+}
+
+// CloseRead implements HalfCloser, the buffered data can still be read before EOF.
+func (c *pipeConnection) CloseRead() error {
+	atomic.StoreInt32(&c.readClosed, 1)
+	return nil
+}
+
+// Close implements Connection.
+func (c *pipeConnection) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return nil
+	}
+	c.in.close()
+	c.out.close()
+	c.heartbeat.stop()
+	// the callbacks are called after OnRequest returns if it's running
+	if handles, ok := c.closeCallbacks.close(); ok {
+		c.closeCallback(handles)
+	}
+	return nil
+}
+
+// closeCallback calls the CloseCallbacks, and then signals Done.
+func (c *pipeConnection) closeCallback(handles []*CloseCallbackHandle) {
+	runCloseCallbacks(c, handles)
+	c.closeState.finish()
+}
+
+// CloseWithError implements CloseNotifier.
+func (c *pipeConnection) CloseWithError(err error) error {
+	c.closeState.setReason(err)
+	return c.Close()
+}
+
+// Done implements CloseNotifier.
+func (c *pipeConnection) Done() <-chan struct{} {
+	return c.closeState.doneChan()
+}
+
+// Err implements CloseNotifier.
+func (c *pipeConnection) Err() error {
+	switch {
+	case c.IsActive():
+		return nil
+	case atomic.LoadInt32(&c.closed) == 0:
+		return Exception(ErrEOF, "closed by peer")
+	}
+	if err := c.closeState.getReason(); err != nil {
+		return err
+	}
+	return Exception(ErrConnClosed, "closed by user")
+}
+
+// serve starts the goroutine to call OnRequest until the connection is closed.
+func (c *pipeConnection) serve() {
+	if !atomic.CompareAndSwapInt32(&c.serving, 0, 1) {
+		return
+	}
+	go func() {
+		var err error
+		for atomic.LoadInt32(&c.closed) == 0 {
+			wm := int(atomic.LoadInt64(&c.watermark))
+			if wm < 1 {
+				wm = 1
+			}
+			if c.reader.Len() < wm {
+				if err != nil {
+					break
+				}
+				c.waiting = true
+				err = c.reader.fill(wm)
+				c.waiting = false
+				continue
+			}
+			c.mu.Lock()
+			onRequest := c.onRequest
+			c.mu.Unlock()
+			// onRequest must either eventually read all the input data or actively Close the connection.
+			if !c.closeCallbacks.enter() {
+				break
+			}
+			onRequest(c.ctx, c)
+			// the CloseCallbacks are deferred if onRequest closes the connection
+			if handles, ok := c.closeCallbacks.leave(); ok {
+				c.closeCallback(handles)
+			}
+		}
+		// closed by peer
+		if atomic.LoadInt32(&c.closed) == 0 {
+			c.CloseWithError(Exception(ErrEOF, "closed by peer"))
+		}
+	}()
+}
+
+// pipeReader applies the read timeout for each Read.
+type pipeReader struct {
+	c *pipeConnection
+}
+
+func (r pipeReader) Read(p []byte) (n int, err error) {
+	c := r.c
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return 0, c.closeState.closedError("when read")
+	}
+	if atomic.LoadInt32(&c.readClosed) == 1 {
+		return 0, io.EOF
+	}
+	var deadline time.Time
+	if !c.waiting {
+		if d := atomic.LoadInt64(&c.readDeadline); d > 0 {
+			deadline = time.Unix(0, d)
+		} else if timeout := time.Duration(atomic.LoadInt64(&c.readTimeout)); timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+	}
+	n, err = c.in.read(c.readCtx, p, deadline)
+	if n > 0 {
+		c.stats.read(n)
+	}
+	return n, err
+}
+
+// pipeWriter counts the data written to the peer.
+type pipeWriter struct {
+	c *pipeConnection
+}
+
+func (w pipeWriter) Write(p []byte) (n int, err error) {
+	n, err = w.c.out.Write(p)
+	if n > 0 {
+		w.c.stats.write(n)
+	}
+	return n, err
+}
+
+// pipeBuffer buffers the data flowing in one direction of the Pipe.
+type pipeBuffer struct {
+	mu     sync.Mutex
+	buf    *LinkBuffer
+	notify chan struct{} // closed once data is written or the buffer is closed
+	closed bool
+}
+
+func newPipeBuffer() *pipeBuffer {
+	return &pipeBuffer{buf: NewLinkBuffer(), notify: make(chan struct{})}
+}
+
+// Write copies p to the buffer, and wakes up the reader.
+func (b *pipeBuffer) Write(p []byte) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, Exception(ErrConnClosed, "when write")
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	buf, _ := b.buf.Malloc(len(p))
+	n = copy(buf, p)
+	b.buf.Flush()
+	b.wakeup()
+	return n, nil
+}
+
+// read waits until there is data or the buffer is closed, io.EOF is returned after all the data is read.
+// It also returns ctx.Err() once ctx is done if ctx is not nil.
+func (b *pipeBuffer) read(ctx context.Context, p []byte, deadline time.Time) (n int, err error) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	for {
+		b.mu.Lock()
+		if b.buf.Len() > 0 {
+			n = b.buf.readCopy(p)
+			b.buf.Release()
+			b.mu.Unlock()
+			return n, nil
+		}
+		closed, notify := b.closed, b.notify
+		b.mu.Unlock()
+		if closed {
+			return 0, io.EOF
+		}
+		select {
+		case <-notify:
+		case <-timeout:
+			return 0, Exception(ErrReadTimeout, "pipe")
+		case <-done:
+			return 0, ctx.Err()
+		}
+	}
+}
+
+func (b *pipeBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		b.wakeup()
+	}
+}
+
+func (b *pipeBuffer) isClosed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+// wakeup notifies the waiting readers, b.mu must be held.
+func (b *pipeBuffer) wakeup() {
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// pipeAddr is the address of the Connections created by Pipe.
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
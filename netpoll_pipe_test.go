@@ -0,0 +1,145 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPipe(t *testing.T) {
+	client, server := Pipe()
+	closed := make(chan struct{})
+	MustNil(t, server.AddCloseCallback(func(Connection) error {
+		close(closed)
+		return nil
+	}))
+	// echo the frames of a 1-byte length field
+	MustNil(t, server.SetOnRequest(DecodeFrames(NewLengthFieldDecoder(0, 1, 0), func(ctx context.Context, conn Connection) error {
+		frame := conn.Reader()
+		buf, err := frame.Next(frame.Len())
+		if err != nil {
+			return err
+		}
+		_, err = conn.Writer().WriteBinary(buf)
+		if err != nil {
+			return err
+		}
+		return conn.Writer().Flush()
+	})))
+
+	// the frame is echoed only after it's complete
+	_, err := client.Writer().WriteString("\x04pi")
+	MustNil(t, err)
+	MustNil(t, client.Writer().Flush())
+	MustNil(t, client.SetReadTimeout(20*time.Millisecond))
+	_, err = client.Reader().Next(1)
+	MustTrue(t, errors.Is(err, ErrReadTimeout))
+	_, err = client.Write([]byte("ng\x04pong"))
+	MustNil(t, err)
+	MustNil(t, client.SetReadTimeout(time.Second))
+	buf, err := client.Reader().Next(10)
+	MustNil(t, err)
+	Equal(t, string(buf), "\x04ping\x04pong")
+	Equal(t, client.LocalAddr().Network(), "pipe")
+
+	// the server is closed once the client is closed
+	MustNil(t, client.Close())
+	Equal(t, client.IsActive(), false)
+	_, err = client.Writer().WriteString("x")
+	MustNil(t, err)
+	MustTrue(t, errors.Is(client.Writer().Flush(), ErrConnClosed))
+	<-closed
+	Equal(t, server.IsActive(), false)
+}
+
+func TestPipeCloseWrite(t *testing.T) {
+	client, server := Pipe()
+	_, err := client.Writer().WriteString("hello")
+	MustNil(t, err)
+	MustNil(t, client.Writer().Flush())
+	MustNil(t, client.(HalfCloser).CloseWrite())
+
+	// the data flushed before CloseWrite is kept
+	buf := make([]byte, 16)
+	n, err := server.Read(buf)
+	MustNil(t, err)
+	Equal(t, string(buf[:n]), "hello")
+	_, err = server.Read(buf)
+	MustTrue(t, errors.Is(err, ErrEOF))
+
+	// the other direction still works
+	_, err = server.Write([]byte("world"))
+	MustNil(t, err)
+	s, err := client.Reader().ReadString(5)
+	MustNil(t, err)
+	Equal(t, s, "world")
+	client.Close()
+	server.Close()
+}
+
+func TestPipeSendFile(t *testing.T) {
+	client, server := Pipe()
+	defer client.Close()
+	defer server.Close()
+	_, ok := client.(SocketConn)
+	MustTrue(t, !ok)
+
+	f, err := os.CreateTemp(t.TempDir(), "sendfile")
+	MustNil(t, err)
+	defer f.Close()
+	_, err = f.WriteString("hello world")
+	MustNil(t, err)
+	// the file is copied by the output buffer
+	n, err := SendFile(client, f, 6, 0)
+	MustNil(t, err)
+	Equal(t, n, int64(5))
+	s, err := server.Reader().ReadString(5)
+	MustNil(t, err)
+	Equal(t, s, "world")
+
+	MustNil(t, client.Close())
+	_, err = SendFile(client, f, 0, 0)
+	MustTrue(t, errors.Is(err, ErrConnClosed))
+}
+
+func TestPipeCloseCallback(t *testing.T) {
+	client, server := Pipe()
+	var requesting, called int32
+	MustNil(t, server.AddCloseCallback(func(Connection) error {
+		// called after OnRequest returns even if it closes the connection
+		Equal(t, atomic.LoadInt32(&requesting), int32(0))
+		atomic.AddInt32(&called, 1)
+		return nil
+	}))
+	MustNil(t, server.SetOnRequest(func(ctx context.Context, conn Connection) error {
+		atomic.StoreInt32(&requesting, 1)
+		defer atomic.StoreInt32(&requesting, 0)
+		conn.Close()
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}))
+	_, err := client.Write([]byte("x"))
+	MustNil(t, err)
+	<-server.(CloseNotifier).Done()
+	Equal(t, atomic.LoadInt32(&called), int32(1))
+	client.Close()
+}
@@ -0,0 +1,168 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// The PROXY protocol header is defined by https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt.
+const (
+	proxyV1MaxSize  = 107 // "PROXY TCP6 " + 2*39 + 2*5 + 3 + "\r\n"
+	proxyV2HeadSize = 16  // signature + ver_cmd + fam + len
+	proxyV2MaxSize  = proxyV2HeadSize + 65535
+)
+
+var (
+	proxyV1Signature = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errProxyNoHeader   = errors.New("PROXY header not found")
+	errProxyIncomplete = errors.New("PROXY header incomplete")
+)
+
+// parseProxyHeader parses the PROXY protocol header at the beginning of buf, and returns the size of the header
+// and the source address carried by it. The src is nil if the header doesn't carry any address, such as
+// "PROXY UNKNOWN" or the LOCAL command, in which case the address of the peer should be used.
+// It returns errProxyNoHeader if buf doesn't start with a header, and errProxyIncomplete if more data is needed.
+func parseProxyHeader(buf []byte) (n int, src net.Addr, err error) {
+	switch {
+	case hasSignature(buf, proxyV2Signature):
+		return parseProxyV2(buf)
+	case hasSignature(buf, proxyV1Signature):
+		return parseProxyV1(buf)
+	}
+	return 0, nil, errProxyNoHeader
+}
+
+// hasSignature reports whether buf starts with the signature, or is a prefix of it.
+func hasSignature(buf, sig []byte) bool {
+	if len(buf) < len(sig) {
+		return bytes.HasPrefix(sig, buf)
+	}
+	return bytes.HasPrefix(buf, sig)
+}
+
+func parseProxyV1(buf []byte) (n int, src net.Addr, err error) {
+	end := bytes.Index(buf, []byte("\r\n"))
+	if end < 0 {
+		if len(buf) >= proxyV1MaxSize {
+			return 0, nil, proxyMalformed("v1 header too long")
+		}
+		return 0, nil, errProxyIncomplete
+	}
+	n = end + 2
+	if n > proxyV1MaxSize {
+		return 0, nil, proxyMalformed("v1 header too long")
+	}
+	fields := strings.Split(string(buf[len(proxyV1Signature):end]), " ")
+	switch fields[0] {
+	case "UNKNOWN":
+		return n, nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return 0, nil, proxyMalformed("v1 unknown protocol " + fields[0])
+	}
+	if len(fields) != 5 {
+		return 0, nil, proxyMalformed("v1 invalid fields")
+	}
+	ip := net.ParseIP(fields[1])
+	if ip == nil || net.ParseIP(fields[2]) == nil || (ip.To4() != nil) != (fields[0] == "TCP4") {
+		return 0, nil, proxyMalformed("v1 invalid address")
+	}
+	port, err := strconv.ParseUint(fields[3], 10, 16)
+	if err != nil {
+		return 0, nil, proxyMalformed("v1 invalid port")
+	}
+	if _, err = strconv.ParseUint(fields[4], 10, 16); err != nil {
+		return 0, nil, proxyMalformed("v1 invalid port")
+	}
+	return n, &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func parseProxyV2(buf []byte) (n int, src net.Addr, err error) {
+	if len(buf) < proxyV2HeadSize {
+		return 0, nil, errProxyIncomplete
+	}
+	verCmd, fam := buf[12], buf[13]
+	n = proxyV2HeadSize + int(binary.BigEndian.Uint16(buf[14:16]))
+	if verCmd>>4 != 2 {
+		return 0, nil, proxyMalformed("v2 invalid version")
+	}
+	if len(buf) < n {
+		return 0, nil, errProxyIncomplete
+	}
+	switch verCmd & 0xf {
+	case 0x0: // LOCAL
+		return n, nil, nil
+	case 0x1: // PROXY
+	default:
+		return 0, nil, proxyMalformed("v2 invalid command")
+	}
+
+	addr := buf[proxyV2HeadSize:n]
+	switch fam >> 4 {
+	case 0x0: // AF_UNSPEC
+		return n, nil, nil
+	case 0x1: // AF_INET
+		if len(addr) < 12 {
+			return 0, nil, proxyMalformed("v2 invalid address length")
+		}
+		src = proxyInetAddr(fam, net.IP(append([]byte{}, addr[:4]...)), binary.BigEndian.Uint16(addr[8:10]))
+	case 0x2: // AF_INET6
+		if len(addr) < 36 {
+			return 0, nil, proxyMalformed("v2 invalid address length")
+		}
+		src = proxyInetAddr(fam, net.IP(append([]byte{}, addr[:16]...)), binary.BigEndian.Uint16(addr[32:34]))
+	case 0x3: // AF_UNIX
+		if len(addr) < 216 {
+			return 0, nil, proxyMalformed("v2 invalid address length")
+		}
+		name := addr[:108]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		network := "unix"
+		if fam&0xf == 0x2 {
+			network = "unixgram"
+		}
+		src = &net.UnixAddr{Name: string(name), Net: network}
+	default:
+		return 0, nil, proxyMalformed("v2 invalid address family")
+	}
+	if src == nil {
+		return 0, nil, proxyMalformed("v2 invalid transport protocol")
+	}
+	return n, src, nil
+}
+
+func proxyInetAddr(fam byte, ip net.IP, port uint16) net.Addr {
+	switch fam & 0xf {
+	case 0x1: // STREAM
+		return &net.TCPAddr{IP: ip, Port: int(port)}
+	case 0x2: // DGRAM
+		return &net.UDPAddr{IP: ip, Port: int(port)}
+	}
+	return nil
+}
+
+func proxyMalformed(reason string) error {
+	return errors.New("malformed PROXY header: " + reason)
+}
@@ -0,0 +1,186 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func proxyV2Header(cmd, fam byte, addr []byte) []byte {
+	buf := append([]byte{}, proxyV2Signature...)
+	buf = append(buf, 0x20|cmd, fam, byte(len(addr)>>8), byte(len(addr)))
+	return append(buf, addr...)
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	v2TCP4 := proxyV2Header(0x1, 0x11, []byte{1, 2, 3, 4, 5, 6, 7, 8, 0x04, 0x57, 0x08, 0xae})
+	v2TCP6 := proxyV2Header(0x1, 0x21, append(make([]byte, 32), 0x04, 0x57, 0x08, 0xae))
+	v2TCP6[proxyV2HeadSize+15] = 1
+	v2Local := proxyV2Header(0x0, 0x00, nil)
+
+	tests := []struct {
+		data string
+		size int
+		src  string
+		err  error
+	}{
+		{data: "PROXY TCP4 1.2.3.4 5.6.7.8 1111 2222\r\nping", size: 38, src: "1.2.3.4:1111"},
+		{data: "PROXY TCP6 ::1 ::2 1111 2222\r\n", size: 30, src: "[::1]:1111"},
+		{data: "PROXY UNKNOWN\r\n", size: 15},
+		{data: string(v2TCP4) + "ping", size: len(v2TCP4), src: "1.2.3.4:1111"},
+		{data: string(v2TCP6), size: len(v2TCP6), src: "[::1]:1111"},
+		{data: string(v2Local), size: len(v2Local)},
+		{data: "PRO", err: errProxyIncomplete},
+		{data: "PROXY TCP4 1.2.3.4", err: errProxyIncomplete},
+		{data: string(v2TCP4[:20]), err: errProxyIncomplete},
+		{data: "GET / HTTP/1.1\r\n", err: errProxyNoHeader},
+	}
+	for _, tt := range tests {
+		size, src, err := parseProxyHeader([]byte(tt.data))
+		Equal(t, err, tt.err)
+		Equal(t, size, tt.size)
+		if tt.src == "" {
+			MustTrue(t, src == nil)
+		} else {
+			Equal(t, src.String(), tt.src)
+		}
+	}
+
+	malformed := []string{
+		"PROXY TCP4 1.2.3.4 5.6.7.8 1111\r\n",
+		"PROXY TCP4 ::1 ::2 1111 2222\r\n",
+		"PROXY TCP4 1.2.3.4 5.6.7.8 1111 99999\r\n",
+		"PROXY UDP4 1.2.3.4 5.6.7.8 1111 2222\r\n",
+		"PROXY TCP4 " + string(make([]byte, proxyV1MaxSize)),
+		string(proxyV2Header(0x1, 0x11, []byte{1, 2, 3, 4})),
+		string(proxyV2Header(0x2, 0x11, nil)),
+	}
+	for _, data := range malformed {
+		_, _, err := parseProxyHeader([]byte(data))
+		Assert(t, err != nil && err != errProxyIncomplete && err != errProxyNoHeader, data, err)
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	address := getTestAddress()
+	loop := newTestEventLoop("tcp", address,
+		func(ctx context.Context, connection Connection) error {
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			_, err = connection.Write(append([]byte(connection.RemoteAddr().String()+" "), buf...))
+			return err
+		},
+		WithProxyProtocol(time.Second, true),
+	)
+	defer loop.Shutdown(context.Background())
+
+	request := func(parts ...string) string {
+		conn, err := net.Dial("tcp", address)
+		MustNil(t, err)
+		defer conn.Close()
+		for _, part := range parts {
+			_, err = conn.Write([]byte(part))
+			MustNil(t, err)
+			time.Sleep(20 * time.Millisecond)
+		}
+		buf := make([]byte, 128)
+		n, err := conn.Read(buf)
+		MustNil(t, err)
+		return string(buf[:n])
+	}
+	Equal(t, request("PROXY TCP4 1.2.3.4 5.6.7.8 1111 2222\r\nping"), "1.2.3.4:1111 ping")
+	// the header is split into multiple packets
+	Equal(t, request("PROXY TCP4 1.2", ".3.4 5.6.7.8 1111 2222\r\n", "ping"), "1.2.3.4:1111 ping")
+	v2 := proxyV2Header(0x1, 0x11, []byte{1, 2, 3, 4, 5, 6, 7, 8, 0x04, 0x57, 0x08, 0xae})
+	Equal(t, request(string(v2[:10]), string(v2[10:])+"ping"), "1.2.3.4:1111 ping")
+
+	// strict mode rejects the connections without header
+	conn, err := net.Dial("tcp", address)
+	MustNil(t, err)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n"))
+	MustNil(t, err)
+	_, err = conn.Read(make([]byte, 128))
+	Assert(t, err != nil) // EOF or reset by the unread data
+	conn.Close()
+}
+
+func TestProxyProtocolNotStrict(t *testing.T) {
+	address := getTestAddress()
+	loop := newTestEventLoop("tcp", address,
+		func(ctx context.Context, connection Connection) error {
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			_, err = connection.Write(buf)
+			return err
+		},
+		WithProxyProtocol(50*time.Millisecond, false),
+	)
+	defer loop.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", address)
+	MustNil(t, err)
+	_, err = conn.Write([]byte("ping"))
+	MustNil(t, err)
+	buf := make([]byte, 128)
+	n, err := conn.Read(buf)
+	MustNil(t, err)
+	Equal(t, string(buf[:n]), "ping")
+	conn.Close()
+
+	// timeout
+	conn, err = net.Dial("tcp", address)
+	MustNil(t, err)
+	_, err = conn.Write([]byte("PROXY "))
+	MustNil(t, err)
+	begin := time.Now()
+	_, err = conn.Read(buf)
+	Assert(t, err != nil) // EOF or reset by the unread data
+	Assert(t, time.Since(begin) < time.Second)
+	conn.Close()
+}
+
+func TestProxyProtocolShutdown(t *testing.T) {
+	address := getTestAddress()
+	loop := newTestEventLoop("tcp", address,
+		func(ctx context.Context, connection Connection) error {
+			_, err := connection.Reader().Next(connection.Reader().Len())
+			return err
+		},
+		WithProxyProtocol(0, true),
+	)
+
+	// the handshake without timeout is closed by Shutdown
+	conn, err := net.Dial("tcp", address)
+	MustNil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("PROXY "))
+	MustNil(t, err)
+	time.Sleep(20 * time.Millisecond)
+	MustNil(t, loop.Shutdown(context.Background()))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 128))
+	Assert(t, err != nil)
+	ne, ok := err.(net.Error)
+	Assert(t, !ok || !ne.Timeout(), err)
+}
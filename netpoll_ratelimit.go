@@ -0,0 +1,108 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// acceptLimiter is a token bucket limiting the rate of accepting connections of a listener, see WithAcceptLimit.
+type acceptLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newAcceptLimiter(cfg *acceptLimitConfig) *acceptLimiter {
+	if cfg == nil {
+		return nil
+	}
+	return &acceptLimiter{rate: cfg.rate, burst: float64(cfg.burst), tokens: float64(cfg.burst)}
+}
+
+// reserve takes a token if there is one, otherwise it returns the time to wait for the next token.
+func (l *acceptLimiter) reserve(now time.Time) (wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// cancel returns the token reserved if no connection is accepted.
+func (l *acceptLimiter) cancel() {
+	l.mu.Lock()
+	if l.tokens++; l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.mu.Unlock()
+}
+
+// writeQuantum is the minimum bytes sent at once when pacing, so that the paced connections
+// are not woken up for a few bytes each time.
+const writeQuantum = 4096
+
+// writeLimiter is the token bucket of SetWriteRateLimit and WithWriteRateLimit, the latter is shared
+// by all the connections of the EventLoop. The tokens are taken after the data is sent, so they may go
+// negative if the connections sharing the bucket send together.
+type writeLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newWriteLimiter(bytesPerSec, burst int) *writeLimiter {
+	if burst < 1 {
+		burst = bytesPerSec
+	}
+	return &writeLimiter{rate: float64(bytesPerSec), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// quota returns the bytes allowed to be sent now, or the time to wait for the next quantum if it's 0.
+func (l *writeLimiter) quota(now time.Time) (n int, wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.tokens += now.Sub(l.last).Seconds() * l.rate; l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	quantum := math.Min(writeQuantum, l.burst)
+	if l.tokens >= quantum {
+		return int(l.tokens), 0
+	}
+	return 0, time.Duration((quantum - l.tokens) / l.rate * float64(time.Second))
+}
+
+// take takes n tokens after n bytes are sent.
+func (l *writeLimiter) take(n int) {
+	l.mu.Lock()
+	l.tokens -= float64(n)
+	l.mu.Unlock()
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAcceptLimiter(t *testing.T) {
+	l := newAcceptLimiter(&acceptLimitConfig{rate: 10, burst: 2})
+	now := time.Now()
+	Equal(t, l.reserve(now), time.Duration(0))
+	Equal(t, l.reserve(now), time.Duration(0))
+	Equal(t, l.reserve(now), 100*time.Millisecond)
+
+	// a token is refilled every 100ms, and the returned token can be reserved again
+	now = now.Add(50 * time.Millisecond)
+	Equal(t, l.reserve(now), 50*time.Millisecond)
+	now = now.Add(50 * time.Millisecond)
+	Equal(t, l.reserve(now), time.Duration(0))
+	l.cancel()
+	Equal(t, l.reserve(now), time.Duration(0))
+
+	// no more than burst tokens are refilled
+	now = now.Add(time.Second)
+	Equal(t, l.reserve(now), time.Duration(0))
+	Equal(t, l.reserve(now), time.Duration(0))
+	MustTrue(t, l.reserve(now) > 0)
+}
+
+func TestAcceptLimit(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	var connected int32
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			_, err := connection.Reader().Next(connection.Reader().Len())
+			return err
+		},
+		WithAcceptLimit(20, 2),
+		WithOnConnect(func(ctx context.Context, connection Connection) context.Context {
+			atomic.AddInt32(&connected, 1)
+			return ctx
+		}),
+	)
+
+	// the connections over the burst are accepted later instead of being rejected
+	begin := time.Now()
+	var conns []Connection
+	for i := 0; i < 4; i++ {
+		conn, err := DialConnection(network, address, time.Second)
+		MustNil(t, err)
+		conns = append(conns, conn)
+	}
+	for atomic.LoadInt32(&connected) < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	MustTrue(t, time.Since(begin) >= 80*time.Millisecond)
+	for _, conn := range conns {
+		MustTrue(t, conn.IsActive())
+		MustNil(t, conn.Close())
+	}
+	MustNil(t, loop.Shutdown(context.Background()))
+}
@@ -19,39 +19,48 @@ package netpoll
 import (
 	"context"
 	"errors"
+	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 // newServer wrap listener into server, quit will be invoked when server exit.
-func newServer(ln Listener, opts *options, onQuit func(err error)) *server {
+// connNum is shared by the servers of an EventLoop, so that maxConns limits all of them.
+func newServer(ln Listener, opts *options, connNum *int32, onQuit func(err error)) *server {
 	return &server{
-		ln:     ln,
-		opts:   opts,
-		onQuit: onQuit,
+		ln:      ln,
+		opts:    opts,
+		onQuit:  onQuit,
+		connNum: connNum,
+		limiter: newAcceptLimiter(opts.acceptLimit),
 	}
 }
 
 type server struct {
-	operator    FDOperator
+	operator    *FDOperator
 	ln          Listener
 	opts        *options
 	onQuit      func(err error)
 	connections sync.Map // key=fd, value=connection
+	connNum     *int32   // number of connections of the EventLoop, only counted if maxConns is set
+	limiter     *acceptLimiter
+	mu          sync.Mutex // serializes resuming the paused listener with Close
+	closed      bool
 }
 
 // Run this server.
 func (s *server) Run() (err error) {
-	s.operator = FDOperator{
-		FD:     s.ln.Fd(),
-		OnRead: s.OnRead,
-		OnHup:  s.OnHup,
-	}
-	s.operator.poll = pollmanager.Pick()
+	// the operator is allocated by the poll, so that the poll will not be closed before the listener when shrinking.
+	s.operator = pickPoll(s.opts, s.ln.Fd()).Alloc()
+	s.operator.FD = s.ln.Fd()
+	s.operator.OnRead, s.operator.OnHup = s.OnRead, s.OnHup
+	s.operator.exclusive = s.opts.exclusive
 	err = s.operator.Control(PollReadable)
 	if err != nil {
+		s.reportError(ErrorOpControl, err)
 		s.onQuit(err)
 	}
 	return err
@@ -59,15 +68,28 @@ func (s *server) Run() (err error) {
 
 // Close this server with deadline.
 func (s *server) Close(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
 	s.operator.Control(PollDetach)
+	s.operator.Free()
 	s.ln.Close()
 
+	// call OnShutdown once for each connection before closing
+	notified := make(map[interface{}]bool)
 	for {
 		activeConn := 0
 		s.connections.Range(func(key, value interface{}) bool {
+			if c, ok := value.(*connection); ok && !notified[key] {
+				if !c.onShutdown() {
+					activeConn++
+					return true
+				}
+				notified[key] = true
+			}
 			conn, ok := value.(gracefulExit)
 			if !ok || conn.isIdle() {
-				value.(Connection).Close()
+				value.(io.Closer).Close()
 			} else {
 				activeConn++
 			}
@@ -96,16 +118,31 @@ func (s *server) Close(ctx context.Context) error {
 
 // OnRead implements FDOperator.
 func (s *server) OnRead(p Poll) error {
+	if s.limiter != nil {
+		if wait := s.limiter.reserve(time.Now()); wait > 0 {
+			s.pauseAccept(wait)
+			return nil
+		}
+	}
 	// accept socket
 	conn, err := s.ln.Accept()
 	if err == nil {
 		if conn != nil {
 			s.onAccept(conn.(Conn))
+		} else if s.limiter != nil {
+			s.limiter.cancel()
 		}
 		// EAGAIN | EWOULDBLOCK if conn and err both nil
 		return nil
 	}
-	logger.Printf("NETPOLL: accept conn failed: %v", err)
+	// shut down
+	if strings.Contains(err.Error(), "closed") {
+		s.operator.Control(PollDetach)
+		s.onQuit(err)
+		return err
+	}
+	logger.Error("accept conn failed", "addr", s.ln.Addr(), "err", err)
+	s.reportError(ErrorOpAccept, err)
 
 	// delay accept when too many open files
 	if isOutOfFdErr(err) {
@@ -113,7 +150,8 @@ func (s *server) OnRead(p Poll) error {
 		// and re-register it when accept successfully or there is no available connection
 		cerr := s.operator.Control(PollDetach)
 		if cerr != nil {
-			logger.Printf("NETPOLL: detach listener fd failed: %v", cerr)
+			logger.Error("detach listener fd failed", "addr", s.ln.Addr(), "err", cerr)
+			s.reportError(ErrorOpControl, cerr)
 			return err
 		}
 		go func() {
@@ -131,26 +169,51 @@ func (s *server) OnRead(p Poll) error {
 						return
 					}
 					s.onAccept(conn.(Conn))
-					logger.Println("NETPOLL: re-accept conn success:", conn.RemoteAddr())
+					logger.Info("re-accept conn success", "addr", s.ln.Addr(), "remote", conn.RemoteAddr())
 					retryTimeIndex = 0
 					continue
 				}
+				if strings.Contains(err.Error(), "closed") {
+					// shut down while retrying
+					return
+				}
 				if retryTimeIndex+1 < len(retryTimes) {
 					retryTimeIndex++
 				}
-				logger.Printf("NETPOLL: re-accept conn failed, err=[%s] and next retrytime=%dms", err.Error(), retryTimes[retryTimeIndex])
+				logger.Warn("re-accept conn failed", "addr", s.ln.Addr(), "err", err, "retryMs", retryTimes[retryTimeIndex])
+				s.reportError(ErrorOpAccept, err)
 			}
 		}()
 	}
+	return err
+}
 
-	// shut down
-	if strings.Contains(err.Error(), "closed") {
-		s.operator.Control(PollDetach)
-		s.onQuit(err)
-		return err
+// pauseAccept detaches the listener from the poller since the accept rate exceeds the limit,
+// and re-registers it after wait.
+func (s *server) pauseAccept(wait time.Duration) {
+	if err := s.operator.Control(PollDetach); err != nil {
+		logger.Error("detach listener fd failed", "addr", s.ln.Addr(), "err", err)
+		s.reportError(ErrorOpControl, err)
+		return
 	}
+	time.AfterFunc(wait, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.closed {
+			return
+		}
+		if err := s.operator.Control(PollReadable); err != nil {
+			logger.Error("resume listener fd failed", "addr", s.ln.Addr(), "err", err)
+			s.reportError(ErrorOpControl, err)
+		}
+	})
+}
 
-	return err
+// reportError reports the error of the listener to OnError if it's set.
+func (s *server) reportError(op ErrorOp, err error) {
+	if s.opts.onError != nil {
+		s.opts.onError(ErrorInfo{Op: op, Addr: s.ln.Addr(), FD: s.ln.Fd(), Err: err})
+	}
 }
 
 // OnHup implements FDOperator.
@@ -160,15 +223,54 @@ func (s *server) OnHup(p Poll) error {
 }
 
 func (s *server) onAccept(conn Conn) {
+	if s.opts.onAccept != nil {
+		if err := s.opts.onAccept(conn.Fd(), conn.RemoteAddr()); err != nil {
+			logger.Debug("reject conn", "remote", conn.RemoteAddr(), "err", err)
+			conn.Close()
+			return
+		}
+	}
+	if s.opts.maxConns > 0 {
+		if atomic.AddInt32(s.connNum, 1) > int32(s.opts.maxConns) {
+			atomic.AddInt32(s.connNum, -1)
+			if s.opts.onOverload != nil {
+				s.opts.onOverload(conn)
+			}
+			conn.Close()
+			return
+		}
+	}
+	if s.opts.proxyProtocol != nil {
+		s.readProxyHeader(conn)
+		return
+	}
+	s.serve(conn)
+}
+
+// serve registers the accepted connection, and triggers OnConnect.
+func (s *server) serve(conn Conn) {
+	if s.opts.onPacket != nil && conn.LocalAddr().Network() == "unixpacket" {
+		s.servePacket(conn)
+		return
+	}
 	// store & register connection
 	nconn := new(connection)
 	nconn.init(conn, s.opts)
 	if !nconn.IsActive() {
+		if s.opts.maxConns > 0 {
+			atomic.AddInt32(s.connNum, -1)
+		}
 		return
 	}
+	shard := nconn.stats.shard
+	shard.accept()
 	fd := conn.Fd()
 	nconn.AddCloseCallback(func(connection Connection) error {
+		shard.close()
 		s.connections.Delete(fd)
+		if s.opts.maxConns > 0 {
+			atomic.AddInt32(s.connNum, -1)
+		}
 		return nil
 	})
 	s.connections.Store(fd, nconn)
@@ -177,6 +279,194 @@ func (s *server) onAccept(conn Conn) {
 	nconn.onConnect()
 }
 
+// servePacket serves the accepted SOCK_SEQPACKET connection as a PacketConnection to keep the message boundaries,
+// every message received is delivered to OnPacket.
+func (s *server) servePacket(conn Conn) {
+	fd := conn.Fd()
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		s.reject(conn)
+		return
+	}
+	pconn := &packetConnection{
+		fd:        fd,
+		localAddr: conn.LocalAddr(),
+		executor:  s.opts.executor,
+	}
+	shard := pickStatsShard()
+	pconn.closeCallback = func() {
+		shard.close()
+		s.connections.Delete(fd)
+		if s.opts.maxConns > 0 {
+			atomic.AddInt32(s.connNum, -1)
+		}
+	}
+	shard.accept()
+	s.connections.Store(fd, pconn)
+	if err := pconn.init(s.opts); err != nil {
+		// closed by init
+		return
+	}
+	pconn.SetOnPacket(s.opts.onPacket)
+}
+
+// reject closes the accepted connection before serving it.
+func (s *server) reject(conn Conn) {
+	conn.Close()
+	if s.opts.maxConns > 0 {
+		atomic.AddInt32(s.connNum, -1)
+	}
+}
+
+// readProxyHeader reads the PROXY protocol header of the accepted connection, and then serves it.
+func (s *server) readProxyHeader(conn Conn) {
+	h := &proxyHandshake{
+		s:    s,
+		conn: conn,
+		buf:  make([]byte, 256),
+	}
+	h.operator = FDOperator{
+		FD:     conn.Fd(),
+		OnRead: h.onRead,
+		OnHup:  h.onHup,
+	}
+	h.operator.poll = pickPoll(h.s.opts, h.operator.FD)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// tracked until it's finished, so that it's closed by Shutdown as well
+	s.connections.Store(h.operator.FD, h)
+	if timeout := s.opts.proxyProtocol.timeout; timeout > 0 {
+		h.timer = time.AfterFunc(timeout, h.onTimeout)
+	}
+	if err := h.operator.Control(PollReadable); err != nil {
+		logger.Error("register conn for PROXY header failed", "err", err)
+		h.finish(false)
+	}
+}
+
+// proxyRetryInterval is the interval to wait for the rest of an incomplete PROXY header.
+const proxyRetryInterval = 10 * time.Millisecond
+
+// proxyHandshake reads the PROXY protocol header by the poller before the connection is initialized.
+// The header is peeked and only consumed when it's complete, so that the data following it will not be lost,
+// and the connection can still be served as is if it turns out not to be a header.
+type proxyHandshake struct {
+	mu       sync.Mutex
+	s        *server
+	conn     Conn
+	operator FDOperator
+	buf      []byte
+	timer    *time.Timer
+	done     bool
+}
+
+func (h *proxyHandshake) onRead(p Poll) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.done {
+		return nil
+	}
+	n, _, err := syscall.Recvfrom(h.operator.FD, h.buf, syscall.MSG_PEEK)
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return nil
+	}
+	if err != nil || n == 0 {
+		h.finish(false)
+		return nil
+	}
+
+	size, src, err := parseProxyHeader(h.buf[:n])
+	switch err {
+	case nil:
+		// the header has been peeked, so it can be read at once.
+		if _, err = syscall.Read(h.operator.FD, h.buf[:size]); err != nil {
+			h.finish(false)
+			return nil
+		}
+		if nfd, ok := h.conn.(*netFD); ok && src != nil {
+			nfd.remoteAddr = src
+		}
+		h.finish(true)
+	case errProxyIncomplete:
+		if n == len(h.buf) {
+			// peek again with a larger buffer
+			h.buf = make([]byte, 2*n)
+			return nil
+		}
+		// stop reading until more data arrives, otherwise the poller keeps being triggered.
+		h.operator.Control(PollPauseRead)
+		time.AfterFunc(proxyRetryInterval, h.onRetry)
+	default:
+		if h.s.opts.proxyProtocol.strict {
+			logger.Warn("reject conn", "remote", h.conn.RemoteAddr(), "err", err)
+			h.finish(false)
+			return nil
+		}
+		h.finish(true)
+	}
+	return nil
+}
+
+func (h *proxyHandshake) onHup(p Poll) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.done {
+		h.finish(false)
+	}
+	return nil
+}
+
+func (h *proxyHandshake) onTimeout() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.done {
+		logger.Warn("reject conn: read PROXY header timeout", "remote", h.conn.RemoteAddr())
+		h.finish(false)
+	}
+}
+
+func (h *proxyHandshake) onRetry() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.done {
+		h.operator.Control(PollResumeRead)
+	}
+}
+
+// isIdle implements gracefulExit, the handshake in progress is closed by Shutdown at once,
+// while the finished one is waited until it's replaced by the connection served.
+func (h *proxyHandshake) isIdle() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.done
+}
+
+// Close implements gracefulExit, it rejects the connection if the handshake is in progress.
+func (h *proxyHandshake) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.done {
+		h.finish(false)
+	}
+	return nil
+}
+
+// finish detaches the connection from the handshake, and then serves or rejects it.
+func (h *proxyHandshake) finish(ok bool) {
+	h.done = true
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	h.operator.Control(PollDetach)
+	if ok {
+		h.s.serve(h.conn)
+	} else {
+		h.s.reject(h.conn)
+	}
+	// it has been replaced if the connection is served
+	h.s.connections.CompareAndDelete(h.operator.FD, h)
+}
+
 func isOutOfFdErr(err error) bool {
 	se, ok := err.(syscall.Errno)
 	return ok && (se == syscall.EMFILE || se == syscall.ENFILE)
@@ -0,0 +1,246 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the internal counters of netpoll.
+// All the counters are accumulated since the process started, except ActiveConns and BufferInUse.
+// RequestTime and PollerWaitTime are measured only if Config.StatsTiming is set,
+// and BufferInUse is counted only if Config.StatsBuffers is set or any EventLoop has WithMemoryLimit.
+type Stats struct {
+	AcceptedConns  uint64        // number of connections accepted by all EventLoops
+	ActiveConns    int64         // number of accepted connections which are not closed yet
+	BytesRead      uint64        // number of bytes read from all connections
+	BytesWritten   uint64        // number of bytes written to all connections
+	Requests       uint64        // number of OnRequest calls
+	RequestTime    time.Duration // total time spent in OnRequest
+	PollerWaits    uint64        // number of times the pollers waited for events
+	PollerWaitTime time.Duration // total time the pollers spent in waiting for events
+	BufferInUse    int64         // bytes of the buffers allocated from the buffer pool and not freed yet
+}
+
+// ConnStats is a snapshot of the counters of a connection, see StatsProvider.Stats.
+type ConnStats struct {
+	BytesRead    uint64    // number of bytes read from the connection
+	BytesWritten uint64    // number of bytes written to the connection
+	Reads        uint64    // number of reads which got data
+	Writes       uint64    // number of writes which sent data
+	CreatedAt    time.Time // the time the connection was created
+	LastActive   time.Time // the time of the last read or write, CreatedAt if there is none or it's not tracked, see activeAt
+}
+
+// StatsExporter exports Stats to a monitoring system, e.g. Prometheus.
+type StatsExporter interface {
+	// Export will be called periodically with the latest Stats, it must not block for a long time.
+	Export(stats Stats)
+}
+
+var (
+	statsTiming  int32 // 1 if the time is measured, see Config.StatsTiming
+	statsBuffers int32 // 1 if the buffers are counted, see Config.StatsBuffers
+)
+
+// bufferInUse counts the buffers, which are not sharded since the buffers have nothing to do with the pollers.
+var bufferInUse int64
+
+// statsShardNum must be a power of 2.
+const statsShardNum = 32
+
+// statsShard holds a shard of the global counters, all fields must be accessed atomically.
+// Each poller counts the connections on it by its own shard, so that the pollers don't contend with each other.
+type statsShard struct {
+	acceptedConns  uint64
+	activeConns    int64
+	bytesRead      uint64
+	bytesWritten   uint64
+	requests       uint64
+	requestTime    int64
+	pollerWaits    uint64
+	pollerWaitTime int64
+	_              [64]byte // pads the counters of the adjacent shards onto separate cache lines
+}
+
+var (
+	statsShards   [statsShardNum]statsShard
+	statsShardSeq uint32
+)
+
+// pickStatsShard returns the shards in turn.
+func pickStatsShard() *statsShard {
+	return &statsShards[atomic.AddUint32(&statsShardSeq, 1)&(statsShardNum-1)]
+}
+
+// pollStats returns the shard of the poller, or picks one if the poller has no shard.
+func pollStats(poll Poll) *statsShard {
+	if sp, ok := poll.(interface{ statsShard() *statsShard }); ok {
+		return sp.statsShard()
+	}
+	return pickStatsShard()
+}
+
+// ReadStats returns the current Stats of netpoll.
+func ReadStats() (stats Stats) {
+	for i := range statsShards {
+		s := &statsShards[i]
+		stats.AcceptedConns += atomic.LoadUint64(&s.acceptedConns)
+		stats.ActiveConns += atomic.LoadInt64(&s.activeConns)
+		stats.BytesRead += atomic.LoadUint64(&s.bytesRead)
+		stats.BytesWritten += atomic.LoadUint64(&s.bytesWritten)
+		stats.Requests += atomic.LoadUint64(&s.requests)
+		stats.RequestTime += time.Duration(atomic.LoadInt64(&s.requestTime))
+		stats.PollerWaits += atomic.LoadUint64(&s.pollerWaits)
+		stats.PollerWaitTime += time.Duration(atomic.LoadInt64(&s.pollerWaitTime))
+	}
+	stats.BufferInUse = atomic.LoadInt64(&bufferInUse)
+	return stats
+}
+
+// activeConns sums the active connections of all the shards.
+func activeConns() (n int64) {
+	for i := range statsShards {
+		n += atomic.LoadInt64(&statsShards[i].activeConns)
+	}
+	return n
+}
+
+// ExportStats starts a goroutine to export Stats to the exporter every interval, until ctx is done.
+func ExportStats(ctx context.Context, exporter StatsExporter, interval time.Duration) {
+	if exporter == nil || interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				exporter.Export(ReadStats())
+			}
+		}
+	}()
+}
+
+// statsNow returns the time to begin the timing, or the zero time if Config.StatsTiming is not set.
+func statsNow() time.Time {
+	if atomic.LoadInt32(&statsTiming) == 0 {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (s *statsShard) accept() {
+	atomic.AddUint64(&s.acceptedConns, 1)
+	atomic.AddInt64(&s.activeConns, 1)
+}
+
+func (s *statsShard) close() {
+	atomic.AddInt64(&s.activeConns, -1)
+}
+
+// request counts an OnRequest call which began at begin, see statsNow.
+func (s *statsShard) request(begin time.Time) {
+	atomic.AddUint64(&s.requests, 1)
+	if !begin.IsZero() {
+		atomic.AddInt64(&s.requestTime, int64(time.Since(begin)))
+	}
+}
+
+// pollerWait counts a wait of the poller which began at begin, see statsNow.
+func (s *statsShard) pollerWait(begin time.Time) {
+	atomic.AddUint64(&s.pollerWaits, 1)
+	if !begin.IsZero() {
+		atomic.AddInt64(&s.pollerWaitTime, int64(time.Since(begin)))
+	}
+}
+
+func statsBuffer(n int) {
+	if atomic.LoadInt32(&statsBuffers) == 1 {
+		atomic.AddInt64(&bufferInUse, int64(n))
+	}
+}
+
+// connStats holds the counters of a connection, all fields must be accessed atomically.
+type connStats struct {
+	shard        *statsShard // the global counters of the connection
+	bytesRead    uint64
+	bytesWritten uint64
+	reads        uint64
+	writes       uint64
+	createdAt    int64 // UnixNano()
+	activeAt     int64 // UnixNano(), only tracked if Config.StatsTiming is set or it's watched by watchIdle
+	tracking     int32 // 1 if activeAt is tracked
+}
+
+// init initializes the counters of a connection counted by shard, a shard is picked if it's nil.
+func (s *connStats) init(shard *statsShard) {
+	if shard == nil {
+		shard = pickStatsShard()
+	}
+	s.shard = shard
+	now := time.Now().UnixNano()
+	atomic.StoreInt64(&s.createdAt, now)
+	atomic.StoreInt64(&s.activeAt, now)
+	if atomic.LoadInt32(&statsTiming) == 1 {
+		atomic.StoreInt32(&s.tracking, 1)
+	}
+}
+
+// track starts tracking the time of the last read or write.
+func (s *connStats) track() {
+	atomic.StoreInt32(&s.tracking, 1)
+}
+
+// read counts a read of n bytes, and the global counters as well.
+func (s *connStats) read(n int) {
+	atomic.AddUint64(&s.shard.bytesRead, uint64(n))
+	atomic.AddUint64(&s.bytesRead, uint64(n))
+	atomic.AddUint64(&s.reads, 1)
+	if atomic.LoadInt32(&s.tracking) == 1 {
+		atomic.StoreInt64(&s.activeAt, time.Now().UnixNano())
+	}
+}
+
+// write counts a write of n bytes, and the global counters as well.
+func (s *connStats) write(n int) {
+	atomic.AddUint64(&s.shard.bytesWritten, uint64(n))
+	atomic.AddUint64(&s.bytesWritten, uint64(n))
+	atomic.AddUint64(&s.writes, 1)
+	if atomic.LoadInt32(&s.tracking) == 1 {
+		atomic.StoreInt64(&s.activeAt, time.Now().UnixNano())
+	}
+}
+
+// idleTime returns the time since the last read or write.
+func (s *connStats) idleTime() time.Duration {
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&s.activeAt))
+}
+
+func (s *connStats) snapshot() ConnStats {
+	return ConnStats{
+		BytesRead:    atomic.LoadUint64(&s.bytesRead),
+		BytesWritten: atomic.LoadUint64(&s.bytesWritten),
+		Reads:        atomic.LoadUint64(&s.reads),
+		Writes:       atomic.LoadUint64(&s.writes),
+		CreatedAt:    time.Unix(0, atomic.LoadInt64(&s.createdAt)),
+		LastActive:   time.Unix(0, atomic.LoadInt64(&s.activeAt)),
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// enableStats enables the timing and the counting of buffers until the test ends.
+func enableStats(t *testing.T) {
+	timing, buffers := atomic.LoadInt32(&statsTiming), atomic.LoadInt32(&statsBuffers)
+	atomic.StoreInt32(&statsTiming, 1)
+	atomic.StoreInt32(&statsBuffers, 1)
+	t.Cleanup(func() {
+		atomic.StoreInt32(&statsTiming, timing)
+		atomic.StoreInt32(&statsBuffers, buffers)
+	})
+}
+
+func TestReadStats(t *testing.T) {
+	enableStats(t)
+	network, address := "tcp", getTestAddress()
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			time.Sleep(time.Millisecond)
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			_, err = connection.Writer().WriteBinary(buf)
+			if err != nil {
+				return err
+			}
+			return connection.Writer().Flush()
+		},
+	)
+	before := ReadStats()
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn.Writer().WriteString("hello")
+	MustNil(t, err)
+	MustNil(t, conn.Writer().Flush())
+	buf, err := conn.Reader().Next(5)
+	MustNil(t, err)
+	Equal(t, string(buf), "hello")
+
+	after := ReadStats()
+	Assert(t, after.AcceptedConns >= before.AcceptedConns+1, before, after)
+	Assert(t, after.ActiveConns >= 1, after)
+	// both server and client side are counted
+	Assert(t, after.BytesRead >= before.BytesRead+10, before, after)
+	Assert(t, after.BytesWritten >= before.BytesWritten+10, before, after)
+	Assert(t, after.Requests >= before.Requests+1, before, after)
+	Assert(t, after.RequestTime >= before.RequestTime+time.Millisecond, before, after)
+	Assert(t, after.PollerWaits > before.PollerWaits, before, after)
+	Assert(t, after.BufferInUse > 0, after)
+
+	MustNil(t, conn.Close())
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+}
+
+type testStatsExporter chan Stats
+
+func (e testStatsExporter) Export(stats Stats) {
+	select {
+	case e <- stats:
+	default:
+	}
+}
+
+func TestExportStats(t *testing.T) {
+	exporter := make(testStatsExporter, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	ExportStats(ctx, exporter, 10*time.Millisecond)
+	select {
+	case <-exporter:
+	case <-time.After(time.Second):
+		t.Fatal("stats are not exported")
+	}
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	// drain the stats exported before cancel
+	select {
+	case <-exporter:
+	default:
+	}
+	select {
+	case <-exporter:
+		t.Fatal("stats are exported after ctx done")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	timerTick  = time.Millisecond // the precision of the timer wheel
+	timerSlots = 1024
+)
+
+// Timer is a timer scheduled by TimerScheduler.AfterFunc or TimerScheduler.Tick.
+type Timer struct {
+	wheel      *timerWheel
+	fn         func()
+	period     time.Duration // the interval of Tick, 0 for AfterFunc
+	slot       int
+	rounds     int  // the rounds of the wheel left before firing
+	scheduled  bool // the timer is linked into the slot, false once stopped or fired by AfterFunc
+	prev, next *Timer
+}
+
+// Stop prevents the Timer from firing. It returns false if the timer has already fired or been stopped.
+// For the timers created by Tick, fn will not be called anymore once Stop returns.
+func (t *Timer) Stop() bool {
+	w := t.wheel
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !t.scheduled {
+		return false
+	}
+	w.unlink(t)
+	return true
+}
+
+// reset schedules the timer to fire after d again, no matter whether it has fired or been stopped.
+func (t *Timer) reset(d time.Duration) {
+	w := t.wheel
+	w.mu.Lock()
+	if t.scheduled {
+		w.unlink(t)
+	}
+	w.schedule(t, time.Now().Add(d))
+	w.mu.Unlock()
+}
+
+// ticking reports whether the timer created by Tick is not stopped.
+func (t *Timer) ticking() bool {
+	t.wheel.mu.Lock()
+	defer t.wheel.mu.Unlock()
+	return t.scheduled
+}
+
+// timerPoll is implemented by the polls which run the expired timers of their timer wheels, see pollTimers.
+type timerPoll interface {
+	timers() *timerWheel
+}
+
+// defaultTimers is the timer wheel of the connections not served by a poller.
+var defaultTimers timerWheel
+
+// pollTimers returns the timer wheel of poll, or defaultTimers if poll has no timer wheel.
+func pollTimers(poll Poll) *timerWheel {
+	if p, ok := poll.(timerPoll); ok {
+		return p.timers()
+	}
+	return &defaultTimers
+}
+
+// timerWheel is a hashed timing wheel, whose expired timers are run by the task posted to the poller,
+// so the timers of the connections run on the same goroutine as their events. It's woken by a runtime timer
+// only at the next slot having timers, so that no goroutine is occupied and no tick is wasted while idle.
+// Scheduling and stopping a timer are O(1), so it's much cheaper than the runtime timers to manage a large
+// number of timers, at the cost of the precision of timerTick.
+type timerWheel struct {
+	mu       sync.Mutex
+	slots    [timerSlots]*Timer // the heads of the doubly linked lists
+	current  int                // the slot to be expired at base
+	base     time.Time          // the time to expire the current slot
+	count    int                // number of timers scheduled
+	waker    *time.Timer        // wakes the wheel at wakeAt
+	wakeAt   time.Time          // zero if the waker is not armed
+	expiring bool               // the expiring is posted but not run yet
+	// post runs the expiring on the poller, and the expiring is run by the waker if it's nil or fails,
+	// e.g. the poller has been closed.
+	post func(task func()) error
+}
+
+// afterFunc schedules fn to be called once after d, or every period if period > 0.
+func (w *timerWheel) afterFunc(d, period time.Duration, fn func()) *Timer {
+	t := &Timer{wheel: w, fn: fn, period: period}
+	w.mu.Lock()
+	w.schedule(t, time.Now().Add(d))
+	w.mu.Unlock()
+	return t
+}
+
+// schedule links t into the slot expired at when, and wakes the wheel earlier if needed, w.mu must be held.
+func (w *timerWheel) schedule(t *Timer, when time.Time) {
+	if w.count == 0 {
+		w.base = time.Now()
+	}
+	ticks := 0
+	if d := when.Sub(w.base); d > 0 {
+		ticks = int((d + timerTick - 1) / timerTick)
+	}
+	t.slot = (w.current + ticks) % timerSlots
+	t.rounds = ticks / timerSlots
+	t.prev, t.next = nil, w.slots[t.slot]
+	if t.next != nil {
+		t.next.prev = t
+	}
+	w.slots[t.slot] = t
+	t.scheduled = true
+	w.count++
+	// the pending expiring wakes the wheel by itself
+	at := w.base.Add(time.Duration(ticks%timerSlots) * timerTick)
+	if !w.expiring && (w.wakeAt.IsZero() || at.Before(w.wakeAt)) {
+		w.wake(at)
+	}
+}
+
+// unlink removes t from its slot, w.mu must be held.
+func (w *timerWheel) unlink(t *Timer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		w.slots[t.slot] = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.prev, t.next = nil, nil
+	t.scheduled = false
+	w.count--
+}
+
+// wake arms the waker to fire at, w.mu must be held.
+func (w *timerWheel) wake(at time.Time) {
+	w.wakeAt = at
+	if w.waker == nil {
+		w.waker = time.AfterFunc(time.Until(at), w.fire)
+		return
+	}
+	w.waker.Reset(time.Until(at))
+}
+
+// fire is called by the waker, and posts the expiring to the poller.
+func (w *timerWheel) fire() {
+	w.mu.Lock()
+	if w.expiring {
+		w.mu.Unlock()
+		return
+	}
+	w.expiring, w.wakeAt = true, time.Time{}
+	w.mu.Unlock()
+	if w.post == nil || w.post(w.expire) != nil {
+		w.expire()
+	}
+}
+
+// expire runs the timers expired until now, and wakes the wheel again at the next slot having timers.
+// The ticks missed by a busy poller are caught up, so that the timers are not delayed cumulatively.
+func (w *timerWheel) expire() {
+	var expired []*Timer
+	w.mu.Lock()
+	w.expiring = false
+	for now := time.Now(); w.count > 0 && !w.base.After(now); {
+		if expired = w.advance(expired[:0]); len(expired) == 0 {
+			continue
+		}
+		w.mu.Unlock()
+		for i, t := range expired {
+			if t.period == 0 || t.ticking() {
+				t.fn()
+			}
+			expired[i] = nil
+		}
+		w.mu.Lock()
+	}
+	w.wakeNext()
+	w.mu.Unlock()
+}
+
+// wakeNext arms the waker at the next slot having timers, w.mu must be held.
+func (w *timerWheel) wakeNext() {
+	if w.count == 0 {
+		if w.waker != nil {
+			w.waker.Stop()
+		}
+		w.wakeAt = time.Time{}
+		return
+	}
+	for i := 0; i < timerSlots; i++ {
+		if w.slots[(w.current+i)%timerSlots] != nil {
+			w.wake(w.base.Add(time.Duration(i) * timerTick))
+			return
+		}
+	}
+}
+
+// advance expires the timers of the current slot and moves to the next slot, the timers of Tick are rescheduled.
+func (w *timerWheel) advance(expired []*Timer) []*Timer {
+	for t := w.slots[w.current]; t != nil; {
+		next := t.next
+		if t.rounds > 0 {
+			t.rounds--
+		} else {
+			w.unlink(t)
+			expired = append(expired, t)
+		}
+		t = next
+	}
+	now := w.base
+	w.current = (w.current + 1) % timerSlots
+	w.base = w.base.Add(timerTick)
+	for _, t := range expired {
+		if t.period > 0 {
+			w.schedule(t, now.Add(t.period))
+		}
+	}
+	return expired
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEventLoopTimer(t *testing.T) {
+	loop, err := NewEventLoop(nil)
+	MustNil(t, err)
+	timers := loop.(TimerScheduler)
+
+	fired := make(chan time.Time, 1)
+	begin := time.Now()
+	timer := timers.AfterFunc(20*time.Millisecond, func() { fired <- time.Now() })
+	at := <-fired
+	Assert(t, at.Sub(begin) >= 20*time.Millisecond, at.Sub(begin))
+	MustTrue(t, !timer.Stop())
+
+	// stopped before firing
+	var calls int32
+	timer = timers.AfterFunc(20*time.Millisecond, func() { atomic.AddInt32(&calls, 1) })
+	MustTrue(t, timer.Stop())
+	MustTrue(t, !timer.Stop())
+
+	// ticking until stopped
+	ticks := make(chan struct{}, 16)
+	timer = timers.Tick(5*time.Millisecond, func() { ticks <- struct{}{} })
+	for i := 0; i < 3; i++ {
+		<-ticks
+	}
+	MustTrue(t, timer.Stop())
+	for len(ticks) > 0 {
+		<-ticks
+	}
+	time.Sleep(40 * time.Millisecond)
+	Equal(t, len(ticks), 0)
+	Equal(t, atomic.LoadInt32(&calls), int32(0))
+
+	// no timer is left in the wheel
+	w := timer.wheel
+	w.mu.Lock()
+	Equal(t, w.count, 0)
+	w.mu.Unlock()
+}
+
+func TestEventLoopTimerDedicatedPollers(t *testing.T) {
+	loop, err := NewEventLoop(nil, WithNumLoops(2))
+	MustNil(t, err)
+	evl, timers := loop.(*eventLoop), loop.(TimerScheduler)
+
+	fired := make(chan struct{}, 1)
+	timer := timers.AfterFunc(10*time.Millisecond, func() { fired <- struct{}{} })
+	<-fired
+	// the timer is scheduled by the wheel of one of the dedicated pollers instead of the global ones
+	var dedicated bool
+	for _, p := range evl.pollers.all() {
+		dedicated = dedicated || pollTimers(p) == timer.wheel
+	}
+	MustTrue(t, dedicated)
+	for _, p := range pollmanager.all() {
+		MustTrue(t, pollTimers(p) != timer.wheel)
+	}
+
+	timer = timers.Tick(5*time.Millisecond, func() {})
+	dedicated = false
+	for _, p := range evl.pollers.all() {
+		dedicated = dedicated || pollTimers(p) == timer.wheel
+	}
+	MustTrue(t, dedicated)
+	MustTrue(t, timer.Stop())
+	MustNil(t, loop.Shutdown(context.Background()))
+}
+
+func TestTimerWheelRounds(t *testing.T) {
+	// the expiring is driven by the test
+	w := &timerWheel{post: func(task func()) error { return nil }}
+	var calls int32
+	timer := &Timer{wheel: w, fn: func() { atomic.AddInt32(&calls, 1) }}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.current, w.base = timerSlots-1, time.Now()
+	w.schedule(timer, w.base.Add((timerSlots+1)*timerTick))
+
+	// expired after one round and two more ticks
+	for i := 0; i < timerSlots+1; i++ {
+		Equal(t, len(w.advance(nil)), 0)
+	}
+	expired := w.advance(nil)
+	Equal(t, len(expired), 1)
+	MustTrue(t, expired[0] == timer)
+	Equal(t, w.count, 0)
+	MustTrue(t, !timer.scheduled)
+}
+
+func TestTimerWheelPollerClosed(t *testing.T) {
+	poll, err := openDefaultPoll()
+	MustNil(t, err)
+	go poll.Wait()
+	w := pollTimers(poll)
+	MustTrue(t, w == &poll.wheel)
+
+	fired := make(chan struct{})
+	w.afterFunc(5*time.Millisecond, 0, func() { close(fired) })
+	<-fired
+
+	// the timers are run by the waker once the poller is closed
+	MustNil(t, poll.Close())
+	for poll.post(func() {}) == nil {
+		time.Sleep(time.Millisecond)
+	}
+	fired = make(chan struct{})
+	w.afterFunc(5*time.Millisecond, 0, func() { close(fired) })
+	<-fired
+}
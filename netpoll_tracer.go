@@ -0,0 +1,68 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"time"
+)
+
+// TraceEvent is the lifecycle event of a connection reported to Tracer.
+type TraceEvent int
+
+const (
+	// TraceAccept is reported when the connection is accepted, before OnPrepare.
+	TraceAccept TraceEvent = iota
+	// TraceFirstByte is reported when the first data of the connection is read.
+	TraceFirstByte
+	// TraceRequestStart is reported before each OnRequest call.
+	TraceRequestStart
+	// TraceRequestEnd is reported after each OnRequest call, with the error returned by OnRequest.
+	TraceRequestEnd
+	// TraceFlush is reported after each Flush, with the error returned by Flush.
+	TraceFlush
+	// TraceClose is reported when the connection is closed, after OnClose.
+	TraceClose
+)
+
+var traceEventNames = [...]string{
+	TraceAccept:       "accept",
+	TraceFirstByte:    "first_byte",
+	TraceRequestStart: "request_start",
+	TraceRequestEnd:   "request_end",
+	TraceFlush:        "flush",
+	TraceClose:        "close",
+}
+
+// String implements fmt.Stringer.
+func (e TraceEvent) String() string {
+	if e >= 0 && int(e) < len(traceEventNames) {
+		return traceEventNames[e]
+	}
+	return "unknown"
+}
+
+// TraceInfo describes a traced event.
+type TraceInfo struct {
+	Event TraceEvent
+	Time  time.Time
+	Conn  Connection // the connection passed to callbacks, use it to get the metadata such as RemoteAddr
+	Err   error      // error of OnRequest or Flush, if any
+}
+
+// Tracer receives the lifecycle events of the connections accepted by EventLoop, see WithTracer.
+// Trace is called synchronously in the poller or the OnRequest goroutine, so it must return as quick as possible.
+type Tracer interface {
+	Trace(info TraceInfo)
+}
@@ -0,0 +1,91 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testTracer chan TraceInfo
+
+func (t testTracer) Trace(info TraceInfo) {
+	t <- info
+}
+
+func TestTracer(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	errRequest := errors.New("request error")
+	tracer := make(testTracer, 16)
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			_, err = connection.Writer().WriteBinary(buf)
+			if err != nil {
+				return err
+			}
+			if err = connection.Writer().Flush(); err != nil {
+				return err
+			}
+			return errRequest
+		},
+		WithTracer(tracer),
+	)
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn.Writer().WriteString("hello")
+	MustNil(t, err)
+	MustNil(t, conn.Writer().Flush())
+	_, err = conn.Reader().Next(5)
+	MustNil(t, err)
+	MustNil(t, conn.Close())
+
+	expected := []TraceEvent{TraceAccept, TraceFirstByte, TraceRequestStart, TraceFlush, TraceRequestEnd, TraceClose}
+	var last time.Time
+	for _, event := range expected {
+		var info TraceInfo
+		select {
+		case info = <-tracer:
+		case <-time.After(time.Second):
+			t.Fatalf("event %s is not traced", event)
+		}
+		Equal(t, info.Event.String(), event.String())
+		Equal(t, info.Conn.LocalAddr().String(), address)
+		Assert(t, !info.Time.Before(last), info.Time, last)
+		last = info.Time
+		switch event {
+		case TraceFlush:
+			MustNil(t, info.Err)
+		case TraceRequestEnd:
+			Equal(t, info.Err, errRequest)
+		}
+	}
+
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+	select {
+	case info := <-tracer:
+		t.Fatalf("unexpected event %s", info.Event)
+	default:
+	}
+}
@@ -18,20 +18,19 @@ package netpoll
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
-	"log"
 	"net"
-	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cloudwego/netpoll/internal/runner"
 )
 
-var (
-	pollmanager = newManager(runtime.GOMAXPROCS(0)/20 + 1) // pollmanager manage all pollers
-	logger      = log.New(os.Stderr, "", log.LstdFlags)
-)
+var pollmanager = newManager(runtime.GOMAXPROCS(0)/20 + 1) // pollmanager manage all pollers
 
 // Initialize the pollers actively. By default, it's lazy initialized.
 // It's safe to call it multi times.
@@ -56,13 +55,42 @@ func Configure(config Config) (err error) {
 		runner.RunTask = config.Runner
 	}
 	if config.LoggerOutput != nil {
-		logger = log.New(config.LoggerOutput, "", log.LstdFlags)
+		logger = newStdLogger(config.LoggerOutput)
+	}
+	if config.Logger != nil {
+		logger = config.Logger
+	}
+	if config.StatsTiming {
+		atomic.StoreInt32(&statsTiming, 1)
+	}
+	if config.StatsBuffers {
+		atomic.StoreInt32(&statsBuffers, 1)
+	}
+	if config.PollerEngine != DefaultEngine {
+		if err = pollmanager.SetPollerEngine(config.PollerEngine); err != nil {
+			return err
+		}
 	}
 	if config.LoadBalance >= 0 {
 		if err = pollmanager.SetLoadBalance(config.LoadBalance); err != nil {
 			return err
 		}
 	}
+	if config.LoadBalancer != nil {
+		if err = pollmanager.SetCustomLoadBalance(config.LoadBalancer); err != nil {
+			return err
+		}
+	}
+	if config.PollerAffinity != nil {
+		if err = pollmanager.SetPollerAffinity(config.PollerAffinity); err != nil {
+			return err
+		}
+	}
+	if config.PollerBatch != 0 || config.PollerSpin != 0 {
+		if err = pollmanager.SetPollerWait(config.PollerBatch, config.PollerSpin); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -73,12 +101,16 @@ func Configure(config Config) (err error) {
 // Otherwise, you may need to adjust the number of pollers to achieve the best results.
 // Experience recommends assigning a poller every 20c.
 //
-// You can only use SetNumLoops before any connection is created. An example usage:
+// SetNumLoops is generally used before any connection is created. An example usage:
 //
 //	func init() {
 //	    netpoll.SetNumLoops(...)
 //	}
 //
+// It can also be called at runtime. When growing, the new connections are routed to the new pollers by the
+// load balance. When shrinking, the removed pollers stop receiving new connections, and are closed after
+// all the connections on them are closed, or migrated by WithRebalance.
+//
 // Deprecated: use Configure instead.
 func SetNumLoops(numLoops int) error {
 	return pollmanager.SetNumLoops(numLoops)
@@ -92,10 +124,36 @@ func SetLoadBalance(lb LoadBalance) error {
 	return pollmanager.SetLoadBalance(lb)
 }
 
+// SetCustomLoadBalance sets the user-defined load balancer, which overrides the LoadBalance.
+// Like SetLoadBalance, it should be called before any connection is created.
+func SetCustomLoadBalance(lb LoadBalancer) error {
+	return pollmanager.SetCustomLoadBalance(lb)
+}
+
+// MigrateConnection moves the registration of conn to poll, which is one of the pollers passed to LoadBalancer,
+// so that the long-lived connections can be rebalanced among the pollers. The connection keeps working during
+// the migration, and the events happening meanwhile are handled after it's registered to the new poll.
+// It returns ErrConcurrentAccess if conn is being processed, so it cannot be called in the callbacks of conn.
+// And it waits for the old poller to finish the events in progress, so it must not be called in the poller.
+func MigrateConnection(conn Connection, poll Poll) error {
+	c := rawConnection(conn)
+	if c == nil {
+		return Exception(ErrUnsupported, "migrate non-netpoll connection")
+	}
+	return c.migrate(poll)
+}
+
+// RegisterFD attaches the user-owned fd to one of the pollers, so that the fds like eventfd, timerfd and pipes
+// can be driven by the same pollers as the connections instead of extra goroutines. The fd must be non-blocking.
+// The callbacks of handler are called in the poller, see FDHandler for details.
+func RegisterFD(fd int, handler FDHandler) (FDWatcher, error) {
+	return newFDWatcher(pollmanager.pick(fd), fd, handler)
+}
+
 // SetLoggerOutput sets the logger output target.
 // Deprecated: use Configure instead.
 func SetLoggerOutput(w io.Writer) {
-	logger = log.New(w, "", log.LstdFlags)
+	logger = newStdLogger(w)
 }
 
 // SetRunner set the runner function for every OnRequest/OnConnect callback
@@ -125,28 +183,202 @@ func NewEventLoop(onRequest OnRequest, ops ...Option) (EventLoop, error) {
 	for _, do := range ops {
 		do.f(opts)
 	}
-	return &eventLoop{
+	if opts.memoryLimit > 0 {
+		opts.budget = newMemoryBudget(opts.memoryLimit, opts.onPressure)
+	}
+	if wl := opts.writeLimit; wl != nil {
+		opts.egress = newWriteLimiter(wl.bytesPerSec, wl.burst)
+	}
+	evl := &eventLoop{
 		opts: opts,
 		stop: make(chan error, 1),
-	}, nil
+	}
+	var nodes [][]int
+	if rb := opts.rebalance; rb != nil && rb.interval <= 0 {
+		return nil, fmt.Errorf("set invalid rebalance interval[%s]", rb.interval)
+	}
+	if opts.numaLoops > 0 {
+		var err error
+		if nodes, err = numaNodes(); err != nil {
+			return nil, err
+		}
+		opts.numLoops = opts.numaLoops * len(nodes)
+	}
+	if opts.numLoops > 0 {
+		evl.pollers = newManager(opts.numLoops)
+		evl.pollers.SetLoadBalance(opts.loadBalance)
+		if opts.loadBalancer != nil {
+			evl.pollers.SetCustomLoadBalance(opts.loadBalancer)
+		}
+		evl.pollers.SetPollerEngine(pollmanager.engine)
+		if opts.affinity != nil {
+			if err := evl.pollers.SetPollerAffinity(opts.affinity); err != nil {
+				return nil, err
+			}
+		}
+		if err := evl.pollers.SetPollerWait(opts.pollerBatch, opts.pollerSpin); err != nil {
+			return nil, err
+		}
+		if nodes != nil {
+			if err := evl.pollers.SetNUMANodes(nodes); err != nil {
+				return nil, err
+			}
+		}
+		opts.pollers = evl.pollers
+	}
+	return evl, nil
+}
+
+// pickPoll picks a poll for fd from the dedicated pollers of opts if any, otherwise from the global pollers.
+func pickPoll(opts *options, fd int) Poll {
+	if opts != nil && opts.pollers != nil {
+		return opts.pollers.pick(fd)
+	}
+	return pollmanager.pick(fd)
+}
+
+// steeredPicker always picks the poller of idx, see WithReusePortSteering.
+type steeredPicker struct {
+	m   *manager
+	idx int
+}
+
+// pick implements pollPicker.
+func (p steeredPicker) pick(fd int) Poll {
+	// make sure the pollers are running
+	p.m.pick(fd)
+	polls := p.m.polls
+	return polls[p.idx%len(polls)]
 }
 
 type eventLoop struct {
 	sync.Mutex
-	opts *options
-	svr  *server
-	stop chan error
+	opts    *options
+	svrs    []*server // one server for each listener, see ServeListeners
+	pconn   *packetConnection
+	pollers *manager // dedicated pollers, see WithNumLoops
+	stop    chan error
+	connNum int32 // number of connections of all the servers, only counted if maxConns is set
+
+	// closed to stop the rebalancing, and closed by the rebalancing once it's stopped, see WithRebalance
+	rebalanceStop, rebalanceDone chan struct{}
 }
 
+var (
+	_ PacketServer      = &eventLoop{}
+	_ ListenersServer   = &eventLoop{}
+	_ ConnectionsLister = &eventLoop{}
+	_ TimerScheduler    = &eventLoop{}
+)
+
 // Serve implements EventLoop.
 func (evl *eventLoop) Serve(ln net.Listener) error {
-	npln, err := ConvertListener(ln)
+	return evl.ServeListeners(ln)
+}
+
+// ServeListeners implements ListenersServer.
+func (evl *eventLoop) ServeListeners(listeners ...net.Listener) error {
+	if len(listeners) == 0 {
+		return errors.New("no listener to serve")
+	}
+	lns := make([]Listener, 0, len(listeners))
+	for _, ln := range listeners {
+		npln, err := ConvertListener(ln)
+		if err != nil {
+			return err
+		}
+		lns = append(lns, npln)
+	}
+	svrOpts := make(map[Listener]*options) // the options of the listeners steered by WithReusePortSteering
+	if evl.opts.reusePort {
+		// create one listener for each poller, and let the kernel distribute the connections.
+		numLoops := int(atomic.LoadInt32(&pollmanager.numLoops))
+		if evl.pollers != nil {
+			numLoops = evl.opts.numLoops
+		}
+		var created []Listener
+		for _, ln := range lns {
+			more, err := reusePortListeners(ln, numLoops-1)
+			if err == nil && evl.opts.steering != nil && len(more) > 0 {
+				err = evl.steer(ln, more, svrOpts)
+			}
+			if err != nil {
+				for _, l := range append(created, more...) {
+					l.Close()
+				}
+				return err
+			}
+			created = append(created, more...)
+		}
+		lns = append(lns, created...)
+	}
+	evl.Lock()
+	for _, ln := range lns {
+		opts := evl.opts
+		if o, ok := svrOpts[ln]; ok {
+			opts = o
+		}
+		svr := newServer(ln, opts, &evl.connNum, evl.quit)
+		svr.Run()
+		evl.svrs = append(evl.svrs, svr)
+	}
+	// all the servers are rebalanced by one goroutine
+	if rb := evl.opts.rebalance; rb != nil && evl.rebalanceStop == nil {
+		evl.rebalanceStop, evl.rebalanceDone = make(chan struct{}), make(chan struct{})
+		go evl.rebalance(rb.interval, rb.threshold, evl.rebalanceStop, evl.rebalanceDone)
+	}
+	evl.Unlock()
+
+	err := evl.waitQuit()
+	// ensure evl will not be finalized until Serve returns
+	runtime.SetFinalizer(evl, nil)
+	return err
+}
+
+// steer attaches the steering program to the reuseport group of ln and more, and sets the options of
+// the listeners in svrOpts, which serve each listener and its connections by the poller of the same index.
+func (evl *eventLoop) steer(ln Listener, more []Listener, svrOpts map[Listener]*options) (err error) {
+	group := append([]Listener{ln}, more...)
+	if prog := evl.opts.steering.progFD; prog >= 0 {
+		err = setReusePortProgram(ln.Fd(), prog)
+	} else {
+		err = setReusePortCPU(ln.Fd(), len(group))
+	}
 	if err != nil {
 		return err
 	}
+	pollers := evl.pollers
+	if pollers == nil {
+		pollers = pollmanager
+	}
+	for i, l := range group {
+		opts := *evl.opts
+		opts.pollers = steeredPicker{m: pollers, idx: i}
+		svrOpts[l] = &opts
+	}
+	return nil
+}
+
+// ServePacket implements PacketServer.
+func (evl *eventLoop) ServePacket(pc net.PacketConn) error {
+	if evl.opts.onPacket == nil {
+		return errors.New("OnPacket must be set by WithOnPacket before ServePacket")
+	}
+	conn, err := ConvertPacketConn(pc)
+	if err != nil {
+		return err
+	}
+	pconn, ok := conn.(*packetConnection)
+	if !ok {
+		return errors.New("packet conn type can't support")
+	}
 	evl.Lock()
-	evl.svr = newServer(npln, evl.opts, evl.quit)
-	evl.svr.Run()
+	evl.pconn = pconn
+	pconn.executor = evl.opts.executor
+	if evl.opts.packetBatch > 1 {
+		pconn.SetReadBatch(evl.opts.packetBatch)
+	}
+	pconn.SetOnPacket(evl.opts.onPacket)
 	evl.Unlock()
 
 	err = evl.waitQuit()
@@ -157,16 +389,193 @@ func (evl *eventLoop) Serve(ln net.Listener) error {
 
 // Shutdown signals a shutdown a begins server closing.
 func (evl *eventLoop) Shutdown(ctx context.Context) error {
+	err := evl.shutdown(ctx)
+	if err == nil && evl.pollers != nil {
+		// all the connections have been closed, and the pollers will be opened again if Serve is called again.
+		evl.Lock()
+		evl.pollers.Release()
+		evl.Unlock()
+	}
+	return err
+}
+
+func (evl *eventLoop) shutdown(ctx context.Context) error {
 	evl.Lock()
-	svr := evl.svr
-	evl.svr = nil
+	svrs, pconn := evl.svrs, evl.pconn
+	evl.svrs, evl.pconn = nil, nil
+	stop, done := evl.rebalanceStop, evl.rebalanceDone
+	evl.rebalanceStop, evl.rebalanceDone = nil, nil
 	evl.Unlock()
 
-	if svr == nil {
+	if stop != nil {
+		// wait for the migrating, so that the pollers will not be released during it
+		close(stop)
+		<-done
+	}
+	if len(svrs) == 0 && pconn == nil {
 		return nil
 	}
 	evl.quit(nil)
-	return svr.Close(ctx)
+	if pconn != nil {
+		if err := pconn.closeGracefully(ctx); err != nil {
+			return err
+		}
+	}
+	if len(svrs) == 1 {
+		return svrs[0].Close(ctx)
+	}
+	// close the servers concurrently, so that all the listeners stop accepting at once.
+	var wg sync.WaitGroup
+	errs := make([]error, len(svrs))
+	for i, svr := range svrs {
+		wg.Add(1)
+		go func(i int, svr *server) {
+			defer wg.Done()
+			errs[i] = svr.Close(ctx)
+		}(i, svr)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AfterFunc implements TimerScheduler.
+func (evl *eventLoop) AfterFunc(d time.Duration, fn func()) *Timer {
+	return evl.timers().afterFunc(d, 0, fn)
+}
+
+// Tick implements TimerScheduler.
+func (evl *eventLoop) Tick(d time.Duration, fn func()) *Timer {
+	if d <= 0 {
+		panic("netpoll: non-positive interval for Tick")
+	}
+	return evl.timers().afterFunc(d, d, fn)
+}
+
+// timers returns the timer wheel of one of the dedicated pollers if any, otherwise of the global pollers.
+func (evl *eventLoop) timers() *timerWheel {
+	if evl.pollers != nil {
+		return pollTimers(evl.pollers.Pick())
+	}
+	return pollTimers(pollmanager.Pick())
+}
+
+// Connections implements ConnectionsLister.
+func (evl *eventLoop) Connections() []ConnInfo {
+	evl.Lock()
+	svrs := evl.svrs
+	var polls []Poll
+	if len(svrs) > 0 {
+		if evl.pollers != nil {
+			polls = evl.pollers.all()
+		} else {
+			polls = pollmanager.all()
+		}
+	}
+	evl.Unlock()
+
+	var infos []ConnInfo
+	for _, svr := range svrs {
+		svr.connections.Range(func(key, value interface{}) bool {
+			if c, ok := value.(*connection); ok && c.IsActive() {
+				infos = append(infos, c.info(polls))
+			}
+			return true
+		})
+	}
+	return infos
+}
+
+// rebalance migrates the connections every interval until stop is closed.
+// The migrating waits for the old pollers, so it's done without the lock, and Shutdown waits for done instead.
+func (evl *eventLoop) rebalance(interval time.Duration, threshold int, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		evl.Lock()
+		svrs := evl.svrs
+		var polls []Poll
+		if evl.pollers != nil {
+			polls = evl.pollers.all()
+		} else {
+			polls = pollmanager.all()
+		}
+		evl.Unlock()
+		rebalanceConnections(svrs, polls, threshold)
+	}
+}
+
+// rebalanceConnections migrates the connections of svrs from the most loaded poll to the least loaded one,
+// if the difference of their live connections exceeds threshold. Only the connections of svrs are counted,
+// since the polls may be shared with the other EventLoops and clients.
+// The listeners and connections left on the polls removed by shrinking are always migrated, so that the polls can be drained.
+func rebalanceConnections(svrs []*server, polls []Poll, threshold int) {
+	if len(polls) == 0 {
+		return
+	}
+	counts := make(map[Poll]int, len(polls))
+	for _, p := range polls {
+		counts[p] = 0
+	}
+	// collect the candidates first, since the migrating takes a while
+	var listeners []*FDOperator
+	var conns, left []*connection
+	for _, svr := range svrs {
+		if svr.operator != nil {
+			if _, ok := counts[svr.operator.currentPoll()]; !ok {
+				listeners = append(listeners, svr.operator)
+			}
+		}
+		svr.connections.Range(func(key, value interface{}) bool {
+			if c, ok := value.(*connection); ok && c.IsActive() {
+				if _, ok = counts[c.operator.currentPoll()]; ok {
+					counts[c.operator.currentPoll()]++
+					conns = append(conns, c)
+				} else {
+					left = append(left, c)
+				}
+			}
+			return true
+		})
+	}
+	most, least := polls[0], polls[0]
+	for _, p := range polls {
+		if counts[p] > counts[most] {
+			most = p
+		}
+		if counts[p] < counts[least] {
+			least = p
+		}
+	}
+
+	for _, op := range listeners {
+		op.migrate(least)
+	}
+	for _, c := range left {
+		c.migrate(least)
+	}
+	if counts[most]-counts[least] <= threshold {
+		return
+	}
+	moves := (counts[most] - counts[least]) / 2
+	for _, c := range conns {
+		if moves == 0 {
+			return
+		}
+		if c.operator.currentPoll() == most && c.migrate(least) == nil {
+			moves--
+		}
+	}
 }
 
 // waitQuit waits for a quit signal
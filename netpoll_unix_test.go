@@ -20,8 +20,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -146,6 +149,62 @@ func TestOnConnectWrite(t *testing.T) {
 	MustNil(t, err)
 }
 
+func TestOnConnectBlocking(t *testing.T) {
+	type prepareKey struct{}
+	type connectKey struct{}
+	network, address := "tcp", getTestAddress()
+	trigger := make(chan struct{})
+	var connecting int32
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			// OnRequest must see the context returned by both OnPrepare and OnConnect
+			Equal(t, ctx.Value(prepareKey{}), "prepare")
+			Equal(t, ctx.Value(connectKey{}), "connect")
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			MustNil(t, err)
+			_, err = connection.Writer().WriteBinary(buf)
+			MustNil(t, err)
+			return connection.Writer().Flush()
+		},
+		WithOnPrepare(func(connection Connection) context.Context {
+			return context.WithValue(context.Background(), prepareKey{}, "prepare")
+		}),
+		WithOnConnect(func(ctx context.Context, conn Connection) context.Context {
+			// blocking work will not block the poller or other connections
+			if atomic.AddInt32(&connecting, 1) == 1 {
+				<-trigger
+			}
+			return context.WithValue(ctx, connectKey{}, "connect")
+		}),
+	)
+
+	blocked, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = blocked.Write([]byte("ping"))
+	MustNil(t, err)
+	for atomic.LoadInt32(&connecting) == 0 {
+		runtime.Gosched()
+	}
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn.Write([]byte("ping"))
+	MustNil(t, err)
+	buf, err := conn.Reader().Next(4)
+	MustNil(t, err)
+	Equal(t, string(buf), "ping")
+	// OnRequest of the blocked connection is not called until OnConnect returns
+	Equal(t, blocked.Reader().Len(), 0)
+	close(trigger)
+	buf, err = blocked.Reader().Next(4)
+	MustNil(t, err)
+	Equal(t, string(buf), "ping")
+
+	MustNil(t, conn.Close())
+	MustNil(t, blocked.Close())
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+}
+
 func TestOnDisconnect(t *testing.T) {
 	type ctxKey struct{}
 	network, address := "tcp", getTestAddress()
@@ -256,65 +315,596 @@ func TestOnDisconnectWhenOnConnect(t *testing.T) {
 	MustNil(t, err)
 }
 
+func TestOnClose(t *testing.T) {
+	type ctxKey struct{}
+	network, address := "tcp", getTestAddress()
+	var requesting, closed int32
+	var conns int32 = 10
+	trigger := make(chan struct{})
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			atomic.StoreInt32(&requesting, 1)
+			<-trigger
+			_, err := connection.Reader().Next(connection.Reader().Len())
+			atomic.StoreInt32(&requesting, 0)
+			return err
+		},
+		WithOnConnect(func(ctx context.Context, conn Connection) context.Context {
+			return context.WithValue(ctx, ctxKey{}, "session")
+		}),
+		WithOnClose(func(ctx context.Context, conn Connection) {
+			// OnRequest has finished and the buffers are still available
+			Equal(t, atomic.LoadInt32(&requesting), int32(0))
+			Equal(t, ctx.Value(ctxKey{}), "session")
+			Equal(t, conn.Reader().Len(), 0)
+			atomic.AddInt32(&closed, 1)
+		}),
+	)
+
+	for i := int32(0); i < conns; i++ {
+		conn, err := DialConnection(network, address, time.Second)
+		MustNil(t, err)
+		_, err = conn.Write([]byte("ping"))
+		MustNil(t, err)
+		for atomic.LoadInt32(&requesting) == 0 {
+			runtime.Gosched()
+		}
+		// closed by peer when OnRequest is running
+		err = conn.Close()
+		MustNil(t, err)
+		time.Sleep(10 * time.Millisecond)
+		Equal(t, atomic.LoadInt32(&closed), i)
+		trigger <- struct{}{}
+		for atomic.LoadInt32(&closed) == i {
+			runtime.Gosched()
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	Equal(t, atomic.LoadInt32(&closed), conns)
+
+	err := loop.Shutdown(context.Background())
+	MustNil(t, err)
+}
+
+func TestOnIdle(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	var idles int32
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			_, err := connection.Reader().Next(connection.Reader().Len())
+			return err
+		},
+		WithIdleTimeout(50*time.Millisecond),
+		WithOnIdle(func(conn Connection) bool {
+			// ping the peer for the first time, and close the connection for the second time
+			if atomic.AddInt32(&idles, 1) > 1 {
+				return false
+			}
+			_, err := conn.Writer().WriteString("ping")
+			MustNil(t, err)
+			MustNil(t, conn.Writer().Flush())
+			return true
+		}),
+	)
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	// the data read delays the idle timeout
+	time.Sleep(30 * time.Millisecond)
+	_, err = conn.Write([]byte("hello"))
+	MustNil(t, err)
+	time.Sleep(30 * time.Millisecond)
+	Equal(t, atomic.LoadInt32(&idles), int32(0))
+
+	MustNil(t, conn.SetReadTimeout(time.Second))
+	buf, err := conn.Reader().Next(4)
+	MustNil(t, err)
+	Equal(t, string(buf), "ping")
+	_, err = conn.Reader().Next(1)
+	MustTrue(t, errors.Is(err, ErrEOF))
+	Equal(t, atomic.LoadInt32(&idles), int32(2))
+	MustNil(t, conn.Close())
+
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+}
+
 func TestGracefulExit(t *testing.T) {
 	network, address := "tcp", getTestAddress()
 
-	// exit without processing connections
-	eventLoop1 := newTestEventLoop(network, address,
-		func(ctx context.Context, connection Connection) error {
-			return nil
-		})
-	_, err := DialConnection(network, address, time.Second)
+	// exit without processing connections
+	eventLoop1 := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			return nil
+		})
+	_, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	err = eventLoop1.Shutdown(context.Background())
+	MustNil(t, err)
+
+	// exit with processing connections
+	trigger := make(chan struct{})
+	eventLoop2 := newTestEventLoop(network, address,
+		func(ctx context.Context, conn Connection) error {
+			<-trigger
+			rd := conn.Reader()
+			rd.Next(rd.Len()) // avoid dead loop
+			return errors.New("done")
+		})
+	for i := 0; i < 10; i++ {
+		// connect success
+		conn, err := DialConnection(network, address, time.Second)
+		MustNil(t, err)
+		_, err = conn.Write(make([]byte, 16))
+		MustNil(t, err)
+	}
+	// shutdown timeout
+	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel2()
+	err = eventLoop2.Shutdown(ctx2)
+	MustTrue(t, err != nil)
+	Equal(t, err.Error(), ctx2.Err().Error())
+	// shutdown success
+	close(trigger)
+	err = eventLoop2.Shutdown(ctx2)
+	MustTrue(t, err == nil)
+
+	// exit with read connections
+	size := 16
+	eventLoop3 := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			_, err := connection.Reader().Next(size)
+			MustNil(t, err)
+			return nil
+		})
+	for i := 0; i < 10; i++ {
+		conn, err := DialConnection(network, address, time.Second)
+		MustNil(t, err)
+		if i%2 == 0 {
+			_, err := conn.Write(make([]byte, size))
+			MustNil(t, err)
+		}
+	}
+	ctx3, cancel3 := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel3()
+	err = eventLoop3.Shutdown(ctx3)
+	MustNil(t, err)
+}
+
+func TestGracefulExitWithOnShutdown(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	var requesting int32
+	trigger := make(chan struct{})
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			atomic.StoreInt32(&requesting, 1)
+			<-trigger
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			MustNil(t, err)
+			_, err = connection.Writer().WriteBinary(buf)
+			MustNil(t, err)
+			err = connection.Writer().Flush()
+			atomic.StoreInt32(&requesting, 0)
+			return err
+		},
+		WithOnShutdown(func(ctx context.Context, connection Connection) {
+			// never run concurrently with OnRequest
+			Equal(t, atomic.LoadInt32(&requesting), int32(0))
+			_, err := connection.Writer().WriteString("bye")
+			MustNil(t, err)
+			err = connection.Writer().Flush()
+			MustNil(t, err)
+		}),
+	)
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn.Write([]byte("ping"))
+	MustNil(t, err)
+	for atomic.LoadInt32(&requesting) == 0 {
+		runtime.Gosched()
+	}
+	// shutdown waits for the running OnRequest
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- loop.Shutdown(ctx)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(trigger)
+	MustNil(t, <-done)
+
+	// the response and going away message are flushed before closing
+	buf, err := conn.Reader().Next(7)
+	MustNil(t, err)
+	Equal(t, string(buf), "pingbye")
+	_, err = conn.Reader().Next(1)
+	Assert(t, err != nil)
+}
+
+func TestServeListeners(t *testing.T) {
+	tcpAddr := getTestAddress()
+	unixAddr := fmt.Sprintf("%s/netpoll-%d.sock", t.TempDir(), os.Getpid())
+	tcpln, err := createTestListener("tcp", tcpAddr)
+	MustNil(t, err)
+	unixln, err := createTestListener("unix", unixAddr)
+	MustNil(t, err)
+
+	loop, err := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		buf, err := connection.Reader().Next(connection.Reader().Len())
+		if err != nil {
+			return err
+		}
+		_, err = connection.Write(buf)
+		return err
+	})
+	MustNil(t, err)
+	served := make(chan error, 1)
+	go func() {
+		served <- loop.(ListenersServer).ServeListeners(tcpln, unixln)
+	}()
+
+	for _, addr := range []net.Addr{tcpln.Addr(), unixln.Addr()} {
+		conn, err := net.Dial(addr.Network(), addr.String())
+		MustNil(t, err)
+		_, err = conn.Write([]byte("ping"))
+		MustNil(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		MustNil(t, err)
+		Equal(t, string(buf), "ping")
+		conn.Close()
+	}
+
+	// a single Shutdown stops all the listeners
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+	MustNil(t, <-served)
+	for _, addr := range []net.Addr{tcpln.Addr(), unixln.Addr()} {
+		_, err = net.DialTimeout(addr.Network(), addr.String(), time.Second)
+		Assert(t, err != nil, addr)
+	}
+}
+
+func TestOnAccept(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	var accepted, connected int32
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			_, err := connection.Reader().Next(connection.Reader().Len())
+			return err
+		},
+		WithOnAccept(func(fd int, remote net.Addr) error {
+			Assert(t, fd > 0 && remote != nil)
+			if atomic.AddInt32(&accepted, 1) == 1 {
+				return errors.New("denied")
+			}
+			return nil
+		}),
+		WithOnConnect(func(ctx context.Context, connection Connection) context.Context {
+			atomic.AddInt32(&connected, 1)
+			return ctx
+		}),
+	)
+
+	// rejected and closed by server before OnConnect
+	conn1, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn1.Reader().Next(1)
+	Assert(t, err != nil)
+	Equal(t, atomic.LoadInt32(&connected), int32(0))
+
+	conn2, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn2.Write([]byte("ping"))
+	MustNil(t, err)
+	for atomic.LoadInt32(&connected) == 0 {
+		runtime.Gosched()
+	}
+	MustTrue(t, conn2.IsActive())
+	Equal(t, atomic.LoadInt32(&accepted), int32(2))
+
+	MustNil(t, conn1.Close())
+	MustNil(t, conn2.Close())
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+}
+
+func TestWriteRateLimit(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			if _, err := connection.Reader().Next(1); err != nil {
+				return err
+			}
+			if _, err := connection.Writer().WriteBinary(make([]byte, 24*1024)); err != nil {
+				return err
+			}
+			return connection.Writer().Flush()
+		},
+		WithWriteRateLimit(200*1024, 8*1024),
+	)
+
+	// the rate is shared by all the connections
+	begin := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := DialConnection(network, address, time.Second)
+			MustNil(t, err)
+			defer conn.Close()
+			_, err = conn.Write([]byte("x"))
+			MustNil(t, err)
+			_, err = conn.Reader().Next(24 * 1024)
+			MustNil(t, err)
+		}()
+	}
+	wg.Wait()
+	MustTrue(t, time.Since(begin) >= 150*time.Millisecond)
+	MustNil(t, loop.Shutdown(context.Background()))
+}
+
+func TestConnectionTCPInfo(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			_, err = connection.Writer().WriteBinary(buf)
+			if err != nil {
+				return err
+			}
+			return connection.Writer().Flush()
+		},
+	)
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn.Write([]byte("ping"))
+	MustNil(t, err)
+	_, err = conn.Reader().Next(4)
+	MustNil(t, err)
+
+	info, err := conn.(TCPInfoProvider).TCPInfo()
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		MustTrue(t, errors.Is(err, ErrUnsupported))
+	} else {
+		MustNil(t, err)
+		MustTrue(t, info.RTT > 0 && info.MSS > 0 && info.CongestionWindow > 0)
+	}
+
+	// unsupported by non-TCP connections
+	r, w := GetSysFdPairs()
+	rconn := &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r, network: "unix"}, nil))
+	_, err = rconn.TCPInfo()
+	MustTrue(t, errors.Is(err, ErrUnsupported))
+	MustNil(t, rconn.Close())
+	syscall.Close(w)
+
+	MustNil(t, conn.Close())
+	MustNil(t, loop.Shutdown(context.Background()))
+}
+
+func TestMaxConnections(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	var overloaded int32
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			_, err := connection.Reader().Next(connection.Reader().Len())
+			return err
+		},
+		WithMaxConnections(2),
+		WithOnOverload(func(conn net.Conn) {
+			atomic.AddInt32(&overloaded, 1)
+		}),
+	)
+
+	conn1, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	conn2, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	// rejected and closed by server, which may close it before the dialer is ready
+	if conn3, err := DialConnection(network, address, time.Second); err == nil {
+		_, err = conn3.Reader().Next(1)
+		Assert(t, err != nil)
+	}
+	Equal(t, atomic.LoadInt32(&overloaded), int32(1))
+
+	// accept again after a connection closed
+	MustNil(t, conn1.Close())
+	time.Sleep(10 * time.Millisecond)
+	conn4, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn4.Write([]byte("ping"))
+	MustNil(t, err)
+	time.Sleep(10 * time.Millisecond)
+	MustTrue(t, conn4.IsActive())
+	Equal(t, atomic.LoadInt32(&overloaded), int32(1))
+
+	MustNil(t, conn2.Close())
+	MustNil(t, conn4.Close())
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+}
+
+func TestWithExecutor(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	var submitted int32
+	executor := ExecutorFunc(func(task func()) {
+		atomic.AddInt32(&submitted, 1)
+		go task()
+	})
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			_, err = connection.Write(buf)
+			return err
+		},
+		WithExecutor(executor),
+	)
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = conn.Write([]byte("ping"))
+		MustNil(t, err)
+		buf, err := conn.Reader().Next(4)
+		MustNil(t, err)
+		Equal(t, string(buf), "ping")
+	}
+	Assert(t, atomic.LoadInt32(&submitted) > 0)
+
+	MustNil(t, conn.Close())
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+}
+
+func TestWithNumLoops(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	polls := make(chan Poll, 8)
+	ln, err := createTestListener(network, address)
+	MustNil(t, err)
+	loop, err := NewEventLoop(
+		func(ctx context.Context, connection Connection) error {
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			_, err = connection.Write(buf)
+			return err
+		},
+		WithOnPrepare(func(conn Connection) context.Context {
+			polls <- conn.(*connection).operator.poll
+			return context.Background()
+		}),
+		WithNumLoops(2),
+		WithLoadBalance(Random),
+	)
+	MustNil(t, err)
+	pollers := loop.(*eventLoop).pollers
+	go loop.Serve(ln)
+
+	for i := 0; i < 4; i++ {
+		conn, err := DialConnection(network, address, time.Second)
+		MustNil(t, err)
+		_, err = conn.Write([]byte("ping"))
+		MustNil(t, err)
+		buf, err := conn.Reader().Next(4)
+		MustNil(t, err)
+		Equal(t, string(buf), "ping")
+		MustNil(t, conn.Close())
+
+		// the connection is served by the dedicated pollers
+		poll := <-polls
+		MustTrue(t, poll == pollers.polls[0] || poll == pollers.polls[1])
+	}
+	Equal(t, pollers.balance.LoadBalance(), Random)
+
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+	Equal(t, len(pollers.polls), 0)
+}
+
+func TestWithPollerWait(t *testing.T) {
+	_, err := NewEventLoop(nil, WithNumLoops(1), WithPollerBatch(-1))
+	MustTrue(t, err != nil)
+
+	network, address := "tcp", getTestAddress()
+	ln, err := createTestListener(network, address)
+	MustNil(t, err)
+	loop, err := NewEventLoop(
+		func(ctx context.Context, connection Connection) error {
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			_, err = connection.Write(buf)
+			return err
+		},
+		WithNumLoops(1),
+		WithPollerBatch(1),
+		WithPollerSpin(10*time.Millisecond),
+	)
+	MustNil(t, err)
+	pollers := loop.(*eventLoop).pollers
+	go loop.Serve(ln)
+	defer loop.Shutdown(context.Background())
+
+	// the events of the concurrent connections are fetched one by one
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := DialConnection(network, address, time.Second)
+			MustNil(t, err)
+			defer conn.Close()
+			for j := 0; j < 16; j++ {
+				_, err = conn.Write([]byte("ping"))
+				MustNil(t, err)
+				buf, err := conn.Reader().Next(4)
+				MustNil(t, err)
+				Equal(t, string(buf), "ping")
+			}
+		}()
+	}
+	wg.Wait()
+	poll, ok := pollers.polls[0].(*defaultPoll)
+	MustTrue(t, ok)
+	Equal(t, poll.eventBatch, 1)
+	Equal(t, poll.spin, 10*time.Millisecond)
+}
+
+func TestEventLoopConnections(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	ln, err := createTestListener(network, address)
+	MustNil(t, err)
+	release := make(chan struct{})
+	loop, err := NewEventLoop(
+		func(ctx context.Context, connection Connection) error {
+			// keep the data in the input buffer until released
+			<-release
+			return connection.Reader().Skip(connection.Reader().Len())
+		},
+		WithNumLoops(2),
+	)
+	MustNil(t, err)
+	lister := loop.(ConnectionsLister)
+	Equal(t, len(lister.Connections()), 0)
+	go loop.Serve(ln)
+
+	conn, err := DialConnection(network, address, time.Second)
 	MustNil(t, err)
-	err = eventLoop1.Shutdown(context.Background())
+	_, err = conn.Write([]byte("hello"))
 	MustNil(t, err)
+	time.Sleep(50 * time.Millisecond)
 
-	// exit with processing connections
-	trigger := make(chan struct{})
-	eventLoop2 := newTestEventLoop(network, address,
-		func(ctx context.Context, conn Connection) error {
-			<-trigger
-			rd := conn.Reader()
-			rd.Next(rd.Len()) // avoid dead loop
-			return errors.New("done")
-		})
-	for i := 0; i < 10; i++ {
-		// connect success
-		conn, err := DialConnection(network, address, time.Second)
-		MustNil(t, err)
-		_, err = conn.Write(make([]byte, 16))
-		MustNil(t, err)
-	}
-	// shutdown timeout
-	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Millisecond*100)
-	defer cancel2()
-	err = eventLoop2.Shutdown(ctx2)
-	MustTrue(t, err != nil)
-	Equal(t, err.Error(), ctx2.Err().Error())
-	// shutdown success
-	close(trigger)
-	err = eventLoop2.Shutdown(ctx2)
-	MustTrue(t, err == nil)
+	infos := lister.Connections()
+	Equal(t, len(infos), 1)
+	info := infos[0]
+	MustTrue(t, info.FD > 0)
+	Equal(t, info.LocalAddr.String(), conn.RemoteAddr().String())
+	Equal(t, info.RemoteAddr.String(), conn.LocalAddr().String())
+	Equal(t, info.InputBytes, 5)
+	Equal(t, info.OutputBytes, 0)
+	Assert(t, info.IdleTime > 0 && info.IdleTime < time.Second, info.IdleTime)
+	Assert(t, info.Poller == 0 || info.Poller == 1, info.Poller)
 
-	// exit with read connections
-	size := 16
-	eventLoop3 := newTestEventLoop(network, address,
-		func(ctx context.Context, connection Connection) error {
-			_, err := connection.Reader().Next(size)
-			MustNil(t, err)
-			return nil
-		})
-	for i := 0; i < 10; i++ {
-		conn, err := DialConnection(network, address, time.Second)
-		MustNil(t, err)
-		if i%2 == 0 {
-			_, err := conn.Write(make([]byte, size))
-			MustNil(t, err)
-		}
+	close(release)
+	MustNil(t, conn.Close())
+	for i := 0; i < 100 && len(lister.Connections()) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
 	}
-	ctx3, cancel3 := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel3()
-	err = eventLoop3.Shutdown(ctx3)
+	Equal(t, len(lister.Connections()), 0)
+	err = loop.Shutdown(context.Background())
 	MustNil(t, err)
 }
 
@@ -482,6 +1072,103 @@ func TestServerPanicAndClose(t *testing.T) {
 	MustNil(t, err)
 }
 
+func TestOnPanic(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	panics := make(chan interface{}, 1)
+	var stack []byte
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			panic("test")
+		},
+		WithOnPanic(func(connection Connection, recovered interface{}, s []byte) {
+			stack = s
+			panics <- recovered
+		}),
+	)
+	defer loop.Shutdown(context.Background())
+
+	// the server keeps serving after panics
+	for i := 0; i < 2; i++ {
+		conn, err := DialConnection(network, address, time.Second)
+		MustNil(t, err)
+		_, err = conn.Write([]byte("hello"))
+		MustNil(t, err)
+		Equal(t, <-panics, "test")
+		Assert(t, strings.Contains(string(stack), "TestOnPanic"), string(stack))
+		for conn.IsActive() {
+			runtime.Gosched() // wait for poller close connection
+		}
+	}
+
+	// rethrow in strict mode
+	runfunc := runner.RunTask
+	defer func() { runner.RunTask = runfunc }()
+	rethrown := make(chan interface{}, 1)
+	runner.RunTask = func(ctx context.Context, f func()) {
+		go func() {
+			defer func() { rethrown <- recover() }()
+			f()
+		}()
+	}
+	address = getTestAddress()
+	loop2 := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			panic("strict")
+		},
+		WithOnPanic(func(connection Connection, recovered interface{}, stack []byte) {
+			panics <- recovered
+		}),
+		WithStrictPanic(),
+	)
+	defer loop2.Shutdown(context.Background())
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn.Write([]byte("hello"))
+	MustNil(t, err)
+	Equal(t, <-panics, "strict")
+	Equal(t, <-rethrown, "strict")
+	for conn.IsActive() {
+		runtime.Gosched() // wait for poller close connection
+	}
+}
+
+func TestOnErrorWhenPanic(t *testing.T) {
+	// use custom RunTask to ignore panic log
+	runfunc := runner.RunTask
+	defer func() { runner.RunTask = runfunc }()
+	runner.RunTask = func(ctx context.Context, f func()) {
+		go func() {
+			defer func() { recover() }()
+			f()
+		}()
+	}
+
+	network, address := "tcp", getTestAddress()
+	errs := make(chan ErrorInfo, 1)
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			panic("test")
+		},
+		WithOnError(func(info ErrorInfo) {
+			errs <- info
+		}),
+	)
+	defer loop.Shutdown(context.Background())
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn.Write([]byte("hello"))
+	MustNil(t, err)
+
+	info := <-errs
+	Equal(t, info.Op, ErrorOpPanic)
+	Equal(t, info.Addr.String(), address)
+	Assert(t, info.FD > 0 && info.Err != nil, info)
+	for conn.IsActive() {
+		runtime.Gosched() // wait for poller close connection
+	}
+}
+
 func TestClientWriteAndClose(t *testing.T) {
 	var (
 		network, address            = "tcp", getTestAddress()
@@ -620,3 +1307,303 @@ func newTestEventLoop(network, address string, onRequest OnRequest, opts ...Opti
 	go elp.Serve(ln)
 	return elp
 }
+
+func TestServerWriteTimeout(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	errCh := make(chan error, 1)
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			return nil
+		},
+		WithOnConnect(func(ctx context.Context, connection Connection) context.Context {
+			// the peer never reads, so the flush must be blocked by a full socket buffer
+			_, err := connection.Writer().Malloc(16 << 20)
+			MustNil(t, err)
+			errCh <- connection.Writer().Flush()
+			connection.Close()
+			return ctx
+		}),
+		WithWriteTimeout(50*time.Millisecond),
+	)
+	conn, err := net.Dial(network, address)
+	MustNil(t, err)
+	defer conn.Close()
+
+	select {
+	case err = <-errCh:
+		Assert(t, errors.Is(err, ErrWriteTimeout), err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("flush is not timeout")
+	}
+
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+}
+
+type firstPollBalancer struct{}
+
+func (firstPollBalancer) Pick(fd int, pollers []Poll) Poll {
+	return pollers[0]
+}
+
+func TestMigrateConnection(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	conns := make(chan Connection, 1)
+	ln, err := createTestListener(network, address)
+	MustNil(t, err)
+	loop, err := NewEventLoop(
+		func(ctx context.Context, connection Connection) error {
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			_, err = connection.Write(buf)
+			return err
+		},
+		WithOnPrepare(func(conn Connection) context.Context {
+			conns <- conn
+			return context.Background()
+		}),
+		WithNumLoops(2),
+		WithLoadBalancer(firstPollBalancer{}),
+	)
+	MustNil(t, err)
+	pollers := loop.(*eventLoop).pollers
+	go loop.Serve(ln)
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	svrConn := <-conns
+	polls := pollers.all()
+	Equal(t, svrConn.(*connection).operator.currentPoll(), polls[0])
+
+	echo := func() {
+		_, err = conn.Write([]byte("ping"))
+		MustNil(t, err)
+		buf, err := conn.Reader().Next(4)
+		MustNil(t, err)
+		Equal(t, string(buf), "ping")
+	}
+	echo()
+	MustNil(t, MigrateConnection(svrConn, polls[1]))
+	Equal(t, svrConn.(*connection).operator.currentPoll(), polls[1])
+	Equal(t, polls[1].(operatorCounter).numOperators(), 1)
+	echo()
+	// migrate back while the peer is writing
+	_, err = conn.Write([]byte("ping"))
+	MustNil(t, err)
+	MustNil(t, MigrateConnection(svrConn, polls[0]))
+	buf, err := conn.Reader().Next(4)
+	MustNil(t, err)
+	Equal(t, string(buf), "ping")
+	MustNil(t, MigrateConnection(svrConn, polls[1]))
+
+	MustNil(t, conn.Close())
+	for svrConn.IsActive() {
+		runtime.Gosched()
+	}
+	Assert(t, MigrateConnection(svrConn, polls[0]) != nil)
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+}
+
+func TestRebalance(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	ln, err := createTestListener(network, address)
+	MustNil(t, err)
+	loop, err := NewEventLoop(
+		func(ctx context.Context, connection Connection) error {
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			_, err = connection.Write(buf)
+			return err
+		},
+		WithNumLoops(2),
+		WithLoadBalancer(firstPollBalancer{}),
+		WithRebalance(10*time.Millisecond, 1),
+	)
+	MustNil(t, err)
+	pollers := loop.(*eventLoop).pollers
+	go loop.Serve(ln)
+
+	var conns []Connection
+	for i := 0; i < 4; i++ {
+		conn, err := DialConnection(network, address, time.Second)
+		MustNil(t, err)
+		conns = append(conns, conn)
+	}
+	time.Sleep(100 * time.Millisecond)
+	// the listener is counted on the first poll
+	polls := pollers.all()
+	n0, n1 := polls[0].(operatorCounter).numOperators(), polls[1].(operatorCounter).numOperators()
+	Equal(t, n0+n1, 5)
+	Assert(t, n0-n1 <= 1 && n1-n0 <= 1, n0, n1)
+
+	for _, conn := range conns {
+		_, err = conn.Write([]byte("ping"))
+		MustNil(t, err)
+		buf, err := conn.Reader().Next(4)
+		MustNil(t, err)
+		Equal(t, string(buf), "ping")
+		MustNil(t, conn.Close())
+	}
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+}
+
+func TestRebalanceLifecycle(t *testing.T) {
+	_, err := NewEventLoop(nil, WithRebalance(0, 1))
+	Assert(t, err != nil)
+
+	network := "tcp"
+	ln1, err := createTestListener(network, getTestAddress())
+	MustNil(t, err)
+	ln2, err := createTestListener(network, getTestAddress())
+	MustNil(t, err)
+	loop, err := NewEventLoop(
+		func(ctx context.Context, connection Connection) error {
+			_, err := connection.Reader().Next(connection.Reader().Len())
+			return err
+		},
+		WithNumLoops(2),
+		WithRebalance(time.Millisecond, 1),
+	)
+	MustNil(t, err)
+	evl := loop.(*eventLoop)
+	go loop.Serve(ln1)
+	time.Sleep(10 * time.Millisecond)
+	evl.Lock()
+	done := evl.rebalanceDone
+	evl.Unlock()
+	MustTrue(t, done != nil)
+
+	// a single rebalancing for all the listeners
+	go loop.Serve(ln2)
+	time.Sleep(10 * time.Millisecond)
+	evl.Lock()
+	MustTrue(t, evl.rebalanceDone == done)
+	evl.Unlock()
+
+	// stopped by Shutdown
+	MustNil(t, loop.Shutdown(context.Background()))
+	select {
+	case <-done:
+	default:
+		t.Fatal("the rebalancing is not stopped")
+	}
+}
+
+func TestEpollMode(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	var edge int32
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			buf, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			_, err = connection.Write(buf)
+			return err
+		},
+		WithOnPrepare(func(conn Connection) context.Context {
+			if conn.(*connection).operator.edgeTriggered {
+				atomic.StoreInt32(&edge, 1)
+			}
+			return context.Background()
+		}),
+		WithEpollMode(EdgeTriggered),
+		WithMaxInputBuffer(64*1024),
+	)
+	defer loop.Shutdown(context.Background())
+
+	// the large payload is drained by each event, and the reading paused by the input limit is resumed
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	data := make([]byte, 4<<20)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	go func() {
+		_, err := conn.Write(data)
+		MustNil(t, err)
+	}()
+	buf, err := conn.Reader().Next(len(data))
+	MustNil(t, err)
+	Equal(t, string(buf), string(data))
+	Equal(t, atomic.LoadInt32(&edge), int32(1))
+}
+
+func TestEpollModeHup(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	disconnected := make(chan struct{}, 100)
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			_, err := connection.Reader().Next(connection.Reader().Len())
+			return err
+		},
+		WithOnDisconnect(func(ctx context.Context, connection Connection) {
+			disconnected <- struct{}{}
+		}),
+		WithEpollMode(EdgeTriggered),
+	)
+	defer loop.Shutdown(context.Background())
+
+	// the hup reported together with the data is not reported again
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial(network, address)
+		MustNil(t, err)
+		_, err = conn.Write([]byte("ping"))
+		MustNil(t, err)
+		MustNil(t, conn.Close())
+	}
+	for i := 0; i < 100; i++ {
+		select {
+		case <-disconnected:
+		case <-time.After(time.Second):
+			t.Fatal("the hup is missed")
+		}
+	}
+}
+
+func TestEpollExclusive(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	ln, err := createTestListener(network, address)
+	MustNil(t, err)
+	// the listener is watched by the dedicated pollers of both loops
+	var loops []EventLoop
+	for i := 0; i < 2; i++ {
+		loop, err := NewEventLoop(
+			func(ctx context.Context, connection Connection) error {
+				buf, err := connection.Reader().Next(connection.Reader().Len())
+				if err != nil {
+					return err
+				}
+				_, err = connection.Write(buf)
+				return err
+			},
+			WithNumLoops(1),
+			WithEpollExclusive(),
+		)
+		MustNil(t, err)
+		go loop.Serve(ln)
+		loops = append(loops, loop)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	for i := 0; i < 8; i++ {
+		conn, err := DialConnection(network, address, time.Second)
+		MustNil(t, err)
+		_, err = conn.Write([]byte("ping"))
+		MustNil(t, err)
+		buf, err := conn.Reader().Next(4)
+		MustNil(t, err)
+		Equal(t, string(buf), "ping")
+		MustNil(t, conn.Close())
+	}
+	for _, loop := range loops {
+		loop.Shutdown(context.Background())
+	}
+}
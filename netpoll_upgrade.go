@@ -0,0 +1,206 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	// envInheritedListeners lists the listeners inherited from the parent process, in the form of
+	// "network:address,network:address", and their fds start from 3 in order.
+	envInheritedListeners = "NETPOLL_INHERITED_LISTENERS"
+	// envUpgradeReadyFD is the fd of the pipe written by Ready, to tell the parent process to drain.
+	envUpgradeReadyFD = "NETPOLL_UPGRADE_READY_FD"
+)
+
+// Upgrade starts a new process of the current executable with the same arguments and environment, and passes lns
+// to it, so that the new process can resume accepting by InheritListener while the current process drains.
+// It returns after the new process calls Ready, then the current process should call EventLoop.Shutdown to drain
+// the existing connections. If the new process exits or ctx is done before it's ready, an error is returned.
+func Upgrade(ctx context.Context, lns ...Listener) (*os.Process, error) {
+	name, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return upgrade(ctx, lns, name, os.Args[1:])
+}
+
+func upgrade(ctx context.Context, lns []Listener, name string, args []string) (*os.Process, error) {
+	files := make([]*os.File, 0, len(lns)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	addrs := make([]string, 0, len(lns))
+	for _, ln := range lns {
+		addr := ln.Addr()
+		if strings.Contains(addr.String(), ",") {
+			return nil, fmt.Errorf("upgrade listener with invalid address[%s]", addr)
+		}
+		fd, err := syscall.Dup(ln.Fd())
+		if err != nil {
+			return nil, os.NewSyscallError("dup", err)
+		}
+		files = append(files, os.NewFile(uintptr(fd), addr.String()))
+		addrs = append(addrs, addr.Network()+":"+addr.String())
+		// the socket file is still used by the new process
+		if l, ok := ln.(*listener); ok {
+			if ul, ok := l.ln.(*net.UnixListener); ok {
+				ul.SetUnlinkOnClose(false)
+			}
+		}
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	files = append(files, w)
+
+	env := make([]string, 0, len(os.Environ())+2)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envInheritedListeners+"=") && !strings.HasPrefix(kv, envUpgradeReadyFD+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env,
+		envInheritedListeners+"="+strings.Join(addrs, ","),
+		envUpgradeReadyFD+"="+strconv.Itoa(3+len(lns)),
+	)
+	proc, err := os.StartProcess(name, append([]string{name}, args...), &os.ProcAttr{
+		Env:   env,
+		Files: append([]*os.File{os.Stdin, os.Stdout, os.Stderr}, files...),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the write end is only held by the new process
+	w.Close()
+	files = files[:len(files)-1]
+
+	ready := make(chan error, 1)
+	go func() {
+		// a byte is written by Ready, or EOF if the new process exits
+		n, err := r.Read(make([]byte, 1))
+		if n > 0 {
+			err = nil
+		}
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+		if err == nil {
+			return proc, nil
+		}
+		proc.Kill()
+		proc.Wait()
+		return nil, errors.New("new process exited before ready")
+	case <-ctx.Done():
+		proc.Kill()
+		proc.Wait()
+		return nil, ctx.Err()
+	}
+}
+
+// Ready tells the parent process that started the current process by Upgrade to drain.
+// It should be called after the inherited listeners are served. It does nothing if the process isn't started by Upgrade.
+func Ready() error {
+	fd, err := strconv.Atoi(os.Getenv(envUpgradeReadyFD))
+	if err != nil {
+		return nil
+	}
+	os.Unsetenv(envUpgradeReadyFD)
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	_, err = f.Write([]byte{1})
+	return err
+}
+
+var inherited struct {
+	sync.Mutex
+	once  sync.Once
+	addrs map[string]int // network:address -> fd
+}
+
+// InheritListener returns the listener passed by the parent process with Upgrade, whose network and address
+// match the arguments, otherwise a new listener is created by CreateListener. So the same code works for both
+// the first start and the upgrades.
+func InheritListener(network, addr string) (Listener, error) {
+	inherited.Lock()
+	defer inherited.Unlock()
+	inherited.once.Do(func() {
+		inherited.addrs = make(map[string]int)
+		for i, s := range strings.Split(os.Getenv(envInheritedListeners), ",") {
+			if s != "" {
+				syscall.CloseOnExec(3 + i)
+				inherited.addrs[s] = 3 + i
+			}
+		}
+	})
+	for key, fd := range inherited.addrs {
+		i := strings.Index(key, ":")
+		if !sameListenAddr(network, addr, key[:i], key[i+1:]) {
+			continue
+		}
+		delete(inherited.addrs, key)
+		f := os.NewFile(uintptr(fd), key)
+		ln, err := net.FileListener(f)
+		// the fd has been duplicated
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		return ConvertListener(ln)
+	}
+	return CreateListener(network, addr)
+}
+
+// sameListenAddr reports whether the address to listen is the one of the inherited listener,
+// e.g. ":8888" is the same as "[::]:8888".
+func sameListenAddr(network, addr, inheritedNetwork, inheritedAddr string) bool {
+	if strings.TrimRight(network, "46") != inheritedNetwork {
+		return false
+	}
+	if addr == inheritedAddr {
+		return true
+	}
+	if inheritedNetwork != "tcp" {
+		return false
+	}
+	a, err := net.ResolveTCPAddr(network, addr)
+	if err != nil {
+		return false
+	}
+	b, err := net.ResolveTCPAddr(inheritedNetwork, inheritedAddr)
+	if err != nil {
+		return false
+	}
+	if a.Port != b.Port {
+		return false
+	}
+	return a.IP.Equal(b.IP) || ((a.IP == nil || a.IP.IsUnspecified()) && b.IP.IsUnspecified())
+}
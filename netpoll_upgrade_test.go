@@ -0,0 +1,95 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSameListenAddr(t *testing.T) {
+	MustTrue(t, sameListenAddr("tcp", ":8888", "tcp", "[::]:8888"))
+	MustTrue(t, sameListenAddr("tcp4", "127.0.0.1:8888", "tcp", "127.0.0.1:8888"))
+	MustTrue(t, sameListenAddr("unix", "/tmp/a.sock", "unix", "/tmp/a.sock"))
+	MustTrue(t, !sameListenAddr("tcp", ":8888", "tcp", "[::]:8889"))
+	MustTrue(t, !sameListenAddr("tcp", "127.0.0.1:8888", "tcp", "[::]:8888"))
+	MustTrue(t, !sameListenAddr("unix", "/tmp/a.sock", "tcp", "/tmp/a.sock"))
+}
+
+// TestUpgradeChild is run by the new process started by TestUpgrade.
+func TestUpgradeChild(t *testing.T) {
+	address := os.Getenv("NETPOLL_TEST_UPGRADE_ADDR")
+	if address == "" {
+		t.Skip("only run by TestUpgrade")
+	}
+	ln, err := InheritListener("tcp", address)
+	MustNil(t, err)
+	served := make(chan struct{})
+	loop, err := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		buf, err := connection.Reader().Next(connection.Reader().Len())
+		if err != nil {
+			return err
+		}
+		_, err = connection.Writer().WriteBinary(append([]byte("child:"), buf...))
+		MustNil(t, err)
+		connection.Writer().Flush()
+		close(served)
+		return nil
+	})
+	MustNil(t, err)
+	go loop.Serve(ln)
+	MustNil(t, Ready())
+	<-served
+	MustNil(t, loop.Shutdown(context.Background()))
+}
+
+func TestUpgrade(t *testing.T) {
+	// not started by Upgrade
+	address := getTestAddress()
+	ln, err := InheritListener("tcp", address)
+	MustNil(t, err)
+	MustNil(t, Ready())
+	os.Setenv("NETPOLL_TEST_UPGRADE_ADDR", address)
+	defer os.Unsetenv("NETPOLL_TEST_UPGRADE_ADDR")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	proc, err := upgrade(ctx, []Listener{ln}, os.Args[0], []string{"-test.run=^TestUpgradeChild$"})
+	MustNil(t, err)
+	// drain, the new connections are accepted by the new process
+	MustNil(t, ln.Close())
+
+	conn, err := DialConnection("tcp", address, time.Second)
+	MustNil(t, err)
+	_, err = conn.Write([]byte("ping"))
+	MustNil(t, err)
+	buf, err := conn.Reader().Next(len("child:ping"))
+	MustNil(t, err)
+	Equal(t, string(buf), "child:ping")
+	conn.Close()
+	state, err := proc.Wait()
+	MustNil(t, err)
+	MustTrue(t, state.Success())
+
+	// the new process exits before ready
+	ln, err = CreateListener("tcp", getTestAddress())
+	MustNil(t, err)
+	defer ln.Close()
+	_, err = upgrade(ctx, []Listener{ln}, "/bin/sh", []string{"-c", "exit 1"})
+	Assert(t, err != nil)
+}
@@ -14,34 +14,521 @@
 
 //go:build windows
 
-// The following methods would not be used, but are intended to compile on Windows.
+// There is no poller on Windows, the following methods fall back to the standard net package,
+// so that the services built on netpoll can be developed on Windows. Don't use it in production.
 package netpoll
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/netpoll/internal/runner"
 )
 
+// Initialize does nothing on Windows.
+func Initialize() {}
+
 // Configure the internal behaviors of netpoll.
+// Only BufferSize, Runner, LoggerOutput and Logger take effect on Windows.
 func Configure(config Config) (err error) {
+	if config.BufferSize > 0 {
+		defaultLinkBufferSize = config.BufferSize
+	}
+	if config.Runner != nil {
+		runner.RunTask = config.Runner
+	}
+	if config.LoggerOutput != nil {
+		logger = newStdLogger(config.LoggerOutput)
+	}
+	if config.Logger != nil {
+		logger = config.Logger
+	}
+	if config.StatsTiming {
+		atomic.StoreInt32(&statsTiming, 1)
+	}
+	if config.StatsBuffers {
+		atomic.StoreInt32(&statsBuffers, 1)
+	}
 	return nil
 }
 
-// NewDialer only support TCP and unix socket now.
-func NewDialer() Dialer {
+// SetNumLoops does nothing on Windows.
+//
+// Deprecated: use Configure instead.
+func SetNumLoops(numLoops int) error {
+	return nil
+}
+
+// SetLoadBalance does nothing on Windows.
+//
+// Deprecated: use Configure instead.
+func SetLoadBalance(lb LoadBalance) error {
+	return nil
+}
+
+// SetCustomLoadBalance does nothing on Windows.
+func SetCustomLoadBalance(lb LoadBalancer) error {
+	return nil
+}
+
+// KernelTLSSupported returns false on Windows.
+func KernelTLSSupported() bool {
+	return false
+}
+
+// MigrateConnection is unsupported on Windows.
+func MigrateConnection(conn Connection, poll Poll) error {
+	return Exception(ErrUnsupported, "MigrateConnection on windows")
+}
+
+// RegisterFD is unsupported on Windows.
+func RegisterFD(fd int, handler FDHandler) (FDWatcher, error) {
+	return nil, Exception(ErrUnsupported, "RegisterFD on windows")
+}
+
+// SetLoggerOutput sets the logger output target.
+//
+// Deprecated: use Configure instead.
+func SetLoggerOutput(w io.Writer) {
+	logger = newStdLogger(w)
+}
+
+// SetRunner set the runner function for every OnRequest/OnConnect callback
+//
+// Deprecated: use Configure and specify config.Runner instead.
+func SetRunner(f func(ctx context.Context, f func())) {
+	runner.RunTask = f
+}
+
+// DisableGopool will remove gopool(the goroutine pool used to run OnRequest).
+//
+// Deprecated: use Configure() and specify config.Runner instead.
+func DisableGopool() error {
+	runner.UseGoRunTask()
 	return nil
 }
 
 // NewEventLoop .
 func NewEventLoop(onRequest OnRequest, ops ...Option) (EventLoop, error) {
-	return nil, nil
+	opts := &options{
+		onRequest: onRequest,
+	}
+	for _, do := range ops {
+		do.f(opts)
+	}
+	return &eventLoop{
+		opts: opts,
+	}, nil
+}
+
+// eventLoop serves each connection by a goroutine.
+type eventLoop struct {
+	sync.Mutex
+	opts    *options
+	lns     []net.Listener
+	conns   sync.Map // key=*stdConnection
+	proxies sync.Map // key=net.Conn, the connections reading the PROXY header
+	connNum int32    // number of connections
+	timers  timerWheel
+}
+
+var (
+	_ ListenersServer   = &eventLoop{}
+	_ ConnectionsLister = &eventLoop{}
+	_ TimerScheduler    = &eventLoop{}
+)
+
+// Serve implements EventLoop.
+func (evl *eventLoop) Serve(ln net.Listener) error {
+	return evl.ServeListeners(ln)
+}
+
+// ServeListeners implements ListenersServer.
+func (evl *eventLoop) ServeListeners(lns ...net.Listener) error {
+	if len(lns) == 0 {
+		return errors.New("no listener to serve")
+	}
+	evl.Lock()
+	evl.lns = append(evl.lns, lns...)
+	evl.Unlock()
+
+	errs := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func(ln net.Listener) {
+			errs <- evl.accept(ln)
+		}(ln)
+	}
+	return <-errs
+}
+
+// serving reports whether ln is still served, it's removed by Shutdown.
+func (evl *eventLoop) serving(ln net.Listener) bool {
+	evl.Lock()
+	defer evl.Unlock()
+	for _, l := range evl.lns {
+		if l == ln {
+			return true
+		}
+	}
+	return false
+}
+
+func (evl *eventLoop) accept(ln net.Listener) error {
+	var delay time.Duration
+	limiter := newAcceptLimiter(evl.opts.acceptLimit)
+	for {
+		if limiter != nil {
+			if wait := limiter.reserve(time.Now()); wait > 0 {
+				// the connections pending are left in the backlog
+				time.Sleep(wait)
+				continue
+			}
+		}
+		conn, err := ln.Accept()
+		if err != nil {
+			if !evl.serving(ln) {
+				return nil
+			}
+			if evl.opts.onError != nil {
+				evl.opts.onError(ErrorInfo{Op: ErrorOpAccept, Addr: ln.Addr(), FD: sysFd(ln), Err: err})
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				// the same backoff as net/http
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		evl.serveConn(conn)
+	}
+}
+
+func (evl *eventLoop) serveConn(conn net.Conn) {
+	if evl.opts.onAccept != nil {
+		if err := evl.opts.onAccept(sysFd(conn), conn.RemoteAddr()); err != nil {
+			logger.Debug("reject conn", "remote", conn.RemoteAddr(), "err", err)
+			conn.Close()
+			return
+		}
+	}
+	if max := evl.opts.maxConns; max > 0 && atomic.LoadInt32(&evl.connNum) >= int32(max) {
+		if evl.opts.onOverload != nil {
+			evl.opts.onOverload(conn)
+		}
+		conn.Close()
+		return
+	}
+	if cfg := evl.opts.proxyProtocol; cfg != nil {
+		// tracked until it's served, so that it's closed by Shutdown as well
+		evl.proxies.Store(conn, struct{}{})
+		go func() {
+			defer evl.proxies.Delete(conn)
+			pconn, err := readProxyHeader(conn, cfg)
+			if err != nil {
+				logger.Warn("reject conn", "remote", conn.RemoteAddr(), "err", err)
+				conn.Close()
+				return
+			}
+			evl.serveStdConn(pconn)
+		}()
+		return
+	}
+	evl.serveStdConn(conn)
+}
+
+func (evl *eventLoop) serveStdConn(conn net.Conn) {
+	if evl.opts.tlsConfig != nil {
+		conn = tls.Server(conn, evl.opts.tlsConfig)
+	}
+	c := newStdConnection(conn, evl.opts)
+	evl.conns.Store(c, struct{}{})
+	atomic.AddInt32(&evl.connNum, 1)
+	shard := c.stats.shard
+	shard.accept()
+	c.AddCloseCallback(func(Connection) error {
+		evl.conns.Delete(c)
+		atomic.AddInt32(&evl.connNum, -1)
+		shard.close()
+		return nil
+	})
+	if evl.opts.onPrepare != nil {
+		c.ctx = evl.opts.onPrepare(c)
+		if c.ctx == nil {
+			c.ctx = context.Background()
+		}
+	}
+	if c.IsActive() {
+		c.serve()
+	}
+}
+
+// readProxyHeader reads the PROXY protocol header of conn, the data read after the header is kept by the returned conn.
+func readProxyHeader(conn net.Conn, cfg *proxyProtocolConfig) (net.Conn, error) {
+	if cfg.timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(cfg.timeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	buf := make([]byte, 0, 256)
+	for {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		n, err := conn.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		size, src, perr := parseProxyHeader(buf)
+		switch {
+		case perr == nil:
+			return &proxyConn{Conn: conn, buf: buf[size:], remoteAddr: src}, nil
+		case perr != errProxyIncomplete:
+			if cfg.strict {
+				return nil, perr
+			}
+			return &proxyConn{Conn: conn, buf: buf}, nil
+		case err != nil:
+			return nil, err
+		}
+	}
+}
+
+// proxyConn is the net.Conn whose PROXY header has been read.
+type proxyConn struct {
+	net.Conn
+	buf        []byte
+	remoteAddr net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if len(c.buf) > 0 {
+		n := copy(b, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// AfterFunc implements TimerScheduler.
+func (evl *eventLoop) AfterFunc(d time.Duration, fn func()) *Timer {
+	return evl.timers.afterFunc(d, 0, fn)
+}
+
+// Tick implements TimerScheduler.
+func (evl *eventLoop) Tick(d time.Duration, fn func()) *Timer {
+	if d <= 0 {
+		panic("netpoll: non-positive interval for Tick")
+	}
+	return evl.timers.afterFunc(d, d, fn)
+}
+
+// Connections implements ConnectionsLister.
+func (evl *eventLoop) Connections() []ConnInfo {
+	var infos []ConnInfo
+	evl.conns.Range(func(key, value interface{}) bool {
+		if c := key.(*stdConnection); c.IsActive() {
+			infos = append(infos, c.info())
+		}
+		return true
+	})
+	return infos
+}
+
+// Shutdown signals a shutdown a begins server closing.
+func (evl *eventLoop) Shutdown(ctx context.Context) error {
+	evl.Lock()
+	lns := evl.lns
+	evl.lns = nil
+	evl.Unlock()
+
+	if len(lns) == 0 {
+		return nil
+	}
+	for _, ln := range lns {
+		ln.Close()
+	}
+
+	notified := make(map[*stdConnection]bool)
+	for {
+		activeConn := 0
+		evl.proxies.Range(func(key, value interface{}) bool {
+			key.(net.Conn).Close()
+			return true
+		})
+		evl.conns.Range(func(key, value interface{}) bool {
+			conn := key.(*stdConnection)
+			if !notified[conn] {
+				if !conn.onShutdown(evl.opts.onShutdown) {
+					activeConn++
+					return true
+				}
+				notified[conn] = true
+			}
+			if conn.isIdle() {
+				conn.Close()
+			} else {
+				activeConn++
+			}
+			return true
+		})
+		if activeConn == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
 }
 
 // ConvertListener converts net.Listener to Listener
 func ConvertListener(l net.Listener) (nl Listener, err error) {
-	return nil, nil
+	if tmp, ok := l.(Listener); ok {
+		return tmp, nil
+	}
+	return &stdListener{Listener: l}, nil
 }
 
 // CreateListener return a new Listener.
 func CreateListener(network, addr string) (l Listener, err error) {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertListener(ln)
+}
+
+// CreateListenerWithOptions is the same as CreateListener on Windows, the options are ignored.
+func CreateListenerWithOptions(network, addr string, opts ...ListenerOption) (l Listener, err error) {
+	return CreateListener(network, addr)
+}
+
+// CreateReusePortListener is the same as CreateListener on Windows.
+func CreateReusePortListener(network, addr string) (l Listener, err error) {
+	return CreateListener(network, addr)
+}
+
+// CreateFastOpenListener is the same as CreateListener on Windows.
+func CreateFastOpenListener(network, addr string, queueLen int) (l Listener, err error) {
+	return CreateListener(network, addr)
+}
+
+type stdListener struct {
+	net.Listener
+}
+
+// Fd implements Listener.
+func (ln *stdListener) Fd() (fd int) {
+	return sysFd(ln.Listener)
+}
+
+// NewDialer only support TCP and unix socket now.
+// The DialerOptions take no effect on Windows.
+func NewDialer(opts ...DialerOption) Dialer {
+	return &dialer{}
+}
+
+// DialConnection is a default implementation of Dialer.
+func DialConnection(network, address string, timeout time.Duration) (connection Connection, err error) {
+	return NewDialer().DialConnection(network, address, timeout)
+}
+
+// DialContext is like DialConnection but uses ctx instead of a timeout, see Dialer.DialContext.
+func DialContext(ctx context.Context, network, address string) (connection Connection, err error) {
+	return NewDialer().DialContext(ctx, network, address)
+}
+
+type dialer struct{}
+
+// DialTimeout implements Dialer.
+func (d *dialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	return d.DialConnection(network, address, timeout)
+}
+
+// DialConnection implements Dialer.
+func (d *dialer) DialConnection(network, address string, timeout time.Duration) (connection Connection, err error) {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			return nil, Exception(ErrDialTimeout, err.Error())
+		}
+		return nil, err
+	}
+	return newStdConnection(conn, nil), nil
+}
+
+// DialContext implements Dialer.
+func (d *dialer) DialContext(ctx context.Context, network, address string) (connection Connection, err error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	c := newStdConnection(conn, nil)
+	c.ctx = valueContext{ctx}
+	return c, nil
+}
+
+// ListenersFromSystemd returns nil on Windows.
+func ListenersFromSystemd() ([]SystemdListener, error) {
 	return nil, nil
 }
+
+// ListenPacket is unsupported on Windows.
+func ListenPacket(network, address string) (PacketConnection, error) {
+	return nil, Exception(ErrUnsupported, "ListenPacket on windows")
+}
+
+// Upgrade is unsupported on Windows.
+func Upgrade(ctx context.Context, lns ...Listener) (*os.Process, error) {
+	return nil, Exception(ErrUnsupported, "Upgrade on windows")
+}
+
+// Ready does nothing on Windows.
+func Ready() error {
+	return nil
+}
+
+// InheritListener is the same as CreateListener on Windows.
+func InheritListener(network, addr string) (Listener, error) {
+	return CreateListener(network, addr)
+}
+
+// ListenNetlink is unsupported on Windows.
+func ListenNetlink(protocol int, groups uint32) (PacketConnection, error) {
+	return nil, Exception(ErrUnsupported, "ListenNetlink on windows")
+}
+
+// ListenRawPacket is unsupported on Windows.
+func ListenRawPacket(ifname string, protocol uint16) (PacketConnection, error) {
+	return nil, Exception(ErrUnsupported, "ListenRawPacket on windows")
+}
+
+// DialPacket is unsupported on Windows.
+func DialPacket(network, address string) (PacketConnection, error) {
+	return nil, Exception(ErrUnsupported, "DialPacket on windows")
+}
+
+// ConvertPacketConn is unsupported on Windows.
+func ConvertPacketConn(pc net.PacketConn) (PacketConnection, error) {
+	return nil, Exception(ErrUnsupported, "ConvertPacketConn on windows")
+}
@@ -15,7 +15,10 @@
 package netpoll
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"sync/atomic"
 
 	"github.com/bytedance/gopkg/lang/dirtmake"
 	"github.com/bytedance/gopkg/lang/mcache"
@@ -201,6 +204,20 @@ type ReadWriter interface {
 	Writer
 }
 
+// BinaryToReader is an optional interface of Reader, which copies the data into the caller buffers.
+// LinkBuffer and Connection implement it, see ReadBinaryTo and PeekTo.
+type BinaryToReader interface {
+	// ReadBinaryTo is the same as ReadBinary, except that it copies the next len(p) bytes into p
+	// instead of allocating a new slice, so that the callers managing their own memory avoid allocations.
+	//
+	// Return: n must be len(p) or 0.
+	ReadBinaryTo(p []byte) (n int, err error)
+
+	// PeekTo copies the next len(p) bytes into p without advancing the reader.
+	// Other behavior is the same as ReadBinaryTo.
+	PeekTo(p []byte) (n int, err error)
+}
+
 // NewReader convert io.Reader to nocopy Reader
 func NewReader(r io.Reader) Reader {
 	return newZCReader(r)
@@ -246,12 +263,183 @@ func NewIOReadWriter(rw ReadWriter) io.ReadWriter {
 	}
 }
 
+// NewRawReader returns an io.Reader which reads from r, e.g. Connection.Reader(), for the decoders expecting the
+// standard library interfaces. Read waits for any data like net.Conn and returns io.EOF once no more data can be read.
+// It also implements io.ByteReader, and io.WriterTo which writes the buffered nodes to the destination directly
+// without copying them into an intermediate buffer, so io.Copy from it is zero-copy on the reading side.
+// The data read is released by each call, so r must not be used by Next or Peek at the same time.
+func NewRawReader(r Reader) io.Reader {
+	return &rawReader{r: r}
+}
+
+// RawWriter is the io.Writer returned by NewRawWriter.
+type RawWriter interface {
+	io.Writer
+	io.StringWriter
+	io.ByteWriter
+	io.ReaderFrom
+
+	// Flush flushes the data buffered by the Writer.
+	Flush() error
+}
+
+// NewRawWriter returns a RawWriter which writes to w, e.g. Connection.Writer(), for the encoders expecting the
+// standard library interfaces. The data is copied into the buffer of w, and flushed once the buffered data reaches
+// size bytes like bufio.Writer, so the small writes of the encoders are coalesced until Flush is called.
+// A non-positive size means 4KB. ReadFrom reads into the buffer of w directly without an intermediate buffer,
+// and flushes all the data once the source returns io.EOF, so io.Copy to it needs no more Flush.
+func NewRawWriter(w Writer, size int) RawWriter {
+	if size <= 0 {
+		size = block4k
+	}
+	return &rawWriter{w: w, size: size}
+}
+
+// VecWriter is an optional interface of Writer, which references several slices without copying.
+// LinkBuffer and Connection implement it, see Writev.
+type VecWriter interface {
+	// Writev writes the slices in order like WriteBinary, but each slice is always referenced by the writer
+	// without copying regardless of its size, e.g. the header, body and trailer produced by a serializer,
+	// and all of them are sent by one writev(2) on Flush. So make sure that the slices will not be changed.
+	Writev(bs [][]byte) (n int, err error)
+}
+
+// PeekVecReader is an optional interface of Reader, which peeks the data without coalescing.
+// LinkBuffer and Connection implement it, see PeekVec.
+type PeekVecReader interface {
+	// PeekVec is the same as Peek, except that it returns the slices of the underlying buffer nodes,
+	// instead of coalescing them into a contiguous slice by copying when the data spans several nodes.
+	// It's more efficient for the parsers that can handle segmented input, especially for large frames.
+	//
+	// The slices are only valid until the next call to the Release method.
+	PeekVec(n int) (vs [][]byte, err error)
+}
+
+// UntilNReader is an optional interface of Reader, which limits the length searched for the delimiter.
+// LinkBuffer and Connection implement it, see UntilN.
+type UntilNReader interface {
+	// UntilN is the same as Until, except that it returns ErrLineTooLong without reading anything
+	// if delim is not found in the first max bytes, so that a peer never sending delim can't make
+	// the buffer grow without limit. A non-positive max means no limit.
+	UntilN(delim byte, max int) (line []byte, err error)
+}
+
+// ReadBinaryTo copies the next len(p) bytes of reader into p by BinaryToReader if reader implements it,
+// otherwise it falls back to Next and copy.
+func ReadBinaryTo(reader Reader, p []byte) (n int, err error) {
+	if r, ok := reader.(BinaryToReader); ok {
+		return r.ReadBinaryTo(p)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	buf, err := reader.Next(len(p))
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, buf), nil
+}
+
+// PeekTo copies the next len(p) bytes of reader into p without advancing it by BinaryToReader
+// if reader implements it, otherwise it falls back to Peek and copy.
+func PeekTo(reader Reader, p []byte) (n int, err error) {
+	if r, ok := reader.(BinaryToReader); ok {
+		return r.PeekTo(p)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	buf, err := reader.Peek(len(p))
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, buf), nil
+}
+
+// PeekVec peeks the next n bytes of reader by PeekVecReader if reader implements it,
+// otherwise it falls back to Peek, which returns only one slice.
+func PeekVec(reader Reader, n int) (vs [][]byte, err error) {
+	if r, ok := reader.(PeekVecReader); ok {
+		return r.PeekVec(n)
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	buf, err := reader.Peek(n)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{buf}, nil
+}
+
+// UntilN reads until the first occurrence of delim in reader by UntilNReader if reader implements it,
+// otherwise it falls back to the Peek loop, which waits for one more byte each time the buffered data has no delim.
+// ErrLineTooLong is returned without reading anything if delim is not found in the first max bytes.
+// A non-positive max means no limit, which is the same as Reader.Until.
+func UntilN(reader Reader, delim byte, max int) (line []byte, err error) {
+	if r, ok := reader.(UntilNReader); ok {
+		return r.UntilN(delim, max)
+	}
+	if max <= 0 {
+		return reader.Until(delim)
+	}
+	for n, off := 1, 0; n <= max; n++ {
+		if l := reader.Len(); l > n {
+			n = l
+		}
+		if n > max {
+			n = max
+		}
+		buf, err := reader.Peek(n)
+		if err != nil {
+			return nil, err
+		}
+		if i := bytes.IndexByte(buf[off:], delim); i >= 0 {
+			return reader.Next(off + i + 1)
+		}
+		off = n
+	}
+	return nil, Exception(ErrLineTooLong, fmt.Sprintf("max[%d]", max))
+}
+
+// Writev writes the slices to writer by VecWriter if writer implements it,
+// otherwise it falls back to WriteBinary for each slice, which may copy the small ones.
+func Writev(writer Writer, bs [][]byte) (n int, err error) {
+	if w, ok := writer.(VecWriter); ok {
+		return w.Writev(bs)
+	}
+	for _, b := range bs {
+		m, err := writer.WriteBinary(b)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ReadLine reads a line ended with "\n" by UntilN, and returns it without the trailing "\r\n" or "\n",
+// which saves the Peek loops of the text protocols such as Redis, HTTP/1 and SMTP.
+// The line is only valid until the next call to Release, and ErrLineTooLong is returned if it exceeds max.
+func ReadLine(reader Reader, max int) (line []byte, err error) {
+	if line, err = UntilN(reader, '\n', max); err != nil {
+		return line, err
+	}
+	line = line[:len(line)-1]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, nil
+}
+
 const (
 	block1k  = 1 * 1024
 	block2k  = 2 * 1024
 	block4k  = 4 * 1024
 	block8k  = 8 * 1024
+	block16k = 16 * 1024
 	block32k = 32 * 1024
+	block64k = 64 * 1024
 
 	pagesize  = block8k
 	mallocMax = block8k * block1k // mallocMax is 8MB
@@ -272,7 +460,9 @@ func malloc(size, capacity int) []byte {
 	if capacity > mallocMax {
 		return dirtmake.Bytes(size, capacity)
 	}
-	return mcache.Malloc(size, capacity)
+	buf := mcache.Malloc(size, capacity)
+	statsBuffer(cap(buf))
+	return buf
 }
 
 // free limits the cap of the buffer from mcache.
@@ -280,5 +470,23 @@ func free(buf []byte) {
 	if cap(buf) > mallocMax {
 		return
 	}
+	statsBuffer(-cap(buf))
 	mcache.Free(buf)
 }
+
+// vecLen returns the total length of the slices.
+func vecLen(bs [][]byte) (n int) {
+	for _, b := range bs {
+		n += len(b)
+	}
+	return n
+}
+
+// checkBufferLimit returns ErrWriteBufferFull if the buffered data will exceed the limit after n bytes written.
+// A non-positive limit means no limit.
+func checkBufferLimit(limit *int64, buffered, n int) error {
+	if size := atomic.LoadInt64(limit); size > 0 && int64(buffered+n) > size {
+		return Exception(ErrWriteBufferFull, fmt.Sprintf("buffered[%d] + n[%d] > limit[%d]", buffered, n, size))
+	}
+	return nil
+}
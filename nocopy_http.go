@@ -0,0 +1,209 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HTTPRequest indexes the request line and the headers of an HTTP/1.x request, see HTTPRequest.Parse.
+// All the fields are the slices of the data peeked from the Reader, so nothing is copied or allocated
+// while parsing, except the index of the headers which is reused by the next Parse.
+type HTTPRequest struct {
+	head    []byte
+	method  []byte
+	uri     []byte
+	proto   []byte
+	headers []HTTPHeader
+}
+
+// HTTPHeader is a header of HTTPRequest, the value is trimmed of the surrounding spaces.
+type HTTPHeader struct {
+	Name  []byte
+	Value []byte
+}
+
+var crlf = []byte("\r\n")
+
+// Parse peeks the request line and the headers ending with an empty line from reader, and indexes them.
+// It blocks like Reader.Peek until the empty line is received, and returns ErrLineTooLong if it's not found
+// in the first max bytes, a non-positive max means no limit. ErrBadHTTPRequest is returned if the request is malformed.
+//
+// Nothing is read from reader, so the request can be routed before the bytes are handed off, e.g. by
+// reader.Slice(r.Len() + contentLength). The slices are only valid until the next call to reader.Release.
+func (r *HTTPRequest) Parse(reader Reader, max int) error {
+	*r = HTTPRequest{headers: r.headers[:0]}
+	head, err := peekHead(reader, max)
+	if err != nil {
+		return err
+	}
+	r.head = head
+	line, rest, _ := bytes.Cut(head, crlf)
+	if err = r.parseRequestLine(line); err != nil {
+		return err
+	}
+	for {
+		line, rest, _ = bytes.Cut(rest, crlf)
+		if len(line) == 0 {
+			return nil
+		}
+		colon := bytes.IndexByte(line, ':')
+		if colon <= 0 || !isHTTPToken(line[:colon]) {
+			// the obsolete line folding is rejected as well
+			return Exception(ErrBadHTTPRequest, fmt.Sprintf("header[%q]", line))
+		}
+		r.headers = append(r.headers, HTTPHeader{
+			Name:  line[:colon],
+			Value: trimHTTPSpace(line[colon+1:]),
+		})
+	}
+}
+
+// peekHead peeks more data until "\r\n\r\n" is found, without scanning the same bytes twice.
+func peekHead(reader Reader, max int) (head []byte, err error) {
+	var scanned int
+	for {
+		n := reader.Len()
+		if n <= scanned {
+			n = scanned + 1
+		}
+		if max > 0 && n > max {
+			n = max
+		}
+		if head, err = reader.Peek(n); err != nil {
+			return nil, err
+		}
+		from := scanned - 3
+		if from < 0 {
+			from = 0
+		}
+		if i := bytes.Index(head[from:], []byte("\r\n\r\n")); i >= 0 {
+			return head[:from+i+4], nil
+		}
+		if max > 0 && n >= max {
+			return nil, Exception(ErrLineTooLong, fmt.Sprintf("max[%d]", max))
+		}
+		scanned = n
+	}
+}
+
+func (r *HTTPRequest) parseRequestLine(line []byte) error {
+	method, rest, ok1 := bytes.Cut(line, []byte(" "))
+	uri, proto, ok2 := bytes.Cut(rest, []byte(" "))
+	if !ok1 || !ok2 || !isHTTPToken(method) || len(uri) == 0 ||
+		(string(proto) != "HTTP/1.1" && string(proto) != "HTTP/1.0") {
+		return Exception(ErrBadHTTPRequest, fmt.Sprintf("request line[%q]", line))
+	}
+	r.method, r.uri, r.proto = method, uri, proto
+	return nil
+}
+
+// Len returns the size of the request line and the headers, including the empty line.
+func (r *HTTPRequest) Len() int {
+	return len(r.head)
+}
+
+// Method returns the method of the request, e.g. "GET".
+func (r *HTTPRequest) Method() []byte {
+	return r.method
+}
+
+// URI returns the request target as is, e.g. "/index.html?a=b".
+func (r *HTTPRequest) URI() []byte {
+	return r.uri
+}
+
+// Proto returns "HTTP/1.1" or "HTTP/1.0".
+func (r *HTTPRequest) Proto() []byte {
+	return r.proto
+}
+
+// Headers returns all the headers in order.
+func (r *HTTPRequest) Headers() []HTTPHeader {
+	return r.headers
+}
+
+// Header returns the value of the first header named name case-insensitively, or nil if there's none.
+func (r *HTTPRequest) Header(name string) []byte {
+	for i := range r.headers {
+		if equalFoldASCII(r.headers[i].Name, name) {
+			return r.headers[i].Value
+		}
+	}
+	return nil
+}
+
+// ContentLength returns the value of Content-Length, or -1 if there's none.
+// The body of the chunked requests is up to the caller.
+func (r *HTTPRequest) ContentLength() (int, error) {
+	v := r.Header("Content-Length")
+	if v == nil {
+		return -1, nil
+	}
+	n, err := strconv.ParseUint(string(v), 10, 31)
+	if err != nil {
+		return -1, Exception(ErrBadHTTPRequest, fmt.Sprintf("content length[%q]", v))
+	}
+	return int(n), nil
+}
+
+// isHTTPToken reports whether b is a token of RFC 9110, which the methods and the header names must be.
+func isHTTPToken(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// trimHTTPSpace trims the spaces and tabs, and keeps the empty value non-nil unlike bytes.Trim.
+func trimHTTPSpace(b []byte) []byte {
+	for len(b) > 0 && (b[0] == ' ' || b[0] == '\t') {
+		b = b[1:]
+	}
+	for n := len(b); n > 0 && (b[n-1] == ' ' || b[n-1] == '\t'); n-- {
+		b = b[:n-1]
+	}
+	return b
+}
+
+func equalFoldASCII(b []byte, s string) bool {
+	if len(b) != len(s) {
+		return false
+	}
+	for i := range b {
+		x, y := b[i], s[i]
+		if 'A' <= x && x <= 'Z' {
+			x += 'a' - 'A'
+		}
+		if 'A' <= y && y <= 'Z' {
+			y += 'a' - 'A'
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHTTPRequestParse(t *testing.T) {
+	const head = "POST /api/v1?x=1 HTTP/1.1\r\nHost: example.com\r\ncontent-length:  5 \r\nX-Empty:\r\n\r\n"
+	buf := NewLinkBuffer()
+	buf.WriteString(head + "hello")
+	buf.Flush()
+
+	var req HTTPRequest
+	MustNil(t, req.Parse(buf, 0))
+	Equal(t, req.Len(), len(head))
+	Equal(t, string(req.Method()), "POST")
+	Equal(t, string(req.URI()), "/api/v1?x=1")
+	Equal(t, string(req.Proto()), "HTTP/1.1")
+	Equal(t, len(req.Headers()), 3)
+	Equal(t, string(req.Header("HOST")), "example.com")
+	Equal(t, string(req.Header("Content-Length")), "5")
+	MustTrue(t, req.Header("X-Empty") != nil && len(req.Header("X-Empty")) == 0)
+	MustTrue(t, req.Header("Cookie") == nil)
+	n, err := req.ContentLength()
+	MustNil(t, err)
+	Equal(t, n, 5)
+	// nothing is read, so the whole request can be sliced
+	Equal(t, buf.Len(), len(head)+5)
+	r, err := buf.Slice(req.Len() + n)
+	MustNil(t, err)
+	Equal(t, r.Len(), len(head)+5)
+
+	// parsing again reuses the index without allocation
+	buf.WriteString(head)
+	buf.Flush()
+	allocs := testing.AllocsPerRun(100, func() {
+		MustNil(t, req.Parse(buf, 0))
+	})
+	Equal(t, allocs, float64(0))
+
+	for _, bad := range []string{
+		"GET /\r\n\r\n",
+		"GET / HTTP/2.0\r\n\r\n",
+		"G(T / HTTP/1.1\r\n\r\n",
+		"GET  HTTP/1.1\r\n\r\n",
+		"GET / HTTP/1.1\r\nno colon\r\n\r\n",
+		"GET / HTTP/1.1\r\nBad Name: x\r\n\r\n",
+		"GET / HTTP/1.1\r\nA: b\r\n folded\r\n\r\n",
+	} {
+		buf = NewLinkBuffer()
+		buf.WriteString(bad)
+		buf.Flush()
+		err = req.Parse(buf, 0)
+		Assert(t, errors.Is(err, ErrBadHTTPRequest), bad, err)
+	}
+
+	buf = NewLinkBuffer()
+	buf.WriteString("GET / HTTP/1.1\r\nContent-Length: -1\r\n\r\n")
+	buf.Flush()
+	MustNil(t, req.Parse(buf, 0))
+	_, err = req.ContentLength()
+	MustTrue(t, errors.Is(err, ErrBadHTTPRequest))
+
+	buf = NewLinkBuffer()
+	buf.WriteString("GET / HTTP/1.1\r\nCookie: 0123456789\r\n\r\n")
+	buf.Flush()
+	err = req.Parse(buf, 32)
+	MustTrue(t, errors.Is(err, ErrLineTooLong))
+	MustNil(t, req.Parse(buf, 64))
+}
+
+func TestHTTPRequestParseConnection(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, nil))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+	defer rconn.Close()
+	defer wconn.Close()
+
+	// the head arrives in pieces, split in the middle of the terminator
+	parsed := make(chan error, 1)
+	var req HTTPRequest
+	go func() {
+		parsed <- req.Parse(rconn.Reader(), 0)
+	}()
+	for _, piece := range []string{"GET /chat HT", "TP/1.1\r\nUpgrade: websocket\r", "\n\r", "\n"} {
+		_, err := wconn.Write([]byte(piece))
+		MustNil(t, err)
+		time.Sleep(10 * time.Millisecond)
+	}
+	MustNil(t, <-parsed)
+	Equal(t, string(req.URI()), "/chat")
+	Equal(t, string(req.Header("upgrade")), "websocket")
+	MustNil(t, rconn.Reader().Skip(req.Len()))
+	Equal(t, rconn.Reader().Len(), 0)
+}
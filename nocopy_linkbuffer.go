@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math/bits"
 	"sync"
 	"sync/atomic"
 	"unsafe"
@@ -32,11 +33,24 @@ const BinaryInplaceThreshold = block4k
 // LinkBufferCap that can be modified marks the minimum value of each node of LinkBuffer.
 var LinkBufferCap = block4k
 
+// The size of the nodes allocated by Malloc is rounded up to a power of 2 between nodeSizeMin and nodeSizeMax,
+// so that the buffers of a few fixed sizes are recycled by the buffer pool instead of the buffers of arbitrary sizes,
+// while less than half of a node is wasted. The sizes out of the range are allocated as requested.
+const (
+	nodeSizeMin = block1k
+	nodeSizeMax = block64k
+)
+
 var untilErr = errors.New("link buffer read slice cannot find delim")
 
 var (
 	_ Reader = &LinkBuffer{}
 	_ Writer = &LinkBuffer{}
+
+	_ BinaryToReader = &LinkBuffer{}
+	_ UntilNReader   = &LinkBuffer{}
+	_ PeekVecReader  = &LinkBuffer{}
+	_ VecWriter      = &LinkBuffer{}
 )
 
 // NewLinkBuffer size defines the initial capacity, but there is no readable data.
@@ -54,6 +68,7 @@ func NewLinkBuffer(size ...int) *LinkBuffer {
 // UnsafeLinkBuffer implements ReadWriter.
 type UnsafeLinkBuffer struct {
 	length     int64
+	blockSize  int64 // the minimum size of the nodes allocated, LinkBufferCap is used if it's 0
 	mallocSize int
 
 	head  *linkBufferNode // release head
@@ -317,17 +332,77 @@ func (b *UnsafeLinkBuffer) ReadBinary(n int) (p []byte, err error) {
 	return b.readBinary(n), nil
 }
 
+// PeekVec implements PeekVecReader.
+func (b *UnsafeLinkBuffer) PeekVec(n int) (vs [][]byte, err error) {
+	if n <= 0 {
+		return
+	}
+	// check whether enough or not.
+	if b.Len() < n {
+		return vs, fmt.Errorf("link buffer peek vec[%d] not enough", n)
+	}
+	for node := b.read; n > 0; node = node.next {
+		l := node.Len()
+		if l == 0 {
+			continue
+		}
+		if l > n {
+			l = n
+		}
+		node.setFlag(flagReadExposed)
+		vs = append(vs, node.buf[node.off:node.off+l:node.off+l])
+		n -= l
+	}
+	return vs, nil
+}
+
+// ReadBinaryTo implements BinaryToReader.
+func (b *UnsafeLinkBuffer) ReadBinaryTo(p []byte) (n int, err error) {
+	n = len(p)
+	if n == 0 {
+		return 0, nil
+	}
+	// check whether enough or not.
+	if b.Len() < n {
+		return 0, fmt.Errorf("link buffer read binary[%d] not enough", n)
+	}
+	b.readBinaryTo(p)
+	return n, nil
+}
+
+// PeekTo implements BinaryToReader.
+func (b *UnsafeLinkBuffer) PeekTo(p []byte) (n int, err error) {
+	n = len(p)
+	if n == 0 {
+		return 0, nil
+	}
+	// check whether enough or not.
+	if b.Len() < n {
+		return 0, fmt.Errorf("link buffer peek[%d] not enough", n)
+	}
+	for node, pIdx := b.read, 0; pIdx < n; node = node.next {
+		pIdx += copy(p[pIdx:], node.buf[node.off:node.off+node.Len()])
+	}
+	return n, nil
+}
+
 // readBinary cannot use mcache, because the memory allocated by readBinary will not be recycled.
 func (b *UnsafeLinkBuffer) readBinary(n int) (p []byte) {
+	p = dirtmake.Bytes(n, n)
+	b.readBinaryTo(p)
+	return p
+}
+
+// readBinaryTo copies the next len(p) bytes into p, the caller must make sure the data is enough.
+func (b *UnsafeLinkBuffer) readBinaryTo(p []byte) {
+	n := len(p)
 	b.recalLen(-n) // re-cal length
 
 	// single node
 	if b.isSingleNode(n) {
-		p = dirtmake.Bytes(n, n)
 		copy(p, b.read.Next(n))
-		return p
+		return
 	}
-	p = dirtmake.Bytes(n, n)
 	// multiple nodes
 	var pIdx int
 	var l int
@@ -342,7 +417,6 @@ func (b *UnsafeLinkBuffer) readBinary(n int) (p []byte) {
 		b.read = b.read.next
 	}
 	_ = pIdx
-	return p
 }
 
 // ReadByte implements Reader.
@@ -362,7 +436,15 @@ func (b *UnsafeLinkBuffer) ReadByte() (p byte, err error) {
 
 // Until returns a slice ends with the delim in the buffer.
 func (b *UnsafeLinkBuffer) Until(delim byte) (line []byte, err error) {
+	return b.UntilN(delim, 0)
+}
+
+// UntilN implements UntilNReader.
+func (b *UnsafeLinkBuffer) UntilN(delim byte, max int) (line []byte, err error) {
 	n := b.indexByte(delim, 0)
+	if max > 0 && (n >= max || n < 0 && b.Len() >= max) {
+		return nil, Exception(ErrLineTooLong, fmt.Sprintf("max[%d]", max))
+	}
 	if n < 0 {
 		return nil, untilErr
 	}
@@ -424,6 +506,22 @@ func (b *UnsafeLinkBuffer) Slice(n int) (r Reader, err error) {
 	return p, b.Release()
 }
 
+// sliceNode is like Slice, but the n bytes must be held by a single node, and the returned LinkBuffer keeps
+// the referred node as its flush node, so that it can be read by Bytes and written like the others.
+func (b *UnsafeLinkBuffer) sliceNode(n int) (p *LinkBuffer) {
+	if n <= 0 || !b.isSingleNode(n) {
+		return NewLinkBuffer(0)
+	}
+	b.recalLen(-n)
+	b.read.setFlag(flagReadExposed)
+	node := b.read.Refer(n)
+	p = new(LinkBuffer)
+	p.length = int64(n)
+	p.head, p.read, p.flush, p.write = node, node, node, node
+	b.Release()
+	return p
+}
+
 // ------------------------------------------ implement zero-copy writer ------------------------------------------
 
 // Malloc pre-allocates memory, which is not readable, and becomes readable data after submission(e.g. Flush).
@@ -568,6 +666,22 @@ func (b *UnsafeLinkBuffer) WriteBinary(p []byte) (n int, err error) {
 	return copy(buf, p), nil
 }
 
+// Writev implements VecWriter.
+func (b *UnsafeLinkBuffer) Writev(bs [][]byte) (n int, err error) {
+	for _, p := range bs {
+		if len(p) == 0 {
+			continue
+		}
+		// expand buffer directly with nocopy
+		b.write.next = newLinkBufferNode(0)
+		b.write = b.write.next
+		b.write.buf, b.write.malloc = p[:0], len(p)
+		n += len(p)
+	}
+	b.mallocSize += n
+	return n, nil
+}
+
 // WriteDirect cannot be mixed with WriteString or WriteBinary functions.
 func (b *UnsafeLinkBuffer) WriteDirect(extra []byte, remainLen int) error {
 	n := len(extra)
@@ -703,8 +817,8 @@ func (b *UnsafeLinkBuffer) book(bookSize, maxSize int) (p []byte) {
 	l := cap(b.write.buf) - b.write.malloc
 	// grow linkBuffer
 	if l == 0 {
-		l = maxSize
-		b.write.next = newLinkBufferNode(maxSize)
+		l = b.nodeSize(maxSize)
+		b.write.next = newLinkBufferNode(l)
 		b.write = b.write.next
 	}
 	if l > bookSize {
@@ -713,6 +827,13 @@ func (b *UnsafeLinkBuffer) book(bookSize, maxSize int) (p []byte) {
 	return b.write.Malloc(l)
 }
 
+// bookFull is like book, but it always returns bookSize bytes held by a single node,
+// which is used to receive the datagrams that cannot be truncated.
+func (b *UnsafeLinkBuffer) bookFull(bookSize int) (p []byte) {
+	b.growth(bookSize)
+	return b.write.Malloc(bookSize)
+}
+
 // bookAck will ack the first n malloc bytes and discard the rest.
 //
 // length: The size of data in inputBuffer. It is used to calculate the maxSize
@@ -800,7 +921,7 @@ func (b *UnsafeLinkBuffer) growth(n int) {
 	// the memory of readonly node if not malloc by us so should skip them
 	for b.write.getFlag(flagUnmanaged) || cap(b.write.buf)-b.write.malloc < n {
 		if b.write.next == nil {
-			b.write.next = newLinkBufferNode(n)
+			b.write.next = newLinkBufferNode(b.growthSize(n))
 			b.write = b.write.next
 			return
 		}
@@ -808,6 +929,38 @@ func (b *UnsafeLinkBuffer) growth(n int) {
 	}
 }
 
+// setBlockSize sets the minimum size of the nodes allocated later.
+func (b *UnsafeLinkBuffer) setBlockSize(size int) {
+	atomic.StoreInt64(&b.blockSize, int64(size))
+}
+
+// nodeSize returns the size of a new node which can hold n bytes.
+func (b *UnsafeLinkBuffer) nodeSize(n int) int {
+	if size := int(atomic.LoadInt64(&b.blockSize)); n < size {
+		return size
+	}
+	return n
+}
+
+// growthSize returns the size of a new node allocated by growth, which can hold n bytes.
+// The size is rounded up by sizeClass unless the block size is set.
+func (b *UnsafeLinkBuffer) growthSize(n int) int {
+	if atomic.LoadInt64(&b.blockSize) > 0 {
+		return b.nodeSize(n)
+	}
+	return sizeClass(n)
+}
+
+// sizeClass returns the smallest power of 2 which can hold n bytes.
+// It returns n itself if n is not larger than nodeSizeMin or larger than nodeSizeMax,
+// the former is aligned by LinkBufferCap and mcache.
+func sizeClass(n int) int {
+	if n <= nodeSizeMin || n > nodeSizeMax {
+		return n
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
 // isSingleNode determines whether reading needs to cross nodes.
 // isSingleNode will move b.read to latest non-empty node if there is a zero-size node
 // Must require b.Len() > 0
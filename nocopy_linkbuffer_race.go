@@ -52,6 +52,13 @@ func (b *SafeLinkBuffer) Peek(n int) (p []byte, err error) {
 	return b.UnsafeLinkBuffer.Peek(n)
 }
 
+// PeekVec implements PeekVecReader.
+func (b *SafeLinkBuffer) PeekVec(n int) (vs [][]byte, err error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.UnsafeLinkBuffer.PeekVec(n)
+}
+
 // Skip implements Reader.
 func (b *SafeLinkBuffer) Skip(n int) (err error) {
 	b.Lock()
@@ -66,6 +73,13 @@ func (b *SafeLinkBuffer) Until(delim byte) (line []byte, err error) {
 	return b.UnsafeLinkBuffer.Until(delim)
 }
 
+// UntilN implements UntilNReader.
+func (b *SafeLinkBuffer) UntilN(delim byte, max int) (line []byte, err error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.UnsafeLinkBuffer.UntilN(delim, max)
+}
+
 // Release implements Reader.
 func (b *SafeLinkBuffer) Release() (err error) {
 	b.Lock()
@@ -87,6 +101,20 @@ func (b *SafeLinkBuffer) ReadBinary(n int) (p []byte, err error) {
 	return b.UnsafeLinkBuffer.ReadBinary(n)
 }
 
+// ReadBinaryTo implements BinaryToReader.
+func (b *SafeLinkBuffer) ReadBinaryTo(p []byte) (n int, err error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.UnsafeLinkBuffer.ReadBinaryTo(p)
+}
+
+// PeekTo implements BinaryToReader.
+func (b *SafeLinkBuffer) PeekTo(p []byte) (n int, err error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.UnsafeLinkBuffer.PeekTo(p)
+}
+
 // ReadByte implements Reader.
 func (b *SafeLinkBuffer) ReadByte() (p byte, err error) {
 	b.Lock()
@@ -159,6 +187,13 @@ func (b *SafeLinkBuffer) WriteBinary(p []byte) (n int, err error) {
 	return b.UnsafeLinkBuffer.WriteBinary(p)
 }
 
+// Writev implements VecWriter.
+func (b *SafeLinkBuffer) Writev(bs [][]byte) (n int, err error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.UnsafeLinkBuffer.Writev(bs)
+}
+
 // WriteDirect cannot be mixed with WriteString or WriteBinary functions.
 func (b *SafeLinkBuffer) WriteDirect(p []byte, remainLen int) error {
 	b.Lock()
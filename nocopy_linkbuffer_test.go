@@ -413,6 +413,61 @@ func TestLinkBufferResetTail(t *testing.T) {
 	Equal(t, got, except)
 }
 
+func TestLinkBufferBlockSize(t *testing.T) {
+	buf := NewLinkBuffer()
+	buf.setBlockSize(block32k)
+	p, err := buf.Malloc(10)
+	MustNil(t, err)
+	Equal(t, len(p), 10)
+	MustTrue(t, cap(buf.write.buf) >= block32k)
+	// small mallocs share the same block
+	node := buf.write
+	_, err = buf.Malloc(block8k)
+	MustNil(t, err)
+	MustTrue(t, buf.write == node)
+	// large malloc is not limited by the block size
+	_, err = buf.Malloc(block32k * 2)
+	MustNil(t, err)
+	MustTrue(t, buf.write != node)
+	MustTrue(t, cap(buf.write.buf) >= block32k*2)
+	MustNil(t, buf.Flush())
+
+	// restore the default size
+	buf.setBlockSize(0)
+	Equal(t, buf.nodeSize(10), 10)
+	MustNil(t, buf.Release())
+}
+
+func TestLinkBufferSizeClass(t *testing.T) {
+	Equal(t, sizeClass(10), 10)
+	Equal(t, sizeClass(block1k), block1k)
+	Equal(t, sizeClass(block1k+1), block2k)
+	Equal(t, sizeClass(block8k), block8k)
+	Equal(t, sizeClass(block8k+1), block16k)
+	Equal(t, sizeClass(block32k+1), block64k)
+	Equal(t, sizeClass(block64k), block64k)
+	Equal(t, sizeClass(block64k+1), block64k+1)
+
+	LinkBufferCap = block1k
+	buf := NewLinkBuffer()
+	_, err := buf.Malloc(block8k + 1)
+	MustNil(t, err)
+	Equal(t, cap(buf.write.buf), block16k)
+	// the rest of the class is used by the following mallocs
+	node := buf.write
+	_, err = buf.Malloc(block4k)
+	MustNil(t, err)
+	MustTrue(t, buf.write == node)
+	// the block size takes precedence over the size classes
+	buf.setBlockSize(block8k)
+	_, err = buf.Malloc(block8k)
+	MustNil(t, err)
+	MustTrue(t, buf.write != node)
+	Equal(t, cap(buf.write.buf), block8k)
+	MustNil(t, buf.Flush())
+	MustNil(t, buf.Release())
+}
+
 func TestLinkBufferWriteBuffer(t *testing.T) {
 	buf1 := NewLinkBuffer()
 	buf2 := NewLinkBuffer()
@@ -501,6 +556,98 @@ func TestLinkBufferWriteBinary(t *testing.T) {
 	Equal(t, b[9], byte(0))
 }
 
+func TestLinkBufferReadBinaryTo(t *testing.T) {
+	// clean & new
+	LinkBufferCap = 8
+
+	buf := NewLinkBuffer()
+	for _, s := range []string{"abcde", "fghij", "klmno"} {
+		buf.WriteBinary([]byte(s))
+		buf.Flush()
+	}
+	p := make([]byte, 12)
+	n, err := buf.PeekTo(p)
+	MustNil(t, err)
+	Equal(t, n, 12)
+	Equal(t, string(p), "abcdefghijkl")
+	Equal(t, buf.Len(), 15)
+
+	n, err = buf.ReadBinaryTo(p[:3])
+	MustNil(t, err)
+	Equal(t, string(p[:n]), "abc")
+	n, err = buf.ReadBinaryTo(p[:9])
+	MustNil(t, err)
+	Equal(t, string(p[:n]), "defghijkl")
+	Equal(t, buf.Len(), 3)
+	n, err = buf.ReadBinaryTo(p[:4])
+	MustTrue(t, err != nil && n == 0)
+	n, err = buf.PeekTo(p[:4])
+	MustTrue(t, err != nil && n == 0)
+
+	// no allocation for the caller buffer
+	buf.Skip(3)
+	buf.Release()
+	data := []byte("0123456789")
+	allocs := testing.AllocsPerRun(100, func() {
+		buf.WriteBinary(data)
+		buf.Flush()
+		buf.ReadBinaryTo(p[:len(data)])
+		buf.Release()
+	})
+	Equal(t, string(p[:len(data)]), string(data))
+	Equal(t, allocs, float64(0))
+}
+
+func TestLinkBufferPeekVec(t *testing.T) {
+	// clean & new
+	LinkBufferCap = 8
+
+	buf := NewLinkBuffer()
+	for _, s := range []string{"abcde", "fghij", "klmno"} {
+		buf.WriteBinary([]byte(s))
+		buf.Flush()
+	}
+	Equal(t, buf.Len(), 15)
+	vs, err := buf.PeekVec(12)
+	MustNil(t, err)
+	MustTrue(t, len(vs) > 1)
+	Equal(t, string(bytes.Join(vs, nil)), "abcdefghijkl")
+	Equal(t, buf.Len(), 15)
+
+	// the slices are not copied
+	p, err := buf.Next(1)
+	MustNil(t, err)
+	Equal(t, &vs[0][0], &p[0])
+
+	vs, err = buf.PeekVec(2)
+	MustNil(t, err)
+	Equal(t, string(bytes.Join(vs, nil)), "bc")
+	_, err = buf.PeekVec(15)
+	MustTrue(t, err != nil)
+	vs, err = buf.PeekVec(0)
+	MustTrue(t, vs == nil && err == nil)
+}
+
+func TestLinkBufferWritev(t *testing.T) {
+	// clean & new
+	LinkBufferCap = 8
+
+	buf := NewLinkBuffer()
+	buf.WriteString("a")
+	header, body := []byte("hd"), []byte("body")
+	n, err := buf.Writev([][]byte{header, nil, body, []byte("!")})
+	MustNil(t, err)
+	Equal(t, n, 7)
+	Equal(t, buf.MallocLen(), 8)
+	// the slices are referenced without copying
+	body[0] = 'B'
+	buf.WriteString("z")
+	MustNil(t, buf.Flush())
+	p, err := buf.Next(buf.Len())
+	MustNil(t, err)
+	Equal(t, string(p), "ahdBody!z")
+}
+
 func TestLinkBufferWriteDirect(t *testing.T) {
 	// clean & new
 	LinkBufferCap = 32
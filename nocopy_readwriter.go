@@ -15,8 +15,10 @@
 package netpoll
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"sync/atomic"
 )
 
 const maxReadCycle = 16
@@ -28,7 +30,13 @@ func newZCReader(r io.Reader) *zcReader {
 	}
 }
 
-var _ Reader = &zcReader{}
+var (
+	_ Reader = &zcReader{}
+
+	_ BinaryToReader = &zcReader{}
+	_ UntilNReader   = &zcReader{}
+	_ PeekVecReader  = &zcReader{}
+)
 
 // zcReader implements Reader.
 type zcReader struct {
@@ -52,6 +60,14 @@ func (r *zcReader) Peek(n int) (buf []byte, err error) {
 	return r.buf.Peek(n)
 }
 
+// PeekVec implements PeekVecReader.
+func (r *zcReader) PeekVec(n int) (vs [][]byte, err error) {
+	if err = r.waitRead(n); err != nil {
+		return vs, err
+	}
+	return r.buf.PeekVec(n)
+}
+
 // Skip implements Reader.
 func (r *zcReader) Skip(n int) (err error) {
 	if err = r.waitRead(n); err != nil {
@@ -94,6 +110,22 @@ func (r *zcReader) ReadBinary(n int) (p []byte, err error) {
 	return r.buf.ReadBinary(n)
 }
 
+// ReadBinaryTo implements BinaryToReader.
+func (r *zcReader) ReadBinaryTo(p []byte) (n int, err error) {
+	if err = r.waitRead(len(p)); err != nil {
+		return 0, err
+	}
+	return r.buf.ReadBinaryTo(p)
+}
+
+// PeekTo implements BinaryToReader.
+func (r *zcReader) PeekTo(p []byte) (n int, err error) {
+	if err = r.waitRead(len(p)); err != nil {
+		return 0, err
+	}
+	return r.buf.PeekTo(p)
+}
+
 // ReadByte implements Reader.
 func (r *zcReader) ReadByte() (b byte, err error) {
 	if err = r.waitRead(1); err != nil {
@@ -103,7 +135,28 @@ func (r *zcReader) ReadByte() (b byte, err error) {
 }
 
 func (r *zcReader) Until(delim byte) (line []byte, err error) {
-	return r.buf.Until(delim)
+	return r.UntilN(delim, 0)
+}
+
+// UntilN implements UntilNReader.
+func (r *zcReader) UntilN(delim byte, max int) (line []byte, err error) {
+	var n int
+	for {
+		if i := r.buf.indexByte(delim, n); i >= 0 {
+			if max > 0 && i >= max {
+				return nil, Exception(ErrLineTooLong, fmt.Sprintf("max[%d]", max))
+			}
+			return r.buf.Next(i + 1)
+		}
+		if n = r.buf.Len(); max > 0 && n >= max {
+			return nil, Exception(ErrLineTooLong, fmt.Sprintf("max[%d]", max))
+		}
+		if err = r.waitRead(n + 1); err != nil {
+			// return all the data in the buffer
+			line, _ = r.buf.Next(r.buf.Len())
+			return line, err
+		}
+	}
 }
 
 func (r *zcReader) waitRead(n int) (err error) {
@@ -151,16 +204,23 @@ func newZCWriter(w io.Writer) *zcWriter {
 	}
 }
 
-var _ Writer = &zcWriter{}
+var (
+	_ Writer    = &zcWriter{}
+	_ VecWriter = &zcWriter{}
+)
 
 // zcWriter implements Writer.
 type zcWriter struct {
-	w   io.Writer
-	buf *LinkBuffer
+	w       io.Writer
+	buf     *LinkBuffer
+	maxSize int64 // the limit of the buffered data, 0 means no limit
 }
 
 // Malloc implements Writer.
 func (w *zcWriter) Malloc(n int) (buf []byte, err error) {
+	if err = w.checkSize(n); err != nil {
+		return nil, err
+	}
 	return w.buf.Malloc(n)
 }
 
@@ -171,6 +231,9 @@ func (w *zcWriter) MallocLen() (length int) {
 
 // Flush implements Writer.
 func (w *zcWriter) Flush() (err error) {
+	if err = w.checkSize(0); err != nil {
+		return err
+	}
 	w.buf.Flush()
 	n, err := w.w.Write(w.buf.Bytes())
 	if n > 0 {
@@ -187,29 +250,65 @@ func (w *zcWriter) MallocAck(n int) (err error) {
 
 // Append implements Writer.
 func (w *zcWriter) Append(w2 Writer) (err error) {
+	if err = w.checkSize(w2.MallocLen()); err != nil {
+		return err
+	}
 	return w.buf.Append(w2)
 }
 
 // WriteString implements Writer.
 func (w *zcWriter) WriteString(s string) (n int, err error) {
+	if err = w.checkSize(len(s)); err != nil {
+		return 0, err
+	}
 	return w.buf.WriteString(s)
 }
 
 // WriteBinary implements Writer.
 func (w *zcWriter) WriteBinary(b []byte) (n int, err error) {
+	if err = w.checkSize(len(b)); err != nil {
+		return 0, err
+	}
 	return w.buf.WriteBinary(b)
 }
 
+// Writev implements VecWriter.
+func (w *zcWriter) Writev(bs [][]byte) (n int, err error) {
+	if err = w.checkSize(vecLen(bs)); err != nil {
+		return 0, err
+	}
+	return w.buf.Writev(bs)
+}
+
 // WriteDirect implements Writer.
 func (w *zcWriter) WriteDirect(p []byte, remainCap int) error {
+	if err := w.checkSize(len(p)); err != nil {
+		return err
+	}
 	return w.buf.WriteDirect(p, remainCap)
 }
 
 // WriteByte implements Writer.
 func (w *zcWriter) WriteByte(b byte) (err error) {
+	if err = w.checkSize(1); err != nil {
+		return err
+	}
 	return w.buf.WriteByte(b)
 }
 
+// setMaxSize sets the limit of the buffered data, see BufferTuner.SetMaxOutputBuffer.
+func (w *zcWriter) setMaxSize(size int) {
+	if size < 0 {
+		size = 0
+	}
+	atomic.StoreInt64(&w.maxSize, int64(size))
+}
+
+// checkSize returns ErrWriteBufferFull if the buffered data will exceed the limit after n bytes written.
+func (w *zcWriter) checkSize(n int) error {
+	return checkBufferLimit(&w.maxSize, w.buf.Len()+w.buf.MallocLen(), n)
+}
+
 // zcWriter implements ReadWriter.
 type zcReadWriter struct {
 	*zcReader
@@ -293,3 +392,170 @@ type ioReadWriter struct {
 	io.Reader
 	io.Writer
 }
+
+var (
+	_ io.WriterTo   = &rawReader{}
+	_ io.ByteReader = &rawReader{}
+)
+
+// rawReader implements io.Reader, io.ByteReader and io.WriterTo by Reader, see NewRawReader.
+type rawReader struct {
+	r Reader
+}
+
+// Read implements io.Reader.
+func (r *rawReader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err = r.wait(); err != nil {
+		return 0, err
+	}
+	if n = r.r.Len(); n > len(p) {
+		n = len(p)
+	}
+	if n, err = ReadBinaryTo(r.r, p[:n]); err != nil {
+		return n, err
+	}
+	return n, r.r.Release()
+}
+
+// ReadByte implements io.ByteReader.
+func (r *rawReader) ReadByte() (b byte, err error) {
+	if err = r.wait(); err != nil {
+		return 0, err
+	}
+	if b, err = r.r.ReadByte(); err != nil {
+		return 0, err
+	}
+	return b, r.r.Release()
+}
+
+// WriteTo implements io.WriterTo, the buffered nodes are written to w directly.
+func (r *rawReader) WriteTo(w io.Writer) (n int64, err error) {
+	for {
+		if err = r.wait(); err != nil {
+			if err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		vs, err := PeekVec(r.r, r.r.Len())
+		if err != nil {
+			return n, err
+		}
+		for _, v := range vs {
+			m, werr := w.Write(v)
+			n += int64(m)
+			if err = r.r.Skip(m); err == nil {
+				err = werr
+			}
+			if err != nil {
+				r.r.Release()
+				return n, err
+			}
+		}
+		if err = r.r.Release(); err != nil {
+			return n, err
+		}
+	}
+}
+
+// wait waits for any data to be read, and returns io.EOF if no more data can be read.
+func (r *rawReader) wait() error {
+	if r.r.Len() > 0 {
+		return nil
+	}
+	if _, ok := r.r.(*LinkBuffer); ok {
+		// the LinkBuffer is never filled by others
+		return io.EOF
+	}
+	if _, err := r.r.Peek(1); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+		return err
+	}
+	return nil
+}
+
+var (
+	_ io.ReaderFrom   = &rawWriter{}
+	_ io.StringWriter = &rawWriter{}
+	_ io.ByteWriter   = &rawWriter{}
+)
+
+// rawWriter implements RawWriter by Writer, see NewRawWriter.
+type rawWriter struct {
+	w    Writer
+	size int
+}
+
+// Write implements io.Writer, p is copied since it may be modified once Write returns.
+func (w *rawWriter) Write(p []byte) (n int, err error) {
+	buf, err := w.w.Malloc(len(p))
+	if err != nil {
+		return 0, err
+	}
+	n = copy(buf, p)
+	return n, w.flushFull()
+}
+
+// WriteString implements io.StringWriter.
+func (w *rawWriter) WriteString(s string) (n int, err error) {
+	buf, err := w.w.Malloc(len(s))
+	if err != nil {
+		return 0, err
+	}
+	n = copy(buf, s)
+	return n, w.flushFull()
+}
+
+// WriteByte implements io.ByteWriter.
+func (w *rawWriter) WriteByte(b byte) error {
+	buf, err := w.w.Malloc(1)
+	if err != nil {
+		return err
+	}
+	buf[0] = b
+	return w.flushFull()
+}
+
+// ReadFrom implements io.ReaderFrom, the data is read into the buffer of the Writer directly.
+func (w *rawWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	for {
+		buffered := w.w.MallocLen()
+		buf, err := w.w.Malloc(block4k)
+		if err != nil {
+			return n, err
+		}
+		m, rerr := r.Read(buf)
+		if m < 0 {
+			m = 0
+		}
+		w.w.MallocAck(buffered + m)
+		n += int64(m)
+		if rerr == io.EOF {
+			return n, w.w.Flush()
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+		if err = w.flushFull(); err != nil {
+			return n, err
+		}
+	}
+}
+
+// Flush implements RawWriter.
+func (w *rawWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// flushFull flushes the buffered data once it reaches the size.
+func (w *rawWriter) flushFull() error {
+	if w.w.MallocLen() >= w.size {
+		return w.w.Flush()
+	}
+	return nil
+}
@@ -17,9 +17,12 @@
 package netpoll
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
+	"strings"
 	"testing"
 )
 
@@ -92,6 +95,156 @@ func TestZCEOF(t *testing.T) {
 	MustTrue(t, errors.Is(err, ErrEOF))
 }
 
+func TestZCReaderUntil(t *testing.T) {
+	chunks := []string{"hel", "lo\nwor", "ld"}
+	reader := &MockIOReadWriter{
+		read: func(p []byte) (n int, err error) {
+			if len(chunks) == 0 {
+				return 0, io.EOF
+			}
+			n = copy(p, chunks[0])
+			chunks = chunks[1:]
+			return n, nil
+		},
+	}
+	r := newZCReader(reader)
+
+	line, err := r.Until('\n')
+	MustNil(t, err)
+	Equal(t, string(line), "hello\n")
+	line, err = r.Until('\n')
+	MustTrue(t, errors.Is(err, ErrEOF))
+	Equal(t, string(line), "world")
+}
+
+func TestZCReaderUntilN(t *testing.T) {
+	chunks := []string{"hel", "lo\r\nwor", "ld"}
+	reader := &MockIOReadWriter{
+		read: func(p []byte) (n int, err error) {
+			if len(chunks) == 0 {
+				return 0, io.EOF
+			}
+			n = copy(p, chunks[0])
+			chunks = chunks[1:]
+			return n, nil
+		},
+	}
+	r := newZCReader(reader)
+
+	line, err := ReadLine(r, 8)
+	MustNil(t, err)
+	Equal(t, string(line), "hello")
+	_, err = r.UntilN('\n', 4)
+	MustTrue(t, errors.Is(err, ErrLineTooLong))
+	Equal(t, r.Len(), 5)
+	line, err = r.UntilN('\n', 8)
+	MustTrue(t, errors.Is(err, ErrEOF))
+	Equal(t, string(line), "world")
+
+	buf := NewLinkBuffer()
+	buf.WriteString("abc\ndef")
+	buf.Flush()
+	_, err = buf.UntilN('\n', 3)
+	MustTrue(t, errors.Is(err, ErrLineTooLong))
+	line, err = buf.UntilN('\n', 4)
+	MustNil(t, err)
+	Equal(t, string(line), "abc\n")
+	_, err = buf.UntilN('\n', 3)
+	MustTrue(t, errors.Is(err, ErrLineTooLong))
+	_, err = buf.UntilN('\n', 4)
+	MustTrue(t, err != nil && !errors.Is(err, ErrLineTooLong))
+}
+
+func TestReadBinaryToFallback(t *testing.T) {
+	buf := NewLinkBuffer()
+	buf.WriteString("hello world")
+	buf.Flush()
+	// hide the optional methods of LinkBuffer
+	r := struct{ Reader }{buf}
+	_, ok := Reader(r).(BinaryToReader)
+	MustTrue(t, !ok)
+
+	p := make([]byte, 5)
+	n, err := PeekTo(r, p)
+	MustNil(t, err)
+	Equal(t, n, 5)
+	Equal(t, string(p), "hello")
+	Equal(t, r.Len(), 11)
+	n, err = ReadBinaryTo(r, p)
+	MustNil(t, err)
+	Equal(t, n, 5)
+	Equal(t, string(p), "hello")
+	Equal(t, r.Len(), 6)
+	_, err = ReadBinaryTo(r, make([]byte, 7))
+	MustTrue(t, err != nil)
+}
+
+func TestPeekVecFallback(t *testing.T) {
+	buf := NewLinkBuffer()
+	buf.WriteString("hello world")
+	buf.Flush()
+	// hide the optional methods of LinkBuffer
+	r := struct{ Reader }{buf}
+	_, ok := Reader(r).(PeekVecReader)
+	MustTrue(t, !ok)
+
+	vs, err := PeekVec(r, 11)
+	MustNil(t, err)
+	Equal(t, len(vs), 1)
+	Equal(t, string(vs[0]), "hello world")
+	Equal(t, r.Len(), 11)
+	_, err = PeekVec(r, 12)
+	MustTrue(t, err != nil)
+}
+
+func TestUntilNFallback(t *testing.T) {
+	chunks := []string{"hel", "lo\r\nwor", "ld\n", "toolong\n"}
+	reader := &MockIOReadWriter{
+		read: func(p []byte) (n int, err error) {
+			if len(chunks) == 0 {
+				return 0, io.EOF
+			}
+			n = copy(p, chunks[0])
+			chunks = chunks[1:]
+			return n, nil
+		},
+	}
+	// hide the optional methods of zcReader
+	r := struct{ Reader }{newZCReader(reader)}
+	_, ok := Reader(r).(UntilNReader)
+	MustTrue(t, !ok)
+
+	line, err := ReadLine(r, 8)
+	MustNil(t, err)
+	Equal(t, string(line), "hello")
+	line, err = UntilN(r, '\n', 6)
+	MustNil(t, err)
+	Equal(t, string(line), "world\n")
+	_, err = UntilN(r, '\n', 4)
+	MustTrue(t, errors.Is(err, ErrLineTooLong))
+	line, err = UntilN(r, '\n', 0)
+	MustNil(t, err)
+	Equal(t, string(line), "toolong\n")
+	_, err = UntilN(r, '\n', 4)
+	MustTrue(t, errors.Is(err, ErrEOF))
+}
+
+func TestWritevFallback(t *testing.T) {
+	buf := NewLinkBuffer()
+	// hide the optional methods of LinkBuffer
+	w := struct{ Writer }{buf}
+	_, ok := Writer(w).(VecWriter)
+	MustTrue(t, !ok)
+
+	n, err := Writev(w, [][]byte{[]byte("head"), nil, []byte("body")})
+	MustNil(t, err)
+	Equal(t, n, 8)
+	MustNil(t, w.Flush())
+	p, err := buf.Next(8)
+	MustNil(t, err)
+	Equal(t, string(p), "headbody")
+}
+
 type MockIOReadWriter struct {
 	read  func(p []byte) (n int, err error)
 	write func(p []byte) (n int, err error)
@@ -137,3 +290,55 @@ func TestIOReadWriter2(t *testing.T) {
 	MustNil(t, err)
 	Equal(t, len(p), len(msg))
 }
+
+func TestRawReadWriter(t *testing.T) {
+	r, w := GetSysFdPairs()
+	rconn, wconn := &connection{}, &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, nil))
+	MustNil(t, wconn.init(&netFD{fd: w}, nil))
+
+	// the small writes of the encoder are buffered until Flush
+	type message struct {
+		ID   int
+		Text string
+	}
+	writer := NewRawWriter(wconn.Writer(), 0)
+	enc := json.NewEncoder(writer)
+	for i := 0; i < 3; i++ {
+		MustNil(t, enc.Encode(message{ID: i, Text: "hello"}))
+	}
+	MustTrue(t, wconn.Writer().MallocLen() > 0)
+	MustNil(t, writer.Flush())
+	dec := json.NewDecoder(NewRawReader(rconn.Reader()))
+	for i := 0; i < 3; i++ {
+		var msg message
+		MustNil(t, dec.Decode(&msg))
+		Equal(t, msg.ID, i)
+		Equal(t, msg.Text, "hello")
+	}
+
+	// io.Copy uses ReadFrom and WriteTo, and the Writer is flushed once the source is drained
+	data := strings.Repeat("0123456789", 100000)
+	done := make(chan int64)
+	go func() {
+		var buf bytes.Buffer
+		n, err := io.Copy(&buf, NewRawReader(rconn.Reader()))
+		MustNil(t, err)
+		Equal(t, buf.String(), data)
+		done <- n
+	}()
+	n, err := io.Copy(writer, strings.NewReader(data))
+	MustNil(t, err)
+	Equal(t, n, int64(len(data)))
+	MustNil(t, wconn.Close())
+	Equal(t, <-done, int64(len(data)))
+	rconn.Close()
+
+	// the data of LinkBuffer ends with io.EOF
+	buf := NewLinkBuffer()
+	buf.WriteString("hello")
+	buf.Flush()
+	p, err := ioutil.ReadAll(NewRawReader(buf))
+	MustNil(t, err)
+	Equal(t, string(p), "hello")
+}
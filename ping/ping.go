@@ -0,0 +1,266 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+/* DOC:
+ * Pinger sends the ICMP echo requests and receives the replies on the pollers of netpoll, so that thousands of
+ * destinations can be pinged concurrently without a goroutine for each, e.g. by the health-checking sidecars.
+ * It prefers the unprivileged datagram ICMP sockets, which need net.ipv4.ping_group_range to cover the group
+ * of the process on Linux, and falls back to the raw sockets, which need the CAP_NET_RAW capability.
+ * The result of each Ping is passed to its callback once the reply is received or the timeout elapses.
+ */
+
+var (
+	// ErrTimeout is the error of Result if no reply is received before the timeout.
+	ErrTimeout = errors.New("ping timeout")
+	// ErrClosed is returned by Ping, or the error of Result, if the Pinger is closed.
+	ErrClosed = errors.New("pinger closed")
+	// ErrTooManyPings is returned by Ping if all the sequence numbers are used by the pings in flight.
+	ErrTooManyPings = errors.New("too many pings in flight")
+)
+
+// ICMP types of the echo messages.
+const (
+	icmpv4EchoRequest = 8
+	icmpv4EchoReply   = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+)
+
+// payload is sent with each echo request, and returned by the reply.
+var payload = []byte("netpoll!")
+
+// Result is the result of a Ping, which is passed to its callback.
+type Result struct {
+	Addr netip.Addr    // the destination pinged
+	Seq  uint16        // the sequence number of the echo request
+	RTT  time.Duration // the round trip time, zero if Err is not nil
+	Err  error         // ErrTimeout if no reply is received in time, or ErrClosed
+}
+
+// Pinger pings the destinations of an address family over one ICMP socket, it's safe for concurrent use.
+type Pinger struct {
+	ipv6    bool
+	raw     bool   // the raw sockets receive all the ICMP messages, and the IPv4 ones with the IP header
+	id      uint16 // the identifier of the echo requests, which is rewritten by the kernel for datagram sockets
+	conn    netpoll.PacketConnection
+	mu      sync.Mutex
+	seq     uint16
+	pending map[uint16]*request
+	closed  bool
+}
+
+type request struct {
+	addr     netip.Addr
+	sent     time.Time
+	timer    *time.Timer
+	callback func(Result)
+}
+
+// New creates a Pinger of network, which must be "ip4" or "ip6".
+func New(network string) (*Pinger, error) {
+	p := &Pinger{id: uint16(rand.Uint32()), pending: make(map[uint16]*request)}
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	switch network {
+	case "ip4":
+	case "ip6":
+		p.ipv6 = true
+		family, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM, proto)
+	if err != nil {
+		// the datagram ICMP sockets are not allowed, try the raw sockets
+		p.raw = true
+		if fd, err = syscall.Socket(family, syscall.SOCK_RAW, proto); err != nil {
+			return nil, os.NewSyscallError("socket", err)
+		}
+	}
+	file := os.NewFile(uintptr(fd), "icmp")
+	pc, err := net.FilePacketConn(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	p.conn, err = netpoll.ConvertPacketConn(pc)
+	// the fd has been duplicated
+	pc.Close()
+	if err != nil {
+		return nil, err
+	}
+	p.conn.SetOnPacket(p.onPacket)
+	return p, nil
+}
+
+// Ping sends an echo request to addr, and callback is called with the Result once the reply is received
+// or the timeout elapses. The callback is called by the pollers or the timers, so it must not block.
+func (p *Pinger) Ping(addr netip.Addr, timeout time.Duration, callback func(Result)) error {
+	addr = addr.Unmap()
+	if addr.Is4() == p.ipv6 {
+		return &net.AddrError{Err: "address of the other family", Addr: addr.String()}
+	}
+	req := &request{addr: addr, callback: callback}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrClosed
+	}
+	if len(p.pending) > 0xffff {
+		p.mu.Unlock()
+		return ErrTooManyPings
+	}
+	seq := p.seq
+	for p.pending[seq] != nil {
+		seq++
+	}
+	p.seq = seq + 1
+	p.pending[seq] = req
+	req.sent = time.Now()
+	req.timer = time.AfterFunc(timeout, func() {
+		p.finish(seq, req, Result{Addr: addr, Seq: seq, Err: ErrTimeout})
+	})
+	p.mu.Unlock()
+
+	if _, err := p.conn.WriteTo(p.echo(seq), &net.UDPAddr{IP: addr.AsSlice(), Zone: addr.Zone()}); err != nil {
+		if p.remove(seq, req) {
+			req.timer.Stop()
+		}
+		return err
+	}
+	return nil
+}
+
+// Close closes the socket, and the pings in flight are finished with ErrClosed.
+func (p *Pinger) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	pending := p.pending
+	p.pending = nil
+	p.mu.Unlock()
+	for seq, req := range pending {
+		req.timer.Stop()
+		req.callback(Result{Addr: req.addr, Seq: seq, Err: ErrClosed})
+	}
+	return p.conn.Close()
+}
+
+// echo builds the echo request of seq.
+func (p *Pinger) echo(seq uint16) []byte {
+	msg := make([]byte, 8+len(payload))
+	msg[0] = icmpv4EchoRequest
+	if p.ipv6 {
+		msg[0] = icmpv6EchoRequest
+	}
+	binary.BigEndian.PutUint16(msg[4:], p.id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	copy(msg[8:], payload)
+	if !p.ipv6 {
+		// the checksum of ICMPv6 is always calculated by the kernel
+		binary.BigEndian.PutUint16(msg[2:], checksum(msg))
+	}
+	return msg
+}
+
+// onPacket matches the echo replies with the pings in flight.
+func (p *Pinger) onPacket(ctx context.Context, conn netpoll.PacketConnection, r netpoll.Reader, addr net.Addr) error {
+	msg, err := r.Next(r.Len())
+	if err != nil {
+		return nil
+	}
+	if !p.ipv6 && len(msg) > 0 && msg[0]>>4 == 4 {
+		// skip the IPv4 header received by the raw sockets
+		if hlen := int(msg[0]&0x0f) * 4; len(msg) >= hlen {
+			msg = msg[hlen:]
+		}
+	}
+	reply := byte(icmpv4EchoReply)
+	if p.ipv6 {
+		reply = icmpv6EchoReply
+	}
+	if len(msg) < 8 || msg[0] != reply || (p.raw && binary.BigEndian.Uint16(msg[4:]) != p.id) {
+		return nil
+	}
+	ua, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	from, _ := netip.AddrFromSlice(ua.IP)
+	seq := binary.BigEndian.Uint16(msg[6:])
+	p.mu.Lock()
+	req := p.pending[seq]
+	p.mu.Unlock()
+	if req == nil || req.addr.WithZone("") != from.Unmap() {
+		return nil
+	}
+	p.finish(seq, req, Result{Addr: req.addr, Seq: seq, RTT: time.Since(req.sent)})
+	return nil
+}
+
+// finish removes the ping of seq if it's still req, and calls the callback with res.
+func (p *Pinger) finish(seq uint16, req *request, res Result) {
+	if !p.remove(seq, req) {
+		return
+	}
+	req.timer.Stop()
+	req.callback(res)
+}
+
+// remove removes the ping of seq if it's still req, and reports whether it's removed.
+func (p *Pinger) remove(seq uint16, req *request) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending[seq] != req {
+		return false
+	}
+	delete(p.pending, seq)
+	return true
+}
+
+// checksum calculates the internet checksum of msg, see RFC 1071.
+func checksum(msg []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(msg); i += 2 {
+		sum += uint32(msg[i])<<8 | uint32(msg[i+1])
+	}
+	if len(msg)%2 == 1 {
+		sum += uint32(msg[len(msg)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,124 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func MustNil(t *testing.T, val interface{}) {
+	t.Helper()
+	if val != nil {
+		t.Fatal("assertion nil failed, val=", val)
+	}
+}
+
+func MustTrue(t *testing.T, cond bool) {
+	t.Helper()
+	if !cond {
+		t.Fatal("assertion true failed")
+	}
+}
+
+func newTestPinger(t *testing.T, network string) *Pinger {
+	p, err := New(network)
+	if err != nil {
+		t.Skipf("ICMP socket is not allowed: %v", err)
+	}
+	return p
+}
+
+func TestPinger(t *testing.T) {
+	p := newTestPinger(t, "ip4")
+	loopback := netip.MustParseAddr("127.0.0.1")
+
+	// the loopback replies to all the concurrent pings
+	var wg sync.WaitGroup
+	results := make(chan Result, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		MustNil(t, p.Ping(loopback, time.Second, func(res Result) {
+			results <- res
+			wg.Done()
+		}))
+	}
+	wg.Wait()
+	close(results)
+	seqs := make(map[uint16]bool)
+	for res := range results {
+		MustNil(t, res.Err)
+		MustTrue(t, res.Addr == loopback)
+		MustTrue(t, res.RTT > 0)
+		seqs[res.Seq] = true
+	}
+	MustTrue(t, len(seqs) == 100)
+
+	// the addresses of the other family are rejected
+	MustTrue(t, p.Ping(netip.MustParseAddr("::1"), time.Second, func(Result) {}) != nil)
+
+	MustNil(t, p.Close())
+	MustTrue(t, errors.Is(p.Ping(loopback, time.Second, func(Result) {}), ErrClosed))
+}
+
+func TestPingerTimeout(t *testing.T) {
+	p := newTestPinger(t, "ip4")
+	loopback := netip.MustParseAddr("127.0.0.1")
+	// drop all the replies
+	p.conn.SetOnPacket(func(ctx context.Context, conn netpoll.PacketConnection, r netpoll.Reader, addr net.Addr) error {
+		return nil
+	})
+
+	done := make(chan Result, 1)
+	MustNil(t, p.Ping(loopback, 50*time.Millisecond, func(res Result) { done <- res }))
+	res := <-done
+	MustTrue(t, errors.Is(res.Err, ErrTimeout))
+	MustTrue(t, res.RTT == 0)
+
+	// the pings in flight are finished by Close
+	MustNil(t, p.Ping(loopback, time.Minute, func(res Result) { done <- res }))
+	MustNil(t, p.Close())
+	MustTrue(t, errors.Is((<-done).Err, ErrClosed))
+}
+
+func TestPingerIPv6(t *testing.T) {
+	p := newTestPinger(t, "ip6")
+	defer p.Close()
+
+	done := make(chan Result, 1)
+	if err := p.Ping(netip.MustParseAddr("::1"), time.Second, func(res Result) { done <- res }); err != nil {
+		t.Skipf("IPv6 loopback is unavailable: %v", err)
+	}
+	res := <-done
+	MustNil(t, res.Err)
+	MustTrue(t, res.RTT > 0)
+}
+
+func TestChecksum(t *testing.T) {
+	// the checksum of the message with its checksum is zero
+	p := &Pinger{id: 0x1234}
+	msg := p.echo(7)
+	MustTrue(t, checksum(msg) == 0)
+	MustTrue(t, checksum([]byte{0x01}) == ^uint16(0x0100))
+}
@@ -14,6 +14,8 @@
 
 package netpoll
 
+import "time"
+
 // Poll monitors fd(file descriptor), calls the FDOperator to perform specific actions,
 // and shields underlying differences. On linux systems, poll uses epoll by default,
 // and kevent by default on bsd systems.
@@ -42,6 +44,21 @@ type Poll interface {
 	Free(operator *FDOperator)
 }
 
+// pollPicker picks the Poll to register new file descriptors.
+type pollPicker interface {
+	pick(fd int) Poll
+}
+
+// taskPoster is implemented by the polls which run the tasks posted by LoopRunner.RunOnLoop in Wait.
+type taskPoster interface {
+	post(task func()) error
+}
+
+// waitTuner is implemented by the polls whose waits can be tuned by Config.PollerEventBatch and Config.PollerSpin.
+type waitTuner interface {
+	tuneWait(eventBatch int, spin time.Duration)
+}
+
 // PollEvent defines the operation of poll.Control.
 type PollEvent int
 
@@ -63,4 +80,37 @@ const (
 
 	// PollRW2R is used to remove the writable monitor of FDOperator, generally used with PollR2RW.
 	PollRW2R PollEvent = 0x6
+
+	// PollPauseRead is used to remove the readable monitor of FDOperator and keep the writable monitor,
+	// which is only called when the input buffer is full.
+	PollPauseRead PollEvent = 0x7
+
+	// PollResumeRead is used to restore the readable monitor of FDOperator, generally used with PollPauseRead.
+	PollResumeRead PollEvent = 0x8
+)
+
+// PollerEngine is the underlying implementation of pollers.
+type PollerEngine int
+
+const (
+	// DefaultEngine uses epoll on linux and kqueue on bsd systems.
+	DefaultEngine PollerEngine = iota
+	// IOUringEngine uses io_uring poll requests on linux, which needs kernel 5.19+. The connections are monitored
+	// by multishot poll requests, so they read and write until the socket is drained or full like EdgeTriggered,
+	// and the requests are submitted together with the wait of the poller. It falls back to DefaultEngine if
+	// io_uring is unavailable.
+	IOUringEngine
+)
+
+// EpollMode is the trigger mode of the events of connections monitored by epoll.
+type EpollMode int
+
+const (
+	// LevelTriggered notifies the events as long as the socket is readable or writable, and the connection
+	// reads or writes once for each event, so that the busy connections can't starve the others of a poller.
+	LevelTriggered EpollMode = iota
+	// EdgeTriggered notifies the events only when the socket becomes readable or writable, and the connection
+	// reads or writes until the socket is drained or full for each event, which saves the epoll_wait syscalls
+	// of the connections with the large payloads.
+	EdgeTriggered
 )
@@ -16,9 +16,97 @@
 
 package netpoll
 
+import (
+	"sync/atomic"
+	"time"
+)
+
+var (
+	_ taskPoster = &defaultPoll{}
+	_ timerPoll  = &defaultPoll{}
+	_ waitTuner  = &defaultPoll{}
+)
+
+// pollWait holds the tuning of the waits of defaultPoll, see Config.PollerEventBatch and Config.PollerSpin.
+type pollWait struct {
+	eventBatch int           // max events fetched by each wait, 0 means growing on demand
+	spin       time.Duration // how long to keep polling without blocking since the last events
+	idle       time.Time     // when the poller went idle, zero if events were polled by the last wait
+	stats      *statsShard   // counts the waits and the connections of the poller, see pollStats
+}
+
+// statsShard returns the shard of the global counters of the poller.
+func (w *pollWait) statsShard() *statsShard {
+	return w.stats
+}
+
+// tuneWait implements waitTuner, it must be called before Wait.
+func (w *pollWait) tuneWait(eventBatch int, spin time.Duration) {
+	w.eventBatch, w.spin = eventBatch, spin
+}
+
+// spinning reports whether the next wait should poll without blocking,
+// which holds until spin has elapsed since the last events were polled.
+func (w *pollWait) spinning() bool {
+	if w.spin <= 0 {
+		return false
+	}
+	now := time.Now()
+	if w.idle.IsZero() {
+		w.idle = now
+	}
+	return now.Sub(w.idle) < w.spin
+}
+
+// polled restarts the spinning once some events are polled.
+func (w *pollWait) polled() {
+	w.idle = time.Time{}
+}
+
+// post implements taskPoster, the task is run by Wait once the poll is triggered.
+func (p *defaultPoll) post(task func()) error {
+	p.taskMu.Lock()
+	if p.exited {
+		p.taskMu.Unlock()
+		return Exception(ErrConnClosed, "poller closed")
+	}
+	p.tasks = append(p.tasks, task)
+	p.taskMu.Unlock()
+	return p.Trigger()
+}
+
+// exit runs the tasks left once Wait returns, and the tasks posted later are refused.
+func (p *defaultPoll) exit() {
+	p.taskMu.Lock()
+	p.exited = true
+	tasks := p.tasks
+	p.tasks = nil
+	p.taskMu.Unlock()
+	for _, task := range tasks {
+		task()
+	}
+}
+
+// timers implements timerPoll.
+func (p *defaultPoll) timers() *timerWheel {
+	return &p.wheel
+}
+
+// runTasks runs the tasks posted, it must be called after the trigger flag is cleaned,
+// so that the tasks posted later will trigger the poll again.
+func (p *defaultPoll) runTasks() {
+	p.taskMu.Lock()
+	tasks := p.tasks
+	p.tasks = nil
+	p.taskMu.Unlock()
+	for _, task := range tasks {
+		task()
+	}
+}
+
 func (p *defaultPoll) Alloc() (operator *FDOperator) {
 	op := p.opcache.alloc()
-	op.poll = p
+	op.setPoll(p)
 	return op
 }
 
@@ -26,6 +114,16 @@ func (p *defaultPoll) Free(operator *FDOperator) {
 	p.opcache.freeable(operator)
 }
 
+// numOperators implements operatorCounter.
+func (p *defaultPoll) numOperators() int {
+	return int(atomic.LoadInt32(&p.opcache.inuse))
+}
+
+// operators implements migratablePoll.
+func (p *defaultPoll) operators() *operatorCache {
+	return p.opcache
+}
+
 func (p *defaultPoll) appendHup(operator *FDOperator) {
 	p.hups = append(p.hups, operator.OnHup)
 	p.detach(operator)
@@ -34,7 +132,7 @@ func (p *defaultPoll) appendHup(operator *FDOperator) {
 
 func (p *defaultPoll) detach(operator *FDOperator) {
 	if err := operator.Control(PollDetach); err != nil {
-		logger.Printf("NETPOLL: poller detach operator failed: %v", err)
+		logger.Error("poller detach operator failed", "fd", operator.FD, "err", err)
 	}
 }
 
@@ -64,7 +162,7 @@ func readall(op *FDOperator, br barrier) (total int, err error) {
 		}
 
 	TryRead:
-		n, err = ioread(op.FD, bs, ivs)
+		n, err = ioreadop(op, bs, ivs)
 		op.InputAck(n)
 		total += n
 		if err != nil {
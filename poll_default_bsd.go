@@ -18,18 +18,25 @@ package netpoll
 
 import (
 	"errors"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"unsafe"
 )
 
+// openIOUringPoll always fails on bsd systems, so the default kqueue poller will be used.
+func openIOUringPoll() (Poll, error) {
+	return nil, Exception(ErrUnsupported, "io_uring")
+}
+
 func openPoll() (Poll, error) {
 	return openDefaultPoll()
 }
 
 func openDefaultPoll() (*defaultPoll, error) {
 	l := new(defaultPoll)
+	l.stats = pickStatsShard()
 	p, err := syscall.Kqueue()
 	if err != nil {
 		return nil, err
@@ -45,12 +52,18 @@ func openDefaultPoll() (*defaultPoll, error) {
 		return nil, err
 	}
 	l.opcache = newOperatorCache()
+	l.wheel.post = l.post
 	return l, nil
 }
 
 type defaultPoll struct {
+	pollWait
 	fd      int
 	trigger uint32
+	taskMu  sync.Mutex     // protects tasks and exited
+	tasks   []func()       // posted by LoopRunner.RunOnLoop and wheel
+	exited  bool           // Wait has returned, and no task is accepted anymore
+	wheel   timerWheel     // the timers of the connections on the poller and TimerScheduler.AfterFunc
 	m       sync.Map       //nolint:unused // only used in go:race
 	opcache *operatorCache // operator cache
 	hups    []func(p Poll) error
@@ -58,8 +71,12 @@ type defaultPoll struct {
 
 // Wait implements Poll.
 func (p *defaultPoll) Wait() error {
+	defer p.exit()
 	// init
 	size, caps := 1024, barriercap
+	if p.eventBatch > 0 {
+		size = p.eventBatch
+	}
 	events, barriers := make([]syscall.Kevent_t, size), make([]barrier, size)
 	for i := range barriers {
 		barriers[i].bs = make([][]byte, caps)
@@ -67,8 +84,11 @@ func (p *defaultPoll) Wait() error {
 	}
 	// wait
 	var triggerRead, triggerWrite, triggerHup bool
+	var timeout *syscall.Timespec
 	for {
-		n, err := syscall.Kevent(p.fd, nil, events, nil)
+		begin := statsNow()
+		n, err := syscall.Kevent(p.fd, nil, events, timeout)
+		p.stats.pollerWait(begin)
 		if err != nil && err != syscall.EINTR {
 			// exit gracefully
 			if err == syscall.EBADF {
@@ -76,12 +96,24 @@ func (p *defaultPoll) Wait() error {
 			}
 			return err
 		}
+		// keep spinning for a while before blocking, to pick up the next events with lower latency
+		if n > 0 {
+			p.polled()
+		}
+		if timeout = nil; p.spinning() {
+			timeout = &syscall.Timespec{}
+			if n <= 0 {
+				runtime.Gosched()
+				continue
+			}
+		}
 		for i := 0; i < n; i++ {
 			fd := int(events[i].Ident)
 			// trigger
 			if fd == 0 {
 				// clean trigger
 				atomic.StoreUint32(&p.trigger, 0)
+				p.runTasks()
 				continue
 			}
 			operator := p.getOperator(fd, unsafe.Pointer(&events[i].Udata))
@@ -89,62 +121,14 @@ func (p *defaultPoll) Wait() error {
 				continue
 			}
 
-			var totalRead int
 			evt := events[i]
 			triggerRead = evt.Filter == syscall.EVFILT_READ && evt.Flags&syscall.EV_ENABLE != 0
 			triggerWrite = evt.Filter == syscall.EVFILT_WRITE && evt.Flags&syscall.EV_ENABLE != 0
 			triggerHup = evt.Flags&syscall.EV_EOF != 0
 
-			if triggerRead {
-				if operator.OnRead != nil {
-					// for non-connection
-					operator.OnRead(p)
-				} else {
-					// only for connection
-					bs := operator.Inputs(barriers[i].bs)
-					if len(bs) > 0 {
-						n, err := ioread(operator.FD, bs, barriers[i].ivs)
-						operator.InputAck(n)
-						totalRead += n
-						if err != nil {
-							p.appendHup(operator)
-							continue
-						}
-					}
-				}
-			}
-			if triggerHup {
-				if triggerRead && operator.Inputs != nil {
-					var leftRead int
-					// read all left data if peer send and close
-					if leftRead, err = readall(operator, barriers[i]); err != nil && !errors.Is(err, ErrEOF) {
-						logger.Printf("NETPOLL: readall(fd=%d)=%d before close: %s", operator.FD, total, err.Error())
-					}
-					totalRead += leftRead
-				}
-				// only close connection if no further read bytes
-				if totalRead == 0 {
-					p.appendHup(operator)
-					continue
-				}
-			}
-			if triggerWrite {
-				if operator.OnWrite != nil {
-					// for non-connection
-					operator.OnWrite(p)
-				} else {
-					// only for connection
-					bs, supportZeroCopy := operator.Outputs(barriers[i].bs)
-					if len(bs) > 0 {
-						// TODO: Let the upper layer pass in whether to use ZeroCopy.
-						n, err := iosend(operator.FD, bs, barriers[i].ivs, false && supportZeroCopy)
-						operator.OutputAck(n)
-						if err != nil {
-							p.appendHup(operator)
-							continue
-						}
-					}
-				}
+			if handleEvent(p, operator, barriers[i], triggerRead, triggerWrite, triggerHup, false) {
+				p.appendHup(operator)
+				continue
 			}
 			operator.done()
 		}
@@ -154,6 +138,62 @@ func (p *defaultPoll) Wait() error {
 	}
 }
 
+// handleEvent performs the I/O of the events reported for the operator acquired by do, which is shared with
+// the mock poll of the tests. It returns true if the operator should be hung up, otherwise it should be done.
+// The error events are not reported by kqueue, so errored is ignored.
+func handleEvent(p Poll, operator *FDOperator, br barrier, read, write, hup, errored bool) (hangup bool) {
+	var totalRead int
+	if read {
+		if operator.OnRead != nil {
+			// for non-connection
+			operator.OnRead(p)
+		} else if operator.Inputs != nil {
+			// only for connection
+			bs := operator.Inputs(br.bs)
+			if len(bs) > 0 {
+				n, err := ioreadop(operator, bs, br.ivs)
+				operator.InputAck(n)
+				totalRead += n
+				if err != nil {
+					return true
+				}
+			}
+		}
+	}
+	if hup {
+		if read && operator.Inputs != nil {
+			// read all left data if peer send and close
+			leftRead, err := readall(operator, br)
+			if err != nil && !errors.Is(err, ErrEOF) {
+				logger.Warn("readall before close failed", "fd", operator.FD, "read", totalRead, "err", err)
+			}
+			totalRead += leftRead
+		}
+		// only close connection if no further read bytes
+		if totalRead == 0 {
+			return true
+		}
+	}
+	if write {
+		if operator.OnWrite != nil {
+			// for non-connection
+			operator.OnWrite(p)
+		} else if operator.Outputs != nil {
+			// only for connection
+			bs, supportZeroCopy := operator.Outputs(br.bs)
+			if len(bs) > 0 {
+				// TODO: Let the upper layer pass in whether to use ZeroCopy.
+				n, err := iosend(operator.FD, bs, br.ivs, false && supportZeroCopy)
+				operator.OutputAck(n)
+				if err != nil {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 // TODO: Close will bad file descriptor here
 func (p *defaultPoll) Close() error {
 	err := syscall.Close(p.fd)
@@ -196,6 +236,10 @@ func (p *defaultPoll) Control(operator *FDOperator, event PollEvent) error {
 		evs[0].Filter, evs[0].Flags = syscall.EVFILT_WRITE, syscall.EV_ADD|syscall.EV_ENABLE
 	case PollRW2R:
 		evs[0].Filter, evs[0].Flags = syscall.EVFILT_WRITE, syscall.EV_DELETE
+	case PollPauseRead:
+		evs[0].Filter, evs[0].Flags = syscall.EVFILT_READ, syscall.EV_DISABLE
+	case PollResumeRead:
+		evs[0].Filter, evs[0].Flags = syscall.EVFILT_READ, syscall.EV_ENABLE
 	}
 	_, err := syscall.Kevent(p.fd, evs, nil, nil)
 	return err
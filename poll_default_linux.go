@@ -28,6 +28,7 @@ func openPoll() (Poll, error) {
 
 func openDefaultPoll() (*defaultPoll, error) {
 	poll := new(defaultPoll)
+	poll.stats = pickStatsShard()
 
 	poll.buf = make([]byte, 8)
 	p, err := EpollCreate(0)
@@ -45,6 +46,7 @@ func openDefaultPoll() (*defaultPoll, error) {
 	poll.Reset = poll.reset
 	poll.Handler = poll.handler
 	poll.wop = &FDOperator{FD: int(r0)}
+	poll.wheel.post = poll.post
 
 	if err = poll.Control(poll.wop, PollReadable); err != nil {
 		_ = syscall.Close(poll.wop.FD)
@@ -58,10 +60,15 @@ func openDefaultPoll() (*defaultPoll, error) {
 
 type defaultPoll struct {
 	pollArgs
+	pollWait
 	fd      int            // epoll fd
 	wop     *FDOperator    // eventfd, wake epoll_wait
 	buf     []byte         // read wfd trigger msg
 	trigger uint32         // trigger flag
+	taskMu  sync.Mutex     // protects tasks and exited
+	tasks   []func()       // posted by LoopRunner.RunOnLoop and wheel
+	exited  bool           // Wait has returned, and no task is accepted anymore
+	wheel   timerWheel     // the timers of the connections on the poller and TimerScheduler.AfterFunc
 	m       sync.Map       //nolint:unused // only used in go:race
 	opcache *operatorCache // operator cache
 	// fns for handle events
@@ -88,23 +95,33 @@ func (a *pollArgs) reset(size, caps int) {
 
 // Wait implements Poll.
 func (p *defaultPoll) Wait() (err error) {
+	defer p.exit()
 	// init
-	caps, msec, n := barriercap, -1, 0
-	p.Reset(128, caps)
+	size, caps, msec, n := 128, barriercap, -1, 0
+	if p.eventBatch > 0 {
+		size = p.eventBatch
+	}
+	p.Reset(size, caps)
 	// wait
 	for {
-		if n == p.size && p.size < 128*1024 {
+		if n == p.size && p.eventBatch == 0 && p.size < 128*1024 {
 			p.Reset(p.size<<1, caps)
 		}
+		begin := statsNow()
 		n, err = EpollWait(p.fd, p.events, msec)
+		p.stats.pollerWait(begin)
 		if err != nil && err != syscall.EINTR {
 			return err
 		}
 		if n <= 0 {
-			msec = -1
+			// keep spinning for a while before blocking, to pick up the next events with lower latency
+			if msec = -1; p.spinning() {
+				msec = 0
+			}
 			runtime.Gosched()
 			continue
 		}
+		p.polled()
 		msec = 0
 		if p.Handler(p.events[:n]) {
 			return nil
@@ -116,14 +133,12 @@ func (p *defaultPoll) Wait() (err error) {
 
 func (p *defaultPoll) handler(events []epollevent) (closed bool) {
 	var triggerRead, triggerWrite, triggerHup, triggerError bool
-	var err error
 	for i := range events {
 		operator := p.getOperator(0, events[i].GetDataPtr())
 		if operator == nil || !operator.do() {
 			continue
 		}
 
-		var totalRead int
 		evt := events[i].Events
 		triggerRead = evt&syscall.EPOLLIN != 0
 		triggerWrite = evt&syscall.EPOLLOUT != 0
@@ -143,74 +158,14 @@ func (p *defaultPoll) handler(events []epollevent) (closed bool) {
 				return true
 			}
 			operator.done()
+			p.runTasks()
 			continue
 		}
 
-		if triggerRead {
-			if operator.OnRead != nil {
-				// for non-connection
-				operator.OnRead(p)
-			} else if operator.Inputs != nil {
-				// for connection
-				bs := operator.Inputs(p.barriers[i].bs)
-				if len(bs) > 0 {
-					n, err := ioread(operator.FD, bs, p.barriers[i].ivs)
-					operator.InputAck(n)
-					totalRead += n
-					if err != nil {
-						p.appendHup(operator)
-						continue
-					}
-				}
-			} else {
-				logger.Printf("NETPOLL: operator has critical problem! event=%d operator=%v", evt, operator)
-			}
-		}
-		if triggerHup {
-			if triggerRead && operator.Inputs != nil {
-				// read all left data if peer send and close
-				var leftRead int
-				// read all left data if peer send and close
-				if leftRead, err = readall(operator, p.barriers[i]); err != nil && !errors.Is(err, ErrEOF) {
-					logger.Printf("NETPOLL: readall(fd=%d)=%d before close: %s", operator.FD, totalRead, err.Error())
-				}
-				totalRead += leftRead
-			}
-			// only close connection if no further read bytes
-			if totalRead == 0 {
-				p.appendHup(operator)
-				continue
-			}
-		}
-		if triggerError {
-			// Under block-zerocopy, the kernel may give an error callback, which is not a real error, just an EAGAIN.
-			// So here we need to check this error, if it is EAGAIN then do nothing, otherwise still mark as hup.
-			if _, _, _, _, err := syscall.Recvmsg(operator.FD, nil, nil, syscall.MSG_ERRQUEUE); err != syscall.EAGAIN {
-				p.appendHup(operator)
-			} else {
-				operator.done()
-			}
+		if handleEvent(p, operator, p.barriers[i], triggerRead, triggerWrite, triggerHup, triggerError) {
+			p.appendHup(operator)
 			continue
 		}
-		if triggerWrite {
-			if operator.OnWrite != nil {
-				// for non-connection
-				operator.OnWrite(p)
-			} else if operator.Outputs != nil {
-				// for connection
-				bs, _ := operator.Outputs(p.barriers[i].bs)
-				if len(bs) > 0 {
-					n, err := iosend(operator.FD, bs, p.barriers[i].ivs, false)
-					operator.OutputAck(n)
-					if err != nil {
-						p.appendHup(operator)
-						continue
-					}
-				}
-			} else {
-				logger.Printf("NETPOLL: operator has critical problem! event=%d operator=%v", evt, operator)
-			}
-		}
 		operator.done()
 	}
 	// hup conns together to avoid blocking the poll.
@@ -249,16 +204,143 @@ func (p *defaultPoll) Control(operator *FDOperator, event PollEvent) error {
 	case PollReadable: // server accept a new connection and wait read
 		operator.inuse()
 		op, evt.Events = syscall.EPOLL_CTL_ADD, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLERR
+		if operator.edgeTriggered {
+			evt.Events |= EPOLLET
+		}
+		if operator.exclusive {
+			// EPOLLRDHUP is not allowed with EPOLLEXCLUSIVE, which is only used by the listeners
+			evt.Events = evt.Events&^syscall.EPOLLRDHUP | epollExclusive
+		}
 	case PollWritable: // client create a new connection and wait connect finished
 		operator.inuse()
 		op, evt.Events = syscall.EPOLL_CTL_ADD, EPOLLET|syscall.EPOLLOUT|syscall.EPOLLRDHUP|syscall.EPOLLERR
 	case PollDetach: // deregister
 		p.delOperator(operator)
 		op, evt.Events = syscall.EPOLL_CTL_DEL, syscall.EPOLLIN|syscall.EPOLLOUT|syscall.EPOLLRDHUP|syscall.EPOLLERR
-	case PollR2RW: // connection wait read/write
-		op, evt.Events = syscall.EPOLL_CTL_MOD, syscall.EPOLLIN|syscall.EPOLLOUT|syscall.EPOLLRDHUP|syscall.EPOLLERR
-	case PollRW2R: // connection wait read
-		op, evt.Events = syscall.EPOLL_CTL_MOD, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLERR
+	case PollR2RW, PollRW2R, PollPauseRead, PollResumeRead: // connection wait read and/or write
+		op, evt.Events = syscall.EPOLL_CTL_MOD, modEvents(operator, event)
 	}
 	return EpollCtl(p.fd, op, fd, &evt)
 }
+
+// modEvents returns the events monitored for a connection after the event applied,
+// the state changed by the other events is kept by FDOperator.Control.
+// The hup is not monitored when reading is paused, otherwise the data left in the socket will be dropped.
+func modEvents(operator *FDOperator, event PollEvent) uint32 {
+	writing, readPaused := operator.writing, operator.readPaused
+	switch event {
+	case PollR2RW, PollRW2R:
+		writing = event == PollR2RW
+	case PollPauseRead, PollResumeRead:
+		readPaused = event == PollPauseRead
+	}
+	events := uint32(syscall.EPOLLERR)
+	if !readPaused {
+		events |= syscall.EPOLLIN | syscall.EPOLLRDHUP
+	}
+	if writing {
+		events |= syscall.EPOLLOUT
+	}
+	if operator.edgeTriggered {
+		events |= EPOLLET
+	}
+	return events
+}
+
+// handleEvent performs the I/O of the events reported for the operator acquired by do, which is shared with
+// the mock poll of the tests. It returns true if the operator should be hung up, otherwise it should be done.
+func handleEvent(p Poll, operator *FDOperator, br barrier, read, write, hup, errored bool) (hangup bool) {
+	var totalRead int
+	if read {
+		if operator.OnRead != nil {
+			// for non-connection
+			operator.OnRead(p)
+		} else if operator.Inputs != nil {
+			// for connection
+			n, err := readop(operator, br)
+			totalRead += n
+			if err != nil {
+				return true
+			}
+		} else {
+			logger.Error("operator has critical problem", "event", "read", "operator", operator)
+		}
+	}
+	if hup {
+		if read && operator.Inputs != nil {
+			// read all left data if peer send and close
+			leftRead, err := readall(operator, br)
+			if err != nil && !errors.Is(err, ErrEOF) {
+				logger.Warn("readall before close failed", "fd", operator.FD, "read", totalRead, "err", err)
+			}
+			totalRead += leftRead
+		}
+		// only close connection if no further read bytes,
+		// or the hup will never be reported again in edge-triggered mode.
+		if totalRead == 0 || operator.edgeTriggered {
+			return true
+		}
+	}
+	if errored && (operator.onErrQueue == nil || !operator.onErrQueue()) {
+		// Under block-zerocopy, the kernel may give an error callback, which is not a real error, just an EAGAIN.
+		// So here we need to check this error, if it is EAGAIN then do nothing, otherwise still mark as hup.
+		// The notifications of MSG_ZEROCOPY are handled by onErrQueue, then the write events go on.
+		_, _, _, _, err := syscall.Recvmsg(operator.FD, nil, nil, syscall.MSG_ERRQUEUE)
+		return err != syscall.EAGAIN
+	}
+	if write {
+		if operator.OnWrite != nil {
+			// for non-connection
+			operator.OnWrite(p)
+		} else if operator.Outputs != nil {
+			// for connection
+			if err := writeop(operator, br); err != nil {
+				return true
+			}
+		} else {
+			logger.Error("operator has critical problem", "event", "write", "operator", operator)
+		}
+	}
+	return false
+}
+
+// readop reads the data of the connection once, or until the socket is drained in edge-triggered mode,
+// since the data left doesn't trigger the event again until the reading is resumed if it's paused.
+func readop(operator *FDOperator, br barrier) (total int, err error) {
+	for {
+		bs := operator.Inputs(br.bs)
+		if len(bs) == 0 {
+			return total, nil
+		}
+		size := vecLen(bs) // bs is reset by the reading
+		n, err := ioreadop(operator, bs, br.ivs)
+		operator.InputAck(n)
+		total += n
+		if err != nil || !operator.edgeTriggered || n < size || operator.isReadPaused() {
+			return total, err
+		}
+	}
+}
+
+// writeop writes the output of the connection once, or until the socket is full in edge-triggered mode,
+// since the space left doesn't trigger the event again.
+func writeop(operator *FDOperator, br barrier) (err error) {
+	for {
+		bs, zerocopy := operator.Outputs(br.bs)
+		if len(bs) == 0 {
+			return nil
+		}
+		size := vecLen(bs) // bs is reset by the sending
+		n, err := iosend(operator.FD, bs, br.ivs, zerocopy)
+		if zerocopy && err == syscall.ENOBUFS {
+			// too many notifications of MSG_ZEROCOPY are pending, send it with copying
+			zerocopy = false
+			n, err = iosend(operator.FD, bs, br.ivs, false)
+		}
+		operator.zeroCopied = zerocopy && n > 0
+		operator.OutputAck(n)
+		if err != nil || !operator.edgeTriggered || n < size {
+			return err
+		}
+	}
+}
@@ -16,25 +16,48 @@
 
 package netpoll
 
-import "unsafe"
+import (
+	"sync/atomic"
+	"unsafe"
+)
 
+// eventdata replaces the pointer of the operator in the event under the race detector, the operators are
+// looked up by fd instead. gen tells apart the registrations of the same fd, so that the events polled
+// before the fd is closed are not delivered to the connection reusing the fd.
 type eventdata struct {
 	fd  int32
-	pad int32
+	gen int32
 }
 
+type raceOperator struct {
+	operator *FDOperator
+	gen      int32
+}
+
+var raceGen int32
+
 func (p *defaultPoll) getOperator(fd int, ptr unsafe.Pointer) *FDOperator {
 	data := *(*eventdata)(ptr)
 	tmp, _ := p.m.Load(int(data.fd))
 	if tmp == nil {
 		return nil
 	}
-	return tmp.(*FDOperator)
+	if ro := tmp.(raceOperator); ro.gen == data.gen {
+		return ro.operator
+	}
+	return nil
 }
 
 func (p *defaultPoll) setOperator(ptr unsafe.Pointer, operator *FDOperator) {
-	*(*eventdata)(ptr) = eventdata{fd: int32(operator.FD)}
-	p.m.Store(operator.FD, operator)
+	// keep the generation if the fd is still registered with the same operator
+	tmp, _ := p.m.Load(operator.FD)
+	ro, ok := tmp.(raceOperator)
+	if !ok || ro.operator != operator {
+		ro = raceOperator{operator: operator, gen: atomic.AddInt32(&raceGen, 1)}
+	}
+	// always store it, which also makes the changes before Control visible to the poller for the race detector
+	p.m.Store(operator.FD, ro)
+	*(*eventdata)(ptr) = eventdata{fd: int32(operator.FD), gen: ro.gen}
 }
 
 func (p *defaultPoll) delOperator(operator *FDOperator) {
@@ -19,6 +19,8 @@ package netpoll
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
 	"syscall"
 	"testing"
 
@@ -329,3 +331,102 @@ WAIT:
 	}
 	return n, err
 }
+
+func TestPollerAffinity(t *testing.T) {
+	cpus, err := allowedCPUs()
+	MustNil(t, err)
+	Assert(t, len(cpus) > 0)
+	cpu := cpus[len(cpus)-1]
+
+	pm := newManager(1)
+	Assert(t, pm.SetPollerAffinity([]int{-1}) != nil)
+	MustNil(t, pm.SetPollerAffinity([]int{cpu}))
+	defer pm.Close()
+
+	// OnRead is called by the poller thread
+	rfd, wfd := GetSysFdPairs()
+	defer syscall.Close(rfd)
+	defer syscall.Close(wfd)
+	sets := make(chan unix.CPUSet, 1)
+	poll := pm.Pick()
+	op := poll.Alloc()
+	op.FD = rfd
+	op.OnRead = func(p Poll) error {
+		var set unix.CPUSet
+		unix.SchedGetaffinity(0, &set)
+		syscall.Read(rfd, make([]byte, 8))
+		sets <- set
+		return nil
+	}
+	MustNil(t, poll.Control(op, PollReadable))
+	_, err = syscall.Write(wfd, []byte("ping"))
+	MustNil(t, err)
+	set := <-sets
+	Equal(t, set.Count(), 1)
+	MustTrue(t, set.IsSet(cpu))
+	MustNil(t, poll.Control(op, PollDetach))
+}
+
+func TestPollerNUMA(t *testing.T) {
+	cpus, err := parseCPUList("0-2,5,7-8")
+	MustNil(t, err)
+	Equal(t, fmt.Sprint(cpus), "[0 1 2 5 7 8]")
+	_, err = parseCPUList("0-x")
+	Assert(t, err != nil)
+	nodes, err := numaNodes()
+	MustNil(t, err)
+	Assert(t, len(nodes) > 0)
+
+	// every cpu belongs to the last node, which serves all the connections
+	cpus, err = allowedCPUs()
+	MustNil(t, err)
+	pm := newManager(2)
+	Assert(t, pm.SetNUMANodes([][]int{cpus, {}}) != nil)
+	MustNil(t, pm.SetNUMANodes([][]int{cpus, cpus}))
+	MustNil(t, pm.Run())
+	defer pm.Close()
+	Equal(t, pm.balance.LoadBalance(), numaLoadBalance)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	MustNil(t, err)
+	defer client.Close()
+	server, err := ln.Accept()
+	MustNil(t, err)
+	defer server.Close()
+	_, err = client.Write([]byte("ping"))
+	MustNil(t, err)
+	_, err = server.Read(make([]byte, 4))
+	MustNil(t, err)
+	raw, err := server.(*net.TCPConn).SyscallConn()
+	MustNil(t, err)
+	for i := 0; i < 4; i++ {
+		raw.Control(func(fd uintptr) {
+			MustTrue(t, pm.pick(int(fd)) == pm.polls[1])
+		})
+	}
+
+	// the pollers are pinned to the cpus of their nodes
+	rfd, wfd := GetSysFdPairs()
+	defer syscall.Close(rfd)
+	defer syscall.Close(wfd)
+	sets := make(chan unix.CPUSet, 1)
+	poll := pm.polls[0]
+	op := poll.Alloc()
+	op.FD = rfd
+	op.OnRead = func(p Poll) error {
+		var set unix.CPUSet
+		unix.SchedGetaffinity(0, &set)
+		syscall.Read(rfd, make([]byte, 8))
+		sets <- set
+		return nil
+	}
+	MustNil(t, poll.Control(op, PollReadable))
+	_, err = syscall.Write(wfd, []byte("ping"))
+	MustNil(t, err)
+	set := <-sets
+	Equal(t, set.Count(), len(cpus))
+	MustNil(t, poll.Control(op, PollDetach))
+}
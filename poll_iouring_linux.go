@@ -0,0 +1,467 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// io_uring syscalls and constants, see include/uapi/linux/io_uring.h
+const (
+	sysIOUringSetup = 425
+	sysIOUringEnter = 426
+
+	uringOffSQRing = 0
+	uringOffSQEs   = 0x10000000
+
+	uringFeatSingleMmap = 1 << 0
+	uringEnterGetEvents = 1 << 0
+
+	uringOpPollAdd    = 6
+	uringOpPollRemove = 7
+
+	uringPollAddMulti     = 1 << 0
+	uringPollUpdateEvents = 1 << 1
+
+	uringCQEFMore = 1 << 1
+
+	// uringEntries is the size of submission queue, and the completion queue is twice as large.
+	uringEntries = 1024
+	// uringIgnored is the user_data of the submissions whose completions should be ignored.
+	uringIgnored = ^uint64(0)
+)
+
+type uringSQRingOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringCQRingOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  uringSQRingOffsets
+	cqOff                                                                  uringCQRingOffsets
+}
+
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	pollEvents  uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uring is a minimal io_uring instance which only supports the poll operations.
+//
+// The sqes pushed while the poller is handling the completions are deferred, and submitted together by
+// the io_uring_enter waiting for the next completions, since the poller cannot reap their completions
+// before that anyway. Otherwise, they're submitted immediately to wake up the poller.
+type uring struct {
+	fd       int
+	mu       sync.Mutex // protects submission queue and deferred
+	deferred bool       // the sqes are deferred to the next wait
+	sqRing   []byte
+	cqRing   []byte
+	sqeMem   []byte
+
+	sqHead, sqTail, sqMask *uint32
+	sqArray                []uint32
+	sqes                   []uringSQE
+	cqHead, cqTail, cqMask *uint32
+	cqes                   []uringCQE
+}
+
+func newURing(entries uint32) (r *uring, err error) {
+	var params uringParams
+	fd, _, e := syscall.Syscall(sysIOUringSetup, uintptr(entries), uintptr(unsafe.Pointer(&params)), 0)
+	if e != 0 {
+		return nil, e
+	}
+	r = &uring{fd: int(fd)}
+	defer func() {
+		if err != nil {
+			r.close()
+		}
+	}()
+
+	sqSize := int(params.sqOff.array + params.sqEntries*4)
+	cqSize := int(params.cqOff.cqes + params.cqEntries*uint32(unsafe.Sizeof(uringCQE{})))
+	if params.features&uringFeatSingleMmap != 0 && cqSize > sqSize {
+		sqSize = cqSize
+	}
+	r.sqRing, err = syscall.Mmap(r.fd, uringOffSQRing, sqSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return nil, err
+	}
+	r.cqRing = r.sqRing
+	if params.features&uringFeatSingleMmap == 0 {
+		// IORING_OFF_CQ_RING
+		r.cqRing, err = syscall.Mmap(r.fd, 0x8000000, cqSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sqeSize := int(params.sqEntries) * int(unsafe.Sizeof(uringSQE{}))
+	r.sqeMem, err = syscall.Mmap(r.fd, uringOffSQEs, sqeSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return nil, err
+	}
+
+	sq, cq := unsafe.Pointer(&r.sqRing[0]), unsafe.Pointer(&r.cqRing[0])
+	r.sqHead = (*uint32)(unsafe.Add(sq, params.sqOff.head))
+	r.sqTail = (*uint32)(unsafe.Add(sq, params.sqOff.tail))
+	r.sqMask = (*uint32)(unsafe.Add(sq, params.sqOff.ringMask))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Add(sq, params.sqOff.array)), params.sqEntries)
+	r.sqes = unsafe.Slice((*uringSQE)(unsafe.Pointer(&r.sqeMem[0])), params.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Add(cq, params.cqOff.head))
+	r.cqTail = (*uint32)(unsafe.Add(cq, params.cqOff.tail))
+	r.cqMask = (*uint32)(unsafe.Add(cq, params.cqOff.ringMask))
+	r.cqes = unsafe.Slice((*uringCQE)(unsafe.Add(cq, params.cqOff.cqes)), params.cqEntries)
+	return r, nil
+}
+
+// push fills a new sqe, which is submitted immediately unless the submissions are deferred.
+func (r *uring) push(fill func(sqe *uringSQE)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.unsubmitted() == uint32(len(r.sqes)) {
+		// the submission queue is full
+		if err := r.submit(); err != nil {
+			return err
+		}
+	}
+	tail := *r.sqTail
+	idx := tail & *r.sqMask
+	sqe := &r.sqes[idx]
+	*sqe = uringSQE{}
+	fill(sqe)
+	r.sqArray[idx] = idx
+	atomic.StoreUint32(r.sqTail, tail+1)
+	if r.deferred {
+		return nil
+	}
+	return r.submit()
+}
+
+// submit submits all the sqes in the submission queue, it must be called with mu held.
+func (r *uring) submit() error {
+	for {
+		_, err := r.enter(r.unsubmitted(), 0, 0)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// unsubmitted returns the number of the sqes which are not consumed by the kernel yet.
+func (r *uring) unsubmitted() uint32 {
+	return *r.sqTail - atomic.LoadUint32(r.sqHead)
+}
+
+// wait submits the deferred sqes and waits for the completions by one io_uring_enter,
+// it doesn't block if there are completions to be reaped. The sqes pushed later are deferred.
+func (r *uring) wait() (err error) {
+	r.mu.Lock()
+	toSubmit := r.unsubmitted()
+	var minComplete, flags uint32
+	if *r.cqHead == atomic.LoadUint32(r.cqTail) {
+		minComplete, flags = 1, uringEnterGetEvents
+	}
+	if toSubmit > 0 || minComplete > 0 {
+		r.deferred = false
+		r.mu.Unlock()
+		// the sqes pushed during waiting are submitted by the pushers, and the kernel
+		// submits the sqes left only, so toSubmit is allowed to be larger.
+		_, err = r.enter(toSubmit, minComplete, flags)
+		r.mu.Lock()
+	}
+	r.deferred = true
+	r.mu.Unlock()
+	return err
+}
+
+// enter wraps io_uring_enter.
+func (r *uring) enter(toSubmit, minComplete, flags uint32) (n int, err error) {
+	r0, _, e := syscall.Syscall6(sysIOUringEnter, uintptr(r.fd), uintptr(toSubmit), uintptr(minComplete), uintptr(flags), 0, 0)
+	if e != 0 {
+		return int(r0), e
+	}
+	return int(r0), nil
+}
+
+func (r *uring) close() {
+	if r.sqeMem != nil {
+		syscall.Munmap(r.sqeMem)
+	}
+	if r.cqRing != nil && &r.cqRing[0] != &r.sqRing[0] {
+		syscall.Munmap(r.cqRing)
+	}
+	if r.sqRing != nil {
+		syscall.Munmap(r.sqRing)
+	}
+	syscall.Close(r.fd)
+}
+
+// uringPoll implements Poll by io_uring poll requests.
+// The completions are converted to epoll events, so the events handler is shared with defaultPoll.
+//
+// The connections are monitored by multishot poll requests, which are edge-triggered, so they read and write
+// until the socket is drained or full like EdgeTriggered. The level-triggered readable events of the other
+// operators are emulated by oneshot requests, which are re-armed after the events have been handled,
+// and the re-arming is submitted together with the next wait.
+//
+// Each registration has a unique user_data, so that the completions posted before detach
+// will never be delivered to a reused operator.
+type uringPoll struct {
+	defaultPoll
+	ring   *uring
+	mu     sync.Mutex              // protects seq, ids and polls
+	seq    uint64                  // the last user_data
+	ids    map[uint64]uint64       // key=operator data, value=user_data of the registered poll request
+	polls  map[uint64]uringPollReq // key=user_data, the registered poll requests
+	rearms []uint64                // the completed poll requests to be re-armed
+}
+
+type uringPollReq struct {
+	data   uint64 // operator data, see defaultPoll.setOperator
+	fd     int
+	events uint32
+	flags  uint32
+}
+
+func openIOUringPoll() (Poll, error) {
+	ring, err := newURing(uringEntries)
+	if err != nil {
+		return nil, err
+	}
+	poll := &uringPoll{ring: ring, ids: make(map[uint64]uint64), polls: make(map[uint64]uringPollReq)}
+	poll.fd = ring.fd
+	poll.buf = make([]byte, 8)
+	poll.stats = pickStatsShard()
+
+	r0, _, e0 := syscall.Syscall(syscall.SYS_EVENTFD2, 0, 0, 0)
+	if e0 != 0 {
+		ring.close()
+		return nil, e0
+	}
+	poll.Reset = poll.reset
+	poll.Handler = poll.handler
+	poll.wop = &FDOperator{FD: int(r0)}
+	poll.opcache = newOperatorCache()
+	poll.wheel.post = poll.post
+	if err = poll.Control(poll.wop, PollReadable); err != nil {
+		syscall.Close(poll.wop.FD)
+		ring.close()
+		return nil, err
+	}
+	return poll, nil
+}
+
+// Wait implements Poll.
+func (p *uringPoll) Wait() (err error) {
+	defer p.exit()
+	p.Reset(128, barriercap)
+	for {
+		begin := statsNow()
+		err = p.ring.wait()
+		p.stats.pollerWait(begin)
+		if err != nil && err != syscall.EINTR {
+			return err
+		}
+		n := p.reap()
+		if n == 0 {
+			// the canceled requests may be reaped without any event
+			p.rearm()
+			continue
+		}
+		if p.Handler(p.events[:n]) {
+			// the ring fd has been closed by handler
+			p.ring.fd = -1
+			p.ring.close()
+			return nil
+		}
+		p.rearm()
+		// we can make sure that there is no op remaining if Handler finished
+		p.opcache.free()
+		if n == p.size && p.size < 128*1024 {
+			p.Reset(p.size<<1, barriercap)
+		}
+	}
+}
+
+// reap converts the completions to epoll events.
+func (p *uringPoll) reap() (n int) {
+	r := p.ring
+	head, tail := *r.cqHead, atomic.LoadUint32(r.cqTail)
+	if head == tail {
+		return 0
+	}
+	p.mu.Lock()
+	for ; head != tail && n < len(p.events); head++ {
+		cqe := r.cqes[head&*r.cqMask]
+		req, ok := p.polls[cqe.userData]
+		if !ok {
+			// detached or ignored requests
+			continue
+		}
+		if cqe.res < 0 {
+			// the requests will be canceled if the submitter thread exits, just re-arm them.
+			if cqe.res == -int32(syscall.ECANCELED) {
+				p.rearms = append(p.rearms, cqe.userData)
+			}
+			continue
+		}
+		*(*uint64)(p.events[n].GetDataPtr()) = req.data
+		p.events[n].Events = uint32(cqe.res)
+		n++
+		if cqe.flags&uringCQEFMore == 0 {
+			// oneshot poll is finished, or multishot poll is terminated by kernel.
+			p.rearms = append(p.rearms, cqe.userData)
+		}
+	}
+	p.mu.Unlock()
+	atomic.StoreUint32(r.cqHead, head)
+	return n
+}
+
+// Alloc implements Poll.
+func (p *uringPoll) Alloc() (operator *FDOperator) {
+	op := p.opcache.alloc()
+	op.setPoll(p)
+	return op
+}
+
+// Control implements Poll.
+func (p *uringPoll) Control(operator *FDOperator, event PollEvent) error {
+	// DON'T move `fd=operator.FD` behind inuse() call, see defaultPoll.Control.
+	fd := operator.FD
+	var evt epollevent
+	p.setOperator(evt.GetDataPtr(), operator)
+	data := *(*uint64)(evt.GetDataPtr())
+	switch event {
+	case PollReadable:
+		var flags uint32
+		if operator.Inputs != nil {
+			// the connection reads until the socket is drained, see readop
+			operator.edgeTriggered = true
+			flags = uringPollAddMulti
+		}
+		operator.inuse()
+		return p.pollAdd(data, fd, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLERR, flags)
+	case PollWritable:
+		operator.inuse()
+		return p.pollAdd(data, fd, syscall.EPOLLOUT|syscall.EPOLLRDHUP|syscall.EPOLLERR, uringPollAddMulti)
+	case PollDetach:
+		p.delOperator(operator)
+		return p.pollRemove(data)
+	case PollR2RW, PollRW2R, PollPauseRead, PollResumeRead:
+		return p.pollUpdate(data, modEvents(operator, event))
+	}
+	return nil
+}
+
+func (p *uringPoll) pollAdd(data uint64, fd int, events, flags uint32) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if id, ok := p.ids[data]; ok {
+		// registered twice, the completions of previous request will be ignored
+		delete(p.polls, id)
+	}
+	p.seq++
+	req := uringPollReq{data: data, fd: fd, events: events, flags: flags}
+	p.ids[data], p.polls[p.seq] = p.seq, req
+	return p.submitPollAdd(p.seq, req)
+}
+
+func (p *uringPoll) pollRemove(data uint64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id, ok := p.ids[data]
+	if !ok {
+		return nil
+	}
+	delete(p.ids, data)
+	delete(p.polls, id)
+	return p.ring.push(func(sqe *uringSQE) {
+		sqe.opcode, sqe.fd = uringOpPollRemove, -1
+		sqe.addr, sqe.userData = id, uringIgnored
+	})
+}
+
+// pollUpdate modifies the events of a registered poll request.
+// If the request has completed and is waiting to be re-armed, the update will fail,
+// but the new events will be used when re-armed.
+func (p *uringPoll) pollUpdate(data uint64, events uint32) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id, ok := p.ids[data]
+	if !ok {
+		return nil
+	}
+	req := p.polls[id]
+	req.events = events
+	p.polls[id] = req
+	return p.ring.push(func(sqe *uringSQE) {
+		sqe.opcode, sqe.fd, sqe.len = uringOpPollRemove, -1, uringPollUpdateEvents|req.flags&uringPollAddMulti
+		sqe.addr, sqe.pollEvents, sqe.userData = id, events, uringIgnored
+	})
+}
+
+// rearm pushes the completed poll requests again if they are not detached, which are submitted by the next wait.
+func (p *uringPoll) rearm() {
+	if len(p.rearms) == 0 {
+		return
+	}
+	p.mu.Lock()
+	for _, id := range p.rearms {
+		if req, ok := p.polls[id]; ok {
+			if err := p.submitPollAdd(id, req); err != nil {
+				logger.Error("io_uring re-arm poll failed", "fd", req.fd, "err", err)
+			}
+		}
+	}
+	p.mu.Unlock()
+	p.rearms = p.rearms[:0]
+}
+
+func (p *uringPoll) submitPollAdd(id uint64, req uringPollReq) error {
+	return p.ring.push(func(sqe *uringSQE) {
+		sqe.opcode, sqe.fd, sqe.len = uringOpPollAdd, int32(req.fd), req.flags
+		sqe.pollEvents, sqe.userData = req.events, id
+	})
+}
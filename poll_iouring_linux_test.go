@@ -0,0 +1,169 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIOUringPoll(t *testing.T) {
+	poll, err := openIOUringPoll()
+	if err != nil {
+		t.Skipf("io_uring is unavailable: %v", err)
+	}
+	go poll.Wait()
+	defer poll.Close()
+
+	rfd, wfd := GetSysFdPairs()
+	defer syscall.Close(rfd)
+	defer syscall.Close(wfd)
+
+	reads, writes := make(chan string, 16), make(chan struct{}, 16)
+	op := poll.Alloc()
+	op.FD = rfd
+	op.OnRead = func(p Poll) error {
+		buf := make([]byte, 1)
+		n, _ := syscall.Read(rfd, buf)
+		reads <- string(buf[:n])
+		return nil
+	}
+	op.OnWrite = func(p Poll) error {
+		writes <- struct{}{}
+		return op.Control(PollRW2R)
+	}
+	err = op.Control(PollReadable)
+	MustNil(t, err)
+
+	// level-triggered: one byte each read, all the data should be consumed
+	_, err = syscall.Write(wfd, []byte("ab"))
+	MustNil(t, err)
+	Equal(t, <-reads, "a")
+	Equal(t, <-reads, "b")
+
+	// wait write and back to read only
+	err = op.Control(PollR2RW)
+	MustNil(t, err)
+	select {
+	case <-writes:
+	case <-time.After(time.Second):
+		t.Fatal("wait writable timeout")
+	}
+	_, err = syscall.Write(wfd, []byte("c"))
+	MustNil(t, err)
+	Equal(t, <-reads, "c")
+
+	// no more events after detach
+	err = op.Control(PollDetach)
+	MustNil(t, err)
+	_, err = syscall.Write(wfd, []byte("d"))
+	MustNil(t, err)
+	select {
+	case s := <-reads:
+		t.Fatalf("unexpected read after detach: %s", s)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestIOUringPollConnection(t *testing.T) {
+	poll, err := openIOUringPoll()
+	if err != nil {
+		t.Skipf("io_uring is unavailable: %v", err)
+	}
+	go poll.Wait()
+	defer poll.Close()
+
+	rfd, wfd := GetSysFdPairs()
+	defer syscall.Close(rfd)
+	defer syscall.Close(wfd)
+
+	MustNil(t, syscall.SetNonblock(rfd, true))
+	var buf [1]byte
+	reads := make(chan string, 16)
+	op := poll.Alloc()
+	op.FD = rfd
+	op.Inputs = func(vs [][]byte) (rs [][]byte) {
+		vs[0] = buf[:]
+		return vs[:1]
+	}
+	op.InputAck = func(n int) (err error) {
+		if n > 0 {
+			reads <- string(buf[:n])
+		}
+		return nil
+	}
+	op.OnHup = func(p Poll) error {
+		return nil
+	}
+	err = op.Control(PollReadable)
+	MustNil(t, err)
+	// the connections are monitored by multishot requests, and read until the socket is drained
+	Assert(t, op.edgeTriggered)
+
+	for _, data := range []string{"abc", "de"} {
+		_, err = syscall.Write(wfd, []byte(data))
+		MustNil(t, err)
+		for i := range data {
+			select {
+			case s := <-reads:
+				Equal(t, s, data[i:i+1])
+			case <-time.After(time.Second):
+				t.Fatal("wait read timeout")
+			}
+		}
+	}
+	err = op.Control(PollDetach)
+	MustNil(t, err)
+}
+
+func TestIOUringDeferredSubmission(t *testing.T) {
+	ring, err := newURing(4)
+	if err != nil {
+		t.Skipf("io_uring is unavailable: %v", err)
+	}
+	defer ring.close()
+	nop := func(sqe *uringSQE) {}
+
+	// submitted immediately if not deferred
+	MustNil(t, ring.push(nop))
+	Equal(t, ring.unsubmitted(), uint32(0))
+
+	// deferred to the next wait, unless the submission queue is full
+	MustNil(t, ring.wait())
+	for i := 0; i < 4; i++ {
+		MustNil(t, ring.push(nop))
+	}
+	Equal(t, ring.unsubmitted(), uint32(4))
+	MustNil(t, ring.push(nop))
+	Equal(t, ring.unsubmitted(), uint32(1))
+	MustNil(t, ring.wait())
+	Equal(t, ring.unsubmitted(), uint32(0))
+}
+
+func TestIOUringEngineFallback(t *testing.T) {
+	m := newManager(1)
+	err := m.SetPollerEngine(PollerEngine(-1))
+	Assert(t, err != nil)
+	err = m.SetPollerEngine(IOUringEngine)
+	MustNil(t, err)
+	poll := m.Pick()
+	Assert(t, poll != nil)
+	if _, err = openIOUringPoll(); err == nil {
+		_, ok := poll.(*uringPoll)
+		Assert(t, ok)
+	}
+	MustNil(t, m.Close())
+}
@@ -29,13 +29,27 @@ const (
 	RoundRobin LoadBalance = iota
 	// Random requests that connections are randomly distributed.
 	Random
+	// LeastConnections requests that connections are distributed to the Poll
+	// with the least live connections, which suits the connections with skewed lifetimes.
+	LeastConnections
 )
 
+// customLoadBalance is the LoadBalance of the load balancer set by SetCustomLoadBalance.
+const customLoadBalance LoadBalance = -1
+
+// LoadBalancer is the user-defined load balancing method, which can implement the affinity schemes
+// such as hashing by the remote address or SO_INCOMING_CPU, see SetCustomLoadBalance and WithLoadBalancer.
+type LoadBalancer interface {
+	// Pick chooses the Poll to register fd among pollers, pollers must not be modified.
+	// The fd is -1 if the Poll is not picked for any file descriptor, such as in Initialize.
+	Pick(fd int, pollers []Poll) Poll
+}
+
 // loadbalance sets the load balancing method for []*polls
 type loadbalance interface {
 	LoadBalance() LoadBalance
-	// Pick choose the most qualified Poll
-	Pick() (poll Poll)
+	// Pick choose the most qualified Poll for fd
+	Pick(fd int) (poll Poll)
 
 	Rebalance(polls []Poll)
 }
@@ -46,51 +60,116 @@ func newLoadbalance(lb LoadBalance, polls []Poll) loadbalance {
 		return newRoundRobinLB(polls)
 	case Random:
 		return newRandomLB(polls)
+	case LeastConnections:
+		return newLeastConnLB(polls)
 	}
 	return newRoundRobinLB(polls)
 }
 
+// pollList holds the polls of load balancers, which may be replaced by Rebalance when Pick is running.
+type pollList struct {
+	v atomic.Value // []Poll
+}
+
+func (l *pollList) load() []Poll {
+	polls, _ := l.v.Load().([]Poll)
+	return polls
+}
+
+func (l *pollList) Rebalance(polls []Poll) {
+	l.v.Store(polls)
+}
+
 func newRandomLB(polls []Poll) loadbalance {
-	return &randomLB{polls: polls, pollSize: len(polls)}
+	b := &randomLB{}
+	b.Rebalance(polls)
+	return b
 }
 
 type randomLB struct {
-	polls    []Poll
-	pollSize int
+	pollList
 }
 
 func (b *randomLB) LoadBalance() LoadBalance {
 	return Random
 }
 
-func (b *randomLB) Pick() (poll Poll) {
-	idx := fastrand.Intn(b.pollSize)
-	return b.polls[idx]
-}
-
-func (b *randomLB) Rebalance(polls []Poll) {
-	b.polls, b.pollSize = polls, len(polls)
+func (b *randomLB) Pick(fd int) (poll Poll) {
+	polls := b.load()
+	idx := fastrand.Intn(len(polls))
+	return polls[idx]
 }
 
 func newRoundRobinLB(polls []Poll) loadbalance {
-	return &roundRobinLB{polls: polls, pollSize: len(polls)}
+	b := &roundRobinLB{}
+	b.Rebalance(polls)
+	return b
 }
 
 type roundRobinLB struct {
-	polls    []Poll
+	pollList
 	accepted uintptr // accept counter
-	pollSize int
 }
 
 func (b *roundRobinLB) LoadBalance() LoadBalance {
 	return RoundRobin
 }
 
-func (b *roundRobinLB) Pick() (poll Poll) {
-	idx := int(atomic.AddUintptr(&b.accepted, 1)) % b.pollSize
-	return b.polls[idx]
+func (b *roundRobinLB) Pick(fd int) (poll Poll) {
+	polls := b.load()
+	idx := int(atomic.AddUintptr(&b.accepted, 1)) % len(polls)
+	return polls[idx]
+}
+
+// operatorCounter is implemented by the polls which count the allocated operators,
+// namely the live connections registered to them.
+type operatorCounter interface {
+	numOperators() int
+}
+
+func newLeastConnLB(polls []Poll) loadbalance {
+	b := &leastConnLB{}
+	b.Rebalance(polls)
+	return b
+}
+
+type leastConnLB struct {
+	pollList
+}
+
+func (b *leastConnLB) LoadBalance() LoadBalance {
+	return LeastConnections
+}
+
+func (b *leastConnLB) Pick(fd int) (poll Poll) {
+	least := -1
+	for _, p := range b.load() {
+		var n int
+		if counter, ok := p.(operatorCounter); ok {
+			n = counter.numOperators()
+		}
+		if least < 0 || n < least {
+			poll, least = p, n
+		}
+	}
+	return poll
+}
+
+func newCustomLB(lb LoadBalancer, polls []Poll) loadbalance {
+	b := &customLB{lb: lb}
+	b.Rebalance(polls)
+	return b
+}
+
+type customLB struct {
+	pollList
+	lb LoadBalancer
+}
+
+func (b *customLB) LoadBalance() LoadBalance {
+	return customLoadBalance
 }
 
-func (b *roundRobinLB) Rebalance(polls []Poll) {
-	b.polls, b.pollSize = polls, len(polls)
+func (b *customLB) Pick(fd int) (poll Poll) {
+	return b.lb.Pick(fd, b.load())
 }
@@ -19,7 +19,9 @@ package netpoll
 import (
 	"fmt"
 	"runtime"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const (
@@ -39,12 +41,20 @@ func newManager(numLoops int) *manager {
 // a single poller may not be optimal if the number of cores is large (40C+).
 type manager struct {
 	numLoops int32
-	status   int32       // 0: uninitialized, 1: initializing, 2: initialized
-	balance  loadbalance // load balancing method
-	polls    []Poll      // all the polls
+	status   int32        // 0: uninitialized, 1: initializing, 2: initialized
+	balance  loadbalance  // load balancing method
+	engine   PollerEngine // underlying implementation of pollers
+	polls    []Poll       // all the polls
+	drainMu  sync.Mutex
+	draining []Poll        // the polls removed by shrinking, which are closed after drained
+	affinity []int         // the cpus which the pollers are pinned to in turn, nil means not pinned
+	nodes    [][]int       // the cpus of the NUMA nodes which the pollers are pinned to in turn, nil means not grouped
+	batch    int           // max events fetched by each wait of the pollers, 0 means growing on demand
+	spin     time.Duration // how long the pollers keep polling without blocking since the last events
 }
 
-// SetNumLoops will return error when set numLoops < 1
+// SetNumLoops will return error when set numLoops < 1.
+// It can be called at runtime, and the pollers will be adjusted by the next Pick.
 func (m *manager) SetNumLoops(numLoops int) (err error) {
 	if numLoops < 1 {
 		return fmt.Errorf("set invalid numLoops[%d]", numLoops)
@@ -64,17 +74,102 @@ func (m *manager) SetLoadBalance(lb LoadBalance) error {
 	return nil
 }
 
+// SetCustomLoadBalance set the user-defined load balancer.
+func (m *manager) SetCustomLoadBalance(lb LoadBalancer) error {
+	if lb == nil {
+		return fmt.Errorf("set nil load balancer")
+	}
+	m.balance = newCustomLB(lb, m.polls)
+	return nil
+}
+
+// SetPollerEngine set the poller engine, it only works for the pollers created later.
+func (m *manager) SetPollerEngine(engine PollerEngine) error {
+	if engine != DefaultEngine && engine != IOUringEngine {
+		return fmt.Errorf("set invalid poller engine[%d]", engine)
+	}
+	m.engine = engine
+	return nil
+}
+
+// SetPollerAffinity pins the OS thread of each poller to the cpus in turn, it only works for the pollers created later.
+// If cpus is empty, the cpus which the process is allowed to run on are used.
+func (m *manager) SetPollerAffinity(cpus []int) (err error) {
+	if len(cpus) == 0 {
+		if cpus, err = allowedCPUs(); err != nil {
+			return err
+		}
+	}
+	for _, cpu := range cpus {
+		if cpu < 0 {
+			return fmt.Errorf("set invalid poller affinity cpu[%d]", cpu)
+		}
+	}
+	m.affinity = cpus
+	return nil
+}
+
+// SetNUMANodes groups the pollers by the NUMA nodes, the i-th poller is pinned to the cpus of the node
+// i%len(nodes) and serves the connections received by them, it overrides the load balance and the affinity.
+func (m *manager) SetNUMANodes(nodes [][]int) error {
+	if len(nodes) == 0 {
+		return fmt.Errorf("set empty NUMA nodes")
+	}
+	for _, cpus := range nodes {
+		if len(cpus) == 0 {
+			return fmt.Errorf("set NUMA node without cpus")
+		}
+	}
+	m.nodes = nodes
+	m.balance = newNUMALB(nodes, m.polls)
+	return nil
+}
+
+// SetPollerWait tunes the waits of the pollers created later: eventBatch fixes the max events fetched by
+// each wait, and spin keeps the pollers polling without blocking for a while since the last events.
+// Zero values keep the default behaviors. The io_uring pollers ignore them.
+func (m *manager) SetPollerWait(eventBatch int, spin time.Duration) error {
+	if eventBatch < 0 || spin < 0 {
+		return fmt.Errorf("set invalid poller wait: event batch[%d], spin[%s]", eventBatch, spin)
+	}
+	m.batch, m.spin = eventBatch, spin
+	return nil
+}
+
 // Close release all resources.
 func (m *manager) Close() (err error) {
 	for _, poll := range m.polls {
 		err = poll.Close()
 	}
+	if derr := m.closeDraining(); derr != nil {
+		err = derr
+	}
 	m.numLoops = 0
 	m.balance = nil
 	m.polls = nil
 	return err
 }
 
+// Release closes all the pollers, which will be opened again by the next Pick.
+func (m *manager) Release() (err error) {
+	for _, poll := range m.polls {
+		err = poll.Close()
+	}
+	if derr := m.closeDraining(); derr != nil {
+		err = derr
+	}
+	m.polls = nil
+	m.balance.Rebalance(nil)
+	atomic.StoreInt32(&m.status, managerUninitialized)
+	return err
+}
+
+// all returns all the pollers.
+func (m *manager) all() []Poll {
+	m.Pick() // make sure the pollers are initialized
+	return m.polls
+}
+
 // Run all pollers.
 func (m *manager) Run() (err error) {
 	defer func() {
@@ -89,25 +184,25 @@ func (m *manager) Run() (err error) {
 	}
 	polls := make([]Poll, numLoops)
 	if numLoops < len(m.polls) {
-		// shrink polls
+		// shrink polls, the redundant polls are closed after the connections on them are closed or migrated.
 		copy(polls, m.polls[:numLoops])
+		m.drainMu.Lock()
+		m.draining = append(m.draining, m.polls[numLoops:]...)
+		m.drainMu.Unlock()
 		for idx := numLoops; idx < len(m.polls); idx++ {
-			// close redundant polls
-			if err = m.polls[idx].Close(); err != nil {
-				logger.Printf("NETPOLL: poller close failed: %v\n", err)
-			}
+			go m.drain(m.polls[idx])
 		}
 	} else {
 		// growth polls
 		copy(polls, m.polls)
 		for idx := len(m.polls); idx < numLoops; idx++ {
 			var poll Poll
-			poll, err = openPoll()
+			poll, err = m.openPoll()
 			if err != nil {
 				return err
 			}
 			polls[idx] = poll
-			go poll.Wait()
+			go m.wait(poll, idx)
 		}
 	}
 	m.polls = polls
@@ -117,6 +212,89 @@ func (m *manager) Run() (err error) {
 	return nil
 }
 
+// wait runs the poll, and pins the poller by the index of the poll if the NUMA nodes or affinity is set.
+func (m *manager) wait(poll Poll, idx int) {
+	if nodes := m.nodes; len(nodes) > 0 {
+		// the thread is not unlocked, so that it's terminated with the poller instead of reused by other goroutines.
+		runtime.LockOSThread()
+		if err := setThreadAffinity(nodes[idx%len(nodes)]...); err != nil {
+			logger.Warn("poller set NUMA affinity failed", "node", idx%len(nodes), "err", err)
+		}
+	} else if cpus := m.affinity; len(cpus) > 0 {
+		// the thread is not unlocked, so that it's terminated with the poller instead of reused by other goroutines.
+		runtime.LockOSThread()
+		if err := setThreadAffinity(cpus[idx%len(cpus)]); err != nil {
+			logger.Warn("poller set affinity failed", "cpu", cpus[idx%len(cpus)], "err", err)
+		}
+	}
+	poll.Wait()
+}
+
+// drainInterval is the interval to check if a draining poll can be closed.
+var drainInterval = 100 * time.Millisecond
+
+// drain closes the poll once all the operators allocated from it are freed.
+func (m *manager) drain(poll Poll) {
+	counter, ok := poll.(operatorCounter)
+	for ok && counter.numOperators() > 0 {
+		time.Sleep(drainInterval)
+	}
+	m.drainMu.Lock()
+	defer m.drainMu.Unlock()
+	for i, p := range m.draining {
+		if p == poll {
+			// it's not closed by others yet
+			m.draining = append(m.draining[:i], m.draining[i+1:]...)
+			if err := poll.Close(); err != nil {
+				logger.Error("poller close failed", "err", err)
+			}
+			return
+		}
+	}
+}
+
+// isDraining reports whether the poll is removed by shrinking.
+func (m *manager) isDraining(poll Poll) bool {
+	m.drainMu.Lock()
+	defer m.drainMu.Unlock()
+	for _, p := range m.draining {
+		if p == poll {
+			return true
+		}
+	}
+	return false
+}
+
+// closeDraining closes the draining polls immediately.
+func (m *manager) closeDraining() (err error) {
+	m.drainMu.Lock()
+	defer m.drainMu.Unlock()
+	for _, poll := range m.draining {
+		err = poll.Close()
+	}
+	m.draining = nil
+	return err
+}
+
+// openPoll opens a poll of the engine, and tunes the wait of it.
+func (m *manager) openPoll() (Poll, error) {
+	if m.engine == IOUringEngine {
+		poll, err := openIOUringPoll()
+		if err == nil {
+			return poll, nil
+		}
+		logger.Warn("io_uring is unavailable, fall back to default poller", "err", err)
+	}
+	poll, err := openPoll()
+	if err != nil {
+		return nil, err
+	}
+	if tuner, ok := poll.(waitTuner); ok {
+		tuner.tuneWait(m.batch, m.spin)
+	}
+	return poll, nil
+}
+
 // Reset pollers, this operation is very dangerous, please make sure to do this when calling !
 func (m *manager) Reset() error {
 	for _, poll := range m.polls {
@@ -128,10 +306,15 @@ func (m *manager) Reset() error {
 
 // Pick will select the poller for use each time based on the LoadBalance.
 func (m *manager) Pick() Poll {
+	return m.pick(-1)
+}
+
+// pick selects the poller to register fd.
+func (m *manager) pick(fd int) Poll {
 START:
 	// fast path
 	if atomic.LoadInt32(&m.status) == managerInitialized {
-		return m.balance.Pick()
+		return m.balance.Pick(fd)
 	}
 	// slow path
 	// try to get initializing lock failed, wait others finished the init work, and try again
@@ -148,5 +331,5 @@ START:
 		// SetNumLoops called during m.Run() which cause CAS failed
 		// The polls will be adjusted next Pick
 	}
-	return m.balance.Pick()
+	return m.balance.Pick(fd)
 }
@@ -20,6 +20,7 @@ import (
 	"runtime"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestPollManager(t *testing.T) {
@@ -88,3 +89,78 @@ func TestPollManagerSetNumLoops(t *testing.T) {
 	wg.Wait()
 	close(finish)
 }
+
+func TestPollManagerLeastConnections(t *testing.T) {
+	pm := newManager(2)
+	MustNil(t, pm.SetLoadBalance(LeastConnections))
+	defer pm.Close()
+
+	p0 := pm.Pick()
+	op := p0.Alloc()
+	// the other poll has less connections
+	p1 := pm.Pick()
+	Assert(t, p1 != p0)
+	op1 := p1.Alloc()
+	op2 := p1.Alloc()
+	Equal(t, pm.Pick(), p0)
+
+	op.Free()
+	Equal(t, pm.Pick(), p0)
+	op1.Free()
+	op2.Free()
+	Equal(t, pm.Pick(), pm.polls[0])
+}
+
+type lastPollBalancer struct {
+	fds []int
+}
+
+func (b *lastPollBalancer) Pick(fd int, pollers []Poll) Poll {
+	b.fds = append(b.fds, fd)
+	return pollers[len(pollers)-1]
+}
+
+func TestPollManagerCustomLoadBalance(t *testing.T) {
+	pm := newManager(2)
+	Assert(t, pm.SetCustomLoadBalance(nil) != nil)
+	lb := &lastPollBalancer{}
+	MustNil(t, pm.SetCustomLoadBalance(lb))
+	defer pm.Close()
+
+	Equal(t, pm.Pick(), pm.polls[1])
+	Equal(t, pm.pick(10), pm.polls[1])
+	Equal(t, len(lb.fds), 2)
+	Equal(t, lb.fds[0], -1)
+	Equal(t, lb.fds[1], 10)
+
+	// switch back to the built-in load balance
+	MustNil(t, pm.SetLoadBalance(RoundRobin))
+	Equal(t, pm.balance.LoadBalance(), RoundRobin)
+}
+
+func TestPollManagerResize(t *testing.T) {
+	interval := drainInterval
+	drainInterval = 10 * time.Millisecond
+	defer func() { drainInterval = interval }()
+
+	pm := newManager(2)
+	MustNil(t, pm.SetCustomLoadBalance(&lastPollBalancer{}))
+	defer pm.Close()
+	p1 := pm.Pick()
+	op := p1.Alloc()
+
+	// shrink, the removed poll is not closed until drained
+	MustNil(t, pm.SetNumLoops(1))
+	Equal(t, pm.Pick(), pm.polls[0])
+	Equal(t, len(pm.polls), 1)
+	time.Sleep(50 * time.Millisecond)
+	MustTrue(t, pm.isDraining(p1))
+	op.Free()
+	time.Sleep(50 * time.Millisecond)
+	MustTrue(t, !pm.isDraining(p1))
+
+	// grow, the new connections are routed to the new polls
+	MustNil(t, pm.SetNumLoops(3))
+	Equal(t, pm.Pick(), pm.polls[2])
+	Equal(t, len(pm.polls), 3)
+}
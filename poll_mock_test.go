@@ -0,0 +1,203 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import (
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// mockEvent is the event injected into mockPoll.
+type mockEvent struct {
+	fd               int
+	read, write, hup bool
+}
+
+// mockPoll is a Poll whose events are injected by tests and handled by step in the calling goroutine,
+// so that the edge cases of the pollers can be reproduced in a controlled order. Only the events monitored
+// by Control are delivered, the same as the kernel. It's also a pollPicker to be set to options.pollers.
+type mockPoll struct {
+	mu        sync.Mutex
+	operators map[int]*FDOperator
+	readable  map[int]bool
+	writable  map[int]bool
+	queue     []mockEvent
+	barrier   *barrier
+}
+
+var _ Poll = &mockPoll{}
+
+func newMockPoll() *mockPoll {
+	return &mockPoll{
+		operators: map[int]*FDOperator{},
+		readable:  map[int]bool{},
+		writable:  map[int]bool{},
+		barrier:   barrierPool.Get().(*barrier),
+	}
+}
+
+func (p *mockPoll) pick(fd int) Poll {
+	return p
+}
+
+func (p *mockPoll) Wait() error {
+	return nil
+}
+
+func (p *mockPoll) Close() error {
+	return nil
+}
+
+func (p *mockPoll) Trigger() error {
+	return nil
+}
+
+func (p *mockPoll) Control(operator *FDOperator, event PollEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fd := operator.FD
+	if _, ok := p.operators[fd]; !ok && event != PollReadable && event != PollWritable {
+		return syscall.ENOENT
+	}
+	switch event {
+	case PollReadable:
+		operator.inuse()
+		p.operators[fd], p.readable[fd], p.writable[fd] = operator, true, false
+	case PollWritable:
+		operator.inuse()
+		p.operators[fd], p.readable[fd], p.writable[fd] = operator, false, true
+	case PollDetach:
+		delete(p.operators, fd)
+		delete(p.readable, fd)
+		delete(p.writable, fd)
+	case PollR2RW, PollRW2R:
+		p.writable[fd] = event == PollR2RW
+	case PollPauseRead, PollResumeRead:
+		p.readable[fd] = event == PollResumeRead
+	}
+	return nil
+}
+
+func (p *mockPoll) Alloc() (operator *FDOperator) {
+	op := &FDOperator{}
+	op.setPoll(p)
+	return op
+}
+
+func (p *mockPoll) Free(operator *FDOperator) {
+	operator.unused()
+	operator.reset()
+}
+
+// inject queues the event, which is handled by the next step.
+func (p *mockPoll) inject(evt mockEvent) {
+	p.mu.Lock()
+	p.queue = append(p.queue, evt)
+	p.mu.Unlock()
+}
+
+// monitored returns the events monitored for fd.
+func (p *mockPoll) monitored(fd int) (readable, writable bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.readable[fd], p.writable[fd]
+}
+
+// step handles the events queued in order by the event dispatch of the default poller,
+// except that OnHup is called synchronously.
+// It returns the number of events delivered.
+func (p *mockPoll) step() (delivered int) {
+	p.mu.Lock()
+	queue := p.queue
+	p.queue = nil
+	p.mu.Unlock()
+	for _, evt := range queue {
+		p.mu.Lock()
+		operator := p.operators[evt.fd]
+		read := evt.read && p.readable[evt.fd]
+		write := evt.write && p.writable[evt.fd]
+		hup := evt.hup && (p.readable[evt.fd] || p.writable[evt.fd])
+		p.mu.Unlock()
+		if operator == nil || !(read || write || hup) || !operator.do() {
+			continue
+		}
+		delivered++
+		if handleEvent(p, operator, *p.barrier, read, write, hup, false) {
+			operator.Control(PollDetach)
+			operator.done()
+			if operator.OnHup != nil {
+				operator.OnHup(p)
+			}
+			continue
+		}
+		operator.done()
+	}
+	return delivered
+}
+
+func TestMockPoll(t *testing.T) {
+	p := newMockPoll()
+	r, w := GetSysFdPairs()
+	rconn := &connection{}
+	MustNil(t, rconn.init(&netFD{fd: r}, &options{pollers: p, maxInput: 4}))
+	readable, writable := p.monitored(r)
+	MustTrue(t, readable && !writable)
+
+	// nothing is read until the event is delivered
+	_, err := syscall.Write(w, []byte("hello"))
+	MustNil(t, err)
+	Equal(t, rconn.Reader().Len(), 0)
+	p.inject(mockEvent{fd: r, read: true})
+	Equal(t, p.step(), 1)
+	Equal(t, rconn.Reader().Len(), 5)
+
+	// reading is paused once the input buffer is full, so the readable event is not delivered
+	readable, _ = p.monitored(r)
+	MustTrue(t, !readable)
+	_, err = syscall.Write(w, []byte("world"))
+	MustNil(t, err)
+	p.inject(mockEvent{fd: r, read: true})
+	Equal(t, p.step(), 0)
+	Equal(t, rconn.Reader().Len(), 5)
+
+	// consuming the data resumes reading
+	MustNil(t, rconn.Reader().Skip(5))
+	MustNil(t, rconn.Reader().Release())
+	readable, _ = p.monitored(r)
+	MustTrue(t, readable)
+	p.inject(mockEvent{fd: r, read: true})
+	Equal(t, p.step(), 1)
+	Equal(t, rconn.Reader().Len(), 5)
+
+	// the data left is read before hanging up, and the hup without data closes the connection
+	_, err = syscall.Write(w, []byte("!"))
+	MustNil(t, err)
+	MustNil(t, syscall.Close(w))
+	MustNil(t, rconn.Reader().Skip(5))
+	MustNil(t, rconn.Reader().Release())
+	p.inject(mockEvent{fd: r, read: true, hup: true})
+	Equal(t, p.step(), 1)
+	MustTrue(t, rconn.IsActive())
+	Equal(t, rconn.Reader().Len(), 1)
+	p.inject(mockEvent{fd: r, hup: true})
+	Equal(t, p.step(), 1)
+	MustTrue(t, !rconn.IsActive())
+	readable, writable = p.monitored(r)
+	MustTrue(t, !readable && !writable)
+	rconn.Close()
+}
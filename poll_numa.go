@@ -0,0 +1,63 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package netpoll
+
+import "sync/atomic"
+
+// numaLoadBalance is the LoadBalance of the load balancer set by SetNUMANodes.
+const numaLoadBalance LoadBalance = -2
+
+// newNUMALB creates the load balancer of the pollers grouped by the NUMA nodes,
+// where the i-th poller belongs to the node i%len(nodes), see WithNUMA.
+func newNUMALB(nodes [][]int, polls []Poll) loadbalance {
+	b := &numaLB{nodes: len(nodes), cpuNode: make(map[int]int)}
+	for node, cpus := range nodes {
+		for _, cpu := range cpus {
+			b.cpuNode[cpu] = node
+		}
+	}
+	b.Rebalance(polls)
+	return b
+}
+
+// numaLB picks the pollers on the node of the cpu which received the connection, namely the node of the NIC
+// if its interrupts are handled locally, so that the connection is not handled across the nodes.
+// The pollers of the same node are picked in a round-robin fashion.
+type numaLB struct {
+	pollList
+	nodes    int
+	cpuNode  map[int]int // the node of each cpu, which is read only
+	accepted uintptr     // accept counter
+}
+
+func (b *numaLB) LoadBalance() LoadBalance {
+	return numaLoadBalance
+}
+
+func (b *numaLB) Pick(fd int) (poll Poll) {
+	polls := b.load()
+	accepted := int(atomic.AddUintptr(&b.accepted, 1))
+	if fd >= 0 {
+		cpu, err := incomingCPU(fd)
+		if node, ok := b.cpuNode[cpu]; err == nil && ok && node < len(polls) {
+			// the pollers of node are node, node+b.nodes, node+2*b.nodes...
+			n := (len(polls) - node + b.nodes - 1) / b.nodes
+			return polls[node+accepted%n*b.nodes]
+		}
+	}
+	return polls[accepted%len(polls)]
+}
@@ -0,0 +1,349 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pool
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+/* DOC:
+ * Pool keeps the client connections for each remote address, so that they can be reused by the requests.
+ * Pool.Get returns an idle connection or dials a new one, and Close of the returned connection puts it
+ * back into the pool instead of closing it, unless the connection is broken or the pool is full.
+ * Discard closes the returned connection for real, e.g. when the protocol state is unknown after an error.
+ */
+
+var (
+	// ErrPoolClosed is returned by Get after the Pool is closed.
+	ErrPoolClosed = errors.New("pool closed")
+	// ErrPoolExhausted is returned by Get when the connections of the address reach the MaxActive.
+	ErrPoolExhausted = errors.New("pool exhausted")
+)
+
+// Option configures the Pool created by New.
+type Option struct {
+	f func(*options)
+}
+
+type options struct {
+	dialer      netpoll.Dialer
+	dialTimeout time.Duration
+	maxIdle     int
+	maxActive   int
+	idleTimeout time.Duration
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	healthCheck func(conn netpoll.Connection) bool
+}
+
+// WithDialer sets the Dialer to create connections, netpoll.NewDialer() is used by default.
+func WithDialer(dialer netpoll.Dialer) Option {
+	return Option{func(op *options) {
+		op.dialer = dialer
+	}}
+}
+
+// WithDialTimeout sets the timeout of dialing, the default is 1s.
+func WithDialTimeout(timeout time.Duration) Option {
+	return Option{func(op *options) {
+		op.dialTimeout = timeout
+	}}
+}
+
+// WithMaxIdle sets the max number of idle connections kept for each address, the default is 16.
+func WithMaxIdle(maxIdle int) Option {
+	return Option{func(op *options) {
+		op.maxIdle = maxIdle
+	}}
+}
+
+// WithMaxActive sets the max number of connections, idle or in use, to each address. 0 means no limit.
+func WithMaxActive(maxActive int) Option {
+	return Option{func(op *options) {
+		op.maxActive = maxActive
+	}}
+}
+
+// WithIdleTimeout closes the connections idle in the pool longer than timeout. 0 means never expire.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return Option{func(op *options) {
+		op.idleTimeout = timeout
+	}}
+}
+
+// WithDialBackoff makes Get fail fast with the last dial error of an address for a while after dialing fails,
+// the backoff starts from min and doubles on each failure up to max, and it's reset once dialing succeeds.
+func WithDialBackoff(min, max time.Duration) Option {
+	return Option{func(op *options) {
+		op.minBackoff, op.maxBackoff = min, max
+	}}
+}
+
+// WithHealthCheck sets the callback to check an idle connection before it's returned by Get,
+// the connection is closed if check returns false.
+func WithHealthCheck(check func(conn netpoll.Connection) bool) Option {
+	return Option{func(op *options) {
+		op.healthCheck = check
+	}}
+}
+
+// Pool is a pool of client connections for each remote address of a network.
+type Pool struct {
+	network string
+	opts    options
+	mu      sync.Mutex
+	closed  bool
+	addrs   map[string]*addrPool
+	stop    chan struct{}
+}
+
+// addrPool is the pool of an address, guarded by Pool.mu.
+type addrPool struct {
+	idle    []idleConn // reuse the latest idle connection first
+	active  int        // number of the connections, idle or in use
+	backoff time.Duration
+	retry   time.Time // Get fails with lastErr before retry
+	lastErr error
+}
+
+type idleConn struct {
+	conn  netpoll.Connection
+	since time.Time
+}
+
+// New creates a Pool for network.
+func New(network string, opts ...Option) *Pool {
+	p := &Pool{
+		network: network,
+		opts: options{
+			dialTimeout: time.Second,
+			maxIdle:     16,
+		},
+		addrs: make(map[string]*addrPool),
+		stop:  make(chan struct{}),
+	}
+	for _, do := range opts {
+		do.f(&p.opts)
+	}
+	if p.opts.dialer == nil {
+		p.opts.dialer = netpoll.NewDialer()
+	}
+	if p.opts.idleTimeout > 0 {
+		go p.evict(p.opts.idleTimeout)
+	}
+	return p
+}
+
+// Get returns an idle connection to address, or dials a new one if there isn't.
+// Close of the returned connection puts it back into the pool.
+func (p *Pool) Get(address string) (netpoll.Connection, error) {
+	var ap *addrPool
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if ap = p.addrs[address]; ap == nil {
+			ap = &addrPool{}
+			p.addrs[address] = ap
+		}
+		if len(ap.idle) == 0 {
+			break
+		}
+		ic := ap.idle[len(ap.idle)-1]
+		ap.idle[len(ap.idle)-1] = idleConn{}
+		ap.idle = ap.idle[:len(ap.idle)-1]
+		p.mu.Unlock()
+
+		// the health check may take a while, so it's called without the lock.
+		if p.usable(ic) {
+			return &pooledConn{Connection: ic.conn, pool: p, addr: address}, nil
+		}
+		p.mu.Lock()
+		ap.active--
+		p.mu.Unlock()
+		ic.conn.Close()
+	}
+	// p.mu is locked
+	if ap.lastErr != nil && time.Now().Before(ap.retry) {
+		err := ap.lastErr
+		p.mu.Unlock()
+		return nil, err
+	}
+	if p.opts.maxActive > 0 && ap.active >= p.opts.maxActive {
+		p.mu.Unlock()
+		return nil, ErrPoolExhausted
+	}
+	ap.active++
+	p.mu.Unlock()
+
+	conn, err := p.opts.dialer.DialConnection(p.network, address, p.opts.dialTimeout)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		ap.active--
+		if p.opts.minBackoff > 0 {
+			if ap.backoff *= 2; ap.backoff < p.opts.minBackoff {
+				ap.backoff = p.opts.minBackoff
+			} else if ap.backoff > p.opts.maxBackoff && p.opts.maxBackoff > 0 {
+				ap.backoff = p.opts.maxBackoff
+			}
+			ap.retry, ap.lastErr = time.Now().Add(ap.backoff), err
+		}
+		return nil, err
+	}
+	ap.backoff, ap.lastErr = 0, nil
+	if p.closed {
+		ap.active--
+		conn.Close()
+		return nil, ErrPoolClosed
+	}
+	return &pooledConn{Connection: conn, pool: p, addr: address}, nil
+}
+
+// usable reports whether the idle connection can be reused.
+func (p *Pool) usable(ic idleConn) bool {
+	if !ic.conn.IsActive() || ic.conn.Reader().Len() > 0 {
+		return false
+	}
+	if p.opts.idleTimeout > 0 && time.Since(ic.since) > p.opts.idleTimeout {
+		return false
+	}
+	return p.opts.healthCheck == nil || p.opts.healthCheck(ic.conn)
+}
+
+// put puts the connection back into the pool, or closes it if it can't be reused.
+func (p *Pool) put(addr string, conn netpoll.Connection) error {
+	p.mu.Lock()
+	ap := p.addrs[addr]
+	if !p.closed && len(ap.idle) < p.opts.maxIdle && conn.IsActive() && conn.Reader().Len() == 0 {
+		ap.idle = append(ap.idle, idleConn{conn: conn, since: time.Now()})
+		p.mu.Unlock()
+		return nil
+	}
+	ap.active--
+	p.mu.Unlock()
+	return conn.Close()
+}
+
+// discard closes the connection and removes it from the pool.
+func (p *Pool) discard(addr string, conn netpoll.Connection) error {
+	p.mu.Lock()
+	p.addrs[addr].active--
+	p.mu.Unlock()
+	return conn.Close()
+}
+
+// evict closes the expired idle connections periodically.
+func (p *Pool) evict(timeout time.Duration) {
+	tick := timeout / 2
+	if tick < time.Millisecond {
+		tick = time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		var expired []netpoll.Connection
+		p.mu.Lock()
+		for _, ap := range p.addrs {
+			// the idle connections are sorted by the time put back
+			n := 0
+			for n < len(ap.idle) && time.Since(ap.idle[n].since) > timeout {
+				expired = append(expired, ap.idle[n].conn)
+				n++
+			}
+			ap.idle = append(ap.idle[:0], ap.idle[n:]...)
+			ap.active -= n
+		}
+		p.mu.Unlock()
+		for _, conn := range expired {
+			conn.Close()
+		}
+	}
+}
+
+// Stats returns the number of the idle connections and all the connections to address.
+func (p *Pool) Stats(address string) (idle, active int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ap := p.addrs[address]; ap != nil {
+		return len(ap.idle), ap.active
+	}
+	return 0, 0
+}
+
+// Close closes all the idle connections, and the connections in use are closed when they are put back.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.stop)
+	var idle []netpoll.Connection
+	for _, ap := range p.addrs {
+		for _, ic := range ap.idle {
+			idle = append(idle, ic.conn)
+		}
+		ap.active -= len(ap.idle)
+		ap.idle = nil
+	}
+	p.mu.Unlock()
+	for _, conn := range idle {
+		conn.Close()
+	}
+	return nil
+}
+
+// Discard closes the connection returned by Pool.Get instead of putting it back,
+// it's the same as Close for the other connections.
+func Discard(conn netpoll.Connection) error {
+	if pc, ok := conn.(*pooledConn); ok {
+		if !atomic.CompareAndSwapInt32(&pc.released, 0, 1) {
+			return nil
+		}
+		return pc.pool.discard(pc.addr, pc.Connection)
+	}
+	return conn.Close()
+}
+
+// pooledConn is the connection returned by Pool.Get, which is put back into the pool by Close.
+type pooledConn struct {
+	netpoll.Connection
+	pool     *Pool
+	addr     string
+	released int32
+}
+
+// Close implements netpoll.Connection.
+func (c *pooledConn) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.released, 0, 1) {
+		return nil
+	}
+	return c.pool.put(c.addr, c.Connection)
+}
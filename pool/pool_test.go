@@ -0,0 +1,248 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package pool
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func MustNil(t *testing.T, val interface{}) {
+	t.Helper()
+	if val != nil {
+		t.Fatal("assertion nil failed, val=", val)
+	}
+}
+
+func Equal(t *testing.T, got, expect interface{}) {
+	t.Helper()
+	if got != expect {
+		t.Fatalf("assertion equal failed, got=[%v], expect=[%v]", got, expect)
+	}
+}
+
+// listen serves the connections until the listener is closed, and returns the accepted connections.
+func listen(t *testing.T) (net.Listener, chan net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	conns := make(chan net.Conn, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return ln, conns
+}
+
+func TestPoolReuse(t *testing.T) {
+	ln, _ := listen(t)
+	addr := ln.Addr().String()
+	p := New("tcp", WithMaxIdle(1))
+	defer p.Close()
+
+	conn1, err := p.Get(addr)
+	MustNil(t, err)
+	conn2, err := p.Get(addr)
+	MustNil(t, err)
+	local := conn1.LocalAddr().String()
+	idle, active := p.Stats(addr)
+	Equal(t, idle, 0)
+	Equal(t, active, 2)
+
+	// only one is kept by MaxIdle
+	MustNil(t, conn1.Close())
+	MustNil(t, conn2.Close())
+	Equal(t, conn2.IsActive(), false)
+	idle, active = p.Stats(addr)
+	Equal(t, idle, 1)
+	Equal(t, active, 1)
+
+	conn, err := p.Get(addr)
+	MustNil(t, err)
+	Equal(t, conn.LocalAddr().String(), local)
+	MustNil(t, Discard(conn))
+	Equal(t, conn.IsActive(), false)
+	idle, active = p.Stats(addr)
+	Equal(t, idle, 0)
+	Equal(t, active, 0)
+}
+
+func TestPoolBrokenConnection(t *testing.T) {
+	ln, conns := listen(t)
+	addr := ln.Addr().String()
+	healthy := true
+	p := New("tcp", WithHealthCheck(func(conn netpoll.Connection) bool {
+		return healthy
+	}))
+	defer p.Close()
+
+	// closed by peer when it's idle
+	conn, err := p.Get(addr)
+	MustNil(t, err)
+	local := conn.LocalAddr().String()
+	MustNil(t, conn.Close())
+	(<-conns).Close()
+	for i := 0; i < 100 && conn.IsActive(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	Equal(t, conn.IsActive(), false)
+	conn, err = p.Get(addr)
+	MustNil(t, err)
+	Equal(t, conn.LocalAddr().String() != local, true)
+	_, active := p.Stats(addr)
+	Equal(t, active, 1)
+
+	// closed by peer when it's in use
+	(<-conns).Close()
+	for i := 0; i < 100 && conn.IsActive(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	MustNil(t, conn.Close())
+	idle, active := p.Stats(addr)
+	Equal(t, idle, 0)
+	Equal(t, active, 0)
+	conn, err = p.Get(addr)
+	MustNil(t, err)
+
+	// failed to check health
+	local = conn.LocalAddr().String()
+	MustNil(t, conn.Close())
+	healthy = false
+	conn, err = p.Get(addr)
+	MustNil(t, err)
+	Equal(t, conn.LocalAddr().String() != local, true)
+	conn.Close()
+}
+
+func TestPoolMaxActive(t *testing.T) {
+	ln, _ := listen(t)
+	addr := ln.Addr().String()
+	p := New("tcp", WithMaxActive(1))
+	defer p.Close()
+
+	conn, err := p.Get(addr)
+	MustNil(t, err)
+	_, err = p.Get(addr)
+	Equal(t, err, ErrPoolExhausted)
+	MustNil(t, conn.Close())
+	conn, err = p.Get(addr)
+	MustNil(t, err)
+	conn.Close()
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	ln, _ := listen(t)
+	addr := ln.Addr().String()
+	p := New("tcp", WithIdleTimeout(50*time.Millisecond))
+	defer p.Close()
+
+	conn, err := p.Get(addr)
+	MustNil(t, err)
+	MustNil(t, conn.Close())
+	idle, _ := p.Stats(addr)
+	Equal(t, idle, 1)
+	time.Sleep(200 * time.Millisecond)
+	idle, active := p.Stats(addr)
+	Equal(t, idle, 0)
+	Equal(t, active, 0)
+	Equal(t, conn.IsActive(), false)
+}
+
+func TestPoolTinyIdleTimeout(t *testing.T) {
+	ln, _ := listen(t)
+	addr := ln.Addr().String()
+	// the evicting tick is clamped, so it doesn't panic by a zero interval
+	p := New("tcp", WithIdleTimeout(time.Nanosecond))
+	defer p.Close()
+
+	conn, err := p.Get(addr)
+	MustNil(t, err)
+	MustNil(t, conn.Close())
+	time.Sleep(50 * time.Millisecond)
+	idle, _ := p.Stats(addr)
+	Equal(t, idle, 0)
+}
+
+func TestPoolDialBackoff(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	dials := 0
+	p := New("tcp", WithDialBackoff(100*time.Millisecond, time.Second),
+		WithDialer(dialerFunc(func(network, address string, timeout time.Duration) (netpoll.Connection, error) {
+			dials++
+			return netpoll.DialConnection(network, address, timeout)
+		})))
+	defer p.Close()
+
+	_, err1 := p.Get(addr)
+	_, err2 := p.Get(addr)
+	Equal(t, err1 != nil, true)
+	Equal(t, err2, err1)
+	Equal(t, dials, 1)
+	time.Sleep(150 * time.Millisecond)
+	_, err = p.Get(addr)
+	Equal(t, err != nil, true)
+	Equal(t, dials, 2)
+}
+
+func TestPoolClose(t *testing.T) {
+	ln, _ := listen(t)
+	addr := ln.Addr().String()
+	p := New("tcp")
+
+	conn1, err := p.Get(addr)
+	MustNil(t, err)
+	conn2, err := p.Get(addr)
+	MustNil(t, err)
+	MustNil(t, conn1.Close())
+	MustNil(t, p.Close())
+	Equal(t, conn1.IsActive(), false)
+	// closed when it's put back
+	Equal(t, conn2.IsActive(), true)
+	MustNil(t, conn2.Close())
+	Equal(t, conn2.IsActive(), false)
+	_, err = p.Get(addr)
+	Equal(t, err, ErrPoolClosed)
+	_, active := p.Stats(addr)
+	Equal(t, active, 0)
+}
+
+type dialerFunc func(network, address string, timeout time.Duration) (netpoll.Connection, error)
+
+func (f dialerFunc) DialConnection(network, address string, timeout time.Duration) (netpoll.Connection, error) {
+	return f(network, address, timeout)
+}
+
+func (f dialerFunc) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	return f(network, address, timeout)
+}
+
+func (f dialerFunc) DialContext(ctx context.Context, network, address string) (netpoll.Connection, error) {
+	return f(network, address, 0)
+}
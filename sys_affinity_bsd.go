@@ -0,0 +1,37 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package netpoll
+
+// allowedCPUs is not supported since there is no sched_getaffinity on bsd systems.
+func allowedCPUs() (cpus []int, err error) {
+	return nil, Exception(ErrUnsupported, "sched_getaffinity")
+}
+
+// setThreadAffinity is not supported since there is no sched_setaffinity on bsd systems.
+func setThreadAffinity(cpus ...int) error {
+	return Exception(ErrUnsupported, "sched_setaffinity")
+}
+
+// numaNodes is not supported since the NUMA topology is not exposed by sysfs on bsd systems.
+func numaNodes() (nodes [][]int, err error) {
+	return nil, Exception(ErrUnsupported, "NUMA")
+}
+
+// incomingCPU is not supported since there is no SO_INCOMING_CPU on bsd systems.
+func incomingCPU(fd int) (int, error) {
+	return -1, Exception(ErrUnsupported, "SO_INCOMING_CPU")
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// allowedCPUs returns the cpus which the process is allowed to run on.
+func allowedCPUs() (cpus []int, err error) {
+	var set unix.CPUSet
+	if err = unix.SchedGetaffinity(0, &set); err != nil {
+		return nil, os.NewSyscallError("sched_getaffinity", err)
+	}
+	for cpu := 0; len(cpus) < set.Count(); cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// setThreadAffinity pins the current thread to cpus, the caller must lock the goroutine to the thread.
+func setThreadAffinity(cpus ...int) error {
+	var set unix.CPUSet
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	return os.NewSyscallError("sched_setaffinity", unix.SchedSetaffinity(0, &set))
+}
+
+// numaNodes returns the allowed cpus of each NUMA node which has any, by the order of the node number.
+// All the allowed cpus are returned as one node if the system doesn't expose the NUMA topology.
+func numaNodes() (nodes [][]int, err error) {
+	allowed, err := allowedCPUs()
+	if err != nil {
+		return nil, err
+	}
+	isAllowed := make(map[int]bool, len(allowed))
+	for _, cpu := range allowed {
+		isAllowed[cpu] = true
+	}
+	paths, _ := filepath.Glob("/sys/devices/system/node/node[0-9]*/cpulist")
+	sort.Slice(paths, func(i, j int) bool {
+		return nodeNumber(paths[i]) < nodeNumber(paths[j])
+	})
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cpus, err := parseCPUList(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("parse %s failed: %w", path, err)
+		}
+		var node []int
+		for _, cpu := range cpus {
+			if isAllowed[cpu] {
+				node = append(node, cpu)
+			}
+		}
+		if len(node) > 0 {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		nodes = [][]int{allowed}
+	}
+	return nodes, nil
+}
+
+// nodeNumber returns the number of the node directory in path, e.g. 1 of /sys/devices/system/node/node1/cpulist.
+func nodeNumber(path string) int {
+	n, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(filepath.Dir(path)), "node"))
+	return n
+}
+
+// parseCPUList parses the cpu list format of sysfs, e.g. "0-3,8,10-11".
+func parseCPUList(list string) (cpus []int, err error) {
+	if list == "" {
+		return nil, nil
+	}
+	for _, part := range strings.Split(list, ",") {
+		lo, hi, found := strings.Cut(part, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if found {
+			if last, err = strconv.Atoi(hi); err != nil {
+				return nil, err
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// incomingCPU returns the cpu which processed the packets of the socket last, -1 if unknown.
+func incomingCPU(fd int) (int, error) {
+	cpu, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_INCOMING_CPU)
+	return cpu, os.NewSyscallError("getsockopt", err)
+}
@@ -22,6 +22,9 @@ import (
 
 const EPOLLET = unix.EPOLLET
 
+// epollExclusive is EPOLLEXCLUSIVE, which avoids the thundering herd of the epoll instances watching the same fd.
+const epollExclusive = unix.EPOLLEXCLUSIVE
+
 type epollevent struct {
 	unix.EpollEvent
 }
@@ -21,10 +21,37 @@ func SetKeepAlive(fd, secs int) error {
 	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1); err != nil {
 		return err
 	}
-	switch err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, 0x101, secs); err {
+	switch err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, sysTCP_KEEPINTVL, secs); err {
 	case nil, syscall.ENOPROTOOPT: // OS X 10.7 and earlier don't support this option
 	default:
 		return err
 	}
 	return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPALIVE, secs)
 }
+
+const (
+	sysTCP_KEEPINTVL = 0x101
+	sysTCP_KEEPCNT   = 0x102
+)
+
+// SetKeepAliveConfig enables the keepalive for the connection and sets the idle time and interval in seconds,
+// and the number of unacknowledged probes before the connection is dropped. Non-positive values keep the system defaults.
+func SetKeepAliveConfig(fd, idle, interval, count int) error {
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1); err != nil {
+		return err
+	}
+	if idle > 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPALIVE, idle); err != nil {
+			return err
+		}
+	}
+	if interval > 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, sysTCP_KEEPINTVL, interval); err != nil {
+			return err
+		}
+	}
+	if count > 0 {
+		return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, sysTCP_KEEPCNT, count)
+	}
+	return nil
+}
@@ -14,8 +14,16 @@
 
 package netpoll
 
+import "syscall"
+
 // SetKeepAlive sets the keepalive for the connection
 func SetKeepAlive(fd, secs int) error {
 	// OpenBSD has no user-settable per-socket TCP keepalive options.
 	return nil
 }
+
+// SetKeepAliveConfig only enables the keepalive for the connection,
+// since OpenBSD has no user-settable per-socket TCP keepalive options.
+func SetKeepAliveConfig(fd, idle, interval, count int) error {
+	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1)
+}
@@ -35,3 +35,25 @@ func SetKeepAlive(fd, secs int) error {
 	// tcp_keepalive_time
 	return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, secs)
 }
+
+// SetKeepAliveConfig enables the keepalive for the connection and sets the idle time and interval in seconds,
+// and the number of unacknowledged probes before the connection is dropped. Non-positive values keep the system defaults.
+func SetKeepAliveConfig(fd, idle, interval, count int) error {
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1); err != nil {
+		return err
+	}
+	if idle > 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, idle); err != nil {
+			return err
+		}
+	}
+	if interval > 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, interval); err != nil {
+			return err
+		}
+	}
+	if count > 0 {
+		return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, count)
+	}
+	return nil
+}
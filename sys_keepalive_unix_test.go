@@ -0,0 +1,64 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build netbsd || freebsd || dragonfly || linux
+
+package netpoll
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTCPKeepAlive(t *testing.T) {
+	network, address := "tcp", getTestAddress()
+	accepted := make(chan Connection, 1)
+	loop := newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			return nil
+		},
+		WithOnConnect(func(ctx context.Context, connection Connection) context.Context {
+			accepted <- connection
+			return ctx
+		}),
+		WithTCPKeepAlive(10*time.Second, 3*time.Second, 4),
+	)
+	getsockopt := func(fd, level, opt int) int {
+		v, err := syscall.GetsockoptInt(fd, level, opt)
+		MustNil(t, err)
+		return v
+	}
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	svr := (<-accepted).(Conn)
+	Equal(t, getsockopt(svr.Fd(), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE), 1)
+	Equal(t, getsockopt(svr.Fd(), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE), 10)
+	Equal(t, getsockopt(svr.Fd(), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL), 3)
+	Equal(t, getsockopt(svr.Fd(), syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT), 4)
+
+	// the durations are rounded up to seconds, and non-positive values are ignored
+	fd := conn.(Conn).Fd()
+	count := getsockopt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT)
+	MustNil(t, conn.(SocketTuner).SetTCPKeepAlive(1500*time.Millisecond, time.Second, 0))
+	Equal(t, getsockopt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE), 1)
+	Equal(t, getsockopt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE), 2)
+	Equal(t, getsockopt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL), 1)
+	Equal(t, getsockopt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT), count)
+
+	MustNil(t, conn.Close())
+	MustNil(t, loop.Shutdown(context.Background()))
+}
@@ -0,0 +1,172 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The constants of linux/tls.h, which are not provided by x/sys/unix.
+const (
+	tlsTX = 1
+	tlsRX = 2
+
+	tlsCipherAESGCM128        = 51
+	tlsCipherAESGCM256        = 52
+	tlsCipherChaCha20Poly1305 = 54
+)
+
+// ktlsCipher is the layout of struct tls12_crypto_info_* of a cipher, which is
+// struct tls_crypto_info followed by iv, key, salt and rec_seq.
+type ktlsCipher struct {
+	typ     uint16
+	ivSize  int
+	keySize int
+	salt    int
+}
+
+var (
+	ktlsAESGCM128        = &ktlsCipher{typ: tlsCipherAESGCM128, ivSize: 8, keySize: 16, salt: 4}
+	ktlsAESGCM256        = &ktlsCipher{typ: tlsCipherAESGCM256, ivSize: 8, keySize: 32, salt: 4}
+	ktlsChaCha20Poly1305 = &ktlsCipher{typ: tlsCipherChaCha20Poly1305, ivSize: 12, keySize: 32}
+)
+
+func ktlsCipherOf(suite uint16) *ktlsCipher {
+	switch suite {
+	case tls.TLS_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256:
+		return ktlsAESGCM128
+	case tls.TLS_AES_256_GCM_SHA384, tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384:
+		return ktlsAESGCM256
+	case tls.TLS_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256:
+		return ktlsChaCha20Poly1305
+	}
+	return nil
+}
+
+// cryptoInfo builds the struct tls12_crypto_info_* of key.
+func (c *ktlsCipher) cryptoInfo(version uint16, key *KernelTLSKey) ([]byte, error) {
+	if len(key.Key) != c.keySize {
+		return nil, Exception(syscall.EINVAL, "kernel TLS key size")
+	}
+	info := make([]byte, 4+c.ivSize+c.keySize+c.salt+8)
+	*(*uint16)(unsafe.Pointer(&info[0])) = version
+	*(*uint16)(unsafe.Pointer(&info[2])) = c.typ
+	iv, k := info[4:4+c.ivSize], info[4+c.ivSize:4+c.ivSize+c.keySize]
+	salt, seq := info[4+c.ivSize+c.keySize:len(info)-8], info[len(info)-8:]
+	switch {
+	case len(key.IV) == c.salt+c.ivSize:
+		copy(salt, key.IV)
+		copy(iv, key.IV[c.salt:])
+	case version == tls.VersionTLS12 && c.salt > 0 && len(key.IV) == c.salt:
+		// the explicit nonce of TLS 1.2 is the sequence number
+		copy(salt, key.IV)
+		binary.BigEndian.PutUint64(iv, key.Seq)
+	default:
+		return nil, Exception(syscall.EINVAL, "kernel TLS IV size")
+	}
+	copy(k, key.Key)
+	binary.BigEndian.PutUint64(seq, key.Seq)
+	return info, nil
+}
+
+// setKernelTLS attaches the tls ULP to fd and sets the keys of TX and RX.
+func setKernelTLS(fd int, params *KernelTLSParams) error {
+	if params.Version != tls.VersionTLS12 && params.Version != tls.VersionTLS13 {
+		return Exception(ErrUnsupported, "kernel TLS version")
+	}
+	cipher := ktlsCipherOf(params.CipherSuite)
+	if cipher == nil {
+		return Exception(ErrUnsupported, "kernel TLS cipher suite")
+	}
+	var tx, rx []byte
+	var err error
+	if params.TX != nil {
+		if tx, err = cipher.cryptoInfo(params.Version, params.TX); err != nil {
+			return err
+		}
+	}
+	if params.RX != nil {
+		if rx, err = cipher.cryptoInfo(params.Version, params.RX); err != nil {
+			return err
+		}
+	}
+	// EEXIST means the ULP is attached by the previous call, e.g. enabling RX after TX
+	switch err = unix.SetsockoptString(fd, unix.IPPROTO_TCP, unix.TCP_ULP, "tls"); err {
+	case nil, unix.EEXIST:
+	case unix.ENOENT:
+		return Exception(ErrUnsupported, "kernel TLS module")
+	default:
+		return os.NewSyscallError("setsockopt", err)
+	}
+	for _, opt := range []struct {
+		name int
+		info []byte
+	}{{tlsTX, tx}, {tlsRX, rx}} {
+		if opt.info == nil {
+			continue
+		}
+		switch err = unix.SetsockoptString(fd, unix.SOL_TLS, opt.name, string(opt.info)); err {
+		case nil:
+		case unix.ENOPROTOOPT, unix.EINVAL:
+			// the direction or the cipher is unsupported by the kernel
+			return Exception(ErrUnsupported, "kernel TLS cipher suite")
+		default:
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	return nil
+}
+
+var kernelTLSSupported struct {
+	once      sync.Once
+	supported bool
+}
+
+// KernelTLSSupported reports whether the kernel supports kernel TLS, see KernelTLSEnabler.EnableKernelTLS.
+// It's probed by attaching the tls ULP to a loopback TCP connection once.
+func KernelTLSSupported() bool {
+	kernelTLSSupported.once.Do(func() {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return
+		}
+		defer ln.Close()
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		raw, err := conn.(*net.TCPConn).SyscallConn()
+		if err != nil {
+			return
+		}
+		raw.Control(func(fd uintptr) {
+			err = unix.SetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_ULP, "tls")
+		})
+		kernelTLSSupported.supported = err == nil
+	})
+	return kernelTLSSupported.supported
+}
@@ -0,0 +1,113 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestKernelTLSCryptoInfo(t *testing.T) {
+	key := &KernelTLSKey{Key: make([]byte, 16), IV: []byte{1, 2, 3, 4}, Seq: 7}
+	for i := range key.Key {
+		key.Key[i] = byte(0x10 + i)
+	}
+	info, err := ktlsCipherOf(tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256).cryptoInfo(tls.VersionTLS12, key)
+	MustNil(t, err)
+	// tls_crypto_info, iv, key, salt and rec_seq
+	Equal(t, len(info), 40)
+	Equal(t, string(info[12:28]), string(key.Key))
+	Equal(t, string(info[28:32]), "\x01\x02\x03\x04")
+	// the explicit nonce and the record sequence are both the sequence number
+	Equal(t, string(info[4:12]), "\x00\x00\x00\x00\x00\x00\x00\x07")
+	Equal(t, string(info[32:40]), "\x00\x00\x00\x00\x00\x00\x00\x07")
+
+	// the 12 bytes nonce of TLS 1.3 is split into the salt and the iv
+	key.IV = []byte("0123456789ab")
+	info, err = ktlsCipherOf(tls.TLS_AES_128_GCM_SHA256).cryptoInfo(tls.VersionTLS13, key)
+	MustNil(t, err)
+	Equal(t, string(info[28:32]), "0123")
+	Equal(t, string(info[4:12]), "456789ab")
+
+	// ChaCha20-Poly1305 has no salt
+	key.Key = make([]byte, 32)
+	info, err = ktlsCipherOf(tls.TLS_CHACHA20_POLY1305_SHA256).cryptoInfo(tls.VersionTLS13, key)
+	MustNil(t, err)
+	Equal(t, len(info), 56)
+	Equal(t, string(info[4:16]), "0123456789ab")
+
+	_, err = ktlsCipherOf(tls.TLS_AES_128_GCM_SHA256).cryptoInfo(tls.VersionTLS13, key)
+	MustTrue(t, errors.Is(err, syscall.EINVAL))
+	key.Key, key.IV = make([]byte, 16), []byte{1, 2, 3, 4}
+	_, err = ktlsCipherOf(tls.TLS_AES_128_GCM_SHA256).cryptoInfo(tls.VersionTLS13, key)
+	MustTrue(t, errors.Is(err, syscall.EINVAL))
+	MustTrue(t, ktlsCipherOf(tls.TLS_RSA_WITH_AES_128_CBC_SHA) == nil)
+}
+
+func TestEnableKernelTLS(t *testing.T) {
+	ln, err := CreateListener("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	servers := make(chan Connection, 1)
+	loop, err := NewEventLoop(func(ctx context.Context, connection Connection) error {
+		return nil
+	}, WithOnPrepare(func(connection Connection) context.Context {
+		servers <- connection
+		return context.Background()
+	}))
+	MustNil(t, err)
+	go loop.Serve(ln)
+	defer loop.Shutdown(context.Background())
+
+	client, err := DialConnection("tcp", ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	defer client.Close()
+	server := <-servers
+
+	key := &KernelTLSKey{Key: make([]byte, 16), IV: make([]byte, 12)}
+	params := KernelTLSParams{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256, TX: key}
+	err = client.(KernelTLSEnabler).EnableKernelTLS(KernelTLSParams{Version: tls.VersionTLS13, CipherSuite: tls.TLS_RSA_WITH_AES_128_CBC_SHA, TX: key})
+	MustTrue(t, errors.Is(err, ErrUnsupported))
+	err = client.(KernelTLSEnabler).EnableKernelTLS(KernelTLSParams{Version: tls.VersionTLS10, CipherSuite: tls.TLS_AES_128_GCM_SHA256, TX: key})
+	MustTrue(t, errors.Is(err, ErrUnsupported))
+
+	// the data buffered before is not the plaintext of the session
+	_, err = server.Writer().WriteString("plain")
+	MustNil(t, err)
+	MustNil(t, server.Writer().Flush())
+	_, err = client.Reader().Peek(5)
+	MustNil(t, err)
+	err = client.(KernelTLSEnabler).EnableKernelTLS(KernelTLSParams{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256, RX: key})
+	MustTrue(t, errors.Is(err, syscall.EBUSY))
+	MustNil(t, client.Reader().Skip(5))
+	MustNil(t, client.Reader().Release())
+
+	if !KernelTLSSupported() {
+		MustTrue(t, errors.Is(server.(KernelTLSEnabler).EnableKernelTLS(params), ErrUnsupported))
+		t.Skip("kernel TLS is unsupported")
+	}
+	// the server encrypts by TX, and the client decrypts by RX with the same key
+	MustNil(t, server.(KernelTLSEnabler).EnableKernelTLS(params))
+	MustNil(t, client.(KernelTLSEnabler).EnableKernelTLS(KernelTLSParams{Version: params.Version, CipherSuite: params.CipherSuite, RX: key}))
+	_, err = server.Writer().WriteString("hello kernel TLS")
+	MustNil(t, err)
+	MustNil(t, server.Writer().Flush())
+	s, err := client.Reader().ReadString(16)
+	MustNil(t, err)
+	Equal(t, s, "hello kernel TLS")
+}
@@ -0,0 +1,177 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmsghdr is struct mmsghdr of recvmmsg(2) and sendmmsg(2).
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+}
+
+// recvmmsg wraps the recvmmsg system call, and returns the count of messages received.
+func recvmmsg(fd int, msgs []mmsghdr) (n int, err error) {
+	r, _, e := syscall.RawSyscall6(unix.SYS_RECVMMSG, uintptr(fd), uintptr(unsafe.Pointer(&msgs[0])), uintptr(len(msgs)), 0, 0, 0)
+	if e != 0 {
+		return 0, e
+	}
+	return int(r), nil
+}
+
+// sendmmsg wraps the sendmmsg system call, and returns the count of messages sent.
+func sendmmsg(fd int, msgs []mmsghdr) (n int, err error) {
+	r, _, e := syscall.RawSyscall6(unix.SYS_SENDMMSG, uintptr(fd), uintptr(unsafe.Pointer(&msgs[0])), uintptr(len(msgs)), 0, 0, 0)
+	if e != 0 {
+		return 0, e
+	}
+	return int(r), nil
+}
+
+// putSockaddr writes addr into rsa in the layout of the kernel, and returns its length, 0 if addr is nil.
+func putSockaddr(addr net.Addr, rsa *syscall.RawSockaddrAny) (n uint32, err error) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		if ip4 := a.IP.To4(); ip4 != nil {
+			sa := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+			sa.Family = syscall.AF_INET
+			putPort(&sa.Port, a.Port)
+			copy(sa.Addr[:], ip4)
+			return syscall.SizeofSockaddrInet4, nil
+		}
+		sa := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		sa.Family = syscall.AF_INET6
+		putPort(&sa.Port, a.Port)
+		copy(sa.Addr[:], a.IP.To16())
+		sa.Scope_id = 0
+		if a.Zone != "" {
+			if ifi, err := net.InterfaceByName(a.Zone); err == nil {
+				sa.Scope_id = uint32(ifi.Index)
+			}
+		}
+		return syscall.SizeofSockaddrInet6, nil
+	case *NetlinkAddr:
+		sa := (*syscall.RawSockaddrNetlink)(unsafe.Pointer(rsa))
+		sa.Family = syscall.AF_NETLINK
+		sa.Pid, sa.Groups = a.Pid, a.Groups
+		return syscall.SizeofSockaddrNetlink, nil
+	case *LinkAddr:
+		sa := (*syscall.RawSockaddrLinklayer)(unsafe.Pointer(rsa))
+		if len(a.HardwareAddr) > len(sa.Addr) {
+			return 0, &net.AddrError{Err: "hardware address too long", Addr: a.HardwareAddr.String()}
+		}
+		sa.Family = syscall.AF_PACKET
+		putPort(&sa.Protocol, int(a.Protocol))
+		sa.Ifindex = int32(a.Ifindex)
+		sa.Hatype, sa.Pkttype = 0, 0
+		sa.Halen = uint8(copy(sa.Addr[:], a.HardwareAddr))
+		return syscall.SizeofSockaddrLinklayer, nil
+	case *net.UnixAddr:
+		sa := (*syscall.RawSockaddrUnix)(unsafe.Pointer(rsa))
+		if len(a.Name) >= len(sa.Path) {
+			return 0, &net.AddrError{Err: "unix address too long", Addr: a.Name}
+		}
+		sa.Family = syscall.AF_UNIX
+		path := (*[len(sa.Path)]byte)(unsafe.Pointer(&sa.Path))
+		copy(path[:], a.Name)
+		n = uint32(unsafe.Offsetof(sa.Path)) + uint32(len(a.Name))
+		if len(a.Name) > 0 && a.Name[0] == '@' {
+			// the abstract address is not terminated by NUL
+			path[0] = 0
+		} else {
+			path[len(a.Name)] = 0
+			n++
+		}
+		return n, nil
+	case nil:
+		// for the connected sockets
+		return 0, nil
+	}
+	return 0, &net.AddrError{Err: "unsupported address type", Addr: addr.String()}
+}
+
+// rawToPacketAddr returns a go/net friendly address of the n bytes in rsa received by recvmmsg.
+func rawToPacketAddr(rsa *syscall.RawSockaddrAny, n uint32) net.Addr {
+	switch rsa.Addr.Family {
+	case syscall.AF_INET:
+		sa := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		return &net.UDPAddr{IP: append(net.IP{}, sa.Addr[:]...), Port: getPort(&sa.Port)}
+	case syscall.AF_INET6:
+		sa := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		var zone string
+		if sa.Scope_id != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa.Scope_id)); err == nil {
+				zone = ifi.Name
+			}
+		}
+		return &net.UDPAddr{IP: append(net.IP{}, sa.Addr[:]...), Port: getPort(&sa.Port), Zone: zone}
+	case syscall.AF_UNIX:
+		sa := (*syscall.RawSockaddrUnix)(unsafe.Pointer(rsa))
+		offset := uint32(unsafe.Offsetof(sa.Path))
+		if n <= offset {
+			// the peer is not bound
+			return &net.UnixAddr{Net: "unixgram"}
+		}
+		raw := (*[len(sa.Path)]byte)(unsafe.Pointer(&sa.Path))
+		size := int(n - offset)
+		if size > len(raw) {
+			size = len(raw)
+		}
+		path := append([]byte{}, raw[:size]...)
+		if path[0] == 0 {
+			// abstract address
+			path[0] = '@'
+		} else {
+			for len(path) > 0 && path[len(path)-1] == 0 {
+				path = path[:len(path)-1]
+			}
+		}
+		return &net.UnixAddr{Net: "unixgram", Name: string(path)}
+	case syscall.AF_NETLINK:
+		sa := (*syscall.RawSockaddrNetlink)(unsafe.Pointer(rsa))
+		return &NetlinkAddr{Pid: sa.Pid, Groups: sa.Groups}
+	case syscall.AF_PACKET:
+		sa := (*syscall.RawSockaddrLinklayer)(unsafe.Pointer(rsa))
+		halen := int(sa.Halen)
+		if halen > len(sa.Addr) {
+			halen = len(sa.Addr)
+		}
+		return &LinkAddr{
+			Ifindex:      int(sa.Ifindex),
+			Protocol:     uint16(getPort(&sa.Protocol)),
+			PacketType:   sa.Pkttype,
+			HardwareAddr: append(net.HardwareAddr{}, sa.Addr[:halen]...),
+		}
+	}
+	return nil
+}
+
+// putPort writes port in network byte order.
+func putPort(p *uint16, port int) {
+	b := (*[2]byte)(unsafe.Pointer(p))
+	b[0], b[1] = byte(port>>8), byte(port)
+}
+
+// getPort reads port in network byte order.
+func getPort(p *uint16) int {
+	b := (*[2]byte)(unsafe.Pointer(p))
+	return int(b[0])<<8 | int(b[1])
+}
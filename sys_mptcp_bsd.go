@@ -0,0 +1,25 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package netpoll
+
+// ipprotoMPTCP is the protocol number of MPTCP on Linux, the sockets are refused by bsd systems,
+// so the listeners and the dialers fall back to TCP.
+const ipprotoMPTCP = 262
+
+func getMPTCPInfo(fd int) (*MPTCPInfo, error) {
+	return nil, Exception(ErrUnsupported, "MPTCPInfo")
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ipprotoMPTCP = unix.IPPROTO_MPTCP
+	// mptcpInfo is MPTCP_INFO of SOL_MPTCP, available since Linux 5.16.
+	mptcpInfo = 1
+)
+
+// rawMPTCPInfo is the leading part of struct mptcp_info in linux/mptcp.h.
+type rawMPTCPInfo struct {
+	Subflows           uint8
+	AddAddrSignal      uint8
+	AddAddrAccepted    uint8
+	SubflowsMax        uint8
+	AddAddrSignalMax   uint8
+	AddAddrAcceptedMax uint8
+	Flags              uint32
+	Token              uint32
+	WriteSeq           uint64
+	SndUna             uint64
+	RcvNxt             uint64
+	LocalAddrUsed      uint8
+	LocalAddrMax       uint8
+	CsumEnabled        uint8
+	Retransmits        uint32
+	BytesRetrans       uint64
+	BytesSent          uint64
+	BytesReceived      uint64
+	BytesAcked         uint64
+}
+
+// getMPTCPInfo reads struct mptcp_info, the fields added by the later kernels are zero on the earlier ones.
+// The kernel refuses MPTCP_INFO if the connection is not MPTCP or has fallen back to TCP.
+func getMPTCPInfo(fd int) (*MPTCPInfo, error) {
+	var info rawMPTCPInfo
+	size := uint32(unsafe.Sizeof(info))
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), unix.SOL_MPTCP, mptcpInfo,
+		uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	switch errno {
+	case 0:
+	case unix.EOPNOTSUPP, unix.ENOPROTOOPT:
+		return nil, Exception(ErrUnsupported, "MPTCPInfo on non-multipath connection")
+	default:
+		return nil, os.NewSyscallError("getsockopt", errno)
+	}
+	return &MPTCPInfo{
+		Subflows:           info.Subflows,
+		SubflowsMax:        info.SubflowsMax,
+		AddAddrSignal:      info.AddAddrSignal,
+		AddAddrAccepted:    info.AddAddrAccepted,
+		LocalAddrUsed:      info.LocalAddrUsed,
+		Token:              info.Token,
+		Retransmits:        info.Retransmits,
+		BytesSent:          info.BytesSent,
+		BytesReceived:      info.BytesReceived,
+		BytesRetransmitted: info.BytesRetrans,
+		BytesAcked:         info.BytesAcked,
+	}, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package netpoll
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// setMulticastMembership joins or leaves group on ifi with IP_ADD_MEMBERSHIP or IPV6_JOIN_GROUP.
+// The source-specific multicast is not supported on bsd systems, since the layout of group_source_req varies.
+func setMulticastMembership(fd int, ifi *net.Interface, group, source net.IP, join bool) error {
+	if source != nil {
+		return ErrUnsupported
+	}
+	if group4 := group.To4(); group4 != nil {
+		mreq := &unix.IPMreq{}
+		copy(mreq.Multiaddr[:], group4)
+		addr, err := interfaceAddr4(ifi)
+		if err != nil {
+			return err
+		}
+		mreq.Interface = addr
+		opt := unix.IP_DROP_MEMBERSHIP
+		if join {
+			opt = unix.IP_ADD_MEMBERSHIP
+		}
+		return unix.SetsockoptIPMreq(fd, unix.IPPROTO_IP, opt, mreq)
+	}
+	mreq := &unix.IPv6Mreq{}
+	copy(mreq.Multiaddr[:], group.To16())
+	if ifi != nil {
+		mreq.Interface = uint32(ifi.Index)
+	}
+	opt := unix.IPV6_LEAVE_GROUP
+	if join {
+		opt = unix.IPV6_JOIN_GROUP
+	}
+	return unix.SetsockoptIPv6Mreq(fd, unix.IPPROTO_IPV6, opt, mreq)
+}
+
+// interfaceAddr4 returns the first IPv4 address of ifi, since the IPv4 options select the interface by address.
+// EADDRNOTAVAIL is returned if ifi has no IPv4 address.
+func interfaceAddr4(ifi *net.Interface) (addr [4]byte, err error) {
+	if ifi == nil {
+		return addr, nil
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return addr, err
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok {
+			if ip4 := ipnet.IP.To4(); ip4 != nil {
+				copy(addr[:], ip4)
+				return addr, nil
+			}
+		}
+	}
+	return addr, unix.EADDRNOTAVAIL
+}
+
+// setMulticastInterface sets the interface of the outgoing multicast datagrams, nil ifi lets the system choose.
+func setMulticastInterface(fd int, ipv6 bool, ifi *net.Interface) error {
+	if ipv6 {
+		var index int
+		if ifi != nil {
+			index = ifi.Index
+		}
+		return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_IF, index)
+	}
+	addr, err := interfaceAddr4(ifi)
+	if err != nil {
+		return err
+	}
+	return unix.SetsockoptInet4Addr(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_IF, addr)
+}
+
+// setMulticastTTL sets the TTL, or the hop limit of IPv6, of the outgoing multicast datagrams.
+// IP_MULTICAST_TTL takes an u_char on bsd systems.
+func setMulticastTTL(fd int, ipv6 bool, ttl int) error {
+	if ipv6 {
+		return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, ttl)
+	}
+	return unix.SetsockoptByte(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_TTL, byte(ttl))
+}
+
+// setMulticastLoopback sets whether the outgoing multicast datagrams are looped back to the local sockets.
+// IP_MULTICAST_LOOP takes an u_char on bsd systems.
+func setMulticastLoopback(fd int, ipv6, enabled bool) error {
+	if ipv6 {
+		return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_LOOP, boolint(enabled))
+	}
+	return unix.SetsockoptByte(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_LOOP, byte(boolint(enabled)))
+}
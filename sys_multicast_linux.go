@@ -0,0 +1,103 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// groupSockaddrOffset is the offset of the sockaddr_storage in group_req and group_source_req,
+// which follows the interface index and is aligned as a pointer.
+const groupSockaddrOffset = int(unsafe.Alignof(uintptr(0)))
+
+// sizeofSockaddrStorage is the size of struct sockaddr_storage, which is larger than unix.RawSockaddrAny.
+const sizeofSockaddrStorage = 128
+
+// setMulticastMembership joins or leaves group on ifi with MCAST_JOIN_GROUP, or MCAST_JOIN_SOURCE_GROUP
+// if source is not nil, which are protocol-independent and work for both IPv4 and IPv6.
+func setMulticastMembership(fd int, ifi *net.Interface, group, source net.IP, join bool) error {
+	level := unix.IPPROTO_IPV6
+	if group.To4() != nil {
+		level = unix.IPPROTO_IP
+	}
+	// struct group_req, or struct group_source_req with the source following the group
+	size := groupSockaddrOffset + sizeofSockaddrStorage
+	if source != nil {
+		size += sizeofSockaddrStorage
+	}
+	req := make([]byte, size)
+	if ifi != nil {
+		*(*uint32)(unsafe.Pointer(&req[0])) = uint32(ifi.Index)
+	}
+	putGroupSockaddr(req[groupSockaddrOffset:], group)
+	var opt int
+	if source == nil {
+		opt = unix.MCAST_LEAVE_GROUP
+		if join {
+			opt = unix.MCAST_JOIN_GROUP
+		}
+	} else {
+		putGroupSockaddr(req[groupSockaddrOffset+sizeofSockaddrStorage:], source)
+		opt = unix.MCAST_LEAVE_SOURCE_GROUP
+		if join {
+			opt = unix.MCAST_JOIN_SOURCE_GROUP
+		}
+	}
+	return unix.SetsockoptString(fd, level, opt, string(req))
+}
+
+// putGroupSockaddr writes ip into b as a sockaddr_in or sockaddr_in6.
+func putGroupSockaddr(b []byte, ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(&b[0]))
+		sa.Family = unix.AF_INET
+		copy(sa.Addr[:], ip4)
+		return
+	}
+	sa := (*unix.RawSockaddrInet6)(unsafe.Pointer(&b[0]))
+	sa.Family = unix.AF_INET6
+	copy(sa.Addr[:], ip.To16())
+}
+
+// setMulticastInterface sets the interface of the outgoing multicast datagrams, nil ifi lets the system choose.
+func setMulticastInterface(fd int, ipv6 bool, ifi *net.Interface) error {
+	var index int
+	if ifi != nil {
+		index = ifi.Index
+	}
+	if ipv6 {
+		return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_IF, index)
+	}
+	return unix.SetsockoptIPMreqn(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_IF, &unix.IPMreqn{Ifindex: int32(index)})
+}
+
+// setMulticastTTL sets the TTL, or the hop limit of IPv6, of the outgoing multicast datagrams.
+func setMulticastTTL(fd int, ipv6 bool, ttl int) error {
+	if ipv6 {
+		return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, ttl)
+	}
+	return unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_TTL, ttl)
+}
+
+// setMulticastLoopback sets whether the outgoing multicast datagrams are looped back to the local sockets.
+func setMulticastLoopback(fd int, ipv6, enabled bool) error {
+	if ipv6 {
+		return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_LOOP, boolint(enabled))
+	}
+	return unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_LOOP, boolint(enabled))
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// soOriginalDst is SO_ORIGINAL_DST of linux/netfilter_ipv4.h, which is also IP6T_SO_ORIGINAL_DST of SOL_IPV6.
+const soOriginalDst = 80
+
+// getOriginalDst reads the destination before NAT from the conntrack entry of the connection,
+// or returns the local address if there's none. The IPv4 connections accepted by the IPv6 sockets
+// are tracked by IPv4, so SOL_IP is used for them.
+func getOriginalDst(fd int) (net.Addr, error) {
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return nil, os.NewSyscallError("getsockname", err)
+	}
+	local := sockaddrToAddr(sa)
+	var addr *net.TCPAddr
+	if sa6, ok := sa.(*syscall.SockaddrInet6); ok && net.IP(sa6.Addr[:]).To4() == nil {
+		var raw unix.RawSockaddrInet6
+		if err = getsockoptRaw(fd, syscall.SOL_IPV6, unsafe.Pointer(&raw), unsafe.Sizeof(raw)); err == nil {
+			addr = &net.TCPAddr{IP: append(net.IP(nil), raw.Addr[:]...), Port: rawPort(raw.Port), Zone: local.(*net.TCPAddr).Zone}
+		}
+	} else {
+		var raw unix.RawSockaddrInet4
+		if err = getsockoptRaw(fd, syscall.SOL_IP, unsafe.Pointer(&raw), unsafe.Sizeof(raw)); err == nil {
+			addr = &net.TCPAddr{IP: net.IPv4(raw.Addr[0], raw.Addr[1], raw.Addr[2], raw.Addr[3]), Port: rawPort(raw.Port)}
+		}
+	}
+	switch err {
+	case nil:
+		return addr, nil
+	case syscall.ENOENT, syscall.ENOPROTOOPT:
+		// not NATed, or no conntrack at all
+		return local, nil
+	}
+	return nil, os.NewSyscallError("getsockopt", err)
+}
+
+// getsockoptRaw reads SO_ORIGINAL_DST of level into the raw sockaddr p of size.
+func getsockoptRaw(fd, level int, p unsafe.Pointer, size uintptr) error {
+	n := uint32(size)
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), uintptr(level), soOriginalDst,
+		uintptr(p), uintptr(unsafe.Pointer(&n)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// rawPort converts the port of a raw sockaddr in network byte order.
+func rawPort(port uint16) int {
+	return int(binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&port))[:]))
+}
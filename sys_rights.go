@@ -0,0 +1,58 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly || linux
+
+package netpoll
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxRightsFDs is the max number of file descriptors received by one recvmsg.
+const maxRightsFDs = 64
+
+// recvRights reads the data into bs by recvmsg, and hands the file descriptors passed by SCM_RIGHTS to onRights.
+func recvRights(fd int, bs [][]byte, onRights func(fds []int)) (n int, err error) {
+	oob := make([]byte, unix.CmsgSpace(maxRightsFDs*4))
+	n, oobn, flags, _, err := unix.RecvmsgBuffers(fd, bs, oob, 0)
+	if err != nil || oobn == 0 {
+		return n, err
+	}
+	if flags&unix.MSG_CTRUNC != 0 {
+		logger.Warn("too many fds received by SCM_RIGHTS, some of them are dropped", "fd", fd)
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return n, nil
+	}
+	for i := range msgs {
+		fds, err := unix.ParseUnixRights(&msgs[i])
+		if err != nil || len(fds) == 0 {
+			continue
+		}
+		for _, rfd := range fds {
+			syscall.CloseOnExec(rfd)
+		}
+		onRights(fds)
+	}
+	return n, nil
+}
+
+// sendRights sends the file descriptors by SCM_RIGHTS along with a single zero byte.
+func sendRights(fd int, fds []int) (n int, err error) {
+	return unix.SendmsgN(fd, []byte{0}, unix.UnixRights(fds...), nil, 0)
+}
@@ -17,6 +17,8 @@ package netpoll
 import (
 	"syscall"
 	"unsafe"
+
+	"golang.org/x/sys/unix"
 )
 
 //func init() {
@@ -29,7 +31,8 @@ import (
 //	}
 //}
 
-// sendmsg wraps the sendmsg system call.
+// sendmsg wraps the sendmsg system call, the data is sent by MSG_ZEROCOPY if zerocopy,
+// whose completion is notified by the error queue, see recvZeroCopy.
 // Must len(iovs) >= len(vs)
 func sendmsg(fd int, bs [][]byte, ivs []syscall.Iovec, zerocopy bool) (n int, err error) {
 	iovLen := iovecs(bs, ivs)
@@ -40,7 +43,11 @@ func sendmsg(fd int, bs [][]byte, ivs []syscall.Iovec, zerocopy bool) (n int, er
 		Iov:    &ivs[0],
 		Iovlen: uint64(iovLen),
 	}
-	r, _, e := syscall.RawSyscall(syscall.SYS_SENDMSG, uintptr(fd), uintptr(unsafe.Pointer(&msghdr)), 0)
+	var flags uintptr
+	if zerocopy {
+		flags = unix.MSG_ZEROCOPY
+	}
+	r, _, e := syscall.RawSyscall(syscall.SYS_SENDMSG, uintptr(fd), uintptr(unsafe.Pointer(&msghdr)), flags)
 	resetIovecs(bs, ivs[:iovLen])
 	if e != 0 {
 		return int(r), e
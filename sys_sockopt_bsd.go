@@ -13,6 +13,7 @@ import (
 	"os"
 	"runtime"
 	"syscall"
+	"time"
 )
 
 func setDefaultSockopts(s, family, sotype int, ipv6only bool) error {
@@ -31,3 +32,60 @@ func setDefaultSockopts(s, family, sotype int, ipv6only bool) error {
 	// Allow broadcast.
 	return os.NewSyscallError("setsockopt", syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1))
 }
+
+// setTCPQuickAck is not supported since there is no TCP_QUICKACK on bsd systems.
+func setTCPQuickAck(fd int, b bool) (err error) {
+	return Exception(ErrUnsupported, "TCP_QUICKACK")
+}
+
+// TCP_FASTOPEN of darwin and freebsd, which is not defined on the other bsd systems.
+const (
+	darwinTCPFastOpen  = 0x105
+	freebsdTCPFastOpen = 0x401
+)
+
+// setTCPFastOpen enables TCP Fast Open on the listener socket, the queue length is managed by the system.
+func setTCPFastOpen(fd, queueLen int) (err error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, darwinTCPFastOpen, 1)
+	case "freebsd":
+		return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, freebsdTCPFastOpen, 1)
+	}
+	return Exception(ErrUnsupported, "TCP_FASTOPEN")
+}
+
+// setTCPFastOpenConnect is ignored on bsd systems, which need sendto or connectx to send data in the SYN.
+func setTCPFastOpenConnect(fd int) (err error) {
+	return nil
+}
+
+// setBindToDevice is not supported since there is no SO_BINDTODEVICE on bsd systems.
+func setBindToDevice(fd int, ifname string) (err error) {
+	return Exception(ErrUnsupported, "SO_BINDTODEVICE")
+}
+
+// setTCPDeferAccept is not supported since there is no TCP_DEFER_ACCEPT on bsd systems.
+func setTCPDeferAccept(fd int, timeout time.Duration) (err error) {
+	return Exception(ErrUnsupported, "TCP_DEFER_ACCEPT")
+}
+
+// setFreebind is not supported since there is no IP_FREEBIND on bsd systems.
+func setFreebind(fd int) (err error) {
+	return Exception(ErrUnsupported, "IP_FREEBIND")
+}
+
+// setTransparent is not supported since there is no IP_TRANSPARENT on bsd systems.
+func setTransparent(fd int, ipv6 bool) (err error) {
+	return Exception(ErrUnsupported, "IP_TRANSPARENT")
+}
+
+// setReusePortCPU is not supported since there is no SO_ATTACH_REUSEPORT_CBPF on bsd systems.
+func setReusePortCPU(fd, n int) (err error) {
+	return Exception(ErrUnsupported, "SO_ATTACH_REUSEPORT_CBPF")
+}
+
+// setReusePortProgram is not supported since there is no SO_ATTACH_REUSEPORT_EBPF on bsd systems.
+func setReusePortProgram(fd, progFD int) (err error) {
+	return Exception(ErrUnsupported, "SO_ATTACH_REUSEPORT_EBPF")
+}
@@ -10,6 +10,9 @@ package netpoll
 import (
 	"os"
 	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 func setDefaultSockopts(s, family, sotype int, ipv6only bool) error {
@@ -23,3 +26,67 @@ func setDefaultSockopts(s, family, sotype int, ipv6only bool) error {
 	// Allow broadcast.
 	return os.NewSyscallError("setsockopt", syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1))
 }
+
+// setTCPQuickAck set the TCP_QUICKACK flag on socket
+func setTCPQuickAck(fd int, b bool) (err error) {
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_QUICKACK, boolint(b))
+}
+
+// setTCPFastOpen enables TCP Fast Open on the listener socket with the queue length of pending TFO requests.
+func setTCPFastOpen(fd, queueLen int) (err error) {
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, unix.TCP_FASTOPEN, queueLen)
+}
+
+// setTCPFastOpenConnect defers the connect until the first write, so that the data is sent in the SYN.
+// It's ignored if the kernel doesn't support TCP_FASTOPEN_CONNECT (before 4.11).
+func setTCPFastOpenConnect(fd int) (err error) {
+	err = syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1)
+	if err == syscall.ENOPROTOOPT {
+		return nil
+	}
+	return err
+}
+
+// setBindToDevice binds the socket to the network interface, so that only the packets of it are used.
+func setBindToDevice(fd int, ifname string) (err error) {
+	return os.NewSyscallError("setsockopt", unix.BindToDevice(fd, ifname))
+}
+
+// setTCPDeferAccept wakes up the listener only when the data arrives or the timeout in seconds elapses.
+func setTCPDeferAccept(fd int, timeout time.Duration) (err error) {
+	secs := int((timeout + time.Second - 1) / time.Second)
+	return os.NewSyscallError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_DEFER_ACCEPT, secs))
+}
+
+// setFreebind allows the socket to bind a nonlocal address, IP_FREEBIND also takes effect on the IPv6 sockets.
+func setFreebind(fd int) (err error) {
+	return os.NewSyscallError("setsockopt", syscall.SetsockoptInt(fd, syscall.SOL_IP, syscall.IP_FREEBIND, 1))
+}
+
+// setTransparent allows the socket to accept the connections to the nonlocal addresses redirected by TPROXY.
+func setTransparent(fd int, ipv6 bool) (err error) {
+	if ipv6 {
+		return os.NewSyscallError("setsockopt", syscall.SetsockoptInt(fd, syscall.SOL_IPV6, unix.IPV6_TRANSPARENT, 1))
+	}
+	return os.NewSyscallError("setsockopt", syscall.SetsockoptInt(fd, syscall.SOL_IP, unix.IP_TRANSPARENT, 1))
+}
+
+// skfAdCPU is the ancillary data offset of classic BPF (SKF_AD_OFF + SKF_AD_CPU) to load the current cpu.
+const skfAdCPU = 0xfffff000 + 36
+
+// setReusePortCPU steers the connections of the SO_REUSEPORT group of the socket by the cpu which receives
+// the SYN, the n listeners of the group are selected by the cpu number modulo n.
+func setReusePortCPU(fd, n int) (err error) {
+	prog := []unix.SockFilter{
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: skfAdCPU},
+		{Code: unix.BPF_ALU | unix.BPF_MOD | unix.BPF_K, K: uint32(n)},
+		{Code: unix.BPF_RET | unix.BPF_A},
+	}
+	fprog := &unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_REUSEPORT_CBPF, fprog))
+}
+
+// setReusePortProgram steers the connections of the SO_REUSEPORT group of the socket by the eBPF program.
+func setReusePortProgram(fd, progFD int) (err error) {
+	return os.NewSyscallError("setsockopt", syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_ATTACH_REUSEPORT_EBPF, progFD))
+}
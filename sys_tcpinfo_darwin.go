@@ -0,0 +1,41 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func getTCPInfo(fd int) (*TCPInfo, error) {
+	info, err := unix.GetsockoptTCPConnectionInfo(fd, unix.IPPROTO_TCP, unix.TCP_CONNECTION_INFO)
+	if err != nil {
+		return nil, os.NewSyscallError("getsockopt", err)
+	}
+	// the times are in milliseconds, and the congestion window is in bytes
+	return &TCPInfo{
+		RTT:                time.Duration(info.Srtt) * time.Millisecond,
+		RTTVar:             time.Duration(info.Rttvar) * time.Millisecond,
+		RTO:                time.Duration(info.Rto) * time.Millisecond,
+		TotalRetransmits:   uint32(info.Txretransmitpackets),
+		CongestionWindow:   info.Snd_cwnd,
+		MSS:                info.Maxseg,
+		BytesSent:          info.Txbytes,
+		BytesRetransmitted: info.Txretransmitbytes,
+		BytesReceived:      info.Rxbytes,
+	}, nil
+}
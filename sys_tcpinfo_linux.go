@@ -0,0 +1,44 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func getTCPInfo(fd int) (*TCPInfo, error) {
+	info, err := unix.GetsockoptTCPInfo(fd, unix.IPPROTO_TCP, unix.TCP_INFO)
+	if err != nil {
+		return nil, os.NewSyscallError("getsockopt", err)
+	}
+	// the times are in microseconds, and the congestion window is in segments
+	return &TCPInfo{
+		RTT:                time.Duration(info.Rtt) * time.Microsecond,
+		RTTVar:             time.Duration(info.Rttvar) * time.Microsecond,
+		MinRTT:             time.Duration(info.Min_rtt) * time.Microsecond,
+		RTO:                time.Duration(info.Rto) * time.Microsecond,
+		Retransmits:        info.Retrans,
+		TotalRetransmits:   info.Total_retrans,
+		CongestionWindow:   info.Snd_cwnd * info.Snd_mss,
+		MSS:                info.Snd_mss,
+		DeliveryRate:       info.Delivery_rate,
+		BytesSent:          info.Bytes_sent,
+		BytesRetransmitted: info.Bytes_retrans,
+		BytesReceived:      info.Bytes_received,
+	}, nil
+}
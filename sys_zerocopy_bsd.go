@@ -0,0 +1,27 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package netpoll
+
+// setZeroCopy is not supported since there is no SO_ZEROCOPY on bsd systems.
+func setZeroCopy(fd int) (err error) {
+	return Exception(ErrUnsupported, "SO_ZEROCOPY")
+}
+
+// recvZeroCopy is never called since setZeroCopy is not supported.
+func recvZeroCopy(fd int) (done uint32, ok bool, err error) {
+	return 0, false, nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// setZeroCopy enables SO_ZEROCOPY on the socket, so that the data can be sent by MSG_ZEROCOPY.
+func setZeroCopy(fd int) (err error) {
+	return os.NewSyscallError("setsockopt", syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_ZEROCOPY, 1))
+}
+
+// recvZeroCopy drains the notifications of MSG_ZEROCOPY from the error queue of fd, and returns the count of
+// the sends completed, which is the end of the last range notified, ok is false if there is no notification.
+// The sends of a TCP socket are numbered from 0 and completed in order.
+func recvZeroCopy(fd int) (done uint32, ok bool, err error) {
+	var p [1]byte
+	var oob [128]byte
+	for {
+		_, oobn, _, _, err := syscall.Recvmsg(fd, p[:], oob[:], syscall.MSG_ERRQUEUE)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			return done, ok, nil
+		}
+		if err != nil {
+			return done, ok, os.NewSyscallError("recvmsg", err)
+		}
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return done, ok, os.NewSyscallError("recvmsg", err)
+		}
+		for _, m := range msgs {
+			if !isRecvErr(m.Header) || len(m.Data) < int(unsafe.Sizeof(unix.SockExtendedErr{})) {
+				continue
+			}
+			ee := (*unix.SockExtendedErr)(unsafe.Pointer(&m.Data[0]))
+			if ee.Origin != unix.SO_EE_ORIGIN_ZEROCOPY {
+				return done, ok, syscall.Errno(ee.Errno)
+			}
+			// the sends from ee.Info to ee.Data are completed
+			if end := ee.Data + 1; !ok || int32(end-done) > 0 {
+				done, ok = end, true
+			}
+		}
+	}
+}
+
+func isRecvErr(h syscall.Cmsghdr) bool {
+	return h.Level == syscall.SOL_IP && h.Type == syscall.IP_RECVERR ||
+		h.Level == syscall.SOL_IPV6 && h.Type == syscall.IPV6_RECVERR
+}
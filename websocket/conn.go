@@ -0,0 +1,384 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// OpCode is the opcode of a WebSocket frame.
+type OpCode byte
+
+const (
+	OpContinuation OpCode = 0x0
+	OpText         OpCode = 0x1
+	OpBinary       OpCode = 0x2
+	OpClose        OpCode = 0x8
+	OpPing         OpCode = 0x9
+	OpPong         OpCode = 0xa
+)
+
+func (op OpCode) isControl() bool {
+	return op&0x8 != 0
+}
+
+// The status codes of the close frames, see RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure      = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatusReceived   = 1005
+	CloseInvalidPayloadData = 1007
+	CloseMessageTooBig      = 1009
+)
+
+const (
+	// maxControlPayload limits the payload of the control frames.
+	maxControlPayload = 125
+	// defaultMaxMessageSize limits the messages read by ReadMessage, see Conn.SetMaxMessageSize.
+	defaultMaxMessageSize = 4 << 20
+)
+
+var (
+	// ErrProtocol is returned by ReadMessage if the peer violates the protocol, the connection is closed after that.
+	ErrProtocol = errors.New("websocket: protocol error")
+	// ErrMessageTooLarge is returned by ReadMessage if the message exceeds the limit, the connection is closed after that.
+	ErrMessageTooLarge = errors.New("websocket: message too large")
+	// ErrCloseSent is returned by writing a message after the close frame is sent.
+	ErrCloseSent = errors.New("websocket: close frame has been sent")
+)
+
+// CloseError is returned by ReadMessage once the close frame is received, which has been replied already.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// Conn reads and writes the WebSocket messages over a netpoll.Connection after the handshake.
+// ReadMessage must be called by one goroutine at a time, while the writes are safe to be called concurrently.
+type Conn struct {
+	conn      netpoll.Connection
+	server    bool
+	maxSize   int
+	wmu       sync.Mutex // serializes the frames written to conn
+	closeSent bool
+}
+
+// NewConn creates a Conn over conn. The server side requires the frames from the peer to be masked
+// and writes the frames unmasked, and the client side does the opposite.
+func NewConn(conn netpoll.Connection, server bool) *Conn {
+	return &Conn{conn: conn, server: server, maxSize: defaultMaxMessageSize}
+}
+
+// SetMaxMessageSize limits the size of the messages read by ReadMessage, which is 4MB by default.
+func (c *Conn) SetMaxMessageSize(n int) {
+	c.maxSize = n
+}
+
+// ReadMessage reads the next data message, whose opcode is OpText or OpBinary. The pings are replied by pongs
+// and the pongs are ignored meanwhile. If the close frame is received, it's replied and *CloseError is returned.
+//
+// The payload of an unfragmented message is sliced from the input buffer of the connection and unmasked in place,
+// so it's not copied at all, while the fragments of a message are copied into one buffer. The payload must be
+// released by the caller once it's no longer used.
+func (c *Conn) ReadMessage() (op OpCode, payload netpoll.Reader, err error) {
+	op, payload, err = c.readMessage()
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrProtocol):
+			c.writeClose(CloseProtocolError, "")
+			c.conn.Close()
+		case errors.Is(err, ErrMessageTooLarge):
+			c.writeClose(CloseMessageTooBig, "")
+			c.conn.Close()
+		}
+		return 0, nil, err
+	}
+	return op, payload, nil
+}
+
+func (c *Conn) readMessage() (op OpCode, payload netpoll.Reader, err error) {
+	var buf *netpoll.LinkBuffer // the fragments of the message
+	for {
+		fin, fop, p, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch {
+		case fop.isControl():
+			if err = c.handleControl(fop, p); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case fop == OpContinuation:
+			if buf == nil {
+				return 0, nil, fmt.Errorf("%w: unexpected continuation frame", ErrProtocol)
+			}
+		case fop == OpText || fop == OpBinary:
+			if buf != nil {
+				return 0, nil, fmt.Errorf("%w: expect continuation frame", ErrProtocol)
+			}
+			op = fop
+			if fin {
+				return op, p, validPayload(op, p)
+			}
+			buf = netpoll.NewLinkBuffer()
+		default:
+			return 0, nil, fmt.Errorf("%w: unknown opcode %d", ErrProtocol, fop)
+		}
+		if buf.Len()+p.Len() > c.maxSize {
+			return 0, nil, ErrMessageTooLarge
+		}
+		b, _ := buf.Malloc(p.Len())
+		netpoll.ReadBinaryTo(p, b)
+		p.Release()
+		buf.Flush()
+		if fin {
+			return op, buf, validPayload(op, buf)
+		}
+	}
+}
+
+// readFrame reads a frame and slices its payload, which is unmasked in place.
+func (c *Conn) readFrame() (fin bool, op OpCode, payload netpoll.Reader, err error) {
+	reader := c.conn.Reader()
+	hdr, err := reader.Next(2)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = hdr[0]&0x80 != 0, OpCode(hdr[0]&0x0f)
+	masked, n := hdr[1]&0x80 != 0, uint64(hdr[1]&0x7f)
+	if hdr[0]&0x70 != 0 {
+		return false, 0, nil, fmt.Errorf("%w: reserved bits are set", ErrProtocol)
+	}
+	if masked != c.server {
+		return false, 0, nil, fmt.Errorf("%w: unexpected mask", ErrProtocol)
+	}
+	switch n {
+	case 126:
+		ext, err := reader.Next(2)
+		if err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext, err := reader.Next(8)
+		if err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext)
+	}
+	if op.isControl() && (!fin || n > maxControlPayload) {
+		return false, 0, nil, fmt.Errorf("%w: invalid control frame", ErrProtocol)
+	}
+	if n > uint64(c.maxSize) {
+		return false, 0, nil, ErrMessageTooLarge
+	}
+	var mask [4]byte
+	if masked {
+		key, err := reader.Next(4)
+		if err != nil {
+			return false, 0, nil, err
+		}
+		copy(mask[:], key)
+	}
+	if payload, err = reader.Slice(int(n)); err != nil {
+		return false, 0, nil, err
+	}
+	if masked && n > 0 {
+		vs, _ := netpoll.PeekVec(payload, int(n))
+		pos := 0
+		for _, v := range vs {
+			for i := range v {
+				v[i] ^= mask[(pos+i)&3]
+			}
+			pos += len(v)
+		}
+	}
+	return fin, op, payload, nil
+}
+
+// handleControl replies the ping and close frames.
+func (c *Conn) handleControl(op OpCode, payload netpoll.Reader) error {
+	defer payload.Release()
+	data, _ := payload.Next(payload.Len())
+	switch op {
+	case OpPing:
+		c.wmu.Lock()
+		defer c.wmu.Unlock()
+		if c.closeSent {
+			return nil
+		}
+		return c.writeFrame(OpPong, data)
+	case OpClose:
+		closeErr := &CloseError{Code: CloseNoStatusReceived}
+		switch {
+		case len(data) == 1:
+			return fmt.Errorf("%w: invalid close frame", ErrProtocol)
+		case len(data) >= 2:
+			closeErr.Code = int(binary.BigEndian.Uint16(data))
+			closeErr.Text = string(data[2:])
+			if !utf8.ValidString(closeErr.Text) {
+				return fmt.Errorf("%w: invalid close reason", ErrProtocol)
+			}
+		}
+		if closeErr.Code == CloseNoStatusReceived {
+			c.writeClose(0, "")
+		} else {
+			c.writeClose(closeErr.Code, "")
+		}
+		return closeErr
+	case OpPong:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown opcode %d", ErrProtocol, op)
+}
+
+// WriteMessage writes payload as a message of a single frame. The server side writes payload without copying
+// if it's large enough, so it must not be modified until WriteMessage returns.
+func (c *Conn) WriteMessage(op OpCode, payload []byte) error {
+	if op == OpClose || op == OpContinuation {
+		return fmt.Errorf("websocket: invalid opcode %d, use WriteClose to close", op)
+	}
+	if op.isControl() && len(payload) > maxControlPayload {
+		return fmt.Errorf("websocket: control frame of %d bytes", len(payload))
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	return c.writeFrame(op, payload)
+}
+
+// WriteClose writes the close frame with code and reason, the peer is expected to reply it and close the connection.
+func (c *Conn) WriteClose(code int, reason string) error {
+	if len(reason) > maxControlPayload-2 {
+		return fmt.Errorf("websocket: close reason of %d bytes", len(reason))
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	return c.writeCloseLocked(code, reason)
+}
+
+// writeClose writes the close frame unless it has been sent, the code is omitted if it's 0.
+func (c *Conn) writeClose(code int, reason string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	return c.writeCloseLocked(code, reason)
+}
+
+func (c *Conn) writeCloseLocked(code int, reason string) error {
+	c.closeSent = true
+	if code == 0 {
+		return c.writeFrame(OpClose, nil)
+	}
+	data := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(data, uint16(code))
+	copy(data[2:], reason)
+	return c.writeFrame(OpClose, data)
+}
+
+// writeFrame writes a frame with fin set, it must be called with wmu held.
+func (c *Conn) writeFrame(op OpCode, payload []byte) error {
+	writer := c.conn.Writer()
+	n := len(payload)
+	size := 2
+	switch {
+	case n > 0xffff:
+		size += 8
+	case n > maxControlPayload:
+		size += 2
+	}
+	if !c.server {
+		size += 4
+	}
+	hdr, err := writer.Malloc(size)
+	if err != nil {
+		return err
+	}
+	hdr[0] = 0x80 | byte(op)
+	switch {
+	case n > 0xffff:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+	case n > maxControlPayload:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(n))
+	default:
+		hdr[1] = byte(n)
+	}
+	if c.server {
+		if _, err = writer.WriteBinary(payload); err != nil {
+			return err
+		}
+		return writer.Flush()
+	}
+	// the client masks the payload into the output buffer, leaving payload untouched
+	hdr[1] |= 0x80
+	mask := hdr[size-4:]
+	if _, err = rand.Read(mask); err != nil {
+		return err
+	}
+	buf, err := writer.Malloc(n)
+	if err != nil {
+		return err
+	}
+	for i := range buf {
+		buf[i] = payload[i] ^ mask[i&3]
+	}
+	return writer.Flush()
+}
+
+// validPayload checks the text messages are valid UTF-8.
+func validPayload(op OpCode, payload netpoll.Reader) error {
+	if op != OpText || payload.Len() == 0 {
+		return nil
+	}
+	vs, _ := netpoll.PeekVec(payload, payload.Len())
+	valid := false
+	if len(vs) == 1 {
+		valid = utf8.Valid(vs[0])
+	} else {
+		var b []byte
+		for _, v := range vs {
+			b = append(b, v...)
+		}
+		valid = utf8.Valid(b)
+	}
+	if !valid {
+		payload.Release()
+		return fmt.Errorf("%w: invalid UTF-8 text", ErrProtocol)
+	}
+	return nil
+}
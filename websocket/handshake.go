@@ -0,0 +1,118 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package websocket
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/cloudwego/netpoll"
+)
+
+/* DOC:
+ * The websocket package implements the WebSocket protocol of RFC 6455 over the nocopy Reader and Writer
+ * of netpoll.Connection, so that no bufio is allocated for each connection.
+ *
+ * Upgrade: accept the handshake request read by the caller, and write the response.
+ * NewConn: read and write the messages after the handshake, see Conn.ReadMessage and Conn.WriteMessage.
+ */
+
+// ErrBadHandshake is returned by Upgrade if the request is not a valid WebSocket handshake,
+// the caller should respond 400 Bad Request and close the connection.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// acceptGUID is concatenated to Sec-WebSocket-Key to compute Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Request is the handshake request accepted by Upgrade.
+type Request struct {
+	URI    string
+	Header http.Header
+}
+
+// Upgrade validates the handshake request, which is the HTTP request line and headers ending with an empty line,
+// and writes the 101 Switching Protocols response to w. The messages can be exchanged by NewConn after that.
+func Upgrade(w netpoll.Writer, request []byte) (*Request, error) {
+	req, err := parseRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		return nil, fmt.Errorf("%w: Upgrade is not websocket", ErrBadHandshake)
+	}
+	if !headerHasToken(req.Header, "Connection", "upgrade") {
+		return nil, fmt.Errorf("%w: Connection has no upgrade", ErrBadHandshake)
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, fmt.Errorf("%w: unsupported version", ErrBadHandshake)
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		return nil, fmt.Errorf("%w: invalid Sec-WebSocket-Key", ErrBadHandshake)
+	}
+	w.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	w.WriteString(acceptKey(key))
+	w.WriteString("\r\n\r\n")
+	return req, w.Flush()
+}
+
+// acceptKey computes Sec-WebSocket-Accept of key.
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// parseRequest parses the request line and the headers of a GET request of HTTP/1.1.
+func parseRequest(request []byte) (*Request, error) {
+	line, rest, _ := bytes.Cut(request, []byte("\r\n"))
+	method, rest1, _ := bytes.Cut(line, []byte(" "))
+	uri, proto, _ := bytes.Cut(rest1, []byte(" "))
+	if string(method) != "GET" || len(uri) == 0 || string(proto) != "HTTP/1.1" {
+		return nil, fmt.Errorf("%w: invalid request line %q", ErrBadHandshake, line)
+	}
+	req := &Request{URI: string(uri), Header: make(http.Header)}
+	for {
+		line, rest, _ = bytes.Cut(rest, []byte("\r\n"))
+		if len(line) == 0 {
+			return req, nil
+		}
+		k, v, ok := bytes.Cut(line, []byte(":"))
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid header %q", ErrBadHandshake, line)
+		}
+		key := textproto.CanonicalMIMEHeaderKey(string(bytes.TrimSpace(k)))
+		req.Header.Add(key, string(bytes.TrimSpace(v)))
+	}
+}
+
+// headerHasToken reports whether the comma-separated values of the header contain token.
+func headerHasToken(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
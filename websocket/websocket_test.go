@@ -0,0 +1,261 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func MustNil(t *testing.T, val interface{}) {
+	t.Helper()
+	if val != nil {
+		t.Fatal("assertion nil failed, val=", val)
+	}
+}
+
+func MustTrue(t *testing.T, cond bool) {
+	t.Helper()
+	if !cond {
+		t.Fatal("assertion true failed")
+	}
+}
+
+const testRequest = "GET /chat HTTP/1.1\r\n" +
+	"Host: server.example.com\r\n" +
+	"Upgrade: websocket\r\n" +
+	"Connection: keep-alive, Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"Sec-WebSocket-Version: 13\r\n\r\n"
+
+// readHeader reads the HTTP request or response until the empty line.
+func readHeader(reader netpoll.Reader) ([]byte, error) {
+	var header []byte
+	for {
+		line, err := reader.Until('\n')
+		if err != nil {
+			return nil, err
+		}
+		header = append(header, line...)
+		if len(line) == 2 {
+			return header, nil
+		}
+	}
+}
+
+// newEchoServer serves the WebSocket connections which echo the messages, it returns the address.
+func newEchoServer(t *testing.T) string {
+	ln, err := netpoll.CreateListener("tcp", "127.0.0.1:0")
+	MustNil(t, err)
+	loop, err := netpoll.NewEventLoop(func(ctx context.Context, connection netpoll.Connection) error {
+		return nil
+	}, netpoll.WithOnPrepare(func(connection netpoll.Connection) context.Context {
+		var ws *Conn
+		connection.SetOnRequest(func(ctx context.Context, connection netpoll.Connection) error {
+			reader := connection.Reader()
+			if ws == nil {
+				request, err := readHeader(reader)
+				if err != nil {
+					return err
+				}
+				if _, err = Upgrade(connection.Writer(), request); err != nil {
+					connection.Close()
+					return err
+				}
+				reader.Release()
+				ws = NewConn(connection, true)
+			}
+			for reader.Len() > 0 {
+				op, payload, err := ws.ReadMessage()
+				if err != nil {
+					connection.Close()
+					return err
+				}
+				data, _ := payload.Next(payload.Len())
+				err = ws.WriteMessage(op, data)
+				payload.Release()
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return context.Background()
+	}))
+	MustNil(t, err)
+	go loop.Serve(ln)
+	t.Cleanup(func() {
+		loop.Shutdown(context.Background())
+	})
+	return ln.Addr().String()
+}
+
+// dial dials addr and completes the handshake.
+func dial(t *testing.T, addr string) (netpoll.Connection, *Conn) {
+	conn, err := netpoll.DialConnection("tcp", addr, time.Second)
+	MustNil(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	_, err = conn.Writer().WriteString(testRequest)
+	MustNil(t, err)
+	MustNil(t, conn.Writer().Flush())
+	resp, err := readHeader(conn.Reader())
+	MustNil(t, err)
+	conn.Reader().Release()
+	MustTrue(t, strings.HasPrefix(string(resp), "HTTP/1.1 101 "))
+	// the example of RFC 6455 section 1.3
+	MustTrue(t, strings.Contains(string(resp), "Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n"))
+	return conn, NewConn(conn, false)
+}
+
+// writeRawFrame writes a masked frame from the client, with the key of 0 which keeps the payload as is.
+func writeRawFrame(t *testing.T, conn netpoll.Connection, b0 byte, payload []byte) {
+	MustTrue(t, len(payload) < 126)
+	w := conn.Writer()
+	_, err := w.WriteBinary([]byte{b0, 0x80 | byte(len(payload)), 0, 0, 0, 0})
+	MustNil(t, err)
+	_, err = w.WriteBinary(payload)
+	MustNil(t, err)
+	MustNil(t, w.Flush())
+}
+
+func TestUpgrade(t *testing.T) {
+	buf := netpoll.NewLinkBuffer()
+	req, err := Upgrade(buf, []byte(testRequest))
+	MustNil(t, err)
+	MustTrue(t, req.URI == "/chat")
+	MustTrue(t, req.Header.Get("Host") == "server.example.com")
+	resp, _ := buf.ReadString(buf.Len())
+	MustTrue(t, resp == "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n\r\n")
+
+	for _, bad := range []string{
+		strings.Replace(testRequest, "GET", "POST", 1),
+		strings.Replace(testRequest, "Upgrade: websocket", "Upgrade: h2c", 1),
+		strings.Replace(testRequest, "keep-alive, Upgrade", "keep-alive", 1),
+		strings.Replace(testRequest, "Version: 13", "Version: 8", 1),
+		strings.Replace(testRequest, "dGhlIHNhbXBsZSBub25jZQ==", "c2hvcnQ=", 1),
+		"GET /chat HTTP/1.1\r\nbad header\r\n\r\n",
+	} {
+		buf = netpoll.NewLinkBuffer()
+		_, err = Upgrade(buf, []byte(bad))
+		MustTrue(t, errors.Is(err, ErrBadHandshake))
+		MustTrue(t, buf.Len() == 0)
+	}
+}
+
+func TestConnMessages(t *testing.T) {
+	_, ws := dial(t, newEchoServer(t))
+	for _, size := range []int{0, 1, 125, 126, 0xffff, 0x10000, 1 << 20} {
+		data := bytes.Repeat([]byte("websocket"), size/9+1)[:size]
+		MustNil(t, ws.WriteMessage(OpBinary, data))
+		op, payload, err := ws.ReadMessage()
+		MustNil(t, err)
+		MustTrue(t, op == OpBinary)
+		MustTrue(t, payload.Len() == size)
+		p, err := payload.Next(size)
+		MustNil(t, err)
+		MustTrue(t, bytes.Equal(p, data))
+		payload.Release()
+	}
+	MustNil(t, ws.WriteMessage(OpText, []byte("hello")))
+	op, payload, err := ws.ReadMessage()
+	MustNil(t, err)
+	s, _ := payload.ReadString(payload.Len())
+	MustTrue(t, op == OpText && s == "hello")
+
+	// a pong is sent back by the server but ignored by ReadMessage
+	MustNil(t, ws.WriteMessage(OpPing, []byte("ping")))
+	MustNil(t, ws.WriteMessage(OpText, []byte("after ping")))
+	op, payload, err = ws.ReadMessage()
+	MustNil(t, err)
+	s, _ = payload.ReadString(payload.Len())
+	MustTrue(t, op == OpText && s == "after ping")
+
+	MustNil(t, ws.WriteClose(CloseNormalClosure, "bye"))
+	MustTrue(t, errors.Is(ws.WriteMessage(OpText, []byte("x")), ErrCloseSent))
+	_, _, err = ws.ReadMessage()
+	var closeErr *CloseError
+	MustTrue(t, errors.As(err, &closeErr))
+	MustTrue(t, closeErr.Code == CloseNormalClosure)
+}
+
+func TestConnFragments(t *testing.T) {
+	conn, ws := dial(t, newEchoServer(t))
+	// a ping is interleaved in the fragments
+	writeRawFrame(t, conn, byte(OpText), []byte("frag"))
+	writeRawFrame(t, conn, 0x80|byte(OpPing), []byte("ping"))
+	writeRawFrame(t, conn, byte(OpContinuation), []byte("men"))
+	writeRawFrame(t, conn, 0x80|byte(OpContinuation), []byte("ted"))
+
+	reader := conn.Reader()
+	hdr, err := reader.Next(2)
+	MustNil(t, err)
+	MustTrue(t, hdr[0] == 0x80|byte(OpPong) && hdr[1] == 4)
+	pong, _ := reader.ReadString(4)
+	MustTrue(t, pong == "ping")
+	op, payload, err := ws.ReadMessage()
+	MustNil(t, err)
+	s, _ := payload.ReadString(payload.Len())
+	MustTrue(t, op == OpText && s == "fragmented")
+}
+
+func TestConnProtocolError(t *testing.T) {
+	for _, frame := range []struct {
+		b0      byte
+		payload []byte
+	}{
+		{0x80 | 0x40 | byte(OpBinary), nil},           // reserved bit
+		{0x80 | byte(OpContinuation), []byte("x")},    // no message to continue
+		{byte(OpPing), nil},                           // fragmented control frame
+		{0x80 | byte(OpText), []byte{0xff, 0xfe}},     // invalid UTF-8
+		{0x80 | 0x3, nil},                             // unknown opcode
+		{0x80 | byte(OpClose), []byte{byte(OpClose)}}, // truncated close code
+	} {
+		conn, ws := dial(t, newEchoServer(t))
+		writeRawFrame(t, conn, frame.b0, frame.payload)
+		_, _, err := ws.ReadMessage()
+		var closeErr *CloseError
+		MustTrue(t, errors.As(err, &closeErr))
+		MustTrue(t, closeErr.Code == CloseProtocolError)
+	}
+
+	// the server rejects the unmasked frames from the client
+	conn, ws := dial(t, newEchoServer(t))
+	_, err := conn.Writer().WriteBinary([]byte{0x80 | byte(OpBinary), 0})
+	MustNil(t, err)
+	MustNil(t, conn.Writer().Flush())
+	_, _, err = ws.ReadMessage()
+	var closeErr *CloseError
+	MustTrue(t, errors.As(err, &closeErr))
+	MustTrue(t, closeErr.Code == CloseProtocolError)
+}
+
+func TestConnMessageTooLarge(t *testing.T) {
+	_, ws := dial(t, newEchoServer(t))
+	ws.SetMaxMessageSize(16)
+	MustNil(t, ws.WriteMessage(OpBinary, make([]byte, 32)))
+	_, _, err := ws.ReadMessage()
+	MustTrue(t, errors.Is(err, ErrMessageTooLarge))
+}